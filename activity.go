@@ -0,0 +1,75 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ActivityKind is the kind of an activity event.
+type ActivityKind string
+
+// Kinds of activity events.
+const (
+	// ActivityImport is a file imported into the library.
+	ActivityImport ActivityKind = "import"
+	// ActivityFinish is a sync user finishing a book, when their reading progress reaches FinishedPercentage.
+	ActivityFinish ActivityKind = "finish"
+	// ActivityRating is a book being rated, with the rating as the detail.
+	ActivityRating ActivityKind = "rating"
+	// ActivityEdit is a book being updated, with the changed fields, such as "title, authors", as the detail.
+	ActivityEdit ActivityKind = "edit"
+)
+
+// ActivityEvent is something that happened in the library.
+type ActivityEvent struct {
+	ID   int64
+	Time time.Time
+	Kind ActivityKind
+	// BookID is the book the event happened to.
+	BookID int64
+	// FileID is the file imported or finished, or 0 if the file is no longer in the library.
+	FileID int64
+	// User is the sync user who finished the book, for finish events.
+	User   string
+	Detail string
+}
+
+// Activity returns the events which happened after since, newest first.
+// Set limit to 0 to return all of them.
+func (lib *Library) Activity(since time.Time, limit int) ([]ActivityEvent, error) {
+	query := "select " + activityRowColumns + " from activity where created_on > ? order by created_on desc, id desc"
+	args := []interface{}{since.UTC().Format("2006-01-02 15:04:05")}
+	if limit > 0 {
+		query += " limit ?"
+		args = append(args, limit)
+	}
+	rows, err := lib.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "get activity")
+	}
+	defer rows.Close()
+	events := []ActivityEvent{}
+	for rows.Next() {
+		row, err := scanActivityRow(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "get activity")
+		}
+		events = append(events, row.event())
+	}
+	return events, errors.Wrap(rows.Err(), "get activity")
+}
+
+// recordActivity records an event, at the current time.
+func recordActivity(tx *sql.Tx, kind ActivityKind, bookID, fileID int64, user, detail string) error {
+	file := sql.NullInt64{Int64: fileID, Valid: fileID != 0}
+	if _, err := tx.Exec("insert into activity (kind, book_id, file_id, username, detail) values(?, ?, ?, ?, ?)", string(kind), bookID, file, user, detail); err != nil {
+		return errors.Wrapf(err, "record %s activity", kind)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// FindChangedFiles returns files whose size or modification time in the books root
+// no longer match the library, usually because they were edited by another program.
+// Missing files are not included.
+// If progress isn't nil, it will be called as each file is checked.
+func (lib *Library) FindChangedFiles(progress ProgressFunc) ([]BookFile, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	ids, err := queryInt64s(tx, "select id from files order by id")
+	if err != nil {
+		return nil, errors.Wrap(err, "get file IDs")
+	}
+	files, err := getFilesByID(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get files")
+	}
+
+	var changed []BookFile
+	tracker := newProgressTracker("find changed files", len(files), progress)
+	for _, f := range files {
+		tracker.start(f.CurrentFilename)
+		fn, err := lib.FilePath(f)
+		if errors.Cause(err) == ErrRootUnavailable {
+			tracker.done()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		fi, err := os.Stat(fn)
+		if os.IsNotExist(err) {
+			tracker.done()
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "stat file %d", f.ID)
+		}
+		if fi.Size() != f.FileSize || !fi.ModTime().Equal(f.FileMtime) {
+			changed = append(changed, f)
+		}
+		tracker.done()
+	}
+	return changed, nil
+}
+
+// AdoptChangedFile updates the library to match a file which was changed in the books root by another program.
+// The file is hashed again, moved to the location for its new hash, and its size, modification time, and hash are updated,
+// along with those of the other files sharing its copy in the books root, since they changed with it.
+// If parser isn't nil, it is used to extract metadata from the changed file, which then replaces the book's title and authors,
+// and its series and publisher if they were parsed.
+func (lib *Library) AdoptChangedFile(fileID int64, parser MetadataParser, tmpl *template.Template) error {
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	var bookID int64
+	if err := tx.QueryRow("select book_id from files where id=?", fileID).Scan(&bookID); err != nil {
+		return ErrFileNotFound
+	}
+	files, err := getFilesByID(tx, []int64{fileID})
+	if err != nil {
+		return errors.Wrap(err, "get file")
+	}
+	if len(files) == 0 {
+		return ErrFileNotFound
+	}
+	file := files[0]
+
+	oldPath, err := lib.FilePath(file)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(oldPath)
+	if err != nil {
+		return errors.Wrap(err, "stat file")
+	}
+	hash, err := hashFile(oldPath)
+	if err != nil {
+		return errors.Wrap(err, "calculate hash")
+	}
+	partialMD5, err := PartialMD5(oldPath)
+	if err != nil {
+		return errors.Wrap(err, "calculate partial MD5")
+	}
+	newFile := file
+	newFile.Hash = hash
+	newPath, err := lib.FilePath(newFile)
+	if err != nil {
+		return err
+	}
+
+	// Files with the same hash in the books roots share one copy, which is moved to its new name, so they're all updated.
+	where, args := "id=?", []interface{}{fileID}
+	if file.Path == "" {
+		where, args = "hash=? and path=''", []interface{}{file.Hash}
+	}
+	bookIDs, err := queryInt64s(tx, "select distinct book_id from files where "+where, args...)
+	if err != nil {
+		return errors.Wrap(err, "get books of file")
+	}
+	_, err = tx.Exec("update files set updated_on=datetime(), file_size=?, file_mtime=?, hash=?, partial_md5=? where "+where,
+		append([]interface{}{fi.Size(), fi.ModTime(), hash, partialMD5}, args...)...)
+	if err != nil {
+		return errors.Wrap(err, "update file")
+	}
+
+	if parser != nil {
+		if err := lib.adoptMetadata(tx, bookID, oldPath, file.Extension, parser, tmpl); err != nil {
+			return errors.Wrap(err, "update metadata")
+		}
+	}
+
+	if newPath != oldPath {
+		if err := lib.perms.mkdirAll(filepath.Dir(newPath)); err != nil {
+			return errors.Wrap(err, "create destination directory")
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return errors.Wrap(err, "move changed file")
+		}
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookIDs...)
+	if err != nil {
+		if newPath != oldPath {
+			if err := os.Rename(newPath, oldPath); err != nil {
+				log.Printf("Error moving %s back to %s: %s", newPath, oldPath, err)
+			}
+		}
+		return errors.Wrap(err, "commit")
+	}
+	log.Printf("Adopted changed file %d, new hash %s", fileID, hash)
+	return nil
+}
+
+// adoptMetadata parses the file at filename, and updates the book's metadata with the result.
+// As files in the books root have no extension, parsers are given a symlink to the file with extension ext.
+// If the new metadata would make the book a duplicate of another book, the metadata is left unchanged.
+func (lib *Library) adoptMetadata(tx *sql.Tx, bookID int64, filename, ext string, parser MetadataParser, tmpl *template.Template) error {
+	tmpDir, err := ioutil.TempDir("", "books")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+	link := filepath.Join(tmpDir, "book."+ext)
+	if err := os.Symlink(filename, link); err != nil {
+		return errors.Wrap(err, "link file")
+	}
+	parsed, ok := parser.Parse([]string{link})
+	if !ok {
+		return nil
+	}
+	books, err := getBooksByID(tx, []int64{bookID})
+	if err != nil {
+		return err
+	}
+	if len(books) == 0 {
+		return ErrBookNotFound
+	}
+	book := books[0]
+	book.Title = parsed.Title
+	book.Authors = parsed.Authors
+	if parsed.Series != "" {
+		book.Series = parsed.Series
+	}
+	if parsed.Publisher != "" {
+		book.Publisher = parsed.Publisher
+	}
+	err = lib.updateBook(tx, book, tmpl, true)
+	if bee, ok := err.(BookExistsError); ok {
+		log.Printf("Not updating metadata of book %d, since it would duplicate book %d", bookID, bee.BookID)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of alternate titles.
+const (
+	// AltTitleSubtitle is a book's title with its subtitle, such as "Frankenstein; or, The Modern Prometheus".
+	AltTitleSubtitle = "subtitle"
+	// AltTitleSeries is the title a book is known by in its series' reading order, such as "Discworld 4".
+	AltTitleSeries = "series"
+	// AltTitleAbbreviation is a short form of a title, such as "HHGTTG".
+	AltTitleAbbreviation = "abbreviation"
+	// AltTitleMerged is the title of a book which was merged into this one.
+	AltTitleMerged = "merged"
+)
+
+// AlternateTitle is another title a book is known by.
+// Alternate titles are searched along with titles, and books imported with one of them are added to the book with it.
+type AlternateTitle struct {
+	Title string `json:"title"`
+	// Kind is what sort of title it is, such as AltTitleSubtitle, or empty.
+	Kind string `json:"kind"`
+}
+
+// ErrAlternateTitleNotFound is returned when removing an alternate title a book doesn't have.
+var ErrAlternateTitleNotFound = errors.New("alternate title not found")
+
+// sqlIndexedAlternateTitles returns an SQL expression for the text indexed in the alternate_titles column of books_fts, for the book with the given ID.
+func sqlIndexedAlternateTitles(bookID string) string {
+	return `(select coalesce(group_concat(title, ' '), '') from alternate_titles where book_id = ` + bookID + `)`
+}
+
+// AddAlternateTitle adds another title a book is known by, or changes the kind of one it already has.
+// A book's own title can't be one of its alternate titles.
+func (lib *Library) AddAlternateTitle(bookID int64, title AlternateTitle) error {
+	title.Title = collapseSpace(title.Title)
+	title.Kind = strings.ToLower(strings.TrimSpace(title.Kind))
+	if title.Title == "" {
+		return errors.New("alternate title is empty")
+	}
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	var existing string
+	err = tx.QueryRow("select title from books where id=?", bookID).Scan(&existing)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrBookNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get book")
+	}
+	if strings.EqualFold(existing, title.Title) {
+		tx.Rollback()
+		return errors.Errorf("%s is already the book's title", title.Title)
+	}
+	res, err := tx.Exec("update alternate_titles set kind=? where book_id=? and title=?", title.Kind, bookID, title.Title)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			_, err = tx.Exec("insert into alternate_titles (book_id, title, kind) values(?, ?, ?)", bookID, title.Title, title.Kind)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "add alternate title")
+	}
+	if err := indexBook(tx, bookID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "index book in search")
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID)
+	return errors.Wrap(err, "commit")
+}
+
+// RemoveAlternateTitle removes one of a book's alternate titles, ignoring case.
+func (lib *Library) RemoveAlternateTitle(bookID int64, title string) error {
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	res, err := tx.Exec("delete from alternate_titles where book_id=? and title=?", bookID, collapseSpace(title))
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "remove alternate title")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return ErrAlternateTitleNotFound
+	}
+	if err := indexBook(tx, bookID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "index book in search")
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID)
+	return errors.Wrap(err, "commit")
+}
+
+// getAlternateTitlesByBookIds gets the alternate titles of each book ID, in the order they were added.
+func getAlternateTitlesByBookIds(tx *sql.Tx, ids []int64) (map[int64][]AlternateTitle, error) {
+	m := make(map[int64][]AlternateTitle)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select " + alternateTitleRowColumns + " from alternate_titles where book_id in (" + joinInt64s(ids, ",") + ") order by book_id, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row, err := scanAlternateTitleRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		m[row.BookID] = append(m[row.BookID], row.alternateTitle())
+	}
+	return m, rows.Err()
+}
+
+// mergeAlternateTitles gives the book with the first of ids the alternate titles of the others,
+// and their titles as alternate titles, so the merged books are still found under them.
+func mergeAlternateTitles(tx *sql.Tx, ids []int64) error {
+	others := joinInt64s(ids[1:], ",")
+	_, err := tx.Exec("insert or ignore into alternate_titles (book_id, title, kind) select ?, title, ? from books where id in ("+others+") and title != (select title from books where id=?) collate nocase", ids[0], AltTitleMerged, ids[0])
+	if err != nil {
+		return errors.Wrap(err, "add titles of merged books")
+	}
+	_, err = tx.Exec("update or ignore alternate_titles set book_id=? where book_id in ("+others+") and title != (select title from books where id=?) collate nocase", ids[0], ids[0])
+	return errors.Wrap(err, "merge alternate titles")
+}
@@ -0,0 +1,166 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CoAuthor is an author who wrote books with another author.
+type CoAuthor struct {
+	ID             int64  `json:"id"`
+	Name           string `json:"name"`
+	Disambiguation string `json:"disambiguation,omitempty"`
+	// BookIDs are the books the authors wrote together.
+	BookIDs []int64 `json:"book_ids"`
+}
+
+// CoAuthors returns the authors who wrote books with the author with ID authorID,
+// those who wrote the most books with them first, and otherwise in the order they were added.
+// Only authors count; editors, translators and other contributors don't.
+func (lib *Library) CoAuthors(authorID int64) ([]CoAuthor, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	if _, err := getAuthor(tx, authorID); err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`select a.id, a.name, a.disambiguation, ba.book_id
+from books_authors mine
+join books_authors ba on ba.book_id = mine.book_id and ba.role = 'author' and ba.author_id != mine.author_id
+join authors a on a.id = ba.author_id
+where mine.author_id = ? and mine.role = 'author'
+order by a.id, ba.book_id`, authorID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get co-authors")
+	}
+	defer rows.Close()
+	var coAuthors []CoAuthor
+	for rows.Next() {
+		var c CoAuthor
+		var bookID int64
+		if err := rows.Scan(&c.ID, &c.Name, &c.Disambiguation, &bookID); err != nil {
+			return nil, errors.Wrap(err, "get co-authors")
+		}
+		if n := len(coAuthors); n > 0 && coAuthors[n-1].ID == c.ID {
+			coAuthors[n-1].BookIDs = append(coAuthors[n-1].BookIDs, bookID)
+			continue
+		}
+		c.BookIDs = []int64{bookID}
+		coAuthors = append(coAuthors, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get co-authors")
+	}
+	sort.SliceStable(coAuthors, func(i, j int) bool {
+		return len(coAuthors[i].BookIDs) > len(coAuthors[j].BookIDs)
+	})
+	return coAuthors, nil
+}
+
+// AuthorGraph is a graph of the collaborations between the authors of a library.
+type AuthorGraph struct {
+	// Authors are the authors who wrote books with others, in the order they were added to the library.
+	Authors []AuthorNode `json:"authors"`
+	// Collaborations are the pairs of authors who wrote books together.
+	Collaborations []Collaboration `json:"collaborations"`
+}
+
+// AuthorNode is an author in an AuthorGraph.
+type AuthorNode struct {
+	ID             int64  `json:"id"`
+	Name           string `json:"name"`
+	Disambiguation string `json:"disambiguation,omitempty"`
+	// Books is the number of books the author wrote, alone or with others.
+	Books int `json:"books"`
+}
+
+// Collaboration is a pair of authors who wrote books together, with the lower of their IDs first.
+type Collaboration struct {
+	Authors [2]int64 `json:"authors"`
+	// Books is the number of books they wrote together.
+	Books int `json:"books"`
+}
+
+// AuthorGraph returns the graph of the authors of the library who wrote books together.
+// Authors who only wrote books alone aren't in it. Names which are variants of each other,
+// such as with and without initials, can be spotted by their sharing co-authors without being linked themselves.
+func (lib *Library) AuthorGraph() (AuthorGraph, error) {
+	g := AuthorGraph{Authors: []AuthorNode{}, Collaborations: []Collaboration{}}
+	tx, err := lib.Begin()
+	if err != nil {
+		return g, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`select a.author_id, b.author_id, count(*)
+from books_authors a join books_authors b on a.book_id = b.book_id and a.author_id < b.author_id
+where a.role = 'author' and b.role = 'author'
+group by a.author_id, b.author_id
+order by a.author_id, b.author_id`)
+	if err != nil {
+		return g, errors.Wrap(err, "get collaborations")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Collaboration
+		if err := rows.Scan(&c.Authors[0], &c.Authors[1], &c.Books); err != nil {
+			return g, errors.Wrap(err, "get collaborations")
+		}
+		g.Collaborations = append(g.Collaborations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return g, errors.Wrap(err, "get collaborations")
+	}
+	rows.Close()
+
+	authorRows, err := tx.Query(`select a.id, a.name, a.disambiguation, count(distinct ba.book_id)
+from authors a join books_authors ba on ba.author_id = a.id and ba.role = 'author'
+where a.id in (select x.author_id from books_authors x join books_authors y on x.book_id = y.book_id and x.author_id != y.author_id
+	where x.role = 'author' and y.role = 'author')
+group by a.id
+order by a.id`)
+	if err != nil {
+		return g, errors.Wrap(err, "get authors")
+	}
+	defer authorRows.Close()
+	for authorRows.Next() {
+		var a AuthorNode
+		if err := authorRows.Scan(&a.ID, &a.Name, &a.Disambiguation, &a.Books); err != nil {
+			return g, errors.Wrap(err, "get authors")
+		}
+		g.Authors = append(g.Authors, a)
+	}
+	return g, errors.Wrap(authorRows.Err(), "get authors")
+}
+
+// dotEscaper escapes text in quoted DOT strings.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// WriteDOT writes the graph in the DOT language, for drawing it with Graphviz.
+// Each author is a node labelled with their name, and each collaboration is an edge weighted by the number of books written together.
+func (g AuthorGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "graph authors {")
+	for _, a := range g.Authors {
+		label := a.Name
+		if a.Disambiguation != "" {
+			label += " (" + a.Disambiguation + ")"
+		}
+		fmt.Fprintf(bw, "\t%d [label=\"%s\"];\n", a.ID, dotEscaper.Replace(label))
+	}
+	for _, c := range g.Collaborations {
+		fmt.Fprintf(bw, "\t%d -- %d [weight=%d, label=%d];\n", c.Authors[0], c.Authors[1], c.Books, c.Books)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
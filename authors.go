@@ -0,0 +1,426 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// authorSeparatorRegexp matches the separators between authors combined into one name.
+var authorSeparatorRegexp = regexp.MustCompile(`\s*[&;]\s*`)
+
+// SplitAuthorName splits a name combining several authors, such as "A & B; C", into the separate authors.
+func SplitAuthorName(name string) []string {
+	var authors []string
+	for _, a := range authorSeparatorRegexp.Split(name, -1) {
+		if a = collapseSpace(a); a != "" {
+			authors = append(authors, a)
+		}
+	}
+	return uniqueAuthors(authors)
+}
+
+// AuthorChange is a change to the authors of books, made by SplitAuthors or JoinAuthors.
+type AuthorChange struct {
+	Old []string
+	New []string
+	// BookIDs are the books whose authors were changed.
+	BookIDs []int64
+	// MergedIDs are books which were merged into another book, because they then had the same title and authors.
+	// They're also in BookIDs.
+	MergedIDs []int64
+}
+
+// CombinedAuthors returns the authors whose names combine several authors.
+func (lib *Library) CombinedAuthors() ([]string, error) {
+	rows, err := lib.Query("select name from authors where name like '%&%' or name like '%;%' order by name")
+	if err != nil {
+		return nil, errors.Wrap(err, "get authors")
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "get authors")
+		}
+		if len(SplitAuthorName(name)) > 1 {
+			names = append(names, name)
+		}
+	}
+	return names, errors.Wrap(rows.Err(), "get authors")
+}
+
+// SplitAuthors replaces each of the named authors with the separate authors its name combines, in every book linked to it.
+// If names is empty, every author whose name combines several authors is split.
+// Books which end up with the same title and authors as another book are merged into it, and files are renamed according to tmpl.
+// If dryRun is true, the changes are returned but not made.
+func (lib *Library) SplitAuthors(names []string, tmpl *template.Template, dryRun bool) ([]AuthorChange, error) {
+	if len(names) == 0 {
+		var err error
+		if names, err = lib.CombinedAuthors(); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	var changes []AuthorChange
+	for _, name := range names {
+		parts := SplitAuthorName(name)
+		if len(parts) < 2 {
+			continue
+		}
+		change, err := lib.replaceAuthors(tx, []string{name}, parts, tmpl, dryRun)
+		if err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "split author %s", name)
+		}
+		if len(change.BookIDs) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	return changes, lib.finishAuthorChanges(tx, changes, dryRun)
+}
+
+// JoinAuthors replaces authors which were wrongly split from one name with a single author, name.
+// Only books linked to all of parts are changed.
+// Books which end up with the same title and authors as another book are merged into it, and files are renamed according to tmpl.
+// If dryRun is true, the change is returned but not made.
+func (lib *Library) JoinAuthors(parts []string, name string, tmpl *template.Template, dryRun bool) (AuthorChange, error) {
+	name = collapseSpace(name)
+	if len(parts) < 2 || name == "" {
+		return AuthorChange{}, errors.New("at least two authors and a name to join them into are required")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return AuthorChange{}, errors.Wrap(err, "begin transaction")
+	}
+	change, err := lib.replaceAuthors(tx, parts, []string{name}, tmpl, dryRun)
+	if err != nil {
+		tx.Rollback()
+		return AuthorChange{}, errors.Wrap(err, "join authors")
+	}
+	return change, lib.finishAuthorChanges(tx, []AuthorChange{change}, dryRun)
+}
+
+// finishAuthorChanges commits tx, or rolls it back if dryRun is true.
+func (lib *Library) finishAuthorChanges(tx *sql.Tx, changes []AuthorChange, dryRun bool) error {
+	if dryRun {
+		tx.Rollback()
+		return nil
+	}
+	err := tx.Commit()
+	for _, c := range changes {
+		if len(c.MergedIDs) > 0 {
+			// Merging also changes the books merged into.
+			lib.invalidateCache()
+			break
+		}
+		lib.invalidateBooks(c.BookIDs...)
+	}
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
+
+// replaceAuthors replaces the authors in from with those in to, in the books linked to all of the authors in from.
+// The new authors take the place of the first of the old ones in each book's list of authors.
+// Old authors no longer linked to any books are removed.
+func (lib *Library) replaceAuthors(tx *sql.Tx, from, to []string, tmpl *template.Template, dryRun bool) (AuthorChange, error) {
+	change := AuthorChange{Old: from, New: to}
+	ids, err := queryInt64s(tx, "select ba.book_id from books_authors ba join authors a on ba.author_id = a.id where ba.role = 'author' and a.name in ("+
+		strings.TrimSuffix(strings.Repeat("?,", len(from)), ",")+") group by ba.book_id having count(distinct a.id) = ? order by ba.book_id",
+		append(stringsToInterfaces(from), len(from))...)
+	if err != nil {
+		return change, errors.Wrap(err, "find books")
+	}
+	bks, err := getBooksByID(tx, ids)
+	if err != nil {
+		return change, errors.Wrap(err, "get books")
+	}
+	isOld := make(map[string]bool, len(from))
+	for _, a := range from {
+		isOld[a] = true
+	}
+	for _, book := range bks {
+		var authors []string
+		replaced := false
+		for _, a := range book.Authors {
+			if !isOld[a] {
+				authors = append(authors, a)
+			} else if !replaced {
+				authors = append(authors, to...)
+				replaced = true
+			}
+		}
+		book.Authors = uniqueAuthors(authors)
+		change.BookIDs = append(change.BookIDs, book.ID)
+
+		existingID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors, false)
+		if err != nil {
+			return change, errors.Wrap(err, "find existing book")
+		}
+		if found && existingID != book.ID {
+			change.MergedIDs = append(change.MergedIDs, book.ID)
+			if dryRun {
+				continue
+			}
+			if err := lib.mergeBooks(tx, []int64{existingID, book.ID}, tmpl); err != nil {
+				return change, errors.Wrapf(err, "merge book %d into %d", book.ID, existingID)
+			}
+			log.Printf("Merged book %d into book %d", book.ID, existingID)
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		if err := lib.updateBook(tx, book, tmpl, false); err != nil {
+			return change, errors.Wrapf(err, "update book %d", book.ID)
+		}
+	}
+	if dryRun {
+		return change, nil
+	}
+	isNew := authorSet(to)
+	for _, a := range from {
+		if isNew[normalizeAuthor(a)] {
+			continue
+		}
+		if _, err := tx.Exec("delete from authors where name=? and disambiguation='' and external_id='' and not exists (select 1 from books_authors ba where ba.author_id = authors.id)", a); err != nil {
+			return change, errors.Wrap(err, "remove unused author")
+		}
+	}
+	return change, nil
+}
+
+// Author is an author record. Different people with the same name have separate records, told apart by their disambiguation notes and external IDs.
+type Author struct {
+	ID   int64
+	Name string
+	// Disambiguation is a note telling the author apart from others with the same name, such as "poet" or "1920-1992".
+	// Only one author with each name can be without one.
+	Disambiguation string
+	// ExternalID identifies the author in an authority file or catalog, such as viaf:102333412 or wikidata:Q34981.
+	ExternalID string
+	// BookIDs are the books the author is linked to, in any role.
+	BookIDs []int64
+}
+
+func (a Author) String() string {
+	if a.Disambiguation == "" {
+		return a.Name
+	}
+	return a.Name + " (" + a.Disambiguation + ")"
+}
+
+// ErrAuthorNotFound is returned when an author record doesn't exist.
+var ErrAuthorNotFound = errors.New("author not found")
+
+// AuthorsNamed returns the author records with the given name, in the order they were added.
+// New books are linked to the one without a disambiguation note, if there is one, or else to the first.
+func (lib *Library) AuthorsNamed(name string) ([]Author, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	ids, err := queryInt64s(tx, "select id from authors where name=? order by id", collapseSpace(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "find authors")
+	}
+	authors := make([]Author, 0, len(ids))
+	for _, id := range ids {
+		a, err := getAuthor(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		authors = append(authors, a)
+	}
+	return authors, nil
+}
+
+// GetAuthor returns the author record with the given ID.
+func (lib *Library) GetAuthor(id int64) (Author, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return Author{}, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	return getAuthor(tx, id)
+}
+
+func getAuthor(tx *sql.Tx, id int64) (Author, error) {
+	row, err := scanAuthorRow(tx.QueryRow("select "+authorRowColumns+" from authors where id=?", id))
+	if err == sql.ErrNoRows {
+		return Author{ID: id}, ErrAuthorNotFound
+	} else if err != nil {
+		return Author{ID: id}, errors.Wrap(err, "get author")
+	}
+	a := row.author()
+	a.BookIDs, err = queryInt64s(tx, "select distinct book_id from books_authors where author_id=? order by book_id", id)
+	return a, errors.Wrap(err, "get author's books")
+}
+
+// AddAuthor adds a record for a different person with the same name as an existing author, or a new author, and returns its ID.
+// Its disambiguation note must be different from those of the other authors with its name.
+// Books are moved to it with ReassignBooks.
+func (lib *Library) AddAuthor(a Author) (int64, error) {
+	a.Name = collapseSpace(a.Name)
+	if a.Name == "" {
+		return 0, errors.New("an author must have a name")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	if err := checkDisambiguation(tx, a); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("insert into authors (name, disambiguation, external_id) values(?, ?, ?)", a.Name, strings.TrimSpace(a.Disambiguation), strings.TrimSpace(a.ExternalID))
+	if err != nil {
+		return 0, errors.Wrap(err, "add author")
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "get author ID")
+	}
+	return id, errors.Wrap(tx.Commit(), "commit")
+}
+
+// UpdateAuthor sets the disambiguation note and external ID of the author with a.ID.
+// Names are changed by changing the authors of books, or with SplitAuthors and JoinAuthors.
+func (lib *Library) UpdateAuthor(a Author) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	existing, err := getAuthor(tx, a.ID)
+	if err != nil {
+		return err
+	}
+	a.Name = existing.Name
+	if err := checkDisambiguation(tx, a); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("update authors set updated_on=datetime(), disambiguation=?, external_id=? where id=?", strings.TrimSpace(a.Disambiguation), strings.TrimSpace(a.ExternalID), a.ID); err != nil {
+		return errors.Wrap(err, "update author")
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// checkDisambiguation returns an error if another author has the name and disambiguation note of a.
+func checkDisambiguation(tx *sql.Tx, a Author) error {
+	var id int64
+	err := tx.QueryRow("select id from authors where name=? and disambiguation=? and id != ?", a.Name, strings.TrimSpace(a.Disambiguation), a.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "find author")
+	}
+	if a.Disambiguation == "" {
+		return errors.Errorf("author %d is already named %s without a disambiguation note", id, a.Name)
+	}
+	return errors.Errorf("author %d is already %s", id, Author{Name: a.Name, Disambiguation: a.Disambiguation})
+}
+
+// ReassignBooks links the books with the given IDs, or all of its books if none are given, to the author with ID to instead of from,
+// in every role, and returns the IDs of the books which were changed.
+// The authors must have the same name, so the books' authors, and everything found by them, stay the same.
+func (lib *Library) ReassignBooks(from, to int64, bookIDs []int64) ([]int64, error) {
+	if from == to {
+		return nil, errors.New("books can't be reassigned to the same author")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	fromAuthor, err := getAuthor(tx, from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "author %d", from)
+	}
+	toAuthor, err := getAuthor(tx, to)
+	if err != nil {
+		return nil, errors.Wrapf(err, "author %d", to)
+	}
+	if fromAuthor.Name != toAuthor.Name {
+		return nil, errors.Errorf("books can only be reassigned between authors with the same name, not %s and %s", fromAuthor.Name, toAuthor.Name)
+	}
+	ids := fromAuthor.BookIDs
+	if len(bookIDs) > 0 {
+		linked := make(map[int64]bool, len(ids))
+		for _, id := range ids {
+			linked[id] = true
+		}
+		ids = nil
+		for _, id := range bookIDs {
+			if !linked[id] {
+				return nil, errors.Errorf("book %d isn't linked to author %d", id, from)
+			}
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	books := joinInt64s(ids, ",")
+	// Links in roles the book already has the other author in are left behind by the update, and removed.
+	if _, err := tx.Exec("update or ignore books_authors set updated_on=datetime(), author_id=? where author_id=? and book_id in ("+books+")", to, from); err != nil {
+		return nil, errors.Wrap(err, "reassign books")
+	}
+	if _, err := tx.Exec("delete from books_authors where author_id=? and book_id in ("+books+")", from); err != nil {
+		return nil, errors.Wrap(err, "reassign books")
+	}
+	if _, err := tx.Exec("update books set updated_on=datetime() where id in (" + books + ")"); err != nil {
+		return nil, errors.Wrap(err, "update books")
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(ids...)
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	return ids, nil
+}
+
+// linkedAuthorIDs returns the IDs of the author records linked to a book, in any role, by name,
+// so the book's authors can be replaced without losing which of several people with the same name they are.
+func linkedAuthorIDs(tx *sql.Tx, bookID int64) (map[string]int64, error) {
+	rows, err := tx.Query("select a.name, a.id from books_authors ba join authors a on ba.author_id = a.id where ba.book_id=? order by ba.id", bookID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get linked authors")
+	}
+	defer rows.Close()
+	linked := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var id int64
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, errors.Wrap(err, "get linked authors")
+		}
+		if _, ok := linked[name]; !ok {
+			linked[name] = id
+		}
+	}
+	return linked, errors.Wrap(rows.Err(), "get linked authors")
+}
+
+func stringsToInterfaces(items []string) []interface{} {
+	result := make([]interface{}, len(items))
+	for i, s := range items {
+		result[i] = s
+	}
+	return result
+}
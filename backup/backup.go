@@ -0,0 +1,221 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+// Package backup exports snapshots of a library's metadata, and optionally its files, to a remote for off-site backup.
+package backup
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tspivey/books"
+)
+
+// SnapshotsDir is the directory on the remote snapshots are uploaded to, and FilesDir is the directory files are.
+const (
+	SnapshotsDir = "snapshots"
+	FilesDir     = "files"
+)
+
+// snapshotTimeFormat is the format of the time in snapshot names, which sorts by time.
+const snapshotTimeFormat = "20060102T150405Z"
+
+// snapshotPrefix and snapshotSuffix surround the time in snapshot names.
+const (
+	snapshotPrefix = "books-"
+	snapshotSuffix = ".jsonl.gz"
+)
+
+// exportChunkSize is the number of books loaded at a time while writing a snapshot.
+const exportChunkSize = 500
+
+// Exporter exports snapshots of a library to a remote.
+type Exporter struct {
+	Lib    *books.Library
+	Remote Remote
+	// Files uploads the library's files which aren't on the remote yet with each snapshot.
+	// Files are named by their hashes, so each is only uploaded once, and they're never removed from the remote.
+	Files bool
+	// Keep is the number of snapshots to keep on the remote. If 0, snapshots aren't expired by count.
+	Keep int
+	// MaxAge is how long to keep snapshots on the remote. If 0, snapshots aren't expired by age.
+	MaxAge time.Duration
+}
+
+// Result describes an export.
+type Result struct {
+	// Snapshot is the name of the uploaded snapshot on the remote.
+	Snapshot string
+	// Books is the number of books in the snapshot.
+	Books int
+	// FilesUploaded is the number of files uploaded.
+	FilesUploaded int
+	// Expired are the names of the old snapshots which were removed.
+	Expired []string
+}
+
+// Export writes a snapshot of the library's metadata, uploads it to the remote, and then uploads new files, if e.Files is set,
+// and removes old snapshots. The snapshot is a gzipped file of JSON lines, with one book, with its files, on each line.
+// Snapshots of a library which hasn't changed are the same, byte for byte, so they can be compared and deduplicated by their hashes.
+// Files which can't be read, such as those on books roots which aren't available, are skipped, and uploaded by a later export.
+func (e *Exporter) Export() (Result, error) {
+	var res Result
+	now := time.Now().UTC()
+	tmp, err := ioutil.TempFile("", "books-snapshot")
+	if err != nil {
+		return res, errors.Wrap(err, "create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+	files := make(map[string]books.BookFile)
+	res.Books, err = e.writeSnapshot(tmp, files)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return res, errors.Wrap(err, "write snapshot")
+	}
+
+	res.Snapshot = path.Join(SnapshotsDir, snapshotPrefix+now.Format(snapshotTimeFormat)+snapshotSuffix)
+	if err := e.Remote.Upload(tmp.Name(), res.Snapshot); err != nil {
+		return res, errors.Wrap(err, "upload snapshot")
+	}
+	log.Printf("Uploaded snapshot %s with %d books", res.Snapshot, res.Books)
+
+	if e.Files {
+		if res.FilesUploaded, err = e.uploadFiles(files); err != nil {
+			return res, err
+		}
+	}
+	res.Expired, err = e.expireSnapshots(now)
+	return res, err
+}
+
+// writeSnapshot writes all of the library's books to w, and adds their files to files, by hash.
+func (e *Exporter) writeSnapshot(w io.Writer, files map[string]books.BookFile) (int, error) {
+	ids, err := e.Lib.ListBookIDs(books.SortByID, false)
+	if err != nil {
+		return 0, err
+	}
+	// The gzip header has no name or modification time, so it doesn't depend on when the snapshot was written.
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	n := 0
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > exportChunkSize {
+			chunk = chunk[:exportChunkSize]
+		}
+		ids = ids[len(chunk):]
+		bks, err := e.Lib.GetBooksByID(chunk)
+		if err != nil {
+			return n, err
+		}
+		for _, b := range bks {
+			if err := enc.Encode(b); err != nil {
+				return n, err
+			}
+			n++
+			for _, f := range b.Files {
+				files[f.Hash] = f
+			}
+		}
+	}
+	return n, gz.Close()
+}
+
+// uploadFiles uploads the files which aren't on the remote yet.
+func (e *Exporter) uploadFiles(files map[string]books.BookFile) (int, error) {
+	names, err := e.Remote.List(FilesDir)
+	if err != nil {
+		return 0, errors.Wrap(err, "list files on remote")
+	}
+	uploaded := make(map[string]bool, len(names))
+	for _, name := range names {
+		uploaded[name] = true
+	}
+	hashes := make([]string, 0, len(files))
+	for hash := range files {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	n := 0
+	for _, hash := range hashes {
+		f := files[hash]
+		name := f.HashPath()
+		if uploaded[name] {
+			continue
+		}
+		fn, err := e.Lib.FilePath(f)
+		if err != nil {
+			log.Printf("Not uploading file %d: %s", f.ID, err)
+			continue
+		}
+		if _, err := os.Stat(fn); err != nil {
+			log.Printf("Not uploading file %d: %s", f.ID, err)
+			continue
+		}
+		if err := e.Remote.Upload(fn, path.Join(FilesDir, name)); err != nil {
+			return n, errors.Wrapf(err, "upload file %d", f.ID)
+		}
+		n++
+	}
+	if n > 0 {
+		log.Printf("Uploaded %d files", n)
+	}
+	return n, nil
+}
+
+// expireSnapshots removes the snapshots on the remote beyond e.Keep, or older than e.MaxAge, and returns their names.
+// The snapshot just uploaded is never removed.
+func (e *Exporter) expireSnapshots(now time.Time) ([]string, error) {
+	if e.Keep <= 0 && e.MaxAge <= 0 {
+		return nil, nil
+	}
+	names, err := e.Remote.List(SnapshotsDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "list snapshots")
+	}
+	type snapshot struct {
+		name string
+		time time.Time
+	}
+	var snapshots []snapshot
+	for _, name := range names {
+		if strings.Contains(name, "/") || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		t, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{name, t})
+	}
+	// Newest first.
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].time.After(snapshots[j].time) })
+
+	var expired []string
+	for i, s := range snapshots {
+		if i == 0 {
+			continue
+		}
+		if (e.Keep > 0 && i >= e.Keep) || (e.MaxAge > 0 && now.Sub(s.time) > e.MaxAge) {
+			name := path.Join(SnapshotsDir, s.name)
+			if err := e.Remote.Delete(name); err != nil {
+				return expired, errors.Wrapf(err, "remove snapshot %s", name)
+			}
+			log.Printf("Removed snapshot %s", name)
+			expired = append(expired, name)
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,168 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package backup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Remote is somewhere backups are stored. Names are slash-separated paths relative to the top of the remote.
+type Remote interface {
+	// Upload copies the local file to name on the remote, replacing it if it exists.
+	Upload(local, name string) error
+	// List returns the names of all of the files under dir, relative to dir, or nothing if dir doesn't exist.
+	List(dir string) ([]string, error)
+	// Delete removes name from the remote.
+	Delete(name string) error
+}
+
+// ParseRemote returns the remote described by s: an rclone remote, such as s3:bucket/books or webdav:books, if it has a colon,
+// or otherwise a local directory, such as a mounted drive.
+func ParseRemote(s string) (Remote, error) {
+	if s == "" {
+		return nil, errors.New("no remote given")
+	}
+	if !filepath.IsAbs(s) && strings.Contains(s, ":") {
+		return RcloneRemote{Remote: s}, nil
+	}
+	return DirRemote(s), nil
+}
+
+// DirRemote is a remote in a local directory.
+type DirRemote string
+
+// Upload copies local to name in the directory, writing to a temporary file first, so a failed upload doesn't leave a partial file.
+func (d DirRemote) Upload(local, name string) error {
+	dst := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return errors.Wrap(err, "create directory")
+	}
+	in, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst+".tmp", dst)
+	}
+	if err != nil {
+		os.Remove(dst + ".tmp")
+		return errors.Wrapf(err, "copy %s to %s", local, dst)
+	}
+	return nil
+}
+
+// List returns the files under dir in the directory.
+func (d DirRemote) List(dir string) ([]string, error) {
+	top := filepath.Join(string(d), filepath.FromSlash(dir))
+	var names []string
+	err := filepath.Walk(top, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == top {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.Mode().IsRegular() && !strings.HasSuffix(p, ".tmp") {
+			rel, err := filepath.Rel(top, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return names, errors.Wrapf(err, "list %s", top)
+}
+
+// Delete removes name from the directory.
+func (d DirRemote) Delete(name string) error {
+	return os.Remove(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// RcloneRemote is a remote reached with rclone, which supports S3, WebDAV and many other kinds of storage.
+// The remote is configured with rclone config.
+type RcloneRemote struct {
+	// Remote is the rclone remote and path backups are stored under, such as s3:bucket/books.
+	Remote string
+	// Command is the rclone executable. If empty, rclone is found in the PATH.
+	Command string
+	// Args are extra arguments given to each rclone command, such as --config.
+	Args []string
+}
+
+// Upload copies local to name on the remote with rclone copyto.
+func (r RcloneRemote) Upload(local, name string) error {
+	_, err := r.run("copyto", local, r.path(name))
+	return err
+}
+
+// List lists the files under dir on the remote with rclone lsf.
+func (r RcloneRemote) List(dir string) ([]string, error) {
+	out, err := r.run("lsf", "-R", "--files-only", r.path(dir))
+	if err != nil {
+		// rclone exits with status 3 when the directory doesn't exist.
+		if ee, ok := errors.Cause(err).(*exec.ExitError); ok && ee.ExitCode() == 3 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+// Delete removes name from the remote with rclone deletefile.
+func (r RcloneRemote) Delete(name string) error {
+	_, err := r.run("deletefile", r.path(name))
+	return err
+}
+
+// path returns the rclone path of name on the remote.
+func (r RcloneRemote) path(name string) string {
+	if strings.HasSuffix(r.Remote, ":") {
+		return r.Remote + name
+	}
+	return strings.TrimSuffix(r.Remote, "/") + "/" + path.Clean(name)
+}
+
+// run runs an rclone command, returning its output.
+func (r RcloneRemote) run(args ...string) (string, error) {
+	command := r.Command
+	if command == "" {
+		command = "rclone"
+	}
+	cmd := exec.Command(command, append(append([]string{}, r.Args...), args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.Wrapf(err, "rclone %s: %s", args[0], msg)
+		}
+		return "", errors.Wrapf(err, "rclone %s", args[0])
+	}
+	return stdout.String(), nil
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -21,36 +22,126 @@ import (
 )
 
 // Book represents a book in a library.
+// Its JSON encoding, with the field names used by the server's API, is how books are given to hooks and stored in backups.
+// The description, language and publication date are left out when they're empty; other fields are always included.
 type Book struct {
-	ID      int64
-	Authors []string
-	Title   string
-	Series  string
-	Files   []BookFile
+	ID      int64    `json:"id"`
+	Authors []string `json:"authors"`
+	Title   string   `json:"title"`
+	// Subtitle is the part of a book's title after the main title, such as "A Novel" in "Wicked: A Novel".
+	// It's kept apart from the title, so long subtitles don't end up in filenames or affect sorting, unless templates use it.
+	Subtitle string `json:"subtitle"`
+	Series   string `json:"series"`
+	// SeriesIndex is the book's position in its series, such as 2, or 2.5 for a novella between the second and third books,
+	// or 0 if it isn't known.
+	SeriesIndex float64      `json:"series_index"`
+	Publisher   string       `json:"publisher"`
+	Identifiers []Identifier `json:"identifiers"`
+	// Classifications are the book's places in library classification schemes, such as Dewey.
+	Classifications []Classification `json:"classifications"`
+	// Contributors are the people other than the authors who worked on the book, such as its editors and translators.
+	// When updating a book, nil leaves them unchanged.
+	Contributors []Contributor `json:"contributors"`
+	// Description is a summary of the book, such as the blurb on its back cover, as plain text with paragraphs separated by blank lines.
+	Description string `json:"description,omitempty"`
+	// Language is the language code of the language the book is written in, such as "en".
+	Language string `json:"language,omitempty"`
+	// Published is the date the book was published, as much of it as is known: a year, such as 1999,
+	// a year and month, such as 1999-05, or a full date, such as 1999-05-17. It's normalized with NormalizeDate.
+	Published string `json:"published,omitempty"`
+	// OriginalTitle and OriginalLanguage are the title and language the book was first published in, if it's a translation.
+	// OriginalLanguage is a language code, such as "ru".
+	OriginalTitle    string `json:"original_title"`
+	OriginalLanguage string `json:"original_language"`
+	// TranslationOf is the ID of the book this book is a translation of, usually an edition in the original language, or 0.
+	// It's set with SetTranslationOf, and isn't changed by updating the book.
+	TranslationOf int64 `json:"translation_of"`
+	// Translations are the IDs of the books which are translations of this book.
+	Translations []int64 `json:"translations"`
+	// AlternateTitles are the other titles the book is known by, such as its subtitle or an abbreviation.
+	// They're set with AddAlternateTitle, and aren't changed by updating the book.
+	AlternateTitles []AlternateTitle `json:"alternate_titles"`
+	// Pages is the number of pages in the book, or 0 if it isn't known.
+	Pages int `json:"pages"`
+	// Fandoms are the fandoms a work of fanfiction is written in, and Ships are the relationships, or pairings, it's about,
+	// such as "Draco Malfoy/Harry Potter". When updating a book, nil leaves them unchanged.
+	Fandoms []string `json:"fandoms"`
+	Ships   []string `json:"ships"`
+	// WordCount is the number of words in the book, or 0 if it isn't known.
+	WordCount int `json:"word_count"`
+	// SourceURL is where the book was published online, such as its page on Archive of Our Own or FanFiction.net.
+	SourceURL string `json:"source_url"`
+	// License is whether the book can be shared with others, such as LicensePublicDomain.
+	License License `json:"license"`
+	// CreatedOn is when the book was added to the library. It isn't changed by updating the book.
+	CreatedOn time.Time `json:"created_on"`
+	// FileAddedOn is when the book's newest file was added, which is later than CreatedOn
+	// if a format was added to a book already in the library. It isn't changed by updating the book.
+	FileAddedOn time.Time `json:"file_added_on"`
+	// UpdatedOn is when the book, its authors, tags, identifiers or classifications, or the files it has, last changed.
+	UpdatedOn time.Time `json:"updated_on"`
+	// Rating is the book's rating, from 1 to MaxRating, or 0 if it isn't rated.
+	// It's set with SetRating, and isn't changed by updating the book.
+	Rating int `json:"rating"`
+	// Tags are the tags which belong to the book as a whole, such as its genres.
+	// Tags which only apply to one file, such as "retail" or "ocr", belong to the file instead.
+	// When updating a book, nil leaves them unchanged.
+	Tags  []string   `json:"tags"`
+	Files []BookFile `json:"files"`
+	// UUID identifies the book across libraries. If empty when the book is imported, a new one is generated.
+	UUID string `json:"uuid"`
 }
 
 // BookFile represents a file linked to a book.
 type BookFile struct {
-	ID               int64
-	Extension        string
-	Tags             []string
-	Hash             string
-	OriginalFilename string
-	CurrentFilename  string
-	FileMtime        time.Time
-	FileSize         int64
-	Source           string
+	ID               int64     `json:"id"`
+	Extension        string    `json:"extension"`
+	Tags             []string  `json:"tags"`
+	Hash             string    `json:"hash"`
+	OriginalFilename string    `json:"original_filename"`
+	CurrentFilename  string    `json:"filename"`
+	FileMtime        time.Time `json:"mtime"`
+	FileSize         int64     `json:"size"`
+	Source           string    `json:"source"`
+	// Missing is true if the file was missing from the books root when the library was last checked.
+	Missing bool `json:"missing"`
+	// UUID identifies the file across libraries. If empty when the file is imported, a new one is generated.
+	UUID string `json:"uuid"`
+	// TemplateOverride, if not empty, is the template the file's name is generated from, instead of the library's output template.
+	// It's set with SetFileTemplate.
+	TemplateOverride string `json:"template_override,omitempty"`
+	// Root is the name of the books root the file is stored on, or empty for the main root.
+	// It's chosen when the file is imported, and changed with MoveFileToRoot.
+	Root string `json:"root,omitempty"`
+	// Path is the absolute path of a file cataloged in place with ImportOptions.InPlace, which is left where it is
+	// instead of being stored in a books root. It's empty for files stored in a books root.
+	Path string `json:"path,omitempty"`
+	// CreatedOn is when the file was added to the library, and UpdatedOn is when it or its tags or works last changed.
+	// Neither is changed by updating the file's book.
+	CreatedOn time.Time `json:"created_on"`
+	UpdatedOn time.Time `json:"updated_on"`
+	// Works are the works contained in the file, if it's a collection such as an anthology.
+	Works []ContainedWork `json:"works"`
 }
 
 // Filename retrieves a book's correct filename, based on the given output template.
+// The template is given the fields of the book and the file, such as {{.Title}} and {{.Extension}},
+// along with Author, the first author, and AuthorsShort, the first two authors joined with " & ".
 func (bf *BookFile) Filename(tmpl *template.Template, book *Book) (string, error) {
 	var fnBuff bytes.Buffer
+	// Tags in the template are the file's tags, and BookTags are the book's.
 	type FilenameTemplate struct {
-		Book
-		BookFile
+		*Book
+		*BookFile
+		Author       string
 		AuthorsShort string
+		Tags         []string
+		BookTags     []string
+	}
+	ft := FilenameTemplate{book, bf, "Unknown", "Unknown", bf.Tags, book.Tags}
+	if len(ft.Authors) > 0 {
+		ft.Author = ft.Authors[0]
 	}
-	ft := FilenameTemplate{*book, *bf, "Unknown"}
 	if len(ft.Authors) == 1 {
 		ft.AuthorsShort = ft.Authors[0]
 	} else if len(ft.Authors) == 2 {
@@ -72,20 +163,27 @@ func (bf *BookFile) CalculateHash() error {
 		bf.Hash = string(data)
 		return nil
 	}
-	fp, err := os.Open(bf.OriginalFilename)
+	hash, err := hashFile(bf.OriginalFilename)
 	if err != nil {
 		return errors.Wrap(err, "Calculate hash")
 	}
+	bf.Hash = hash
+	return nil
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of a file's contents, read no faster than the limit set by SetIOLimit.
+func hashFile(filename string) (string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
 	defer fp.Close()
 
 	hasher := sha256.New()
-	_, err = io.Copy(hasher, fp)
-	if err != nil {
-		return errors.Wrap(err, "Calculate hash")
+	if _, err := io.Copy(hasher, throttle(fp)); err != nil {
+		return "", err
 	}
-	hash := fmt.Sprintf("%x", hasher.Sum(nil))
-	bf.Hash = hash
-	return nil
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
 
 // HashPath gets the path of a file's hash, relative to books root.
@@ -94,7 +192,9 @@ func (bf *BookFile) HashPath() string {
 }
 
 // ParseFilename creates a new Book given a filename and regular expression.
-// The named groups author, title, series, and extension in the regular expression will map to their respective fields in the resulting book.
+// The named groups author, title, subtitle, series, series_index, publisher, and extension in the regular expression will map to their respective fields in the resulting book.
+// The year group is the year the book was published, or a date as accepted by NormalizeDate, and is ignored if it isn't one.
+// For fanfiction, the groups fandom and ship are lists separated by commas, and words is the word count.
 func ParseFilename(filename string, re *regexp.Regexp) (Book, bool) {
 	result := Book{}
 	bf := BookFile{}
@@ -107,12 +207,28 @@ func ParseFilename(filename string, re *regexp.Regexp) (Book, bool) {
 		result.Authors = append(result.Authors, strings.TrimSpace(author))
 	}
 	result.Title = mapping["title"]
+	result.Subtitle = mapping["subtitle"]
 	result.Series = mapping["series"]
+	result.SeriesIndex = parseSeriesIndex(mapping["series_index"])
+	result.Publisher = mapping["publisher"]
+	result.Published = parsePublished(mapping["year"])
+	result.Fandoms = SplitFanficTags(mapping["fandom"])
+	result.Ships = SplitFanficTags(mapping["ship"])
+	result.WordCount = ParseWordCount(mapping["words"])
 	bf.Extension = mapping["ext"]
 	result.Files = append(result.Files, bf)
 	return result, true
 }
 
+// parseSeriesIndex parses the position of a book in its series, such as "3" or "2.5", returning 0 if it isn't a number.
+func parseSeriesIndex(s string) float64 {
+	index, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || index < 0 {
+		return 0
+	}
+	return index
+}
+
 // Escape replaces special characters in a filename with _.
 func Escape(filename string) string {
 	replacements := []string{"\\", "/", ":", "*", "?", "\"", "<", ">", "|"}
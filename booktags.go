@@ -0,0 +1,197 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// getTagID returns the ID of a tag, inserting it if it doesn't exist.
+func getTagID(tx *sql.Tx, tag string) (int64, error) {
+	var tagID int64
+	err := tx.QueryRow("select id from tags where name=?", tag).Scan(&tagID)
+	if err == sql.ErrNoRows {
+		res, err := tx.Exec("insert into tags (name) values(?)", tag)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+	return tagID, err
+}
+
+// insertBookTag tags a book.
+func insertBookTag(tx *sql.Tx, bookID int64, tag string) error {
+	tagID, err := getTagID(tx, tag)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("insert or ignore into books_tags (book_id, tag_id) values(?, ?)", bookID, tagID); err != nil {
+		return errors.Wrap(err, "inserting book tag link")
+	}
+	return nil
+}
+
+// getTagsByBookIds gets the book-level tag names for each book ID.
+func getTagsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
+	m := make(map[int64][]string)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select bt.book_id, t.name from books_tags bt join tags t on bt.tag_id = t.id where bt.book_id in (" + joinInt64s(ids, ",") + ") order by bt.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bookID int64
+		var tag string
+		if err := rows.Scan(&bookID, &tag); err != nil {
+			return nil, err
+		}
+		m[bookID] = append(m[bookID], tag)
+	}
+	return m, rows.Err()
+}
+
+// AllTags returns the book's tags, followed by the tags of its files which the book doesn't have, without duplicates.
+func (b *Book) AllTags() []string {
+	seen := make(map[string]bool)
+	var tags []string
+	add := func(list []string) {
+		for _, t := range list {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	add(b.Tags)
+	for _, f := range b.Files {
+		add(f.Tags)
+	}
+	return tags
+}
+
+// PromoteTag makes tag a tag of the book, removing it from each of the book's files.
+// It returns false if neither the book nor any of its files had the tag, and the book wasn't changed.
+func (b *Book) PromoteTag(tag string) bool {
+	found := false
+	for i := range b.Files {
+		var tags []string
+		for _, t := range b.Files[i].Tags {
+			if t == tag {
+				found = true
+				continue
+			}
+			tags = append(tags, t)
+		}
+		b.Files[i].Tags = tags
+	}
+	for _, t := range b.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	if found {
+		b.Tags = append(b.Tags, tag)
+	}
+	return found
+}
+
+// DemoteTag removes tag from the book, and adds it to each of the book's files instead.
+// It returns false if the book didn't have the tag, and the book wasn't changed.
+func (b *Book) DemoteTag(tag string) bool {
+	found := false
+	tags := []string{}
+	for _, t := range b.Tags {
+		if t == tag {
+			found = true
+			continue
+		}
+		tags = append(tags, t)
+	}
+	if !found {
+		return false
+	}
+	b.Tags = tags
+	for i := range b.Files {
+		hasTag := false
+		for _, t := range b.Files[i].Tags {
+			hasTag = hasTag || t == tag
+		}
+		if !hasTag {
+			b.Files[i].Tags = append(b.Files[i].Tags, tag)
+		}
+	}
+	return true
+}
+
+// PromoteTag makes a tag of a book's files a tag of the book, as Book.PromoteTag does.
+// Its files are renamed with tmpl, since their tags may be part of their filenames.
+func (lib *Library) PromoteTag(bookID int64, tag string, tmpl *template.Template) error {
+	return lib.changeBookTags(bookID, tmpl, func(b *Book) bool { return b.PromoteTag(tag) })
+}
+
+// DemoteTag makes a tag of a book a tag of each of its files instead, as Book.DemoteTag does.
+// Its files are renamed with tmpl, since their tags may be part of their filenames.
+func (lib *Library) DemoteTag(bookID int64, tag string, tmpl *template.Template) error {
+	return lib.changeBookTags(bookID, tmpl, func(b *Book) bool { return b.DemoteTag(tag) })
+}
+
+// changeBookTags updates a book after change changes its tags.
+// If change returns false, an error is returned, since the book doesn't have the tag being changed.
+func (lib *Library) changeBookTags(bookID int64, tmpl *template.Template, change func(b *Book) bool) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	existing, err := getBooksByID(tx, []int64{bookID})
+	if err != nil {
+		return errors.Wrap(err, "get book")
+	}
+	if len(existing) == 0 {
+		return ErrBookNotFound
+	}
+	book := existing[0]
+	if !change(&book) {
+		return errors.Errorf("book %d doesn't have that tag", bookID)
+	}
+	if err := lib.updateBook(tx, book, tmpl, false); err != nil {
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID)
+	return errors.Wrap(err, "commit")
+}
+
+// TagNames returns the tags of the books and files in the library, in alphabetical order.
+func (lib *Library) TagNames() ([]string, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	names, err := queryStrings(tx, `select name from tags where id in (select tag_id from books_tags union select tag_id from files_tags)
+order by name collate `+unicodeCollation)
+	return names, errors.Wrap(err, "get tags")
+}
+
+// TagBookIDs returns the IDs of the books which have a tag, ignoring case, either themselves or on one of their files.
+func (lib *Library) TagBookIDs(tag string) ([]int64, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	ids, err := queryInt64s(tx, `select bt.book_id from books_tags bt join tags t on bt.tag_id = t.id where t.name = ? collate nocase
+union select f.book_id from files f join files_tags ft on ft.file_id = f.id join tags t on ft.tag_id = t.id where t.name = ? collate nocase
+order by 1`, tag, tag)
+	return ids, errors.Wrap(err, "get books with tag")
+}
@@ -0,0 +1,375 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBulkImportBatchSize is the number of files BulkImport imports in each transaction, unless told otherwise.
+const DefaultBulkImportBatchSize = 100
+
+// maxQueryParams is the most parameters put in one query, below SQLite's default limit of 999,
+// and the most IDs listed in one query, so long lists of them don't make queries too long.
+const maxQueryParams = 500
+
+// errBulkImportStopped stops the directory walk of a bulk import which has failed.
+var errBulkImportStopped = errors.New("bulk import stopped")
+
+// BulkImportStats counts the files seen by BulkImport.
+type BulkImportStats struct {
+	// Scanned is the number of files found and hashed, or which couldn't be.
+	Scanned int
+	// Imported is the number of files imported.
+	Imported int
+	// Duplicates is the number of files skipped because the library already has them.
+	Duplicates int
+	// Errors is the number of files which couldn't be hashed, parsed or imported.
+	Errors int
+}
+
+// BulkImportProgressFunc is called by BulkImport each time a file is scanned or imported, with the counts so far and the file.
+type BulkImportProgressFunc func(stats BulkImportStats, current string)
+
+// BulkBookFunc returns the book a file being bulk imported belongs to, with bf as its only file,
+// and the name of the metadata parser which matched it, which is recorded in the import report.
+// OriginalFilename, FileSize, FileMtime, Extension and Hash are set on bf.
+// It's called from several goroutines at once. If it returns an error, the file is counted as an error and skipped.
+type BulkBookFunc func(bf BookFile) (book Book, parser string, err error)
+
+// MetadataParserBookFunc returns a BulkBookFunc which parses the metadata of each file with p.
+// p must be safe to call from several goroutines at once.
+func MetadataParserBookFunc(p MetadataParser) BulkBookFunc {
+	return func(bf BookFile) (Book, string, error) {
+		book, parsed := p.Parse([]string{bf.OriginalFilename})
+		if !parsed {
+			return book, "", errors.Errorf("no metadata parser matched %s", bf.OriginalFilename)
+		}
+		book.Files = []BookFile{bf}
+		return book, "", nil
+	}
+}
+
+// BulkImportOptions controls how BulkImport imports files.
+type BulkImportOptions struct {
+	// ImportOptions are the options each file is imported with. IdempotencyKey and Parser are ignored.
+	ImportOptions
+	// Recursive imports the files in subdirectories too.
+	Recursive bool
+	// Workers is the number of files hashed and parsed at once. If 0, it's the number of CPUs.
+	Workers int
+	// BatchSize is the number of files imported in each transaction. If 0, it's DefaultBulkImportBatchSize.
+	BatchSize int
+	// Progress, if not nil, is called each time a file is scanned or imported.
+	Progress BulkImportProgressFunc
+}
+
+// bulkFile is a file found by BulkImport, hashed and parsed, or the error which stopped it from being.
+type bulkFile struct {
+	path   string
+	book   Book
+	parser string
+	err    error
+}
+
+// BulkImport imports the files in dir, which is faster than importing a large collection one file at a time with ImportBookWithOptions.
+// Files are hashed and parsed by a pool of workers, checked against the library for duplicates a batch at a time,
+// and imported in one transaction per batch. Files whose hash is already in the library are skipped,
+// and so are files which can't be hashed, parsed or imported, which are recorded in opts.Report.
+// If a batch can't be committed, none of its files are left in the books root, and files being moved are put back.
+// An error is returned if the import has to stop, such as when the books roots are full,
+// and the files imported before then stay imported.
+func (lib *Library) BulkImport(dir string, tmpl *template.Template, bookFunc BulkBookFunc, opts BulkImportOptions) (BulkImportStats, error) {
+	var stats BulkImportStats
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return stats, errors.Wrap(err, "get absolute path")
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBulkImportBatchSize
+	}
+	opts.IdempotencyKey = ""
+	progress := func(current string) {
+		if opts.Progress != nil {
+			opts.Progress(stats, current)
+		}
+	}
+
+	paths := make(chan string)
+	files := make(chan bulkFile)
+	stop := make(chan struct{})
+	var walkErr error
+	go func() {
+		defer close(paths)
+		walkErr = walkFiles(root, opts.Recursive, func(path string, info os.FileInfo) error {
+			select {
+			case paths <- path:
+				return nil
+			case <-stop:
+				return errBulkImportStopped
+			}
+		})
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				files <- scanBulkFile(path, bookFunc)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(files)
+	}()
+
+	var batch []bulkFile
+	for f := range files {
+		stats.Scanned++
+		if f.err != nil {
+			log.Printf("Cannot import %s: %s; skipping", f.path, f.err)
+			stats.Errors++
+			opts.Report.Fail(f.path, guessFromBook(f.book, f.parser), f.err)
+			progress(f.path)
+			continue
+		}
+		progress(f.path)
+		batch = append(batch, f)
+		if len(batch) < batchSize {
+			continue
+		}
+		err = lib.importBulkBatch(batch, tmpl, opts.ImportOptions, &stats, progress)
+		batch = nil
+		if err != nil {
+			close(stop)
+			// Let the workers finish the files they have, so they can exit.
+			for range files {
+			}
+			return stats, err
+		}
+	}
+	if err := lib.importBulkBatch(batch, tmpl, opts.ImportOptions, &stats, progress); err != nil {
+		return stats, err
+	}
+	return stats, errors.Wrapf(walkErr, "scan %s", dir)
+}
+
+// scanBulkFile hashes the file at path, and finds the book it belongs to with bookFunc.
+func scanBulkFile(path string, bookFunc BulkBookFunc) bulkFile {
+	f := bulkFile{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		f.err = errors.Wrap(err, "get file info")
+		return f
+	}
+	bf := BookFile{OriginalFilename: path, FileSize: info.Size(), FileMtime: info.ModTime(), Extension: strings.TrimPrefix(filepath.Ext(path), ".")}
+	if err := bf.CalculateHash(); err != nil {
+		f.err = errors.Wrap(err, "calculate hash")
+		return f
+	}
+	f.book, f.parser, f.err = bookFunc(bf)
+	if f.err == nil && len(f.book.Files) != 1 {
+		f.err = errors.New("book to import must contain only one file")
+	}
+	return f
+}
+
+// importBulkBatch imports a batch of files found by BulkImport in one transaction, updating stats and reporting progress as it goes.
+// An error is returned if the bulk import has to stop.
+func (lib *Library) importBulkBatch(batch []bulkFile, tmpl *template.Template, opts ImportOptions, stats *BulkImportStats, progress func(string)) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	fail := func(f bulkFile, err error) {
+		log.Printf("Cannot import %s: %s; skipping", f.path, err)
+		stats.Errors++
+		opts.Report.Fail(f.path, guessFromBook(f.book, f.parser), err)
+		progress(f.path)
+	}
+
+	// Files already in the library, or earlier in the batch, are checked for all at once, rather than one at a time as they're imported.
+	hashes := make([]string, len(batch))
+	for i, f := range batch {
+		hashes[i] = f.book.Files[0].Hash
+	}
+	existing, err := lib.bookIDsByHash(hashes)
+	if err != nil {
+		return err
+	}
+	var pending []*pendingImport
+	// held are files with the same hash as one pending earlier in the batch. They're only known to be duplicates
+	// once that file is committed, so they're set aside until then.
+	var held []bulkFile
+	batchHashes := make(map[string]bool)
+	for _, f := range batch {
+		bf := f.book.Files[0]
+		if batchHashes[bf.Hash] {
+			held = append(held, f)
+			continue
+		}
+		if bookID, ok := existing[bf.Hash]; ok {
+			log.Printf("Not importing %s, since the library already has a file with the same hash", f.path)
+			stats.Duplicates++
+			opts.Report.add(ImportReportEntry{File: f.path, Status: ImportDuplicate, BookID: bookID,
+				Reason: "a file with the same hash is already in the library", Metadata: guessFromBook(f.book, f.parser)})
+			if opts.Move && !opts.InPlace {
+				if err := os.Remove(f.path); err != nil {
+					log.Printf("Error deleting %s: %v", f.path, err)
+				}
+			}
+			progress(f.path)
+			continue
+		}
+		fileOpts := opts
+		fileOpts.Parser = f.parser
+		p, err := lib.prepareImport(f.book, fileOpts)
+		if err != nil {
+			fail(f, err)
+			continue
+		}
+		batchHashes[bf.Hash] = true
+		pending = append(pending, &pendingImport{file: f, prepared: p, opts: fileOpts})
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := lib.importPendingBulk(pending, tmpl, stats, fail, progress); err != nil {
+		return err
+	}
+	// The held files are looked up again now that the batch is committed: those whose contents were imported are duplicates,
+	// and only then removed if moving, and the others are imported in their place.
+	return lib.importBulkBatch(held, tmpl, opts, stats, progress)
+}
+
+// importPendingBulk imports the prepared files of a batch in one transaction, each in a savepoint so one which fails doesn't fail the rest.
+// If a file doesn't fit in the books roots, the files after it aren't imported, and the error is returned once the rest are committed.
+func (lib *Library) importPendingBulk(pending []*pendingImport, tmpl *template.Template, stats *BulkImportStats, fail func(bulkFile, error), progress func(string)) error {
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	var imported []*pendingImport
+	// abort rolls back the batch when it can't continue, taking the files placed so far out of the books root.
+	abort := func(err error) error {
+		tx.Rollback()
+		for _, pi := range imported {
+			lib.undoPlacedFile(pi.out)
+		}
+		return err
+	}
+	var stopErr error
+	for _, pi := range pending {
+		// Each file is imported in a savepoint, so one which fails can be rolled back without the rest of the batch.
+		if _, err := tx.Exec("savepoint bulk_import_file"); err != nil {
+			return abort(errors.Wrap(err, "create savepoint"))
+		}
+		pi.out, err = lib.importPrepared(tx, pi.prepared, tmpl, pi.opts)
+		if err != nil {
+			lib.undoPlacedFile(pi.out)
+			if _, rbErr := tx.Exec("rollback to bulk_import_file; release bulk_import_file"); rbErr != nil {
+				return abort(errors.Wrap(rbErr, "roll back to savepoint"))
+			}
+			fail(pi.file, err)
+			if errors.Cause(err) == ErrInsufficientSpace {
+				// The rest of the files won't fit either, so stop before trying to copy them.
+				stopErr = err
+				break
+			}
+			continue
+		}
+		imported = append(imported, pi)
+		if _, err := tx.Exec("release bulk_import_file"); err != nil {
+			return abort(errors.Wrap(err, "release savepoint"))
+		}
+	}
+
+	err = tx.Commit()
+	ids := make([]int64, len(imported))
+	for i, pi := range imported {
+		ids[i] = pi.out.book.ID
+	}
+	lib.invalidateBooks(ids...)
+	if err != nil {
+		for _, pi := range imported {
+			lib.undoPlacedFile(pi.out)
+			fail(pi.file, errors.Wrap(err, "commit"))
+		}
+		return errors.Wrap(err, "commit")
+	}
+	for _, pi := range imported {
+		lib.finishImport(pi.prepared, pi.out, pi.opts)
+		if pi.out.duplicateOf != 0 {
+			stats.Duplicates++
+		} else {
+			stats.Imported++
+		}
+		progress(pi.file.path)
+	}
+	return stopErr
+}
+
+// pendingImport is a file in a batch being imported by BulkImport.
+type pendingImport struct {
+	file     bulkFile
+	prepared *preparedImport
+	opts     ImportOptions
+	out      importOutcome
+}
+
+// bookIDsByHash returns the ID of a book with a file with each of hashes, for the hashes the library has.
+func (lib *Library) bookIDsByHash(hashes []string) (map[string]int64, error) {
+	m := make(map[string]int64)
+	// SQLite limits the number of parameters in a query, so large batches are looked up in chunks.
+	for len(hashes) > 0 {
+		n := len(hashes)
+		if n > maxQueryParams {
+			n = maxQueryParams
+		}
+		args := make([]interface{}, n)
+		for i, h := range hashes[:n] {
+			args[i] = h
+		}
+		hashes = hashes[n:]
+		rows, err := lib.Query("select hash, min(book_id) from files where hash in (?"+strings.Repeat(", ?", n-1)+") group by hash", args...)
+		if err != nil {
+			return nil, errors.Wrap(err, "find files by hash")
+		}
+		for rows.Next() {
+			var hash string
+			var id int64
+			if err := rows.Scan(&hash, &id); err != nil {
+				rows.Close()
+				return nil, errors.Wrap(err, "find files by hash")
+			}
+			m[hash] = id
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "find files by hash")
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,213 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a fixed size cache of values by ID, which discards the least recently used values first.
+type lruCache struct {
+	size  int
+	order *list.List
+	items map[int64]*list.Element
+}
+
+type lruEntry struct {
+	id    int64
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), items: make(map[int64]*list.Element)}
+}
+
+func (c *lruCache) get(id int64) (interface{}, bool) {
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) put(id int64, value interface{}) {
+	if el, ok := c.items[id]; ok {
+		el.Value.(*lruEntry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[id] = c.order.PushFront(&lruEntry{id, value})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).id)
+	}
+}
+
+func (c *lruCache) remove(id int64) {
+	if el, ok := c.items[id]; ok {
+		c.order.Remove(el)
+		delete(c.items, id)
+	}
+}
+
+func (c *lruCache) clear() {
+	c.order.Init()
+	c.items = make(map[int64]*list.Element)
+}
+
+// metadataCache caches books and files retrieved by ID.
+// Every invalidation increments the generation, so that results read from the database
+// before an invalidation aren't cached after it.
+type metadataCache struct {
+	mu         sync.Mutex
+	books      *lruCache
+	files      *lruCache
+	generation uint64
+}
+
+// EnableCache caches up to size books, and size files, retrieved by GetBooksByID and GetFilesByID in memory.
+// The cache is invalidated when the library changes the books, but not when another process changes the library file.
+// If size is 0 or less, caching is disabled.
+func (lib *Library) EnableCache(size int) {
+	if size <= 0 {
+		lib.cache = nil
+		return
+	}
+	lib.cache = &metadataCache{books: newLRUCache(size), files: newLRUCache(size)}
+}
+
+// getBooks returns copies of the cached books with the given IDs, the IDs which weren't cached,
+// and the current generation, to pass to putBooks.
+func (c *metadataCache) getBooks(ids []int64) (found []Book, missing []int64, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		if v, ok := c.books.get(id); ok {
+			found = append(found, copyBook(v.(Book)))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return found, missing, c.generation
+}
+
+// putBooks caches books read from the database, unless the cache was invalidated since generation.
+func (c *metadataCache) putBooks(books []Book, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	for _, b := range books {
+		c.books.put(b.ID, copyBook(b))
+	}
+}
+
+// getFiles is like getBooks, for files.
+func (c *metadataCache) getFiles(ids []int64) (found []BookFile, missing []int64, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		if v, ok := c.files.get(id); ok {
+			found = append(found, copyBookFile(v.(BookFile)))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return found, missing, c.generation
+}
+
+// putFiles is like putBooks, for files.
+func (c *metadataCache) putFiles(files []BookFile, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	for _, f := range files {
+		c.files.put(f.ID, copyBookFile(f))
+	}
+}
+
+// invalidateBooks removes books, and their cached files, from the cache.
+func (c *metadataCache) invalidateBooks(ids ...int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, id := range ids {
+		if v, ok := c.books.get(id); ok {
+			for _, f := range v.(Book).Files {
+				c.files.remove(f.ID)
+			}
+		}
+		c.books.remove(id)
+	}
+}
+
+// invalidateAll empties the cache.
+func (c *metadataCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.books.clear()
+	c.files.clear()
+}
+
+// invalidateBooks removes books from the library's cache, if it's enabled.
+// It should be called after any change to the books, their files, or their identifiers.
+func (lib *Library) invalidateBooks(ids ...int64) {
+	if lib.cache != nil {
+		lib.cache.invalidateBooks(ids...)
+	}
+}
+
+// invalidateCache empties the library's cache, if it's enabled.
+// It should be called after changes which can't easily be traced to specific books.
+func (lib *Library) invalidateCache() {
+	if lib.cache != nil {
+		lib.cache.invalidateAll()
+	}
+}
+
+// copyBook returns a copy of b which shares no slices with it, so that callers can't modify cached books.
+func copyBook(b Book) Book {
+	b.Authors = append([]string(nil), b.Authors...)
+	b.Identifiers = append([]Identifier(nil), b.Identifiers...)
+	b.Classifications = append([]Classification(nil), b.Classifications...)
+	b.Translations = append([]int64(nil), b.Translations...)
+	b.AlternateTitles = append([]AlternateTitle(nil), b.AlternateTitles...)
+	if b.Tags != nil {
+		b.Tags = append([]string(nil), b.Tags...)
+	}
+	if b.Contributors != nil {
+		b.Contributors = append([]Contributor(nil), b.Contributors...)
+	}
+	files := make([]BookFile, len(b.Files))
+	for i, f := range b.Files {
+		files[i] = copyBookFile(f)
+	}
+	if b.Files == nil {
+		files = nil
+	}
+	b.Files = files
+	return b
+}
+
+// copyBookFile returns a copy of f which shares no slices with it.
+func copyBookFile(f BookFile) BookFile {
+	f.Tags = append([]string(nil), f.Tags...)
+	if f.Works != nil {
+		works := make([]ContainedWork, len(f.Works))
+		for i, w := range f.Works {
+			w.Authors = append([]string(nil), w.Authors...)
+			works[i] = w
+		}
+		f.Works = works
+	}
+	return f
+}
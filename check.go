@@ -0,0 +1,241 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// SearchIndexProblems describes inconsistencies between books_fts and the books table.
+type SearchIndexProblems struct {
+	// GhostIDs are search index entries which don't refer to an existing book.
+	GhostIDs []int64
+	// MissingIDs are books which don't have a search index entry.
+	MissingIDs []int64
+}
+
+// Empty returns true if no problems were found.
+func (p SearchIndexProblems) Empty() bool {
+	return len(p.GhostIDs) == 0 && len(p.MissingIDs) == 0
+}
+
+// CheckSearchIndex reports search index entries without books, and books without search index entries.
+func (lib *Library) CheckSearchIndex() (SearchIndexProblems, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return SearchIndexProblems{}, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	return checkSearchIndex(tx)
+}
+
+func checkSearchIndex(tx *sql.Tx) (SearchIndexProblems, error) {
+	var problems SearchIndexProblems
+	var err error
+	problems.GhostIDs, err = queryInt64s(tx, "select docid from books_fts where docid not in (select id from books)")
+	if err != nil {
+		return problems, errors.Wrap(err, "find ghost search entries")
+	}
+	problems.MissingIDs, err = queryInt64s(tx, "select id from books where id not in (select docid from books_fts)")
+	if err != nil {
+		return problems, errors.Wrap(err, "find books missing from search")
+	}
+	return problems, nil
+}
+
+// RepairSearchIndex removes ghost search index entries, and indexes books which are missing from the search index.
+// If progress isn't nil, it will be called as each missing book is indexed.
+func (lib *Library) RepairSearchIndex(progress ProgressFunc) (SearchIndexProblems, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return SearchIndexProblems{}, errors.Wrap(err, "begin transaction")
+	}
+	problems, err := checkSearchIndex(tx)
+	if err != nil {
+		tx.Rollback()
+		return problems, err
+	}
+	if len(problems.GhostIDs) > 0 {
+		if _, err := tx.Exec("delete from books_fts where docid in (" + joinInt64s(problems.GhostIDs, ",") + ")"); err != nil {
+			tx.Rollback()
+			return problems, errors.Wrap(err, "delete ghost search entries")
+		}
+	}
+	books, err := getBooksByID(tx, problems.MissingIDs)
+	if err != nil {
+		tx.Rollback()
+		return problems, errors.Wrap(err, "get books missing from search")
+	}
+	tracker := newProgressTracker("reindex", len(books), progress)
+	for i := range books {
+		tracker.start(books[i].Title)
+		if err := indexBook(tx, books[i].ID); err != nil {
+			tx.Rollback()
+			return problems, errors.Wrapf(err, "index book %d", books[i].ID)
+		}
+		tracker.done()
+	}
+	if err := tx.Commit(); err != nil {
+		return problems, errors.Wrap(err, "commit")
+	}
+	log.Printf("Repaired search index: removed %d ghost entries, indexed %d books", len(problems.GhostIDs), len(problems.MissingIDs))
+	return problems, nil
+}
+
+// queryInt64s runs a query returning a single integer column, and collects the results.
+func queryInt64s(tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CheckMissingFiles checks that every file in the library exists in the books root, and returns the files which don't.
+// The missing flag of each file is updated to match, so that missing files can be annotated in book results.
+// If progress isn't nil, it will be called as each file is checked.
+func (lib *Library) CheckMissingFiles(progress ProgressFunc) ([]BookFile, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	ids, err := queryInt64s(tx, "select id from files order by id")
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "get file IDs")
+	}
+	files, err := getFilesByID(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "get files")
+	}
+
+	var missing []BookFile
+	tracker := newProgressTracker("check files", len(files), progress)
+	for _, f := range files {
+		tracker.start(f.CurrentFilename)
+		fn, err := lib.FilePath(f)
+		if errors.Cause(err) == ErrRootUnavailable {
+			// Files on a drive which isn't mounted aren't missing.
+			tracker.done()
+			continue
+		} else if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		_, err = os.Stat(fn)
+		if err != nil && !os.IsNotExist(err) {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "stat file %d", f.ID)
+		}
+		isMissing := os.IsNotExist(err)
+		if isMissing != f.Missing {
+			if _, err := tx.Exec("update files set missing=? where id=?", isMissing, f.ID); err != nil {
+				tx.Rollback()
+				return nil, errors.Wrap(err, "update missing flag")
+			}
+			f.Missing = isMissing
+		}
+		if isMissing {
+			missing = append(missing, f)
+		}
+		tracker.done()
+	}
+	err = tx.Commit()
+	lib.invalidateCache()
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	return missing, nil
+}
+
+// FindSplitBooks returns groups of books which have the same title and authors, ignoring case and the order of authors.
+// Such books were created by older versions, which compared authors in order.
+// The first ID in each group is the oldest book.
+func (lib *Library) FindSplitBooks() ([][]int64, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	return findSplitBooks(tx)
+}
+
+func findSplitBooks(tx *sql.Tx) ([][]int64, error) {
+	ids, err := queryInt64s(tx, "select id from books order by id")
+	if err != nil {
+		return nil, errors.Wrap(err, "get book IDs")
+	}
+	books, err := getBooksByID(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+
+	groupMap := make(map[string][]int64)
+	var keys []string
+	for _, b := range books {
+		authors := make([]string, 0, len(b.Authors))
+		for name := range authorSet(b.Authors) {
+			authors = append(authors, name)
+		}
+		sort.Strings(authors)
+		key := strings.ToLower(b.Title) + "\x00" + strings.Join(authors, "\x00")
+		if _, ok := groupMap[key]; !ok {
+			keys = append(keys, key)
+		}
+		groupMap[key] = append(groupMap[key], b.ID)
+	}
+
+	var groups [][]int64
+	for _, key := range keys {
+		if len(groupMap[key]) > 1 {
+			groups = append(groups, groupMap[key])
+		}
+	}
+	return groups, nil
+}
+
+// MergeSplitBooks merges each group of books found by FindSplitBooks into its oldest book, and returns the merged groups.
+func (lib *Library) MergeSplitBooks(tmpl *template.Template) ([][]int64, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	groups, err := findSplitBooks(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, ids := range groups {
+		if err := lib.mergeBooks(tx, ids, tmpl); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "merge books %v", ids)
+		}
+	}
+	err = tx.Commit()
+	lib.invalidateCache()
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	log.Printf("Merged %d groups of split books", len(groups))
+	return groups, nil
+}
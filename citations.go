@@ -0,0 +1,165 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// CitationFormat is a format citations can be exported in.
+type CitationFormat string
+
+// Citation formats.
+const (
+	BibTeX CitationFormat = "bibtex"
+	RIS    CitationFormat = "ris"
+)
+
+// ExportCitations returns citations of the books matching query, or all books if query is empty, ordered by author.
+func (lib *Library) ExportCitations(query string, format CitationFormat) (string, error) {
+	if format != BibTeX && format != RIS {
+		return "", errors.Errorf("unknown citation format %s", format)
+	}
+	var bks []Book
+	var err error
+	if strings.TrimSpace(query) == "" {
+		var ids []int64
+		if ids, err = lib.ListBookIDs(SortByID, false); err == nil {
+			bks, err = lib.GetBooksByID(ids)
+		}
+	} else {
+		bks, err = lib.Search(query)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "find books")
+	}
+	byID := make(map[int64]Book, len(bks))
+	ids := make([]int64, len(bks))
+	for i, book := range bks {
+		byID[book.ID] = book
+		ids[i] = book.ID
+	}
+	if ids, err = lib.SortBookIDs(ids, SortByAuthor, false); err != nil {
+		return "", errors.Wrap(err, "sort books")
+	}
+
+	var sb strings.Builder
+	keys := make(map[string]bool)
+	for _, id := range ids {
+		book := byID[id]
+		if format == BibTeX {
+			writeBibTeX(&sb, book, citationKey(book, keys))
+		} else {
+			writeRIS(&sb, book)
+		}
+	}
+	return sb.String(), nil
+}
+
+// citationName converts an author's name to "Last, First", the form used in citations.
+// Names which are already in that form, or are a single word, are left alone.
+func citationName(name string) string {
+	if strings.Contains(name, ",") {
+		return name
+	}
+	fields := strings.Fields(name)
+	if len(fields) < 2 {
+		return name
+	}
+	last := len(fields) - 1
+	if authorSuffixes[strings.ToLower(fields[last])] && last > 1 {
+		return fields[last-1] + ", " + strings.Join(fields[:last-1], " ") + ", " + fields[last]
+	}
+	return fields[last] + ", " + strings.Join(fields[:last], " ")
+}
+
+// isbn returns the first of a book's ISBNs, or an empty string if it has none.
+func isbn(book Book) string {
+	for _, ident := range book.Identifiers {
+		if ident.Type == "isbn" {
+			return ident.Value
+		}
+	}
+	return ""
+}
+
+// citationKey returns a BibTeX key for a book, made from its first author's last name and the first word of its title.
+// keys holds the keys already used, and a number is added to keys which would be duplicates.
+func citationKey(book Book, keys map[string]bool) string {
+	var key string
+	if len(book.Authors) > 0 {
+		key = asciiWord(strings.Split(citationName(book.Authors[0]), ",")[0])
+	}
+	for _, w := range strings.Fields(book.Title) {
+		if w := asciiWord(w); w != "" && !minorWords[w] {
+			key += w
+			break
+		}
+	}
+	if key == "" {
+		key = fmt.Sprintf("book%d", book.ID)
+	}
+	unique := key
+	for i := 2; keys[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", key, i)
+	}
+	keys[unique] = true
+	return unique
+}
+
+// asciiWord lowercases s, and removes everything but ASCII letters and digits.
+func asciiWord(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+var bibTeXReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`,
+	"~", `\textasciitilde{}`, "^", `\textasciicircum{}`,
+)
+
+func writeBibTeX(sb *strings.Builder, book Book, key string) {
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(sb, "  %s = {%s},\n", name, bibTeXReplacer.Replace(value))
+		}
+	}
+	fmt.Fprintf(sb, "@book{%s,\n", key)
+	authors := make([]string, len(book.Authors))
+	for i, a := range book.Authors {
+		authors[i] = citationName(a)
+	}
+	field("author", strings.Join(authors, " and "))
+	field("title", book.Title)
+	field("series", book.Series)
+	field("publisher", book.Publisher)
+	field("isbn", isbn(book))
+	sb.WriteString("}\n\n")
+}
+
+func writeRIS(sb *strings.Builder, book Book) {
+	field := func(tag, value string) {
+		if value != "" {
+			fmt.Fprintf(sb, "%s  - %s\n", tag, value)
+		}
+	}
+	field("TY", "BOOK")
+	for _, a := range book.Authors {
+		field("AU", citationName(a))
+	}
+	field("TI", book.Title)
+	field("T3", book.Series)
+	field("PB", book.Publisher)
+	field("SN", isbn(book))
+	sb.WriteString("ER  - \n\n")
+}
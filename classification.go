@@ -0,0 +1,335 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Classification schemes.
+const (
+	// Dewey is the Dewey Decimal Classification, such as 823.914.
+	Dewey = "ddc"
+	// LCC is the Library of Congress Classification, such as PR6063.A438 F67 2001.
+	LCC = "lcc"
+)
+
+// Classification is a book's place in a library classification scheme.
+// A book has at most one classification in each scheme.
+type Classification struct {
+	// Scheme is Dewey or LCC.
+	Scheme string `json:"scheme"`
+	Number string `json:"number"`
+}
+
+// ClassificationProvider looks up the classifications of books, usually from an online catalog.
+type ClassificationProvider interface {
+	Classify(book Book) ([]Classification, error)
+}
+
+// ClassificationNode is a class in the classification tree, returned by ClassificationTree.
+type ClassificationNode struct {
+	Number string
+	// Caption is the name of the class, if it's a main class.
+	Caption string
+	// Books is the number of books in the class, or any class under it.
+	Books int
+	// HasChildren is true if books are classified more specifically than Number.
+	HasChildren bool
+}
+
+var (
+	deweyRegexp = regexp.MustCompile(`^\d{3}(\.\d+)?$`)
+	lccRegexp   = regexp.MustCompile(`^([A-Z])([A-Z]{0,2}) ?(\d+)?`)
+)
+
+// classificationCaptions are the names of the main classes of each scheme.
+var classificationCaptions = map[string]map[string]string{Dewey: deweyCaptions, LCC: lccCaptions}
+
+// deweyCaptions are the names of the main classes of the Dewey Decimal Classification.
+var deweyCaptions = map[string]string{
+	"000": "Computer science, information and general works",
+	"100": "Philosophy and psychology",
+	"200": "Religion",
+	"300": "Social sciences",
+	"400": "Language",
+	"500": "Science",
+	"600": "Technology",
+	"700": "Arts and recreation",
+	"800": "Literature",
+	"900": "History and geography",
+}
+
+// lccCaptions are the names of the main classes of the Library of Congress Classification.
+var lccCaptions = map[string]string{
+	"A": "General works",
+	"B": "Philosophy, psychology, religion",
+	"C": "Auxiliary sciences of history",
+	"D": "World history",
+	"E": "History of the Americas",
+	"F": "History of the Americas",
+	"G": "Geography, anthropology, recreation",
+	"H": "Social sciences",
+	"J": "Political science",
+	"K": "Law",
+	"L": "Education",
+	"M": "Music",
+	"N": "Fine arts",
+	"P": "Language and literature",
+	"Q": "Science",
+	"R": "Medicine",
+	"S": "Agriculture",
+	"T": "Technology",
+	"U": "Military science",
+	"V": "Naval science",
+	"Z": "Bibliography, library science",
+}
+
+// NormalizeClassification checks a classification number, and converts it to a standard form.
+// Dewey numbers have segmentation marks and spaces removed, and LCC numbers are uppercased with single spaces.
+func NormalizeClassification(scheme, number string) (Classification, error) {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	switch scheme {
+	case Dewey:
+		number = strings.NewReplacer("/", "", "'", "", " ", "").Replace(number)
+		if !deweyRegexp.MatchString(number) {
+			return Classification{}, errors.Errorf("invalid Dewey number %s", number)
+		}
+	case LCC:
+		number = strings.ToUpper(collapseSpace(number))
+		if !lccRegexp.MatchString(number) {
+			return Classification{}, errors.Errorf("invalid LCC number %s", number)
+		}
+	default:
+		return Classification{}, errors.Errorf("unknown classification scheme %s", scheme)
+	}
+	return Classification{scheme, number}, nil
+}
+
+// classificationPath returns the classes a classification is in, from the most general to its full number.
+// Dewey numbers are in a main class (800), a division (820) and a section (823).
+// LCC numbers are in a class (P), a subclass (PR) and a class number (PR6063).
+func classificationPath(c Classification) []string {
+	var path []string
+	switch c.Scheme {
+	case Dewey:
+		path = []string{c.Number[:1] + "00", c.Number[:2] + "0", c.Number[:3]}
+	case LCC:
+		m := lccRegexp.FindStringSubmatch(c.Number)
+		if m == nil {
+			break
+		}
+		path = append(path, m[1])
+		if m[2] != "" {
+			path = append(path, m[1]+m[2])
+		}
+		if m[3] != "" {
+			path = append(path, m[1]+m[2]+m[3])
+		}
+	}
+	path = append(path, c.Number)
+	// Remove classes which are the same as the class above them, such as the division of 800.
+	unique := path[:1]
+	for _, class := range path[1:] {
+		if class != unique[len(unique)-1] {
+			unique = append(unique, class)
+		}
+	}
+	return unique
+}
+
+// SetClassification sets a book's classification in scheme to number.
+// If number is empty, the book's classification in scheme is removed.
+func (lib *Library) SetClassification(bookID int64, scheme, number string) error {
+	var err error
+	if strings.TrimSpace(number) == "" {
+		_, err = lib.Exec("delete from book_classifications where book_id=? and scheme=?", bookID, strings.ToLower(strings.TrimSpace(scheme)))
+	} else {
+		var c Classification
+		if c, err = NormalizeClassification(scheme, number); err != nil {
+			return err
+		}
+		_, err = lib.Exec("insert or replace into book_classifications (book_id, scheme, number) values(?, ?, ?)", bookID, c.Scheme, c.Number)
+	}
+	lib.invalidateBooks(bookID)
+	if err != nil {
+		return errors.Wrap(err, "set classification")
+	}
+	return nil
+}
+
+// addClassification adds a classification to a book, unless it already has one in the same scheme.
+// It returns true if the classification was added.
+func addClassification(tx *sql.Tx, bookID int64, c Classification) (bool, error) {
+	c, err := NormalizeClassification(c.Scheme, c.Number)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("insert or ignore into book_classifications (book_id, scheme, number) values(?, ?, ?)", bookID, c.Scheme, c.Number)
+	if err != nil {
+		return false, errors.Wrap(err, "insert classification")
+	}
+	n, err := res.RowsAffected()
+	return n > 0, errors.Wrap(err, "insert classification")
+}
+
+// ClassifyBooks looks up the classifications of the books in ids with provider, and adds them.
+// Books are only looked up if they're missing a classification, and existing classifications are kept.
+// It returns the number of books which were given new classifications.
+func (lib *Library) ClassifyBooks(ids []int64, provider ClassificationProvider) (int, error) {
+	bks, err := lib.GetBooksByID(ids)
+	if err != nil {
+		return 0, errors.Wrap(err, "get books")
+	}
+	classified := 0
+	for _, book := range bks {
+		if len(book.Classifications) >= 2 {
+			continue
+		}
+		cs, err := provider.Classify(book)
+		if err != nil {
+			log.Printf("Cannot classify book %d: %s", book.ID, err)
+			continue
+		}
+		if len(cs) == 0 {
+			continue
+		}
+		tx, err := lib.Begin()
+		if err != nil {
+			return classified, errors.Wrap(err, "begin transaction")
+		}
+		added := false
+		for _, c := range cs {
+			ok, err := addClassification(tx, book.ID, c)
+			if err != nil {
+				log.Printf("Not classifying book %d: %s", book.ID, err)
+			}
+			added = added || ok
+		}
+		err = tx.Commit()
+		lib.invalidateBooks(book.ID)
+		if err != nil {
+			return classified, errors.Wrap(err, "commit")
+		}
+		if added {
+			classified++
+		}
+	}
+	return classified, nil
+}
+
+// ClassificationTree returns the classes directly under parent in scheme which have books in them, in order.
+// If parent is empty, the main classes are returned.
+func (lib *Library) ClassificationTree(scheme, parent string) ([]ClassificationNode, error) {
+	paths, err := lib.classificationPaths(scheme)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]*ClassificationNode)
+	for _, path := range paths {
+		i := 0
+		if parent != "" {
+			for i < len(path) && path[i] != parent {
+				i++
+			}
+			i++
+		}
+		if i >= len(path) {
+			continue
+		}
+		node, ok := nodes[path[i]]
+		if !ok {
+			node = &ClassificationNode{Number: path[i]}
+			if i == 0 {
+				node.Caption = classificationCaptions[strings.ToLower(scheme)][path[i]]
+			}
+			nodes[path[i]] = node
+		}
+		node.Books++
+		if i < len(path)-1 {
+			node.HasChildren = true
+		}
+	}
+	var result []ClassificationNode
+	for _, node := range nodes {
+		result = append(result, *node)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Number < result[j].Number })
+	return result, nil
+}
+
+// ClassifiedBookIDs returns the IDs of the books in a class, or any class under it, ordered by classification number.
+func (lib *Library) ClassifiedBookIDs(scheme, number string) ([]int64, error) {
+	rows, err := lib.Query("select book_id, number from book_classifications where scheme=? order by number, book_id", strings.ToLower(scheme))
+	if err != nil {
+		return nil, errors.Wrap(err, "get classifications")
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var c Classification
+		var id int64
+		if err := rows.Scan(&id, &c.Number); err != nil {
+			return nil, errors.Wrap(err, "get classifications")
+		}
+		c.Scheme = strings.ToLower(scheme)
+		for _, class := range classificationPath(c) {
+			if class == number {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	return ids, errors.Wrap(rows.Err(), "get classifications")
+}
+
+// classificationPaths returns the path of each book's classification in scheme.
+func (lib *Library) classificationPaths(scheme string) ([][]string, error) {
+	scheme = strings.ToLower(scheme)
+	if scheme != Dewey && scheme != LCC {
+		return nil, errors.Errorf("unknown classification scheme %s", scheme)
+	}
+	rows, err := lib.Query("select number from book_classifications where scheme=?", scheme)
+	if err != nil {
+		return nil, errors.Wrap(err, "get classifications")
+	}
+	defer rows.Close()
+	var paths [][]string
+	for rows.Next() {
+		c := Classification{Scheme: scheme}
+		if err := rows.Scan(&c.Number); err != nil {
+			return nil, errors.Wrap(err, "get classifications")
+		}
+		paths = append(paths, classificationPath(c))
+	}
+	return paths, errors.Wrap(rows.Err(), "get classifications")
+}
+
+// getClassificationsByBookIds gets classifications for each book ID.
+func getClassificationsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]Classification, error) {
+	m := make(map[int64][]Classification)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select " + classificationRowColumns + " from book_classifications where book_id in (" + joinInt64s(ids, ",") + ") order by scheme")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row, err := scanClassificationRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		m[row.BookID] = append(m[row.BookID], row.classification())
+	}
+	return m, rows.Err()
+}
@@ -0,0 +1,273 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// CleanupRule normalizes part of a book's metadata.
+type CleanupRule struct {
+	Name        string
+	Description string
+	Apply       func(book *Book)
+}
+
+// CleanupRules are the available cleanup rules, in the order they should be applied.
+var CleanupRules = []CleanupRule{
+	{"trim", "Remove extra whitespace", trimMetadata},
+	{"strip-format", `Remove format suffixes such as "(epub)" from titles`, stripFormatSuffixes},
+	{"swap-authors", `Change authors written as "Last, First" to "First Last"`, swapAuthorNames},
+	{"title-case", "Capitalize titles and series in title case", titleCaseMetadata},
+	{"subtitle", `Move subtitles out of titles such as "Title: Subtitle"`, splitSubtitle},
+}
+
+// LookupCleanupRule returns the cleanup rule with the given name.
+func LookupCleanupRule(name string) (CleanupRule, bool) {
+	for _, rule := range CleanupRules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return CleanupRule{}, false
+}
+
+// CleanupChange is a change made to a book's metadata by CleanupMetadata.
+type CleanupChange struct {
+	Old Book
+	New Book
+	// ConflictID is the ID of an existing book with the new title and authors.
+	// If it's not 0, the change wasn't made.
+	ConflictID int64
+}
+
+// CleanupMetadata applies cleanup rules to the metadata of books matching query, or all books if query is empty.
+// Files are renamed according to tmpl.
+// If dryRun is true, the changes are returned but not made.
+func (lib *Library) CleanupMetadata(query string, rules []CleanupRule, tmpl *template.Template, dryRun bool) ([]CleanupChange, error) {
+	var ids []int64
+	var err error
+	if strings.TrimSpace(query) == "" {
+		ids, err = lib.ListBookIDs(SortByID, false)
+	} else {
+		var results []SearchResult
+		results, _, err = lib.SearchWithOptions(query, SearchOptions{})
+		for _, r := range results {
+			ids = append(ids, r.Book.ID)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "find books")
+	}
+
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	changes, err := lib.cleanupMetadata(tx, ids, rules, tmpl, dryRun)
+	if err != nil || dryRun {
+		tx.Rollback()
+		return changes, err
+	}
+	err = tx.Commit()
+	var changed []int64
+	for _, c := range changes {
+		if c.ConflictID == 0 {
+			changed = append(changed, c.New.ID)
+		}
+	}
+	lib.invalidateBooks(changed...)
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	return changes, nil
+}
+
+func (lib *Library) cleanupMetadata(tx *sql.Tx, ids []int64, rules []CleanupRule, tmpl *template.Template, dryRun bool) ([]CleanupChange, error) {
+	bks, err := getBooksByID(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	var changes []CleanupChange
+	for _, old := range bks {
+		book := copyBook(old)
+		for _, rule := range rules {
+			rule.Apply(&book)
+		}
+		book.Authors = uniqueAuthors(book.Authors)
+		if book.Title == "" || len(book.Authors) == 0 {
+			continue
+		}
+		if book.Title == old.Title && book.Subtitle == old.Subtitle && book.Series == old.Series && book.Publisher == old.Publisher &&
+			stringSlicesEqual(book.Authors, old.Authors, false) {
+			continue
+		}
+		change := CleanupChange{Old: old, New: book}
+		existingID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "find existing book")
+		}
+		if found && existingID != book.ID {
+			change.ConflictID = existingID
+			log.Printf("Not cleaning up book %d: book %d already has title %s and authors %s", book.ID, existingID, book.Title, strings.Join(book.Authors, " & "))
+		} else if !dryRun {
+			if err := lib.updateBook(tx, book, tmpl, true); err != nil {
+				return nil, errors.Wrapf(err, "update book %d", book.ID)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// uniqueAuthors removes empty and duplicate authors, keeping the first of each.
+func uniqueAuthors(authors []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, a := range authors {
+		n := normalizeAuthor(a)
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		result = append(result, a)
+	}
+	return result
+}
+
+// collapseSpace trims s and replaces runs of whitespace in it with single spaces.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func trimMetadata(book *Book) {
+	book.Title = collapseSpace(book.Title)
+	book.Subtitle = collapseSpace(book.Subtitle)
+	book.Series = collapseSpace(book.Series)
+	book.Publisher = collapseSpace(book.Publisher)
+	for i := range book.Authors {
+		book.Authors[i] = collapseSpace(book.Authors[i])
+	}
+}
+
+var formatSuffixRegexp = regexp.MustCompile(`(?i)(?:\s*[(\[{]\s*(?:azw3?|cbr|cbz|djvu|docx?|epub|fb2|html?|lit|mobi|pdf|rtf|txt|retail|ebook|e-book)\s*[)\]}]|\s+-\s*(?:azw3|epub|mobi|pdf))\s*$`)
+
+func stripFormatSuffixes(book *Book) {
+	for {
+		title := formatSuffixRegexp.ReplaceAllString(book.Title, "")
+		if title == book.Title || title == "" {
+			return
+		}
+		book.Title = title
+	}
+}
+
+// SplitSubtitle splits a title such as "Title: Subtitle" into its main title and subtitle, at the first colon followed by a space.
+// If the title has no subtitle, it's returned as is, with an empty subtitle.
+// Titles which start with their series, such as "Discworld 4: Mort", aren't split, since what follows is the book's own title.
+func SplitSubtitle(title, series string) (string, string) {
+	i := strings.Index(title, ": ")
+	if i < 0 {
+		return title, ""
+	}
+	main, subtitle := strings.TrimSpace(title[:i]), strings.TrimSpace(title[i+2:])
+	if main == "" || subtitle == "" {
+		return title, ""
+	}
+	if series != "" && strings.HasPrefix(strings.ToLower(main), strings.ToLower(series)) {
+		return title, ""
+	}
+	return main, subtitle
+}
+
+// splitSubtitle moves the subtitle of a book's title into its subtitle, if it doesn't have one.
+func splitSubtitle(book *Book) {
+	if book.Subtitle != "" {
+		return
+	}
+	book.Title, book.Subtitle = SplitSubtitle(book.Title, book.Series)
+}
+
+// authorSuffixes are parts of names which follow a comma, but aren't a first name.
+var authorSuffixes = map[string]bool{"jr": true, "jr.": true, "sr": true, "sr.": true, "ii": true, "iii": true, "iv": true, "phd": true, "ph.d.": true, "md": true}
+
+func swapAuthorNames(book *Book) {
+	for i, a := range book.Authors {
+		parts := strings.Split(a, ",")
+		if len(parts) != 2 {
+			continue
+		}
+		last, first := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if last == "" || first == "" || authorSuffixes[strings.ToLower(first)] {
+			continue
+		}
+		book.Authors[i] = first + " " + last
+	}
+}
+
+// minorWords aren't capitalized in title case unless they begin or end a title.
+var minorWords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "but": true, "by": true, "for": true, "from": true,
+	"if": true, "in": true, "into": true, "nor": true, "of": true, "on": true, "or": true, "over": true, "per": true,
+	"the": true, "to": true, "via": true, "vs": true, "vs.": true, "with": true,
+}
+
+func titleCaseMetadata(book *Book) {
+	book.Title = TitleCase(book.Title)
+	book.Subtitle = TitleCase(book.Subtitle)
+	book.Series = TitleCase(book.Series)
+}
+
+// TitleCase capitalizes s as a title.
+// Major words are capitalized, and minor words such as "of" and "the" are lowercased unless they begin the title, end it, or follow a colon.
+// Words with capitals after their first letter, such as "iPhone" or "NASA", are left alone, unless the whole title is in capitals.
+func TitleCase(s string) string {
+	if !strings.ContainsAny(s, "abcdefghijklmnopqrstuvwxyz") {
+		s = strings.ToLower(s)
+	}
+	words := strings.Fields(s)
+	for i, w := range words {
+		first := i == 0 || strings.HasSuffix(words[i-1], ":")
+		last := i == len(words)-1
+		core := strings.TrimFunc(w, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		switch {
+		case core == "":
+		case hasInnerCapital(core):
+		case minorWords[strings.ToLower(core)] && !first && !last:
+			words[i] = strings.ToLower(w)
+		default:
+			words[i] = capitalizeWord(w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// hasInnerCapital reports whether s has a capital letter after its first letter.
+func hasInnerCapital(s string) bool {
+	_, size := utf8.DecodeRuneInString(s)
+	return strings.IndexFunc(s[size:], unicode.IsUpper) >= 0
+}
+
+// capitalizeWord capitalizes the first letter of w, and each part of it after a hyphen.
+func capitalizeWord(w string) string {
+	parts := strings.Split(w, "-")
+	for i, p := range parts {
+		for j, r := range p {
+			if unicode.IsLetter(r) {
+				parts[i] = p[:j] + string(unicode.ToUpper(r)) + p[j+utf8.RuneLen(r):]
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "-")
+}
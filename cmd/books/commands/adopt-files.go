@@ -0,0 +1,69 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// adoptFilesCmd represents the adopt-files command
+var adoptFilesCmd = &cobra.Command{
+	Use:   "adopt-files [query]",
+	Short: "Copy files cataloged in place into the books root",
+	Long: `Copy the files cataloged in place with import --in-place, of books matching a search query or of all books if no query is given,
+into the books root, where they're named by the output template like imported files.
+
+Either all of the files are adopted, or none are, such as if one of them changed since it was cataloged.
+The originals are left where they are, unless --move is given, in which case they're removed once all of the files are adopted.`,
+	Run: CPUProfile(adoptFilesRun),
+}
+
+func init() {
+	rootCmd.AddCommand(adoptFilesCmd)
+
+	adoptFilesCmd.Flags().BoolP("move", "m", false, "Remove the originals once the files are adopted")
+}
+
+func adoptFilesRun(cmd *cobra.Command, args []string) {
+	move, err := cmd.Flags().GetBool("move")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	files, err := lib.AdoptFiles(strings.Join(args, " "), outputTmpl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot adopt files: %s\n", err)
+		os.Exit(1)
+	}
+	if move {
+		for _, f := range files {
+			if err := os.Remove(f.Path); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot remove %s: %s\n", f.Path, err)
+			}
+		}
+	}
+	fmt.Printf("Adopted %d files\n", len(files))
+}
@@ -0,0 +1,56 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// akaAddCmd represents the aka add command
+var akaAddCmd = &cobra.Command{
+	Use:   "add <book id> <title...>",
+	Short: "Add another title a book is known by",
+	Long: `Add another title a book is known by, such as: books aka add 12 hhgttg --kind abbreviation
+
+Kinds are subtitle, series, abbreviation and merged, though any kind can be used.
+Adding a title the book already has changes its kind.`,
+	Run: CPUProfile(akaAddRun),
+}
+
+func init() {
+	akaCmd.AddCommand(akaAddCmd)
+	akaAddCmd.Flags().StringP("kind", "k", "", "What sort of title it is, such as subtitle")
+}
+
+func akaAddRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	bookID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid book ID.\n")
+		os.Exit(1)
+	}
+	kind, _ := cmd.Flags().GetString("kind")
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	title := books.AlternateTitle{Title: strings.Join(args[1:], " "), Kind: kind}
+	if err := lib.AddAlternateTitle(bookID, title); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot add alternate title: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// akaRemoveCmd represents the aka remove command
+var akaRemoveCmd = &cobra.Command{
+	Use:   "remove <book id> <title...>",
+	Short: "Remove another title a book is known by",
+	Run:   CPUProfile(akaRemoveRun),
+}
+
+func init() {
+	akaCmd.AddCommand(akaRemoveCmd)
+}
+
+func akaRemoveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	bookID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid book ID.\n")
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.RemoveAlternateTitle(bookID, strings.Join(args[1:], " ")); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot remove alternate title: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// akaCmd represents the aka command
+var akaCmd = &cobra.Command{
+	Use:   "aka <book id>",
+	Short: "List, add and remove the other titles a book is known by",
+	Long: `List the alternate titles of a book, such as its subtitle, its title in its series' reading order, or an abbreviation.
+
+Alternate titles are searched along with titles, so a book can be found under any of its common names,
+and importing a book under one of them adds it to the book that has it, rather than creating another.
+When books are merged, the titles of the merged books become alternate titles of the book they're merged into.`,
+	Run: CPUProfile(akaRun),
+}
+
+func init() {
+	rootCmd.AddCommand(akaCmd)
+}
+
+func akaRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	bookID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid book ID.\n")
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	found, err := lib.GetBooksByID([]int64{bookID})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get book: %s\n", err)
+		os.Exit(1)
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(os.Stderr, "Book not found.\n")
+		os.Exit(1)
+	}
+	for _, t := range found[0].AlternateTitles {
+		if t.Kind != "" {
+			fmt.Printf("%s (%s)\n", t.Title, t.Kind)
+		} else {
+			fmt.Println(t.Title)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsAddCmd represents the authors add command
+var authorsAddCmd = &cobra.Command{
+	Use:   "add <name...>",
+	Short: "Add an author with the same name as another",
+	Long: `Add a record for a different person with the same name as an existing author, told apart by a disambiguation note.
+
+For example, to move the books by the poet John Smith away from the other John Smith:
+books authors add John Smith --disambiguation poet --external-id viaf:12345
+books authors reassign <old author id> <new author id> <book id>...`,
+	Run: CPUProfile(authorsAddRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsAddCmd)
+
+	authorsAddCmd.Flags().StringP("disambiguation", "d", "", "Note telling the author apart from others with the same name")
+	authorsAddCmd.Flags().StringP("external-id", "x", "", "ID of the author in an authority file or catalog, such as viaf:12345 or wikidata:Q42")
+}
+
+func authorsAddRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	disambiguation, _ := cmd.Flags().GetString("disambiguation")
+	externalID, _ := cmd.Flags().GetString("external-id")
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	id, err := lib.AddAuthor(books.Author{Name: strings.Join(args, " "), Disambiguation: disambiguation, ExternalID: externalID})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot add author: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Added author %d\n", id)
+}
@@ -0,0 +1,72 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsCoAuthorsCmd represents the authors coauthors command
+var authorsCoAuthorsCmd = &cobra.Command{
+	Use:   "coauthors <name...>",
+	Short: "Show who an author wrote books with",
+	Long: `Show the authors who wrote books with the authors with a name, and how many books they wrote together,
+those who wrote the most first.`,
+	Run: CPUProfile(authorsCoAuthorsRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsCoAuthorsCmd)
+}
+
+func authorsCoAuthorsRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	name := strings.Join(args, " ")
+	authors, err := lib.AuthorsNamed(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get authors: %s\n", err)
+		os.Exit(1)
+	}
+	if len(authors) == 0 {
+		fmt.Fprintf(os.Stderr, "No authors are named %s.\n", name)
+		os.Exit(1)
+	}
+	for _, a := range authors {
+		coAuthors, err := lib.CoAuthors(a.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get co-authors: %s\n", err)
+			os.Exit(1)
+		}
+		if len(authors) > 1 {
+			fmt.Printf("%d: %s\n", a.ID, a)
+		}
+		if len(coAuthors) == 0 {
+			fmt.Println("No co-authors")
+		}
+		for _, c := range coAuthors {
+			fmt.Printf("%d: %s", c.ID, books.Author{Name: c.Name, Disambiguation: c.Disambiguation})
+			if len(c.BookIDs) == 1 {
+				fmt.Println(" (1 book)")
+			} else {
+				fmt.Printf(" (%d books)\n", len(c.BookIDs))
+			}
+		}
+	}
+}
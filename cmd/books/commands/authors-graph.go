@@ -0,0 +1,62 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsGraphCmd represents the authors graph command
+var authorsGraphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Write a graph of which authors wrote books together",
+	Long: `Write a graph of the authors who wrote books together to standard output, in the DOT language, or as JSON with --json.
+
+The DOT graph can be drawn with Graphviz, such as with: books authors graph | neato -Tsvg > authors.svg
+Variants of one author's name can sometimes be spotted as authors who share co-authors, but never wrote anything together.`,
+	Run: CPUProfile(authorsGraphRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsGraphCmd)
+
+	authorsGraphCmd.Flags().Bool("json", false, "Write the graph as JSON")
+}
+
+func authorsGraphRun(cmd *cobra.Command, args []string) {
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	g, err := lib.AuthorGraph()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get author graph: %s\n", err)
+		os.Exit(1)
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(g)
+	} else {
+		err = g.WriteDOT(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write author graph: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// authorsJoinCmd represents the authors join command
+var authorsJoinCmd = &cobra.Command{
+	Use:   "join <name> <author> <author>...",
+	Short: "Join authors which were wrongly split",
+	Long: `Replace authors which were wrongly split from one name with a single author.
+
+Only books with all of the given authors are changed.
+For example, to fix "Smith, John" having been imported as the authors "Smith" and "John":
+books authors join "John Smith" Smith John`,
+	Run: CPUProfile(authorsJoinRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsJoinCmd)
+
+	authorsJoinCmd.Flags().Bool("dry-run", false, "Show the changes which would be made, without making them")
+}
+
+func authorsJoinRun(cmd *cobra.Command, args []string) {
+	if len(args) < 3 {
+		fmt.Fprintln(os.Stderr, "A name and at least two authors to join must be specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lib, tmpl := openAuthorsLibrary()
+	defer lib.Close()
+
+	change, err := lib.JoinAuthors(args[1:], args[0], tmpl, dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error joining authors: %s\n", err)
+		os.Exit(1)
+	}
+	printAuthorChange(change, dryRun)
+}
@@ -0,0 +1,57 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsReassignCmd represents the authors reassign command
+var authorsReassignCmd = &cobra.Command{
+	Use:   "reassign <from author id> <to author id> [book id...]",
+	Short: "Move books between authors with the same name",
+	Long: `Link books to another author with the same name, such as one added with books authors add.
+
+If no books are given, all of the first author's books are moved.`,
+	Run: CPUProfile(authorsReassignRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsReassignCmd)
+}
+
+func authorsReassignRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	ids := make([]int64, len(args))
+	for i, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", arg)
+			os.Exit(1)
+		}
+		ids[i] = id
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	moved, err := lib.ReassignBooks(ids[0], ids[1], ids[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot reassign books: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Moved %d books from author %d to author %d\n", len(moved), ids[0], ids[1])
+}
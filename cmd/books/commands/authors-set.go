@@ -0,0 +1,65 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsSetCmd represents the authors set command
+var authorsSetCmd = &cobra.Command{
+	Use:   "set <author id>",
+	Short: "Set the disambiguation note and external ID of an author",
+	Long: `Set the disambiguation note and external ID of an author, shown by books authors show.
+
+Only the given flags are changed. Give an empty value to remove one.`,
+	Run: CPUProfile(authorsSetRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsSetCmd)
+
+	authorsSetCmd.Flags().StringP("disambiguation", "d", "", "Note telling the author apart from others with the same name")
+	authorsSetCmd.Flags().StringP("external-id", "x", "", "ID of the author in an authority file or catalog, such as viaf:12345 or wikidata:Q42")
+}
+
+func authorsSetRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	authorID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid author ID.\n")
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	author, err := lib.GetAuthor(authorID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get author: %s\n", err)
+		os.Exit(1)
+	}
+	if cmd.Flags().Changed("disambiguation") {
+		author.Disambiguation, _ = cmd.Flags().GetString("disambiguation")
+	}
+	if cmd.Flags().Changed("external-id") {
+		author.ExternalID, _ = cmd.Flags().GetString("external-id")
+	}
+	if err := lib.UpdateAuthor(author); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot update author: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// authorsShowCmd represents the authors show command
+var authorsShowCmd = &cobra.Command{
+	Use:   "show <name...>",
+	Short: "Show the authors with a name",
+	Long: `Show the author records with a name, with their IDs, disambiguation notes, external IDs and books.
+
+Different people with the same name have separate records, added with books authors add.`,
+	Run: CPUProfile(authorsShowRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsShowCmd)
+}
+
+func authorsShowRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	name := strings.Join(args, " ")
+	authors, err := lib.AuthorsNamed(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get authors: %s\n", err)
+		os.Exit(1)
+	}
+	if len(authors) == 0 {
+		fmt.Fprintf(os.Stderr, "No authors are named %s.\n", name)
+		os.Exit(1)
+	}
+	for _, a := range authors {
+		fmt.Printf("%d: %s\n", a.ID, a)
+		if a.ExternalID != "" {
+			fmt.Printf("External ID: %s\n", a.ExternalID)
+		}
+		bks, err := lib.GetBooksByID(a.BookIDs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get books: %s\n", err)
+			os.Exit(1)
+		}
+		for _, b := range bks {
+			fmt.Printf("  %d: %s\n", b.ID, b.Title)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// authorsSplitCmd represents the authors split command
+var authorsSplitCmd = &cobra.Command{
+	Use:   "split [author...]",
+	Short: "Split authors combined into one name",
+	Long: `Split authors whose names combine several authors, such as "A & B; C", into separate authors.
+
+If no authors are given, every author whose name contains & or ; is split.`,
+	Run: CPUProfile(authorsSplitRun),
+}
+
+func init() {
+	authorsCmd.AddCommand(authorsSplitCmd)
+
+	authorsSplitCmd.Flags().Bool("dry-run", false, "Show the changes which would be made, without making them")
+}
+
+func authorsSplitRun(cmd *cobra.Command, args []string) {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lib, tmpl := openAuthorsLibrary()
+	defer lib.Close()
+
+	changes, err := lib.SplitAuthors(args, tmpl, dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error splitting authors: %s\n", err)
+		os.Exit(1)
+	}
+	for _, c := range changes {
+		printAuthorChange(c, dryRun)
+	}
+	if len(changes) == 0 {
+		fmt.Println("No authors to split")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// authorsCmd represents the authors command
+var authorsCmd = &cobra.Command{
+	Use:   "authors",
+	Short: "Split, join and disambiguate authors",
+	Long: `Fix authors which were combined into one name, or wrongly split into several.
+Books which end up with the same title and authors as another book are merged into it.
+
+Different people with the same name can be told apart with disambiguation notes and external IDs,
+and their books moved between them.`,
+}
+
+func init() {
+	rootCmd.AddCommand(authorsCmd)
+}
+
+// openAuthorsLibrary opens the library and parses the output template, for changing authors.
+func openAuthorsLibrary() (*books.Library, *template.Template) {
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	return lib, outputTmpl
+}
+
+// printAuthorChange prints a change to authors, made or to be made.
+func printAuthorChange(c books.AuthorChange, dryRun bool) {
+	verb := "Changed"
+	if dryRun {
+		verb = "Would change"
+	}
+	fmt.Printf("%s %s to %s in %d books", verb, strings.Join(c.Old, " & "), strings.Join(c.New, " & "), len(c.BookIDs))
+	if len(c.MergedIDs) > 0 {
+		fmt.Printf(", merging %d into existing books", len(c.MergedIDs))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,92 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+	"github.com/tspivey/books/backup"
+)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Export snapshots of the library to a remote",
+	Long: `Export a snapshot of the library's metadata to a remote, then keep exporting them on a schedule.
+
+Snapshots are gzipped JSON lines files, with one book on each line, uploaded to the snapshots directory of the remote.
+With backup.files, the library's files which aren't on the remote yet are uploaded to its files directory, named by their hashes.
+
+Configure the backup in the config file with:
+remote: an rclone remote and path, such as s3:bucket/books or webdav:books, or a local directory, such as a mounted drive.
+rclone_args: extra arguments given to rclone, such as ["--config", "/path/to/rclone.conf"].
+files: whether to upload files as well as metadata.
+keep: the number of snapshots to keep, and max_age_days: the number of days to keep them.
+interval: the number of minutes between snapshots, default 1440.`,
+	Run: CPUProfile(backupRun),
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+
+	backupCmd.Flags().Bool("once", false, "Export one snapshot, instead of exporting them on a schedule")
+	backupCmd.Flags().String("remote", "", "Remote to export to, instead of backup.remote")
+	viper.BindPFlag("backup.remote", backupCmd.Flags().Lookup("remote"))
+	viper.SetDefault("backup.interval", 1440)
+}
+
+func backupRun(cmd *cobra.Command, args []string) {
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	remote, err := backup.ParseRemote(viper.GetString("backup.remote"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot use backup.remote: %s\n", err)
+		os.Exit(1)
+	}
+	if r, ok := remote.(backup.RcloneRemote); ok {
+		r.Args = viper.GetStringSlice("backup.rclone_args")
+		remote = r
+	}
+	interval := viper.GetDuration("backup.interval") * time.Minute
+
+	library, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	exporter := &backup.Exporter{
+		Lib:    library,
+		Remote: remote,
+		Files:  viper.GetBool("backup.files"),
+		Keep:   viper.GetInt("backup.keep"),
+		MaxAge: time.Duration(viper.GetInt("backup.max_age_days")) * 24 * time.Hour,
+	}
+	for {
+		res, err := exporter.Export()
+		if err != nil {
+			log.Printf("Error exporting snapshot: %s", err)
+			if once {
+				os.Exit(1)
+			}
+		} else if once {
+			fmt.Printf("Exported %d books to %s, uploaded %d files, and removed %d old snapshots\n", res.Books, res.Snapshot, res.FilesUploaded, len(res.Expired))
+		}
+		if once {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// citeCmd represents the cite command
+var citeCmd = &cobra.Command{
+	Use:   "cite [query]",
+	Short: "Export citations of books",
+	Long: `Print citations of books matching a search query, or all books if no query is given.
+
+Citations are in BibTeX format by default. Use --format ris for RIS, which most reference managers can import.`,
+	Run: CPUProfile(citeRun),
+}
+
+func init() {
+	rootCmd.AddCommand(citeCmd)
+
+	citeCmd.Flags().StringP("format", "f", string(books.BibTeX), "Citation format: bibtex or ris")
+}
+
+func citeRun(cmd *cobra.Command, args []string) {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	citations, err := lib.ExportCitations(strings.Join(args, " "), books.CitationFormat(strings.ToLower(format)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting citations: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(citations)
+}
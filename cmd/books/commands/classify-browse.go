@@ -0,0 +1,76 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// classifyBrowseCmd represents the classify browse command
+var classifyBrowseCmd = &cobra.Command{
+	Use:   "browse <scheme> [class]",
+	Short: "Browse books by classification",
+	Long: `List the classes in a scheme, ddc or lcc, which have books in them.
+
+If a class is given, the classes under it are listed, followed by the books in it.`,
+	Run: CPUProfile(classifyBrowseRun),
+}
+
+func init() {
+	classifyCmd.AddCommand(classifyBrowseCmd)
+}
+
+func classifyBrowseRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "A scheme must be specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	parent := ""
+	if len(args) > 1 {
+		parent = args[1]
+	}
+	lib := openClassifyLibrary()
+	defer lib.Close()
+
+	nodes, err := lib.ClassificationTree(args[0], parent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error browsing classifications: %s\n", err)
+		os.Exit(1)
+	}
+	for _, node := range nodes {
+		fmt.Print(node.Number)
+		if node.Caption != "" {
+			fmt.Printf(" %s", node.Caption)
+		}
+		fmt.Printf(" (%d books)\n", node.Books)
+	}
+	if parent == "" {
+		return
+	}
+	ids, err := lib.ClassifiedBookIDs(args[0], parent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting books: %s\n", err)
+		os.Exit(1)
+	}
+	bks, err := lib.GetBooksByID(ids)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting books: %s\n", err)
+		os.Exit(1)
+	}
+	for _, book := range bks {
+		number := ""
+		for _, c := range book.Classifications {
+			if c.Scheme == strings.ToLower(args[0]) {
+				number = c.Number
+			}
+		}
+		fmt.Printf("%d: %s %s\n", book.ID, number, describeBook(book))
+	}
+}
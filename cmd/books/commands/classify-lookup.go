@@ -0,0 +1,68 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+	"github.com/tspivey/books/openlibrary"
+)
+
+// classifyLookupCmd represents the classify lookup command
+var classifyLookupCmd = &cobra.Command{
+	Use:   "lookup [query]",
+	Short: "Look up classifications of books from Open Library",
+	Long: `Look up the Dewey and LCC classifications of books matching a search query, or all books if no query is given.
+
+Books are looked up by their ISBNs, so books without ISBNs can't be classified.
+Existing classifications are kept.
+The Open Library URL can be changed with openlibrary_url in the config file.`,
+	Run: CPUProfile(classifyLookupRun),
+}
+
+func init() {
+	classifyCmd.AddCommand(classifyLookupCmd)
+
+	viper.SetDefault("openlibrary_url", openlibrary.DefaultURL)
+}
+
+func classifyLookupRun(cmd *cobra.Command, args []string) {
+	lib := openClassifyLibrary()
+	defer lib.Close()
+
+	var ids []int64
+	var err error
+	if len(args) == 0 {
+		ids, err = lib.ListBookIDs(books.SortByID, false)
+	} else {
+		var results []books.SearchResult
+		results, _, err = lib.SearchWithOptions(strings.Join(args, " "), books.SearchOptions{})
+		for _, r := range results {
+			ids = append(ids, r.Book.ID)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding books: %s\n", err)
+		os.Exit(1)
+	}
+
+	classifier := &openlibrary.Classifier{
+		URL:    viper.GetString("openlibrary_url"),
+		Client: &http.Client{Timeout: time.Minute},
+	}
+	n, err := lib.ClassifyBooks(ids, classifier)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error classifying books: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d books classified\n", n)
+}
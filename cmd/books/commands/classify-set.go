@@ -0,0 +1,53 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// classifySetCmd represents the classify set command
+var classifySetCmd = &cobra.Command{
+	Use:   "set <book ID> <scheme> [number]",
+	Short: "Set the classification of a book",
+	Long: `Set the classification of a book in a scheme, ddc or lcc.
+
+If no number is given, the book's classification in the scheme is removed.
+For example:
+books classify set 12 ddc 823.914`,
+	Run: CPUProfile(classifySetRun),
+}
+
+func init() {
+	classifyCmd.AddCommand(classifySetCmd)
+}
+
+func classifySetRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "A book ID and scheme must be specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	bookID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
+		os.Exit(1)
+	}
+	number := ""
+	if len(args) > 2 {
+		number = args[2]
+	}
+	lib := openClassifyLibrary()
+	defer lib.Close()
+
+	if err := lib.SetClassification(bookID, args[1], number); err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting classification: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// classifyCmd represents the classify command
+var classifyCmd = &cobra.Command{
+	Use:   "classify",
+	Short: "Manage Dewey and LCC classifications of books",
+	Long: `Set, look up, and browse the classifications of books in library classification schemes.
+
+The supported schemes are ddc (Dewey Decimal Classification) and lcc (Library of Congress Classification).`,
+}
+
+func init() {
+	rootCmd.AddCommand(classifyCmd)
+}
+
+// openClassifyLibrary opens the library, for changing classifications.
+func openClassifyLibrary() *books.Library {
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	return lib
+}
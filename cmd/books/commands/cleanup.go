@@ -0,0 +1,115 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// cleanupCmd represents the cleanup command
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup [query]",
+	Short: "Clean up book metadata",
+	Long: `Normalize the metadata of books matching a search query, or all books if no query is given.
+
+By default, all rules are applied. Use --rules to choose which, from:
+` + cleanupRulesHelp() + `
+Use --dry-run to see the changes without making them.`,
+	Run: CPUProfile(cleanupRun),
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.Flags().Bool("dry-run", false, "Show the changes which would be made, without making them")
+	cleanupCmd.Flags().StringSlice("rules", nil, "Comma-separated cleanup rules to apply")
+}
+
+func cleanupRulesHelp() string {
+	var s string
+	for _, rule := range books.CleanupRules {
+		s += fmt.Sprintf("  %s: %s\n", rule.Name, rule.Description)
+	}
+	return s
+}
+
+func cleanupRun(cmd *cobra.Command, args []string) {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	names, err := cmd.Flags().GetStringSlice("rules")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	rules := books.CleanupRules
+	if len(names) > 0 {
+		rules = nil
+		for _, name := range names {
+			rule, ok := books.LookupCleanupRule(name)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Unknown cleanup rule: %s\n", name)
+				os.Exit(1)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	changes, err := lib.CleanupMetadata(strings.Join(args, " "), rules, outputTmpl, dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error cleaning up metadata: %s\n", err)
+		os.Exit(1)
+	}
+	made := 0
+	for _, c := range changes {
+		fmt.Printf("Book %d: %s -> %s", c.New.ID, describeBook(c.Old), describeBook(c.New))
+		if c.ConflictID != 0 {
+			fmt.Printf(" (skipped: same as book %d)", c.ConflictID)
+		} else {
+			made++
+		}
+		fmt.Println()
+	}
+	if dryRun {
+		fmt.Printf("%d books would be changed\n", made)
+	} else {
+		fmt.Printf("%d books changed\n", made)
+	}
+}
+
+// describeBook describes a book's metadata in one line.
+func describeBook(book books.Book) string {
+	s := strings.Join(book.Authors, " & ") + " - "
+	if book.Series != "" {
+		s += "[" + book.Series + "] - "
+	}
+	s += book.Title
+	if book.Subtitle != "" {
+		s += ": " + book.Subtitle
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// compactCmd represents the compact command
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Remove unused records and files from the library",
+	Long: `Remove authors with no books, tags with no books or files, search results for books that no longer exist,
+empty directories in the books root, cached covers, conversions and previews of files no longer in the library,
+and scan cache entries for files that no longer exist, then vacuum the database.`,
+	Run: CPUProfile(compactRun),
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+}
+
+func compactRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	report, err := lib.Compact()
+	for _, a := range report.Authors {
+		fmt.Printf("Removed author: %s\n", a)
+	}
+	for _, t := range report.Tags {
+		fmt.Printf("Removed tag: %s\n", t)
+	}
+	for _, id := range report.SearchEntries {
+		fmt.Printf("Removed search entry for book %d\n", id)
+	}
+	for _, d := range report.Directories {
+		fmt.Printf("Removed directory: %s\n", d)
+	}
+	for _, f := range report.CacheFiles {
+		fmt.Printf("Removed cache file: %s\n", f)
+	}
+	if report.ScanCacheEntries > 0 {
+		fmt.Printf("Removed %d scan cache entries\n", report.ScanCacheEntries)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot compact library: %s\n", err)
+		os.Exit(1)
+	}
+	if report.Empty() {
+		fmt.Println("Nothing to clean up.")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// contributorsCmd represents the contributors command
+var contributorsCmd = &cobra.Command{
+	Use:   "contributors <role> [name]",
+	Short: "List contributors in a role, or the books they worked on",
+	Long: `List everyone with a role in at least one book.
+Roles are author, editor, translator, narrator, and illustrator.
+
+If a name is given, the books they worked on in that role are listed instead.`,
+	Run: CPUProfile(contributorsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(contributorsCmd)
+}
+
+func contributorsRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if len(args) == 1 {
+		names, err := lib.Contributors(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting contributors: %s\n", err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return
+	}
+	ids, err := lib.ContributorBookIDs(args[1], args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting books: %s\n", err)
+		os.Exit(1)
+	}
+	bks, err := lib.GetBooksByID(ids)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting books: %s\n", err)
+		os.Exit(1)
+	}
+	for _, book := range bks {
+		fmt.Printf("%d: %s\n", book.ID, describeBook(book))
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// correctionsCmd represents the corrections command
+var correctionsCmd = &cobra.Command{
+	Use:   "corrections <file.csv>",
+	Short: "Apply metadata corrections from a CSV file",
+	Long: `Apply corrections to the metadata of books from a CSV file, or standard input if the file is -.
+
+Each row has three columns: a book ID or the hash of one of its files, a field, and its new value.
+A header row, starting with book_id, id or hash, is skipped. The fields are:
+  ` + strings.Join(books.CorrectionFields, ", ") + `
+Authors are separated by & or ;, and tags by commas. file_tags changes the tags of the file with the hash in the first column.
+
+All of the corrections are checked before any are applied, and if any row is invalid, nothing is changed.
+Use --dry-run to check the corrections without applying them.`,
+	Run: CPUProfile(correctionsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(correctionsCmd)
+	correctionsCmd.Flags().Bool("dry-run", false, "Check the corrections without applying them")
+}
+
+func correctionsRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: books corrections <file.csv>")
+		os.Exit(1)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open corrections: %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	report, err := lib.ApplyCorrections(r, outputTmpl, dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot apply corrections: %s\n", err)
+		os.Exit(1)
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(report.Errors) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d corrections are invalid, so none were applied.\n", len(report.Errors), report.Corrections)
+		os.Exit(1)
+	}
+	if dryRun {
+		fmt.Printf("%d corrections are valid, and would change %d books.\n", report.Corrections, len(report.Books))
+	} else {
+		fmt.Printf("Applied %d corrections to %d books.\n", report.Corrections, len(report.Books))
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// decadesCmd represents the decades command
+var decadesCmd = &cobra.Command{
+	Use:   "decades",
+	Short: "Count the books published in each decade",
+	Long: `Count the books in the library by the decade they were published in, and show how many have no publication date.
+
+Books of a decade can be found by searching with a year range, such as year:1990..1999.`,
+	Run: CPUProfile(decadesRun),
+}
+
+func init() {
+	rootCmd.AddCommand(decadesCmd)
+}
+
+func decadesRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	decades, undated, err := lib.PublicationDecades()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot count books by decade: %s\n", err)
+		os.Exit(1)
+	}
+	for _, d := range decades {
+		fmt.Printf("%ds: %d\n", d.Decade, d.Books)
+	}
+	if undated > 0 {
+		fmt.Printf("No publication date: %d\n", undated)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// deleteCmd represents the delete command
+var deleteCmd = &cobra.Command{
+	Use:   "delete ID...",
+	Short: "Delete books or files from the library",
+	Long: `Delete books, with all of their files, from the library.
+
+With --file, the IDs are file IDs, and only those files are deleted, leaving their books in the library.
+Files are removed from the books root too, unless another file in the library has the same contents.
+Use show to find book and file IDs.`,
+	Run: CPUProfile(deleteRun),
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().Bool("file", false, "Delete files, rather than books")
+}
+
+func deleteRun(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	var ids []int64
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid ID: %s\n", arg)
+			os.Exit(1)
+		}
+		ids = append(ids, id)
+	}
+	files, _ := cmd.Flags().GetBool("file")
+
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	failed := false
+	for _, id := range ids {
+		if files {
+			err = lib.DeleteFile(id)
+		} else {
+			err = lib.DeleteBook(id)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot delete %d: %s\n", id, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
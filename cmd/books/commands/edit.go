@@ -52,7 +52,7 @@ func editFunc(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	library, err := books.OpenLibrary(libraryFile, booksRoot)
+	library, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening library: %s\n", err)
 		os.Exit(1)
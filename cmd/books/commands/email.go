@@ -0,0 +1,91 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+	"github.com/tspivey/books/email"
+)
+
+// emailCmd represents the email command
+var emailCmd = &cobra.Command{
+	Use:   "email",
+	Short: "Import books attached to email",
+	Long: `Watch an IMAP mailbox, and import books attached to new messages.
+
+Each unseen message's attachments are imported as if by the import command, with the source email:<sender>.
+The message is then moved to the archive mailbox, or marked as seen if there isn't one.
+If the server supports neither MOVE nor UIDPLUS, the original is left flagged as deleted, for your mail client to expunge.
+
+The mailbox is configured in the email section of the config file:
+addr (host:port), username, password, mailbox (default INBOX), archive_mailbox,
+tls (default true), extensions, and interval (seconds between checks, default 300).`,
+	Run: CPUProfile(emailRun),
+}
+
+func init() {
+	rootCmd.AddCommand(emailCmd)
+
+	emailCmd.Flags().Bool("once", false, "Check the mailbox once, instead of watching it")
+	viper.SetDefault("email.tls", true)
+	viper.SetDefault("email.interval", 300)
+}
+
+func emailRun(cmd *cobra.Command, args []string) {
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg := email.Config{
+		Addr:           viper.GetString("email.addr"),
+		Username:       viper.GetString("email.username"),
+		Password:       viper.GetString("email.password"),
+		TLS:            viper.GetBool("email.tls"),
+		Mailbox:        viper.GetString("email.mailbox"),
+		ArchiveMailbox: viper.GetString("email.archive_mailbox"),
+		Extensions:     viper.GetStringSlice("email.extensions"),
+	}
+	if cfg.Addr == "" {
+		fmt.Fprintln(os.Stderr, "email.addr must be set in the configuration file.")
+		os.Exit(1)
+	}
+	interval := viper.GetDuration("email.interval") * time.Second
+
+	setupImport()
+	throttleImports()
+	library, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	for {
+		stats, err := email.Poll(cfg, func(filename, source string) error {
+			log.Printf("Importing file %s from %s:\n", filename, source)
+			return importBook(filename, source, library)
+		})
+		if err != nil {
+			log.Printf("Error checking mailbox: %s", err)
+		} else if stats.Messages > 0 {
+			log.Printf("Checked %d messages: %d attachments, %d imported, %d errors", stats.Messages, stats.Attachments, stats.Imported, stats.Errors)
+		}
+		if once {
+			if err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the library for consistency",
+	Long: `Check the library for inconsistencies, such as damage to the library file, rows left behind by deleted books,
+search results for books that no longer exist or are out of date, files missing from or changed in the books root,
+files in the books root that aren't in the library, books without files,
+or books with the same title and authors that were imported as separate books.
+Use --verify-hashes to also hash every file, to find files changed without their size changing.
+
+Use --repair to fix any problems found. Missing files are removed from the library,
+books without files are deleted, files that aren't in the library are moved to the trash,
+and the search index is rebuilt if it has any problems.
+Use --only with a comma separated list of kinds to only repair some problems.
+The kinds are missing-file, size-mismatch, hash-mismatch, orphan-file, empty-book,
+dangling-row, search-ghost, search-missing and search-stale.`,
+	Run: CPUProfile(fsckRun),
+}
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+
+	fsckCmd.Flags().Bool("repair", false, "Repair any problems found")
+	fsckCmd.Flags().Bool("verify-hashes", false, "Hash every file to check that its contents haven't changed")
+	fsckCmd.Flags().StringSlice("only", nil, "With --repair, only repair problems of these kinds")
+}
+
+// isProblemKind returns true if kind is one of the kinds of problems found by fsck.
+func isProblemKind(kind string) bool {
+	for _, k := range books.ProblemKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func fsckRun(cmd *cobra.Command, args []string) {
+	repair, err := cmd.Flags().GetBool("repair")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	verifyHashes, err := cmd.Flags().GetBool("verify-hashes")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	only, err := cmd.Flags().GetStringSlice("only")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, kind := range only {
+		if !isProblemKind(kind) {
+			fmt.Fprintf(os.Stderr, "Unknown kind of problem: %s\nKinds are: %s\n", kind, strings.Join(books.ProblemKinds, ", "))
+			os.Exit(1)
+		}
+	}
+	// Problems not found by Check are only repaired when every kind of problem is.
+	repairAll := repair && len(only) == 0
+	var outputTmpl *template.Template
+	if repair {
+		outputTmplSrc := viper.GetString("output_template")
+		outputTmpl, err = template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+			os.Exit(1)
+		}
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.CheckIntegrity(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking library file: %s\n", err)
+		os.Exit(1)
+	}
+
+	report, err := lib.Check(books.CheckOptions{VerifyHashes: verifyHashes, Progress: progressFunc()})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking library: %s\n", err)
+		os.Exit(1)
+	}
+	reported := make(map[int64]bool)
+	for _, p := range report.Problems {
+		fmt.Println(p)
+		if p.FileID != 0 {
+			reported[p.FileID] = true
+		}
+	}
+	if repair {
+		problems := report.Problems
+		if len(only) > 0 {
+			problems = report.OfKind(only...)
+		}
+		repaired, err := lib.Repair(problems, books.RepairOptions{Parser: &books.EpubMetadataParser{}, Template: outputTmpl})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error repairing library: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Repaired %d problems.\n", len(repaired))
+	}
+	// Missing files are flagged, so they can be annotated in search results.
+	if _, err := lib.CheckMissingFiles(nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking for missing files: %s\n", err)
+		os.Exit(1)
+	}
+
+	changed, err := lib.FindChangedFiles(progressFunc())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking for changed files: %s\n", err)
+		os.Exit(1)
+	}
+	for _, f := range changed {
+		if !reported[f.ID] {
+			fmt.Printf("File %d was changed outside the library: %s\n", f.ID, f.CurrentFilename)
+		}
+		if repairAll {
+			if err := lib.AdoptChangedFile(f.ID, &books.EpubMetadataParser{}, outputTmpl); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot update file %d: %s\n", f.ID, err)
+			}
+		}
+	}
+
+	var splitBooks [][]int64
+	if repairAll {
+		splitBooks, err = lib.MergeSplitBooks(outputTmpl)
+	} else {
+		splitBooks, err = lib.FindSplitBooks()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking for split books: %s\n", err)
+		os.Exit(1)
+	}
+	for _, ids := range splitBooks {
+		fmt.Printf("Books %v have the same title and authors\n", ids)
+	}
+
+	if report.Empty() && len(changed) == 0 && len(splitBooks) == 0 {
+		fmt.Println("No problems found.")
+	} else if !repair {
+		fmt.Println("Run with --repair to fix these problems.")
+	}
+}
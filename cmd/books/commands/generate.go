@@ -0,0 +1,155 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// generateCmd represents the generate command
+var generateCmd = &cobra.Command{
+	Use:   "generate DIR NUM_BOOKS",
+	Short: "Generate a synthetic library for benchmarking",
+	Long: `Generate a new library in DIR, containing NUM_BOOKS books with made up metadata,
+and report how long importing, searching, and listing the books took.
+
+The library is created in DIR/books.db, with its books root in DIR/root.
+Unless --stub-files is given, no files are written to the books root.`,
+	Args: cobra.ExactArgs(2),
+	Run:  CPUProfile(generateRun),
+}
+
+func init() {
+	rootCmd.AddCommand(generateCmd)
+
+	generateCmd.Flags().Bool("stub-files", false, "Write a small file to the books root for each generated file")
+	generateCmd.Flags().Int("max-files", 3, "Maximum number of files for each book")
+	generateCmd.Flags().Int64("seed", 1, "Seed for the random generator")
+	generateCmd.Flags().StringSlice("search", []string{"shadow", "author:patel", "series:chronicles", "night river"}, "Searches to time")
+}
+
+func generateRun(cmd *cobra.Command, args []string) {
+	dir := args[0]
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid number of books: %s\n", args[1])
+		os.Exit(1)
+	}
+	stubFiles, _ := cmd.Flags().GetBool("stub-files")
+	maxFiles, _ := cmd.Flags().GetInt("max-files")
+	seed, _ := cmd.Flags().GetInt64("seed")
+	searches, _ := cmd.Flags().GetStringSlice("search")
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	libFile := filepath.Join(dir, "books.db")
+	if _, err := os.Stat(libFile); err == nil {
+		fmt.Fprintf(os.Stderr, "A library already exists in %s.\n", libFile)
+		os.Exit(1)
+	}
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create books root: %s\n", err)
+		os.Exit(1)
+	}
+	if err := books.CreateLibrary(libFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create library: %s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libFile, root, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	// Each import logs a line, which would swamp the results and slow down generation.
+	log.SetOutput(ioutil.Discard)
+	start := time.Now()
+	err = lib.GenerateTestLibrary(n, books.GenerateOptions{
+		Template:        outputTmpl,
+		StubFiles:       stubFiles,
+		MaxFilesPerBook: maxFiles,
+		Seed:            seed,
+		Progress:        progressFunc(),
+	})
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating library: %s\n", err)
+		os.Exit(1)
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Generated %d books in %s (%s per book)\n", n, elapsed, perItem(elapsed, n))
+
+	for _, terms := range searches {
+		start = time.Now()
+		results, err := lib.Search(terms)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error searching for %s: %s\n", terms, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Search for %q: %d results in %s\n", terms, len(results), time.Since(start))
+	}
+
+	var ids []int64
+	rows, err := lib.Query("select id from books order by id")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing books: %s\n", err)
+		os.Exit(1)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing books: %s\n", err)
+			os.Exit(1)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing books: %s\n", err)
+		os.Exit(1)
+	}
+	rows.Close()
+	pageSize := viper.GetInt("server.items_per_page")
+	start = time.Now()
+	pages := 0
+	for i := 0; i < len(ids); i += pageSize {
+		end := i + pageSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if _, err := lib.GetBooksByID(ids[i:end]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing books: %s\n", err)
+			os.Exit(1)
+		}
+		pages++
+	}
+	elapsed = time.Since(start)
+	fmt.Printf("Listed %d books in %d pages of %d in %s (%s per page)\n", len(ids), pages, pageSize, elapsed, perItem(elapsed, pages))
+}
+
+// perItem divides a duration evenly between n items.
+func perItem(d time.Duration, n int) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return d / time.Duration(n)
+}
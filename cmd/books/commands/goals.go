@@ -0,0 +1,142 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// goalsCmd represents the goals command
+var goalsCmd = &cobra.Command{
+	Use:   "goals <user> [year] [books|pages <target>]",
+	Short: "Show or set reading goals",
+	Long: `Show a sync user's reading goals for a year, and how close they are to them.
+The year defaults to the current year.
+
+Set the number of books or pages the user wants to read in a year with:
+    goals <user> <year> books <target>
+    goals <user> <year> pages <target>
+A target of 0 removes the goal.
+A book counts once it's finished, and pages are counted from books' page counts, which are set with books edit.`,
+	Run: CPUProfile(goalsRun),
+}
+
+// yearInReviewCmd represents the year-in-review command
+var yearInReviewCmd = &cobra.Command{
+	Use:   "year-in-review [year]",
+	Short: "Summarize the books read in a year",
+	Long: `Summarize the books finished in a year: how many, their pages, and the most read authors and genres.
+Genres are the tags of the books and their files.
+The year defaults to the current year.
+With --user, only the books finished by that sync user are counted.`,
+	Run: CPUProfile(yearInReviewRun),
+}
+
+func init() {
+	rootCmd.AddCommand(goalsCmd)
+	rootCmd.AddCommand(yearInReviewCmd)
+	yearInReviewCmd.Flags().String("user", "", "Only count the books finished by this sync user")
+}
+
+// parseYear parses a year argument, or returns the current year if args is empty.
+func parseYear(args []string) int {
+	if len(args) == 0 {
+		return time.Now().Year()
+	}
+	year, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid year.\n")
+		os.Exit(1)
+	}
+	return year
+}
+
+func goalsRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 && len(args) != 2 && len(args) != 4 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	user := args[0]
+	year := parseYear(args[1:])
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if len(args) == 4 {
+		target, err := strconv.Atoi(args[3])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid target.\n")
+			os.Exit(1)
+		}
+		if err := lib.SetReadingGoal(user, year, books.GoalKind(args[2]), target); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot set reading goal: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	goals, err := lib.GetReadingGoals(user, year)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get reading goals: %s\n", err)
+		os.Exit(1)
+	}
+	if len(goals) == 0 {
+		fmt.Printf("%s has no reading goals for %d.\n", user, year)
+		return
+	}
+	for _, g := range goals {
+		reached := ""
+		if g.Reached() {
+			reached = " (reached)"
+		}
+		fmt.Printf("%s: %d of %d%s\n", g.Kind, g.Progress, g.Target, reached)
+	}
+}
+
+func yearInReviewRun(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	year := parseYear(args)
+	user, _ := cmd.Flags().GetString("user")
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	review, err := lib.YearInReview(user, year)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get year in review: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Books read in %d: %d\n", year, len(review.BookIDs))
+	if review.Pages > 0 {
+		fmt.Printf("Pages: %d\n", review.Pages)
+	}
+	if len(review.TopAuthors) > 0 {
+		fmt.Println("Top authors:")
+		for _, a := range review.TopAuthors {
+			fmt.Printf("    %s (%d)\n", a.Name, a.Books)
+		}
+	}
+	if len(review.TopGenres) > 0 {
+		fmt.Println("Top genres:")
+		for _, g := range review.TopGenres {
+			fmt.Printf("    %s (%d)\n", g.Name, g.Books)
+		}
+	}
+}
@@ -5,11 +5,14 @@
 package commands
 
 import (
+	"bufio"
+	"encoding/json"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -26,8 +29,12 @@ var compiled []*regexp.Regexp
 var regexpNames []string
 var outputTmpl *template.Template
 var recursive bool
+var interactive bool
+var rescan bool
 var metadataParsers []string
 var metadataParserMap map[string]books.MetadataParser
+var preImportHooks, postImportHooks []books.ImportHook
+var importReport *books.ImportReport
 var tagsRegexp = regexp.MustCompile(`^(.*)\(([^)]+)\)\s*$`)
 
 // importCmd represents the import command
@@ -41,9 +48,51 @@ If a directory is passed, the books in that directory will be imported,
 but the books in its subdirectories will not be, unless --recursive is set.
 
 Each file will be matched against the list of regular expressions in order, and will be imported according to the first match.
-The following named groups will be recognized: author, series, title, and ext.
+The following named groups will be recognized: author, series, title, publisher, and ext.
+The author group can name several authors, separated by the strings in author_separators in the config file
+(by default &, ; and "and"), and by commas in lists unless split_author_commas is false.
+Names followed by a role in parentheses, such as "(editor)", "(translator)", "(narrator)" or "(illustrator)",
+are added as contributors in that role.
+
+The epub metadata parser reads the title and authors from EPUB files, and the embedded parser reads them
+from EPUBs and PDFs, and from other formats with Calibre's ebook-meta if ebook_meta is true in the config file.
+With --embedded-metadata (embedded_metadata in the config file), the metadata embedded in each file replaces
+the metadata from its filename when it includes the title and authors, and EPUB covers are cached as they're imported.
+
+If subject_tags.enabled is true in the config file, the subjects in EPUB files' metadata are added to their tags.
+Subjects in subject_tags.blocklist are skipped, subject_tags.mapping maps subjects to the tags they become,
+and subject_tags.max_tags limits how many tags are added to each file.
 Your files will be named according to the output template in the config file,
-or the template override set in the library.`,
+or the template override set in the library.
+
+With --in-place (import.in_place in the config file), files are cataloged where they are, by their absolute paths,
+instead of being copied into the books root, so an existing directory can be searched without being reorganized.
+Files cataloged in place are never moved or removed by the library; deleting them from the library only forgets them,
+and relocate records a file's new path if it's moved. adopt-files copies them into the books root later.
+
+Commands listed in import.pre_hooks and import.post_hooks in the config file are run with sh -c
+before and after each book is imported, with the book as JSON on standard input, with the same field names as in the server's API,
+such as "title" and "original_filename", and the file being imported in the BOOKS_FILE environment variable.
+A pre-import hook can change the book by writing it back to standard output, or skip the import by failing.
+Hooks of the form func:NAME call a hook registered by a program built on the books package.
+
+To check files for malware before they're imported, such as ones uploaded by others, list commands in import.screeners,
+such as clamscan --no-summary "$BOOKS_FILE". They're run with sh -c, with the file in the BOOKS_FILE environment variable,
+and should exit with status 0 if the file is clean, and 1 if it's flagged. Flagged files aren't imported,
+and if import.quarantine is true, they're moved to the library's quarantine directory, which paths shows.
+
+To keep a large import from starving other programs, --io-limit (io_limit in the config file) limits how fast files are read
+while they're hashed and copied, such as 10mb for 10 MB per second, and --nice (nice in the config file)
+gives the import the lowest CPU and I/O priority. These also apply to the email and periodicals commands.
+
+To import a large collection faster, --bulk hashes and parses files in parallel, with --workers at once
+(the number of CPUs by default), and imports them in batches of --batch-size files, one transaction per batch.
+Files whose hash is already in the library are skipped. --progress shows how many files have been scanned,
+imported, skipped as duplicates, and failed. --bulk can't be used with --rescan.
+
+When the import is finished, a report listing the files which were imported, skipped as duplicates, or failed,
+with the metadata each was given, is saved in the library, where the API can return it.
+--report writes it to a file as well, as JSON if the filename ends in .json, and as text otherwise.`,
 	Run: CPUProfile(importFunc),
 }
 
@@ -53,10 +102,30 @@ func init() {
 	importCmd.Flags().StringSliceP("metadata-parsers", "p", []string{}, "List of metadata parsers to use during import")
 	importCmd.Flags().StringSliceP("regexp", "r", []string{"regexp"}, "List of regular expressions to use during import")
 	importCmd.Flags().BoolP("move", "m", false, "Move files instead of copying them")
+	importCmd.Flags().Bool("in-place", false, "Catalog files where they are, instead of copying them into the books root")
 	importCmd.Flags().BoolVarP(&recursive, "recursive", "R", false, "Recurse into subdirectories")
+	importCmd.Flags().Bool("match-author-subsets", false, "Add files to an existing book with the same title if one book's authors include all of the other's")
+	importCmd.Flags().BoolVar(&rescan, "rescan", false, "Skip files which haven't changed since they were last imported with --rescan")
+	importCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Ask which book to use when a file could belong to more than one existing book")
 	viper.BindPFlag("move", importCmd.Flags().Lookup("move"))
+	viper.BindPFlag("import.in_place", importCmd.Flags().Lookup("in-place"))
+	viper.BindPFlag("match_author_subsets", importCmd.Flags().Lookup("match-author-subsets"))
 	viper.BindPFlag("default_metadata_parsers", importCmd.Flags().Lookup("metadata-parsers"))
 	viper.BindPFlag("default_regexps", importCmd.Flags().Lookup("regexp"))
+	importCmd.Flags().String("io-limit", "", "Read files no faster than this many bytes per second, such as 10mb")
+	importCmd.Flags().Bool("nice", false, "Import with the lowest CPU and I/O priority")
+	viper.BindPFlag("io_limit", importCmd.Flags().Lookup("io-limit"))
+	viper.BindPFlag("nice", importCmd.Flags().Lookup("nice"))
+	importCmd.Flags().Bool("content-signatures", false, "Skip EPUBs whose content is already in the library, even if their metadata differs")
+	viper.BindPFlag("content_signatures", importCmd.Flags().Lookup("content-signatures"))
+	importCmd.Flags().Bool("embedded-metadata", false, "Prefer the metadata embedded in files to the metadata from their filenames")
+	viper.BindPFlag("embedded_metadata", importCmd.Flags().Lookup("embedded-metadata"))
+	importCmd.Flags().Bool("strict", false, "Reject books with no title or authors, empty files, and files whose contents don't match their hashes")
+	viper.BindPFlag("import.strict", importCmd.Flags().Lookup("strict"))
+	importCmd.Flags().String("report", "", "Write the import report to this file, as JSON if it ends in .json")
+	importCmd.Flags().Bool("bulk", false, "Hash and parse files in parallel, and import them in batches")
+	importCmd.Flags().Int("workers", 0, "Number of files to hash and parse at once with --bulk (default the number of CPUs)")
+	importCmd.Flags().Int("batch-size", books.DefaultBulkImportBatchSize, "Number of files to import in each transaction with --bulk")
 }
 
 func importFunc(cmd *cobra.Command, args []string) {
@@ -65,6 +134,101 @@ func importFunc(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	setupImport()
+	throttleImports()
+	library, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	importReport = books.NewImportReport()
+	bulk, _ := cmd.Flags().GetBool("bulk")
+	if bulk && rescan {
+		fmt.Fprintf(os.Stderr, "--bulk can't be used with --rescan.\n")
+		os.Exit(1)
+	}
+	for _, path := range args {
+		if bulk {
+			if err := bulkImportBooks(cmd, path, library); err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot import books from %s: %s; skipping\n", path, err)
+			}
+			continue
+		}
+		if rescan {
+			stats, err := library.Rescan(path, recursive, func(bf books.BookFile) error {
+				log.Printf("Importing file %s:\n", bf.OriginalFilename)
+				return importBookFile(bf, library)
+			}, progressFunc())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot rescan books from %s: %s; skipping\n", path, err)
+				continue
+			}
+			log.Printf("Rescanned %s: %d files, %d unchanged, %d imported, %d errors", path, stats.Scanned, stats.Unchanged, stats.Imported, stats.Errors)
+			continue
+		}
+		if err := importBooks(path, recursive, library); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot import books from %s: %s; skipping\n", path, err)
+			continue
+		}
+	}
+	saveImportReport(cmd, library)
+}
+
+// saveImportReport saves the import report in the library, and writes it to the file passed to --report, if any.
+func saveImportReport(cmd *cobra.Command, library *books.Library) {
+	if err := library.SaveImportReport(importReport); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot save import report: %s\n", err)
+	} else {
+		log.Printf("Saved import report %d: %d imported, %d duplicates, %d failed", importReport.ID, importReport.Imported, importReport.Duplicates, importReport.Failures)
+	}
+	fn, _ := cmd.Flags().GetString("report")
+	if fn == "" {
+		return
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write import report: %s\n", err)
+		os.Exit(1)
+	}
+	if strings.EqualFold(filepath.Ext(fn), ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(importReport)
+	} else {
+		err = importReport.WriteText(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write import report: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// throttleImports limits the rate files are read, and lowers the process's priority, if the configuration asks for it,
+// so long running imports leave the machine responsive.
+func throttleImports() {
+	if s := viper.GetString("io_limit"); s != "" {
+		limit, err := books.ParseSize(s)
+		if err != nil || limit < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid io_limit %s: must be a size, such as 10mb\n", s)
+			os.Exit(1)
+		}
+		books.SetIOLimit(limit)
+	}
+	if viper.GetBool("nice") {
+		if err := books.LowerPriority(); err != nil {
+			log.Printf("Cannot lower the priority of imports: %s", err)
+		}
+	}
+}
+
+// setupImport compiles the regular expressions, metadata parsers, output template, and import hooks from the configuration.
+// Errors are printed, and the program exits.
+func setupImport() {
 	// Get regular expressions by their names and compile them.
 	res := viper.GetStringSlice("default_Regexps")
 	if len(res) == 0 {
@@ -87,12 +251,21 @@ func importFunc(cmd *cobra.Command, args []string) {
 		compiled = append(compiled, c)
 	}
 
+	authorParser := books.DefaultAuthorParser
+	if viper.IsSet("author_separators") {
+		authorParser.Separators = viper.GetStringSlice("author_separators")
+	}
+	if viper.IsSet("split_author_commas") {
+		authorParser.SplitCommas = viper.GetBool("split_author_commas")
+	}
 	metadataParserMap = make(map[string]books.MetadataParser)
 	metadataParserMap["regexp"] = &books.RegexpMetadataParser{
-		Regexps:     compiled,
-		RegexpNames: regexpNames,
+		Regexps:      compiled,
+		RegexpNames:  regexpNames,
+		AuthorParser: &authorParser,
 	}
 	metadataParserMap["epub"] = &books.EpubMetadataParser{}
+	metadataParserMap["embedded"] = &books.EmbeddedMetadataParser{Extractor: metadataExtractor()}
 	metadataParsers = viper.GetStringSlice("default_metadata_parsers")
 	for _, name := range metadataParsers {
 		if _, ok := metadataParserMap[name]; !ok {
@@ -113,18 +286,13 @@ func importFunc(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	library, err := books.OpenLibrary(libraryFile, booksRoot)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
+	if preImportHooks, err = configImportHooks("import.pre_hooks"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	defer library.Close()
-
-	for _, path := range args {
-		if err := importBooks(path, recursive, library); err != nil {
-			fmt.Fprintf(os.Stderr, "Cannot import books from %s: %s; skipping\n", path, err)
-			continue
-		}
+	if postImportHooks, err = configImportHooks("import.post_hooks"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
@@ -138,7 +306,10 @@ func importBooks(root string, recursive bool, library *books.Library) error {
 
 		if !info.IsDir() {
 			log.Printf("Importing file %s:\n", path)
-			if err := importBook(path, library); err != nil {
+			if err := importBook(path, "", library); errors.Cause(err) == books.ErrInsufficientSpace {
+				// The rest of the files won't fit either, so stop before trying to copy them.
+				return err
+			} else if err != nil {
 				log.Printf("Cannot import book from %s: %s; skipping\n", path, err)
 			}
 			return nil
@@ -152,46 +323,177 @@ func importBooks(root string, recursive bool, library *books.Library) error {
 	})
 }
 
-// importBook imports a single book into the library.
-func importBook(filename string, library *books.Library) error {
-	fi, err := os.Stat(filename)
-	if err != nil {
-		return errors.Wrap(err, "Get file info for book")
+// bulkImportBooks imports the books in root, which may be a file or directory, with BulkImport.
+func bulkImportBooks(cmd *cobra.Command, root string, library *books.Library) error {
+	opts := books.BulkImportOptions{ImportOptions: importOptions(), Recursive: recursive}
+	opts.Workers, _ = cmd.Flags().GetInt("workers")
+	opts.BatchSize, _ = cmd.Flags().GetInt("batch-size")
+	if interactive {
+		opts.ConflictResolver = books.ConflictResolverFunc(askConflict)
 	}
-
-	tags := splitTags(filename)
-	ext := path.Ext(filename)
-	var book books.Book
-	var matched bool
-	for _, parserName := range metadataParsers {
-		if book, matched = metadataParserMap[parserName].Parse([]string{filename}); matched {
-			log.Printf("Matched metadata parser: %s", parserName)
-			break
+	if showProgress {
+		opts.Progress = func(stats books.BulkImportStats, current string) {
+			fmt.Fprintf(os.Stderr, "import: %d scanned, %d imported, %d duplicates, %d errors: %s\n", stats.Scanned, stats.Imported, stats.Duplicates, stats.Errors, current)
 		}
 	}
+	stats, err := library.BulkImport(root, outputTmpl, bulkBook, opts)
+	log.Printf("Imported %s: %d files, %d imported, %d duplicates, %d errors", root, stats.Scanned, stats.Imported, stats.Duplicates, stats.Errors)
+	return err
+}
+
+// bulkBook parses the metadata of a file being imported with BulkImport, as importBookFile does.
+func bulkBook(bf books.BookFile) (books.Book, string, error) {
+	book, parser, matched := importParser{}.parse([]string{bf.OriginalFilename})
 	if !matched {
-		return errors.Errorf("No metadata parser matched %s", filename)
+		return book, "", errors.Errorf("No metadata parser matched %s", bf.OriginalFilename)
+	}
+	bf.Tags = splitTags(bf.OriginalFilename)
+	book.Files = append(book.Files, bf)
+	return book, parser, nil
+}
+
+// importBook imports a single book into the library.
+// source, if not empty, records where the file came from.
+func importBook(filename, source string, library *books.Library) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		err = errors.Wrap(err, "Get file info for book")
+		importReport.Fail(filename, books.ImportGuess{}, err)
+		return err
 	}
 
-	bf := books.BookFile{Tags: tags, OriginalFilename: filename}
+	bf := books.BookFile{OriginalFilename: filename, Source: source}
 	bf.FileSize = fi.Size()
 	bf.FileMtime = fi.ModTime()
-	bf.Extension = strings.TrimPrefix(ext, ".")
 
 	err = bf.CalculateHash()
 	if err != nil {
-		return errors.Wrap(err, "Calculate book hash")
+		err = errors.Wrap(err, "Calculate book hash")
+		importReport.Fail(filename, books.ImportGuess{}, err)
+		return err
+	}
+
+	return importBookFile(bf, library)
+}
+
+// importBookFile imports a single book into the library, given a file with its size, modification time, and hash already set.
+func importBookFile(bf books.BookFile, library *books.Library) error {
+	filename := bf.OriginalFilename
+	tags := splitTags(filename)
+	ext := path.Ext(filename)
+	book, parser, matched := importParser{}.parse([]string{filename})
+	if !matched {
+		err := errors.Errorf("No metadata parser matched %s", filename)
+		importReport.Fail(filename, books.ImportGuess{}, err)
+		return err
 	}
 
+	bf.Tags = tags
+	bf.Extension = strings.TrimPrefix(ext, ".")
+
 	book.Files = append(book.Files, bf)
 
-	if err := library.ImportBook(book, outputTmpl, viper.GetBool("move")); err != nil {
+	opts := importOptions()
+	opts.Parser = parser
+	if interactive {
+		opts.ConflictResolver = books.ConflictResolverFunc(askConflict)
+	}
+	if err := library.ImportBookWithOptions(book, outputTmpl, opts); err != nil {
+		importReport.Fail(filename, books.ImportGuess{Parser: parser, Authors: book.Authors, Title: book.Title, Series: book.Series, SeriesIndex: book.SeriesIndex, Published: book.Published}, err)
 		return errors.Wrap(err, "Import book into library")
 	}
 
 	return nil
 }
 
+// importParser is a MetadataParser which tries each of the configured metadata parsers in order.
+// setupImport must be called before it's used.
+type importParser struct{}
+
+// Parse parses files with the first configured metadata parser which matches them.
+func (p importParser) Parse(files []string) (book books.Book, parsed bool) {
+	book, _, parsed = p.parse(files)
+	return book, parsed
+}
+
+// parse is like Parse, but also returns the name of the parser which matched.
+func (importParser) parse(files []string) (book books.Book, parserName string, parsed bool) {
+	for _, parserName = range metadataParsers {
+		if book, parsed = metadataParserMap[parserName].Parse(files); parsed {
+			log.Printf("Matched metadata parser: %s", parserName)
+			return book, parserName, true
+		}
+	}
+	return book, "", false
+}
+
+// importOptions returns the options books are imported with, from the configuration.
+// setupImport must be called before it's used.
+func importOptions() books.ImportOptions {
+	opts := books.ImportOptions{
+		Move:               viper.GetBool("move"),
+		InPlace:            viper.GetBool("import.in_place"),
+		MatchAuthorSubsets: viper.GetBool("match_author_subsets"),
+		ContentSignatures:  viper.GetBool("content_signatures"),
+		PreImportHooks:     preImportHooks,
+		PostImportHooks:    postImportHooks,
+		Report:             importReport,
+	}
+	if viper.GetBool("embedded_metadata") {
+		extractor := metadataExtractor()
+		opts.EmbeddedMetadata = &extractor
+	}
+	for _, c := range viper.GetStringSlice("import.screeners") {
+		opts.Screeners = append(opts.Screeners, books.CommandScreener("sh", "-c", c))
+	}
+	opts.Quarantine = viper.GetBool("import.quarantine")
+	opts.Strict = viper.GetBool("import.strict")
+	if viper.GetBool("subject_tags.enabled") {
+		opts.SubjectTagger = &books.SubjectTagger{
+			Mapping:   viper.GetStringMapString("subject_tags.mapping"),
+			Blocklist: viper.GetStringSlice("subject_tags.blocklist"),
+			MaxTags:   viper.GetInt("subject_tags.max_tags"),
+		}
+	}
+	return opts
+}
+
+// metadataExtractor returns the extractor which reads the metadata embedded in files, from the configuration.
+func metadataExtractor() books.MetadataExtractor {
+	return books.MetadataExtractor{EbookMeta: viper.GetBool("ebook_meta")}
+}
+
+// askConflict asks the user which existing book, if any, an imported book should be added to.
+func askConflict(book books.Book, candidates []books.Book) (int64, error) {
+	fmt.Printf("%s - %s may already be in the library:\n", books.JoinNaturally("and", book.Authors), book.Title)
+	fmt.Println("0. Create a new book")
+	for i, c := range candidates {
+		bookName := books.JoinNaturally("and", c.Authors) + " - " + c.Title
+		if c.Series != "" {
+			bookName += " [" + c.Series + "]"
+		}
+		bookName += " (" + strconv.FormatInt(c.ID, 10) + ")"
+		fmt.Printf("%d. %s\n", i+1, bookName)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print("Select book: ")
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		idx, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || idx < 0 || idx > len(candidates) {
+			fmt.Printf("Please enter a number between 0 and %d.\n", len(candidates))
+			continue
+		}
+		if idx == 0 {
+			return 0, nil
+		}
+		return candidates[idx-1].ID, nil
+	}
+}
+
 // SplitTags takes an unsplit filename in the form "filename (tag1) (tag2)..."
 // and returns the tags.
 func splitTags(filename string) []string {
@@ -210,3 +512,21 @@ func splitTags(filename string) []string {
 	}
 	return tags
 }
+
+// configImportHooks returns the import hooks listed in the config file under key.
+// Each hook is either func:NAME, for a registered hook, or a command to run with sh -c.
+func configImportHooks(key string) ([]books.ImportHook, error) {
+	var hooks []books.ImportHook
+	for _, h := range viper.GetStringSlice(key) {
+		if strings.HasPrefix(h, "func:") {
+			hook, ok := books.LookupImportHook(strings.TrimPrefix(h, "func:"))
+			if !ok {
+				return nil, errors.Errorf("import hook %s not registered", h)
+			}
+			hooks = append(hooks, hook)
+			continue
+		}
+		hooks = append(hooks, books.CommandHook("sh", "-c", h))
+	}
+	return hooks, nil
+}
@@ -18,7 +18,10 @@ var overrideExistingLibrary = false
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the library",
-	Long:  `Initialize a new empty library`,
+	Long: `Initialize a new empty library.
+
+By default, every search field is indexed. To index only some of them, list them with --search-fields;
+title is always needed. The indexed fields can be changed later with books search-fields.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if _, err := os.Stat(libraryFile); err == nil {
 			if !overrideExistingLibrary {
@@ -32,7 +35,8 @@ var initCmd = &cobra.Command{
 			}
 		}
 
-		if err := books.CreateLibrary(libraryFile); err != nil {
+		fields, _ := cmd.Flags().GetStringSlice("search-fields")
+		if err := books.CreateLibraryWithOptions(libraryFile, books.CreateOptions{SearchFields: fields}); err != nil {
 			fmt.Fprintf(os.Stderr, "Cannot create library: %s\n", err)
 			os.Exit(1)
 		}
@@ -43,4 +47,5 @@ func init() {
 	rootCmd.AddCommand(initCmd)
 
 	initCmd.Flags().BoolVarP(&overrideExistingLibrary, "forceOverride", "f", false, "Override a library if one already exists.")
+	initCmd.Flags().StringSlice("search-fields", nil, "Comma-separated fields to index for searching (default all)")
 }
@@ -0,0 +1,80 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// mergeLibraryCmd represents the merge-library command
+var mergeLibraryCmd = &cobra.Command{
+	Use:   "merge-library LIBRARY_FILE BOOKS_ROOT",
+	Short: "Import all of the books of another library",
+	Long: `Import all of the books of another library, such as an old library database, into this one.
+
+Books are matched by their UUIDs, or by the hashes of their files, and files which aren't in this library yet are copied from BOOKS_ROOT.
+Books which aren't matched are imported as usual, so they're added to books with the same title and authors.
+If a matched book was changed more recently in the other library, its metadata replaces this library's.
+The other library isn't changed, though it's upgraded to the current schema.`,
+	Run: CPUProfile(mergeLibraryRun),
+}
+
+func init() {
+	rootCmd.AddCommand(mergeLibraryCmd)
+}
+
+func mergeLibraryRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	if _, err := os.Stat(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open other library: %s\n", err)
+		os.Exit(1)
+	}
+
+	outputTmplSrc := viper.GetString("output_template")
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+	other, err := books.OpenLibrary(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open other library: %s\n", err)
+		os.Exit(1)
+	}
+	defer other.Close()
+
+	res, err := lib.MergeLibrary(other, books.MergeLibraryOptions{
+		Template:           outputTmpl,
+		MatchAuthorSubsets: viper.GetBool("match_author_subsets"),
+		Progress:           progressFunc(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot merge library: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Added %d books and matched %d, copied %d files, and skipped %d already in the library\n", res.BooksAdded, res.BooksMatched, res.FilesAdded, res.FilesSkipped)
+	if len(res.Updated) > 0 {
+		fmt.Printf("Updated the metadata of %d books with newer metadata\n", len(res.Updated))
+	}
+	if res.FilesMissing > 0 {
+		fmt.Printf("%d files couldn't be read from the other library\n", res.FilesMissing)
+	}
+}
@@ -47,7 +47,7 @@ func mergeFunc(cmd *cobra.Command, args []string) {
 		ids = append(ids, int64(id))
 	}
 
-	library, err := books.OpenLibrary(libraryFile, booksRoot)
+	library, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
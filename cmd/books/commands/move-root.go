@@ -0,0 +1,59 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// moveRootCmd represents the move-root command
+var moveRootCmd = &cobra.Command{
+	Use:   "move-root FILE_ID [ROOT]",
+	Short: "Move a file to another books root",
+	Long: `Move a file to another books root, such as an archive on an external drive.
+
+Books roots other than the main one are set in the roots section of the config file, with a name and a path.
+Without ROOT, the file is moved back to the main books root.
+Files with the same contents are stored once, so they're moved together.
+Use paths to list the books roots, and show to find file IDs.`,
+	Run: CPUProfile(moveRootRun),
+}
+
+func init() {
+	rootCmd.AddCommand(moveRootCmd)
+}
+
+func moveRootRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	fileID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "File ID must be a number.")
+		os.Exit(1)
+	}
+	var root string
+	if len(args) == 2 {
+		root = args[1]
+	}
+
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.MoveFileToRoot(fileID, root); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot move file: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// optimizeSearchCmd represents the optimize-search command
+var optimizeSearchCmd = &cobra.Command{
+	Use:   "optimize-search",
+	Short: "Merge the search index, to speed up searches",
+	Long: `Merge the segments the search index is split into as books are imported, changed and deleted,
+which slow searches down, and show the size of the index before and after.
+
+Use compact afterwards to return the space freed to the file system.
+With --size, only show the size of the index.`,
+	Run: CPUProfile(optimizeSearchRun),
+}
+
+func init() {
+	rootCmd.AddCommand(optimizeSearchCmd)
+
+	optimizeSearchCmd.Flags().Bool("size", false, "Only show the size of the search index")
+}
+
+func optimizeSearchRun(cmd *cobra.Command, args []string) {
+	sizeOnly, err := cmd.Flags().GetBool("size")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if sizeOnly {
+		size, err := lib.SearchIndexSize()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get search index size: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d segments, %s\n", size.Segments, books.ByteCountSI(size.Bytes))
+		return
+	}
+	before, after, err := lib.OptimizeSearch()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot optimize search index: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Before: %d segments, %s\n", before.Segments, books.ByteCountSI(before.Bytes))
+	fmt.Printf("After: %d segments, %s\n", after.Segments, books.ByteCountSI(after.Bytes))
+}
@@ -0,0 +1,76 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// organizeCmd represents the organize command
+var organizeCmd = &cobra.Command{
+	Use:   "organize [query]",
+	Short: "Rename files with the output template",
+	Long: `Rename the files of books matching a search query, or all books if no query is given, with the output template,
+such as after changing it in the config file. Files are named by the output template when they're imported,
+and when their books are edited, so this only needs to be run when the template changes.
+
+Use --template to use another template than the one in the config file, and --dry-run to see the renames without making them.`,
+	Run: CPUProfile(organizeRun),
+}
+
+func init() {
+	rootCmd.AddCommand(organizeCmd)
+
+	organizeCmd.Flags().Bool("dry-run", false, "Show the files which would be renamed, without renaming them")
+	organizeCmd.Flags().String("template", "", "Template to name files with, instead of the output template in the config file")
+}
+
+func organizeRun(cmd *cobra.Command, args []string) {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	outputTmplSrc, err := cmd.Flags().GetString("template")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if outputTmplSrc == "" {
+		outputTmplSrc = viper.GetString("output_template")
+	}
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(outputTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, outputTmplSrc)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	renames, err := lib.OrganizeFiles(strings.Join(args, " "), outputTmpl, dryRun, progressFunc())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error renaming files: %s\n", err)
+		os.Exit(1)
+	}
+	for _, r := range renames {
+		fmt.Printf("File %d: %s -> %s\n", r.FileID, r.OldName, r.NewName)
+	}
+	if dryRun {
+		fmt.Printf("%d files would be renamed\n", len(renames))
+	} else {
+		fmt.Printf("%d files renamed\n", len(renames))
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// originalPathsCmd represents the original-paths command
+var originalPathsCmd = &cobra.Command{
+	Use:   "original-paths",
+	Short: "Make the original filenames of older files absolute",
+	Long: `Original filenames are recorded as absolute paths when books are imported.
+Files imported by older versions may have original filenames relative to the directory they were imported from, which wasn't recorded.
+
+Without --base, show how many files have relative original filenames.
+With --base, make them absolute by resolving them from that directory.`,
+	Run: CPUProfile(originalPathsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(originalPathsCmd)
+	originalPathsCmd.Flags().String("base", "", "Directory to resolve relative original filenames from")
+}
+
+func originalPathsRun(cmd *cobra.Command, args []string) {
+	base, err := cmd.Flags().GetString("base")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if base == "" {
+		n, err := lib.RelativeOriginalFilenames()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get original filenames: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d files have relative original filenames.\n", n)
+		if n > 0 {
+			fmt.Println("Use --base to resolve them from the directory they were imported from.")
+		}
+		return
+	}
+	n, err := lib.NormalizeOriginalFilenames(base)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot normalize original filenames: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Made %d original filenames absolute.\n", n)
+}
@@ -0,0 +1,55 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// pathsCmd represents the paths command
+var pathsCmd = &cobra.Command{
+	Use:   "paths",
+	Short: "Show the directories the library keeps its files in",
+	Long: `Show the absolute paths of the books root and the directories next to the library,
+such as the cache and trash, one per line as a name and a path separated by a tab.
+Other books roots are shown as root:NAME, followed by (unavailable) if their directory doesn't exist.`,
+	Run: CPUProfile(pathsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(pathsCmd)
+}
+
+func pathsRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	p := lib.Paths()
+	fmt.Printf("books_root\t%s\n", p.BooksRoot)
+	fmt.Printf("cache\t%s\n", p.Cache)
+	fmt.Printf("covers\t%s\n", p.Covers)
+	fmt.Printf("previews\t%s\n", p.Previews)
+	fmt.Printf("trash\t%s\n", p.Trash)
+	fmt.Printf("quarantine\t%s\n", p.Quarantine)
+	fmt.Printf("remote_cache\t%s\n", p.RemoteCache)
+	for _, r := range lib.Roots() {
+		if r.Name == "" {
+			continue
+		}
+		if r.Available() {
+			fmt.Printf("root:%s\t%s\n", r.Name, r.Path)
+		} else {
+			fmt.Printf("root:%s\t%s (unavailable)\n", r.Name, r.Path)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+	"github.com/tspivey/books/periodicals"
+)
+
+// periodicalsCmd represents the periodicals command
+var periodicalsCmd = &cobra.Command{
+	Use:   "periodicals",
+	Short: "Import periodicals from feeds",
+	Long: `Check RSS and Atom feeds, and import their new items as issues of periodicals.
+
+New items in each feed are rendered into an EPUB issue, or, with linked_epubs, EPUBs linked from the feed are imported as issues.
+Old issues are removed according to each feed's retention policy, and their files are moved to the trash.
+Issues a retention policy no longer keeps aren't copied to devices by the sync command, even before they're removed.
+Use --expire to only remove old issues, without checking the feeds, such as from a daily job.
+
+Feeds are configured as a list in periodicals.feeds in the config file, each with:
+name, url, linked_epubs, keep (number of issues to keep), and max_age_days (days to keep issues).
+periodicals.interval is the number of minutes between checks, default 60.`,
+	Run: CPUProfile(periodicalsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(periodicalsCmd)
+
+	periodicalsCmd.Flags().Bool("once", false, "Check the feeds once, instead of watching them")
+	periodicalsCmd.Flags().Bool("expire", false, "Remove old issues of each feed, without checking the feeds")
+	viper.SetDefault("periodicals.interval", 60)
+}
+
+// feedConfig is a feed in the configuration file.
+type feedConfig struct {
+	Name        string
+	URL         string
+	LinkedEpubs bool `mapstructure:"linked_epubs"`
+	Keep        int
+	MaxAgeDays  int `mapstructure:"max_age_days"`
+}
+
+func periodicalsRun(cmd *cobra.Command, args []string) {
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	expire, err := cmd.Flags().GetBool("expire")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var configs []feedConfig
+	if err := viper.UnmarshalKey("periodicals.feeds", &configs); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read periodicals.feeds: %s\n", err)
+		os.Exit(1)
+	}
+	if len(configs) == 0 {
+		fmt.Fprintln(os.Stderr, "No feeds are configured in periodicals.feeds.")
+		os.Exit(1)
+	}
+	var feeds []periodicals.Feed
+	for _, c := range configs {
+		if c.URL == "" {
+			fmt.Fprintln(os.Stderr, "Each feed in periodicals.feeds must have a url.")
+			os.Exit(1)
+		}
+		feeds = append(feeds, periodicals.Feed{
+			Name:        c.Name,
+			URL:         c.URL,
+			LinkedEpubs: c.LinkedEpubs,
+			Keep:        c.Keep,
+			MaxAge:      time.Duration(c.MaxAgeDays) * 24 * time.Hour,
+		})
+	}
+	interval := viper.GetDuration("periodicals.interval") * time.Minute
+
+	setupImport()
+	throttleImports()
+	library, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening Library: %s\n", err)
+		os.Exit(1)
+	}
+	defer library.Close()
+
+	if expire {
+		for _, feed := range feeds {
+			if err := library.SetRetentionPolicy(feed.URL, books.RetentionPolicy{Keep: feed.Keep, MaxAge: feed.MaxAge}); err != nil {
+				fmt.Fprintf(os.Stderr, "Error setting retention policy of %s: %s\n", feed.URL, err)
+				os.Exit(1)
+			}
+		}
+		expired, err := library.ExpireAllIssues()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error expiring issues: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d issues expired\n", len(expired))
+		return
+	}
+
+	updater := &periodicals.Updater{
+		Lib:      library,
+		Template: outputTmpl,
+		Options:  books.ImportOptions{PreImportHooks: preImportHooks, PostImportHooks: postImportHooks},
+		Client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+	for {
+		failed := false
+		for _, feed := range feeds {
+			n, err := updater.Update(feed)
+			if err != nil {
+				log.Printf("Error updating %s: %s", feed.URL, err)
+				failed = true
+			} else if n > 0 {
+				log.Printf("Imported %d issues from %s", n, feed.URL)
+			}
+		}
+		if once {
+			if failed {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(interval)
+	}
+}
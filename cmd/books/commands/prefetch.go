@@ -0,0 +1,78 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// prefetchCmd represents the prefetch command
+var prefetchCmd = &cobra.Command{
+	Use:   "prefetch [query]",
+	Short: "Download files on remote books roots into the remote cache",
+	Long: `Download the files on remote books roots, of books matching a search query or of all books if no query is given,
+into the library's remote cache, so they can be read and served quickly later.
+
+Remote roots are those marked remote in the roots section of the config file.
+How many files are downloaded at once, and how fast, is set in the remote_cache section:
+
+remote_cache:
+  max_size: 5gb
+  concurrency: 2
+  bandwidth: 2mb
+
+max_size is the most the cached files can take up; once they take up more, the least recently read are removed.
+bandwidth is per second, and is shared by all of the downloads. By default, the cache holds up to 2 GB, 2 files are downloaded at once,
+and there's no bandwidth limit.`,
+	Run: CPUProfile(prefetchRun),
+}
+
+func init() {
+	rootCmd.AddCommand(prefetchCmd)
+}
+
+func prefetchRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	var ids []int64
+	if query := strings.Join(args, " "); strings.TrimSpace(query) == "" {
+		ids, err = lib.ListBookIDs(books.SortByID, false)
+	} else {
+		var results []books.SearchResult
+		results, _, err = lib.SearchWithOptions(query, books.SearchOptions{})
+		for _, r := range results {
+			ids = append(ids, r.Book.ID)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find books: %s\n", err)
+		os.Exit(1)
+	}
+	found, err := lib.GetBooksByID(ids)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get books: %s\n", err)
+		os.Exit(1)
+	}
+	var files []books.BookFile
+	for _, b := range found {
+		files = append(files, b.Files...)
+	}
+	n, err := lib.Prefetch(files, progressFunc())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot prefetch files: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Cached %d files\n", n)
+}
@@ -0,0 +1,36 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tspivey/books"
+)
+
+var showProgress bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&showProgress, "progress", false, "Show progress of long-running operations")
+}
+
+// progressFunc returns a function printing progress to stderr if --progress was given, or nil otherwise.
+func progressFunc() books.ProgressFunc {
+	if !showProgress {
+		return nil
+	}
+	return printProgress
+}
+
+// printProgress prints a one-line progress report to stderr.
+func printProgress(p books.Progress) {
+	if p.Total > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d/%d (%.0f%%), ETA %s: %s\n", p.Operation, p.Done, p.Total, p.Fraction()*100, p.ETA().Round(time.Second), p.Current)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d: %s\n", p.Operation, p.Done, p.Current)
+}
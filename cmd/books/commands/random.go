@@ -0,0 +1,70 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// randomCmd represents the random command
+var randomCmd = &cobra.Command{
+	Use:   "random [TERMS]",
+	Short: "Pick random books to read",
+	Long: `Pick random books from the library, or from the books matching a search.
+
+Terms are a search, as described in books search --help.
+With --unread-by, books the sync user has finished aren't picked.
+
+Examples:
+    random
+    random -n 3 tags:sf rating:>=4
+    random --unread-by tyler --exclude-started`,
+	Run: CPUProfile(randomRun),
+}
+
+func init() {
+	rootCmd.AddCommand(randomCmd)
+	randomCmd.Flags().IntP("number", "n", 1, "Number of books to pick")
+	randomCmd.Flags().String("unread-by", "", "Don't pick books this sync user has finished")
+	randomCmd.Flags().Bool("exclude-started", false, "With --unread-by, also don't pick books the user has started")
+}
+
+func randomRun(cmd *cobra.Command, args []string) {
+	n, _ := cmd.Flags().GetInt("number")
+	var opts books.RandomOptions
+	opts.ExcludeReadBy, _ = cmd.Flags().GetString("unread-by")
+	opts.ExcludeStarted, _ = cmd.Flags().GetBool("exclude-started")
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	picked, err := lib.RandomBooksWithOptions(strings.Join(args, " "), n, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot pick books: %s\n", err)
+		os.Exit(1)
+	}
+	resultTmplSrc := `{{range $i, $v := . -}}
+{{joinNaturally "and" $v.Authors}} - {{$v.Title -}}
+{{if $v.Series}} [{{$v.Series}}]{{end }} ({{ $v.ID }})
+{{end}}`
+	tmpl, err := template.New("random_result").Funcs(funcMap).Parse(resultTmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing template: %s\n", err)
+		os.Exit(1)
+	}
+	if err := tmpl.Execute(os.Stdout, picked); err != nil {
+		fmt.Fprintf(os.Stderr, "Error executing template: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// ratingCmd represents the rating command
+var ratingCmd = &cobra.Command{
+	Use:   "rating <book id> <rating>",
+	Short: "Rate a book",
+	Long: `Rate a book from 1 to 5.
+
+Use 0 as the rating to clear it.
+Search for books by rating with rating:>=4.`,
+	Run: CPUProfile(ratingRun),
+}
+
+func init() {
+	rootCmd.AddCommand(ratingCmd)
+}
+
+func ratingRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	bookID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid book ID.\n")
+		os.Exit(1)
+	}
+	rating, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid rating.\n")
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.SetRating(bookID, rating); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot set rating: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// relocateCmd represents the relocate command
+var relocateCmd = &cobra.Command{
+	Use:   "relocate FILE_ID PATH",
+	Short: "Put a missing file back in the library",
+	Long: `Put a file which was moved out of the books root back in place, given its new location.
+
+The file at PATH must have the same contents as the file in the library.
+Use fsck to find missing files, and show to find file IDs.`,
+	Run: CPUProfile(relocateRun),
+}
+
+func init() {
+	rootCmd.AddCommand(relocateCmd)
+
+	relocateCmd.Flags().BoolP("move", "m", false, "Move the file instead of copying it")
+}
+
+func relocateRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	fileID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "File ID must be a number.")
+		os.Exit(1)
+	}
+	move, err := cmd.Flags().GetBool("move")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.RelocateFile(fileID, args[1], move); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot relocate file: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// reparseCmd represents the reparse command
+var reparseCmd = &cobra.Command{
+	Use:   "reparse [query]",
+	Short: "Parse the metadata of existing books from their filenames again",
+	Long: `Parse the original filenames of the files of books matching a search query, or all books if no query is given,
+with the configured regular expressions, and update the books to match.
+This applies improved regular expressions to books imported before they were improved.
+
+Use --rules to parse with the regular expressions and output template in a rule set file instead,
+such as one exported with books rules export and edited, to try it before importing it.
+Only the fields a matching regular expression has groups for are changed.
+Use --dry-run to see the changes without making them.`,
+	Run: CPUProfile(reparseRun),
+}
+
+func init() {
+	rootCmd.AddCommand(reparseCmd)
+
+	reparseCmd.Flags().Bool("dry-run", false, "Show the changes which would be made, without making them")
+	reparseCmd.Flags().String("rules", "", "Rule set file to parse filenames with, instead of the configured rules")
+}
+
+func reparseRun(cmd *cobra.Command, args []string) {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	rulesFn, err := cmd.Flags().GetString("rules")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	rules := configuredRules()
+	if rulesFn != "" {
+		f, err := os.Open(rulesFn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open rule set: %s\n", err)
+			os.Exit(1)
+		}
+		rules, err = books.ImportRules(f, books.RulesFormatForFilename(rulesFn), funcMap)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read rule set: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(rules.Regexps) == 0 {
+		fmt.Fprintln(os.Stderr, "No regular expressions are configured; set default_regexps in the configuration file, or use --rules.")
+		os.Exit(1)
+	}
+
+	outputTmpl, err := template.New("filename").Funcs(funcMap).Parse(rules.OutputTemplate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse output template: %s\n\n%s\n", err, rules.OutputTemplate)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	changes, err := lib.ReparseFilenames(rules, strings.Join(args, " "), outputTmpl, dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reparsing filenames: %s\n", err)
+		os.Exit(1)
+	}
+	made := 0
+	for _, c := range changes {
+		fmt.Printf("Book %d: %s -> %s (from %s by %s)", c.New.ID, describeBook(c.Old), describeBook(c.New), c.Filename, c.Regexp)
+		if c.ConflictID != 0 {
+			fmt.Printf(" (skipped: same as book %d)", c.ConflictID)
+		} else {
+			made++
+		}
+		fmt.Println()
+	}
+	if dryRun {
+		fmt.Printf("%d books would be changed\n", made)
+	} else {
+		fmt.Printf("%d books changed\n", made)
+	}
+}
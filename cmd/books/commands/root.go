@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -58,6 +59,133 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgDir, "config", "", "config directory (default is $HOME/.config/books)")
 	rootCmd.PersistentFlags().StringVar(&cpuProfile, "cpuprofile", "", "CPU profile filename")
+	rootCmd.PersistentFlags().Bool("durable", false, "Flush changes to disk before finishing them, so they survive power failures (slower)")
+	viper.BindPFlag("durable", rootCmd.PersistentFlags().Lookup("durable"))
+	rootCmd.PersistentFlags().Duration("lock-timeout", books.DefaultLockTimeout, "How long to wait while another process has the library locked")
+	viper.BindPFlag("lock_timeout", rootCmd.PersistentFlags().Lookup("lock-timeout"))
+}
+
+// libraryOptions returns the options libraries are opened with, from the config file.
+// Errors are printed, and the program exits.
+func libraryOptions() books.LibraryOptions {
+	perms, err := configPermissions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	policy, err := books.GetFilenamePolicy(viper.GetString("filename_policy"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	roots, err := configRoots()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	placement := books.PlacementPolicy(viper.GetString("placement"))
+	if placement != "" && placement != books.PlaceFirst && placement != books.PlaceMostFree {
+		fmt.Fprintf(os.Stderr, "Invalid placement %s: must be %s or %s\n", placement, books.PlaceFirst, books.PlaceMostFree)
+		os.Exit(1)
+	}
+	var quota int64
+	if s := viper.GetString("quota"); s != "" {
+		if quota, err = books.ParseSize(s); err != nil || quota < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid quota %s: must be a size, such as 500gb\n", s)
+			os.Exit(1)
+		}
+	}
+	remoteCache, err := configRemoteCache()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return books.LibraryOptions{Durable: viper.GetBool("durable"), LockTimeout: viper.GetDuration("lock_timeout"), Permissions: perms,
+		CopyProgress: progressFunc(), IntegrityCheck: viper.GetBool("integrity_check"), FilenamePolicy: policy,
+		Roots: roots, Placement: placement, Quota: quota, Locale: viper.GetString("locale"), RemoteCache: remoteCache}
+}
+
+// configRemoteCache returns how files on remote books roots are cached, from the remote_cache section of the config file.
+// max_size and bandwidth, which is per second, are sizes, such as 5gb and 2mb, and concurrency is the number of files downloaded at once.
+func configRemoteCache() (books.RemoteCacheOptions, error) {
+	var opts books.RemoteCacheOptions
+	var err error
+	if s := viper.GetString("remote_cache.max_size"); s != "" {
+		if opts.MaxBytes, err = books.ParseSize(s); err != nil || opts.MaxBytes < 0 {
+			return opts, fmt.Errorf("Invalid remote_cache.max_size %s: must be a size, such as 5gb", s)
+		}
+	}
+	if s := viper.GetString("remote_cache.bandwidth"); s != "" {
+		if opts.BandwidthLimit, err = books.ParseSize(s); err != nil || opts.BandwidthLimit < 0 {
+			return opts, fmt.Errorf("Invalid remote_cache.bandwidth %s: must be a size, such as 2mb", s)
+		}
+	}
+	opts.Concurrency = viper.GetInt("remote_cache.concurrency")
+	if opts.Concurrency < 0 {
+		return opts, fmt.Errorf("Invalid remote_cache.concurrency %d: must not be negative", opts.Concurrency)
+	}
+	return opts, nil
+}
+
+// configRoots returns the books roots in the roots section of the config file, in addition to the main books root.
+// Each has a name, a path, which may start with ~, and optionally no_placement, a quota, such as 500gb,
+// and remote, for roots on slow storage whose files are cached locally.
+func configRoots() ([]books.BooksRoot, error) {
+	var entries []struct {
+		Name        string
+		Path        string
+		NoPlacement bool `mapstructure:"no_placement"`
+		Quota       string
+		Remote      bool
+	}
+	if err := viper.UnmarshalKey("roots", &entries); err != nil {
+		return nil, fmt.Errorf("Invalid roots: %s", err)
+	}
+	var roots []books.BooksRoot
+	for _, e := range entries {
+		p, err := homedir.Expand(e.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid path of books root %s: %s", e.Name, err)
+		}
+		var quota int64
+		if e.Quota != "" {
+			if quota, err = books.ParseSize(e.Quota); err != nil || quota < 0 {
+				return nil, fmt.Errorf("Invalid quota of books root %s: %s must be a size, such as 500gb", e.Name, e.Quota)
+			}
+		}
+		roots = append(roots, books.BooksRoot{Name: e.Name, Path: p, NoPlacement: e.NoPlacement, Quota: quota, Remote: e.Remote})
+	}
+	return roots, nil
+}
+
+// configPermissions returns the permissions of imported files from the permissions section of the config file.
+// Modes are octal strings, such as "0664".
+// If uid or gid is set, the owner of imported files is changed.
+func configPermissions() (books.Permissions, error) {
+	perms := books.Permissions{UID: -1, GID: -1}
+	for _, m := range []struct {
+		key  string
+		mode *os.FileMode
+	}{{"permissions.file_mode", &perms.FileMode}, {"permissions.dir_mode", &perms.DirMode}} {
+		s := viper.GetString(m.key)
+		if s == "" {
+			continue
+		}
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || mode > 0777 {
+			return perms, fmt.Errorf("Invalid %s %s: must be an octal mode, such as 0664", m.key, s)
+		}
+		*m.mode = os.FileMode(mode)
+	}
+	if viper.IsSet("permissions.uid") {
+		perms.Chown = true
+		perms.UID = viper.GetInt("permissions.uid")
+	}
+	if viper.IsSet("permissions.gid") {
+		perms.Chown = true
+		perms.GID = viper.GetInt("permissions.gid")
+	}
+	return perms, nil
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -88,6 +216,7 @@ func initConfig() {
 
 	viper.SetDefault("root", path.Join(home, "books"))
 	booksRoot = viper.GetString("root")
+	loadRules()
 }
 
 // CPUProfile wraps a cobra command for CPU profiling.
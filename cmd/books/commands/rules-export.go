@@ -0,0 +1,67 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// rulesExportCmd represents the rules export command
+var rulesExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the rules in use",
+	Long: `Export the regular expressions in default_regexps, the output template and the author separators as a rule set.
+
+The rule set is written to file, or standard output if no file is given.
+For example:
+books rules export --name "Scene releases" scene.yaml`,
+	Run: CPUProfile(rulesExportRun),
+}
+
+func init() {
+	rulesCmd.AddCommand(rulesExportCmd)
+	rulesExportCmd.Flags().String("name", "", "Name of the rule set")
+	rulesExportCmd.Flags().String("description", "", "Description of the rule set")
+	rulesExportCmd.Flags().String("format", "", "Format of the rule set, json or yaml (default from the file extension, or yaml)")
+}
+
+func rulesExportRun(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	rules := configuredRules()
+	rules.Name, _ = cmd.Flags().GetString("name")
+	rules.Description, _ = cmd.Flags().GetString("description")
+	if err := rules.Validate(funcMap); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot export rules: %s\n", err)
+		os.Exit(1)
+	}
+
+	format := books.RulesYAML
+	var w io.Writer = os.Stdout
+	if len(args) == 1 {
+		format = books.RulesFormatForFilename(args[0])
+		f, err := os.Create(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create rules file: %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	if s, _ := cmd.Flags().GetString("format"); s != "" {
+		format = books.RulesFormat(s)
+	}
+	if err := books.ExportRules(w, rules, format); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot export rules: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// rulesImportCmd represents the rules import command
+var rulesImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a rule set",
+	Long: `Import a rule set, replacing the rules in the configuration file.
+
+The rule set is checked, then saved to rules_file, where it's used by every command.
+Its regular expressions are tried in the order they're listed.`,
+	Run: CPUProfile(rulesImportRun),
+}
+
+func init() {
+	rulesCmd.AddCommand(rulesImportCmd)
+	rulesImportCmd.Flags().String("format", "", "Format of the rule set, json or yaml (default from the file extension)")
+}
+
+func rulesImportRun(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	format := books.RulesFormatForFilename(args[0])
+	if s, _ := cmd.Flags().GetString("format"); s != "" {
+		format = books.RulesFormat(s)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open rule set: %s\n", err)
+		os.Exit(1)
+	}
+	rules, err := books.ImportRules(f, format, funcMap)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot import rule set: %s\n", err)
+		os.Exit(1)
+	}
+
+	fn := rulesFile()
+	out, err := os.Create(fn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot create rules file: %s\n", err)
+		os.Exit(1)
+	}
+	defer out.Close()
+	if err := books.ExportRules(out, rules, books.RulesFormatForFilename(fn)); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot save rule set: %s\n", err)
+		os.Exit(1)
+	}
+	name := rules.Name
+	if name == "" {
+		name = args[0]
+	}
+	fmt.Printf("Imported %s, with %d regular expressions, to %s\n", name, len(rules.Regexps), fn)
+}
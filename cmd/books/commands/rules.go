@@ -0,0 +1,92 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// rulesCmd represents the rules command
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Share regular expressions and output templates",
+	Long: `Export and import rule sets: the regular expressions which parse filenames,
+the output template which names imported files, and the author separators.
+
+Rule sets are JSON or YAML documents, chosen by the file extension.
+An imported rule set is kept in the file set by rules_file in the configuration file,
+rules.yaml in the config directory by default, and replaces the rules in the configuration file while it exists.
+Delete it to go back to the configuration file's rules.`,
+}
+
+func init() {
+	rootCmd.AddCommand(rulesCmd)
+}
+
+// rulesFile returns the filename of the imported rule set.
+func rulesFile() string {
+	if fn := viper.GetString("rules_file"); fn != "" {
+		return fn
+	}
+	return path.Join(cfgDir, "rules.yaml")
+}
+
+// loadRules replaces the configured rules with the imported rule set, if there is one.
+// Errors are printed, and the program exits.
+func loadRules() {
+	fn := rulesFile()
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open rules file: %s\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	rules, err := books.ImportRules(f, books.RulesFormatForFilename(fn), funcMap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot load rules from %s: %s\n", fn, err)
+		os.Exit(1)
+	}
+
+	regexps := make(map[string]interface{}, len(rules.Regexps))
+	var names []string
+	for _, r := range rules.Regexps {
+		regexps[r.Name] = r.Pattern
+		names = append(names, r.Name)
+	}
+	viper.Set("regexps", regexps)
+	viper.Set("default_regexps", names)
+	viper.Set("output_template", rules.OutputTemplate)
+	if len(rules.AuthorSeparators) > 0 {
+		viper.Set("author_separators", rules.AuthorSeparators)
+	}
+	if rules.SplitAuthorCommas != nil {
+		viper.Set("split_author_commas", *rules.SplitAuthorCommas)
+	}
+}
+
+// configuredRules returns the rules in use, in the order the regular expressions are tried.
+func configuredRules() books.RuleSet {
+	rules := books.RuleSet{OutputTemplate: viper.GetString("output_template")}
+	for _, name := range viper.GetStringSlice("default_regexps") {
+		rules.Regexps = append(rules.Regexps, books.NamedRegexp{Name: name, Pattern: viper.GetString("regexps." + name)})
+	}
+	if viper.IsSet("author_separators") {
+		rules.AuthorSeparators = viper.GetStringSlice("author_separators")
+	}
+	if viper.IsSet("split_author_commas") {
+		splitCommas := viper.GetBool("split_author_commas")
+		rules.SplitAuthorCommas = &splitCommas
+	}
+	return rules
+}
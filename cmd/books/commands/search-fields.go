@@ -0,0 +1,67 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// searchFieldsCmd represents the search-fields command
+var searchFieldsCmd = &cobra.Command{
+	Use:   "search-fields [field...]",
+	Short: "Show or change the fields indexed for searching",
+	Long: `Show the fields indexed for searching, or change them.
+
+If fields are given, they replace the indexed fields, and the search index is regenerated.
+title must be one of them.
+With --regenerate, the search index is regenerated with the fields already indexed.`,
+	Run: CPUProfile(searchFieldsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(searchFieldsCmd)
+	searchFieldsCmd.Flags().Bool("regenerate", false, "Regenerate the search index with the fields already indexed")
+}
+
+func searchFieldsRun(cmd *cobra.Command, args []string) {
+	regenerate, _ := cmd.Flags().GetBool("regenerate")
+	if regenerate && len(args) > 0 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if regenerate {
+		if err := lib.RegenerateSearchIndex(); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot regenerate search index: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(args) > 0 {
+		if err := lib.SetSearchFields(args); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot set search fields: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	fields, err := lib.SearchFields()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get search fields: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Indexed: %s\n", strings.Join(fields, ", "))
+	fmt.Printf("Available: %s\n", strings.Join(books.AllSearchFields, ", "))
+}
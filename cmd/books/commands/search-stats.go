@@ -0,0 +1,74 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// searchStatsCmd represents the search-stats command
+var searchStatsCmd = &cobra.Command{
+	Use:   "search-stats",
+	Short: "Show the most common searches, and those which found nothing",
+	Long: `Show the most common searches, and the searches which found nothing the last time they were made:
+books people keep looking for which aren't in the library.
+
+Searches are only logged when search.log is set for the search command, or server.log_searches for the web server.
+With --clear, logged searches older than --days are removed instead.`,
+	Run: CPUProfile(searchStatsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(searchStatsCmd)
+	searchStatsCmd.Flags().IntP("days", "d", 30, "Only count searches made in this many days")
+	searchStatsCmd.Flags().IntP("limit", "n", 20, "Number of searches to show in each list")
+	searchStatsCmd.Flags().Bool("clear", false, "Remove logged searches older than --days")
+}
+
+func searchStatsRun(cmd *cobra.Command, args []string) {
+	days, _ := cmd.Flags().GetInt("days")
+	limit, _ := cmd.Flags().GetInt("limit")
+	clear, _ := cmd.Flags().GetBool("clear")
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	since := time.Now().AddDate(0, 0, -days)
+	if clear {
+		n, err := lib.ClearSearchLog(since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot clear search log: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d searches\n", n)
+		return
+	}
+	a, err := lib.SearchAnalytics(since, limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get search analytics: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%d searches in the last %d days\n", a.Searches, days)
+	if len(a.TopQueries) > 0 {
+		fmt.Println("\nMost common searches:")
+		for _, q := range a.TopQueries {
+			fmt.Printf("%d: %s (%d results)\n", q.Count, q.Query, q.Results)
+		}
+	}
+	if len(a.ZeroResultQueries) > 0 {
+		fmt.Println("\nSearches which found nothing:")
+		for _, q := range a.ZeroResultQueries {
+			fmt.Printf("%d: %s (last searched %s)\n", q.Count, q.Query, q.LastSearched.Local().Format("2006-01-02"))
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/tspivey/books"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // searchCmd represents the search command
@@ -21,28 +22,50 @@ var searchCmd = &cobra.Command{
 	Short: "Search the library",
 	Long: `Search the library.
 By default, all fields are searched. This can be overridden with field:value.
-Supported fields: author, series, title, tags, extension.
+Supported fields: author, series, title, publisher, tags, extension, filename, works, editor, translator, narrator, illustrator,
+original_title, original_language, alternate_titles, subtitle, fandom, ship, url. Only the fields shown by books search-fields are searched.
+works searches the stories and essays contained in anthologies and collections.
+editor, translator, narrator, and illustrator search the book's contributors in those roles.
+tags searches the tags of books, and of their files.
+fandom, ship and url search the fandoms, ships, and source URLs of fanfiction.
+
+Results can be filtered with added, the date a book was added (YYYY-MM-DD),
+size, the size of one of its files (such as 5mb), rating, from 0 for unrated books to 5, words, its word count,
+and year, the year it was published, which only finds books whose publication dates are known.
+Filters take the operators <, <=, >, >= and =, such as rating:>=4. Years can also be ranges, such as year:1990..1999.
+license filters by license: public-domain, cc (any Creative Commons license, or one such as cc-by-sa),
+purchased, unknown, or shareable, which finds public domain and Creative Commons books.
+
+Terms with synonyms, added with books synonyms add, also find books matching their expansions.
 
 Examples:
     Wizard's First Rule
     series:Sword+of+Truth
-    author:Terry+Goodkind title:Phantom`,
+    author:Terry+Goodkind title:Phantom
+    translator:Pevear
+    author:Pratchett added:>2024-01-01 size:<5mb
+    publisher:Manning
+    fandom:Discworld words:>50000
+    author:Asimov year:<1960
+
+With search.log set in the config file, searches are logged for books search-stats.`,
 	Run: CPUProfile(searchRun),
 }
 
 func searchRun(cmd *cobra.Command, args []string) {
 	terms := strings.Join(args, " ")
-	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
 	}
 
-	books, err := lib.Search(terms)
+	results, _, err := lib.SearchWithOptions(terms, books.SearchOptions{BoostTitleMatches: true, Log: viper.GetBool("search.log")})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error while searching for books: %s\n", err)
 		os.Exit(1)
 	}
+	books := books.ResultBooks(results)
 	resultTmplSrc := `{{range $i, $v := . -}}
 {{joinNaturally "and" $v.Authors}} - {{$v.Title -}}
 {{if $v.Series}} [{{$v.Series}}]{{end }} ({{ $v.ID }})
@@ -59,6 +82,16 @@ func searchRun(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(os.Stderr, "Error executing template: %s\n", err)
 		os.Exit(1)
 	}
+	if len(books) == 0 {
+		suggestions, err := lib.SearchSuggestions(terms, 3)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting search suggestions: %s\n", err)
+			os.Exit(1)
+		}
+		if len(suggestions) > 0 {
+			fmt.Printf("No results. Did you mean: %s\n", strings.Join(suggestions, ", "))
+		}
+	}
 }
 
 func init() {
@@ -27,8 +27,19 @@ import (
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Serve the library from a web server",
-	Long:  `Bring up a web server and serve the library.`,
-	Run:   runServer,
+	Long: `Bring up a web server and serve the library.
+
+With --admin-ui, pages for listing, editing and uploading books are served under /admin/.
+Uploaded books are imported with the metadata parsers, regular expressions and hooks used by the import command.
+Since they change the library, add a user with books auth add first, so a login is needed to use them.
+
+With --uploads, books can be uploaded to the API in chunks, which can be resumed after a failed connection.
+Unfinished uploads are kept in the uploads directory in the config directory for a day.
+Each chunk must arrive within server.read_timeout, so clients on slow connections should send small chunks.
+
+Pages link to books and files by short IDs, under /b/ and /f/, which can be shared without revealing how big the library is.
+With --short-ids-only, the pages which take their numeric IDs are disabled, so books can't be found by counting.`,
+	Run: runServer,
 }
 
 func init() {
@@ -37,28 +48,53 @@ func init() {
 	serveCmd.Flags().StringP("bind", "b", "127.0.0.1:8000", "Bind the server to host:port. Leave host empty to bind to all interfaces.")
 	serveCmd.Flags().IntP("conversion-workers", "c", 4, "Number of conversion workers to run")
 	viper.BindPFlag("server.bind", serveCmd.Flags().Lookup("bind"))
+	serveCmd.Flags().Bool("kosync", false, "Enable KOReader progress sync")
+	serveCmd.Flags().Bool("kosync-registration", false, "Allow new users to register for KOReader progress sync")
+	serveCmd.Flags().Bool("calibre-api", false, "Enable an API compatible with Calibre's content server")
+	serveCmd.Flags().Bool("opds", false, "Enable an OPDS catalog at /opds for e-readers")
+	serveCmd.Flags().Bool("admin-ui", false, "Serve pages for listing, editing and uploading books under /admin/")
+	serveCmd.Flags().Bool("uploads", false, "Enable chunked uploads of books to the API")
+	serveCmd.Flags().Bool("short-ids-only", false, "Only serve books and files by their short IDs")
+	serveCmd.Flags().Bool("log-searches", false, "Log searches, for books search-stats")
 	viper.BindPFlag("server.conversion_workers", serveCmd.Flags().Lookup("conversion-workers"))
+	viper.BindPFlag("server.kosync", serveCmd.Flags().Lookup("kosync"))
+	viper.BindPFlag("server.kosync_registration", serveCmd.Flags().Lookup("kosync-registration"))
+	viper.BindPFlag("server.calibre_api", serveCmd.Flags().Lookup("calibre-api"))
+	viper.BindPFlag("server.opds", serveCmd.Flags().Lookup("opds"))
+	viper.BindPFlag("server.admin_ui", serveCmd.Flags().Lookup("admin-ui"))
+	viper.BindPFlag("server.uploads", serveCmd.Flags().Lookup("uploads"))
+	viper.BindPFlag("server.short_ids_only", serveCmd.Flags().Lookup("short-ids-only"))
+	viper.BindPFlag("server.log_searches", serveCmd.Flags().Lookup("log-searches"))
 	viper.SetDefault("server.read_timeout", 5)
 	viper.SetDefault("server.write_timeout", 0)
 	viper.SetDefault("server.idle_timeout", 120)
 	viper.SetDefault("server.items_per_page", 20)
+	viper.SetDefault("server.cache_size", 1000)
+	viper.SetDefault("server.idempotency_key_hours", 24)
 }
 
 func runServer(cmd *cobra.Command, args []string) {
-	cacheDir := path.Join(cfgDir, "cache")
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating cache directory: %s\n", err)
-		os.Exit(1)
-	}
 	templatesDir := path.Join(cfgDir, "templates")
-	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening library: %s\n", err)
 		os.Exit(1)
 	}
 
+	lib.EnableCache(viper.GetInt("server.cache_size"))
+	if _, err := lib.ExpireIdempotencyKeys(viper.GetDuration("server.idempotency_key_hours") * time.Hour); err != nil {
+		log.Printf("Error expiring idempotency keys: %s", err)
+	}
+
+	if viper.GetBool("server.kosync") {
+		if err := lib.IndexPartialMD5s(progressFunc()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error indexing files for KOReader progress sync: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
 	numConversionWorkers := viper.GetInt("server.conversion_workers")
-	converter := server.NewCalibreBookConverter(booksRoot, cacheDir, numConversionWorkers)
+	converter := server.NewCalibreBookConverter(lib, lib.Paths().Cache, numConversionWorkers)
 	log.Printf("Starting %d workers for converting books", numConversionWorkers)
 
 	hsrv := &http.Server{
@@ -84,6 +120,26 @@ func runServer(cmd *cobra.Command, args []string) {
 		HtpasswdFile:   htpasswdFile,
 		BooksRoot:      booksRoot,
 		OutputTemplate: outputTmpl,
+
+		KOSync:             viper.GetBool("server.kosync"),
+		KOSyncRegistration: viper.GetBool("server.kosync_registration"),
+		CalibreAPI:         viper.GetBool("server.calibre_api"),
+		OPDS:               viper.GetBool("server.opds"),
+		ShortIDsOnly:       viper.GetBool("server.short_ids_only"),
+		LogSearches:        viper.GetBool("server.log_searches"),
+	}
+	if viper.GetBool("server.admin_ui") || viper.GetBool("server.uploads") {
+		setupImport()
+		cfg.AdminUI = viper.GetBool("server.admin_ui")
+		cfg.MetadataParser = importParser{}
+		cfg.ImportOptions = importOptions()
+	}
+	if viper.GetBool("server.uploads") {
+		cfg.UploadsDir = path.Join(cfgDir, "uploads")
+		if err := os.MkdirAll(cfg.UploadsDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating uploads directory: %s\n", err)
+			os.Exit(1)
+		}
 	}
 	srv := server.New(cfg)
 	log.Printf("Listening on %s", hsrv.Addr)
@@ -37,7 +37,7 @@ func showRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	lib, err := books.OpenLibrary(libraryFile, booksRoot)
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
@@ -57,14 +57,38 @@ func showRun(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	bookDetailsTmplSrc := `{{joinNaturally "and" .Authors}} - {{.Title }}
+	bookDetailsTmplSrc := `{{joinNaturally "and" .Authors}} - {{.Title }}{{if .Subtitle}}: {{.Subtitle}}{{end}}
 {{if .Series}}Series: {{.Series}}
+{{end }}{{if .Publisher}}Publisher: {{.Publisher}}
+{{end }}{{if .Published}}Published: {{.Published}}
+{{end }}{{if .Language}}Language: {{.Language}}
+{{end }}{{range .Contributors}}{{.Role}}: {{.Name}}
+{{end }}{{range .AlternateTitles}}Also known as: {{.Title}}{{if .Kind}} ({{.Kind}}){{end}}
+{{end }}{{if .OriginalTitle}}Original title: {{.OriginalTitle}}{{if .OriginalLanguage}} ({{.OriginalLanguage}}){{end}}
+{{else if .OriginalLanguage}}Original language: {{.OriginalLanguage}}
+{{end }}{{if .TranslationOf}}Translation of: {{.TranslationOf}}
+{{end }}{{if .Translations}}Translations:{{range .Translations}} {{.}}{{end}}
+{{end }}{{if .Pages}}Pages: {{.Pages}}
+{{end }}{{if .WordCount}}Words: {{.WordCount}}
+{{end }}{{if .Fandoms}}Fandoms: {{join .Fandoms ", "}}
+{{end }}{{if .Ships}}Ships: {{join .Ships ", "}}
+{{end }}{{if .SourceURL}}Source URL: {{.SourceURL}}
+{{end }}{{if .License}}License: {{.License}}
+{{end }}Added: {{.CreatedOn.Local.Format "2006-01-02 15:04"}}{{if .FileAddedOn.After .CreatedOn}}, newest file added {{.FileAddedOn.Local.Format "2006-01-02 15:04"}}{{end}}
+{{if .Rating}}Rating: {{.Rating}}
+{{end }}{{if .Tags}}Tags: {{join .Tags ", "}}
+{{end }}{{range .Classifications}}{{.Scheme}}: {{.Number}}
+{{end }}{{if .UUID}}UUID: {{.UUID}}
+{{end }}{{if .Description}}
+{{.Description}}
 {{end }}
 {{ if .Files}}{{range .Files -}}
 {{ .Extension -}}
 : {{if .Tags}}({{range $i, $v := .Tags -}}
 {{if $i}}, {{end -}}
-{{ $v }}{{end}}){{end }} ({{ .ID }})
+{{ $v }}{{end}}){{end }} ({{ .ID }}){{if .Missing}} (missing){{end}}{{if .Path}} (in place: {{.Path}}){{end}}
+{{range .Works}}    {{.Title}}{{if .Authors}} by {{joinNaturally "and" .Authors}}{{end}}
+{{end -}}
 {{ end -}}
 {{ else }}No files available for this book{{ end }}`
 
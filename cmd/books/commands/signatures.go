@@ -0,0 +1,62 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// signaturesCmd represents the signatures command
+var signaturesCmd = &cobra.Command{
+	Use:   "signatures",
+	Short: "Find books with the same content but different metadata",
+	Long: `Calculate the content signatures of EPUB files which don't have one, and list the books which share one.
+
+A content signature hashes only the text of a book, not its metadata or cover,
+so copies which were re-tagged have the same signature even though their files differ.
+Signatures are calculated during import with --content-signatures.`,
+	Run: CPUProfile(signaturesRun),
+}
+
+func init() {
+	rootCmd.AddCommand(signaturesCmd)
+}
+
+func signaturesRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.IndexContentSignatures(progressFunc()); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot calculate content signatures: %s\n", err)
+		os.Exit(1)
+	}
+	groups, err := lib.ContentDuplicates()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get duplicates: %s\n", err)
+		os.Exit(1)
+	}
+	for i, ids := range groups {
+		if i > 0 {
+			fmt.Println()
+		}
+		bks, err := lib.GetBooksByID(ids)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get books: %s\n", err)
+			os.Exit(1)
+		}
+		for _, b := range bks {
+			fmt.Printf("%d: %s - %s\n", b.ID, books.JoinNaturally("and", b.Authors), b.Title)
+		}
+	}
+	fmt.Printf("\n%d groups of books have the same content.\n", len(groups))
+}
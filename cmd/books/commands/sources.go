@@ -0,0 +1,79 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// sourcesCmd represents the sources command
+var sourcesCmd = &cobra.Command{
+	Use:   "sources",
+	Short: "Show how the books from each source overlap",
+	Long: `Show the sources files were imported from, such as periodical feeds and email senders,
+with how many books each contributed which aren't available from any other source.
+
+Sources which contribute only duplicates are listed separately, to help decide which to stop importing from.
+With --books, every book with files from more than one source is also listed.`,
+	Run: CPUProfile(sourcesRun),
+}
+
+func init() {
+	rootCmd.AddCommand(sourcesCmd)
+	sourcesCmd.Flags().Bool("books", false, "List the books with files from more than one source")
+}
+
+// sourceName returns the name a source is shown with.
+func sourceName(source string) string {
+	if source == "" {
+		return "(no source)"
+	}
+	return source
+}
+
+func sourcesRun(cmd *cobra.Command, args []string) {
+	listBooks, err := cmd.Flags().GetBool("books")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get books flag: %s\n", err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	report, err := lib.SourceOverlapReport()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get source overlap: %s\n", err)
+		os.Exit(1)
+	}
+	for _, s := range report.Sources {
+		fmt.Printf("%s: %d files, %d books, %d only from this source\n", sourceName(s.Source), s.Files, s.Books, s.UniqueBooks)
+	}
+	if duplicates := report.DuplicateSources(); len(duplicates) > 0 {
+		fmt.Println("\nSources contributing only duplicates:")
+		for _, s := range duplicates {
+			fmt.Println(sourceName(s.Source))
+		}
+	}
+	fmt.Printf("\n%d books have files from more than one source.\n", len(report.SharedBooks))
+	if !listBooks {
+		return
+	}
+	for _, b := range report.SharedBooks {
+		sources := make([]string, len(b.Sources))
+		for i, s := range b.Sources {
+			sources[i] = sourceName(s)
+		}
+		fmt.Printf("%d: %s (%s)\n", b.ID, b.Title, strings.Join(sources, ", "))
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tspivey/books"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync MOUNT_POINT [BOOK_ID...]",
+	Short: "Copy books to an e-reader",
+	Long: `Copy books to an e-reader mounted at MOUNT_POINT.
+
+Books can be given by ID, found with --search, or given as a series with --series,
+which also numbers the books of each series in their filenames, as --number-series does, so they're listed in order.
+Files are named on the device according to device.template in the config file, or the output template if it isn't set.
+Use --kobo to also add the books to collections on a Kobo, one for each of their tags.
+Issues of periodicals which their retention policies no longer keep are left off, unless --include-expired is given.`,
+	Run: CPUProfile(syncRun),
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().StringP("search", "s", "", "Sync all books matching a search")
+	syncCmd.Flags().String("dir", "", "Directory on the device to copy books to")
+	syncCmd.Flags().StringSlice("ext", []string{}, "Only copy files with these extensions")
+	syncCmd.Flags().String("series", "", "Sync all books in a series, in order")
+	syncCmd.Flags().Bool("number-series", false, "Start filenames with the position of books in their series")
+	viper.BindPFlag("device.number_series", syncCmd.Flags().Lookup("number-series"))
+	syncCmd.Flags().Bool("include-expired", false, "Copy issues of periodicals which their retention policies no longer keep")
+	syncCmd.Flags().Bool("kobo", false, "Add books to Kobo collections named after their tags")
+	viper.BindPFlag("device.dir", syncCmd.Flags().Lookup("dir"))
+	viper.BindPFlag("device.extensions", syncCmd.Flags().Lookup("ext"))
+	syncCmd.Flags().String("filename-policy", "", "Make filenames safe for the device's file system: posix, windows or fat32")
+	viper.BindPFlag("device.filename_policy", syncCmd.Flags().Lookup("filename-policy"))
+}
+
+func syncRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	search, _ := cmd.Flags().GetString("search")
+	series, _ := cmd.Flags().GetString("series")
+	kobo, _ := cmd.Flags().GetBool("kobo")
+	includeExpired, _ := cmd.Flags().GetBool("include-expired")
+	if len(args) < 2 && search == "" && series == "" {
+		fmt.Fprintln(os.Stderr, "No books specified.")
+		os.Exit(1)
+	}
+
+	tmplSrc := viper.GetString("device.template")
+	if tmplSrc == "" {
+		tmplSrc = viper.GetString("output_template")
+	}
+	tmpl, err := template.New("filename").Funcs(funcMap).Parse(tmplSrc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse device template: %s\n\n%s\n", err, tmplSrc)
+		os.Exit(1)
+	}
+
+	policy, err := books.GetFilenamePolicy(viper.GetString("device.filename_policy"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	var ids []int64
+	for _, arg := range args[1:] {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Book ID must be a number.")
+			os.Exit(1)
+		}
+		ids = append(ids, id)
+	}
+	if search != "" {
+		results, err := lib.Search(search)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error while searching for books: %s\n", err)
+			os.Exit(1)
+		}
+		for _, b := range results {
+			ids = append(ids, b.ID)
+		}
+	}
+	if series != "" {
+		seriesIDs, err := lib.SeriesBookIDs(series)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot get books in series: %s\n", err)
+			os.Exit(1)
+		}
+		if len(seriesIDs) == 0 {
+			fmt.Fprintf(os.Stderr, "No books in series %s.\n", series)
+			os.Exit(1)
+		}
+		ids = append(ids, seriesIDs...)
+	}
+
+	opts := books.DeviceSyncOptions{
+		Root:                 args[0],
+		Dir:                  viper.GetString("device.dir"),
+		Template:             tmpl,
+		Extensions:           viper.GetStringSlice("device.extensions"),
+		FilenamePolicy:       policy,
+		NumberSeries:         series != "" || viper.GetBool("device.number_series"),
+		IncludeExpiredIssues: includeExpired,
+	}
+	synced, err := lib.SyncToDevice(ids, opts)
+	for _, df := range synced {
+		fmt.Println(df.Path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error syncing books: %s\n", err)
+		os.Exit(1)
+	}
+	if kobo {
+		if err := books.WriteKoboCollections(opts.Root, synced, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing Kobo collections: %s\n", err)
+			os.Exit(1)
+		}
+	}
+}
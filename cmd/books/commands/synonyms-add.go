@@ -0,0 +1,51 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// synonymsAddCmd represents the synonyms add command
+var synonymsAddCmd = &cobra.Command{
+	Use:   "add <term> <expansion...>",
+	Short: "Add a synonym of a search term",
+	Long: `Add a synonym of a search term, such as: books synonyms add lotr lord of the rings
+
+The term must be a single word. A term can have more than one expansion.
+With --index, the expansion is also indexed with books containing the term, and the search index is regenerated.
+Adding an existing synonym changes whether it's indexed.`,
+	Run: CPUProfile(synonymsAddRun),
+}
+
+func init() {
+	synonymsCmd.AddCommand(synonymsAddCmd)
+	synonymsAddCmd.Flags().Bool("index", false, "Also apply the synonym when indexing books")
+}
+
+func synonymsAddRun(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	index, _ := cmd.Flags().GetBool("index")
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	s := books.Synonym{Term: args[0], Expansion: strings.Join(args[1:], " "), IndexTime: index}
+	if err := lib.AddSynonym(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot add synonym: %s\n", err)
+		os.Exit(1)
+	}
+}
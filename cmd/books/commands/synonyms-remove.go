@@ -0,0 +1,45 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// synonymsRemoveCmd represents the synonyms remove command
+var synonymsRemoveCmd = &cobra.Command{
+	Use:   "remove <term> [expansion...]",
+	Short: "Remove synonyms of a search term",
+	Long: `Remove the synonym of a search term with the given expansion, or every synonym of the term if no expansion is given.
+If a removed synonym was indexed, the search index is regenerated.`,
+	Run: CPUProfile(synonymsRemoveRun),
+}
+
+func init() {
+	synonymsCmd.AddCommand(synonymsRemoveCmd)
+}
+
+func synonymsRemoveRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.RemoveSynonym(args[0], strings.Join(args[1:], " ")); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot remove synonym: %s\n", err)
+		os.Exit(1)
+	}
+}
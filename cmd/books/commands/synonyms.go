@@ -0,0 +1,51 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// synonymsCmd represents the synonyms command
+var synonymsCmd = &cobra.Command{
+	Use:   "synonyms",
+	Short: "List, add and remove synonyms of search terms",
+	Long: `List the synonyms used when searching, such as science fiction for sf.
+
+A search for a term with synonyms also finds books matching any of its expansions.
+Synonyms added with --index are also indexed with the text of books containing the term,
+so the term is found in search snippets, and by anything reading the search index directly.`,
+	Run: CPUProfile(synonymsRun),
+}
+
+func init() {
+	rootCmd.AddCommand(synonymsCmd)
+}
+
+func synonymsRun(cmd *cobra.Command, args []string) {
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	synonyms, err := lib.Synonyms()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot get synonyms: %s\n", err)
+		os.Exit(1)
+	}
+	for _, s := range synonyms {
+		if s.IndexTime {
+			fmt.Printf("%s: %s (indexed)\n", s.Term, s.Expansion)
+		} else {
+			fmt.Printf("%s: %s\n", s.Term, s.Expansion)
+		}
+	}
+}
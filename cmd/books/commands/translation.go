@@ -0,0 +1,57 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// translationCmd represents the translation command
+var translationCmd = &cobra.Command{
+	Use:   "translation <book id> <original book id>",
+	Short: "Record that a book is a translation of another book",
+	Long: `Record that a book is a translation of another book, usually an edition in the original language.
+
+Use 0 as the original book ID to record that a book isn't a translation.
+Set a translation's original title and language with books edit.`,
+	Run: CPUProfile(translationRun),
+}
+
+func init() {
+	rootCmd.AddCommand(translationCmd)
+}
+
+func translationRun(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		cmd.Usage()
+		os.Exit(1)
+	}
+	bookID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid book ID.\n")
+		os.Exit(1)
+	}
+	originalID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid original book ID.\n")
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if err := lib.SetTranslationOf(bookID, originalID); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot set translation: %s\n", err)
+		os.Exit(1)
+	}
+}
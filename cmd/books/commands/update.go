@@ -44,7 +44,7 @@ func updateFunc(cmd *cobra.Command, args []string) {
 		fmt.Fprintln(os.Stderr, "Book ID must be a number.")
 		os.Exit(1)
 	}
-	library, err := books.OpenLibrary(libraryFile, booksRoot)
+	library, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
 		os.Exit(1)
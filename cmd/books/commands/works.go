@@ -0,0 +1,105 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tspivey/books"
+)
+
+// worksCmd represents the works command
+var worksCmd = &cobra.Command{
+	Use:   "works FILE_ID",
+	Short: "List or set the works contained in a file",
+	Long: `List the works contained in a file, such as the stories in an anthology.
+
+Use --set with a filename, or - for standard input, to replace the works with those listed in it, one per line.
+Each line is written as "Author & Author - Title", or just the title if the work has the same authors as the book.
+Contained works are searchable, so searching for a story finds the anthology containing it.
+
+Use show to find the IDs of a book's files.`,
+	Run: CPUProfile(worksRun),
+}
+
+func init() {
+	rootCmd.AddCommand(worksCmd)
+
+	worksCmd.Flags().String("set", "", "File listing the contained works, one per line")
+}
+
+func worksRun(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "No file ID specified.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	fileID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "File ID must be a number.")
+		os.Exit(1)
+	}
+	set, err := cmd.Flags().GetString("set")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	lib, err := books.OpenLibraryWithOptions(libraryFile, booksRoot, libraryOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open library: %s\n", err)
+		os.Exit(1)
+	}
+	defer lib.Close()
+
+	if set != "" {
+		var r io.Reader = os.Stdin
+		if set != "-" {
+			f, err := os.Open(set)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Cannot open %s: %s\n", set, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			r = f
+		}
+		var works []books.ContainedWork
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) != "" {
+				works = append(works, books.ParseContainedWork(scanner.Text()))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading works: %s\n", err)
+			os.Exit(1)
+		}
+		if err := lib.SetContainedWorks(fileID, works); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting works: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	files, err := lib.GetFilesByID([]int64{fileID})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting file: %s\n", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No file found")
+		os.Exit(1)
+	}
+	for _, w := range files[0].Works {
+		if len(w.Authors) > 0 {
+			fmt.Printf("%s - ", strings.Join(w.Authors, " & "))
+		}
+		fmt.Println(w.Title)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -121,6 +122,262 @@ var seriesCmd = &DefaultCommand{
 	},
 }
 
+var publisherCmd = &DefaultCommand{
+	Help: "Sets the publisher of the currently edited book",
+	Run: func(cmd *DefaultCommand, args string) {
+		if args == "" {
+			fmt.Fprintf(os.Stderr, "Usage: publisher <publisher>\n")
+			return
+		}
+		cmd.parser.book.Publisher = args
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("publisher", s) {
+			return []string{}
+		}
+		return []string{"publisher " + cmd.parser.book.Publisher}
+	},
+}
+
+var subtitleCmd = &DefaultCommand{
+	Help: "Sets the subtitle of the currently edited book, or removes it if none is given",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.Subtitle = args
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("subtitle", s) {
+			return []string{}
+		}
+		return []string{"subtitle " + cmd.parser.book.Subtitle}
+	},
+}
+
+var descriptionCmd = &DefaultCommand{
+	Help: "Sets the description of the currently edited book, with \\n separating paragraphs",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.Description = strings.Replace(args, `\n`, "\n\n", -1)
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("description", s) {
+			return []string{}
+		}
+		return []string{"description " + strings.Replace(cmd.parser.book.Description, "\n\n", `\n`, -1)}
+	},
+}
+
+var languageCmd = &DefaultCommand{
+	Help: "Sets the language code of the language the currently edited book is written in, such as en",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.Language = args
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("language", s) {
+			return []string{}
+		}
+		return []string{"language " + cmd.parser.book.Language}
+	},
+}
+
+var publishedCmd = &DefaultCommand{
+	Help: "Sets the date the currently edited book was published, such as 1999, 1999-05 or 1999-05-17",
+	Run: func(cmd *DefaultCommand, args string) {
+		published, err := books.NormalizeDate(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
+		}
+		cmd.parser.book.Published = published
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("published", s) {
+			return []string{}
+		}
+		return []string{"published " + cmd.parser.book.Published}
+	},
+}
+
+var originalTitleCmd = &DefaultCommand{
+	Help: "Sets the title the currently edited book was first published in, if it's a translation",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.OriginalTitle = args
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("original-title", s) {
+			return []string{}
+		}
+		return []string{"original-title " + cmd.parser.book.OriginalTitle}
+	},
+}
+
+var originalLanguageCmd = &DefaultCommand{
+	Help: "Sets the language code the currently edited book was first published in, if it's a translation",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.OriginalLanguage = args
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("original-language", s) {
+			return []string{}
+		}
+		return []string{"original-language " + cmd.parser.book.OriginalLanguage}
+	},
+}
+
+var licenseCmd = &DefaultCommand{
+	Help: "Sets the license of the currently edited book: public-domain, cc, a Creative Commons license such as cc-by-sa, purchased or unknown",
+	Run: func(cmd *DefaultCommand, args string) {
+		license, err := books.NormalizeLicense(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return
+		}
+		cmd.parser.book.License = license
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("license", s) {
+			return []string{}
+		}
+		return []string{"license " + cmd.parser.book.License.String()}
+	},
+}
+
+var pagesCmd = &DefaultCommand{
+	Help: "Sets the number of pages in the currently edited book, or 0 if it isn't known",
+	Run: func(cmd *DefaultCommand, args string) {
+		pages, err := strconv.Atoi(args)
+		if err != nil || pages < 0 {
+			fmt.Fprintf(os.Stderr, "Usage: pages <number of pages>\n")
+			return
+		}
+		cmd.parser.book.Pages = pages
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("pages", s) {
+			return []string{}
+		}
+		return []string{"pages " + strconv.Itoa(cmd.parser.book.Pages)}
+	},
+}
+
+var fandomsCmd = &DefaultCommand{
+	Help: "Sets the fandoms of the currently edited book, separated by commas",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.Fandoms = books.SplitFanficTags(args)
+		if cmd.parser.book.Fandoms == nil {
+			cmd.parser.book.Fandoms = []string{}
+		}
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("fandoms", s) {
+			return []string{}
+		}
+		return []string{"fandoms " + strings.Join(cmd.parser.book.Fandoms, ", ")}
+	},
+}
+
+var shipsCmd = &DefaultCommand{
+	Help: "Sets the ships, or pairings, of the currently edited book, separated by commas",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.Ships = books.SplitFanficTags(args)
+		if cmd.parser.book.Ships == nil {
+			cmd.parser.book.Ships = []string{}
+		}
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("ships", s) {
+			return []string{}
+		}
+		return []string{"ships " + strings.Join(cmd.parser.book.Ships, ", ")}
+	},
+}
+
+var wordsCmd = &DefaultCommand{
+	Help: "Sets the number of words in the currently edited book, or 0 if it isn't known",
+	Run: func(cmd *DefaultCommand, args string) {
+		words := books.ParseWordCount(args)
+		if words == 0 && strings.TrimSpace(args) != "0" {
+			fmt.Fprintf(os.Stderr, "Usage: words <number of words>\n")
+			return
+		}
+		cmd.parser.book.WordCount = words
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("words", s) {
+			return []string{}
+		}
+		return []string{"words " + strconv.Itoa(cmd.parser.book.WordCount)}
+	},
+}
+
+var urlCmd = &DefaultCommand{
+	Help: "Sets the URL the currently edited book was published at, such as its page on Archive of Our Own, or removes it if none is given",
+	Run: func(cmd *DefaultCommand, args string) {
+		cmd.parser.book.SourceURL = strings.TrimSpace(args)
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("url", s) {
+			return []string{}
+		}
+		return []string{"url " + cmd.parser.book.SourceURL}
+	},
+}
+
+var tagsCmd = &DefaultCommand{
+	Help: "Sets the tags of the currently edited book, separated by commas. They belong to the book, rather than one of its files",
+	Run: func(cmd *DefaultCommand, args string) {
+		tags := []string{}
+		for _, t := range strings.Split(args, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		cmd.parser.book.Tags = tags
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("tags", s) {
+			return []string{}
+		}
+		return []string{"tags " + strings.Join(cmd.parser.book.Tags, ", ")}
+	},
+}
+
+var promoteCmd = &DefaultCommand{
+	Help: "Moves a tag from the files of the currently edited book to the book",
+	Run: func(cmd *DefaultCommand, args string) {
+		if args == "" {
+			fmt.Fprintf(os.Stderr, "Usage: promote <tag>\n")
+			return
+		}
+		if !cmd.parser.book.PromoteTag(args) {
+			fmt.Fprintf(os.Stderr, "No file has the tag %s.\n", args)
+		}
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("promote", s) {
+			return []string{}
+		}
+		return []string{"promote "}
+	},
+}
+
+var demoteCmd = &DefaultCommand{
+	Help: "Moves a tag from the currently edited book to each of its files",
+	Run: func(cmd *DefaultCommand, args string) {
+		if args == "" {
+			fmt.Fprintf(os.Stderr, "Usage: demote <tag>\n")
+			return
+		}
+		if !cmd.parser.book.DemoteTag(args) {
+			fmt.Fprintf(os.Stderr, "The book doesn't have the tag %s.\n", args)
+		}
+	},
+	completer: func(cmd *DefaultCommand, s string) []string {
+		if !strings.HasPrefix("demote", s) {
+			return []string{}
+		}
+		return []string{"demote "}
+	},
+}
+
 var saveCmd = &DefaultCommand{
 	Help: "Saves the currently edited book",
 	Run: func(cmd *DefaultCommand, args string) {
@@ -166,8 +423,25 @@ var showCmd = &DefaultCommand{
 	Help: "Shows available commands",
 	Run: func(cmd *DefaultCommand, args string) {
 		fmt.Println("Title: ", cmd.parser.book.Title)
+		fmt.Println("Subtitle: ", cmd.parser.book.Subtitle)
 		fmt.Println("Authors: ", strings.Join(cmd.parser.book.Authors, " & "))
 		fmt.Println("Series: ", cmd.parser.book.Series)
+		fmt.Println("Publisher: ", cmd.parser.book.Publisher)
+		fmt.Println("Published: ", cmd.parser.book.Published)
+		fmt.Println("Language: ", cmd.parser.book.Language)
+		fmt.Println("Description: ", cmd.parser.book.Description)
+		fmt.Println("Original title: ", cmd.parser.book.OriginalTitle)
+		fmt.Println("Original language: ", cmd.parser.book.OriginalLanguage)
+		fmt.Println("Pages: ", cmd.parser.book.Pages)
+		fmt.Println("License: ", cmd.parser.book.License)
+		fmt.Println("Fandoms: ", strings.Join(cmd.parser.book.Fandoms, ", "))
+		fmt.Println("Ships: ", strings.Join(cmd.parser.book.Ships, ", "))
+		fmt.Println("Words: ", cmd.parser.book.WordCount)
+		fmt.Println("URL: ", cmd.parser.book.SourceURL)
+		fmt.Println("Tags: ", strings.Join(cmd.parser.book.Tags, ", "))
+		for _, f := range cmd.parser.book.Files {
+			fmt.Printf("File %d (%s) tags: %s\n", f.ID, f.Extension, strings.Join(f.Tags, ", "))
+		}
 	},
 	completer: func(cmd *DefaultCommand, s string) []string {
 		if !strings.HasPrefix("show", s) {
@@ -231,7 +505,23 @@ func NewParser(book *books.Book, lib *books.Library, tmpl *template.Template) *P
 	m := make(map[string]*DefaultCommand)
 	m["authors"] = c(authorsCmd)
 	m["title"] = c(titleCmd)
+	m["subtitle"] = c(subtitleCmd)
 	m["series"] = c(seriesCmd)
+	m["publisher"] = c(publisherCmd)
+	m["description"] = c(descriptionCmd)
+	m["language"] = c(languageCmd)
+	m["published"] = c(publishedCmd)
+	m["original-title"] = c(originalTitleCmd)
+	m["original-language"] = c(originalLanguageCmd)
+	m["pages"] = c(pagesCmd)
+	m["license"] = c(licenseCmd)
+	m["fandoms"] = c(fandomsCmd)
+	m["ships"] = c(shipsCmd)
+	m["words"] = c(wordsCmd)
+	m["url"] = c(urlCmd)
+	m["tags"] = c(tagsCmd)
+	m["promote"] = c(promoteCmd)
+	m["demote"] = c(demoteCmd)
 	m["save"] = c(saveCmd)
 	m["show"] = c(showCmd)
 	m["help"] = c(helpCmd)
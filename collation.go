@@ -0,0 +1,97 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// unicodeCollation is the name of the SQLite collation which sorts text by the Unicode collation algorithm,
+// with the rules of the library's locale, ignoring case.
+// It's only used in queries, not in the schema, so other programs can still open libraries.
+const unicodeCollation = "unicode"
+
+var (
+	driversMtx sync.Mutex
+	// drivers are the names of the registered SQLite drivers, by synchronous mode and locale.
+	drivers = make(map[string]string)
+)
+
+// sqliteDriver returns the name of an SQLite driver whose connections use the given synchronous mode, enforce foreign keys,
+// and sort with unicodeCollation for locale, registering it if it hasn't been.
+// The driver for the root locale, language.Und, sorts most languages well.
+func sqliteDriver(synchronous string, locale language.Tag) string {
+	key := synchronous + "-" + locale.String()
+	driversMtx.Lock()
+	defer driversMtx.Unlock()
+	if name, ok := drivers[key]; ok {
+		return name
+	}
+	name := "sqlite3-" + key
+	sql.Register(name,
+		&sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				// Collators aren't safe for concurrent use, but a connection is only used by one goroutine at a time.
+				c := collate.New(locale, collate.IgnoreCase)
+				if err := conn.RegisterCollation(unicodeCollation, c.CompareString); err != nil {
+					return errors.Wrap(err, "register collation")
+				}
+				if err := execPragmas(conn, "pragma foreign_keys=on", "pragma synchronous="+synchronous); err != nil {
+					return err
+				}
+				// SQLite doesn't enforce foreign keys by default, and the schema relies on them to delete the rows
+				// which belong to deleted books and files. Turning them on does nothing, rather than failing,
+				// if SQLite was built without them, so connections fail unless they're on.
+				on, err := foreignKeysOn(conn)
+				if err != nil {
+					return err
+				}
+				if !on {
+					return errors.New("SQLite can't enforce foreign keys")
+				}
+				return nil
+			},
+		})
+	drivers[key] = name
+	return name
+}
+
+// foreignKeysOn returns true if foreign keys are enforced on conn.
+func foreignKeysOn(conn *sqlite3.SQLiteConn) (bool, error) {
+	rows, err := conn.Query("pragma foreign_keys", []driver.Value{})
+	if err != nil {
+		return false, errors.Wrap(err, "check foreign keys")
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "check foreign keys")
+	}
+	on, _ := dest[0].(int64)
+	return on == 1, nil
+}
+
+// parseLocale parses the locale of a library, such as "sv" or "de-DE", for sorting.
+// An empty locale is the root locale.
+func parseLocale(locale string) (language.Tag, error) {
+	if locale == "" {
+		return language.Und, nil
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return language.Und, errors.Wrapf(err, "invalid locale %s", locale)
+	}
+	return tag, nil
+}
@@ -0,0 +1,256 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CompactReport describes what was cleaned up by Compact.
+type CompactReport struct {
+	// Authors are the removed authors, which had no books.
+	Authors []string
+	// Tags are the removed tags, which had no files.
+	Tags []string
+	// SearchEntries are the IDs of removed search index entries, which had no book.
+	SearchEntries []int64
+	// Directories are the removed empty directories under the books roots.
+	Directories []string
+	// CacheFiles are the removed covers, converted books and previews, whose files are no longer in the library.
+	CacheFiles []string
+	// ScanCacheEntries is the number of removed scan cache entries, for files which no longer exist.
+	ScanCacheEntries int64
+}
+
+// Empty returns true if nothing was cleaned up.
+func (r CompactReport) Empty() bool {
+	return len(r.Authors) == 0 && len(r.Tags) == 0 && len(r.SearchEntries) == 0 && len(r.Directories) == 0 &&
+		len(r.CacheFiles) == 0 && r.ScanCacheEntries == 0
+}
+
+// Compact removes unused records from the library, and unused files from around it:
+// authors with no books, tags with no files, search index entries with no book,
+// empty directories under the books root, cached covers, conversions and previews of files no longer in the library,
+// and scan cache entries for files which no longer exist.
+// The database is then vacuumed, to return the space to the file system.
+func (lib *Library) Compact() (CompactReport, error) {
+	var report CompactReport
+	unlock, err := lib.lock()
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return report, errors.Wrap(err, "begin transaction")
+	}
+	if err := compactRecords(tx, &report); err != nil {
+		tx.Rollback()
+		return report, err
+	}
+	hashes, err := queryStrings(tx, "select distinct hash from files")
+	if err != nil {
+		tx.Rollback()
+		return report, errors.Wrap(err, "get file hashes")
+	}
+	derived, err := deleteUnusedDerivations(tx)
+	if err != nil {
+		tx.Rollback()
+		return report, err
+	}
+	scanPaths, err := queryStrings(tx, "select path from scan_cache")
+	if err != nil {
+		tx.Rollback()
+		return report, errors.Wrap(err, "get scan cache")
+	}
+	for _, p := range scanPaths {
+		// Relative paths depend on where the scan was run from, so they can't be checked.
+		if !filepath.IsAbs(p) {
+			continue
+		}
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			continue
+		}
+		if _, err := tx.Exec("delete from scan_cache where path=?", p); err != nil {
+			tx.Rollback()
+			return report, errors.Wrap(err, "delete scan cache entry")
+		}
+		report.ScanCacheEntries++
+	}
+	err = tx.Commit()
+	lib.invalidateCache()
+	if err != nil {
+		return report, errors.Wrap(err, "commit")
+	}
+
+	for _, fn := range derived {
+		if err := os.Remove(fn); err == nil {
+			report.CacheFiles = append(report.CacheFiles, fn)
+		} else if !os.IsNotExist(err) {
+			return report, errors.Wrap(err, "remove derived file")
+		}
+	}
+	// Files cached before derivations were recorded are found by their names.
+	inLibrary := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		inLibrary[h] = true
+	}
+	for _, dir := range []string{lib.paths.Cache, lib.paths.Previews, lib.paths.Covers} {
+		removed, err := removeUnusedCacheFiles(dir, inLibrary)
+		report.CacheFiles = append(report.CacheFiles, removed...)
+		if err != nil {
+			return report, errors.Wrapf(err, "clean %s directory", dir)
+		}
+	}
+	for _, r := range lib.roots {
+		if !r.Available() {
+			continue
+		}
+		removed, err := removeEmptyDirs(r.Path)
+		report.Directories = append(report.Directories, removed...)
+		if err != nil {
+			return report, errors.Wrap(err, "remove empty directories")
+		}
+	}
+
+	if _, err := lib.Exec("vacuum"); err != nil {
+		return report, errors.Wrap(err, "vacuum")
+	}
+	log.Printf("Compacted library: removed %d authors, %d tags, %d search entries, %d directories, %d cache files, %d scan cache entries",
+		len(report.Authors), len(report.Tags), len(report.SearchEntries), len(report.Directories), len(report.CacheFiles), report.ScanCacheEntries)
+	return report, nil
+}
+
+// sqlUnusedAuthors matches authors which aren't linked to any books.
+// Authors with disambiguation notes or external IDs were added to tell people apart, and are kept until books are reassigned to them.
+const sqlUnusedAuthors = "id not in (select author_id from books_authors) and disambiguation = '' and external_id = ''"
+
+// compactRecords removes unused authors, tags and search index entries.
+func compactRecords(tx *sql.Tx, report *CompactReport) error {
+	var err error
+	report.Authors, err = queryStrings(tx, "select name from authors where "+sqlUnusedAuthors+" order by name")
+	if err != nil {
+		return errors.Wrap(err, "find unused authors")
+	}
+	if _, err := tx.Exec("delete from authors where " + sqlUnusedAuthors); err != nil {
+		return errors.Wrap(err, "delete unused authors")
+	}
+	report.Tags, err = queryStrings(tx, "select name from tags where id not in (select tag_id from files_tags union select tag_id from books_tags) order by name")
+	if err != nil {
+		return errors.Wrap(err, "find unused tags")
+	}
+	if _, err := tx.Exec("delete from tags where id not in (select tag_id from files_tags union select tag_id from books_tags)"); err != nil {
+		return errors.Wrap(err, "delete unused tags")
+	}
+	problems, err := checkSearchIndex(tx)
+	if err != nil {
+		return err
+	}
+	if len(problems.GhostIDs) > 0 {
+		if _, err := tx.Exec("delete from books_fts where docid in (" + joinInt64s(problems.GhostIDs, ",") + ")"); err != nil {
+			return errors.Wrap(err, "delete ghost search entries")
+		}
+	}
+	report.SearchEntries = problems.GhostIDs
+	return nil
+}
+
+// removeUnusedCacheFiles removes files named after hashes which aren't in inLibrary from dir, and returns their names.
+// Cache files are named with the hash, followed by a dash or dot.
+func removeUnusedCacheFiles(dir string, inLibrary map[string]bool) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, e := range entries {
+		name := e.Name()
+		hash := name
+		if i := strings.IndexAny(name, "-."); i >= 0 {
+			hash = name[:i]
+		}
+		if e.IsDir() || inLibrary[hash] || !isHash(hash) {
+			continue
+		}
+		fn := filepath.Join(dir, name)
+		if err := os.Remove(fn); err != nil {
+			return removed, err
+		}
+		removed = append(removed, fn)
+	}
+	return removed, nil
+}
+
+// isHash reports whether s looks like the hex encoded SHA-256 hash of a file.
+func isHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	return strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// removeEmptyDirs removes the empty directories under root, including directories which only contained empty directories.
+// root itself isn't removed.
+func removeEmptyDirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && p != root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Remove the deepest directories first, so their parents can become empty.
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	var removed []string
+	for _, d := range dirs {
+		entries, err := ioutil.ReadDir(d)
+		if err != nil {
+			return removed, err
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err := os.Remove(d); err != nil {
+			return removed, err
+		}
+		removed = append(removed, d)
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// queryStrings runs a query returning a single text column, and collects the results.
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,319 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Contributor roles.
+const (
+	RoleAuthor      = "author"
+	RoleEditor      = "editor"
+	RoleTranslator  = "translator"
+	RoleNarrator    = "narrator"
+	RoleIllustrator = "illustrator"
+)
+
+// contributorRoles are the roles other than author, in order.
+// Each has its own column in the search index, named after the role, so translator:Smith finds books translated by Smith.
+var contributorRoles = []string{RoleEditor, RoleTranslator, RoleNarrator, RoleIllustrator}
+
+// Contributor is a person who worked on a book, in a role such as author or translator.
+type Contributor struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// roleNames maps the role suffixes recognized by AuthorParser, lowercased and without periods, to roles.
+// Plural suffixes, such as "eds", apply to all of the names before them without a role.
+var roleNames = map[string]string{
+	"author":         RoleAuthor,
+	"editor":         RoleEditor,
+	"ed":             RoleEditor,
+	"edited by":      RoleEditor,
+	"translator":     RoleTranslator,
+	"trans":          RoleTranslator,
+	"tr":             RoleTranslator,
+	"translated by":  RoleTranslator,
+	"narrator":       RoleNarrator,
+	"narr":           RoleNarrator,
+	"read by":        RoleNarrator,
+	"illustrator":    RoleIllustrator,
+	"illus":          RoleIllustrator,
+	"ill":            RoleIllustrator,
+	"illustrated by": RoleIllustrator,
+}
+
+var pluralRoleNames = map[string]string{
+	"authors":      RoleAuthor,
+	"editors":      RoleEditor,
+	"eds":          RoleEditor,
+	"translators":  RoleTranslator,
+	"narrators":    RoleNarrator,
+	"illustrators": RoleIllustrator,
+}
+
+// epubRoles maps the MARC relator codes used for roles in EPUB metadata to roles.
+var epubRoles = map[string]string{
+	"aut": RoleAuthor,
+	"edt": RoleEditor,
+	"trl": RoleTranslator,
+	"nrt": RoleNarrator,
+	"ill": RoleIllustrator,
+}
+
+// roleSuffixRegexp matches a parenthesized role at the end of a name, such as " (editor)".
+var roleSuffixRegexp = regexp.MustCompile(`\s*\(([^()]*)\)$`)
+
+// AuthorParser parses strings naming several contributors to a book, such as "Jane Doe & John Roe (translator)".
+// Names may end with a role in parentheses; names without one are authors.
+type AuthorParser struct {
+	// Separators separate the names, and are matched ignoring case.
+	// Separators made of letters, such as "and", only match whole words.
+	Separators []string
+	// SplitCommas also separates names at commas, when the string is a list such as "A, B and C".
+	// Names written "Last, First" are kept together if each part is a single word, or if nothing else makes the string a list.
+	SplitCommas bool
+}
+
+// DefaultAuthorParser is the AuthorParser used by ParseAuthors.
+var DefaultAuthorParser = AuthorParser{Separators: []string{"&", ";", "and"}, SplitCommas: true}
+
+// ParseAuthors parses a string naming several contributors to a book with DefaultAuthorParser.
+func ParseAuthors(s string) []Contributor {
+	return DefaultAuthorParser.Parse(s)
+}
+
+// Parse parses a string naming several contributors to a book, returning them in order.
+// Each contributor appears once per role.
+func (p AuthorParser) Parse(s string) []Contributor {
+	segments := []string{s}
+	if re := p.separatorRegexp(); re != nil {
+		segments = re.Split(s, -1)
+	}
+	isList := len(segments) > 1 || strings.Count(s, ",") > 1
+	var names []string
+	for _, seg := range segments {
+		if p.SplitCommas && isList {
+			names = append(names, splitCommas(seg)...)
+		} else {
+			names = append(names, seg)
+		}
+	}
+
+	var contributors []Contributor
+	roleless := 0
+	for _, name := range names {
+		name = collapseSpace(name)
+		role := ""
+		if m := roleSuffixRegexp.FindStringSubmatch(name); m != nil {
+			suffix := strings.ToLower(strings.Replace(collapseSpace(m[1]), ".", "", -1))
+			if r, ok := roleNames[suffix]; ok {
+				role = r
+			} else if r, ok := pluralRoleNames[suffix]; ok {
+				role = r
+				for i := roleless; i < len(contributors); i++ {
+					contributors[i].Role = r
+				}
+			}
+			if role != "" {
+				name = strings.TrimSpace(name[:len(name)-len(m[0])])
+			}
+		}
+		if name == "" {
+			continue
+		}
+		c := Contributor{Name: name, Role: role}
+		if role != "" {
+			roleless = len(contributors) + 1
+		}
+		contributors = append(contributors, c)
+	}
+
+	var result []Contributor
+	seen := make(map[Contributor]bool)
+	for _, c := range contributors {
+		if c.Role == "" {
+			c.Role = RoleAuthor
+		}
+		key := Contributor{Name: normalizeAuthor(c.Name), Role: c.Role}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, c)
+	}
+	return result
+}
+
+// separatorRegexp returns a regular expression matching the parser's separators, or nil if it has none.
+func (p AuthorParser) separatorRegexp() *regexp.Regexp {
+	var alternatives []string
+	for _, sep := range p.Separators {
+		sep = strings.TrimSpace(sep)
+		if sep == "" {
+			continue
+		}
+		quoted := regexp.QuoteMeta(sep)
+		if isWordy(sep) {
+			quoted = `\b` + quoted + `\b`
+		}
+		alternatives = append(alternatives, quoted)
+	}
+	if len(alternatives) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)\s*(?:` + strings.Join(alternatives, "|") + `)\s*`)
+}
+
+// isWordy reports whether s starts and ends with a letter or digit, so it should only match whole words.
+func isWordy(s string) bool {
+	isWordChar := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+	return isWordChar(s[0]) && isWordChar(s[len(s)-1])
+}
+
+// splitCommas splits a list of names at commas.
+// Suffixes such as "Jr." stay with the name before them, and single words are paired as "Last, First" names.
+func splitCommas(s string) []string {
+	var parts []string
+	for _, part := range strings.Split(s, ",") {
+		part = collapseSpace(part)
+		if part == "" {
+			continue
+		}
+		if len(parts) > 0 && authorSuffixes[strings.ToLower(roleSuffixRegexp.ReplaceAllString(part, ""))] {
+			parts[len(parts)-1] += ", " + part
+			continue
+		}
+		parts = append(parts, part)
+	}
+	for _, p := range parts {
+		if len(strings.Fields(roleSuffixRegexp.ReplaceAllString(p, ""))) != 1 {
+			return parts
+		}
+	}
+	if len(parts)%2 != 0 {
+		return parts
+	}
+	var names []string
+	for i := 0; i < len(parts); i += 2 {
+		names = append(names, parts[i]+", "+parts[i+1])
+	}
+	return names
+}
+
+// splitContributors divides contributors into the names of the authors, and everyone else.
+func splitContributors(contributors []Contributor) (authors []string, others []Contributor) {
+	for _, c := range contributors {
+		if c.Role == RoleAuthor {
+			authors = append(authors, c.Name)
+		} else {
+			others = append(others, c)
+		}
+	}
+	return authors, others
+}
+
+// contributorsEqual reports whether a and b contain the same contributors, ignoring order.
+func contributorsEqual(a, b []Contributor) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[Contributor]int, len(a))
+	for _, c := range a {
+		count[c]++
+	}
+	for _, c := range b {
+		if count[c] == 0 {
+			return false
+		}
+		count[c]--
+	}
+	return true
+}
+
+// getContributorsByBookIds gets the contributors other than authors for each book ID.
+func getContributorsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]Contributor, error) {
+	m := make(map[int64][]Contributor)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select " + contributorRowColumns + " from books_authors ba join authors a on ba.author_id = a.id where ba.role != 'author' and ba.book_id in (" + joinInt64s(ids, ",") + ") order by ba.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row, err := scanContributorRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		m[row.BookID] = append(m[row.BookID], row.contributor())
+	}
+	return m, rows.Err()
+}
+
+// validRole reports whether role is one of the contributor roles, including author.
+func validRole(role string) bool {
+	if role == RoleAuthor {
+		return true
+	}
+	for _, r := range contributorRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Contributors returns the names of everyone with role in at least one book, in alphabetical order.
+func (lib *Library) Contributors(role string) ([]string, error) {
+	if !validRole(role) {
+		return nil, errors.Errorf("unknown role %s", role)
+	}
+	rows, err := lib.Query("select distinct a.name from books_authors ba join authors a on ba.author_id = a.id where ba.role=? order by a.name collate "+unicodeCollation, role)
+	if err != nil {
+		return nil, errors.Wrap(err, "get contributors")
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "get contributors")
+		}
+		names = append(names, name)
+	}
+	return names, errors.Wrap(rows.Err(), "get contributors")
+}
+
+// ContributorBookIDs returns the IDs of the books name contributed to in role, ignoring case.
+// If role is empty, books they contributed to in any role are returned.
+func (lib *Library) ContributorBookIDs(name, role string) ([]int64, error) {
+	if role != "" && !validRole(role) {
+		return nil, errors.Errorf("unknown role %s", role)
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	ids, err := queryInt64s(tx, "select distinct ba.book_id from books_authors ba join authors a on ba.author_id = a.id where a.name=? collate nocase and (?='' or ba.role=?) order by ba.book_id",
+		collapseSpace(name), role, role)
+	return ids, errors.Wrap(err, "get books")
+}
+
+// sqlIndexedContributors returns an SQL expression for the names indexed in the search index column for role,
+// for the book with the given ID.
+func sqlIndexedContributors(role, bookID string) string {
+	return `(select coalesce(group_concat(a.name, ' & '), '') from books_authors ba join authors a on ba.author_id = a.id where ba.book_id = ` + bookID + ` and ba.role = '` + role + `')`
+}
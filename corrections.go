@@ -0,0 +1,265 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// CorrectionFields are the fields which can be changed by ApplyCorrections.
+// Authors are separated by & or ;, and tags, fandoms and ships by commas. file_tags changes the tags of the file named by a hash.
+var CorrectionFields = []string{"title", "subtitle", "authors", "series", "publisher", "original_title", "original_language", "pages", "license", "fandoms", "ships", "word_count", "source_url", "tags", "file_tags"}
+
+// CorrectionError describes a row of a corrections file which can't be applied.
+type CorrectionError struct {
+	// Row is the number of the row in the file, starting at 1.
+	Row int
+	Err error
+}
+
+func (e CorrectionError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// CorrectionReport describes the corrections read by ApplyCorrections.
+type CorrectionReport struct {
+	// Corrections is the number of corrections read, not counting the header.
+	Corrections int
+	// Books are the IDs of the books which were changed, or would have been in a dry run, in the order they were first corrected.
+	Books []int64
+	// Errors are the rows which couldn't be applied. If there are any, no corrections are applied.
+	Errors []CorrectionError
+}
+
+// correction is a change to one field of a book, read from a row of a corrections file.
+type correction struct {
+	row    int
+	bookID int64
+	hash   string
+	field  string
+	value  string
+}
+
+// ApplyCorrections reads a CSV file of corrections, and applies them to the books they name in a single transaction.
+// Each row has three columns: a book ID or the hash of one of the book's files, a field from CorrectionFields, and its new value.
+// A first row starting with book_id, id or hash is a header, and is skipped.
+// Corrections are checked before any of them are applied, and if any of them are invalid,
+// they're listed in the report and nothing is changed. If dryRun is true, nothing is changed either way.
+// Files are renamed with tmpl, as in UpdateBook, and series are always overwritten.
+// An error is only returned if the file can't be read, or the library can't be updated.
+func (lib *Library) ApplyCorrections(r io.Reader, tmpl *template.Template, dryRun bool) (CorrectionReport, error) {
+	var report CorrectionReport
+	corrections, err := readCorrections(r, &report)
+	if err != nil {
+		return report, err
+	}
+	report.Corrections = len(corrections)
+
+	tx, err := lib.Begin()
+	if err != nil {
+		return report, errors.Wrap(err, "begin transaction")
+	}
+	corrected := make(map[int64]*Book)
+	rows := make(map[int64][]int)
+	for _, c := range corrections {
+		if err := applyCorrection(tx, &c, corrected, &report); err != nil {
+			if ce, ok := err.(CorrectionError); ok {
+				report.Errors = append(report.Errors, ce)
+				continue
+			}
+			tx.Rollback()
+			return report, err
+		}
+		rows[c.bookID] = append(rows[c.bookID], c.row)
+	}
+	if len(report.Errors) == 0 {
+		for _, id := range report.Books {
+			err := lib.updateBook(tx, *corrected[id], tmpl, true)
+			if bee, ok := err.(BookExistsError); ok {
+				for _, row := range rows[id] {
+					report.Errors = append(report.Errors, CorrectionError{row, errors.Errorf("book %d would have the same title and authors as book %d", id, bee.BookID)})
+				}
+			} else if err != nil {
+				tx.Rollback()
+				return report, errors.Wrapf(err, "update book %d", id)
+			}
+		}
+	}
+	if len(report.Errors) > 0 || dryRun {
+		tx.Rollback()
+		return report, nil
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(report.Books...)
+	return report, errors.Wrap(err, "commit")
+}
+
+// readCorrections reads the rows of a corrections file.
+// Rows which can't be read are added to the report's errors.
+func readCorrections(r io.Reader, report *CorrectionReport) ([]correction, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var corrections []correction
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				report.Errors = append(report.Errors, CorrectionError{row, err})
+				continue
+			}
+			return nil, errors.Wrap(err, "read corrections")
+		}
+		if row == 1 && len(record) > 0 {
+			// Spreadsheets often start a CSV file with a byte order mark.
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+			switch strings.ToLower(strings.TrimSpace(record[0])) {
+			case "book_id", "id", "hash":
+				continue
+			}
+		}
+		if len(record) != 3 {
+			report.Errors = append(report.Errors, CorrectionError{row, errors.Errorf("expected 3 columns, got %d", len(record))})
+			continue
+		}
+		c := correction{row: row, field: strings.ToLower(strings.TrimSpace(record[1])), value: strings.TrimSpace(record[2])}
+		book := strings.ToLower(strings.TrimSpace(record[0]))
+		if isHash(book) {
+			c.hash = book
+		} else if c.bookID, err = strconv.ParseInt(book, 10, 64); err != nil || c.bookID <= 0 {
+			report.Errors = append(report.Errors, CorrectionError{row, errors.Errorf("%q isn't a book ID or hash", record[0])})
+			continue
+		}
+		corrections = append(corrections, c)
+	}
+	return corrections, nil
+}
+
+// applyCorrection changes the field of the book in corrected, getting the book first if it hasn't been corrected yet.
+// If the book is named by a hash, c.bookID is set to its ID.
+// Invalid corrections are returned as a CorrectionError.
+func applyCorrection(tx *sql.Tx, c *correction, corrected map[int64]*Book, report *CorrectionReport) error {
+	if c.hash != "" {
+		err := tx.QueryRow("select book_id from files where hash=?", c.hash).Scan(&c.bookID)
+		if err == sql.ErrNoRows {
+			return CorrectionError{c.row, errors.Errorf("no file has hash %s", c.hash)}
+		} else if err != nil {
+			return errors.Wrapf(err, "find file with hash %s", c.hash)
+		}
+	}
+	book, ok := corrected[c.bookID]
+	if !ok {
+		books, err := getBooksByID(tx, []int64{c.bookID})
+		if err != nil {
+			return errors.Wrapf(err, "get book %d", c.bookID)
+		}
+		if len(books) == 0 {
+			return CorrectionError{c.row, errors.Errorf("book %d not found", c.bookID)}
+		}
+		book = &books[0]
+		corrected[c.bookID] = book
+		report.Books = append(report.Books, c.bookID)
+	}
+
+	switch c.field {
+	case "title":
+		if c.value == "" {
+			return CorrectionError{c.row, errors.New("title can't be empty")}
+		}
+		book.Title = c.value
+	case "authors":
+		var authors []string
+		for _, a := range authorSeparatorRegexp.Split(c.value, -1) {
+			if a = strings.TrimSpace(a); a != "" {
+				authors = append(authors, a)
+			}
+		}
+		if len(authors) == 0 {
+			return CorrectionError{c.row, errors.New("a book must have at least one author")}
+		}
+		book.Authors = authors
+	case "subtitle":
+		book.Subtitle = c.value
+	case "series":
+		book.Series = c.value
+	case "publisher":
+		book.Publisher = c.value
+	case "original_title":
+		book.OriginalTitle = c.value
+	case "original_language":
+		book.OriginalLanguage = c.value
+	case "pages":
+		pages, err := strconv.Atoi(c.value)
+		if c.value == "" {
+			pages, err = 0, nil
+		}
+		if err != nil || pages < 0 {
+			return CorrectionError{c.row, errors.Errorf("%q isn't a number of pages", c.value)}
+		}
+		book.Pages = pages
+	case "fandoms":
+		book.Fandoms = SplitFanficTags(c.value)
+		if book.Fandoms == nil {
+			book.Fandoms = []string{}
+		}
+	case "ships":
+		book.Ships = SplitFanficTags(c.value)
+		if book.Ships == nil {
+			book.Ships = []string{}
+		}
+	case "word_count":
+		words := 0
+		if c.value != "" && c.value != "0" {
+			if words = ParseWordCount(c.value); words == 0 {
+				return CorrectionError{c.row, errors.Errorf("%q isn't a word count", c.value)}
+			}
+		}
+		book.WordCount = words
+	case "source_url":
+		book.SourceURL = c.value
+	case "license":
+		license, err := NormalizeLicense(c.value)
+		if err != nil {
+			return CorrectionError{c.row, err}
+		}
+		book.License = license
+	case "tags":
+		book.Tags = splitCorrectionTags(c.value)
+	case "file_tags":
+		if c.hash == "" {
+			return CorrectionError{c.row, errors.New("file_tags needs the hash of a file, not a book ID")}
+		}
+		for i := range book.Files {
+			if book.Files[i].Hash == c.hash {
+				book.Files[i].Tags = splitCorrectionTags(c.value)
+			}
+		}
+	default:
+		return CorrectionError{c.row, errors.Errorf("unknown field %q; must be one of %s", c.field, strings.Join(CorrectionFields, ", "))}
+	}
+	return nil
+}
+
+// splitCorrectionTags splits a comma separated list of tags.
+func splitCorrectionTags(s string) []string {
+	tags := make([]string, 0)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,166 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+)
+
+// ErrNoCover is returned by BookCover when none of a book's files has a cover.
+var ErrNoCover = errors.New("book has no cover")
+
+// noCoverExt is the extension of the file cached for an EPUB without a cover by older versions, before derivations were recorded.
+const noCoverExt = ".none"
+
+// BookCover returns the filename of an image of a book's cover, from the first of its files which has one.
+// EPUB covers are the image the file names as its cover, and are cached as derivations of the file in the covers directory next to the library.
+// PDF covers are the first page, rendered by RenderPDFPreview.
+func (lib *Library) BookCover(bookID int64) (string, error) {
+	fn, _, err := lib.bookCover(bookID)
+	return fn, err
+}
+
+// bookCover returns the filename of a book's cover, and the file it's from.
+func (lib *Library) bookCover(bookID int64) (string, BookFile, error) {
+	books, err := lib.GetBooksByID([]int64{bookID})
+	if err != nil {
+		return "", BookFile{}, errors.Wrap(err, "get book")
+	}
+	if len(books) == 0 {
+		return "", BookFile{}, ErrBookNotFound
+	}
+	for _, file := range books[0].Files {
+		if file.Missing || !GetFormatCapabilities(file.Extension).CoverExtraction {
+			continue
+		}
+		var fn string
+		if strings.ToLower(file.Extension) == "pdf" {
+			fn, err = lib.RenderPDFPreview(file.ID, 1, 0)
+		} else {
+			fn, err = lib.epubCover(file)
+		}
+		if err == ErrNoCover {
+			continue
+		}
+		return fn, file, err
+	}
+	return "", BookFile{}, ErrNoCover
+}
+
+// epubCover extracts the cover image of an EPUB file, and returns the filename it's cached in.
+func (lib *Library) epubCover(file BookFile) (string, error) {
+	if d, found, err := lib.GetDerivation(file.Hash, DerivationCover, ""); err != nil {
+		return "", err
+	} else if found {
+		if d.Filename == "" {
+			return "", ErrNoCover
+		}
+		return d.Filename, nil
+	}
+	dir := lib.paths.Covers
+	// Covers extracted before derivations were recorded are only found by their names.
+	if matches, err := filepath.Glob(filepath.Join(dir, file.Hash+".*")); err == nil && len(matches) > 0 {
+		d := Derivation{Hash: file.Hash, Kind: DerivationCover, Filename: matches[0]}
+		if filepath.Ext(matches[0]) == noCoverExt {
+			d.Filename = ""
+		}
+		if err := lib.SaveDerivation(d); err != nil {
+			return "", err
+		}
+		if d.Filename == "" {
+			return "", ErrNoCover
+		}
+		return matches[0], nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "create covers directory")
+	}
+
+	src, release, err := lib.CachedFilePath(file)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	book, err := epub.Open(src)
+	if err != nil {
+		return "", errors.Wrapf(err, "open file %d", file.ID)
+	}
+	defer book.Close()
+	href := epubCoverHref(book.Opf)
+	if href == "" {
+		// Remember that there's no cover, so the file isn't opened every time it's asked for.
+		if err := lib.SaveDerivation(Derivation{Hash: file.Hash, Kind: DerivationCover}); err != nil {
+			return "", err
+		}
+		return "", ErrNoCover
+	}
+	r, err := book.Open(href)
+	if err != nil {
+		return "", errors.Wrapf(err, "open cover of file %d", file.ID)
+	}
+	defer r.Close()
+
+	// Write to a temporary file, so a partly extracted image is never served from the cache.
+	tmp, err := ioutil.TempFile(dir, "cover-*")
+	if err != nil {
+		return "", errors.Wrap(err, "create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "extract cover of file %d", file.ID)
+	}
+	fn := filepath.Join(dir, file.Hash+strings.ToLower(path.Ext(href)))
+	if err := os.Rename(tmp.Name(), fn); err != nil {
+		return "", errors.Wrap(err, "cache cover")
+	}
+	if err := lib.SaveDerivation(Derivation{Hash: file.Hash, Kind: DerivationCover, Filename: fn}); err != nil {
+		return "", err
+	}
+	return fn, nil
+}
+
+// epubCoverHref returns the location of the cover image in an EPUB package, relative to the package document,
+// or an empty string if there isn't one.
+// EPUB 3 marks the cover in the manifest, and EPUB 2 names it in a cover meta element.
+func epubCoverHref(opf epub.Opf) string {
+	var coverID string
+	for _, m := range opf.Metadata.Meta {
+		if m.Name == "cover" {
+			coverID = m.Content
+		}
+	}
+	for _, item := range opf.Manifest {
+		if !strings.HasPrefix(item.MediaType, "image/") {
+			continue
+		}
+		isCover := item.ID == coverID
+		for _, p := range strings.Fields(item.Properties) {
+			if p == "cover-image" {
+				isCover = true
+			}
+		}
+		if !isCover {
+			continue
+		}
+		if href, err := url.PathUnescape(item.Href); err == nil {
+			return href
+		}
+		return item.Href
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// UpdateFile updates the tags of an existing file, specified by file.ID.
+// The file's filename is generated again from tmpl, since it may include the tags,
+// and its book is indexed again in search.
+func (lib *Library) UpdateFile(file BookFile, tmpl *template.Template) error {
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	book, err := bookOfFile(tx, file.ID)
+	if err != nil {
+		return err
+	}
+	for i, f := range book.Files {
+		if f.ID == file.ID {
+			book.Files[i].Tags = file.Tags
+		}
+	}
+	// Book tags and contributors are left alone when they're nil.
+	book.Tags, book.Contributors = nil, nil
+	if err := lib.updateBook(tx, book, tmpl, true); err != nil {
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(book.ID)
+	return errors.Wrap(err, "commit")
+}
+
+// DeleteFile removes a file from the library, and from the books root, unless another file has the same contents.
+// Its book is kept, even if it has no files left; use DeleteBook to remove a book.
+func (lib *Library) DeleteFile(fileID int64) error {
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	var bookID int64
+	var hash string
+	if err := tx.QueryRow("select book_id, hash from files where id=?", fileID).Scan(&bookID, &hash); err == sql.ErrNoRows {
+		return ErrFileNotFound
+	} else if err != nil {
+		return errors.Wrap(err, "get file")
+	}
+	// The file's tags are removed by foreign keys, and its book is indexed again by a trigger.
+	if _, err := tx.Exec("delete from files where id=?", fileID); err != nil {
+		return errors.Wrap(err, "delete file")
+	}
+	if _, err := tx.Exec("update books set updated_on=datetime() where id=?", bookID); err != nil {
+		return errors.Wrap(err, "update book")
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	lib.removeUnusedFiles([]string{hash})
+	log.Printf("Deleted file %d of book %d", fileID, bookID)
+	return nil
+}
+
+// DeleteBook removes a book and all of its files from the library,
+// and removes the files from the books root, unless other files have the same contents.
+func (lib *Library) DeleteBook(id int64) error {
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	var found int64
+	if err := tx.QueryRow("select id from books where id=?", id).Scan(&found); err == sql.ErrNoRows {
+		return ErrBookNotFound
+	} else if err != nil {
+		return errors.Wrap(err, "get book")
+	}
+	hashes, err := deleteBooks(tx, []int64{id})
+	if err != nil {
+		return errors.Wrap(err, "delete book")
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(id)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	lib.removeUnusedFiles(hashes)
+	log.Printf("Deleted book %d", id)
+	return nil
+}
+
+// bookOfFile returns the book a file belongs to.
+func bookOfFile(tx *sql.Tx, fileID int64) (Book, error) {
+	var bookID int64
+	if err := tx.QueryRow("select book_id from files where id=?", fileID).Scan(&bookID); err == sql.ErrNoRows {
+		return Book{}, ErrFileNotFound
+	} else if err != nil {
+		return Book{}, errors.Wrap(err, "get book of file")
+	}
+	books, err := getBooksByID(tx, []int64{bookID})
+	if err != nil {
+		return Book{}, errors.Wrap(err, "get book")
+	}
+	if len(books) == 0 {
+		return Book{}, ErrBookNotFound
+	}
+	return books[0], nil
+}
+
+// deleteBooks removes books and everything belonging to them from the library,
+// returning the hashes of the removed files.
+// The files themselves aren't removed from the books root; see removeUnusedFiles.
+func deleteBooks(tx *sql.Tx, ids []int64) ([]string, error) {
+	rows, err := tx.Query("select hash from files where book_id in (" + joinInt64s(ids, ",") + ")")
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+	// Files, authors, identifiers, and search index entries are removed by foreign keys and triggers.
+	if _, err := tx.Exec("delete from books where id in (" + joinInt64s(ids, ",") + ")"); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// removeUnusedFiles removes files with the given hashes from the books roots, unless another file in a books root has the same hash.
+// Errors are logged, since the files' records have already been removed.
+func (lib *Library) removeUnusedFiles(hashes []string) {
+	for _, hash := range hashes {
+		var n int
+		if err := lib.QueryRow("select count(*) from files where hash=? and path=''", hash).Scan(&n); err != nil {
+			log.Printf("Cannot check for other files with hash %s: %s", hash, err)
+			continue
+		}
+		if n > 0 {
+			continue
+		}
+		bf := BookFile{Hash: hash}
+		for _, r := range lib.roots {
+			if !r.Available() {
+				continue
+			}
+			if err := os.Remove(filepath.Join(r.Path, bf.HashPath())); err != nil && !os.IsNotExist(err) {
+				log.Printf("Cannot remove file with hash %s: %s", hash, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of derivations.
+const (
+	// DerivationCover is the cover image extracted from an EPUB. Its filename is empty if the EPUB has no cover.
+	DerivationCover = "cover"
+	// DerivationPDFPreview is a rendered page of a PDF, with the page and dpi as its parameters.
+	DerivationPDFPreview = "pdf-preview"
+	// DerivationTextPreview is an opening excerpt of a book, with the number of characters as its parameters.
+	DerivationTextPreview = "text-preview"
+	// DerivationConversion is a book converted to another format, with the format's extension as its parameters.
+	DerivationConversion = "conversion"
+)
+
+// A Derivation is something made from the contents of a file, such as a cover image or a converted book,
+// which is cached so it only has to be made once.
+// Derivations are identified by the hash of the file they were made from, rather than the file's ID or name,
+// so they stay valid when the file is renamed or moved to another book, and are shared by files with the same contents.
+type Derivation struct {
+	// Hash is the hash of the file the derivation was made from.
+	Hash string
+	// Kind is what was made, such as DerivationCover.
+	Kind string
+	// Params are the parameters it was made with, such as the page of a preview, or an empty string.
+	Params string
+	// Filename is the absolute path of the file it's cached in, or empty if it has no file.
+	Filename string
+	// OutputHash is the hash of the file it's cached in, which is only calculated for conversions,
+	// so that importing a copy of a converted book can be recognized.
+	OutputHash string
+	// CreatedOn is when it was made.
+	CreatedOn time.Time
+}
+
+// GetDerivation returns the derivation of kind made with params from the file with hash.
+// found is false if it hasn't been made, or its file has since been removed.
+func (lib *Library) GetDerivation(hash, kind, params string) (d Derivation, found bool, err error) {
+	d = Derivation{Hash: hash, Kind: kind, Params: params}
+	row, err := scanDerivationRow(lib.QueryRow("select "+derivationRowColumns+" from derivations where hash=? and kind=? and params=?", hash, kind, params))
+	if err == sql.ErrNoRows {
+		return d, false, nil
+	}
+	if err != nil {
+		return d, false, errors.Wrapf(err, "get %s of %s", kind, hash)
+	}
+	d = row.derivation()
+	if d.Filename == "" {
+		return d, true, nil
+	}
+	if _, err := os.Stat(d.Filename); os.IsNotExist(err) {
+		if _, err := lib.Exec("delete from derivations where hash=? and kind=? and params=?", hash, kind, params); err != nil {
+			return d, false, errors.Wrapf(err, "forget %s of %s", kind, hash)
+		}
+		return d, false, nil
+	} else if err != nil {
+		return d, false, err
+	}
+	return d, true, nil
+}
+
+// SaveDerivation records that d has been made, replacing any derivation of the same kind made with the same parameters from the same file.
+// A relative filename is made absolute, so the derivation can be found from any directory.
+// The output hash of a conversion is calculated if it isn't set.
+func (lib *Library) SaveDerivation(d Derivation) error {
+	if d.Filename != "" {
+		fn, err := filepath.Abs(d.Filename)
+		if err != nil {
+			return err
+		}
+		d.Filename = fn
+	}
+	if d.Kind == DerivationConversion && d.Filename != "" && d.OutputHash == "" {
+		hash, err := hashFile(d.Filename)
+		if err != nil {
+			return errors.Wrapf(err, "hash %s of %s", d.Kind, d.Hash)
+		}
+		d.OutputHash = hash
+	}
+	outputHash := sql.NullString{String: d.OutputHash, Valid: d.OutputHash != ""}
+	_, err := lib.Exec("insert or replace into derivations (hash, kind, params, filename, output_hash) values(?, ?, ?, ?, ?)", d.Hash, d.Kind, d.Params, d.Filename, outputHash)
+	return errors.Wrapf(err, "save %s of %s", d.Kind, d.Hash)
+}
+
+// cachedDerivationFile returns the filename of the derivation of kind made with params from the file with hash.
+// Files cached at fn before derivations were recorded are recorded when they're found, so they don't have to be made again.
+func (lib *Library) cachedDerivationFile(hash, kind, params, fn string) (string, bool, error) {
+	d, found, err := lib.GetDerivation(hash, kind, params)
+	if err != nil || found {
+		return d.Filename, found, err
+	}
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	if err := lib.SaveDerivation(Derivation{Hash: hash, Kind: kind, Params: params, Filename: fn}); err != nil {
+		return "", false, err
+	}
+	return fn, true, nil
+}
+
+// deleteUnusedDerivations forgets the derivations of files no longer in the library,
+// and returns the names of their files, to be removed once the transaction is committed.
+func deleteUnusedDerivations(tx *sql.Tx) ([]string, error) {
+	filenames, err := queryStrings(tx, "select filename from derivations where filename != '' and hash not in (select hash from files) order by filename")
+	if err != nil {
+		return nil, errors.Wrap(err, "find unused derivations")
+	}
+	if _, err := tx.Exec("delete from derivations where hash not in (select hash from files)"); err != nil {
+		return nil, errors.Wrap(err, "delete unused derivations")
+	}
+	if _, err := tx.Exec("delete from cover_palettes where hash not in (select hash from files)"); err != nil {
+		return nil, errors.Wrap(err, "delete unused cover palettes")
+	}
+	return filenames, nil
+}
+
+// convertedBookID returns the book with a file which was converted to a file with hash, if there is one.
+func convertedBookID(tx *sql.Tx, hash string) (id int64, found bool, err error) {
+	err = tx.QueryRow("select f.book_id from derivations d join files f on f.hash=d.hash where d.kind=? and d.output_hash=? order by f.id limit 1", DerivationConversion, hash).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, errors.Wrap(err, "find converted book")
+	}
+	return id, true, nil
+}
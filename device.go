@@ -0,0 +1,208 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DeviceSyncOptions controls how books are copied to an e-reader.
+type DeviceSyncOptions struct {
+	// Root is where the device is mounted.
+	Root string
+	// Dir is the directory, relative to Root, to copy books to.
+	Dir string
+	// Template names each file on the device, relative to Dir.
+	Template *template.Template
+	// Extensions limits the files copied to those with one of these extensions.
+	// If empty, all of a book's files are copied.
+	Extensions []string
+	// FilenamePolicy is applied to the paths of the files on the device, relative to Root,
+	// such as FilenamePolicyFAT32 for an SD card.
+	FilenamePolicy FilenamePolicy
+	// NumberSeries copies the books of each series in order, and starts their filenames with their zero-padded position in it,
+	// such as "02 - ", so devices which sort by filename list them in order.
+	// Books are numbered by their series index, or if any of the synced books of a series doesn't have one, from 1 in order.
+	NumberSeries bool
+	// IncludeExpiredIssues copies issues of periodicals which their retention policies no longer keep, but which haven't been removed yet.
+	// By default, they're left off the device.
+	IncludeExpiredIssues bool
+}
+
+// DeviceFile is a file which was copied to a device by SyncToDevice.
+type DeviceFile struct {
+	Book Book
+	File BookFile
+	// Path is the file's location on the device, relative to the device's root.
+	Path string
+}
+
+// SyncToDevice copies the files of the given books to a device.
+// Files which already exist on the device with the same size are not copied again.
+// All synced files, including ones which were already on the device, are returned.
+// Issues of periodicals which their retention policies no longer keep aren't copied, unless opts.IncludeExpiredIssues is set.
+func (lib *Library) SyncToDevice(bookIDs []int64, opts DeviceSyncOptions) ([]DeviceFile, error) {
+	if opts.Template == nil {
+		return nil, errors.New("no template for device filenames")
+	}
+	if !opts.IncludeExpiredIssues {
+		var err error
+		if bookIDs, err = lib.withoutExpiredIssues(bookIDs); err != nil {
+			return nil, err
+		}
+	}
+	// Books are copied in the order they were given, or of their series, which devices which list books by when they were added can use.
+	books, err := lib.GetBooksByID(bookIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	var prefixes map[int64]string
+	if opts.NumberSeries {
+		books, prefixes = numberSeries(books)
+	}
+
+	var synced []DeviceFile
+	for _, book := range books {
+		for _, bf := range book.Files {
+			if !extensionAllowed(bf.Extension, opts.Extensions) {
+				continue
+			}
+			if bf.Missing {
+				log.Printf("Not syncing missing file %d", bf.ID)
+				continue
+			}
+			name, err := bf.Filename(opts.Template, &book)
+			if err != nil {
+				return synced, errors.Wrap(err, "get device filename")
+			}
+			if prefix, ok := prefixes[book.ID]; ok {
+				dir, base := path.Split(name)
+				name = dir + prefix + " - " + base
+			}
+			rel := TruncateFilename(filepath.FromSlash(opts.FilenamePolicy.Sanitize(path.Join(filepath.ToSlash(opts.Dir), name))))
+			dst := filepath.Join(opts.Root, rel)
+			if fi, err := os.Stat(dst); err == nil && fi.Size() == bf.FileSize {
+				synced = append(synced, DeviceFile{book, bf, rel})
+				continue
+			}
+			src, err := lib.FilePath(bf)
+			if err != nil {
+				return synced, errors.Wrapf(err, "copy file %d to device", bf.ID)
+			}
+			if err := moveOrCopyFile(src, dst, false, nil); err != nil {
+				return synced, errors.Wrapf(err, "copy file %d to device", bf.ID)
+			}
+			synced = append(synced, DeviceFile{book, bf, rel})
+		}
+	}
+	return synced, nil
+}
+
+// withoutExpiredIssues returns ids without the issues of periodicals which their retention policies no longer keep.
+func (lib *Library) withoutExpiredIssues(ids []int64) ([]int64, error) {
+	expired, err := lib.ExpiredIssues()
+	if err != nil {
+		return nil, errors.Wrap(err, "get expired issues")
+	}
+	if len(expired) == 0 {
+		return ids, nil
+	}
+	isExpired := make(map[int64]bool, len(expired))
+	for _, id := range expired {
+		isExpired[id] = true
+	}
+	var kept []int64
+	for _, id := range ids {
+		if isExpired[id] {
+			log.Printf("Not syncing expired issue %d", id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	return kept, nil
+}
+
+// extensionAllowed returns true if ext is in allowed, ignoring case, or allowed is empty.
+func extensionAllowed(ext string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimPrefix(a, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// numberSeries returns books with the books of each series together, in order, where the first of them was,
+// and the filename prefixes of the books in a series, by ID.
+func numberSeries(books []Book) ([]Book, map[int64]string) {
+	series := make(map[string][]Book)
+	for _, b := range books {
+		if b.Series != "" {
+			key := strings.ToLower(b.Series)
+			series[key] = append(series[key], b)
+		}
+	}
+	prefixes := make(map[int64]string)
+	ordered := make([]Book, 0, len(books))
+	for _, b := range books {
+		if b.Series == "" {
+			ordered = append(ordered, b)
+			continue
+		}
+		key := strings.ToLower(b.Series)
+		group, ok := series[key]
+		if !ok {
+			// The series was already added.
+			continue
+		}
+		delete(series, key)
+		sort.SliceStable(group, func(i, j int) bool { return group[i].SeriesIndex < group[j].SeriesIndex })
+		positions := make([]float64, len(group))
+		numbered := true
+		for i, gb := range group {
+			positions[i] = gb.SeriesIndex
+			numbered = numbered && gb.SeriesIndex > 0
+		}
+		if !numbered {
+			for i := range positions {
+				positions[i] = float64(i + 1)
+			}
+		}
+		width := len(strconv.Itoa(int(positions[len(positions)-1])))
+		if width < 2 {
+			width = 2
+		}
+		for i, gb := range group {
+			prefixes[gb.ID] = seriesPrefix(positions[i], width)
+		}
+		ordered = append(ordered, group...)
+	}
+	return ordered, prefixes
+}
+
+// seriesPrefix formats a position in a series with its whole part zero-padded to width, such as 02 or 02.5.
+func seriesPrefix(position float64, width int) string {
+	s := strconv.FormatFloat(position, 'f', -1, 64)
+	whole := s
+	if i := strings.Index(s, "."); i >= 0 {
+		whole = s[:i]
+	}
+	if len(whole) < width {
+		s = strings.Repeat("0", width-len(whole)) + s
+	}
+	return s
+}
@@ -0,0 +1,228 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package email
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// imapClient is a minimal IMAP4rev1 client, supporting only what's needed to fetch and archive messages.
+type imapClient struct {
+	conn         net.Conn
+	r            *bufio.Reader
+	tag          int
+	capabilities map[string]bool
+}
+
+// imapResponse is an untagged response line, with any literals it contained removed and returned separately.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// dialIMAP connects to an IMAP server at addr (host:port), using TLS if useTLS is true.
+func dialIMAP(addr string, useTLS bool) (*imapClient, error) {
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = tls.Dial("tcp", addr, nil)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "read greeting")
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, errors.Errorf("unexpected greeting: %s", greeting)
+	}
+	return c, nil
+}
+
+// readLine reads a line from the server, without the trailing CRLF.
+func (c *imapClient) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readResponse reads a response line, along with any literals ({n} followed by n bytes) it contains.
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return resp, err
+		}
+		n, ok := literalSize(line)
+		if !ok {
+			resp.line += line
+			return resp, nil
+		}
+		resp.line += line[:strings.LastIndex(line, "{")]
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.literals = append(resp.literals, literal)
+	}
+}
+
+// literalSize returns the size of the literal a line ends with, if it ends with one.
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	i := strings.LastIndex(line, "{")
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(line[i+1 : len(line)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// command sends a command, and returns the untagged responses received before it completed.
+// An error is returned if the command doesn't complete with OK.
+func (c *imapClient) command(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	cmd := fmt.Sprintf(format, args...)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+	var untagged []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(resp.line, tag+" ") {
+			untagged = append(untagged, resp)
+			continue
+		}
+		status := strings.TrimPrefix(resp.line, tag+" ")
+		if !strings.HasPrefix(status, "OK") {
+			// Don't include the arguments, which could contain a password.
+			return untagged, errors.Errorf("%s: %s", strings.Fields(cmd)[0], status)
+		}
+		return untagged, nil
+	}
+}
+
+// quote quotes a string for use as an IMAP argument.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (c *imapClient) login(username, password string) error {
+	if _, err := c.command("LOGIN %s %s", quote(username), quote(password)); err != nil {
+		return err
+	}
+	resps, err := c.command("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	c.capabilities = make(map[string]bool)
+	for _, r := range resps {
+		if strings.HasPrefix(r.line, "* CAPABILITY ") {
+			for _, name := range strings.Fields(strings.TrimPrefix(r.line, "* CAPABILITY ")) {
+				c.capabilities[strings.ToUpper(name)] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (c *imapClient) selectMailbox(mailbox string) error {
+	_, err := c.command("SELECT %s", quote(mailbox))
+	return err
+}
+
+// searchUnseen returns the UIDs of messages in the selected mailbox which haven't been seen.
+func (c *imapClient) searchUnseen() ([]uint32, error) {
+	resps, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, r := range resps {
+		if !strings.HasPrefix(r.line, "* SEARCH") {
+			continue
+		}
+		for _, f := range strings.Fields(strings.TrimPrefix(r.line, "* SEARCH")) {
+			uid, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse UID %s", f)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the full contents of a message, without marking it as seen.
+func (c *imapClient) fetch(uid uint32) ([]byte, error) {
+	resps, err := c.command("UID FETCH %d (BODY.PEEK[])", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resps {
+		if strings.Contains(r.line, "FETCH") && len(r.literals) > 0 {
+			return r.literals[0], nil
+		}
+	}
+	return nil, errors.Errorf("message %d not returned", uid)
+}
+
+// markSeen marks a message as seen.
+func (c *imapClient) markSeen(uid uint32) error {
+	_, err := c.command(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid)
+	return err
+}
+
+// move moves a message to another mailbox, using MOVE if the server supports it, or COPY followed by deleting the message otherwise.
+// Only the copied message is expunged, which needs UIDPLUS; without it, the message is left flagged as deleted,
+// since a plain EXPUNGE would also remove every other message flagged as deleted in the mailbox.
+func (c *imapClient) move(uid uint32, mailbox string) error {
+	if c.capabilities["MOVE"] {
+		_, err := c.command("UID MOVE %d %s", uid, quote(mailbox))
+		return err
+	}
+	if _, err := c.command("UID COPY %d %s", uid, quote(mailbox)); err != nil {
+		return err
+	}
+	if _, err := c.command(`UID STORE %d +FLAGS.SILENT (\Seen \Deleted)`, uid); err != nil {
+		return err
+	}
+	if !c.capabilities["UIDPLUS"] {
+		return nil
+	}
+	_, err := c.command("UID EXPUNGE %d", uid)
+	return err
+}
+
+func (c *imapClient) logout() error {
+	c.command("LOGOUT")
+	return c.conn.Close()
+}
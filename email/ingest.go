@@ -0,0 +1,218 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+// Package email imports books sent as email attachments, by polling an IMAP mailbox.
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Config describes the mailbox to import books from.
+type Config struct {
+	// Addr is the host:port of the IMAP server.
+	Addr     string
+	Username string
+	Password string
+	// TLS connects to the server with TLS. Without it, the password is sent in plain text.
+	TLS bool
+	// Mailbox is checked for unseen messages. If empty, INBOX is used.
+	Mailbox string
+	// ArchiveMailbox is where messages are moved once their attachments are imported.
+	// If empty, messages are marked as seen and left in Mailbox.
+	ArchiveMailbox string
+	// Extensions limits the attachments imported to those with one of these extensions.
+	// If empty, all attachments are imported.
+	Extensions []string
+}
+
+// ImportFunc imports an attachment saved to filename.
+// source is email:<sender>, where sender is the address the message was sent from.
+// The file is removed once ImportFunc returns, if ImportFunc didn't move it.
+type ImportFunc func(filename, source string) error
+
+// PollStats counts the messages and attachments processed by Poll.
+type PollStats struct {
+	Messages    int
+	Attachments int
+	Imported    int
+	Errors      int
+}
+
+// Poll imports the attachments of each unseen message in the mailbox, then archives the message.
+// Messages are archived even if some of their attachments can't be imported; the errors are logged.
+func Poll(cfg Config, fn ImportFunc) (PollStats, error) {
+	var stats PollStats
+	mailbox := cfg.Mailbox
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	c, err := dialIMAP(cfg.Addr, cfg.TLS)
+	if err != nil {
+		return stats, errors.Wrap(err, "connect")
+	}
+	defer c.logout()
+	if err := c.login(cfg.Username, cfg.Password); err != nil {
+		return stats, errors.Wrap(err, "log in")
+	}
+	if err := c.selectMailbox(mailbox); err != nil {
+		return stats, errors.Wrapf(err, "select %s", mailbox)
+	}
+	uids, err := c.searchUnseen()
+	if err != nil {
+		return stats, errors.Wrap(err, "search for unseen messages")
+	}
+
+	for _, uid := range uids {
+		raw, err := c.fetch(uid)
+		if err != nil {
+			return stats, errors.Wrapf(err, "fetch message %d", uid)
+		}
+		stats.Messages++
+		if err := importMessage(raw, cfg.Extensions, fn, &stats); err != nil {
+			log.Printf("Cannot import attachments from message %d: %s", uid, err)
+			stats.Errors++
+		}
+		if cfg.ArchiveMailbox != "" {
+			err = c.move(uid, cfg.ArchiveMailbox)
+		} else {
+			err = c.markSeen(uid)
+		}
+		if err != nil {
+			return stats, errors.Wrapf(err, "archive message %d", uid)
+		}
+	}
+	return stats, nil
+}
+
+// importMessage saves each attachment of a message to a temporary directory, and passes it to fn.
+func importMessage(raw []byte, extensions []string, fn ImportFunc, stats *PollStats) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return errors.Wrap(err, "parse message")
+	}
+	sender := "unknown"
+	if from, err := mail.ParseAddress(msg.Header.Get("From")); err == nil {
+		sender = strings.ToLower(from.Address)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "books")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	return walkParts(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Header, msg.Body, func(name string, body io.Reader) error {
+		if !extensionAllowed(filepath.Ext(name), extensions) {
+			return nil
+		}
+		stats.Attachments++
+		filename := filepath.Join(tmpDir, name)
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return errors.Wrapf(err, "read attachment %s", name)
+		}
+		if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+			return errors.Wrapf(err, "save attachment %s", name)
+		}
+		if err := fn(filename, "email:"+sender); err != nil {
+			log.Printf("Cannot import %s from %s: %s", name, sender, err)
+			stats.Errors++
+		} else {
+			stats.Imported++
+		}
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// partHeader is implemented by both mail.Header and textproto.MIMEHeader.
+type partHeader interface {
+	Get(key string) string
+}
+
+// walkParts calls fn for each attachment in a MIME entity, decoding its transfer encoding.
+// Multipart entities are searched recursively.
+func walkParts(contentType, encoding string, header partHeader, body io.Reader, fn func(name string, body io.Reader) error) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return errors.Wrap(err, "read MIME part")
+			}
+			// multipart.Part decodes quoted-printable parts itself, removing their Content-Transfer-Encoding header.
+			if err := walkParts(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.Header, part, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	name := attachmentName(header, params)
+	if name == "" {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	return fn(name, body)
+}
+
+// attachmentName returns the file name of an attachment, taken from its Content-Disposition or Content-Type,
+// reduced to its base name so that it can't escape the directory it's saved in.
+// If the entity isn't an attachment with a name, an empty string is returned.
+func attachmentName(header partHeader, typeParams map[string]string) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = typeParams["name"]
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(name); err == nil {
+		name = decoded
+	}
+	name = filepath.Base(filepath.Clean("/" + strings.Replace(name, `\`, "/", -1)))
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
+
+// extensionAllowed returns true if ext is in allowed, ignoring case and leading dots, or allowed is empty.
+func extensionAllowed(ext string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimPrefix(a, "."), strings.TrimPrefix(ext, ".")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+)
+
+// ErrNoMetadata is returned when a file's embedded metadata doesn't include its title and authors.
+var ErrNoMetadata = errors.New("file has no title and authors in its metadata")
+
+// A MetadataExtractor reads the metadata embedded in ebook files, rather than guessing it from their names.
+// EPUB metadata is read from the package document, and PDF metadata from the document's info, with pdfinfo from Poppler.
+// Other formats are read with ebook-meta, from Calibre, if EbookMeta is true.
+type MetadataExtractor struct {
+	// EbookMeta falls back to ebook-meta for files which can't be read otherwise, including EPUBs and PDFs without a title and authors.
+	EbookMeta bool
+	// EbookMetaCommand is the ebook-meta command, "ebook-meta" if empty.
+	EbookMetaCommand string
+	// PdfinfoCommand is the pdfinfo command, "pdfinfo" if empty.
+	PdfinfoCommand string
+}
+
+// ExtractMetadata reads the metadata embedded in a file with the default MetadataExtractor, which doesn't use ebook-meta.
+// The title and authors are always set if the error is nil.
+func ExtractMetadata(fn string) (Book, error) {
+	return MetadataExtractor{}.Extract(fn)
+}
+
+// Extract reads the metadata embedded in a file: its title, authors and other contributors, and, if the format has them,
+// its series, publisher, identifiers, description, language and publication date.
+// The publication year of an EPUB without a date is taken from the copyright notice in its front matter, if it has one. The fandoms, ships, word count and source URL of fanfiction are read
+// from EPUBs made by Archive of Our Own and downloaders such as FanFicFare. The title and authors are always set if the error is nil.
+func (e MetadataExtractor) Extract(fn string) (Book, error) {
+	var book Book
+	var err error
+	switch strings.ToLower(path.Ext(fn)) {
+	case ".epub":
+		book, err = epubMetadata(fn)
+	case ".pdf":
+		book, err = e.pdfMetadata(fn)
+	default:
+		err = errors.Wrapf(ErrNoMetadata, "cannot read metadata of %s files", path.Ext(fn))
+	}
+	if err != nil && e.EbookMeta {
+		if book, ebookMetaErr := e.ebookMetadata(fn); ebookMetaErr == nil {
+			return book, nil
+		} else if errors.Cause(err) == ErrNoMetadata {
+			err = ebookMetaErr
+		}
+	}
+	return book, err
+}
+
+// epubMetadata reads the metadata in an EPUB's package document.
+func epubMetadata(fn string) (Book, error) {
+	var book Book
+	f, err := epub.Open(fn)
+	if err != nil {
+		return book, errors.Wrapf(err, "open %s", fn)
+	}
+	defer f.Close()
+
+	m := f.Opf.Metadata
+	if len(m.Title) > 0 {
+		book.Title = strings.TrimSpace(m.Title[0])
+	}
+	for _, author := range m.Creator {
+		if author.Data == "" {
+			continue
+		}
+		// Creators with unknown roles are treated as authors.
+		if role, ok := epubRoles[strings.ToLower(author.Role)]; ok && role != RoleAuthor {
+			book.Contributors = append(book.Contributors, Contributor{Name: author.Data, Role: role})
+		} else {
+			book.Authors = append(book.Authors, author.Data)
+		}
+	}
+	if book.Title == "" || len(book.Authors) == 0 {
+		return book, errors.Wrap(ErrNoMetadata, fn)
+	}
+	if len(m.Publisher) > 0 {
+		book.Publisher = strings.TrimSpace(m.Publisher[0])
+	}
+	if len(m.Description) > 0 {
+		// EPUB descriptions are often HTML.
+		book.Description = strings.Join(HTMLParagraphs(m.Description[0]), "\n\n")
+	}
+	if len(m.Language) > 0 {
+		book.Language = NormalizeLanguage(m.Language[0])
+	}
+	// The publication date is the date without an event, or the one whose event is publication.
+	for _, d := range m.Date {
+		if d.Event != "" && !strings.EqualFold(d.Event, "publication") {
+			continue
+		}
+		if book.Published = parsePublished(d.Data); book.Published != "" {
+			break
+		}
+	}
+	// Without one, the year is taken from the copyright page, if the book has one near the start.
+	if book.Published == "" {
+		if paragraphs, err := epubParagraphs(fn, MaxPreviewChars); err == nil {
+			book.Published = copyrightYear(paragraphs)
+		}
+	}
+	for _, id := range m.Identifier {
+		if ident, ok := parseEpubIdentifier(id.Scheme, id.Data); ok {
+			book.Identifiers = append(book.Identifiers, ident)
+		}
+	}
+	// Calibre records series in its own meta elements.
+	for _, meta := range m.Meta {
+		switch meta.Name {
+		case "calibre:series":
+			book.Series = strings.TrimSpace(meta.Content)
+		case "calibre:series_index":
+			book.SeriesIndex = parseSeriesIndex(meta.Content)
+		}
+	}
+	if err := epubFanficMetadata(f, &book); err != nil {
+		log.Printf("Cannot read fanfiction metadata of %s: %s", fn, err)
+	}
+	return book, nil
+}
+
+// pdfMetadata reads the title and author in a PDF's document info with pdfinfo.
+func (e MetadataExtractor) pdfMetadata(fn string) (Book, error) {
+	command := e.PdfinfoCommand
+	if command == "" {
+		command = "pdfinfo"
+	}
+	fields, err := commandFields(command, fn)
+	if err != nil {
+		return Book{}, err
+	}
+	book := Book{Title: fields["Title"]}
+	book.Authors, book.Contributors = splitContributors(ParseAuthors(fields["Author"]))
+	if book.Title == "" || len(book.Authors) == 0 {
+		return Book{}, errors.Wrap(ErrNoMetadata, fn)
+	}
+	return book, nil
+}
+
+// ebookMetaSeriesRegexp matches the series printed by ebook-meta, such as "Discworld #2".
+var ebookMetaSeriesRegexp = regexp.MustCompile(`^(.*?)\s*#([0-9.]+)$`)
+
+// ebookMetaSortRegexp matches the sort name ebook-meta prints after an author's name, such as "[Pratchett, Terry]".
+var ebookMetaSortRegexp = regexp.MustCompile(`\s*\[[^\]]*\]$`)
+
+// ebookMetadata reads a file's metadata with ebook-meta.
+func (e MetadataExtractor) ebookMetadata(fn string) (Book, error) {
+	command := e.EbookMetaCommand
+	if command == "" {
+		command = "ebook-meta"
+	}
+	fields, err := commandFields(command, fn)
+	if err != nil {
+		return Book{}, err
+	}
+	book := Book{Title: fields["Title"], Publisher: fields["Publisher"], Description: fields["Comments"]}
+	for _, name := range strings.Split(fields["Author(s)"], " & ") {
+		if name = strings.TrimSpace(ebookMetaSortRegexp.ReplaceAllString(name, "")); name != "" {
+			book.Authors = append(book.Authors, name)
+		}
+	}
+	if book.Title == "" || len(book.Authors) == 0 {
+		return Book{}, errors.Wrap(ErrNoMetadata, fn)
+	}
+	if m := ebookMetaSeriesRegexp.FindStringSubmatch(fields["Series"]); m != nil {
+		book.Series, book.SeriesIndex = m[1], parseSeriesIndex(m[2])
+	} else {
+		book.Series = fields["Series"]
+	}
+	// ebook-meta prints languages as a comma separated list.
+	if languages := strings.Split(fields["Languages"], ","); languages[0] != "" {
+		book.Language = NormalizeLanguage(languages[0])
+	}
+	book.Published = parsePublished(fields["Published"])
+	for _, id := range strings.Split(fields["Identifiers"], ",") {
+		parts := strings.SplitN(strings.TrimSpace(id), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if ident, ok := parseEpubIdentifier(parts[0], parts[1]); ok {
+			book.Identifiers = append(book.Identifiers, ident)
+		}
+	}
+	return book, nil
+}
+
+// commandFields runs a command which prints fields, one per line, as "Name: value" or "Name : value",
+// and returns them by name. Fields without values are left out.
+func commandFields(name string, args ...string) (map[string]string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrapf(err, "run %s: %s", name, msg)
+		}
+		return nil, errors.Wrapf(err, "run %s", name)
+	}
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if key != "" && value != "" {
+			fields[key] = value
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// mergeEmbeddedMetadata replaces the metadata of book, such as from its filename, with the metadata embedded in its file.
+// Fields the file's metadata doesn't have are kept.
+func mergeEmbeddedMetadata(book *Book, embedded Book) {
+	book.Title = embedded.Title
+	book.Authors = embedded.Authors
+	if len(embedded.Contributors) > 0 {
+		book.Contributors = embedded.Contributors
+	}
+	if embedded.Series != "" {
+		book.Series, book.SeriesIndex = embedded.Series, embedded.SeriesIndex
+	}
+	if embedded.Publisher != "" {
+		book.Publisher = embedded.Publisher
+	}
+	if embedded.Description != "" {
+		book.Description = embedded.Description
+	}
+	if embedded.Language != "" {
+		book.Language = embedded.Language
+	}
+	if embedded.Published != "" {
+		book.Published = embedded.Published
+	}
+	book.Identifiers = append(book.Identifiers, embedded.Identifiers...)
+	if embedded.SourceURL != "" {
+		book.SourceURL = embedded.SourceURL
+	}
+	if embedded.WordCount != 0 {
+		book.WordCount = embedded.WordCount
+	}
+	if len(embedded.Fandoms) > 0 {
+		book.Fandoms = embedded.Fandoms
+	}
+	if len(embedded.Ships) > 0 {
+		book.Ships = embedded.Ships
+	}
+}
+
+// EmbeddedMetadataParser is a MetadataParser which reads the metadata embedded in files with Extractor.
+type EmbeddedMetadataParser struct {
+	Extractor MetadataExtractor
+}
+
+// Parse parses the first file whose embedded metadata includes its title and authors.
+func (p *EmbeddedMetadataParser) Parse(files []string) (book Book, parsed bool) {
+	for _, file := range files {
+		book, err := p.Extractor.Extract(file)
+		if err == nil {
+			return book, true
+		}
+		if errors.Cause(err) != ErrNoMetadata {
+			log.Printf("Cannot read metadata of %s: %s", file, err)
+		}
+	}
+	return Book{}, false
+}
@@ -0,0 +1,225 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+)
+
+// Kinds of fanfiction tags, stored in the fanfic_tags table.
+const (
+	fanficFandom = "fandom"
+	fanficShip   = "ship"
+)
+
+// sqlIndexedFanficTags returns an SQL expression for the fanfiction tags of a kind, such as fandoms, indexed for the book with the given ID.
+func sqlIndexedFanficTags(kind, bookID string) string {
+	return `(select coalesce(group_concat(name, ' '), '') from fanfic_tags where kind = '` + kind + `' and book_id = ` + bookID + `)`
+}
+
+// getFanficTagsByBookIds returns the fandoms and ships of each book.
+func getFanficTagsByBookIds(tx *sql.Tx, ids []int64) (fandoms, ships map[int64][]string, err error) {
+	fandoms = make(map[int64][]string)
+	ships = make(map[int64][]string)
+	if len(ids) == 0 {
+		return fandoms, ships, nil
+	}
+	rows, err := tx.Query("select " + fanficTagRowColumns + " from fanfic_tags where book_id in (" + joinInt64s(ids, ",") + ") order by id")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row, err := scanFanficTagRow(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		if row.Kind == fanficShip {
+			ships[row.BookID] = append(ships[row.BookID], row.Name)
+		} else {
+			fandoms[row.BookID] = append(fandoms[row.BookID], row.Name)
+		}
+	}
+	return fandoms, ships, rows.Err()
+}
+
+// setFanficTags replaces the fanfiction tags of a kind of a book with names.
+func setFanficTags(tx *sql.Tx, bookID int64, kind string, names []string) error {
+	if _, err := tx.Exec("delete from fanfic_tags where book_id=? and kind=?", bookID, kind); err != nil {
+		return errors.Wrapf(err, "delete %ss", kind)
+	}
+	for _, name := range names {
+		if name = collapseSpace(name); name == "" {
+			continue
+		}
+		if _, err := tx.Exec("insert or ignore into fanfic_tags (book_id, kind, name) values(?, ?, ?)", bookID, kind, name); err != nil {
+			return errors.Wrapf(err, "insert %s %s", kind, name)
+		}
+	}
+	return nil
+}
+
+// mergeFanficTags gives the book with the first of ids the fandoms and ships of the others, and their word count and URL if it has none.
+func mergeFanficTags(tx *sql.Tx, ids []int64) error {
+	others := joinInt64s(ids[1:], ",")
+	if _, err := tx.Exec("update or ignore fanfic_tags set book_id=? where book_id in ("+others+")", ids[0]); err != nil {
+		return errors.Wrap(err, "merge fandoms and ships")
+	}
+	_, err := tx.Exec("update books set word_count=(select max(word_count) from books where id in ("+joinInt64s(ids, ",")+")) where id=? and word_count=0", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge word counts")
+	}
+	_, err = tx.Exec("update books set source_url=coalesce((select source_url from books where id in ("+others+") and source_url != '' order by id limit 1), '') where id=? and source_url=''", ids[0])
+	return errors.Wrap(err, "merge source URLs")
+}
+
+// SplitFanficTags splits a list of fandoms or ships, such as "Harry Potter, Naruto", at commas.
+func SplitFanficTags(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = collapseSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ParseWordCount parses a word count such as 12,345, returning 0 if it isn't one.
+func ParseWordCount(s string) int {
+	n, err := strconv.Atoi(strings.NewReplacer(",", "", ".", "", " ", "").Replace(strings.TrimSpace(s)))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// fanficLabels maps the labels fanfiction downloaders use on title pages, lowercased, to the field they label.
+// Archive of Our Own puts them in the preface of its EPUBs, and FanFicFare on the title page it adds.
+var fanficLabels = map[string]string{
+	"fandom":        fanficFandom,
+	"fandoms":       fanficFandom,
+	"category":      fanficFandom,
+	"categories":    fanficFandom,
+	"relationship":  fanficShip,
+	"relationships": fanficShip,
+	"ship":          fanficShip,
+	"ships":         fanficShip,
+	"pairing":       fanficShip,
+	"pairings":      fanficShip,
+	"story url":     "url",
+	"source":        "url",
+}
+
+var (
+	fanficLabelRegexp  = regexp.MustCompile(`(?i)^([a-z ]+):\s*(.*)$`)
+	fanficWordsRegexp  = regexp.MustCompile(`(?i)\bwords:\s*([0-9][0-9,.]*)`)
+	fanficPostedRegexp = regexp.MustCompile(`(?i)posted originally on the .+? at (https?://\S+?)\.?$`)
+	fanficURLRegexp    = regexp.MustCompile(`^https?://\S+$`)
+	// definitionRegexp matches the elements of definition lists, which Archive of Our Own lays its preface out with.
+	definitionRegexp = regexp.MustCompile(`(?i)</?(dl|dt|dd)\b[^>]*>`)
+)
+
+// fanficPages is the number of documents at the start of an EPUB searched for a title page with fanfiction metadata.
+const fanficPages = 3
+
+// epubFanficMetadata adds the fanfiction metadata of an EPUB made by a fanfiction downloader to book:
+// the source URL from its package document, and the fandoms, ships, word count and source URL from the title page
+// at the start of the book. The rest is only used if the source URL is found, so the title pages of other books
+// which happen to have similar labels don't give them fandoms.
+func epubFanficMetadata(f *epub.Book, book *Book) error {
+	m := f.Opf.Metadata
+	var url string
+	for _, s := range m.Source {
+		if s = strings.TrimSpace(s); fanficURLRegexp.MatchString(s) {
+			url = s
+			break
+		}
+	}
+	for _, id := range m.Identifier {
+		if d := strings.TrimSpace(id.Data); url == "" && fanficURLRegexp.MatchString(d) {
+			url = d
+		}
+	}
+
+	hrefs := make(map[string]string)
+	for _, item := range f.Opf.Manifest {
+		if isContentDocument(item) {
+			hrefs[item.ID] = item.Href
+		}
+	}
+	var fandoms, ships []string
+	words := 0
+	pages := 0
+	for _, item := range f.Opf.Spine.Items {
+		href, ok := hrefs[item.IDref]
+		if !ok {
+			continue
+		}
+		if pages++; pages > fanficPages {
+			break
+		}
+		r, err := f.Open(href)
+		if err != nil {
+			return errors.Wrapf(err, "open %s", href)
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(r, 1<<20))
+		r.Close()
+		if err != nil {
+			return errors.Wrapf(err, "read %s", href)
+		}
+		paragraphs := HTMLParagraphs(definitionRegexp.ReplaceAllString(string(b), "\n\n"))
+		for i, p := range paragraphs {
+			if m := fanficPostedRegexp.FindStringSubmatch(p); m != nil && url == "" {
+				url = m[1]
+			}
+			if m := fanficWordsRegexp.FindStringSubmatch(p); m != nil && words == 0 {
+				words = ParseWordCount(m[1])
+			}
+			m := fanficLabelRegexp.FindStringSubmatch(p)
+			if m == nil {
+				continue
+			}
+			field, ok := fanficLabels[strings.ToLower(strings.TrimSpace(m[1]))]
+			if !ok {
+				continue
+			}
+			value := m[2]
+			// Labels in definition lists are followed by their values in the next paragraph.
+			if value == "" && i+1 < len(paragraphs) {
+				value = paragraphs[i+1]
+			}
+			switch field {
+			case fanficFandom:
+				if fandoms == nil {
+					fandoms = SplitFanficTags(value)
+				}
+			case fanficShip:
+				if ships == nil {
+					ships = SplitFanficTags(value)
+				}
+			case "url":
+				if url == "" && fanficURLRegexp.MatchString(value) {
+					url = value
+				}
+			}
+		}
+	}
+	if url == "" {
+		return nil
+	}
+	book.SourceURL = url
+	book.Fandoms = fandoms
+	book.Ships = ships
+	book.WordCount = words
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// searchFilter limits search results by something which isn't in the search index, such as when a book was added.
+type searchFilter struct {
+	// term is the filter as written in the query.
+	term string
+	// where is an SQL condition on the books table, which is named b.
+	where string
+	arg   interface{}
+}
+
+// filterRegexp matches a filter in a search query, such as rating:>=4.
+var filterRegexp = regexp.MustCompile(`(?i)^(added|size|rating|words|license|year):(<=|>=|<|>|=)?(.+)$`)
+
+// sizeUnits are the multipliers of the units sizes can be given in, which are powers of 1024.
+var sizeUnits = map[string]float64{"": 1, "b": 1, "k": 1 << 10, "kb": 1 << 10, "m": 1 << 20, "mb": 1 << 20, "g": 1 << 30, "gb": 1 << 30}
+
+var sizeRegexp = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-z]*)$`)
+
+// parseSearchFilters separates the filters in a search query from the terms to look up in the search index.
+// Filters are written field:value or field:<value, with the operators <, <=, >, >= or =. Supported filters:
+// added, the date a book was added, as YYYY-MM-DD;
+// size, the size of one of a book's files, in bytes or with a unit such as 5mb;
+// rating, the book's rating, where books which aren't rated have a rating of 0;
+// words, the book's word count, where books without one have a word count of 0;
+// license, the book's license, where license:cc matches every Creative Commons license,
+// and license:shareable matches public domain and Creative Commons books, which can only use =;
+// and year, the year the book was published, which only matches books whose publication dates are known.
+// Year ranges can also be written year:1990..1999, which includes both years.
+func parseSearchFilters(terms string) (string, []searchFilter, error) {
+	var rest []string
+	var filters []searchFilter
+	for _, term := range strings.Fields(terms) {
+		m := filterRegexp.FindStringSubmatch(term)
+		if m == nil {
+			rest = append(rest, term)
+			continue
+		}
+		field, op, value := strings.ToLower(m[1]), m[2], m[3]
+		if op == "" {
+			op = "="
+		}
+		var f searchFilter
+		switch field {
+		case "added":
+			t, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return "", nil, errors.Errorf("invalid date %q in %s, expected YYYY-MM-DD", value, term)
+			}
+			f = searchFilter{where: "date(b.created_on) " + op + " ?", arg: t.Format("2006-01-02")}
+		case "size":
+			size, err := ParseSize(value)
+			if err != nil {
+				return "", nil, errors.Wrapf(err, "invalid size in %s", term)
+			}
+			f = searchFilter{where: "exists (select 1 from files f where f.book_id = b.id and f.file_size " + op + " ?)", arg: size}
+		case "rating":
+			rating, err := strconv.Atoi(value)
+			if err != nil || rating < 0 || rating > MaxRating {
+				return "", nil, errors.Errorf("invalid rating %q in %s, expected 0 to %d", value, term, MaxRating)
+			}
+			f = searchFilter{where: "b.rating " + op + " ?", arg: rating}
+		case "words":
+			words, err := strconv.Atoi(strings.Replace(value, ",", "", -1))
+			if err != nil || words < 0 {
+				return "", nil, errors.Errorf("invalid word count %q in %s", value, term)
+			}
+			f = searchFilter{where: "b.word_count " + op + " ?", arg: words}
+		case "year":
+			var err error
+			if f, err = yearFilter(op, value); err != nil {
+				return "", nil, errors.Wrapf(err, "invalid year in %s", term)
+			}
+		case "license":
+			if op != "=" {
+				return "", nil, errors.Errorf("invalid operator in %s: licenses can only be compared with =", term)
+			}
+			f = licenseFilter(value)
+			if f.where == "" {
+				license, err := NormalizeLicense(value)
+				if err != nil {
+					return "", nil, errors.Wrapf(err, "invalid license in %s", term)
+				}
+				f = searchFilter{where: "b.license = ?", arg: string(license)}
+			}
+		}
+		f.term = term
+		filters = append(filters, f)
+	}
+	return strings.Join(rest, " "), filters, nil
+}
+
+// ParseSize parses a size such as 512, 20kb or 1.5mb into bytes.
+func ParseSize(s string) (int64, error) {
+	m := sizeRegexp.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, errors.Errorf("%q isn't a size", s)
+	}
+	unit, ok := sizeUnits[m[2]]
+	if !ok {
+		return 0, errors.Errorf("unknown unit %q", m[2])
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, errors.Errorf("%q isn't a size", s)
+	}
+	return int64(n * unit), nil
+}
+
+// licenseFilter returns the filter for the license:cc and license:shareable filters, which match more than one license.
+// For other licenses, the filter's condition is empty.
+func licenseFilter(value string) searchFilter {
+	cc := "b.license = 'cc0' or b.license like 'cc-%'"
+	switch strings.ToLower(value) {
+	case "cc":
+		return searchFilter{where: "(b.license = ? or " + cc + ")", arg: string(LicenseCC)}
+	case "shareable":
+		return searchFilter{where: "(b.license = ? or b.license = 'cc' or " + cc + ")", arg: string(LicensePublicDomain)}
+	}
+	return searchFilter{}
+}
+
+// sqlPublishedYear is an SQL expression for the year the book b was published, which is only meaningful if its date is known.
+const sqlPublishedYear = "cast(substr(b.published, 1, 4) as integer)"
+
+// yearFilter returns the filter for the year filter, compared with op, or a range such as 1990..1999 if op is =.
+func yearFilter(op, value string) (searchFilter, error) {
+	parseYear := func(s string) (int, error) {
+		year, err := strconv.Atoi(s)
+		if err != nil || year < 1 || year > 9999 {
+			return 0, errors.Errorf("%q isn't a year", s)
+		}
+		return year, nil
+	}
+	if parts := strings.SplitN(value, "..", 2); len(parts) == 2 {
+		if op != "=" {
+			return searchFilter{}, errors.New("year ranges can't be compared with an operator")
+		}
+		from, err := parseYear(parts[0])
+		if err != nil {
+			return searchFilter{}, err
+		}
+		to, err := parseYear(parts[1])
+		if err != nil {
+			return searchFilter{}, err
+		}
+		return searchFilter{where: "(b.published != '' and " + sqlPublishedYear + " between " + strconv.Itoa(from) + " and ?)", arg: to}, nil
+	}
+	year, err := parseYear(value)
+	if err != nil {
+		return searchFilter{}, err
+	}
+	return searchFilter{where: "(b.published != '' and " + sqlPublishedYear + " " + op + " ?)", arg: year}, nil
+}
+
+// filterTerms returns the filters as written in the query they were parsed from.
+func filterTerms(filters []searchFilter) string {
+	terms := make([]string, len(filters))
+	for i, f := range filters {
+		terms[i] = f.term
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchQuery returns a query selecting searchHitColumns for the books matching terms and filters, and its arguments.
+// If there are filters but no terms, every book matching the filters is returned, in the order they were added.
+func searchQuery(terms string, filters []searchFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	columns := searchHitColumns
+	if terms != "" || len(filters) == 0 {
+		where = append(where, "books_fts match ?")
+		args = append(args, terms)
+	} else {
+		// offsets and snippet need a full text match.
+		columns = "docid, title, '', ''"
+	}
+	if len(filters) > 0 {
+		var conditions []string
+		for _, f := range filters {
+			conditions = append(conditions, f.where)
+			args = append(args, f.arg)
+		}
+		where = append(where, "docid in (select b.id from books b where "+strings.Join(conditions, " and ")+")")
+	}
+	query := "select " + columns + " from books_fts where " + strings.Join(where, " and ")
+	if terms == "" && len(filters) > 0 {
+		query += " order by docid"
+	}
+	return query, args
+}
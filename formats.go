@@ -0,0 +1,70 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"sort"
+	"strings"
+)
+
+// FormatCapabilities describes what the library can do with files of a format.
+type FormatCapabilities struct {
+	// Extension is the format's file extension, without a dot, in lower case.
+	Extension string
+	// MetadataExtraction is true if titles, authors and subjects can be read from the file itself, rather than its filename.
+	MetadataExtraction bool
+	// CoverExtraction is true if a cover image can be made from the file, with BookCover.
+	// EPUB covers are the image the file names as its cover, and PDF covers are rendered from the first page.
+	CoverExtraction bool
+	// ConversionSource is true if the file can be converted to EPUB with ebook-convert.
+	ConversionSource bool
+	// ConversionTarget is true if other formats can be converted to this one.
+	ConversionTarget bool
+	// ContentIndexing is true if the text of the file can be read, for previews with GetPreview.
+	ContentIndexing bool
+}
+
+// conversionSources are the formats ebook-convert, from calibre, can convert to EPUB.
+var conversionSources = []string{"azw", "azw3", "azw4", "cb7", "cbr", "cbz", "chm", "djvu", "docx", "fb2", "fbz", "htm", "html", "htmlz",
+	"lit", "lrf", "mobi", "odt", "pdb", "pdf", "pml", "prc", "rb", "rtf", "snb", "tcr", "txt", "txtz"}
+
+// formatCapabilities holds the capabilities of every format the library can do something with, by extension.
+var formatCapabilities = func() map[string]FormatCapabilities {
+	m := map[string]FormatCapabilities{
+		"epub": {Extension: "epub", MetadataExtraction: true, CoverExtraction: true, ConversionTarget: true, ContentIndexing: true},
+	}
+	for _, ext := range conversionSources {
+		m[ext] = FormatCapabilities{Extension: ext, ConversionSource: true}
+	}
+	pdf := m["pdf"]
+	pdf.CoverExtraction = true
+	m["pdf"] = pdf
+	txt := m["txt"]
+	txt.ContentIndexing = true
+	m["txt"] = txt
+	return m
+}()
+
+// SupportedFormats returns the capabilities of every format the library can do something with, sorted by extension.
+// Files of other formats can still be imported, but can only be downloaded.
+func SupportedFormats() []FormatCapabilities {
+	formats := make([]FormatCapabilities, 0, len(formatCapabilities))
+	for _, c := range formatCapabilities {
+		formats = append(formats, c)
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i].Extension < formats[j].Extension })
+	return formats
+}
+
+// GetFormatCapabilities returns the capabilities of the format with the given extension.
+// The extension may start with a dot, and is case insensitive.
+// Unsupported formats have no capabilities.
+func GetFormatCapabilities(ext string) FormatCapabilities {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if c, ok := formatCapabilities[ext]; ok {
+		return c
+	}
+	return FormatCapabilities{Extension: ext}
+}
@@ -0,0 +1,14 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package books
+
+// freeSpace returns the number of bytes available on the filesystem holding dir.
+// The free space isn't known on this platform, so ok is always false.
+func freeSpace(dir string) (free uint64, ok bool, err error) {
+	return 0, false, nil
+}
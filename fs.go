@@ -1,6 +1,7 @@
 package books
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"log"
@@ -40,68 +41,249 @@ func TruncateFilename(fn string) string {
 	return filepath.Join(lst...)
 }
 
+// Permissions controls the modes and owner of the files and directories the library creates in the books root.
+type Permissions struct {
+	// FileMode is the mode of files imported into the books root.
+	// If it is 0, copied files are created with mode 0644, less the umask, and moved files keep their modes.
+	// Otherwise, files are set to exactly FileMode, regardless of the umask.
+	FileMode os.FileMode
+	// DirMode is the mode of directories created in the books root.
+	// If it is 0, directories are created with mode 0755, less the umask.
+	// Otherwise, they are set to exactly DirMode, regardless of the umask.
+	DirMode os.FileMode
+	// Chown changes the owner of the files and directories to UID and GID.
+	// A UID or GID of -1 leaves that ID unchanged.
+	// This usually needs root, or for the process to already be in the group.
+	Chown    bool
+	UID, GID int
+}
+
+// mkdirAll creates dir, along with any necessary parents, and sets the mode and owner of each directory it creates.
+func (p Permissions) mkdirAll(dir string) error {
+	var created []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		created = append(created, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Start at the top, so a restrictive mode can't lock us out of the directories below.
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := p.apply(created[i], p.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyFile sets the mode and owner of a file.
+func (p Permissions) applyFile(fn string) error {
+	return p.apply(fn, p.FileMode)
+}
+
+// apply sets the mode of fn, if mode isn't 0, and its owner, if p.Chown is true.
+func (p Permissions) apply(fn string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(fn, mode); err != nil {
+			return err
+		}
+	}
+	if p.Chown {
+		if err := os.Chown(fn, p.UID, p.GID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// largeFileSize is the size above which copies report their progress.
+const largeFileSize = 64 << 20
+
+// copyProgressInterval is how many bytes are copied between progress reports.
+const copyProgressInterval = 8 << 20
+
+// InsufficientSpaceError is returned when there isn't enough free space to copy a file.
+// Its cause is ErrInsufficientSpace, so it's handled like running out of room before the copy.
+type InsufficientSpaceError struct {
+	Filename string
+	Needed   int64
+	Free     uint64
+}
+
+func (e InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough space to copy %s: %d bytes needed, %d free", e.Filename, e.Needed, e.Free)
+}
+
+// Cause returns ErrInsufficientSpace, for errors.Cause.
+func (e InsufficientSpaceError) Cause() error {
+	return ErrInsufficientSpace
+}
+
+// SourceNotRemovedError is returned when a file was moved by copying it, but the original couldn't be removed.
+// The copy is complete, and the original is still in place.
+type SourceNotRemovedError struct {
+	Source string
+	Err    error
+}
+
+func (e SourceNotRemovedError) Error() string {
+	return fmt.Sprintf("copied %s, but cannot remove it: %s", e.Source, e.Err)
+}
+
 // moveOrCopyFile moves or copies a file from origName to newName.
 // All necessary directories to make the destination valid will be created.
-func moveOrCopyFile(origName, newName string, move bool) error {
+// If progress isn't nil, it's called as large files are copied.
+func moveOrCopyFile(origName, newName string, move bool, progress ProgressFunc) error {
 	err := os.MkdirAll(path.Dir(newName), 0755)
 	if err != nil {
 		return errors.Wrap(err, "create destination directory")
 	}
 
 	if move {
-		return moveFile(origName, newName)
+		return moveFile(origName, newName, progress)
 	}
-	return copyFile(origName, newName)
+	_, err = copyFile(origName, newName, progress)
+	return err
 }
 
 // copyFile copies a file from src to dst, setting dst's modified time to that of src.
-func copyFile(src, dst string) (e error) {
+// It returns the hex-encoded SHA-256 hash of the data it copied.
+// If the free space on dst's filesystem can be found, it's checked before copying.
+// If the copy fails, dst is removed. src is read no faster than the limit set by SetIOLimit.
+func copyFile(src, dst string, progress ProgressFunc) (hash string, e error) {
 	fp, err := os.Open(src)
 	if err != nil {
-		return errors.Wrap(err, "Copy file")
+		return "", errors.Wrap(err, "Copy file")
 	}
 	defer fp.Close()
 
 	st, err := fp.Stat()
 	if err != nil {
-		return errors.Wrap(err, "Copy file")
+		return "", errors.Wrap(err, "Copy file")
+	}
+	if free, ok, err := freeSpace(filepath.Dir(dst)); err != nil {
+		log.Printf("Cannot find the free space for %s: %s", dst, err)
+	} else if ok && free < uint64(st.Size()) {
+		return "", InsufficientSpaceError{src, st.Size(), free}
 	}
 
 	fd, err := os.Create(dst)
 	if err != nil {
-		return errors.Wrap(err, "create destination file")
+		return "", errors.Wrap(err, "create destination file")
 	}
 	defer func() {
-		if err := fd.Close(); err != nil {
+		if err := fd.Close(); err != nil && e == nil {
 			e = errors.Wrap(err, "close destination file")
 		}
+		if e != nil {
+			if err := os.Remove(dst); err != nil {
+				log.Printf("Error removing partial copy %s: %s", dst, err)
+			}
+			return
+		}
 		if err := os.Chtimes(dst, time.Now(), st.ModTime()); err != nil {
 			log.Printf("Error updating times of %s: %s", dst, err)
 		}
 	}()
 
-	if _, err := io.Copy(fd, fp); err != nil {
-		return errors.Wrap(err, "Copy file")
+	hasher := sha256.New()
+	var w io.Writer = io.MultiWriter(fd, hasher)
+	if progress != nil && st.Size() > largeFileSize {
+		tracker := newProgressTracker("copy", int(st.Size()), progress)
+		tracker.progress.Current = src
+		w = &progressWriter{w: w, tracker: tracker}
+	}
+	if _, err := io.Copy(w, throttle(fp)); err != nil {
+		return "", errors.Wrap(err, "Copy file")
 	}
 
 	log.Printf("Copied %s to %s", src, dst)
 
-	return nil
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// progressWriter reports the number of bytes written through it every copyProgressInterval bytes.
+type progressWriter struct {
+	w        io.Writer
+	tracker  *progressTracker
+	reported int
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.tracker.progress.Done += n
+	if pw.tracker.progress.Done-pw.reported >= copyProgressInterval || pw.tracker.progress.Done == pw.tracker.progress.Total {
+		pw.reported = pw.tracker.progress.Done
+		pw.tracker.report()
+	}
+	return n, err
+}
+
+// syncFile flushes the contents of a file to disk.
+func syncFile(name string) error {
+	fp, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	if err := fp.Sync(); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// syncDirs flushes dir and each of its parents up to and including root to disk,
+// so files created or renamed in them, and the directories themselves, are durable.
+// If dir isn't inside root, only dir is flushed.
+func syncDirs(dir, root string) error {
+	for {
+		fp, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		err = fp.Sync()
+		fp.Close()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
 }
 
 // moveFile moves a file from src to dst.
 // First, moveFile will attempt to rename the file,
 // and if that fails, it will perform a copy and delete.
-func moveFile(src, dst string) error {
+// The copy is checked against the data read from src before src is deleted,
+// and a SourceNotRemovedError is returned if src can't be deleted.
+func moveFile(src, dst string, progress ProgressFunc) error {
 	if err := os.Rename(src, dst); err != nil {
-		err = copyFile(src, dst)
+		hash, err := copyFile(src, dst, progress)
 		if err != nil {
 			return err
 		}
-		err = os.Remove(src)
+		copied, err := hashFile(dst)
 		if err != nil {
-			log.Printf("Error removing %s: %s", src, err)
-			return nil
+			os.Remove(dst)
+			return errors.Wrap(err, "verify copy")
+		}
+		if copied != hash {
+			os.Remove(dst)
+			return errors.Errorf("copy of %s to %s doesn't match the original", src, dst)
+		}
+		if err := os.Remove(src); err != nil {
+			return SourceNotRemovedError{src, err}
 		}
 
 		log.Printf("Moved %s to %s (copy/delete)", src, dst)
@@ -0,0 +1,437 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of problems found by Check.
+const (
+	// ProblemMissingFile is a file in the library which isn't in its books root.
+	ProblemMissingFile = "missing-file"
+	// ProblemSizeMismatch is a file whose size in the books root doesn't match the library.
+	ProblemSizeMismatch = "size-mismatch"
+	// ProblemHashMismatch is a file whose contents no longer match its hash.
+	ProblemHashMismatch = "hash-mismatch"
+	// ProblemOrphanFile is a file in a books root which isn't in the library.
+	ProblemOrphanFile = "orphan-file"
+	// ProblemEmptyBook is a book without any files.
+	ProblemEmptyBook = "empty-book"
+	// ProblemDanglingRow is a row which refers to a row that doesn't exist, such as an author of a deleted book.
+	ProblemDanglingRow = "dangling-row"
+	// ProblemSearchGhost is a search index entry without a book.
+	ProblemSearchGhost = "search-ghost"
+	// ProblemSearchMissing is a book without a search index entry.
+	ProblemSearchMissing = "search-missing"
+	// ProblemSearchStale is a search index entry which doesn't match its book.
+	ProblemSearchStale = "search-stale"
+)
+
+// ProblemKinds are the kinds of problems found by Check.
+var ProblemKinds = []string{ProblemMissingFile, ProblemSizeMismatch, ProblemHashMismatch, ProblemOrphanFile,
+	ProblemEmptyBook, ProblemDanglingRow, ProblemSearchGhost, ProblemSearchMissing, ProblemSearchStale}
+
+// A Problem is an inconsistency between the library and its books roots, or within the library, found by Check.
+type Problem struct {
+	// Kind is the kind of problem, one of ProblemKinds.
+	Kind string
+	// BookID is the book with the problem, or the book of the file with the problem.
+	BookID int64
+	// FileID is the file with the problem, for problems with files in the library.
+	FileID int64
+	// Root is the name of the books root the file with the problem is on.
+	Root string
+	// Path is the absolute path of the file with the problem in its books root.
+	Path string
+	// Row is the row with the problem, for ProblemDanglingRow.
+	Row OrphanedRow
+}
+
+func (p Problem) String() string {
+	switch p.Kind {
+	case ProblemMissingFile:
+		return fmt.Sprintf("File %d of book %d is missing: %s", p.FileID, p.BookID, p.Path)
+	case ProblemSizeMismatch:
+		return fmt.Sprintf("File %d of book %d has changed size: %s", p.FileID, p.BookID, p.Path)
+	case ProblemHashMismatch:
+		return fmt.Sprintf("File %d of book %d doesn't match its hash: %s", p.FileID, p.BookID, p.Path)
+	case ProblemOrphanFile:
+		return fmt.Sprintf("File is not in the library: %s", p.Path)
+	case ProblemEmptyBook:
+		return fmt.Sprintf("Book %d has no files", p.BookID)
+	case ProblemDanglingRow:
+		return fmt.Sprintf("Row %d of %s refers to a missing row of %s", p.Row.RowID, p.Row.Table, p.Row.Parent)
+	case ProblemSearchGhost:
+		return fmt.Sprintf("Search index entry %d has no book", p.BookID)
+	case ProblemSearchMissing:
+		return fmt.Sprintf("Book %d is missing from the search index", p.BookID)
+	case ProblemSearchStale:
+		return fmt.Sprintf("Search index entry of book %d is out of date", p.BookID)
+	}
+	return p.Kind
+}
+
+// CheckReport lists the problems found by Check.
+type CheckReport struct {
+	Problems []Problem
+}
+
+// Empty returns true if no problems were found.
+func (r CheckReport) Empty() bool {
+	return len(r.Problems) == 0
+}
+
+// OfKind returns the problems of the given kinds.
+func (r CheckReport) OfKind(kinds ...string) []Problem {
+	var problems []Problem
+	for _, p := range r.Problems {
+		for _, k := range kinds {
+			if p.Kind == k {
+				problems = append(problems, p)
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// CheckOptions controls what Check checks.
+type CheckOptions struct {
+	// VerifyHashes hashes every file in the books roots, to find files whose contents changed without their size changing.
+	// It reads every file, so it's slow for large libraries.
+	VerifyHashes bool
+	// Progress, if not nil, is called as each file is checked.
+	Progress ProgressFunc
+}
+
+// Check checks that the library and its books roots agree, without changing either, and reports the problems it finds:
+// files which are missing from the books roots, or whose size or hash don't match the library,
+// files in the books roots which aren't in the library, books without files,
+// rows which refer to rows that don't exist, and search index entries which are missing, have no book, or are out of date.
+// Files on books roots which aren't available are skipped.
+// The problems can be fixed with Repair.
+func (lib *Library) Check(opts CheckOptions) (CheckReport, error) {
+	var report CheckReport
+	tx, err := lib.Begin()
+	if err != nil {
+		return report, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	bookIDs, err := fileBookIDs(tx)
+	if err != nil {
+		return report, err
+	}
+	ids := make([]int64, 0, len(bookIDs))
+	for id := range bookIDs {
+		ids = append(ids, id)
+	}
+	files, err := getFilesByID(tx, ids)
+	if err != nil {
+		return report, errors.Wrap(err, "get files")
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	// Files with the same contents share one file in the books root, so its path can be expected more than once.
+	expected := make(map[string]bool, len(files))
+	tracker := newProgressTracker("check files", len(files), opts.Progress)
+	for _, f := range files {
+		tracker.start(f.CurrentFilename)
+		problems, path, err := lib.checkFile(f, bookIDs[f.ID], opts.VerifyHashes)
+		if err != nil {
+			return report, err
+		}
+		if path != "" {
+			expected[path] = true
+		}
+		report.Problems = append(report.Problems, problems...)
+		tracker.done()
+	}
+
+	orphans, err := lib.findOrphanFiles(expected)
+	if err != nil {
+		return report, err
+	}
+	report.Problems = append(report.Problems, orphans...)
+
+	empty, err := queryInt64s(tx, "select id from books where id not in (select book_id from files) order by id")
+	if err != nil {
+		return report, errors.Wrap(err, "find books without files")
+	}
+	for _, id := range empty {
+		report.Problems = append(report.Problems, Problem{Kind: ProblemEmptyBook, BookID: id})
+	}
+
+	dangling, err := checkForeignKeys(tx)
+	if err != nil {
+		return report, err
+	}
+	for _, o := range dangling {
+		report.Problems = append(report.Problems, Problem{Kind: ProblemDanglingRow, Row: o})
+	}
+
+	search, err := checkSearchIndex(tx)
+	if err != nil {
+		return report, err
+	}
+	for _, id := range search.GhostIDs {
+		report.Problems = append(report.Problems, Problem{Kind: ProblemSearchGhost, BookID: id})
+	}
+	for _, id := range search.MissingIDs {
+		report.Problems = append(report.Problems, Problem{Kind: ProblemSearchMissing, BookID: id})
+	}
+	stale, err := findStaleSearchEntries(tx)
+	if err != nil {
+		return report, err
+	}
+	for _, id := range stale {
+		report.Problems = append(report.Problems, Problem{Kind: ProblemSearchStale, BookID: id})
+	}
+	return report, nil
+}
+
+// fileBookIDs returns the ID of the book of every file in the library, by file ID.
+func fileBookIDs(tx *sql.Tx) (map[int64]int64, error) {
+	rows, err := tx.Query("select id, book_id from files")
+	if err != nil {
+		return nil, errors.Wrap(err, "get files")
+	}
+	defer rows.Close()
+	m := make(map[int64]int64)
+	for rows.Next() {
+		var id, bookID int64
+		if err := rows.Scan(&id, &bookID); err != nil {
+			return nil, errors.Wrap(err, "get files")
+		}
+		m[id] = bookID
+	}
+	return m, errors.Wrap(rows.Err(), "get files")
+}
+
+// checkFile checks that a file of the book with bookID is in its books root, with the right size, and if verifyHash is true, the right hash.
+// It returns the problems found, and the file's path, which is empty if its root isn't available.
+func (lib *Library) checkFile(f BookFile, bookID int64, verifyHash bool) ([]Problem, string, error) {
+	fn, err := lib.FilePath(f)
+	if errors.Cause(err) == ErrRootUnavailable {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	p := Problem{BookID: bookID, FileID: f.ID, Root: f.Root, Path: fn}
+	fi, err := os.Stat(fn)
+	if os.IsNotExist(err) {
+		p.Kind = ProblemMissingFile
+		return []Problem{p}, fn, nil
+	} else if err != nil {
+		return nil, "", errors.Wrapf(err, "stat file %d", f.ID)
+	}
+	if fi.Size() != f.FileSize {
+		p.Kind = ProblemSizeMismatch
+		return []Problem{p}, fn, nil
+	}
+	if verifyHash {
+		hash, err := hashFile(fn)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "hash file %d", f.ID)
+		}
+		if hash != f.Hash {
+			p.Kind = ProblemHashMismatch
+			return []Problem{p}, fn, nil
+		}
+	}
+	return nil, fn, nil
+}
+
+// findOrphanFiles returns a problem for each file in the available books roots whose path isn't expected.
+// The library's own files are skipped, in case it's kept in a books root.
+func (lib *Library) findOrphanFiles(expected map[string]bool) ([]Problem, error) {
+	libraryFile, err := filepath.Abs(lib.filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "get absolute path of library")
+	}
+	var orphans []Problem
+	for _, r := range lib.roots {
+		if !r.Available() {
+			continue
+		}
+		if _, err := os.Stat(r.Path); os.IsNotExist(err) {
+			// The main root isn't created until a file is imported.
+			continue
+		}
+		err := walkFiles(r.Path, true, func(path string, info os.FileInfo) error {
+			if expected[path] || strings.HasPrefix(path, libraryFile) || lib.paths.contains(path) {
+				return nil
+			}
+			orphans = append(orphans, Problem{Kind: ProblemOrphanFile, Root: r.Name, Path: path})
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "find files in books root %s", r.Path)
+		}
+	}
+	return orphans, nil
+}
+
+// contains returns true if path is in one of the directories the library manages.
+func (p LibraryPaths) contains(path string) bool {
+	for _, dir := range p.managed() {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// findStaleSearchEntries returns the IDs of books whose search index entries don't match what would be indexed for them now.
+func findStaleSearchEntries(tx *sql.Tx) ([]int64, error) {
+	fields, err := searchIndexFields(tx)
+	if err != nil {
+		return nil, err
+	}
+	conds := make([]string, len(fields))
+	for i, f := range fields {
+		conds[i] = "fts." + f + " is not " + sqlWithIndexSynonyms(searchFieldSources[f])
+	}
+	ids, err := queryInt64s(tx, "select b.id from books b join books_fts fts on fts.docid = b.id where "+strings.Join(conds, " or ")+" order by b.id")
+	return ids, errors.Wrap(err, "find stale search entries")
+}
+
+// RepairOptions controls how Repair fixes problems.
+type RepairOptions struct {
+	// Parser, if not nil, replaces the metadata of books whose files changed with the metadata of the changed files,
+	// as in AdoptChangedFile, and Template renames their files.
+	Parser   MetadataParser
+	Template *template.Template
+}
+
+// Repair fixes problems found by Check, and returns the problems which were fixed.
+// Only the problems given are fixed, so a caller can choose which to fix.
+// Missing files are removed from the library, and books without files are deleted.
+// Files whose size or hash changed are adopted with AdoptChangedFile.
+// Files which aren't in the library are moved to the library's trash, rather than deleted.
+// Dangling rows are fixed as they are by RepairForeignKeys,
+// and if the search index has any problems, it's rebuilt from scratch.
+// Problems which were already fixed, such as by an earlier call, are skipped.
+func (lib *Library) Repair(problems []Problem, opts RepairOptions) ([]Problem, error) {
+	repaired, err := lib.repairRows(problems)
+	if err != nil {
+		return repaired, err
+	}
+	for _, p := range problems {
+		switch p.Kind {
+		case ProblemSizeMismatch, ProblemHashMismatch:
+			err := lib.AdoptChangedFile(p.FileID, opts.Parser, opts.Template)
+			if err == ErrFileNotFound {
+				continue
+			} else if err != nil {
+				return repaired, errors.Wrapf(err, "adopt file %d", p.FileID)
+			}
+		case ProblemOrphanFile:
+			if _, err := os.Stat(p.Path); os.IsNotExist(err) {
+				continue
+			}
+			if err := trashFile(p.Path, lib.paths.Trash); err != nil {
+				return repaired, errors.Wrapf(err, "move %s to trash", p.Path)
+			}
+		default:
+			continue
+		}
+		repaired = append(repaired, p)
+	}
+	return repaired, nil
+}
+
+// repairRows fixes the problems which only need the library to change, in one transaction.
+func (lib *Library) repairRows(problems []Problem) ([]Problem, error) {
+	unlock, err := lib.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	var repaired []Problem
+	var hashes []string
+	var rebuildSearch bool
+	for _, p := range problems {
+		var res sql.Result
+		var err error
+		switch p.Kind {
+		case ProblemMissingFile:
+			var hash string
+			if err := tx.QueryRow("select hash from files where id=?", p.FileID).Scan(&hash); err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				tx.Rollback()
+				return nil, errors.Wrapf(err, "get file %d", p.FileID)
+			}
+			hashes = append(hashes, hash)
+			// The file's tags are removed by foreign keys, and its book is indexed again by a trigger.
+			res, err = tx.Exec("delete from files where id=?", p.FileID)
+		case ProblemEmptyBook:
+			res, err = tx.Exec("delete from books where id=? and not exists (select 1 from files where book_id=?)", p.BookID, p.BookID)
+		case ProblemDanglingRow:
+			res, err = repairOrphanedRow(tx, p.Row)
+		case ProblemSearchGhost, ProblemSearchMissing, ProblemSearchStale:
+			rebuildSearch = true
+			repaired = append(repaired, p)
+			continue
+		default:
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "repair %s", p)
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			repaired = append(repaired, p)
+		}
+	}
+	// The search index is rebuilt last, so it reflects the other repairs.
+	if rebuildSearch {
+		if err := rebuildSearchIndex(tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	lib.invalidateCache()
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	lib.removeUnusedFiles(hashes)
+	if len(repaired) > 0 {
+		log.Printf("Repaired %d problems in the library", len(repaired))
+	}
+	return repaired, nil
+}
+
+// trashFile moves fn into dir, with a unique name.
+func trashFile(fn, dir string) error {
+	return trashFileAs(fn, dir, filepath.Base(fn))
+}
+
+// trashFileAs moves a file into the trash directory dir, named name, or a unique name like it if a file there already has it.
+func trashFileAs(fn, dir, name string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dst, err := GetUniqueName(filepath.Join(dir, name), "")
+	if err != nil {
+		return err
+	}
+	return moveFile(fn, dst, nil)
+}
@@ -0,0 +1,150 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GenerateOptions controls the books created by GenerateTestLibrary.
+type GenerateOptions struct {
+	// Template names the generated files, as in ImportBook.
+	Template *template.Template
+	// StubFiles writes a small file to the books root for each generated file.
+	// Without it, files are only recorded in the library, so operations which read files will fail.
+	StubFiles bool
+	// MaxFilesPerBook is the most files a generated book can have, each with a different extension.
+	// If 0, each book has one file.
+	MaxFilesPerBook int
+	// Seed seeds the random generator, so that the same options always generate the same library.
+	Seed int64
+	// Progress, if not nil, is called as each book is generated.
+	Progress ProgressFunc
+}
+
+var (
+	generatedFirstNames = []string{"Alice", "Benjamin", "Clara", "David", "Elena", "Frank", "Grace", "Henry", "Iris", "James",
+		"Katherine", "Leo", "Margaret", "Nathan", "Olivia", "Peter", "Quinn", "Rachel", "Samuel", "Tessa", "Ursula", "Victor", "Wendy", "Xavier", "Yvonne", "Zachary"}
+	generatedLastNames = []string{"Abbott", "Baker", "Carter", "Dalton", "Ellis", "Fletcher", "Garcia", "Hughes", "Ingram", "Jensen",
+		"Kowalski", "Lindqvist", "Morales", "Nakamura", "O'Brien", "Patel", "Quincy", "Reyes", "Sorensen", "Thompson", "Underwood", "Vasquez", "Whitaker", "Yamada", "Zimmerman"}
+	generatedTitleWords = []string{"Shadow", "River", "Empire", "Garden", "Silent", "Last", "Iron", "Winter", "Glass", "Forgotten",
+		"Star", "Kingdom", "Night", "Crown", "Storm", "Harbor", "Secret", "Light", "Stone", "Ember", "Wolf", "Machine", "Mirror", "Ocean", "Road"}
+	generatedSeries     = []string{"The Long Watch", "Chronicles of Ash", "Harbor Lights", "The Iron Accords", "Tales of the Verge", "Night Circus Mysteries"}
+	generatedPublishers = []string{"Northwind Press", "Blue Heron Books", "Marrow & Finch", "Tidewater Publishing", ""}
+	generatedTags       = []string{"retail", "illustrated", "abridged", "v5", "ocr"}
+	generatedExtensions = []string{"epub", "mobi", "pdf", "azw3", "txt"}
+)
+
+// GenerateTestLibrary imports n books with randomly generated authors, titles, series, publishers, tags, and files,
+// for evaluating the library's performance without a real collection of books.
+// Books are imported as ImportBook would, so files for the same book are merged into it.
+func (lib *Library) GenerateTestLibrary(n int, opts GenerateOptions) error {
+	if opts.Template == nil {
+		return errors.New("no template for generated filenames")
+	}
+	maxFiles := opts.MaxFilesPerBook
+	if maxFiles <= 0 {
+		maxFiles = 1
+	}
+	if maxFiles > len(generatedExtensions) {
+		maxFiles = len(generatedExtensions)
+	}
+	rnd := rand.New(rand.NewSource(opts.Seed))
+
+	var tmpDir string
+	if opts.StubFiles {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "books"); err != nil {
+			return errors.Wrap(err, "create temporary directory")
+		}
+		defer os.RemoveAll(tmpDir)
+	}
+
+	tracker := newProgressTracker("generate", n, opts.Progress)
+	for i := 0; i < n; i++ {
+		book := generateBook(rnd)
+		tracker.start(book.Title)
+		numFiles := 1 + rnd.Intn(maxFiles)
+		for j, k := range rnd.Perm(len(generatedExtensions))[:numFiles] {
+			bf, err := generateBookFile(rnd, i, j, generatedExtensions[k], tmpDir)
+			if err != nil {
+				return errors.Wrap(err, "generate file")
+			}
+			book.Files = []BookFile{bf}
+			err = lib.ImportBookWithOptions(book, opts.Template, ImportOptions{Move: true, metadataOnly: !opts.StubFiles})
+			if err != nil {
+				return errors.Wrapf(err, "import generated book %d", i)
+			}
+		}
+		tracker.done()
+	}
+	return nil
+}
+
+// generateBook returns a book with random metadata and no files.
+func generateBook(rnd *rand.Rand) Book {
+	var book Book
+	numAuthors := 1
+	if rnd.Intn(100) < 15 {
+		numAuthors += 1 + rnd.Intn(2)
+	}
+	for i := 0; i < numAuthors; i++ {
+		book.Authors = append(book.Authors, generatedFirstNames[rnd.Intn(len(generatedFirstNames))]+" "+generatedLastNames[rnd.Intn(len(generatedLastNames))])
+	}
+	words := make([]string, 1+rnd.Intn(4))
+	for i := range words {
+		words[i] = generatedTitleWords[rnd.Intn(len(generatedTitleWords))]
+	}
+	book.Title = strings.Join(words, " ")
+	if rnd.Intn(100) < 30 {
+		book.Series = fmt.Sprintf("%s %d", generatedSeries[rnd.Intn(len(generatedSeries))], 1+rnd.Intn(12))
+	}
+	book.Publisher = generatedPublishers[rnd.Intn(len(generatedPublishers))]
+	return book
+}
+
+// generateBookFile returns a file for the jth file of the ith generated book.
+// If dir isn't empty, a stub file is written there; otherwise, the file's hash and size are made up.
+func generateBookFile(rnd *rand.Rand, i, j int, ext, dir string) (BookFile, error) {
+	bf := BookFile{
+		Extension: ext,
+		FileMtime: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(rnd.Int63n(int64(10 * 365 * 24 * time.Hour)))),
+		Source:    "generated",
+	}
+	if rnd.Intn(100) < 20 {
+		bf.Tags = []string{generatedTags[rnd.Intn(len(generatedTags))]}
+	}
+	content := fmt.Sprintf("Generated book %d, file %d.\n", i, j)
+	if dir == "" {
+		bf.OriginalFilename = fmt.Sprintf("generated-%d-%d.%s", i, j, ext)
+		bf.FileSize = 100*1024 + rnd.Int63n(5*1024*1024)
+		bf.Hash = fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+		return bf, nil
+	}
+	bf.OriginalFilename = filepath.Join(dir, fmt.Sprintf("%d-%d.%s", i, j, ext))
+	if err := ioutil.WriteFile(bf.OriginalFilename, []byte(content), 0644); err != nil {
+		return bf, err
+	}
+	if err := os.Chtimes(bf.OriginalFilename, bf.FileMtime, bf.FileMtime); err != nil {
+		return bf, err
+	}
+	bf.FileSize = int64(len(content))
+	hash, err := hashFile(bf.OriginalFilename)
+	if err != nil {
+		return bf, err
+	}
+	bf.Hash = hash
+	return bf, nil
+}
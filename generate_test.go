@@ -0,0 +1,104 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+)
+
+// benchmarkLibrarySize is the number of books in the libraries searched and listed by the benchmarks.
+const benchmarkLibrarySize = 2000
+
+// benchmarkTemplate names the files of generated books.
+var benchmarkTemplate = template.Must(template.New("filename").Parse("{{.Author}}/{{.Title}}.{{.Extension}}"))
+
+// newBenchmarkLibrary creates an empty library in a temporary directory, and stops logging, which would take up most of the time measured.
+// The returned function closes the library, removes the directory, and logs to stderr again.
+func newBenchmarkLibrary(b *testing.B) (*Library, func()) {
+	b.Helper()
+	dir, err := ioutil.TempDir("", "books")
+	if err != nil {
+		b.Fatal(err)
+	}
+	fn := filepath.Join(dir, "books.db")
+	root := filepath.Join(dir, "books")
+	if err := CreateLibrary(fn); err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	if err := os.Mkdir(root, 0755); err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	lib, err := OpenLibrary(fn, root)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	log.SetOutput(ioutil.Discard)
+	return lib, func() {
+		lib.Close()
+		os.RemoveAll(dir)
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// generateBenchmarkLibrary returns a library of benchmarkLibrarySize generated books, which is always the same,
+// and a function which removes it, as newBenchmarkLibrary does.
+func generateBenchmarkLibrary(b *testing.B) (*Library, func()) {
+	b.Helper()
+	lib, cleanup := newBenchmarkLibrary(b)
+	if err := lib.GenerateTestLibrary(benchmarkLibrarySize, GenerateOptions{Template: benchmarkTemplate, MaxFilesPerBook: 3, Seed: 1}); err != nil {
+		cleanup()
+		b.Fatal(err)
+	}
+	return lib, cleanup
+}
+
+// BenchmarkImport measures importing a generated book into a library, including merging files into books which are already there.
+func BenchmarkImport(b *testing.B) {
+	lib, cleanup := newBenchmarkLibrary(b)
+	defer cleanup()
+	b.ResetTimer()
+	if err := lib.GenerateTestLibrary(b.N, GenerateOptions{Template: benchmarkTemplate, MaxFilesPerBook: 3, Seed: 1}); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkSearch measures searching a generated library for a word in many of its titles.
+func BenchmarkSearch(b *testing.B) {
+	lib, cleanup := generateBenchmarkLibrary(b)
+	defer cleanup()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := lib.Search("title:shadow"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkListBooks measures listing a generated library sorted by title, and getting the books on its first page, as the server does.
+func BenchmarkListBooks(b *testing.B) {
+	lib, cleanup := generateBenchmarkLibrary(b)
+	defer cleanup()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ids, err := lib.ListBookIDs(SortByTitle, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(ids) > 50 {
+			ids = ids[:50]
+		}
+		if _, err := lib.GetBooksByID(ids); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
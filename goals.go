@@ -0,0 +1,203 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// GoalKind is what a reading goal counts.
+type GoalKind string
+
+// Kinds of reading goals.
+const (
+	GoalBooks GoalKind = "books"
+	GoalPages GoalKind = "pages"
+)
+
+// maxTopEntries is the number of authors and genres listed in a YearInReview.
+const maxTopEntries = 10
+
+// ReadingGoal is the number of books or pages a sync user wants to read in a year.
+type ReadingGoal struct {
+	User   string
+	Year   int
+	Kind   GoalKind
+	Target int
+	// Progress is the number of books or pages the user has finished in the year so far.
+	// Pages are counted from the page counts of finished books, so books without one don't add to it.
+	Progress int
+}
+
+// Reached returns true if the user has read at least the goal's target.
+func (g ReadingGoal) Reached() bool {
+	return g.Progress >= g.Target
+}
+
+// RankedName is an author or genre, and the number of books finished in a year which have it.
+type RankedName struct {
+	Name  string
+	Books int
+}
+
+// YearInReview summarizes the books finished in a year.
+type YearInReview struct {
+	// User is the sync user whose books are summarized, or empty for all users.
+	User string
+	Year int
+	// BookIDs are the IDs of the finished books, in the order they were finished.
+	BookIDs []int64
+	// Pages is the total page count of the finished books which have one.
+	Pages int
+	// TopAuthors and TopGenres are the most read authors and genres, most read first.
+	// Genres are the tags of the books' files.
+	TopAuthors []RankedName
+	TopGenres  []RankedName
+}
+
+// SetReadingGoal sets the number of books or pages a sync user wants to read in a year.
+// A target of 0 removes the goal.
+func (lib *Library) SetReadingGoal(user string, year int, kind GoalKind, target int) error {
+	if kind != GoalBooks && kind != GoalPages {
+		return errors.Errorf("unknown goal kind %s", kind)
+	}
+	if target < 0 {
+		return errors.New("target can't be negative")
+	}
+	var users int
+	if err := lib.QueryRow("select count(*) from sync_users where username=?", user).Scan(&users); err != nil {
+		return errors.Wrap(err, "find sync user")
+	}
+	if users == 0 {
+		return errors.Errorf("sync user %s not found", user)
+	}
+	var err error
+	if target == 0 {
+		_, err = lib.Exec("delete from reading_goals where username=? and year=? and kind=?", user, year, string(kind))
+	} else {
+		_, err = lib.Exec("insert or replace into reading_goals (username, year, kind, target, updated_on) values(?, ?, ?, ?, datetime())", user, year, string(kind), target)
+	}
+	return errors.Wrap(err, "set reading goal")
+}
+
+// GetReadingGoals returns a sync user's reading goals for a year, with their progress.
+func (lib *Library) GetReadingGoals(user string, year int) ([]ReadingGoal, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("select kind, target from reading_goals where username=? and year=? order by kind", user, year)
+	if err != nil {
+		return nil, errors.Wrap(err, "get reading goals")
+	}
+	var goals []ReadingGoal
+	for rows.Next() {
+		g := ReadingGoal{User: user, Year: year}
+		if err := rows.Scan(&g.Kind, &g.Target); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "get reading goals")
+		}
+		goals = append(goals, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get reading goals")
+	}
+	if len(goals) == 0 {
+		return goals, nil
+	}
+
+	ids, err := finishedBookIDs(tx, user, year)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := totalPages(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range goals {
+		if goals[i].Kind == GoalPages {
+			goals[i].Progress = pages
+		} else {
+			goals[i].Progress = len(ids)
+		}
+	}
+	return goals, nil
+}
+
+// YearInReview summarizes the books a sync user finished in a year.
+// If user is empty, the books finished by any user are summarized.
+// A book counts as finished when the reading progress of one of its files reaches FinishedPercentage,
+// in the year that progress was last reported.
+func (lib *Library) YearInReview(user string, year int) (YearInReview, error) {
+	review := YearInReview{User: user, Year: year}
+	tx, err := lib.Begin()
+	if err != nil {
+		return review, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	if review.BookIDs, err = finishedBookIDs(tx, user, year); err != nil {
+		return review, err
+	}
+	if len(review.BookIDs) == 0 {
+		return review, nil
+	}
+	if review.Pages, err = totalPages(tx, review.BookIDs); err != nil {
+		return review, err
+	}
+	ids := joinInt64s(review.BookIDs, ",")
+	review.TopAuthors, err = rankNames(tx, `select a.name, count(distinct ba.book_id) n from books_authors ba join authors a on ba.author_id = a.id
+where ba.role = 'author' and ba.book_id in (`+ids+`) group by a.id order by n desc, a.name collate nocase limit ?`)
+	if err != nil {
+		return review, errors.Wrap(err, "get top authors")
+	}
+	review.TopGenres, err = rankNames(tx, `select t.name, count(distinct bt.book_id) n from
+(select book_id, tag_id from books_tags union select f.book_id, ft.tag_id from files_tags ft join files f on ft.file_id = f.id) bt join tags t on bt.tag_id = t.id
+where bt.book_id in (`+ids+`) group by t.id order by n desc, t.name limit ?`)
+	if err != nil {
+		return review, errors.Wrap(err, "get top genres")
+	}
+	return review, nil
+}
+
+// finishedBookIDs returns the IDs of the books user finished in year, or any user if user is empty, in the order they were finished.
+func finishedBookIDs(tx *sql.Tx, user string, year int) ([]int64, error) {
+	ids, err := queryInt64s(tx, `select f.book_id from reading_progress p join files f on p.file_id = f.id
+where (? = '' or p.username = ?) and p.percentage >= ? and strftime('%Y', p.updated_on) = ?
+group by f.book_id order by max(p.updated_on), f.book_id`, user, user, FinishedPercentage, strconv.Itoa(year))
+	return ids, errors.Wrap(err, "get finished books")
+}
+
+// totalPages returns the sum of the page counts of the books with the given IDs.
+func totalPages(tx *sql.Tx, ids []int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	var pages int
+	err := tx.QueryRow("select coalesce(sum(pages), 0) from books where id in (" + joinInt64s(ids, ",") + ")").Scan(&pages)
+	return pages, errors.Wrap(err, "count pages")
+}
+
+// rankNames runs a query returning names and book counts, limited to maxTopEntries rows.
+func rankNames(tx *sql.Tx, query string) ([]RankedName, error) {
+	rows, err := tx.Query(query, maxTopEntries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []RankedName
+	for rows.Next() {
+		var r RankedName
+		if err := rows.Scan(&r.Name, &r.Books); err != nil {
+			return nil, err
+		}
+		names = append(names, r)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,93 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// An ImportHook is called with a book being imported.
+// Pre-import hooks may change the book, and returning an error from one skips the import.
+// Post-import hooks are called once the book has been imported, with its ID set; their errors are only logged.
+type ImportHook func(book *Book) error
+
+var (
+	importHooksLock sync.Mutex
+	importHooks     = make(map[string]ImportHook)
+)
+
+// RegisterImportHook makes a hook available by name, for programs which configure hooks by name with LookupImportHook.
+// Registering a hook with the same name as an existing hook replaces it.
+func RegisterImportHook(name string, hook ImportHook) {
+	importHooksLock.Lock()
+	defer importHooksLock.Unlock()
+	importHooks[name] = hook
+}
+
+// LookupImportHook returns the hook registered with name.
+func LookupImportHook(name string) (ImportHook, bool) {
+	importHooksLock.Lock()
+	defer importHooksLock.Unlock()
+	hook, ok := importHooks[name]
+	return hook, ok
+}
+
+// CommandHook returns a hook which runs an external command.
+// The book is written to the command's standard input as JSON, with Book's JSON field names, such as "title",
+// and the path of the file being imported is set in the BOOKS_FILE environment variable.
+// If the command writes a book as JSON to standard output, it replaces the book being imported.
+// If the command exits with a non-zero status, the hook returns an error.
+func CommandHook(name string, args ...string) ImportHook {
+	return func(book *Book) error {
+		input, err := json.Marshal(book)
+		if err != nil {
+			return errors.Wrap(err, "encode book")
+		}
+		cmd := exec.Command(name, args...)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Env = os.Environ()
+		if len(book.Files) > 0 {
+			cmd.Env = append(cmd.Env, "BOOKS_FILE="+book.Files[len(book.Files)-1].OriginalFilename)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return errors.Wrapf(err, "run %s: %s", name, msg)
+			}
+			return errors.Wrapf(err, "run %s", name)
+		}
+		if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+			return nil
+		}
+		var changed Book
+		if err := json.Unmarshal(stdout.Bytes(), &changed); err != nil {
+			return errors.Wrapf(err, "decode book from %s", name)
+		}
+		*book = changed
+		return nil
+	}
+}
+
+// runPreImportHooks runs the pre-import hooks on book, stopping at the first error.
+func runPreImportHooks(hooks []ImportHook, book *Book) error {
+	for _, hook := range hooks {
+		if err := hook(book); err != nil {
+			return err
+		}
+	}
+	if len(book.Files) != 1 {
+		return errors.New("pre-import hook changed the number of files")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Operations recorded with idempotency keys.
+const (
+	OperationImport = "import"
+	OperationUpdate = "update"
+	OperationMerge  = "merge"
+)
+
+// IdempotencyKeyReusedError is returned when an idempotency key which was used for one operation is used for another.
+type IdempotencyKeyReusedError struct {
+	Key       string
+	Operation string
+}
+
+func (e IdempotencyKeyReusedError) Error() string {
+	return "idempotency key " + e.Key + " was already used for " + e.Operation
+}
+
+// IdempotentResult is the stored result of a mutation made with an idempotency key.
+type IdempotentResult struct {
+	Operation string
+	// BookID is the ID of the book which was imported, updated or merged into.
+	BookID    int64
+	CreatedOn time.Time
+}
+
+// GetIdempotentResult returns the result of the mutation made with key.
+// The returned bool is false if no mutation has been made with key.
+func (lib *Library) GetIdempotentResult(key string) (IdempotentResult, bool, error) {
+	row, err := scanIdempotencyRow(lib.QueryRow("select "+idempotencyRowColumns+" from idempotency_keys where key=?", key))
+	if err == sql.ErrNoRows {
+		return IdempotentResult{}, false, nil
+	}
+	if err != nil {
+		return IdempotentResult{}, false, errors.Wrap(err, "get idempotency key")
+	}
+	res, err := row.result()
+	return res, true, err
+}
+
+// ExpireIdempotencyKeys removes idempotency keys used more than maxAge ago, so they can't be retried any more.
+func (lib *Library) ExpireIdempotencyKeys(maxAge time.Duration) (int64, error) {
+	res, err := lib.Exec("delete from idempotency_keys where created_on < ?", time.Now().UTC().Add(-maxAge).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, errors.Wrap(err, "expire idempotency keys")
+	}
+	return res.RowsAffected()
+}
+
+// lookupIdempotencyKey returns the ID of the book which the mutation made with key applied to.
+// If key is empty, or no mutation has been made with it, the returned bool is false.
+func lookupIdempotencyKey(tx *sql.Tx, key, operation string) (int64, bool, error) {
+	if key == "" {
+		return 0, false, nil
+	}
+	var op, result string
+	err := tx.QueryRow("select operation, result from idempotency_keys where key=?", key).Scan(&op, &result)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrap(err, "get idempotency key")
+	}
+	if op != operation {
+		return 0, false, IdempotencyKeyReusedError{key, op}
+	}
+	id, err := strconv.ParseInt(result, 10, 64)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "parse idempotent result")
+	}
+	return id, true, nil
+}
+
+// saveIdempotencyKey records that the mutation made with key applied to bookID.
+// Nothing is recorded if key is empty.
+func saveIdempotencyKey(tx *sql.Tx, key, operation string, bookID int64) error {
+	if key == "" {
+		return nil
+	}
+	_, err := tx.Exec("insert into idempotency_keys (key, operation, result) values(?, ?, ?)", key, operation, strconv.FormatInt(bookID, 10))
+	return errors.Wrap(err, "save idempotency key")
+}
+
+// UpdateBookWithKey updates a book, as described in UpdateBook.
+// If a book has already been updated with key, nothing is changed.
+// If key is empty, it behaves like UpdateBook.
+func (lib *Library) UpdateBookWithKey(key string, book Book, tmpl *template.Template, overwriteSeries bool) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "get transaction")
+	}
+	if _, found, err := lookupIdempotencyKey(tx, key, OperationUpdate); err != nil || found {
+		tx.Rollback()
+		return err
+	}
+	if err := lib.updateBook(tx, book, tmpl, overwriteSeries); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := saveIdempotencyKey(tx, key, OperationUpdate, book.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(book.ID)
+	if err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+	return nil
+}
+
+// MergeBooksWithKey merges books, as described in MergeBooks.
+// If books have already been merged with key, nothing is changed.
+// If key is empty, it behaves like MergeBooks.
+func (lib *Library) MergeBooksWithKey(key string, ids []int64, tmpl *template.Template) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "create transaction")
+	}
+	if _, found, err := lookupIdempotencyKey(tx, key, OperationMerge); err != nil || found {
+		tx.Rollback()
+		return err
+	}
+	if err := lib.mergeBooks(tx, ids, tmpl); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "merge books")
+	}
+	if err := saveIdempotencyKey(tx, key, OperationMerge, ids[0]); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(ids...)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
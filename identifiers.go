@@ -0,0 +1,160 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Identifier is an external identifier for a book, such as an ISBN, ASIN, DOI, or Google Books ID.
+// Each identifier value can belong to only one book, but a book may have several identifiers of the same type.
+type Identifier struct {
+	// Type is the lowercase name of the identifier scheme, such as isbn, asin, doi, or google.
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// IdentifierExistsError is returned by AddIdentifier when the identifier already belongs to another book.
+type IdentifierExistsError struct {
+	Identifier Identifier
+	BookID     int64
+}
+
+func (iee IdentifierExistsError) Error() string {
+	return fmt.Sprintf("identifier %s:%s already belongs to book %d", iee.Identifier.Type, iee.Identifier.Value, iee.BookID)
+}
+
+// normalizeIdentifier lowercases the type and trims whitespace from the type and value.
+func normalizeIdentifier(idType, value string) Identifier {
+	return Identifier{strings.ToLower(strings.TrimSpace(idType)), strings.TrimSpace(value)}
+}
+
+// AddIdentifier adds an identifier to a book.
+// If the book already has the identifier, nothing is changed.
+// If another book has the identifier, an IdentifierExistsError is returned.
+func (lib *Library) AddIdentifier(bookID int64, idType, value string) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	if err := addIdentifier(tx, bookID, normalizeIdentifier(idType, value)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
+
+func addIdentifier(tx *sql.Tx, bookID int64, ident Identifier) error {
+	if ident.Type == "" || ident.Value == "" {
+		return errors.New("identifier type and value must not be empty")
+	}
+	var existingID int64
+	err := tx.QueryRow("select book_id from identifiers where type=? and value=?", ident.Type, ident.Value).Scan(&existingID)
+	if err == nil {
+		if existingID != bookID {
+			return IdentifierExistsError{ident, existingID}
+		}
+		return nil
+	} else if err != sql.ErrNoRows {
+		return errors.Wrap(err, "find existing identifier")
+	}
+	if _, err := tx.Exec("insert into identifiers (book_id, type, value) values(?, ?, ?)", bookID, ident.Type, ident.Value); err != nil {
+		return errors.Wrap(err, "insert identifier")
+	}
+	return nil
+}
+
+// RemoveIdentifier removes an identifier from whichever book it belongs to.
+func (lib *Library) RemoveIdentifier(idType, value string) error {
+	ident := normalizeIdentifier(idType, value)
+	if _, err := lib.Exec("delete from identifiers where type=? and value=?", ident.Type, ident.Value); err != nil {
+		return errors.Wrap(err, "delete identifier")
+	}
+	lib.invalidateCache()
+	return nil
+}
+
+// GetBookByIdentifier retrieves the book with the given identifier.
+// ErrBookNotFound is returned if no book has the identifier.
+func (lib *Library) GetBookByIdentifier(idType, value string) (Book, error) {
+	ident := normalizeIdentifier(idType, value)
+	tx, err := lib.Begin()
+	if err != nil {
+		return Book{}, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	var bookID int64
+	err = tx.QueryRow("select book_id from identifiers where type=? and value=?", ident.Type, ident.Value).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		return Book{}, ErrBookNotFound
+	} else if err != nil {
+		return Book{}, errors.Wrap(err, "get book ID by identifier")
+	}
+	books, err := getBooksByID(tx, []int64{bookID})
+	if err != nil {
+		return Book{}, errors.Wrap(err, "get book by ID")
+	}
+	if len(books) == 0 {
+		return Book{}, ErrBookNotFound
+	}
+	return books[0], nil
+}
+
+// getIdentifiersByBookIds gets identifiers for each book ID.
+func getIdentifiersByBookIds(tx *sql.Tx, ids []int64) (map[int64][]Identifier, error) {
+	m := make(map[int64][]Identifier)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select " + identifierRowColumns + " from identifiers where book_id in (" + joinInt64s(ids, ",") + ") order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row, err := scanIdentifierRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		m[row.BookID] = append(m[row.BookID], row.identifier())
+	}
+	return m, rows.Err()
+}
+
+// epubIdentifierTypes maps EPUB identifier schemes, in lowercase, to identifier types.
+var epubIdentifierTypes = map[string]string{
+	"isbn":      "isbn",
+	"asin":      "asin",
+	"amazon":    "asin",
+	"mobi-asin": "asin",
+	"doi":       "doi",
+	"google":    "google",
+}
+
+// parseEpubIdentifier converts an EPUB identifier to an Identifier,
+// using either its scheme or a urn:type: prefix on its value.
+func parseEpubIdentifier(scheme, data string) (Identifier, bool) {
+	data = strings.TrimSpace(data)
+	if t, ok := epubIdentifierTypes[strings.ToLower(scheme)]; ok && data != "" {
+		return normalizeIdentifier(t, data), true
+	}
+	lower := strings.ToLower(data)
+	for prefix, t := range epubIdentifierTypes {
+		if strings.HasPrefix(lower, "urn:"+prefix+":") {
+			return normalizeIdentifier(t, data[len("urn:"+prefix+":"):]), true
+		}
+	}
+	return Identifier{}, false
+}
@@ -0,0 +1,119 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// ImportOptions controls how ImportBookWithOptions imports a book.
+type ImportOptions struct {
+	// Move moves the original file into the books root instead of copying it.
+	Move bool
+	// InPlace catalogs the file where it is, recording its absolute path, instead of storing it in a books root,
+	// so a directory can be searched without being reorganized. Move is ignored.
+	InPlace bool
+	// MatchAuthorSubsets adds the file to an existing book with the same title
+	// if the book's authors are a subset or superset of the imported book's authors.
+	// By default, both books must have the same authors.
+	MatchAuthorSubsets bool
+	// ConflictResolver, if set, is asked which book to import into when no existing book exactly matches the imported book,
+	// but one or more books have the same title and share at least one author.
+	ConflictResolver ConflictResolver
+	// PreImportHooks are run, in order, before the book is imported.
+	PreImportHooks []ImportHook
+	// PostImportHooks are run, in order, after the book is imported.
+	PostImportHooks []ImportHook
+	// EmbeddedMetadata, if set, reads the metadata embedded in the file, which replaces the metadata the book was given,
+	// such as from its filename, if it includes the title and authors. The covers of EPUBs are cached once they're imported.
+	EmbeddedMetadata *MetadataExtractor
+	// SubjectTagger, if set, adds tags made from the subjects in an EPUB file's metadata to the file, before PreImportHooks are run.
+	SubjectTagger *SubjectTagger
+	// IdempotencyKey, if set, identifies the import, so retrying it with the same key doesn't import the book again.
+	IdempotencyKey string
+	// ContentSignatures calculates the content signature of EPUB files, and doesn't import a file
+	// if a file with the same signature is already in the library, even if its metadata differs.
+	ContentSignatures bool
+	// Screeners check the file, in order, before it's copied into the books root, and a file any of them flags isn't imported.
+	Screeners []FileScreener
+	// Quarantine moves files flagged by a screener to the library's quarantine directory. Otherwise, they're left where they are.
+	Quarantine bool
+	// Report, if set, records whether the book was imported or was a duplicate, and the metadata it was given.
+	Report *ImportReport
+	// Parser is the name of the metadata parser which matched the book, which is recorded in Report.
+	Parser string
+	// Strict rejects books with no title or authors, empty files, and files whose hash doesn't match their contents,
+	// with a *ValidationError, rather than importing them as they are. The book is checked after PreImportHooks are run.
+	Strict bool
+
+	// metadataOnly records the file in the library without reading it or storing it in the books root.
+	// It's used to generate test libraries without real files.
+	metadataOnly bool
+}
+
+// A ConflictResolver decides which existing book, if any, a file being imported belongs to.
+type ConflictResolver interface {
+	// Resolve is passed the book being imported, and the existing books which could plausibly be the same book.
+	// It returns the ID of one of the candidates to add the file to that book, or 0 to create a new book.
+	Resolve(book Book, candidates []Book) (int64, error)
+}
+
+// ConflictResolverFunc is an adapter to allow the use of ordinary functions as ConflictResolvers.
+type ConflictResolverFunc func(book Book, candidates []Book) (int64, error)
+
+// Resolve calls f(book, candidates).
+func (f ConflictResolverFunc) Resolve(book Book, candidates []Book) (int64, error) {
+	return f(book, candidates)
+}
+
+// resolveConflict finds books which plausibly match book, and asks resolver to choose between them.
+// found will be false if there are no candidates, or the resolver chose to create a new book.
+func resolveConflict(tx *sql.Tx, book Book, resolver ConflictResolver) (id int64, found bool, err error) {
+	candidates, err := getConflictCandidates(tx, book.Title, book.Authors)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "get conflicting books")
+	}
+	if len(candidates) == 0 {
+		return 0, false, nil
+	}
+	id, err = resolver.Resolve(book, candidates)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "resolve conflicting books")
+	}
+	if id == 0 {
+		return 0, false, nil
+	}
+	for _, c := range candidates {
+		if c.ID == id {
+			return id, true, nil
+		}
+	}
+	return 0, false, errors.Errorf("conflict resolver chose book %d, which is not a candidate", id)
+}
+
+// getConflictCandidates returns books with the given title (ignoring case) which share at least one author with authors.
+func getConflictCandidates(tx *sql.Tx, title string, authors []string) ([]Book, error) {
+	ids, err := queryInt64s(tx, "select id from books where title = ? collate nocase order by id", title)
+	if err != nil {
+		return nil, err
+	}
+	books, err := getBooksByID(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	wanted := authorSet(authors)
+	var candidates []Book
+	for _, b := range books {
+		for _, a := range b.Authors {
+			if wanted[normalizeAuthor(a)] {
+				candidates = append(candidates, b)
+				break
+			}
+		}
+	}
+	return candidates, nil
+}
@@ -0,0 +1,204 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ImportStatus is what happened to a file in an import.
+type ImportStatus string
+
+// Import statuses.
+const (
+	// ImportImported is a file added to the library.
+	ImportImported ImportStatus = "imported"
+	// ImportDuplicate is a file which wasn't imported, because it, or a file with the same content, is already in the library.
+	ImportDuplicate ImportStatus = "duplicate"
+	// ImportFailed is a file which couldn't be imported.
+	ImportFailed ImportStatus = "failed"
+)
+
+// ImportGuess is the metadata an imported file was given, from its filename or contents.
+type ImportGuess struct {
+	// Parser is the metadata parser which matched the file, if known.
+	Parser      string   `json:"parser,omitempty"`
+	Authors     []string `json:"authors"`
+	Title       string   `json:"title"`
+	Series      string   `json:"series,omitempty"`
+	SeriesIndex float64  `json:"series_index,omitempty"`
+	Published   string   `json:"published,omitempty"`
+}
+
+// guessFromBook returns the metadata of book, which was matched by parser.
+func guessFromBook(book Book, parser string) ImportGuess {
+	return ImportGuess{Parser: parser, Authors: book.Authors, Title: book.Title, Series: book.Series, SeriesIndex: book.SeriesIndex, Published: book.Published}
+}
+
+// ImportReportEntry is the outcome of importing one file.
+type ImportReportEntry struct {
+	File   string       `json:"file"`
+	Status ImportStatus `json:"status"`
+	// BookID is the book the file was imported into, or which already has it.
+	BookID int64 `json:"book_id,omitempty"`
+	// FileID is the imported file.
+	FileID int64 `json:"file_id,omitempty"`
+	// Reason explains why a file is a duplicate, or why it failed.
+	Reason   string      `json:"reason,omitempty"`
+	Metadata ImportGuess `json:"metadata"`
+}
+
+// ImportReport records the outcome of each file in a batch import, such as a run of the import command.
+// It's passed to ImportBookWithOptions in ImportOptions, which records imported and duplicate files,
+// and callers record files which fail with Fail. It's safe to use from more than one goroutine.
+type ImportReport struct {
+	ID         int64               `json:"id"`
+	StartedOn  time.Time           `json:"started_on"`
+	FinishedOn time.Time           `json:"finished_on"`
+	Imported   int                 `json:"imported"`
+	Duplicates int                 `json:"duplicates"`
+	Failures   int                 `json:"failures"`
+	Entries    []ImportReportEntry `json:"entries"`
+
+	mu sync.Mutex
+}
+
+// NewImportReport starts a report of an import, which starts now.
+func NewImportReport() *ImportReport {
+	return &ImportReport{StartedOn: time.Now().UTC(), Entries: []ImportReportEntry{}}
+}
+
+// add records the outcome of a file.
+func (r *ImportReport) add(e ImportReportEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch e.Status {
+	case ImportImported:
+		r.Imported++
+	case ImportDuplicate:
+		r.Duplicates++
+	case ImportFailed:
+		r.Failures++
+	}
+	r.Entries = append(r.Entries, e)
+}
+
+// Fail records that a file couldn't be imported, with the metadata it was given, if any, and the error.
+// It does nothing if r is nil, so callers needn't check whether they're keeping a report.
+func (r *ImportReport) Fail(file string, guess ImportGuess, err error) {
+	r.add(ImportReportEntry{File: file, Status: ImportFailed, Reason: err.Error(), Metadata: guess})
+}
+
+// WriteText writes the report to w for people to read: a summary, followed by a line for each file.
+func (r *ImportReport) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Import report %d, %s to %s\n", r.ID, r.StartedOn.Local().Format(time.RFC1123), r.FinishedOn.Local().Format(time.RFC1123))
+	fmt.Fprintf(&sb, "%d imported, %d duplicates, %d failed\n", r.Imported, r.Duplicates, r.Failures)
+	for _, e := range r.Entries {
+		fmt.Fprintf(&sb, "\n%s: %s\n", e.Status, e.File)
+		if e.Metadata.Title != "" || len(e.Metadata.Authors) > 0 {
+			name := JoinNaturally("and", e.Metadata.Authors) + " - " + e.Metadata.Title
+			if e.Metadata.Series != "" {
+				name += " [" + e.Metadata.Series
+				if e.Metadata.SeriesIndex != 0 {
+					name += " " + strconv.FormatFloat(e.Metadata.SeriesIndex, 'f', -1, 64)
+				}
+				name += "]"
+			}
+			if e.Metadata.Published != "" {
+				name += ", " + e.Metadata.Published
+			}
+			if e.Metadata.Parser != "" {
+				name += " (from " + e.Metadata.Parser + ")"
+			}
+			fmt.Fprintf(&sb, "  %s\n", name)
+		}
+		if e.BookID != 0 {
+			fmt.Fprintf(&sb, "  Book %d\n", e.BookID)
+		}
+		if e.Reason != "" {
+			fmt.Fprintf(&sb, "  %s\n", e.Reason)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// SaveImportReport finishes a report, and stores it in the library, setting its ID.
+func (lib *Library) SaveImportReport(r *ImportReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedOn = time.Now().UTC()
+	entries, err := json.Marshal(r.Entries)
+	if err != nil {
+		return errors.Wrap(err, "encode import report")
+	}
+	res, err := lib.Exec("insert into import_reports (started_on, finished_on, imported, duplicates, failures, entries) values(?, ?, ?, ?, ?, ?)",
+		r.StartedOn, r.FinishedOn, r.Imported, r.Duplicates, r.Failures, string(entries))
+	if err != nil {
+		return errors.Wrap(err, "save import report")
+	}
+	r.ID, err = res.LastInsertId()
+	return errors.Wrap(err, "get import report ID")
+}
+
+// ImportReports returns the stored import reports, newest first, without their entries.
+// Set limit to 0 to return all of them.
+func (lib *Library) ImportReports(limit int) ([]*ImportReport, error) {
+	query := "select " + importReportRowColumns + " from import_reports order by id desc"
+	var args []interface{}
+	if limit > 0 {
+		query += " limit ?"
+		args = append(args, limit)
+	}
+	rows, err := lib.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "get import reports")
+	}
+	defer rows.Close()
+	reports := []*ImportReport{}
+	for rows.Next() {
+		row, err := scanImportReportRow(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "get import reports")
+		}
+		reports = append(reports, row.report())
+	}
+	return reports, errors.Wrap(rows.Err(), "get import reports")
+}
+
+// GetImportReport returns the stored import report with an ID, and whether it was found.
+func (lib *Library) GetImportReport(id int64) (*ImportReport, bool, error) {
+	row, err := scanImportReportRow(lib.QueryRow("select "+importReportRowColumns+" from import_reports where id=?", id))
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, errors.Wrapf(err, "get import report %d", id)
+	}
+	// Reports are never removed, so the entries of one which was found can be read separately.
+	r := row.report()
+	var entries string
+	if err := lib.QueryRow("select entries from import_reports where id=?", id).Scan(&entries); err != nil {
+		return nil, false, errors.Wrapf(err, "get entries of import report %d", id)
+	}
+	if err := json.Unmarshal([]byte(entries), &r.Entries); err != nil {
+		return nil, false, errors.Wrapf(err, "decode import report %d", id)
+	}
+	return r, true, nil
+}
@@ -0,0 +1,136 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// AdoptFiles copies the files cataloged in place of the books matching query, or of all books if query is empty,
+// into the books roots, where they're stored like imported files, named by tmpl.
+// Either all of the files are adopted, or, if any of them can't be, such as because it changed since it was cataloged,
+// none are, and nothing is left in the books roots.
+// The originals are left where they are; the adopted files are returned as they were, with the paths they were cataloged at,
+// so they can be removed.
+func (lib *Library) AdoptFiles(query string, tmpl *template.Template) ([]BookFile, error) {
+	var ids []int64
+	var err error
+	if strings.TrimSpace(query) == "" {
+		ids, err = lib.ListBookIDs(SortByID, false)
+	} else {
+		var results []SearchResult
+		results, _, err = lib.SearchWithOptions(query, SearchOptions{})
+		for _, r := range results {
+			ids = append(ids, r.Book.ID)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "find books")
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	unlock, err := lib.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	fileIDs, err := queryInt64s(tx, "select id from files where path != '' and book_id in ("+joinInt64s(ids, ",")+") order by id")
+	if err != nil {
+		return nil, errors.Wrap(err, "find files cataloged in place")
+	}
+	if len(fileIDs) == 0 {
+		return nil, nil
+	}
+	files, err := getFilesByID(tx, fileIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "get files")
+	}
+	bookIDs, err := queryInt64s(tx, "select distinct book_id from files where id in ("+joinInt64s(fileIDs, ",")+")")
+	if err != nil {
+		return nil, errors.Wrap(err, "get books of files")
+	}
+	bks, err := getBooksByID(tx, bookIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	bookOf := make(map[int64]*Book)
+	for i := range bks {
+		for _, f := range bks[i].Files {
+			bookOf[f.ID] = &bks[i]
+		}
+	}
+
+	// placed are the copies made so far, which are removed again if the adoption fails.
+	var placed []string
+	undo := func() {
+		for _, fn := range placed {
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing %s after failed adoption: %s", fn, err)
+			}
+		}
+	}
+	for _, f := range files {
+		hash, err := hashFile(f.Path)
+		if err != nil {
+			undo()
+			return nil, errors.Wrapf(err, "hash file %d", f.ID)
+		}
+		if hash != f.Hash {
+			undo()
+			return nil, errors.Errorf("file %d at %s has changed since it was cataloged", f.ID, f.Path)
+		}
+		managed := f
+		managed.Path = ""
+		managed.OriginalFilename = f.Path
+		if managed.Root, err = lib.placeFile(tx, managed); err != nil {
+			undo()
+			return nil, err
+		}
+		if managed.CurrentFilename, err = lib.generateFilename(&managed, tmpl, bookOf[f.ID]); err != nil {
+			undo()
+			return nil, errors.Wrapf(err, "generate filename of file %d", f.ID)
+		}
+		fn, err := lib.insertFile(managed, false)
+		if fn != "" {
+			placed = append(placed, fn)
+		}
+		if err != nil {
+			undo()
+			return nil, errors.Wrapf(err, "copy file %d", f.ID)
+		}
+		if _, err := tx.Exec("update files set updated_on=datetime(), path='', root=?, filename=? where id=?", managed.Root, managed.CurrentFilename, f.ID); err != nil {
+			undo()
+			return nil, errors.Wrapf(err, "update file %d", f.ID)
+		}
+	}
+	for _, id := range bookIDs {
+		if err := indexBook(tx, id); err != nil {
+			undo()
+			return nil, errors.Wrap(err, "index book in search")
+		}
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookIDs...)
+	if err != nil {
+		undo()
+		return nil, errors.Wrap(err, "commit")
+	}
+	for _, f := range files {
+		log.Printf("Adopted file %d from %s", f.ID, f.Path)
+	}
+	return files, nil
+}
@@ -0,0 +1,202 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IntegrityError is returned when SQLite's integrity check finds that the library file is damaged.
+type IntegrityError struct {
+	// Problems are the problems reported by the check.
+	Problems []string
+}
+
+func (e IntegrityError) Error() string {
+	return "library is damaged: " + strings.Join(e.Problems, "; ")
+}
+
+// CheckIntegrity checks the library file for damage, such as corrupt pages or indexes, and returns an IntegrityError if it finds any.
+// It reads the whole file, so it can take a while for large libraries.
+func (lib *Library) CheckIntegrity() error {
+	return checkIntegrity(lib.DB)
+}
+
+func checkIntegrity(db *sql.DB) error {
+	rows, err := db.Query("pragma integrity_check")
+	if err != nil {
+		return errors.Wrap(err, "check integrity")
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return errors.Wrap(err, "check integrity")
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "check integrity")
+	}
+	if len(problems) > 0 {
+		return IntegrityError{Problems: problems}
+	}
+	return nil
+}
+
+// An OrphanedRow is a row which refers to a row that doesn't exist in another table,
+// such as a file of a deleted book.
+// Foreign keys are enforced when the library is opened, so they're only left by changes made with other programs,
+// or by older versions which didn't enforce them.
+type OrphanedRow struct {
+	// Table is the table the row is in.
+	Table string
+	// RowID is the row's ID.
+	RowID int64
+	// Parent is the table of the missing row it refers to.
+	Parent string
+	// Column is the column which refers to the missing row.
+	Column string
+	// SetNull is true if the column is set to null when the row it refers to is deleted, rather than the row being deleted with it.
+	SetNull bool
+}
+
+// CheckForeignKeys returns the rows which refer to rows that don't exist.
+func (lib *Library) CheckForeignKeys() ([]OrphanedRow, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	return checkForeignKeys(tx)
+}
+
+func checkForeignKeys(tx *sql.Tx) ([]OrphanedRow, error) {
+	rows, err := tx.Query("pragma foreign_key_check")
+	if err != nil {
+		return nil, errors.Wrap(err, "check foreign keys")
+	}
+	type violation struct {
+		table, parent string
+		rowID, fkID   int64
+	}
+	var violations []violation
+	for rows.Next() {
+		var v violation
+		if err := rows.Scan(&v.table, &v.rowID, &v.parent, &v.fkID); err != nil {
+			rows.Close()
+			return nil, errors.Wrap(err, "check foreign keys")
+		}
+		violations = append(violations, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "check foreign keys")
+	}
+
+	orphans := make([]OrphanedRow, 0, len(violations))
+	for _, v := range violations {
+		column, onDelete, err := foreignKey(tx, v.table, v.fkID)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, OrphanedRow{Table: v.table, RowID: v.rowID, Parent: v.parent, Column: column, SetNull: onDelete == "SET NULL"})
+	}
+	return orphans, nil
+}
+
+// foreignKey returns the column and on delete action of the foreign key of table with the ID reported by foreign_key_check.
+func foreignKey(tx *sql.Tx, table string, id int64) (column, onDelete string, err error) {
+	// Pragmas can't take bound parameters.
+	rows, err := tx.Query("pragma foreign_key_list(" + quoteIdentifier(table) + ")")
+	if err != nil {
+		return "", "", errors.Wrapf(err, "get foreign keys of %s", table)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", "", errors.Wrapf(err, "get foreign keys of %s", table)
+	}
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return "", "", errors.Wrapf(err, "get foreign keys of %s", table)
+		}
+		fk := make(map[string]string, len(cols))
+		for i, c := range cols {
+			fk[c] = values[i].String
+		}
+		if fk["id"] == strconv.FormatInt(id, 10) {
+			return fk["from"], strings.ToUpper(fk["on_delete"]), nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", "", errors.Wrapf(err, "get foreign keys of %s", table)
+	}
+	return "", "", errors.Errorf("foreign key %d of %s not found", id, table)
+}
+
+// RepairForeignKeys fixes the rows which refer to rows that don't exist, as if the missing rows had been deleted while foreign keys were enforced:
+// columns which are set to null on delete are set to null, and other rows are deleted, along with the rows which depend on them.
+// It returns the rows which were repaired.
+func (lib *Library) RepairForeignKeys() ([]OrphanedRow, error) {
+	unlock, err := lib.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	orphans, err := checkForeignKeys(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, o := range orphans {
+		if _, err := repairOrphanedRow(tx, o); err != nil {
+			tx.Rollback()
+			return nil, errors.Wrapf(err, "repair row %d of %s", o.RowID, o.Table)
+		}
+	}
+	err = tx.Commit()
+	lib.invalidateCache()
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	if len(orphans) > 0 {
+		log.Printf("Repaired %d rows which referred to missing rows", len(orphans))
+	}
+	return orphans, nil
+}
+
+// repairOrphanedRow sets the column of an orphaned row to null, if it's set to null on delete, or deletes the row.
+func repairOrphanedRow(tx *sql.Tx, o OrphanedRow) (sql.Result, error) {
+	var query string
+	if o.SetNull {
+		query = "update " + quoteIdentifier(o.Table) + " set " + quoteIdentifier(o.Column) + "=null where rowid=?"
+	} else {
+		query = "delete from " + quoteIdentifier(o.Table) + " where rowid=?"
+	}
+	return tx.Exec(query, o.RowID)
+}
+
+// quoteIdentifier quotes a table or column name for use in SQL.
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
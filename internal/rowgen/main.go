@@ -0,0 +1,173 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+// Rowgen generates the code which scans database rows into typed row structs.
+//
+// Each field of a row struct is a column, given by its db tag, which is the SQL selected for it, such as id or coalesce(title, 'Untitled').
+// A field whose SQL is too long for a tag can instead have a dbconst tag, naming a string constant in the package holding its SQL.
+// For a row struct named fooRow, rowgen generates fooRowColumns, the columns to select, in order,
+// and scanFooRow, which scans a row selected with them, so the columns and the fields they're scanned into can't get out of step.
+//
+// Usage:
+//
+//	rowgen -output rows_gen.go -type bookRow,fileRow rows.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// column is a field of a row struct and the SQL selected for it.
+type column struct {
+	field string
+	// sql is the SQL of the column, or if constant is true, the name of the constant holding it.
+	sql      string
+	constant bool
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("rowgen: ")
+	output := flag.String("output", "", "file to write the generated code to")
+	types := flag.String("type", "", "comma-separated names of the row structs")
+	flag.Parse()
+	if flag.NArg() != 1 || *output == "" || *types == "" {
+		fmt.Fprintln(os.Stderr, "Usage: rowgen -output file -type names file.go")
+		os.Exit(2)
+	}
+	src := flag.Arg(0)
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, src, nil, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by rowgen from %s; DO NOT EDIT.\n\npackage %s\n", src, f.Name.Name)
+	for _, name := range strings.Split(*types, ",") {
+		st, err := findStruct(f, name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		columns, err := structColumns(st)
+		if err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+		writeRow(&buf, name, columns)
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("format generated code: %s", err)
+	}
+	if err := ioutil.WriteFile(*output, code, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// findStruct returns the struct type named name declared in f.
+func findStruct(f *ast.File, name string) (*ast.StructType, error) {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.Name.Name != name {
+				continue
+			}
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a struct", name)
+			}
+			return st, nil
+		}
+	}
+	return nil, fmt.Errorf("no struct named %s", name)
+}
+
+// structColumns returns the columns of the fields of st, in order.
+func structColumns(st *ast.StructType) ([]column, error) {
+	var columns []column
+	for _, field := range st.Fields.List {
+		if len(field.Names) != 1 || field.Tag == nil {
+			return nil, fmt.Errorf("every field must be named on its own line and have a db or dbconst tag")
+		}
+		name := field.Names[0].Name
+		tagValue, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			return nil, fmt.Errorf("tag of %s: %s", name, err)
+		}
+		tag := reflect.StructTag(tagValue)
+		if sql, ok := tag.Lookup("db"); ok && sql != "" {
+			columns = append(columns, column{field: name, sql: sql})
+		} else if constant, ok := tag.Lookup("dbconst"); ok && constant != "" {
+			columns = append(columns, column{field: name, sql: constant, constant: true})
+		} else {
+			return nil, fmt.Errorf("%s has no db or dbconst tag", name)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns")
+	}
+	return columns, nil
+}
+
+// writeRow writes the column list and scan function of the row struct name.
+func writeRow(buf *bytes.Buffer, name string, columns []column) {
+	// The columns given as SQL are joined into string literals, which the constants are concatenated with.
+	type part struct {
+		text    string
+		literal bool
+	}
+	var parts []part
+	addLiteral := func(s string) {
+		if n := len(parts); n > 0 && parts[n-1].literal {
+			parts[n-1].text += s
+			return
+		}
+		parts = append(parts, part{s, true})
+	}
+	for i, c := range columns {
+		if i > 0 {
+			addLiteral(", ")
+		}
+		if c.constant {
+			parts = append(parts, part{c.sql, false})
+		} else {
+			addLiteral(c.sql)
+		}
+	}
+	exprs := make([]string, len(parts))
+	for i, p := range parts {
+		exprs[i] = p.text
+		if p.literal {
+			exprs[i] = strconv.Quote(p.text)
+		}
+	}
+
+	dests := make([]string, len(columns))
+	for i, c := range columns {
+		dests[i] = "&r." + c.field
+	}
+	scanName := "scan" + string(unicode.ToUpper(rune(name[0]))) + name[1:]
+	fmt.Fprintf(buf, "\n// %sColumns are the columns of %s, in the order %s scans them.\n", name, name, scanName)
+	fmt.Fprintf(buf, "const %sColumns = %s\n", name, strings.Join(exprs, " + "))
+	fmt.Fprintf(buf, "\n// %s scans a row selected with %sColumns.\n", scanName, name)
+	fmt.Fprintf(buf, "func %s(row rowScanner) (%s, error) {\n\tvar r %s\n", scanName, name, name)
+	fmt.Fprintf(buf, "\terr := row.Scan(%s)\n\treturn r, err\n}\n", strings.Join(dests, ", "))
+}
@@ -0,0 +1,109 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// koboContentPrefix is where Kobo devices see their onboard storage.
+const koboContentPrefix = "file:///mnt/onboard/"
+
+// KoboDatabasePath returns the path of the KoboReader.sqlite database on a Kobo mounted at root.
+func KoboDatabasePath(root string) string {
+	return filepath.Join(root, ".kobo", "KoboReader.sqlite")
+}
+
+// TagCollections returns the tags of a synced file and its book, for use as its collections.
+func TagCollections(df DeviceFile) []string {
+	b := Book{Tags: df.Book.Tags, Files: []BookFile{df.File}}
+	return b.AllTags()
+}
+
+// WriteKoboCollections adds files synced to a Kobo mounted at root to collections (shelves) on the device,
+// by writing to the device's KoboReader.sqlite database.
+// collections returns the names of the collections each file belongs to; if nil, TagCollections is used.
+// Collections which don't exist on the device are created.
+// The device must be ejected for the changes to take effect.
+func WriteKoboCollections(root string, files []DeviceFile, collections func(DeviceFile) []string) error {
+	if collections == nil {
+		collections = TagCollections
+	}
+	dbPath := KoboDatabasePath(root)
+	if _, err := os.Stat(dbPath); err != nil {
+		return errors.Wrap(err, "find Kobo database")
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return errors.Wrap(err, "open Kobo database")
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	for _, df := range files {
+		contentID := koboContentPrefix + filepath.ToSlash(df.Path)
+		for _, name := range collections(df) {
+			if err := ensureKoboShelf(tx, name, now); err != nil {
+				tx.Rollback()
+				return errors.Wrapf(err, "create collection %s", name)
+			}
+			if err := addToKoboShelf(tx, name, contentID, now); err != nil {
+				tx.Rollback()
+				return errors.Wrapf(err, "add %s to collection %s", df.Path, name)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
+
+// ensureKoboShelf creates a shelf if it doesn't exist, or restores it if it was deleted.
+func ensureKoboShelf(tx *sql.Tx, name, now string) error {
+	var deleted string
+	err := tx.QueryRow("select _IsDeleted from Shelf where InternalName=?", name).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec(`insert into Shelf (CreationDate, InternalName, LastModified, Name, _IsDeleted, _IsVisible, _IsSynced, Id)
+		values (?, ?, ?, ?, 'false', 'true', 'false', ?)`, now, name, now, name, name)
+		if err == nil {
+			log.Printf("Created Kobo collection %s", name)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+	if deleted == "true" {
+		_, err = tx.Exec("update Shelf set _IsDeleted='false', _IsVisible='true', LastModified=? where InternalName=?", now, name)
+	}
+	return err
+}
+
+// addToKoboShelf adds content to a shelf, unless it's already there.
+func addToKoboShelf(tx *sql.Tx, name, contentID, now string) error {
+	var deleted string
+	err := tx.QueryRow("select _IsDeleted from ShelfContent where ShelfName=? and ContentId=?", name, contentID).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec(`insert into ShelfContent (ShelfName, ContentId, DateModified, _IsDeleted, _IsSynced)
+		values (?, ?, ?, 'false', 'false')`, name, contentID, now)
+		return err
+	} else if err != nil {
+		return err
+	}
+	if deleted == "true" {
+		_, err = tx.Exec("update ShelfContent set _IsDeleted='false', DateModified=? where ShelfName=? and ContentId=?", now, name, contentID)
+	}
+	return err
+}
@@ -14,7 +14,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
@@ -33,6 +35,9 @@ func (bee BookExistsError) Error() string {
 // ErrBookNotFound is returned when a book is not found in the database.
 var ErrBookNotFound = errors.New("book not found")
 
+// ErrFileNotFound is returned when a file is not found in the database.
+var ErrFileNotFound = errors.New("file not found")
+
 var initialSchema = `create table books (
 id integer primary key,
 created_on timestamp not null default (datetime()),
@@ -95,34 +100,137 @@ create virtual table books_fts using fts4 (author, series, title, extension, tag
 create index idx_books_nocase_title on books(title collate nocase);
 `
 
-func init() {
-	// Add a connect hook to set synchronous = off for all connections.
-	// This improves performance, especially during import,
-	// but since changes aren't immediately synced to disk, data could be lost during a power outage or sudden OS crash.
-	sql.Register("sqlite3async",
-		&sqlite3.SQLiteDriver{
-			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-				conn.Exec("pragma foreign_keys=on", []driver.Value{})
-				conn.Exec("pragma synchronous=off", []driver.Value{})
-				return nil
-			},
-		})
+// execPragmas runs pragmas on a new connection.
+func execPragmas(conn *sqlite3.SQLiteConn, pragmas ...string) error {
+	for _, p := range pragmas {
+		if _, err := conn.Exec(p, []driver.Value{}); err != nil {
+			return errors.Wrap(err, p)
+		}
+	}
+	return nil
 }
 
 // Library represents a set of books in persistent storage.
 type Library struct {
 	*sql.DB
-	filename  string
-	booksRoot string
+	filename    string
+	booksRoot   string
+	cache       *metadataCache
+	durable     bool
+	pdfRenderer PDFRenderer
+	lockTimeout time.Duration
+	// lockMu serializes holders of the library's advisory lock within the process, as described in lock.go.
+	lockMu       sync.Mutex
+	perms        Permissions
+	copyProgress ProgressFunc
+	paths        LibraryPaths
+	filenames    FilenamePolicy
+	roots        []BooksRoot
+	placement    PlacementPolicy
+	remoteCache  *remoteCache
+}
+
+// LibraryOptions control how a library is opened, in OpenLibraryWithOptions.
+type LibraryOptions struct {
+	// Durable makes changes to the library survive a power failure or OS crash, at the cost of speed.
+	// Database writes are synchronous, and files imported into the books root are flushed to disk, along with their directories,
+	// before the import is committed.
+	Durable bool
+	// PDFRenderer renders the pages of PDF files for RenderPDFPreview. By default, pdftoppm is used.
+	PDFRenderer PDFRenderer
+	// LockTimeout is how long to wait for the library while another process has it locked.
+	// If it is 0, DefaultLockTimeout is used.
+	LockTimeout time.Duration
+	// Permissions sets the modes and owner of the files and directories created in the books root.
+	Permissions Permissions
+	// CopyProgress, if not nil, is called as large files are copied into the books root.
+	// Done and Total are in bytes.
+	CopyProgress ProgressFunc
+	// Roots are books roots for files, in addition to the main root passed to OpenLibraryWithOptions,
+	// such as an external drive. Each must have a unique name, which files on it are recorded with.
+	Roots []BooksRoot
+	// Placement chooses which root imported files are placed on. By default, it's PlaceFirst.
+	// Roots without enough free space or quota left for a file are skipped.
+	Placement PlacementPolicy
+	// Quota is the most bytes the files on the main root can take up, or 0 for no limit.
+	Quota int64
+	// FilenamePolicy is applied to the filenames generated for files from the output template.
+	FilenamePolicy FilenamePolicy
+	// Locale is the BCP 47 language tag, such as "sv" or "de-DE", whose rules books are sorted by.
+	// If it's empty, the Unicode collation algorithm's default order is used, which suits most languages.
+	Locale string
+	// IntegrityCheck checks the library file for damage when it's opened, and returns an IntegrityError instead of opening a damaged library.
+	// The whole file is read, so opening large libraries is slower.
+	IntegrityCheck bool
+	// RemoteCache controls how files on books roots marked Remote are downloaded and cached.
+	RemoteCache RemoteCacheOptions
 }
 
 // OpenLibrary opens a library stored in a file.
+// The directories the library keeps its other files in, described by Paths, are created next to it if they don't exist.
 func OpenLibrary(filename, booksRoot string) (*Library, error) {
-	db, err := sql.Open("sqlite3async", filename)
+	return OpenLibraryWithOptions(filename, booksRoot, LibraryOptions{})
+}
+
+// OpenLibraryWithOptions opens a library stored in a file, as described in OpenLibrary, with behavior controlled by opts.
+func OpenLibraryWithOptions(filename, booksRoot string, opts LibraryOptions) (*Library, error) {
+	paths, err := newLibraryPaths(filename, booksRoot)
 	if err != nil {
+		return nil, errors.Wrap(err, "get library paths")
+	}
+	if err := paths.prepare(); err != nil {
 		return nil, err
 	}
-	return &Library{db, filename, booksRoot}, nil
+	roots, err := newBooksRoots(booksRoot, opts.Quota, opts.Roots)
+	if err != nil {
+		return nil, err
+	}
+	locale, err := parseLocale(opts.Locale)
+	if err != nil {
+		return nil, err
+	}
+	// Connections set synchronous = off, which improves performance, especially during import,
+	// but since changes aren't immediately synced to disk, data could be lost during a power outage or sudden OS crash.
+	// Durable libraries use synchronous = normal, so a power outage or OS crash can't lose committed changes.
+	driverName := sqliteDriver("off", locale)
+	if opts.Durable {
+		driverName = sqliteDriver("normal", locale)
+	}
+	timeout := opts.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	db, err := sql.Open(driverName, filename+"?_busy_timeout="+strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+	if err != nil {
+		return nil, err
+	}
+	// Hold the lock while migrating, so two processes opening an old library don't both try to migrate it.
+	unlock, err := lockLibrary(filename, timeout)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if opts.IntegrityCheck {
+		if err := checkIntegrity(db); err != nil {
+			unlock()
+			db.Close()
+			return nil, err
+		}
+	}
+	err = migrate(db)
+	unlock()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "migrate library")
+	}
+	return &Library{DB: db, filename: filename, booksRoot: booksRoot, durable: opts.Durable, pdfRenderer: opts.PDFRenderer, lockTimeout: timeout, perms: opts.Permissions, copyProgress: opts.CopyProgress, paths: paths, filenames: opts.FilenamePolicy, roots: roots, placement: opts.Placement, remoteCache: newRemoteCache(paths.RemoteCache, opts.RemoteCache)}, nil
+}
+
+// CreateOptions controls how a new library is set up.
+type CreateOptions struct {
+	// SearchFields are the fields indexed for searching, from AllSearchFields. If empty, DefaultSearchFields are indexed.
+	// They can be changed later with SetSearchFields.
+	SearchFields []string
 }
 
 // CreateLibrary initializes a new library in the specified file.
@@ -130,6 +238,18 @@ func OpenLibrary(filename, booksRoot string) (*Library, error) {
 // Warning: This function sets up a new library for the first time. To get a Library based on an existing library file,
 // call OpenLibrary.
 func CreateLibrary(filename string) error {
+	return CreateLibraryWithOptions(filename, CreateOptions{})
+}
+
+// CreateLibraryWithOptions initializes a new library in the specified file, as described in CreateLibrary, set up according to opts.
+func CreateLibraryWithOptions(filename string, opts CreateOptions) error {
+	fields := DefaultSearchFields
+	if len(opts.SearchFields) > 0 {
+		var err error
+		if fields, err = normalizeSearchFields(opts.SearchFields); err != nil {
+			return errors.Wrap(err, "Create library")
+		}
+	}
 	log.Printf("Creating library in %s\n", filename)
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
@@ -141,6 +261,12 @@ func CreateLibrary(filename string) error {
 	if err != nil {
 		return errors.Wrap(err, "Create library")
 	}
+	if err := migrate(db); err != nil {
+		return errors.Wrap(err, "Create library")
+	}
+	if _, err := db.Exec(sqlCreateSearchIndex(fields)); err != nil {
+		return errors.Wrap(err, "Create library")
+	}
 
 	log.Printf("Library created in %s\n", filename)
 	return nil
@@ -148,176 +274,455 @@ func CreateLibrary(filename string) error {
 
 // ImportBook adds a book to a library.
 // The file referred to by book.OriginalFilename will either be copied or moved to the location referred to by book.CurrentFilename, relative to the configured books root.
+// The original filename is recorded as an absolute path, resolved from the current directory if it's relative.
 // The book will not be imported if another book already in the library has the same hash.
 func (lib *Library) ImportBook(book Book, tmpl *template.Template, move bool) error {
-	if len(book.Files) != 1 {
-		return errors.New("Book to import must contain only one file")
+	return lib.ImportBookWithOptions(book, tmpl, ImportOptions{Move: move})
+}
+
+// ImportBookWithOptions adds a book to a library, as described in ImportBook, with behavior controlled by opts.
+func (lib *Library) ImportBookWithOptions(book Book, tmpl *template.Template, opts ImportOptions) error {
+	p, err := lib.prepareImport(book, opts)
+	if err != nil {
+		return err
+	}
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 	tx, err := lib.Begin()
 	if err != nil {
 		return err
 	}
-
-	existingBookID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors)
+	out, err := lib.importPrepared(tx, p, tmpl, opts)
 	if err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "find existing book")
+		lib.undoPlacedFile(out)
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(out.book.ID)
+	if err != nil {
+		lib.undoPlacedFile(out)
+		return errors.Wrap(err, "import book")
+	}
+	lib.finishImport(p, out, opts)
+	return nil
+}
+
+// preparedImport is a book ready to be imported, with what was found out about its file before the library is locked.
+type preparedImport struct {
+	book             Book
+	guess            ImportGuess
+	contentSignature sql.NullString
+}
+
+// prepareImport screens the file of a book being imported, and reads what's needed from it, before the library is locked.
+// If the file is flagged by a screener, a pre-import hook fails, or the book is invalid in strict mode, the book isn't imported.
+func (lib *Library) prepareImport(book Book, opts ImportOptions) (*preparedImport, error) {
+	if len(book.Files) != 1 {
+		return nil, errors.New("Book to import must contain only one file")
+	}
+	if !opts.metadataOnly {
+		fn, err := normalizeOriginalFilename(book.Files[0].OriginalFilename)
+		if err != nil {
+			return nil, err
+		}
+		book.Files[0].OriginalFilename = fn
+		// Files are screened before anything reads them, including subject tagging and hooks.
+		var quarantineDir string
+		if opts.Quarantine {
+			quarantineDir = lib.paths.Quarantine
+		}
+		if err := screenFile(opts.Screeners, book.Files[0].OriginalFilename, quarantineDir); err != nil {
+			return nil, errors.Wrap(err, "screen file")
+		}
+		if opts.EmbeddedMetadata != nil {
+			if embedded, err := opts.EmbeddedMetadata.Extract(fn); err == nil {
+				mergeEmbeddedMetadata(&book, embedded)
+			} else if errors.Cause(err) != ErrNoMetadata {
+				log.Printf("Cannot read metadata of %s: %s", fn, err)
+			}
+		}
+	}
+	if opts.SubjectTagger != nil && !opts.metadataOnly {
+		if err := tagFromSubjects(&book.Files[0], opts.SubjectTagger); err != nil {
+			log.Printf("Cannot read subjects of %s: %s", book.Files[0].OriginalFilename, err)
+		}
+	}
+	if book.Subtitle == "" {
+		splitSubtitle(&book)
+	}
+	if err := runPreImportHooks(opts.PreImportHooks, &book); err != nil {
+		return nil, errors.Wrap(err, "pre-import hook")
+	}
+	if opts.Strict {
+		if err := validateImport(book, !opts.metadataOnly); err != nil {
+			return nil, err
+		}
+	}
+	p := &preparedImport{book: book}
+	if opts.ContentSignatures && !opts.metadataOnly && strings.ToLower(book.Files[0].Extension) == "epub" {
+		if signature, err := ContentSignature(book.Files[0].OriginalFilename); err == nil {
+			p.contentSignature = sql.NullString{String: signature, Valid: true}
+		} else {
+			log.Printf("Cannot calculate content signature of %s: %s", book.Files[0].OriginalFilename, err)
+		}
+	}
+	p.guess = guessFromBook(book, opts.Parser)
+	return p, nil
+}
+
+// importOutcome is what happened to a book imported by importPrepared.
+type importOutcome struct {
+	// book is the book the file was imported into, with the file last.
+	book   Book
+	fileID int64
+	// duplicateOf is the ID of the book which already has the file, if it wasn't imported, and reason says why.
+	duplicateOf int64
+	reason      string
+	// placed is where the file was moved or copied to in the books root, if it wasn't there already.
+	// Until the import is committed, the file has to be taken out again if it fails.
+	placed string
+	moved  bool
+	// removeOriginal removes the original of a duplicate file once the import is committed, since it was being moved.
+	removeOriginal bool
+}
+
+// importPrepared imports a prepared book in tx, and moves or copies its file into the books root.
+// Nothing is committed or rolled back; if an error is returned, the caller rolls back tx and calls undoPlacedFile,
+// and once tx is committed, finishImport.
+func (lib *Library) importPrepared(tx *sql.Tx, p *preparedImport, tmpl *template.Template, opts ImportOptions) (out importOutcome, err error) {
+	book := p.book
+	move := opts.Move && !opts.InPlace
+	identifiers := book.Identifiers
+	classifications := book.Classifications
+	bookTags := book.Tags
+	out.book = book
+	if importedID, found, err := lookupIdempotencyKey(tx, opts.IdempotencyKey, OperationImport); err != nil || found {
+		if found {
+			log.Printf("Not importing %s again: it was imported into book %d with idempotency key %s", book.Files[0].OriginalFilename, importedID, opts.IdempotencyKey)
+			out.duplicateOf, out.reason = importedID, "already imported with idempotency key "+opts.IdempotencyKey
+		}
+		return out, err
+	}
+	if p.contentSignature.Valid {
+		signedBookID, found, err := contentSignatureBookID(tx, p.contentSignature.String)
+		if err != nil {
+			return out, err
+		}
+		if found {
+			if err := saveIdempotencyKey(tx, opts.IdempotencyKey, OperationImport, signedBookID); err != nil {
+				return out, err
+			}
+			log.Printf("Not importing %s, since book %d has a file with the same content", book.Files[0].OriginalFilename, signedBookID)
+			out.duplicateOf, out.reason = signedBookID, "a file with the same content is already in the library"
+			out.removeOriginal = move
+			return out, nil
+		}
+	}
+
+	// A file which is a copy of a book converted by the library belongs with the book, even if its metadata differs.
+	existingBookID, found, err := convertedBookID(tx, book.Files[0].Hash)
+	if err != nil {
+		return out, err
+	}
+	if found {
+		log.Printf("%s is a conversion of book %d; adding it to that book", book.Files[0].OriginalFilename, existingBookID)
+	} else if existingBookID, found, err = getBookIDByTitleAndAuthors(tx, book.Title, book.Authors, opts.MatchAuthorSubsets); err != nil {
+		return out, errors.Wrap(err, "find existing book")
+	} else if !found && book.Subtitle != "" {
+		// Books imported before subtitles were split from titles have both in their title.
+		if existingBookID, found, err = getBookIDByTitleAndAuthors(tx, book.Title+": "+book.Subtitle, book.Authors, opts.MatchAuthorSubsets); err != nil {
+			return out, errors.Wrap(err, "find existing book")
+		}
+	}
+	if !found && opts.ConflictResolver != nil {
+		existingBookID, found, err = resolveConflict(tx, book, opts.ConflictResolver)
+		if err != nil {
+			return out, err
+		}
 	}
 	if !found {
-		res, err := tx.Exec("insert into books (series, title) values(?, ?)", book.Series, book.Title)
+		if book.License, err = NormalizeLicense(string(book.License)); err != nil {
+			return out, err
+		}
+		if book.Published, err = NormalizeDate(book.Published); err != nil {
+			return out, err
+		}
+		if book.UUID == "" {
+			if book.UUID, err = newUUID(); err != nil {
+				return out, err
+			}
+		}
+		res, err := tx.Exec("insert into books (uuid, series, series_index, title, subtitle, publisher, description, language, published, original_title, original_language, pages, license, word_count, source_url) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			book.UUID, book.Series, book.SeriesIndex, book.Title, book.Subtitle, book.Publisher, strings.TrimSpace(book.Description), NormalizeLanguage(book.Language), book.Published,
+			book.OriginalTitle, NormalizeLanguage(book.OriginalLanguage), book.Pages, book.License, book.WordCount, book.SourceURL)
 		if err != nil {
-			tx.Rollback()
-			return errors.Wrap(err, "Insert new book")
+			return out, errors.Wrap(err, "Insert new book")
 		}
 		book.ID, err = res.LastInsertId()
 		if err != nil {
-			tx.Rollback()
-			return errors.Wrap(err, "sett new book ID")
+			return out, errors.Wrap(err, "sett new book ID")
 		}
 		for _, author := range book.Authors {
 			if err := insertAuthor(tx, author, &book); err != nil {
-				tx.Rollback()
-				return errors.Wrapf(err, "inserting author %s", author)
+				return out, errors.Wrapf(err, "inserting author %s", author)
 			}
 		}
+		for _, c := range book.Contributors {
+			if err := insertContributor(tx, c, &book); err != nil {
+				return out, errors.Wrapf(err, "inserting %s %s", c.Role, c.Name)
+			}
+		}
+		if err := setFanficTags(tx, book.ID, fanficFandom, book.Fandoms); err != nil {
+			return out, err
+		}
+		if err := setFanficTags(tx, book.ID, fanficShip, book.Ships); err != nil {
+			return out, err
+		}
 
 	} else {
 		existingBooksList, err := getBooksByID(tx, []int64{existingBookID})
 		if err != nil {
-			return errors.Wrap(err, "get existing book")
+			return out, errors.Wrap(err, "get existing book")
 		}
 		existingBook := existingBooksList[0]
 		for _, f := range existingBook.Files {
 			if f.Hash != book.Files[0].Hash {
 				continue
 			}
-			tx.Commit()
-			log.Printf("Not importing duplicate file into book with authors: %s title: %s", book.Authors, book.Title)
-			if move {
-				err := os.Remove(book.Files[0].OriginalFilename)
-				log.Printf("Error deleting %s: %v", f.OriginalFilename, err)
+			if err := saveIdempotencyKey(tx, opts.IdempotencyKey, OperationImport, existingBookID); err != nil {
+				return out, err
 			}
-			return nil
+			log.Printf("Not importing duplicate file into book with authors: %s title: %s", book.Authors, book.Title)
+			out.duplicateOf, out.reason = existingBookID, "the book already has this file"
+			out.removeOriginal = move
+			return out, nil
 		}
 		// Update the existing book series only if it's empty
 		existingBook.Series = book.Series
+		if existingBook.SeriesIndex == 0 {
+			existingBook.SeriesIndex = book.SeriesIndex
+		}
+		if existingBook.Publisher == "" {
+			existingBook.Publisher = book.Publisher
+		}
+		// A book matched by its title with the subtitle already has it.
+		if existingBook.Subtitle == "" && strings.EqualFold(existingBook.Title, book.Title) {
+			existingBook.Subtitle = book.Subtitle
+		}
+		if existingBook.Description == "" {
+			existingBook.Description = book.Description
+		}
+		if existingBook.Language == "" {
+			existingBook.Language = book.Language
+		}
+		if existingBook.Published == "" {
+			existingBook.Published = book.Published
+		}
+		if existingBook.OriginalTitle == "" {
+			existingBook.OriginalTitle = book.OriginalTitle
+		}
+		if existingBook.OriginalLanguage == "" {
+			existingBook.OriginalLanguage = book.OriginalLanguage
+		}
+		if existingBook.Pages == 0 {
+			existingBook.Pages = book.Pages
+		}
+		if existingBook.WordCount == 0 {
+			existingBook.WordCount = book.WordCount
+		}
+		if existingBook.SourceURL == "" {
+			existingBook.SourceURL = book.SourceURL
+		}
+		if len(existingBook.Fandoms) == 0 {
+			existingBook.Fandoms = book.Fandoms
+		}
+		if len(existingBook.Ships) == 0 {
+			existingBook.Ships = book.Ships
+		}
 		err = lib.updateBook(tx, existingBook, tmpl, false)
 		if err != nil {
-			return errors.Wrap(err, "update book")
+			return out, errors.Wrap(err, "update book")
 		}
 		existingBooksList, err = getBooksByID(tx, []int64{existingBookID})
 		if err != nil {
-			return errors.Wrap(err, "get existing book")
+			return out, errors.Wrap(err, "get existing book")
 		}
 		existingBook = existingBooksList[0]
 		existingBook.Files = append(existingBook.Files, book.Files[0])
+		for _, c := range book.Contributors {
+			if err := insertContributor(tx, c, &existingBook); err != nil {
+				return out, errors.Wrapf(err, "inserting %s %s", c.Role, c.Name)
+			}
+		}
 		book = existingBook
 	}
+	out.book = book
+
+	for _, ident := range identifiers {
+		err := addIdentifier(tx, book.ID, normalizeIdentifier(ident.Type, ident.Value))
+		if iee, ok := err.(IdentifierExistsError); ok {
+			log.Printf("Not adding identifier %s:%s to book %d, since it belongs to book %d", iee.Identifier.Type, iee.Identifier.Value, book.ID, iee.BookID)
+		} else if err != nil {
+			return out, errors.Wrap(err, "add identifier")
+		}
+	}
+	for _, c := range classifications {
+		if _, err := addClassification(tx, book.ID, c); err != nil {
+			log.Printf("Not adding classification %s:%s to book %d: %s", c.Scheme, c.Number, book.ID, err)
+		}
+	}
+	for _, tag := range bookTags {
+		if err := insertBookTag(tx, book.ID, tag); err != nil {
+			return out, errors.Wrapf(err, "inserting book tag %s", tag)
+		}
+	}
 
 	bf := &book.Files[len(book.Files)-1]
-	bf.CurrentFilename, err = bf.Filename(tmpl, &book)
+	bf.CurrentFilename, err = lib.generateFilename(bf, tmpl, &book)
 	if err != nil {
-		return errors.Wrap(err, "get current filename")
+		return out, errors.Wrap(err, "get current filename")
+	}
+	var partialMD5 sql.NullString
+	if !opts.metadataOnly {
+		if hash, err := PartialMD5(bf.OriginalFilename); err == nil {
+			partialMD5 = sql.NullString{String: hash, Valid: true}
+		} else {
+			log.Printf("Cannot calculate partial MD5 of %s: %s", bf.OriginalFilename, err)
+		}
+	}
+	if bf.UUID == "" {
+		if bf.UUID, err = newUUID(); err != nil {
+			return out, err
+		}
+	}
+	if opts.InPlace {
+		bf.Root, bf.Path = "", bf.OriginalFilename
+	} else if !opts.metadataOnly {
+		if bf.Root, err = lib.placeFile(tx, *bf); err != nil {
+			return out, err
+		}
 	}
-	res, err := tx.Exec(`insert into files (book_id, extension, original_filename, filename, file_size, file_mtime, hash, source)
-	values (?, ?, ?, ?, ?, ?, ?, ?)`,
-		book.ID, bf.Extension, bf.OriginalFilename, bf.CurrentFilename, bf.FileSize, bf.FileMtime, bf.Hash, bf.Source)
+	res, err := tx.Exec(`insert into files (uuid, book_id, extension, original_filename, filename, file_size, file_mtime, hash, source, partial_md5, content_signature, root, template_override, path)
+	values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, nullif(?, ''), ?)`,
+		bf.UUID, book.ID, bf.Extension, bf.OriginalFilename, bf.CurrentFilename, bf.FileSize, bf.FileMtime, bf.Hash, bf.Source, partialMD5, p.contentSignature, bf.Root, bf.TemplateOverride, bf.Path)
 	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "Inserting book file into the db")
+		return out, errors.Wrap(err, "Inserting book file into the db")
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "Fetching new book ID")
+		return out, errors.Wrap(err, "Fetching new book ID")
+	}
+	bf.ID = id
+	out.book, out.fileID = book, id
+	if err := recordActivity(tx, ActivityImport, book.ID, id, "", ""); err != nil {
+		return out, err
 	}
-	book.Files[len(book.Files)-1].ID = id
 
 	for _, tag := range bf.Tags {
 		if err := insertTag(tx, tag, bf); err != nil {
-			tx.Rollback()
-			return errors.Wrapf(err, "inserting tag %s", tag)
+			return out, errors.Wrapf(err, "inserting tag %s", tag)
 		}
 	}
+	if err := insertContainedWorks(tx, id, bf.Works); err != nil {
+		return out, err
+	}
 
-	err = indexBookInSearch(tx, &book, !found)
+	err = indexBook(tx, book.ID)
 	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "index book in search")
+		return out, errors.Wrap(err, "index book in search")
 	}
 
-	err = lib.insertFile(*bf, move)
-	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "insert book")
+	if err := saveIdempotencyKey(tx, opts.IdempotencyKey, OperationImport, book.ID); err != nil {
+		return out, err
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return errors.Wrap(err, "import book")
+	if !opts.metadataOnly && !opts.InPlace {
+		out.placed, err = lib.insertFile(*bf, move)
+		out.moved = move
+		if err != nil {
+			return out, errors.Wrap(err, "insert book")
+		}
 	}
-	log.Printf("Imported book: %s: %s, ID = %d", strings.Join(book.Authors, " & "), book.Title, book.ID)
+	return out, nil
+}
 
-	return nil
+// undoPlacedFile takes the file placed in the books root by an import which failed or wasn't committed out again.
+func (lib *Library) undoPlacedFile(out importOutcome) {
+	if out.placed != "" {
+		unplaceFile(out.placed, out.book.Files[len(out.book.Files)-1].OriginalFilename, out.moved)
+	}
 }
 
-func indexBookInSearch(tx *sql.Tx, book *Book, createNew bool) error {
-	bf := book.Files[len(book.Files)-1]
-	joinedTags := strings.Join(bf.Tags, " ")
-	if createNew {
-		// Index book for searching.
-		extensions := []string{}
-		tags := []string{}
-		sources := []string{}
-		for _, f := range book.Files {
-			tags = append(tags, f.Tags...)
-			extensions = append(extensions, f.Extension)
-			sources = append(sources, f.Source)
-		}
-
-		_, err := tx.Exec(`insert into books_fts (docid, author, series, title, extension, tags,  source)
-	values (?, ?, ?, ?, ?, ?, ?)`,
-			book.ID, strings.Join(book.Authors, " & "), book.Series, book.Title, strings.Join(extensions, " "), strings.Join(tags, " "), strings.Join(sources, " "))
-		if err != nil {
-			return err
+// unplaceFile removes a file placed in a books root, or if it was moved there, moves it back to original,
+// so a failed import leaves no trace and loses no files.
+func unplaceFile(placed, original string, moved bool) {
+	if !moved {
+		if err := os.Remove(placed); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing %s after failed import: %s", placed, err)
 		}
-		return nil
+		return
 	}
-	rows, err := tx.Query("select docid, tags, extension, source from books_fts where docid=?", book.ID)
-	if err != nil {
-		return err
+	if err := moveFile(placed, original, nil); err != nil {
+		log.Printf("Cannot move %s back to %s after failed import: %s", placed, original, err)
 	}
-	if !rows.Next() {
-		rows.Close()
-		if rows.Err() != nil {
-			return err
+}
+
+// finishImport does what's left of an import once it's committed: removing the original of a duplicate file if it was being moved,
+// reporting the outcome, and running the post-import hooks.
+func (lib *Library) finishImport(p *preparedImport, out importOutcome, opts ImportOptions) {
+	if out.duplicateOf != 0 {
+		fn := p.book.Files[0].OriginalFilename
+		opts.Report.add(ImportReportEntry{File: fn, Status: ImportDuplicate, BookID: out.duplicateOf, Reason: out.reason, Metadata: p.guess})
+		if out.removeOriginal {
+			if err := os.Remove(fn); err != nil {
+				log.Printf("Error deleting %s: %v", fn, err)
+			}
 		}
-		return errors.Errorf("Existing book %d not found in FTS", book.ID)
+		return
 	}
-	var id int64
-	var tags, extension, source string
-	err = rows.Scan(&id, &tags, &extension, &source)
-	if err != nil {
-		return err
+	book := out.book
+	bf := book.Files[len(book.Files)-1]
+	log.Printf("Imported book: %s: %s, ID = %d", strings.Join(book.Authors, " & "), book.Title, book.ID)
+	if opts.EmbeddedMetadata != nil && !opts.metadataOnly && strings.ToLower(bf.Extension) == "epub" {
+		if _, err := lib.epubCover(bf); err != nil && err != ErrNoCover {
+			log.Printf("Cannot cache cover of book %d: %s", book.ID, err)
+		}
 	}
-	rows.Close()
-
-	_, err = tx.Exec("update books_fts set tags=?, extension=?, source=?, series=? where docid=?", tags+" "+joinedTags, extension+" "+bf.Extension, source+" "+bf.Source, book.Series, id)
-	if err != nil {
-		return err
+	opts.Report.add(ImportReportEntry{File: bf.OriginalFilename, Status: ImportImported, BookID: book.ID, FileID: out.fileID, Metadata: p.guess})
+	for _, hook := range opts.PostImportHooks {
+		if err := hook(&book); err != nil {
+			log.Printf("Post-import hook failed for book %d: %s", book.ID, err)
+		}
 	}
-	return nil
 }
 
 // insertAuthor inserts an author into the database.
 func insertAuthor(tx *sql.Tx, author string, book *Book) error {
-	var authorID int64
-	row := tx.QueryRow("select id from authors where name=?", author)
-	err := row.Scan(&authorID)
+	return insertContributor(tx, Contributor{Name: author, Role: RoleAuthor}, book)
+}
+
+// insertContributor inserts a contributor into the database, linking them to the book in their role.
+func insertContributor(tx *sql.Tx, c Contributor, book *Book) error {
+	return linkContributor(tx, c, book, nil)
+}
+
+// linkContributor is insertContributor, linking the contributor to the author record in linked with their name, if there is one.
+// Otherwise, when several people share the name, the one without a disambiguation note is chosen, or else the first one added.
+func linkContributor(tx *sql.Tx, c Contributor, book *Book, linked map[string]int64) error {
+	authorID, ok := linked[c.Name]
+	var err error
+	if !ok {
+		row := tx.QueryRow("select id from authors where name=? order by disambiguation != '', id limit 1", c.Name)
+		err = row.Scan(&authorID)
+	}
 	if err == sql.ErrNoRows {
 		// Insert the author
-		res, err := tx.Exec("insert into authors (name) values(?)", author)
+		res, err := tx.Exec("insert into authors (name) values(?)", c.Name)
 		if err != nil {
 			return err
 		}
@@ -330,7 +735,7 @@ func insertAuthor(tx *sql.Tx, author string, book *Book) error {
 	}
 	// Author inserted, insert the link
 	// For two authors in the same book with the same name, only insert one.
-	if _, err := tx.Exec("insert or ignore into books_authors (book_id, author_id) values(?, ?)", book.ID, authorID); err != nil {
+	if _, err := tx.Exec("insert or ignore into books_authors (book_id, author_id, role) values(?, ?, ?)", book.ID, authorID, c.Role); err != nil {
 		return err
 	}
 	return nil
@@ -338,20 +743,8 @@ func insertAuthor(tx *sql.Tx, author string, book *Book) error {
 
 // insertTag inserts a tag into the database.
 func insertTag(tx *sql.Tx, tag string, bf *BookFile) error {
-	var tagID int64
-	row := tx.QueryRow("select id from tags where name=?", tag)
-	err := row.Scan(&tagID)
-	if err == sql.ErrNoRows {
-		// Insert the tag
-		res, err := tx.Exec("insert into tags (name) values(?)", tag)
-		if err != nil {
-			return err
-		}
-		tagID, err = res.LastInsertId()
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
+	tagID, err := getTagID(tx, tag)
+	if err != nil {
 		return err
 	}
 	// Tag inserted, insert the link
@@ -362,59 +755,36 @@ func insertTag(tx *sql.Tx, tag string, bf *BookFile) error {
 	return nil
 }
 
-// Search searches the library for books.
-// By default, all fields are searched, but
-// field:terms+to+search will limit to that field only.
-// Fields: author, title, series, extension, tags, filename, source.
-// Example: author:Stephen+King title:Shining
-func (lib *Library) Search(terms string) ([]Book, error) {
-	books, _, err := lib.SearchPaged(terms, 0, 0, 0)
-	return books, err
-}
-
-// SearchPaged implements book searching, both paged and non paged.
-// Set limit to 0 to return all results.
-// moreResults will be set to the number of additional results not returned, with a maximum of moreResultsLimit.
-func (lib *Library) SearchPaged(terms string, offset, limit, moreResultsLimit int) (books []Book, moreResults int, err error) {
-	books = []Book{}
-	var query string
-	args := []interface{}{terms}
-	if limit == 0 {
-		query = "select docid from books_fts where books_fts match ?"
-	} else {
-		query = "select docid from books_fts where books_fts match ? LIMIT ? OFFSET ?"
-		args = append(args, limit+moreResultsLimit, offset)
-	}
-
-	rows, err := lib.Query(query, args...)
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "Querying db for search terms")
-	}
-
-	var ids []int64
-	var id int64
-	for rows.Next() {
-		rows.Scan(&id)
-		ids = append(ids, id)
+// GetBooksByID retrieves books from the library by their id, in the order of ids, such as the order of search results.
+// Books which don't exist are skipped, and a book whose ID is given more than once is only returned once.
+// If the cache is enabled, only books which aren't cached are read from the database.
+func (lib *Library) GetBooksByID(ids []int64) ([]Book, error) {
+	if len(ids) == 0 {
+		return nil, nil
 	}
-	err = rows.Err()
+	m, err := lib.GetBooksByIDMap(ids)
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "Retrieving search results from db")
-	}
-
-	if limit > 0 && len(ids) > limit {
-		moreResults = len(ids) - limit
-		ids = ids[:limit]
+		return nil, err
 	}
-	books, err = lib.GetBooksByID(ids)
-
-	return
+	return booksInOrder(ids, m), nil
 }
 
-// GetBooksByID retrieves books from the library by their id.
-func (lib *Library) GetBooksByID(ids []int64) ([]Book, error) {
+// GetBooksByIDMap is like GetBooksByID, but returns the books keyed by ID, for looking them up rather than listing them.
+func (lib *Library) GetBooksByIDMap(ids []int64) (map[int64]Book, error) {
+	m := make(map[int64]Book, len(ids))
 	if len(ids) == 0 {
-		return nil, nil
+		return m, nil
+	}
+	var generation uint64
+	if lib.cache != nil {
+		var cached []Book
+		cached, ids, generation = lib.cache.getBooks(ids)
+		for _, b := range cached {
+			m[b.ID] = b
+		}
+		if len(ids) == 0 {
+			return m, nil
+		}
 	}
 	tx, err := lib.Begin()
 	if err != nil {
@@ -429,33 +799,88 @@ func (lib *Library) GetBooksByID(ids []int64) ([]Book, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "get books by ID")
 	}
-	return books, nil
+	if lib.cache != nil {
+		lib.cache.putBooks(books, generation)
+	}
+	for _, b := range books {
+		m[b.ID] = b
+	}
+	return m, nil
+}
+
+// booksInOrder returns the books in m in the order of ids, once each.
+func booksInOrder(ids []int64, m map[int64]Book) []Book {
+	books := make([]Book, 0, len(m))
+	seen := make(map[int64]bool, len(m))
+	for _, id := range ids {
+		if b, ok := m[id]; ok && !seen[id] {
+			books = append(books, b)
+			seen[id] = true
+		}
+	}
+	return books
 }
 
-// getBooksByID retrieves books from the library by their id.
+// parseTimestamp parses a time stored in the database, in any of the formats the driver accepts for timestamp columns.
+func parseTimestamp(s string) (time.Time, error) {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid time %q", s)
+}
+
+// getBooksByID retrieves books from the library by their id, in the order of ids, as GetBooksByID does.
+// Large sets of IDs are read in chunks, so queries stay within SQLite's limits.
 func getBooksByID(tx *sql.Tx, ids []int64) ([]Book, error) {
 	if len(ids) == 0 {
 		return nil, nil
 	}
+	m := make(map[int64]Book, len(ids))
+	for rest := ids; len(rest) > 0; {
+		n := len(rest)
+		if n > maxQueryParams {
+			n = maxQueryParams
+		}
+		books, err := getBookChunk(tx, rest[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range books {
+			m[b.ID] = b
+		}
+		rest = rest[n:]
+	}
+	return booksInOrder(ids, m), nil
+}
 
+// getBookChunk retrieves a chunk of the books requested from getBooksByID, in any order.
+func getBookChunk(tx *sql.Tx, ids []int64) ([]Book, error) {
 	results := []Book{}
 
-	query := "select id, series, title from books where id in (" + joinInt64s(ids, ",") + ")"
-	rows, err := tx.Query(query)
+	rows, err := tx.Query("select " + bookRowColumns + " from books where id in (" + joinInt64s(ids, ",") + ")")
 	if err != nil {
 		return results, errors.Wrap(err, "fetching books from database by ID")
 	}
 
 	for rows.Next() {
-		book := Book{}
-		if err := rows.Scan(&book.ID, &book.Series, &book.Title); err != nil {
+		row, err := scanBookRow(rows)
+		if err != nil {
+			rows.Close()
 			return nil, errors.Wrap(err, "scanning rows")
 		}
+		book, err := row.book()
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
 
 		results = append(results, book)
 	}
 
-	if rows.Err() != nil {
+	if err := rows.Err(); err != nil {
+		rows.Close()
 		return nil, errors.Wrap(err, "querying books by ID")
 	}
 	rows.Close()
@@ -470,10 +895,53 @@ func getBooksByID(tx *sql.Tx, ids []int64) ([]Book, error) {
 		return nil, errors.Wrap(err, "get files for books")
 	}
 
+	identifierMap, err := getIdentifiersByBookIds(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get identifiers for books")
+	}
+
+	classificationMap, err := getClassificationsByBookIds(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get classifications for books")
+	}
+
+	contributorMap, err := getContributorsByBookIds(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get contributors for books")
+	}
+
+	translationMap, err := getTranslationsByBookIds(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get translations of books")
+	}
+
+	bookTagMap, err := getTagsByBookIds(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get tags for books")
+	}
+
+	alternateTitleMap, err := getAlternateTitlesByBookIds(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get alternate titles of books")
+	}
+
+	fandomMap, shipMap, err := getFanficTagsByBookIds(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get fandoms and ships of books")
+	}
+
 	// Get authors and files
 	for i, book := range results {
 		results[i].Authors = authorMap[book.ID]
 		results[i].Files = fileMap[book.ID]
+		results[i].Identifiers = identifierMap[book.ID]
+		results[i].Classifications = classificationMap[book.ID]
+		results[i].Contributors = contributorMap[book.ID]
+		results[i].Translations = translationMap[book.ID]
+		results[i].Tags = bookTagMap[book.ID]
+		results[i].AlternateTitles = alternateTitleMap[book.ID]
+		results[i].Fandoms = fandomMap[book.ID]
+		results[i].Ships = shipMap[book.ID]
 	}
 	return results, nil
 }
@@ -488,7 +956,7 @@ func getAuthorsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 	var bookID int64
 	var authorName string
 
-	query := "SELECT ba.book_id, a.name FROM books_authors ba JOIN authors a ON ba.author_id = a.id WHERE ba.book_id IN (" + joinInt64s(ids, ",") + ") ORDER BY ba.id"
+	query := "SELECT ba.book_id, a.name FROM books_authors ba JOIN authors a ON ba.author_id = a.id WHERE ba.role = 'author' AND ba.book_id IN (" + joinInt64s(ids, ",") + ") ORDER BY ba.id"
 	rows, err := tx.Query(query)
 	if err != nil {
 		return nil, err
@@ -504,7 +972,7 @@ func getAuthorsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 		m[bookID] = append(authors, authorName)
 	}
 
-	return m, nil
+	return m, rows.Err()
 }
 
 // getTagsByFileIds gets tag names for each book ID.
@@ -532,7 +1000,7 @@ func getTagsByFileIds(tx *sql.Tx, ids []int64) (map[int64][]string, error) {
 		tagsMap[fileID] = append(tagsMap[fileID], tag)
 	}
 
-	return tagsMap, nil
+	return tagsMap, rows.Err()
 }
 
 // getFilesByBookIds gets files for each book ID.
@@ -543,7 +1011,7 @@ func getFilesByBookIds(tx *sql.Tx, ids []int64) (fileMap map[int64][]BookFile, e
 	fileIDMap := make(map[int64][]int64)
 	fileMap = make(map[int64][]BookFile)
 
-	query := "select id, book_id from files where book_id in (" + joinInt64s(ids, ",") + ")"
+	query := "select id, book_id from files where book_id in (" + joinInt64s(ids, ",") + ") order by id"
 	rows, err := tx.Query(query)
 	if err != nil {
 		return nil, err
@@ -558,6 +1026,9 @@ func getFilesByBookIds(tx *sql.Tx, ids []int64) (fileMap map[int64][]BookFile, e
 		}
 		fileIDMap[bookID] = append(fileIDMap[bookID], fileID)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	for bookID, fileIDs := range fileIDMap {
 		files, err := getFilesByID(tx, fileIDs)
@@ -576,6 +1047,15 @@ func (lib *Library) GetFilesByID(ids []int64) ([]BookFile, error) {
 		return nil, nil
 	}
 
+	var cached []BookFile
+	var generation uint64
+	if lib.cache != nil {
+		cached, ids, generation = lib.cache.getFiles(ids)
+		if len(ids) == 0 {
+			return cached, nil
+		}
+	}
+
 	tx, err := lib.Begin()
 	if err != nil {
 		return nil, err
@@ -584,11 +1064,16 @@ func (lib *Library) GetFilesByID(ids []int64) ([]BookFile, error) {
 	files, err := getFilesByID(tx, ids)
 	if err != nil {
 		tx.Rollback()
-	} else {
-		tx.Commit()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if lib.cache != nil {
+		lib.cache.putFiles(files, generation)
 	}
 
-	return files, err
+	return append(cached, files...), nil
 }
 
 // GetFilesById gets files for each ID.
@@ -601,56 +1086,65 @@ func getFilesByID(tx *sql.Tx, ids []int64) ([]BookFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	query := "select id, extension, original_filename, filename, file_size, file_mtime, hash, source from files where id in (" + joinInt64s(ids, ",") + ")"
-	rows, err := tx.Query(query)
+	worksMap, err := getWorksByFileIds(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query("select " + fileRowColumns + " from files where id in (" + joinInt64s(ids, ",") + ") order by id")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		bf := BookFile{}
-		err := rows.Scan(&bf.ID, &bf.Extension, &bf.OriginalFilename, &bf.CurrentFilename, &bf.FileSize, &bf.FileMtime, &bf.Hash, &bf.Source)
+		row, err := scanFileRow(rows)
 		if err != nil {
 			return nil, err
 		}
+		bf := row.bookFile()
 		bf.Tags = tagMap[bf.ID]
+		bf.Works = worksMap[bf.ID]
 		files = append(files, bf)
 	}
-	return files, nil
+	return files, rows.Err()
+}
+
+// generateFilename returns the filename of a file of book, generated from tmpl and made safe by the library's filename policy.
+// A file with a template override is named by it instead, with the same functions as tmpl.
+func (lib *Library) generateFilename(bf *BookFile, tmpl *template.Template, book *Book) (string, error) {
+	if bf.TemplateOverride != "" {
+		var err error
+		if tmpl, err = parseTemplateOverride(tmpl, bf.TemplateOverride); err != nil {
+			return "", err
+		}
+	}
+	fn, err := bf.Filename(tmpl, book)
+	if err != nil {
+		return "", err
+	}
+	return lib.filenames.Sanitize(fn), nil
 }
 
-// ConvertToEpub converts a file to epub, and caches it in LIBRARY_ROOT/cache.
+// ConvertToEpub converts a file to epub, and caches it in the library's cache directory.
 // This depends on ebook-convert, which takes the original filename, and the new filename, in that order.
-// the file's hash, with the extension .epub, will be the name of the cached file.
+// the file's hash, with the extension .epub, will be the name of the cached file, which is recorded as a derivation of the file.
 func (lib *Library) ConvertToEpub(file BookFile) error {
-	filename := path.Join(lib.booksRoot, file.CurrentFilename)
-	cacheDir := path.Join(path.Dir(lib.filename), "cache")
-	newFile := path.Join(cacheDir, file.Hash+".epub")
+	filename, err := lib.FilePath(file)
+	if err != nil {
+		return err
+	}
+	newFile := path.Join(lib.paths.Cache, file.Hash+".epub")
 	cmd := exec.Command("ebook-convert", filename, newFile)
 	if err := cmd.Run(); err != nil {
 		return err
 	}
 
-	return nil
+	return lib.SaveDerivation(Derivation{Hash: file.Hash, Kind: DerivationConversion, Params: "epub", Filename: newFile})
 }
 
-// UpdateBook updates the authors and title of an existing book in the database, specified by book.ID.
+// UpdateBook updates the authors, title, and publisher of an existing book in the database, specified by book.ID.
 // If the existing book's series is not empty, it will not be updated unless overwriteSeries is true.
 func (lib *Library) UpdateBook(book Book, tmpl *template.Template, overwriteSeries bool) error {
-	tx, err := lib.Begin()
-	if err != nil {
-		return errors.Wrap(err, "get transaction")
-	}
-	err = lib.updateBook(tx, book, tmpl, overwriteSeries)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	err = tx.Commit()
-	if err != nil {
-		return errors.Wrap(err, "commit transaction")
-	}
-	return nil
+	return lib.UpdateBookWithKey("", book, tmpl, overwriteSeries)
 }
 
 func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, overwriteSeries bool) (err error) {
@@ -668,7 +1162,7 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 		book.Series = existingBook.Series
 	}
 
-	existingBookID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors)
+	existingBookID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors, false)
 	if err != nil {
 		return errors.Wrap(err, "find existing book")
 	}
@@ -676,36 +1170,106 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 		return BookExistsError{"Book already exists", existingBookID}
 	}
 
-	if book.Title != existingBook.Title ||
-		book.Series != existingBook.Series {
-		_, err = tx.Exec("update books set updated_on=datetime(), title=?, series=? where id=?", book.Title, book.Series, book.ID)
+	book.Description = strings.TrimSpace(book.Description)
+	book.Language = NormalizeLanguage(book.Language)
+	if book.Published, err = NormalizeDate(book.Published); err != nil {
+		return err
+	}
+	book.OriginalLanguage = NormalizeLanguage(book.OriginalLanguage)
+	if book.License, err = NormalizeLicense(string(book.License)); err != nil {
+		return err
+	}
+	// changed lists the fields which were changed, for the activity feed.
+	var changed []string
+	checkChanged := func(field string, isChanged bool) {
+		if isChanged {
+			changed = append(changed, field)
+		}
+	}
+	checkChanged("title", book.Title != existingBook.Title)
+	checkChanged("subtitle", book.Subtitle != existingBook.Subtitle)
+	checkChanged("series", book.Series != existingBook.Series)
+	checkChanged("series index", book.SeriesIndex != existingBook.SeriesIndex)
+	checkChanged("publisher", book.Publisher != existingBook.Publisher)
+	checkChanged("description", book.Description != existingBook.Description)
+	checkChanged("language", book.Language != existingBook.Language)
+	checkChanged("published", book.Published != existingBook.Published)
+	checkChanged("original title", book.OriginalTitle != existingBook.OriginalTitle)
+	checkChanged("original language", book.OriginalLanguage != existingBook.OriginalLanguage)
+	checkChanged("pages", book.Pages != existingBook.Pages)
+	checkChanged("license", book.License != existingBook.License)
+	checkChanged("word count", book.WordCount != existingBook.WordCount)
+	checkChanged("source URL", book.SourceURL != existingBook.SourceURL)
+	if len(changed) > 0 {
+		_, err = tx.Exec("update books set updated_on=datetime(), title=?, subtitle=?, series=?, series_index=?, publisher=?, description=?, language=?, published=?, original_title=?, original_language=?, pages=?, license=?, word_count=?, source_url=? where id=?",
+			book.Title, book.Subtitle, book.Series, book.SeriesIndex, book.Publisher, book.Description, book.Language, book.Published, book.OriginalTitle, book.OriginalLanguage, book.Pages, book.License, book.WordCount, book.SourceURL, book.ID)
 		if err != nil {
 			return errors.Wrap(err, "update book")
 		}
 	}
 	if !stringSlicesEqual(existingBook.Authors, book.Authors, false) {
-		_, err := tx.Exec("delete from books_authors where book_id=?", book.ID)
+		changed = append(changed, "authors")
+		linked, err := linkedAuthorIDs(tx, book.ID)
 		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("delete from books_authors where book_id=? and role='author'", book.ID); err != nil {
 			return errors.Wrap(err, "delete authors")
 		}
 		for _, author := range book.Authors {
-			if err := insertAuthor(tx, author, &book); err != nil {
+			if err := linkContributor(tx, Contributor{Name: author, Role: RoleAuthor}, &book, linked); err != nil {
 				return errors.Wrap(err, "insert author")
 			}
 		}
 	}
-	var tags []string
+	if book.Contributors != nil && !contributorsEqual(existingBook.Contributors, book.Contributors) {
+		changed = append(changed, "contributors")
+		linked, err := linkedAuthorIDs(tx, book.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("delete from books_authors where book_id=? and role!='author'", book.ID); err != nil {
+			return errors.Wrap(err, "delete contributors")
+		}
+		for _, c := range book.Contributors {
+			if err := linkContributor(tx, c, &book, linked); err != nil {
+				return errors.Wrap(err, "insert contributor")
+			}
+		}
+	}
+	if book.Fandoms != nil && !stringSlicesEqual(existingBook.Fandoms, book.Fandoms, false) {
+		changed = append(changed, "fandoms")
+		if err := setFanficTags(tx, book.ID, fanficFandom, book.Fandoms); err != nil {
+			return err
+		}
+	}
+	if book.Ships != nil && !stringSlicesEqual(existingBook.Ships, book.Ships, false) {
+		changed = append(changed, "ships")
+		if err := setFanficTags(tx, book.ID, fanficShip, book.Ships); err != nil {
+			return err
+		}
+	}
+	if book.Tags != nil && !stringSlicesEqual(existingBook.Tags, book.Tags, false) {
+		changed = append(changed, "book tags")
+		if _, err := tx.Exec("delete from books_tags where book_id=?", book.ID); err != nil {
+			return errors.Wrap(err, "delete existing book tags")
+		}
+		for _, t := range book.Tags {
+			if err := insertBookTag(tx, book.ID, t); err != nil {
+				return errors.Wrap(err, "insert book tag")
+			}
+		}
+	}
+	tagsChanged := false
 	for i, f := range book.Files {
 		if f.ID != existingBook.Files[i].ID {
 			// Someone tried to delete from/reorder the files list, which isn't currently supported.
 			return errors.New("file list reorder not supported")
 		}
-		for _, t := range f.Tags {
-			tags = append(tags, t)
-		}
 		if stringSlicesEqual(existingBook.Files[i].Tags, f.Tags, false) {
 			continue
 		}
+		tagsChanged = true
 		_, err = tx.Exec("delete from files_tags where file_id=?", f.ID)
 		if err != nil {
 			return errors.Wrap(err, "delete existing file tags")
@@ -717,12 +1281,16 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 			}
 		}
 	}
-	_, err = tx.Exec("update books_fts set title=?, author=?, series=?, tags=? where docid=?", book.Title, strings.Join(book.Authors, " & "), book.Series, strings.Join(tags, " "), book.ID)
-	if err != nil {
-		return errors.Wrap(err, "update fts")
+	if tagsChanged {
+		changed = append(changed, "file tags")
+	}
+	if len(changed) > 0 {
+		if err := recordActivity(tx, ActivityEdit, book.ID, 0, "", strings.Join(changed, ", ")); err != nil {
+			return err
+		}
 	}
 	for _, bf := range book.Files {
-		newFn, err := bf.Filename(tmpl, &book)
+		newFn, err := lib.generateFilename(&bf, tmpl, &book)
 		if err != nil {
 			return errors.Wrap(err, "get new filename")
 		}
@@ -733,36 +1301,50 @@ func (lib *Library) updateBook(tx *sql.Tx, book Book, tmpl *template.Template, o
 			return errors.Wrap(err, "update file")
 		}
 	}
+	if err := indexBook(tx, book.ID); err != nil {
+		return err
+	}
 	log.Printf("Updated book %d with authors: %s series: %s title: %s", book.ID, strings.Join(book.Authors, " & "), book.Series, book.Title)
 	return nil
 }
 
 // GetBookIDByTitleAndAuthors gets an existing book ID with the given title and authors.
+// Titles and authors are compared without regard to case, and authors may be in any order.
+// Books are also found by their alternate titles, though books with the title are preferred.
 func (lib *Library) GetBookIDByTitleAndAuthors(title string, authors []string) (int64, bool, error) {
 	tx, err := lib.Begin()
 	if err != nil {
 		return 0, false, errors.Wrap(err, "get transaction")
 	}
 	defer tx.Rollback()
-	return getBookIDByTitleAndAuthors(tx, title, authors)
+	return getBookIDByTitleAndAuthors(tx, title, authors, false)
 }
 
-func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int64, bool, error) {
-	rows, err := tx.Query("SELECT id FROM books WHERE title = ? COLLATE NOCASE", title)
+// getBookIDByTitleAndAuthors finds a book by title, or one of its alternate titles, and authors, ignoring case and the order of authors.
+// If subset is true, a book also matches if its authors are a subset or superset of authors.
+func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string, subset bool) (int64, bool, error) {
+	// Books with the title come before books with it as an alternate title.
+	rows, err := tx.Query("SELECT id, 0 AS alternate FROM books WHERE title = ? COLLATE NOCASE UNION SELECT book_id, 1 FROM alternate_titles WHERE title = ? ORDER BY alternate, id", title, title)
 	if err != nil {
 		return 0, false, errors.Wrap(err, "get book by title")
 	}
 
 	var id int64
+	var alternate bool
 	ids := make([]int64, 0)
 	for rows.Next() {
-		err := rows.Scan(&id)
+		err := rows.Scan(&id, &alternate)
 		if err != nil {
+			rows.Close()
 			return 0, false, errors.Wrap(err, "Get book ID from title")
 		}
 
 		ids = append(ids, id)
 	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, false, errors.Wrap(err, "get book by title")
+	}
 	rows.Close()
 
 	authorMap, err := getAuthorsByBookIds(tx, ids)
@@ -770,15 +1352,57 @@ func getBookIDByTitleAndAuthors(tx *sql.Tx, title string, authors []string) (int
 		return 0, false, errors.Wrap(err, "get authors for books")
 	}
 
-	for bookID, authorNames := range authorMap {
-		if stringSlicesEqual(authors, authorNames, true) {
-			return bookID, true, nil
+	// Prefer an exact match to a subset match, and the oldest book if more than one matches.
+	for _, exact := range []bool{true, false} {
+		if !exact && !subset {
+			break
+		}
+		for _, bookID := range ids {
+			if authorsMatch(authors, authorMap[bookID], !exact) {
+				return bookID, true, nil
+			}
 		}
 	}
 
 	return 0, false, nil
 }
 
+// authorsMatch compares two lists of authors, ignoring case, surrounding whitespace, order, and duplicates.
+// If subset is true, the lists also match if either one contains all of the authors in the other.
+func authorsMatch(a, b []string, subset bool) bool {
+	setA, setB := authorSet(a), authorSet(b)
+	if len(setA) == 0 || len(setB) == 0 {
+		return len(setA) == len(setB)
+	}
+	if !subset && len(setA) != len(setB) {
+		return false
+	}
+	small, large := setA, setB
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	for name := range small {
+		if !large[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// authorSet returns the normalized set of names in authors.
+func authorSet(authors []string) map[string]bool {
+	set := make(map[string]bool, len(authors))
+	for _, a := range authors {
+		set[normalizeAuthor(a)] = true
+	}
+	return set
+}
+
+// normalizeAuthor normalizes an author name for comparison.
+func normalizeAuthor(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
 // GetBooksByHash retrieves books from the library by the hash of one of their files.
 func (lib *Library) GetBooksByHash(hash string) ([]Book, error) {
 	bks := []Book{}
@@ -786,22 +1410,11 @@ func (lib *Library) GetBooksByHash(hash string) ([]Book, error) {
 	if err != nil {
 		return bks, errors.Wrap(err, "begin transaction")
 	}
-	rows, err := tx.Query("select distinct book_id from files where hash=?", hash)
+	ids, err := queryInt64s(tx, "select distinct book_id from files where hash=?", hash)
 	if err != nil {
 		tx.Commit()
 		return bks, errors.Wrap(err, "query book IDs")
 	}
-	ids := []int64{}
-	for rows.Next() {
-		var id int64
-		rows.Scan(&id)
-		ids = append(ids, id)
-	}
-	if err := rows.Err(); err != nil {
-		tx.Commit()
-		return bks, errors.Wrap(err, "get rows")
-	}
-	rows.Close()
 	bks, err = getBooksByID(tx, ids)
 	if err != nil {
 		tx.Commit()
@@ -813,18 +1426,7 @@ func (lib *Library) GetBooksByHash(hash string) ([]Book, error) {
 
 // MergeBooks merges all of the files from ids into the first one.
 func (lib *Library) MergeBooks(ids []int64, tmpl *template.Template) error {
-	tx, err := lib.Begin()
-	if err != nil {
-		return errors.Wrap(err, "create transaction")
-	}
-	if err := lib.mergeBooks(tx, ids, tmpl); err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "merge books")
-	}
-	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "commit")
-	}
-	return nil
+	return lib.MergeBooksWithKey("", ids, tmpl)
 }
 
 func (lib *Library) mergeBooks(tx *sql.Tx, ids []int64, tmpl *template.Template) error {
@@ -836,17 +1438,68 @@ func (lib *Library) mergeBooks(tx *sql.Tx, ids []int64, tmpl *template.Template)
 	if err != nil {
 		return errors.Wrap(err, "merge books")
 	}
+	// Authors are already the same, but other contributors are kept from all the books.
+	_, err = tx.Exec("update or ignore books_authors set updated_on=datetime(), book_id=? where role!='author' and book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge contributors")
+	}
+	_, err = tx.Exec("update identifiers set updated_on=datetime(), book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge identifiers")
+	}
+	// Translations of the merged books become translations of the book merged into, unless they're the book itself.
+	_, err = tx.Exec("update books set translation_of = nullif(?, id) where translation_of in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge translations")
+	}
+	_, err = tx.Exec("update activity set book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge activity")
+	}
+	// The book merged into keeps its rating, page count, license, subtitle, description, language and publication date, unless they aren't known.
+	_, err = tx.Exec("update books set rating=(select max(rating) from books where id in ("+joinInt64s(ids, ",")+")) where id=? and rating=0", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge ratings")
+	}
+	_, err = tx.Exec("update books set pages=(select max(pages) from books where id in ("+joinInt64s(ids, ",")+")) where id=? and pages=0", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge page counts")
+	}
+	_, err = tx.Exec("update books set license=coalesce((select license from books where id in ("+joinInt64s(ids[1:], ",")+") and license != '' order by id limit 1), '') where id=? and license=''", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge licenses")
+	}
+	_, err = tx.Exec("update books set subtitle=coalesce((select subtitle from books where id in ("+joinInt64s(ids[1:], ",")+") and subtitle != '' order by id limit 1), '') where id=? and subtitle=''", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge subtitles")
+	}
+	for _, column := range []string{"description", "language", "published"} {
+		_, err = tx.Exec("update books set "+column+"=coalesce((select "+column+" from books where id in ("+joinInt64s(ids[1:], ",")+") and "+column+" != '' order by id limit 1), '') where id=? and "+column+"=''", ids[0])
+		if err != nil {
+			return errors.Wrap(err, "merge "+column)
+		}
+	}
+	_, err = tx.Exec("update or ignore books_tags set updated_on=datetime(), book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge book tags")
+	}
+	// Classifications are only kept from the merged books if the book merged into has none in the same scheme.
+	_, err = tx.Exec("update or ignore book_classifications set book_id=? where book_id in ("+joinInt64s(ids[1:], ",")+")", ids[0])
+	if err != nil {
+		return errors.Wrap(err, "merge classifications")
+	}
+	if err := mergeAlternateTitles(tx, ids); err != nil {
+		return err
+	}
+	if err := mergeFanficTags(tx, ids); err != nil {
+		return err
+	}
 	if _, err = tx.Exec("delete from books where id in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
 		return errors.Wrap(err, "delete book")
 	}
 	if _, err = tx.Exec("delete from books_fts where docid in (" + joinInt64s(ids[1:], ",") + ")"); err != nil {
 		return errors.Wrap(err, "delete from books_fts")
 	}
-	// Reindex the book in search
-	_, err = tx.Exec("delete from books_fts where docid=?", ids[0])
-	if err != nil {
-		return errors.Wrap(err, "delete original book from fts")
-	}
 	books, err := getBooksByID(tx, []int64{ids[0]})
 	if err != nil {
 		return errors.Wrap(err, "get original book")
@@ -855,7 +1508,7 @@ func (lib *Library) mergeBooks(tx *sql.Tx, ids []int64, tmpl *template.Template)
 		return errors.New("Can't find original book to reindex")
 	}
 	for _, f := range books[0].Files {
-		newFn, err := f.Filename(tmpl, &books[0])
+		newFn, err := lib.generateFilename(&f, tmpl, &books[0])
 		if err != nil {
 			return errors.Wrap(err, "get filename")
 		}
@@ -867,7 +1520,7 @@ func (lib *Library) mergeBooks(tx *sql.Tx, ids []int64, tmpl *template.Template)
 			return errors.Wrap(err, "update filename")
 		}
 	}
-	if err := indexBookInSearch(tx, &books[0], true); err != nil {
+	if err := indexBook(tx, ids[0]); err != nil {
 		return errors.Wrap(err, "index book in search")
 	}
 	return nil
@@ -880,22 +1533,25 @@ func (lib *Library) GetBookIDByFilename(fn string) (int64, error) {
 		return 0, err
 	}
 	defer tx.Rollback()
-	rows, err := tx.Query("Select book_id from files where filename=?", fn)
-	if err != nil {
+	var id int64
+	err = tx.QueryRow("select book_id from files where filename=? limit 1", fn).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrBookNotFound
+	} else if err != nil {
 		return 0, err
 	}
-	if rows.Next() {
-		var id int64
-		rows.Scan(&id)
-		return id, nil
-	}
-	rows.Close()
-	return 0, ErrBookNotFound
+	return id, nil
 }
 
-func (lib *Library) insertFile(file BookFile, deleteOriginal bool) error {
-	newPath := filepath.Join(lib.booksRoot, file.HashPath())
-	_, err := os.Stat(newPath)
+// insertFile copies or moves a file into the books root it's placed on, and returns where it was placed.
+// If the books root already has the file, the original is left out of it, and placed is empty.
+// If inserting the file fails, nothing is left in the books root, and a moved file is moved back where it was.
+func (lib *Library) insertFile(file BookFile, deleteOriginal bool) (placed string, e error) {
+	newPath, err := lib.FilePath(file)
+	if err != nil {
+		return "", err
+	}
+	_, err = os.Stat(newPath)
 	if err == nil {
 		if deleteOriginal {
 			err := os.Remove(file.OriginalFilename)
@@ -903,17 +1559,101 @@ func (lib *Library) insertFile(file BookFile, deleteOriginal bool) error {
 				log.Printf("Error deleting %s: %v", file.OriginalFilename, err)
 			}
 		}
-		return nil
+		return "", nil
 	} else if !os.IsNotExist(err) {
-		return errors.Wrap(err, "stat")
+		return "", errors.Wrap(err, "stat")
+	}
+	if err := lib.perms.mkdirAll(filepath.Dir(newPath)); err != nil {
+		return "", errors.Wrap(err, "create destination directory")
 	}
 	// Move or copy the file to .tmp first, to avoid crashes causing partial files.
-	if err := moveOrCopyFile(file.OriginalFilename, newPath+".tmp", deleteOriginal); err != nil {
-		return errors.Wrap(err, "move or copy file")
+	tmp := newPath + ".tmp"
+	if err := moveOrCopyFile(file.OriginalFilename, tmp, deleteOriginal, lib.copyProgress); err != nil {
+		if _, ok := err.(SourceNotRemovedError); ok {
+			// The import will be rolled back, so the original is the only copy that should be left.
+			os.Remove(tmp)
+		}
+		return "", errors.Wrap(err, "move or copy file")
+	}
+	placed = tmp
+	defer func() {
+		if e != nil {
+			unplaceFile(placed, file.OriginalFilename, deleteOriginal)
+			placed = ""
+		}
+	}()
+	if err := lib.perms.applyFile(tmp); err != nil {
+		return placed, errors.Wrap(err, "set permissions")
+	}
+	if lib.durable {
+		// Flush the file before it gets its final name, so a crash can't leave a partial file there.
+		if err := syncFile(tmp); err != nil {
+			return placed, errors.Wrap(err, "sync file")
+		}
+	}
+	if err = os.Rename(tmp, newPath); err != nil {
+		return placed, errors.Wrap(err, "rename temporary file")
+	}
+	placed = newPath
+	if lib.durable {
+		root, err := lib.root(file.Root)
+		if err != nil {
+			return placed, err
+		}
+		if err := syncDirs(filepath.Dir(newPath), root.Path); err != nil {
+			return placed, errors.Wrap(err, "sync directories")
+		}
+	}
+	return placed, nil
+}
+
+// RelocateFile puts a file which was moved out of its books root back in place, given its new location.
+// The file at newPath must have the same hash as the file in the library.
+// If move is true, the file will be moved rather than copied.
+// A file cataloged in place is left at newPath, which is recorded as its path.
+// Once relocated, the file will no longer be marked as missing.
+func (lib *Library) RelocateFile(fileID int64, newPath string, move bool) error {
+	files, err := lib.GetFilesByID([]int64{fileID})
+	if err != nil {
+		return errors.Wrap(err, "get file")
+	}
+	if len(files) == 0 {
+		return ErrFileNotFound
+	}
+	file := files[0]
+	found := BookFile{OriginalFilename: newPath}
+	if err := found.CalculateHash(); err != nil {
+		return errors.Wrap(err, "calculate hash")
+	}
+	if found.Hash != file.Hash {
+		return errors.Errorf("hash of %s doesn't match file %d", newPath, fileID)
+	}
+	file.OriginalFilename = newPath
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if file.Path != "" {
+		abs, err := filepath.Abs(newPath)
+		if err != nil {
+			return err
+		}
+		if _, err := lib.Exec("update files set updated_on=datetime(), missing=0, path=? where id=?", abs, fileID); err != nil {
+			return errors.Wrap(err, "update path")
+		}
+		lib.invalidateCache()
+		log.Printf("Relocated file %d to %s", fileID, abs)
+		return nil
+	}
+	if _, err := lib.insertFile(file, move); err != nil {
+		return errors.Wrap(err, "insert file")
 	}
-	if err = os.Rename(newPath+".tmp", newPath); err != nil {
-		return errors.Wrap(err, "rename temporary file")
+	if _, err := lib.Exec("update files set updated_on=datetime(), missing=0 where id=?", fileID); err != nil {
+		return errors.Wrap(err, "clear missing flag")
 	}
+	lib.invalidateCache()
+	log.Printf("Relocated file %d from %s", fileID, newPath)
 	return nil
 }
 
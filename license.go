@@ -0,0 +1,95 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// License describes whether a book may be shared with others.
+type License string
+
+// Licenses of books. Creative Commons licenses may also name the license, such as cc-by-sa.
+const (
+	// LicenseUnknown is the license of books no license has been set for.
+	LicenseUnknown License = ""
+	// LicensePublicDomain is a book in the public domain.
+	LicensePublicDomain License = "public-domain"
+	// LicenseCC is a book under a Creative Commons license.
+	LicenseCC License = "cc"
+	// LicensePurchased is a book bought for the owner of the library, which can't be shared.
+	LicensePurchased License = "purchased"
+)
+
+// ccLicenseRegexp matches a Creative Commons license, such as cc, cc0 or cc-by-nc-4.0.
+var ccLicenseRegexp = regexp.MustCompile(`^cc(?:0|-[a-z0-9.-]+)?$`)
+
+// NormalizeLicense returns the License named by s, such as "Public Domain" or "CC BY-SA".
+// An empty string or "unknown" is LicenseUnknown.
+func NormalizeLicense(s string) (License, error) {
+	l := strings.Join(strings.Fields(strings.ToLower(s)), "-")
+	switch l {
+	case "", "unknown":
+		return LicenseUnknown, nil
+	case "pd", "public-domain":
+		return LicensePublicDomain, nil
+	case "purchased":
+		return LicensePurchased, nil
+	}
+	if ccLicenseRegexp.MatchString(l) {
+		return License(l), nil
+	}
+	return LicenseUnknown, errors.Errorf("unknown license %q; must be public domain, cc, a Creative Commons license such as cc-by-sa, purchased or unknown", s)
+}
+
+// IsCC returns true if l is a Creative Commons license.
+func (l License) IsCC() bool {
+	return ccLicenseRegexp.MatchString(string(l))
+}
+
+// Shareable returns true if books under l can be shared with others: public domain and Creative Commons books.
+func (l License) Shareable() bool {
+	return l == LicensePublicDomain || l.IsCC()
+}
+
+// String returns the license, or "unknown" for LicenseUnknown.
+func (l License) String() string {
+	if l == LicenseUnknown {
+		return "unknown"
+	}
+	return string(l)
+}
+
+// NotShareableError is returned by CheckShareable when some of the books can't be shared.
+type NotShareableError struct {
+	// Books are the books which can't be shared, because they're purchased or their license is unknown.
+	Books []Book
+}
+
+func (e NotShareableError) Error() string {
+	if len(e.Books) == 1 {
+		return fmt.Sprintf("book %d (%s) is %s, and may not be shareable", e.Books[0].ID, e.Books[0].Title, e.Books[0].License)
+	}
+	return fmt.Sprintf("%d books are purchased or have an unknown license, and may not be shareable", len(e.Books))
+}
+
+// CheckShareable returns a NotShareableError listing the books which can't be shared, if any,
+// so that anything sharing books with others, such as a bundle of files, can warn before including them.
+func CheckShareable(books []Book) error {
+	var notShareable []Book
+	for _, b := range books {
+		if !b.License.Shareable() {
+			notShareable = append(notShareable, b)
+		}
+	}
+	if len(notShareable) > 0 {
+		return NotShareableError{notShareable}
+	}
+	return nil
+}
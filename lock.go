@@ -0,0 +1,92 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A library can be used by several processes at once, such as a running server and a CLI import.
+// Two mechanisms keep them from corrupting it:
+//
+// SQLite locks the database itself, and a process which finds it locked waits up to the library's lock timeout
+// for the lock to be released, rather than failing straight away.
+//
+// Operations which change both the database and the files in the books root, such as importing a book
+// or removing a book's files, also hold an advisory lock on the library's lock file, the library's filename with .lock added.
+// This keeps one process from removing a file another process is about to import into the library, for example.
+// The lock file is only locked, never written to, and is left in place once the operation is finished.
+// If the lock isn't released within the lock timeout, the operation fails with ErrLibraryLocked.
+//
+// The lock file's lock is held by an open file, so two operations in one process would conflict just as two processes do,
+// and the second would fail rather than wait its turn. Operations on the same Library in one process
+// are serialized by a mutex instead, which is taken before the lock file, and waited for as long as it takes.
+
+// DefaultLockTimeout is how long a library waits for a lock held by another process, if LibraryOptions.LockTimeout is 0.
+const DefaultLockTimeout = 5 * time.Second
+
+// lockRetryInterval is how often the lock file is checked while waiting for it.
+const lockRetryInterval = 50 * time.Millisecond
+
+// ErrLibraryLocked is returned when the library is locked by another process for longer than the library's lock timeout.
+var ErrLibraryLocked = errors.New("library is locked by another process")
+
+// lock acquires the library's advisory lock, waiting for other operations on lib to finish,
+// then up to the lock timeout for other processes to release it.
+// The returned function releases it.
+func (lib *Library) lock() (unlock func(), err error) {
+	lib.lockMu.Lock()
+	unlockFile, err := lockLibrary(lib.filename, lib.lockTimeout)
+	if err != nil {
+		lib.lockMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		lib.lockMu.Unlock()
+	}, nil
+}
+
+// lockLibrary acquires the advisory lock of the library stored in filename, waiting up to timeout for it.
+func lockLibrary(filename string, timeout time.Duration) (unlock func(), err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var f io.Closer
+		var locked bool
+		f, locked, err = tryLockFile(filename + ".lock")
+		if err != nil {
+			return nil, errors.Wrap(err, "lock library")
+		}
+		if locked {
+			return func() { f.Close() }, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrLibraryLocked
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// tryLockFile takes an exclusive lock on filename, creating it if needed, without waiting.
+// If locked is true, closing f releases the lock.
+func tryLockFile(filename string) (f io.Closer, locked bool, err error) {
+	fp, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := syscall.Flock(int(fp.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		fp.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return fp, true, nil
+}
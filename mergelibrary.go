@@ -0,0 +1,282 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// mergeLibraryChunkSize is the number of books loaded from the other library at a time by MergeLibrary.
+const mergeLibraryChunkSize = 500
+
+// MergeLibraryOptions controls how MergeLibrary brings another library's books into this one.
+type MergeLibraryOptions struct {
+	// Template is the output template the names of files copied from the other library are generated from.
+	Template *template.Template
+	// MatchAuthorSubsets is passed on to the imports of books which aren't matched by UUID or hash, as in ImportOptions.
+	MatchAuthorSubsets bool
+	// Progress, if set, is called as each book of the other library is merged.
+	Progress ProgressFunc
+}
+
+// MergeLibraryResult describes a merge of another library into this one.
+type MergeLibraryResult struct {
+	// BooksAdded is the number of books which weren't in the library, and BooksMatched the number which were,
+	// found by their UUIDs or the hashes of their files.
+	BooksAdded   int
+	BooksMatched int
+	// FilesAdded is the number of files copied from the other library, and FilesSkipped the number which were already in this one.
+	FilesAdded   int
+	FilesSkipped int
+	// FilesMissing is the number of files which couldn't be read from the other library, such as those on books roots which aren't available.
+	FilesMissing int
+	// Updated are the IDs of matched books whose metadata was replaced by the other library's, since it was newer.
+	Updated []int64
+}
+
+// MergeLibrary imports all of the books of other, such as an old library database, into the library.
+// A book of the other library matches a book in this one with the same UUID, or failing that, one which has any of its files, by hash.
+// Files which aren't in the library yet are copied from the other library's books root, keeping their UUIDs,
+// and books which aren't matched are imported as usual, so they're added to books with the same title and authors.
+// The metadata of a matched book is replaced by the other library's if it was changed more recently there;
+// fields the newer book doesn't have, such as an empty publisher, are kept. Identifiers are added to matched books either way,
+// and their ratings are taken from the other library if it's newer or they aren't rated.
+// The other library isn't changed, other than being upgraded to the current schema when it's opened.
+func (lib *Library) MergeLibrary(other *Library, opts MergeLibraryOptions) (MergeLibraryResult, error) {
+	var res MergeLibraryResult
+	if other == lib {
+		return res, errors.New("cannot merge a library into itself")
+	}
+	ids, err := other.ListBookIDs(SortByID, false)
+	if err != nil {
+		return res, errors.Wrap(err, "list books of other library")
+	}
+	tracker := newProgressTracker("merge library", len(ids), opts.Progress)
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > mergeLibraryChunkSize {
+			chunk = chunk[:mergeLibraryChunkSize]
+		}
+		ids = ids[len(chunk):]
+		bks, err := other.GetBooksByID(chunk)
+		if err != nil {
+			return res, errors.Wrap(err, "get books of other library")
+		}
+		for _, book := range bks {
+			tracker.start(book.Title)
+			if err := lib.mergeLibraryBook(other, book, opts, &res); err != nil {
+				return res, errors.Wrapf(err, "merge book %d of other library", book.ID)
+			}
+			tracker.done()
+		}
+	}
+	return res, nil
+}
+
+// mergeLibraryBook merges one book of other into the library.
+func (lib *Library) mergeLibraryBook(other *Library, book Book, opts MergeLibraryOptions, res *MergeLibraryResult) error {
+	targetID, found, err := lib.mergeTarget(book)
+	if err != nil {
+		return err
+	}
+	var target Book
+	if found {
+		bks, err := lib.GetBooksByID([]int64{targetID})
+		if err != nil {
+			return err
+		}
+		if len(bks) == 0 {
+			return ErrBookNotFound
+		}
+		target = bks[0]
+		res.BooksMatched++
+	}
+
+	added := false
+	for _, f := range book.Files {
+		var n int
+		if err := lib.QueryRow("select count(*) from files where hash=?", f.Hash).Scan(&n); err != nil {
+			return errors.Wrap(err, "find file by hash")
+		}
+		if n > 0 {
+			res.FilesSkipped++
+			continue
+		}
+		fn, err := other.FilePath(f)
+		if err == nil {
+			_, err = os.Stat(fn)
+		}
+		if err != nil {
+			log.Printf("Not merging file %d of book %d from the other library: %s", f.ID, book.ID, err)
+			res.FilesMissing++
+			continue
+		}
+		if err := lib.QueryRow("select count(*) from files where uuid=?", f.UUID).Scan(&n); err != nil {
+			return errors.Wrap(err, "find file by UUID")
+		}
+		if n > 0 {
+			f.UUID = ""
+		}
+		fileID := f.ID
+		f.ID = 0
+		f.OriginalFilename = fn
+		f.CurrentFilename = ""
+		f.Root, f.Path = "", ""
+		f.Missing = false
+
+		imported := book
+		// Files of a matched book are added to it, by giving them its title and authors.
+		if found {
+			imported.Title, imported.Subtitle, imported.Authors = target.Title, target.Subtitle, target.Authors
+		}
+		imported.Files = []BookFile{f}
+		err = lib.ImportBookWithOptions(imported, opts.Template, ImportOptions{MatchAuthorSubsets: opts.MatchAuthorSubsets && !found})
+		if err != nil {
+			return errors.Wrapf(err, "import file %d", fileID)
+		}
+		res.FilesAdded++
+		added = true
+		if !found {
+			var bookID int64
+			err := lib.QueryRow("select book_id from files where hash=? order by id limit 1", f.Hash).Scan(&bookID)
+			if err == sql.ErrNoRows {
+				// The file was a duplicate, such as by content signature.
+				res.FilesAdded--
+				continue
+			} else if err != nil {
+				return errors.Wrap(err, "find imported file")
+			}
+			bks, err := lib.GetBooksByID([]int64{bookID})
+			if err != nil {
+				return err
+			}
+			if len(bks) == 0 {
+				return ErrBookNotFound
+			}
+			// The book was imported, and matches the other library's book from now on; its metadata was just taken from it.
+			target, found = bks[0], true
+			target.UpdatedOn = book.UpdatedOn
+			res.BooksAdded++
+		}
+	}
+	if !found {
+		if !added && len(book.Files) > 0 {
+			log.Printf("Not merging book %d from the other library, since none of its files could be read", book.ID)
+		}
+		return nil
+	}
+	return lib.mergeNewerMetadata(target, book, opts.Template, res)
+}
+
+// mergeTarget returns the ID of the book in the library matching book from another library, by UUID or file hash.
+func (lib *Library) mergeTarget(book Book) (int64, bool, error) {
+	var id int64
+	if book.UUID != "" {
+		err := lib.QueryRow("select id from books where uuid=?", book.UUID).Scan(&id)
+		if err == nil {
+			return id, true, nil
+		} else if err != sql.ErrNoRows {
+			return 0, false, errors.Wrap(err, "find book by UUID")
+		}
+	}
+	for _, f := range book.Files {
+		err := lib.QueryRow("select book_id from files where hash=? order by id limit 1", f.Hash).Scan(&id)
+		if err == nil {
+			return id, true, nil
+		} else if err != sql.ErrNoRows {
+			return 0, false, errors.Wrap(err, "find book by hash")
+		}
+	}
+	return 0, false, nil
+}
+
+// mergeNewerMetadata gives the book target, as it was before any files were added to it, the metadata of book from another library
+// if book was changed more recently, and its identifiers and rating.
+func (lib *Library) mergeNewerMetadata(target, book Book, tmpl *template.Template, res *MergeLibraryResult) error {
+	newer := book.UpdatedOn.After(target.UpdatedOn)
+	bks, err := lib.GetBooksByID([]int64{target.ID})
+	if err != nil {
+		return err
+	}
+	if len(bks) == 0 {
+		return ErrBookNotFound
+	}
+	current := bks[0]
+	if merged := current; newer && mergeFields(&merged, book) {
+		err := lib.UpdateBook(merged, tmpl, true)
+		if _, ok := errors.Cause(err).(BookExistsError); ok {
+			log.Printf("Not updating book %d from the other library's book %d: %s", current.ID, book.ID, err)
+		} else if err != nil {
+			return errors.Wrapf(err, "update book %d", current.ID)
+		} else {
+			res.Updated = append(res.Updated, current.ID)
+		}
+	}
+	for _, ident := range book.Identifiers {
+		err := lib.AddIdentifier(current.ID, ident.Type, ident.Value)
+		if iee, ok := err.(IdentifierExistsError); ok {
+			log.Printf("Not adding identifier %s:%s to book %d, since it belongs to book %d", iee.Identifier.Type, iee.Identifier.Value, current.ID, iee.BookID)
+		} else if err != nil {
+			return errors.Wrap(err, "add identifier")
+		}
+	}
+	if book.Rating != 0 && book.Rating != current.Rating && (newer || current.Rating == 0) {
+		if err := lib.SetRating(current.ID, book.Rating); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeFields replaces the fields of dst with those of src which aren't empty, and reports whether any of them changed.
+// Files, and what isn't changed by updating a book, are left alone.
+func mergeFields(dst *Book, src Book) bool {
+	changed := false
+	mergeString := func(dst *string, src string) {
+		if src != "" && src != *dst {
+			*dst, changed = src, true
+		}
+	}
+	mergeInt := func(dst *int, src int) {
+		if src != 0 && src != *dst {
+			*dst, changed = src, true
+		}
+	}
+	mergeStrings := func(dst *[]string, src []string) {
+		if len(src) > 0 && !stringSlicesEqual(*dst, src, false) {
+			*dst, changed = src, true
+		}
+	}
+	mergeString(&dst.Title, src.Title)
+	mergeString(&dst.Subtitle, src.Subtitle)
+	mergeStrings(&dst.Authors, src.Authors)
+	if src.Series != "" && (src.Series != dst.Series || src.SeriesIndex != dst.SeriesIndex) {
+		dst.Series, dst.SeriesIndex, changed = src.Series, src.SeriesIndex, true
+	}
+	mergeString(&dst.Publisher, src.Publisher)
+	mergeString(&dst.Description, src.Description)
+	mergeString(&dst.Language, src.Language)
+	mergeString(&dst.Published, src.Published)
+	mergeString(&dst.OriginalTitle, src.OriginalTitle)
+	mergeString(&dst.OriginalLanguage, src.OriginalLanguage)
+	mergeInt(&dst.Pages, src.Pages)
+	if src.License != "" && src.License != dst.License {
+		dst.License, changed = src.License, true
+	}
+	mergeInt(&dst.WordCount, src.WordCount)
+	mergeString(&dst.SourceURL, src.SourceURL)
+	if len(src.Contributors) > 0 && !contributorsEqual(dst.Contributors, src.Contributors) {
+		dst.Contributors, changed = src.Contributors, true
+	}
+	mergeStrings(&dst.Fandoms, src.Fandoms)
+	mergeStrings(&dst.Ships, src.Ships)
+	mergeStrings(&dst.Tags, src.Tags)
+	return changed
+}
@@ -6,7 +6,7 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
 )
 
 // A MetadataParser is used to parse the metadata for a book from a list of BookFiles.
@@ -23,9 +23,13 @@ type MetadataParser interface {
 // Each file’s extension will be trimmed before regular expressions are tested.
 // If a file doesn’t match the used regular expression, it will be included with its extension and no tags.
 // Regexps and RegexpNames must match.
+// The author group is parsed with AuthorParser, so it can name several authors, and contributors such as translators.
+// The year group, if there is one, is the year the book was published.
 type RegexpMetadataParser struct {
 	Regexps     []*regexp.Regexp
 	RegexpNames []string
+	// AuthorParser parses the author group. If nil, DefaultAuthorParser is used.
+	AuthorParser *AuthorParser
 }
 
 // Parse parses a list of files using regexps.
@@ -42,11 +46,16 @@ func (p *RegexpMetadataParser) Parse(files []string) (book Book, parsed bool) {
 				continue
 			}
 			log.Printf("Parsed metadata from file %s using regexp name %s", file, p.RegexpNames[i])
-			for _, author := range strings.Split(mapping["author"], " & ") {
-				book.Authors = append(book.Authors, strings.TrimSpace(author))
+			authorParser := DefaultAuthorParser
+			if p.AuthorParser != nil {
+				authorParser = *p.AuthorParser
 			}
+			book.Authors, book.Contributors = splitContributors(authorParser.Parse(mapping["author"]))
 			book.Title = mapping["title"]
 			book.Series = mapping["series"]
+			book.SeriesIndex = parseSeriesIndex(mapping["series_index"])
+			book.Publisher = mapping["publisher"]
+			book.Published = parsePublished(mapping["year"])
 			return book, true
 		}
 	}
@@ -85,31 +94,13 @@ func (*EpubMetadataParser) Parse(files []string) (book Book, parsed bool) {
 		if path.Ext(strings.ToLower(file)) != ".epub" {
 			continue
 		}
-		f, err := epub.Open(file)
-		if err != nil {
-			log.Printf("Error while opening epub %s: %s", file, err)
+		book, err := epubMetadata(file)
+		if errors.Cause(err) == ErrNoMetadata {
 			continue
-		}
-
-		m := f.Opf.Metadata
-		if len(m.Title) == 0 || m.Title[0] == "" {
-			f.Close()
-			continue
-		}
-		book.Title = m.Title[0]
-
-		book.Authors = make([]string, 0)
-		for _, author := range m.Creator {
-			if author.Data != "" {
-				book.Authors = append(book.Authors, author.Data)
-			}
-		}
-		if len(book.Authors) == 0 {
-			f.Close()
+		} else if err != nil {
+			log.Printf("Error while opening epub %s: %s", file, err)
 			continue
 		}
-		f.Close()
-
 		return book, true
 	}
 
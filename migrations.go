@@ -0,0 +1,520 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// migrations holds the schema changes applied on top of initialSchema, in order.
+// The number of migrations applied to a library is stored in its user_version pragma,
+// so new migrations must only ever be appended to the end of this list.
+var migrations = []string{
+	// Keep books_fts consistent when books or files are removed or moved between books,
+	// so deleted records don't linger as ghost search results.
+	`create trigger books_fts_delete_book after delete on books begin
+	delete from books_fts where docid = old.id;
+end;
+create trigger books_fts_delete_file after delete on files begin
+	update books_fts set
+		extension = (select coalesce(group_concat(extension, ' '), '') from files where book_id = old.book_id),
+		source = (select coalesce(group_concat(source, ' '), '') from files where book_id = old.book_id),
+		tags = (select coalesce(group_concat(t.name, ' '), '') from files f join files_tags ft on ft.file_id = f.id join tags t on ft.tag_id = t.id where f.book_id = old.book_id)
+	where docid = old.book_id;
+end;
+create trigger books_fts_move_file after update of book_id on files when old.book_id != new.book_id begin
+	update books_fts set
+		extension = (select coalesce(group_concat(extension, ' '), '') from files where book_id = books_fts.docid),
+		source = (select coalesce(group_concat(source, ' '), '') from files where book_id = books_fts.docid),
+		tags = (select coalesce(group_concat(t.name, ' '), '') from files f join files_tags ft on ft.file_id = f.id join tags t on ft.tag_id = t.id where f.book_id = books_fts.docid)
+	where docid in (old.book_id, new.book_id);
+end;`,
+	// Add publisher to books and the search index.
+	// FTS tables can't be altered, so the search index is copied into a new table with the extra column.
+	`alter table books add column publisher text not null default '';
+create temporary table books_fts_copy as select docid, author, series, title, extension, tags, filename, source from books_fts;
+drop table books_fts;
+create virtual table books_fts using fts4 (author, series, title, extension, tags,  filename, source, publisher);
+insert into books_fts (docid, author, series, title, extension, tags, filename, source) select docid, author, series, title, extension, tags, filename, source from books_fts_copy;
+drop table books_fts_copy;`,
+	// External identifiers, such as ISBNs and ASINs.
+	`create table identifiers (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+type text not null,
+value text not null,
+unique (type, value)
+);
+create index idx_identifiers_book_id on identifiers(book_id);`,
+	// Remember the files seen by Rescan, so unchanged files aren't hashed again.
+	// file_mtime is stored in nanoseconds since the Unix epoch, so it can be compared exactly.
+	`create table scan_cache (
+path text primary key,
+file_size integer not null,
+file_mtime integer not null,
+hash text not null,
+scanned_on timestamp not null default (datetime())
+);`,
+	// Files found to be missing from the books root by CheckMissingFiles.
+	`alter table files add column missing integer not null default 0;`,
+	// Reading progress, reported by KOReader's progress sync.
+	`alter table files add column partial_md5 text;
+create index idx_files_partial_md5 on files(partial_md5);
+create table sync_users (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+username text not null unique,
+key text not null
+);
+create table reading_progress (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+username text not null references sync_users(username) on delete cascade,
+document text not null,
+file_id integer references files(id) on delete set null,
+progress text not null,
+percentage real not null,
+device text not null default '',
+device_id text not null default '',
+unique(username, document)
+);
+create index idx_reading_progress_file_id on reading_progress(file_id);`,
+	// Preferences saved by frontends, per user or global.
+	`create table preferences (
+username text not null,
+key text not null,
+value text not null,
+updated_on timestamp not null default (datetime()),
+primary key(username, key)
+);`,
+	// Issues of periodicals imported from feeds, for expiring old issues.
+	`create table periodical_issues (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+book_id integer not null unique references books(id) on delete cascade,
+feed text not null,
+issue_date timestamp not null,
+last_item timestamp not null
+);
+create index idx_periodical_issues_feed on periodical_issues(feed, issue_date);`,
+	// The vocabulary of the search index, for suggesting corrections to misspelled searches.
+	`create virtual table books_fts_terms using fts4aux(books_fts);`,
+	// Globally unique identifiers for books and files, which stay the same across libraries.
+	// Existing records get random (version 4) UUIDs.
+	`alter table books add column uuid text;
+alter table files add column uuid text;
+update books set uuid = ` + sqlRandomUUID + `;
+update files set uuid = ` + sqlRandomUUID + `;
+create unique index idx_books_uuid on books(uuid);
+create unique index idx_files_uuid on files(uuid);`,
+	// Results of mutations made with idempotency keys, so retried requests aren't applied twice.
+	`create table idempotency_keys (
+key text primary key,
+operation text not null,
+result text not null,
+created_on timestamp not null default (datetime())
+);`,
+	// Dewey and LCC classifications of books.
+	`create table book_classifications (
+book_id integer not null references books(id) on delete cascade,
+scheme text not null,
+number text not null,
+primary key (book_id, scheme)
+);
+create index idx_book_classifications_number on book_classifications(scheme, number);`,
+	// Works contained in files, such as the stories in an anthology.
+	// Their titles and authors are added to the search index, which is copied into a new table with the extra column.
+	`create table contained_works (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+file_id integer not null references files(id) on delete cascade,
+position integer not null,
+title text not null,
+authors text not null default ''
+);
+create index idx_contained_works_file_id on contained_works(file_id);
+drop table books_fts_terms;
+create temporary table books_fts_copy as select docid, author, series, title, extension, tags, filename, source, publisher from books_fts;
+drop table books_fts;
+create virtual table books_fts using fts4 (author, series, title, extension, tags, filename, source, publisher, works);
+insert into books_fts (docid, author, series, title, extension, tags, filename, source, publisher)
+select docid, author, series, title, extension, tags, filename, source, publisher from books_fts_copy;
+drop table books_fts_copy;
+create virtual table books_fts_terms using fts4aux(books_fts);
+drop trigger books_fts_delete_file;
+drop trigger books_fts_move_file;
+create trigger books_fts_delete_file after delete on files begin
+	update books_fts set
+		extension = (select coalesce(group_concat(extension, ' '), '') from files where book_id = old.book_id),
+		source = (select coalesce(group_concat(source, ' '), '') from files where book_id = old.book_id),
+		tags = (select coalesce(group_concat(t.name, ' '), '') from files f join files_tags ft on ft.file_id = f.id join tags t on ft.tag_id = t.id where f.book_id = old.book_id),
+		works = ` + sqlIndexedWorks("old.book_id") + `
+	where docid = old.book_id;
+end;
+create trigger books_fts_move_file after update of book_id on files when old.book_id != new.book_id begin
+	update books_fts set
+		extension = (select coalesce(group_concat(extension, ' '), '') from files where book_id = books_fts.docid),
+		source = (select coalesce(group_concat(source, ' '), '') from files where book_id = books_fts.docid),
+		tags = (select coalesce(group_concat(t.name, ' '), '') from files f join files_tags ft on ft.file_id = f.id join tags t on ft.tag_id = t.id where f.book_id = books_fts.docid),
+		works = ` + sqlIndexedWorks("books_fts.docid") + `
+	where docid in (old.book_id, new.book_id);
+end;`,
+	// Contributor roles. Someone can have several roles in the same book, such as author and illustrator.
+	`create table books_authors_roles (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+author_id integer not null references authors(id) on delete cascade,
+role text not null default 'author',
+unique (book_id, author_id, role)
+);
+insert into books_authors_roles (id, created_on, updated_on, book_id, author_id)
+select id, created_on, updated_on, book_id, author_id from books_authors;
+drop table books_authors;
+alter table books_authors_roles rename to books_authors;`,
+	// Search index columns for contributors other than authors.
+	`drop table books_fts_terms;
+create temporary table books_fts_copy as select docid, author, series, title, extension, tags, filename, source, publisher, works from books_fts;
+drop table books_fts;
+create virtual table books_fts using fts4 (author, series, title, extension, tags, filename, source, publisher, works, editor, translator, narrator, illustrator);
+insert into books_fts (docid, author, series, title, extension, tags, filename, source, publisher, works, editor, translator, narrator, illustrator)
+select docid, author, series, title, extension, tags, filename, source, publisher, works,
+` + sqlIndexedContributors(RoleEditor, "docid") + `,
+` + sqlIndexedContributors(RoleTranslator, "docid") + `,
+` + sqlIndexedContributors(RoleNarrator, "docid") + `,
+` + sqlIndexedContributors(RoleIllustrator, "docid") + `
+from books_fts_copy;
+drop table books_fts_copy;
+create virtual table books_fts_terms using fts4aux(books_fts);`,
+	// Original titles and languages of translations, and links from translations to their originals.
+	`alter table books add column original_title text not null default '';
+alter table books add column original_language text not null default '';
+alter table books add column translation_of integer references books(id) on delete set null;
+create index idx_books_translation_of on books(translation_of);
+drop table books_fts_terms;
+create temporary table books_fts_copy as select docid, author, series, title, extension, tags, filename, source, publisher, works, editor, translator, narrator, illustrator from books_fts;
+drop table books_fts;
+create virtual table books_fts using fts4 (author, series, title, extension, tags, filename, source, publisher, works, editor, translator, narrator, illustrator,
+original_title, original_language);
+insert into books_fts (docid, author, series, title, extension, tags, filename, source, publisher, works, editor, translator, narrator, illustrator)
+select docid, author, series, title, extension, tags, filename, source, publisher, works, editor, translator, narrator, illustrator from books_fts_copy;
+drop table books_fts_copy;
+create virtual table books_fts_terms using fts4aux(books_fts);`,
+	// Ratings of books, from 1 to 5, or 0 if unrated.
+	`alter table books add column rating integer not null default 0;`,
+	// Page counts of books, and yearly reading goals of sync users.
+	`alter table books add column pages integer not null default 0;
+create table reading_goals (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+username text not null references sync_users(username) on delete cascade,
+year integer not null,
+kind text not null,
+target integer not null,
+unique(username, year, kind)
+);`,
+	// The activity feed, started with the imports and finished books already in the library.
+	`create table activity (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+kind text not null,
+book_id integer not null references books(id) on delete cascade,
+file_id integer references files(id) on delete set null,
+username text not null default '',
+detail text not null default ''
+);
+create index idx_activity_created_on on activity(created_on);
+create index idx_activity_book_id on activity(book_id);
+create index idx_activity_file_id on activity(file_id);
+insert into activity (created_on, kind, book_id, file_id) select created_on, 'import', book_id, id from files order by created_on, id;
+insert into activity (created_on, kind, book_id, file_id, username)
+select p.updated_on, 'finish', f.book_id, f.id, p.username from reading_progress p join files f on p.file_id = f.id where p.percentage >= 0.95 order by p.updated_on;`,
+	// Regenerate the search index from the books, so the filename field, which used to be left empty, is indexed.
+	regenerateSearchIndex,
+	// Tags of books as a whole, rather than of one of their files.
+	`create table books_tags (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+tag_id integer not null references tags(id) on delete cascade,
+unique (book_id, tag_id)
+);
+create index idx_books_tags_tag_id on books_tags(tag_id);`,
+	// Synonyms of search terms, such as sf for science fiction.
+	`create table synonyms (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+term text not null collate nocase,
+expansion text not null collate nocase,
+index_time integer not null default 0,
+unique (term, expansion)
+);`,
+	// Licenses of books, which say whether they can be shared.
+	`alter table books add column license text not null default '';`,
+	// Keep updated_on current when books and files change, however they're changed.
+	// Changes to a book's authors, tags, identifiers, classifications and files change the book,
+	// and changes to a file's tags and works change the file, and so its book.
+	// Updates which set updated_on themselves are left alone, and triggers don't fire themselves recursively.
+	// Books which had files added after they were last updated are counted as updated then.
+	`update books set updated_on = max(updated_on, coalesce((select max(f.created_on) from files f where f.book_id = books.id), updated_on));
+create trigger books_updated_on after update on books when new.updated_on is old.updated_on begin
+	update books set updated_on = datetime() where id = new.id;
+end;
+create trigger files_updated_on after update on files when new.updated_on is old.updated_on begin
+	update files set updated_on = datetime() where id = new.id;
+end;
+` + sqlTouchTrigger("books_authors", "books", "book_id") +
+		sqlTouchTrigger("books_tags", "books", "book_id") +
+		sqlTouchTrigger("identifiers", "books", "book_id") +
+		sqlTouchTrigger("book_classifications", "books", "book_id") +
+		sqlTouchTrigger("files", "books", "book_id") +
+		sqlTouchTrigger("files_tags", "files", "file_id") +
+		sqlTouchTrigger("contained_works", "files", "file_id") + `
+create trigger files_books_updated_on after update on files begin
+	update books set updated_on = datetime() where id in (old.book_id, new.book_id);
+end;`,
+	// Content signatures of EPUBs, which hash their content without their metadata.
+	`alter table files add column content_signature text;
+create index idx_files_content_signature on files(content_signature);`,
+	// Things made from files, such as covers and previews, by the hash of the file they were made from.
+	`create table derivations (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+hash text not null,
+kind text not null,
+params text not null default '',
+filename text not null default '',
+unique (hash, kind, params)
+);`,
+	// The books roots files are stored on, by name, with the main root's name empty.
+	`alter table files add column root text not null default '';`,
+	// Positions of books in their series, with 0 for unknown.
+	`alter table books add column series_index real not null default 0;`,
+	// Reports of batch imports, with the outcome of each file as JSON.
+	`create table import_reports (
+id integer primary key,
+started_on timestamp not null,
+finished_on timestamp not null,
+imported integer not null default 0,
+duplicates integer not null default 0,
+failures integer not null default 0,
+entries text not null default '[]'
+);`,
+	// Hashes of converted books, so importing a copy of one adds it to the book it was converted from.
+	`alter table derivations add column output_hash text;
+create index idx_derivations_output_hash on derivations(output_hash);`,
+	// Dominant colors of covers, as hex colors separated by commas, by the hash of the file the cover is from.
+	`create table cover_palettes (
+hash text primary key,
+created_on timestamp not null default (datetime()),
+colors text not null
+);`,
+	// Other titles books are known by, such as subtitles and abbreviations, which are searched along with their titles.
+	`create table alternate_titles (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+title text not null collate nocase,
+kind text not null default '',
+unique (book_id, title)
+);
+create index idx_alternate_titles_title on alternate_titles(title);
+` + sqlTouchTrigger("alternate_titles", "books", "book_id"),
+	sqlAddSearchField("alternate_titles"),
+	// Subtitles, kept apart from titles.
+	`alter table books add column subtitle text not null default '';`,
+	sqlAddSearchField("subtitle"),
+	// Fanfiction metadata: word counts, the URLs books were downloaded from, and fandoms and ships.
+	`alter table books add column word_count integer not null default 0;
+alter table books add column source_url text not null default '';
+create table fanfic_tags (
+id integer primary key,
+book_id integer not null references books(id) on delete cascade,
+kind text not null,
+name text not null collate nocase,
+unique (book_id, kind, name)
+);
+create index idx_fanfic_tags_name on fanfic_tags(kind, name);
+` + sqlTouchTrigger("fanfic_tags", "books", "book_id"),
+	sqlAddSearchField("fandom"),
+	sqlAddSearchField("ship"),
+	sqlAddSearchField("url"),
+	// How long the issues of each periodical are kept, with 0 for no limit. max_age is in seconds.
+	`create table periodical_retention (
+feed text primary key,
+keep integer not null default 0,
+max_age integer not null default 0
+);`,
+	// Different people with the same name, told apart by disambiguation notes, such as "(poet)", and external IDs, such as VIAF or Wikidata IDs.
+	// Names are no longer unique, and SQLite can't drop the constraint, so authors is recreated.
+	// Dropping authors would remove the links to it by foreign key, so books_authors is recreated first,
+	// and neither is renamed, which would fail on the search index triggers referring to authors.
+	`create temporary table authors_copy as select * from authors;
+create temporary table books_authors_copy as select * from books_authors;
+drop table books_authors;
+drop table authors;
+create table authors (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+name text not null,
+disambiguation text not null default '',
+external_id text not null default '',
+unique (name, disambiguation)
+);
+create index idx_authors_external_id on authors(external_id);
+insert into authors (id, created_on, updated_on, name)
+select id, created_on, updated_on, name from authors_copy;
+create table books_authors (
+id integer primary key,
+created_on timestamp not null default (datetime()),
+updated_on timestamp not null default (datetime()),
+book_id integer not null references books(id) on delete cascade,
+author_id integer not null references authors(id) on delete cascade,
+role text not null default 'author',
+unique (book_id, author_id, role)
+);
+insert into books_authors (id, created_on, updated_on, book_id, author_id, role)
+select id, created_on, updated_on, book_id, author_id, role from books_authors_copy;
+drop table authors_copy;
+drop table books_authors_copy;
+` + sqlTouchTrigger("books_authors", "books", "book_id"),
+	// Searches made with SearchOptions.Log, and how many books they found, for SearchAnalytics.
+	`create table search_log (
+id integer primary key,
+searched_on timestamp not null default (datetime()),
+query text not null collate nocase,
+results integer not null
+);
+create index idx_search_log_query on search_log(query, id);
+create index idx_search_log_searched_on on search_log(searched_on);`,
+	// The absolute paths of files cataloged in place, outside of the books roots, or empty for files stored in a books root.
+	`alter table files add column path text not null default '';`,
+	// Books' descriptions, the language codes of the languages they're written in, and their publication dates,
+	// as much of them as is known, such as 1999 or 1999-05-17.
+	`alter table books add column description text not null default '';
+alter table books add column language text not null default '';
+alter table books add column published text not null default '';`,
+	// Regenerate the search index, so extensions and sources shared by several files of a book are only indexed once,
+	// and entries left with terms the books no longer have by older versions are cleaned up.
+	regenerateSearchIndex,
+}
+
+// sqlTouchTrigger returns SQL which creates triggers that set updated_on in parent
+// for the row referred to by column when rows are added to or removed from table.
+func sqlTouchTrigger(table, parent, column string) string {
+	return `create trigger ` + table + `_insert_updated_on after insert on ` + table + ` begin
+	update ` + parent + ` set updated_on = datetime() where id = new.` + column + `;
+end;
+create trigger ` + table + `_delete_updated_on after delete on ` + table + ` begin
+	update ` + parent + ` set updated_on = datetime() where id = old.` + column + `;
+end;
+`
+}
+
+// regenerateSearchIndex is a migration which regenerates the search index with the fields already indexed.
+// The index is regenerated once every migration has been applied,
+// since the SQL for its fields may use tables which later migrations add.
+const regenerateSearchIndex = "-- regenerate search index"
+
+// addSearchFieldPrefix starts migrations which add a field to the search index.
+const addSearchFieldPrefix = "-- add search field "
+
+// sqlAddSearchField returns a migration which adds field, one of AllSearchFields, to the search index,
+// which is then regenerated as with regenerateSearchIndex.
+// Libraries which chose their fields couldn't have chosen a new one, so it's added to them too, and can be removed with SetSearchFields.
+func sqlAddSearchField(field string) string {
+	return addSearchFieldPrefix + field
+}
+
+// sqlRandomUUID is an SQL expression which generates a random UUID.
+const sqlRandomUUID = `lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' ||
+substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))`
+
+// migrate brings the schema of db up to date by applying any migrations which haven't been applied yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("pragma user_version").Scan(&version); err != nil {
+		return errors.Wrap(err, "get schema version")
+	}
+	if version > len(migrations) {
+		return errors.Errorf("library schema version %d is newer than the supported version %d", version, len(migrations))
+	}
+
+	if version == len(migrations) {
+		return nil
+	}
+
+	// Every pending migration is applied in one transaction, along with the search index update they call for,
+	// so a library is never left with some migrations applied but its search index not updated for them.
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin migrations")
+	}
+	regenerate := false
+	var addFields []string
+	for i := version; i < len(migrations); i++ {
+		if migrations[i] == regenerateSearchIndex {
+			regenerate = true
+		} else if strings.HasPrefix(migrations[i], addSearchFieldPrefix) {
+			addFields = append(addFields, strings.TrimPrefix(migrations[i], addSearchFieldPrefix))
+			regenerate = true
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "apply migration %d", i+1)
+		}
+	}
+	if err := updateSearchIndex(tx, addFields, regenerate); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Pragmas can't take bound parameters.
+	if _, err := tx.Exec("pragma user_version=" + strconv.Itoa(len(migrations))); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "set schema version %d", len(migrations))
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit migrations")
+	}
+	log.Printf("Applied library migrations %d to %d", version+1, len(migrations))
+	if regenerate {
+		log.Printf("Regenerated search index")
+	}
+	return nil
+}
+
+// updateSearchIndex brings the search index up to date after migrations, adding addFields to it.
+// If regenerate is true, the whole index is regenerated; otherwise only its triggers are recreated,
+// since they include the SQL for each indexed field, which migrations may have changed.
+func updateSearchIndex(tx *sql.Tx, addFields []string, regenerate bool) error {
+	fields, err := searchIndexFields(tx)
+	if err != nil {
+		return err
+	}
+	if len(addFields) > 0 {
+		if fields, err = normalizeSearchFields(append(fields, addFields...)); err != nil {
+			return err
+		}
+	}
+	if regenerate {
+		_, err := tx.Exec(sqlCreateSearchIndex(fields))
+		return errors.Wrap(err, "regenerate search index")
+	}
+	_, err = tx.Exec(sqlCreateSearchTriggers(fields))
+	return errors.Wrap(err, "recreate search index triggers")
+}
@@ -0,0 +1,86 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+// Package openlibrary looks up book metadata from Open Library.
+package openlibrary
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/tspivey/books"
+)
+
+// DefaultURL is the URL of Open Library.
+const DefaultURL = "https://openlibrary.org"
+
+// Classifier looks up the Dewey and LCC classifications of books by their ISBNs.
+// It implements books.ClassificationProvider.
+type Classifier struct {
+	// URL is the URL of Open Library, DefaultURL if empty.
+	URL    string
+	Client *http.Client
+}
+
+// edition is the part of an Open Library edition record which holds its classifications.
+type edition struct {
+	Dewey []string `json:"dewey_decimal_class"`
+	LCC   []string `json:"lc_classifications"`
+}
+
+// Classify returns the classifications of the first of book's ISBNs which Open Library has an edition for.
+// Books without ISBNs have no classifications.
+func (c *Classifier) Classify(book books.Book) ([]books.Classification, error) {
+	for _, ident := range book.Identifiers {
+		if ident.Type != "isbn" {
+			continue
+		}
+		ed, found, err := c.getEdition(ident.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get edition for ISBN %s", ident.Value)
+		}
+		if !found {
+			continue
+		}
+		var cs []books.Classification
+		if len(ed.Dewey) > 0 {
+			cs = append(cs, books.Classification{Scheme: books.Dewey, Number: ed.Dewey[0]})
+		}
+		if len(ed.LCC) > 0 {
+			cs = append(cs, books.Classification{Scheme: books.LCC, Number: ed.LCC[0]})
+		}
+		return cs, nil
+	}
+	return nil, nil
+}
+
+func (c *Classifier) getEdition(isbn string) (edition, bool, error) {
+	var ed edition
+	base := c.URL
+	if base == "" {
+		base = DefaultURL
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(base + "/isbn/" + url.PathEscape(isbn) + ".json")
+	if err != nil {
+		return ed, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ed, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ed, false, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ed); err != nil {
+		return ed, false, errors.Wrap(err, "decode edition")
+	}
+	return ed, true, nil
+}
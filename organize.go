@@ -0,0 +1,166 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// FileRename is a change to the name of a file made, or planned, by OrganizeFiles.
+type FileRename struct {
+	FileID  int64
+	BookID  int64
+	OldName string
+	NewName string
+}
+
+// OrganizeFiles names the files of the books matching query, or all books if query is empty, with tmpl,
+// such as after the output template was changed, and returns the files which were renamed.
+// Files with a template override are named by it instead.
+// Files are stored in the books roots by their hashes, so renaming them only changes the names they're listed,
+// downloaded and searched by, and nothing on disk is moved.
+// All of the files are renamed in one transaction. If dryRun is true, the renames are returned but not made.
+func (lib *Library) OrganizeFiles(query string, tmpl *template.Template, dryRun bool, progress ProgressFunc) ([]FileRename, error) {
+	var ids []int64
+	var err error
+	if strings.TrimSpace(query) == "" {
+		ids, err = lib.ListBookIDs(SortByID, false)
+	} else {
+		var results []SearchResult
+		results, _, err = lib.SearchWithOptions(query, SearchOptions{})
+		for _, r := range results {
+			ids = append(ids, r.Book.ID)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "find books")
+	}
+
+	if !dryRun {
+		unlock, err := lib.lock()
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	renames, err := lib.organizeFiles(tx, ids, tmpl, dryRun, progress)
+	if err != nil || dryRun {
+		tx.Rollback()
+		return renames, err
+	}
+	err = tx.Commit()
+	var changed []int64
+	for _, r := range renames {
+		changed = append(changed, r.BookID)
+	}
+	lib.invalidateBooks(changed...)
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	if len(renames) > 0 {
+		log.Printf("Renamed %d files", len(renames))
+	}
+	return renames, nil
+}
+
+func (lib *Library) organizeFiles(tx *sql.Tx, ids []int64, tmpl *template.Template, dryRun bool, progress ProgressFunc) ([]FileRename, error) {
+	bks, err := getBooksByID(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	sort.Slice(bks, func(i, j int) bool { return bks[i].ID < bks[j].ID })
+	var renames []FileRename
+	tracker := newProgressTracker("organize", len(bks), progress)
+	for i := range bks {
+		book := &bks[i]
+		tracker.start(book.Title)
+		renamed := false
+		for _, f := range book.Files {
+			newFn, err := lib.generateFilename(&f, tmpl, book)
+			if err != nil {
+				return nil, errors.Wrapf(err, "get filename of file %d", f.ID)
+			}
+			if newFn == f.CurrentFilename {
+				continue
+			}
+			renames = append(renames, FileRename{FileID: f.ID, BookID: book.ID, OldName: f.CurrentFilename, NewName: newFn})
+			renamed = true
+			if dryRun {
+				continue
+			}
+			if _, err := tx.Exec("update files set updated_on=datetime(), filename=? where id=?", newFn, f.ID); err != nil {
+				return nil, errors.Wrapf(err, "rename file %d", f.ID)
+			}
+		}
+		// Filenames are searched, so the book is indexed again with its new ones.
+		if renamed && !dryRun {
+			if err := indexBook(tx, book.ID); err != nil {
+				return nil, errors.Wrapf(err, "index book %d", book.ID)
+			}
+		}
+		tracker.done()
+	}
+	return renames, nil
+}
+
+// SetFileTemplate sets the template a file is named by, instead of tmpl, the library's output template, and renames the file with it.
+// The template can use the same functions as tmpl. If src is empty, the file is named by tmpl again.
+func (lib *Library) SetFileTemplate(fileID int64, src string, tmpl *template.Template) error {
+	if src != "" {
+		if _, err := parseTemplateOverride(tmpl, src); err != nil {
+			return err
+		}
+	}
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	res, err := tx.Exec("update files set updated_on=datetime(), template_override=nullif(?, '') where id=?", src, fileID)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "set template override")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return ErrFileNotFound
+	}
+	book, err := bookOfFile(tx, fileID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := lib.organizeFiles(tx, []int64{book.ID}, tmpl, false, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(book.ID)
+	return errors.Wrap(err, "commit")
+}
+
+// parseTemplateOverride parses the template override of a file, with the same functions as tmpl.
+func parseTemplateOverride(tmpl *template.Template, src string) (*template.Template, error) {
+	t, err := tmpl.Clone()
+	if err != nil {
+		return nil, errors.Wrap(err, "copy output template")
+	}
+	t, err = t.Parse(src)
+	return t, errors.Wrap(err, "parse template override")
+}
@@ -0,0 +1,94 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// normalizeOriginalFilename returns fn as a clean absolute path, resolving it from the current directory if it's relative.
+func normalizeOriginalFilename(fn string) (string, error) {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return "", errors.Wrapf(err, "get absolute path of %s", fn)
+	}
+	return abs, nil
+}
+
+// ResolveOriginalFilename returns the absolute path a file was imported from.
+// Files imported before original filenames were made absolute may have paths relative to the directory they were imported from,
+// which wasn't recorded. For those, the relative path is returned, and ok is false.
+// NormalizeOriginalFilenames makes them absolute.
+func (lib *Library) ResolveOriginalFilename(fileID int64) (fn string, ok bool, err error) {
+	err = lib.QueryRow("select original_filename from files where id=?", fileID).Scan(&fn)
+	if err == sql.ErrNoRows {
+		return "", false, ErrFileNotFound
+	} else if err != nil {
+		return "", false, errors.Wrapf(err, "get original filename of file %d", fileID)
+	}
+	return fn, filepath.IsAbs(fn), nil
+}
+
+// RelativeOriginalFilenames returns the number of files whose original filenames are relative,
+// so can't be resolved by ResolveOriginalFilename.
+func (lib *Library) RelativeOriginalFilenames() (int, error) {
+	files, err := lib.relativeOriginalFilenames()
+	return len(files), err
+}
+
+// NormalizeOriginalFilenames makes the relative original filenames of files absolute, resolving them from base,
+// the directory the files were imported from. It returns the number of files changed.
+func (lib *Library) NormalizeOriginalFilenames(base string) (int, error) {
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return 0, errors.Wrap(err, "get absolute path")
+	}
+	files, err := lib.relativeOriginalFilenames()
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, nil
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "begin transaction")
+	}
+	for id, fn := range files {
+		if _, err := tx.Exec("update files set updated_on=datetime(), original_filename=? where id=?", filepath.Join(base, fn), id); err != nil {
+			tx.Rollback()
+			return 0, errors.Wrapf(err, "update original filename of file %d", id)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "commit")
+	}
+	lib.invalidateCache()
+	return len(files), nil
+}
+
+// relativeOriginalFilenames returns the relative original filenames of files, by file ID.
+func (lib *Library) relativeOriginalFilenames() (map[int64]string, error) {
+	rows, err := lib.Query("select id, original_filename from files")
+	if err != nil {
+		return nil, errors.Wrap(err, "get original filenames")
+	}
+	defer rows.Close()
+	files := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var fn string
+		if err := rows.Scan(&id, &fn); err != nil {
+			return nil, errors.Wrap(err, "get original filenames")
+		}
+		if !filepath.IsAbs(fn) {
+			files[id] = fn
+		}
+	}
+	return files, errors.Wrap(rows.Err(), "get original filenames")
+}
@@ -0,0 +1,140 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+	// Covers may be in any of these formats.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Limits of cover palettes.
+const (
+	// PaletteSize is the most colors in a cover's palette.
+	PaletteSize = 5
+	// paletteSamples is the most pixels sampled along each side of a cover.
+	paletteSamples = 100
+	// minPaletteDistance is how far apart, in RGB, the colors of a palette are, so it isn't all shades of one color.
+	minPaletteDistance = 48
+)
+
+// CoverPalette returns the dominant colors of a book's cover, most common first, as hex colors such as "#1a2b3c",
+// so frontends can theme a book to match its cover.
+// Palettes are cached in the library by the file the cover is from. If the book has no cover, the error is ErrNoCover.
+func (lib *Library) CoverPalette(bookID int64) ([]string, error) {
+	fn, file, err := lib.bookCover(bookID)
+	if err != nil {
+		return nil, err
+	}
+	var colors string
+	err = lib.QueryRow("select colors from cover_palettes where hash=?", file.Hash).Scan(&colors)
+	if err == nil {
+		if colors == "" {
+			return []string{}, nil
+		}
+		return strings.Split(colors, ","), nil
+	} else if err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "get cover palette")
+	}
+
+	palette, err := imagePalette(fn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get palette of cover of book %d", bookID)
+	}
+	if _, err := lib.Exec("insert or replace into cover_palettes (hash, colors) values(?, ?)", file.Hash, strings.Join(palette, ",")); err != nil {
+		return nil, errors.Wrap(err, "save cover palette")
+	}
+	return palette, nil
+}
+
+// imagePalette returns the dominant colors of the image in fn.
+func imagePalette(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode image")
+	}
+	return paletteOf(img), nil
+}
+
+// paletteOf returns the dominant colors of img, by counting a sample of its pixels in buckets of similar colors.
+func paletteOf(img image.Image) []string {
+	type bucket struct {
+		n       int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+	bounds := img.Bounds()
+	stepX := bounds.Dx()/paletteSamples + 1
+	stepY := bounds.Dy()/paletteSamples + 1
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				// Mostly transparent pixels aren't part of the cover.
+				continue
+			}
+			r, g, b = r>>8, g>>8, b>>8
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.n++
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+		}
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		// Each bucket's color is the average of its pixels.
+		bk.r, bk.g, bk.b = bk.r/bk.n, bk.g/bk.n, bk.b/bk.n
+		sorted = append(sorted, bk)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].n != sorted[j].n {
+			return sorted[i].n > sorted[j].n
+		}
+		return sorted[i].r<<16|sorted[i].g<<8|sorted[i].b < sorted[j].r<<16|sorted[j].g<<8|sorted[j].b
+	})
+	var chosen []*bucket
+	for _, bk := range sorted {
+		distinct := true
+		for _, c := range chosen {
+			dr, dg, db := bk.r-c.r, bk.g-c.g, bk.b-c.b
+			if dr*dr+dg*dg+db*db < minPaletteDistance*minPaletteDistance {
+				distinct = false
+				break
+			}
+		}
+		if distinct {
+			chosen = append(chosen, bk)
+		}
+		if len(chosen) == PaletteSize {
+			break
+		}
+	}
+	palette := make([]string, 0, len(chosen))
+	for _, c := range chosen {
+		palette = append(palette, fmt.Sprintf("#%02x%02x%02x", c.r, c.g, c.b))
+	}
+	return palette
+}
@@ -0,0 +1,78 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// LibraryPaths are the directories a library keeps its files in.
+// Apart from the books root, they're next to the library file.
+type LibraryPaths struct {
+	// BooksRoot holds the files of the books in the library.
+	BooksRoot string
+	// Cache holds books converted to other formats.
+	Cache string
+	// Covers holds cover images extracted from books.
+	Covers string
+	// Previews holds rendered pages and opening excerpts of books.
+	Previews string
+	// Trash holds files removed from the library, until it's emptied.
+	Trash string
+	// Quarantine holds files which were set aside instead of being imported, such as files which failed a check.
+	Quarantine string
+	// RemoteCache holds copies of recently read files on remote books roots.
+	RemoteCache string
+}
+
+// newLibraryPaths returns the absolute paths of the directories of the library in filename, with its books in booksRoot.
+func newLibraryPaths(filename, booksRoot string) (LibraryPaths, error) {
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return LibraryPaths{}, err
+	}
+	root, err := filepath.Abs(booksRoot)
+	if err != nil {
+		return LibraryPaths{}, err
+	}
+	return LibraryPaths{
+		BooksRoot:   root,
+		Cache:       filepath.Join(dir, "cache"),
+		Covers:      filepath.Join(dir, "covers"),
+		Previews:    filepath.Join(dir, "previews"),
+		Trash:       filepath.Join(dir, "trash"),
+		Quarantine:  filepath.Join(dir, "quarantine"),
+		RemoteCache: filepath.Join(dir, "remote_cache"),
+	}, nil
+}
+
+// managed returns the directories the library creates, which are all of them except the books root.
+func (p LibraryPaths) managed() []string {
+	return []string{p.Cache, p.Covers, p.Previews, p.Trash, p.Quarantine, p.RemoteCache}
+}
+
+// prepare creates the directories the library manages, and checks that the books root, if it exists, is a directory.
+// A books root which doesn't exist isn't created, since it may be on a drive which isn't mounted.
+func (p LibraryPaths) prepare() error {
+	if fi, err := os.Stat(p.BooksRoot); err == nil && !fi.IsDir() {
+		return errors.Errorf("books root %s is not a directory", p.BooksRoot)
+	} else if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "check books root")
+	}
+	for _, dir := range p.managed() {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "create %s", dir)
+		}
+	}
+	return nil
+}
+
+// Paths returns the directories the library keeps its files in, so other programs can find them.
+func (lib *Library) Paths() LibraryPaths {
+	return lib.paths
+}
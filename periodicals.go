@@ -0,0 +1,252 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Issue is an issue of a periodical, imported as a book from a feed.
+type Issue struct {
+	BookID int64
+	// Feed identifies the periodical, usually by the URL of its feed.
+	Feed string
+	// Date is when the issue was published.
+	Date time.Time
+	// LastItem is the publication time of the newest feed item in the issue.
+	// Items up to this time are not included in later issues.
+	LastItem time.Time
+}
+
+// AddIssue records that a book is an issue of a periodical.
+func (lib *Library) AddIssue(issue Issue) error {
+	_, err := lib.Exec("insert or replace into periodical_issues (book_id, feed, issue_date, last_item) values(?, ?, ?, ?)",
+		issue.BookID, issue.Feed, issue.Date.UTC(), issue.LastItem.UTC())
+	if err != nil {
+		return errors.Wrap(err, "add issue")
+	}
+	return nil
+}
+
+// LatestIssue returns the most recent issue of a periodical.
+// found will be false if the library has no issues of it.
+func (lib *Library) LatestIssue(feed string) (issue Issue, found bool, err error) {
+	row, err := scanIssueRow(lib.QueryRow("select "+issueRowColumns+" from periodical_issues where feed=? order by last_item desc limit 1", feed))
+	if err == sql.ErrNoRows {
+		return issue, false, nil
+	} else if err != nil {
+		return issue, false, errors.Wrap(err, "get latest issue")
+	}
+	return row.issue(), true, nil
+}
+
+// RetentionPolicy is how long the issues of a periodical are kept.
+type RetentionPolicy struct {
+	// Keep is the number of the latest issues to keep. If 0, issues aren't expired by count.
+	Keep int
+	// MaxAge is how long to keep issues after they were published. If 0, issues aren't expired by age.
+	MaxAge time.Duration
+}
+
+// SetRetentionPolicy sets how long the issues of a periodical are kept, which is used by ExpireAllIssues and SyncToDevice.
+// A policy which keeps everything removes the periodical's policy.
+func (lib *Library) SetRetentionPolicy(feed string, policy RetentionPolicy) error {
+	var err error
+	if policy.Keep <= 0 && policy.MaxAge <= 0 {
+		_, err = lib.Exec("delete from periodical_retention where feed=?", feed)
+	} else {
+		_, err = lib.Exec("insert or replace into periodical_retention (feed, keep, max_age) values(?, ?, ?)", feed, policy.Keep, int64(policy.MaxAge/time.Second))
+	}
+	return errors.Wrap(err, "set retention policy")
+}
+
+// RetentionPolicies returns the retention policies of all periodicals which have one, by feed.
+func (lib *Library) RetentionPolicies() (map[string]RetentionPolicy, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	return retentionPolicies(tx)
+}
+
+func retentionPolicies(tx *sql.Tx) (map[string]RetentionPolicy, error) {
+	rows, err := tx.Query("select feed, keep, max_age from periodical_retention")
+	if err != nil {
+		return nil, errors.Wrap(err, "get retention policies")
+	}
+	defer rows.Close()
+	policies := make(map[string]RetentionPolicy)
+	for rows.Next() {
+		var feed string
+		var p RetentionPolicy
+		var maxAge int64
+		if err := rows.Scan(&feed, &p.Keep, &maxAge); err != nil {
+			return nil, errors.Wrap(err, "get retention policies")
+		}
+		p.MaxAge = time.Duration(maxAge) * time.Second
+		policies[feed] = p
+	}
+	return policies, errors.Wrap(rows.Err(), "get retention policies")
+}
+
+// expiredIssues returns the IDs of the issues of a periodical which policy no longer keeps, as of now.
+func expiredIssues(tx *sql.Tx, feed string, policy RetentionPolicy, now time.Time) ([]int64, error) {
+	ids, err := queryInt64s(tx, "select book_id from periodical_issues where feed=? order by issue_date desc, book_id desc", feed)
+	if err != nil {
+		return nil, errors.Wrap(err, "get issues")
+	}
+	var expired []int64
+	if policy.Keep > 0 && len(ids) > policy.Keep {
+		expired = append(expired, ids[policy.Keep:]...)
+		ids = ids[:policy.Keep]
+	}
+	if policy.MaxAge > 0 {
+		old, err := queryInt64s(tx, "select book_id from periodical_issues where feed=? and issue_date < ?", feed, now.Add(-policy.MaxAge).UTC())
+		if err != nil {
+			return nil, errors.Wrap(err, "get old issues")
+		}
+		isOld := make(map[int64]bool, len(old))
+		for _, id := range old {
+			isOld[id] = true
+		}
+		for _, id := range ids {
+			if isOld[id] {
+				expired = append(expired, id)
+			}
+		}
+	}
+	return expired, nil
+}
+
+// ExpiredIssues returns the IDs of the issues of all periodicals which their retention policies no longer keep,
+// but which haven't been removed by ExpireAllIssues yet.
+func (lib *Library) ExpiredIssues() ([]int64, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	policies, err := retentionPolicies(tx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var expired []int64
+	for feed, policy := range policies {
+		ids, err := expiredIssues(tx, feed, policy, now)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, ids...)
+	}
+	return expired, nil
+}
+
+// ExpireIssues removes old issues of a periodical from the library, and moves their files to the trash.
+// At most keep issues are kept, and issues published more than maxAge ago are removed.
+// If keep or maxAge is 0, that limit isn't applied.
+// The IDs of the removed books are returned.
+func (lib *Library) ExpireIssues(feed string, keep int, maxAge time.Duration) ([]int64, error) {
+	return lib.expireIssues(map[string]RetentionPolicy{feed: {Keep: keep, MaxAge: maxAge}})
+}
+
+// ExpireAllIssues removes the issues of every periodical which their retention policies, set with SetRetentionPolicy,
+// no longer keep, as ExpireIssues does. The IDs of the removed books are returned.
+func (lib *Library) ExpireAllIssues() ([]int64, error) {
+	policies, err := lib.RetentionPolicies()
+	if err != nil {
+		return nil, err
+	}
+	return lib.expireIssues(policies)
+}
+
+// expireIssues removes the issues of the periodicals in policies which their policies no longer keep.
+func (lib *Library) expireIssues(policies map[string]RetentionPolicy) ([]int64, error) {
+	unlock, err := lib.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	now := time.Now()
+	var expired []int64
+	for feed, policy := range policies {
+		ids, err := expiredIssues(tx, feed, policy, now)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if len(ids) > 0 {
+			log.Printf("Expiring %d issues of %s", len(ids), feed)
+		}
+		expired = append(expired, ids...)
+	}
+	if len(expired) == 0 {
+		tx.Rollback()
+		return nil, nil
+	}
+
+	var files []BookFile
+	fileMap, err := getFilesByBookIds(tx, expired)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "get files of expired issues")
+	}
+	for _, id := range expired {
+		files = append(files, fileMap[id]...)
+	}
+	if _, err := deleteBooks(tx, expired); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "delete expired issues")
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(expired...)
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	lib.trashUnusedFiles(files)
+	return expired, nil
+}
+
+// trashUnusedFiles moves files which were removed from the library to the trash, named as they were in the library,
+// unless another file in a books root has the same hash. Files cataloged in place are left where they are.
+// Errors are logged, since the files' records have already been removed.
+func (lib *Library) trashUnusedFiles(files []BookFile) {
+	for _, bf := range files {
+		if bf.Path != "" {
+			continue
+		}
+		var n int
+		if err := lib.QueryRow("select count(*) from files where hash=? and path=''", bf.Hash).Scan(&n); err != nil {
+			log.Printf("Cannot check for other files with hash %s: %s", bf.Hash, err)
+			continue
+		}
+		if n > 0 {
+			continue
+		}
+		fn, err := lib.FilePath(bf)
+		if err != nil {
+			log.Printf("Cannot move file %d to trash: %s", bf.ID, err)
+			continue
+		}
+		name := filepath.Base(bf.CurrentFilename)
+		if bf.CurrentFilename == "" {
+			name = bf.Hash
+		}
+		if err := trashFileAs(fn, lib.paths.Trash, name); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			log.Printf("Cannot move file %d to trash: %s", bf.ID, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package periodicals
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tspivey/books"
+)
+
+// escapeXML escapes text for inclusion in XHTML or XML.
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// writeEpub renders feed items into an EPUB 3 file, with a chapter for each item.
+func writeEpub(filename, title, author string, date time.Time, items []item) error {
+	fp, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(fp)
+
+	// The mimetype file must come first, and be stored uncompressed.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err == nil {
+		_, err = w.Write([]byte(epubType))
+	}
+	files := map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>
+`,
+	}
+	var manifest, spine, nav strings.Builder
+	for i, it := range items {
+		name := fmt.Sprintf("item%d.xhtml", i+1)
+		itemTitle := it.Title
+		if itemTitle == "" {
+			itemTitle = fmt.Sprintf("Item %d", i+1)
+		}
+		var body strings.Builder
+		for _, p := range books.HTMLParagraphs(it.Content) {
+			body.WriteString("<p>" + escapeXML(p) + "</p>\n")
+		}
+		if it.Link != "" {
+			body.WriteString(`<p><a href="` + escapeXML(it.Link) + `">` + escapeXML(it.Link) + "</a></p>\n")
+		}
+		files["OEBPS/"+name] = xhtmlPage(itemTitle, "<h1>"+escapeXML(itemTitle)+"</h1>\n"+body.String())
+		fmt.Fprintf(&manifest, `<item id="item%d" href="%s" media-type="application/xhtml+xml"/>`+"\n", i+1, name)
+		fmt.Fprintf(&spine, `<itemref idref="item%d"/>`+"\n", i+1)
+		fmt.Fprintf(&nav, `<li><a href="%s">%s</a></li>`+"\n", name, escapeXML(itemTitle))
+	}
+	files["OEBPS/nav.xhtml"] = xhtmlPage(title, `<nav epub:type="toc" id="toc"><h1>`+escapeXML(title)+"</h1>\n<ol>\n"+nav.String()+"</ol></nav>\n")
+	identifier := fmt.Sprintf("urn:sha256:%x", sha256.Sum256([]byte(title+date.String())))
+	files["OEBPS/content.opf"] = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">` + identifier + `</dc:identifier>
+<dc:title>` + escapeXML(title) + `</dc:title>
+<dc:creator>` + escapeXML(author) + `</dc:creator>
+<dc:publisher>` + escapeXML(author) + `</dc:publisher>
+<dc:date>` + date.UTC().Format("2006-01-02") + `</dc:date>
+<dc:language>en</dc:language>
+<meta property="dcterms:modified">` + date.UTC().Format("2006-01-02T15:04:05Z") + `</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+` + manifest.String() + `</manifest>
+<spine>
+<itemref idref="nav"/>
+` + spine.String() + `</spine>
+</package>
+`
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml"} {
+		if err == nil {
+			err = writeZipFile(zw, name, files[name])
+		}
+	}
+	for i := range items {
+		if err == nil {
+			name := fmt.Sprintf("OEBPS/item%d.xhtml", i+1)
+			err = writeZipFile(zw, name, files[name])
+		}
+	}
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func writeZipFile(zw *zip.Writer, name, contents string) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(contents))
+	return err
+}
+
+// xhtmlPage wraps body in an XHTML document.
+func xhtmlPage(title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>` + escapeXML(title) + `</title></head>
+<body>
+` + body + `</body>
+</html>
+`
+}
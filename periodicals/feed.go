@@ -0,0 +1,167 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package periodicals
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// item is an entry in an RSS or Atom feed.
+type item struct {
+	Title     string
+	Link      string
+	Content   string
+	Published time.Time
+	// EpubURL is the URL of an EPUB linked from the item, as an enclosure or its link.
+	EpubURL string
+}
+
+// feed is an RSS or Atom feed.
+type feed struct {
+	Title string
+	Items []item
+}
+
+type rssDocument struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			Encoded     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+			PubDate     string `xml:"pubDate"`
+			Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+			Enclosures  []struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomDocument struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+		Content   string `xml:"content"`
+		Summary   string `xml:"summary"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// epubType is the media type of EPUB files.
+const epubType = "application/epub+zip"
+
+// parseFeed parses an RSS 2.0 or Atom feed.
+func parseFeed(r io.Reader) (feed, error) {
+	var f feed
+	dec := xml.NewDecoder(r)
+	// Feeds often declare encodings other than UTF-8, and are usually close enough to it to read anyway.
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	var root xml.StartElement
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return f, errors.Wrap(err, "find root element")
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			root = se
+			break
+		}
+	}
+
+	switch root.Name.Local {
+	case "rss":
+		var doc rssDocument
+		if err := dec.DecodeElement(&doc, &root); err != nil {
+			return f, errors.Wrap(err, "parse RSS")
+		}
+		f.Title = strings.TrimSpace(doc.Channel.Title)
+		for _, ri := range doc.Channel.Items {
+			it := item{Title: strings.TrimSpace(ri.Title), Link: strings.TrimSpace(ri.Link), Content: ri.Encoded}
+			if it.Content == "" {
+				it.Content = ri.Description
+			}
+			date := ri.PubDate
+			if date == "" {
+				date = ri.Date
+			}
+			it.Published = parseDate(date)
+			for _, enc := range ri.Enclosures {
+				if enc.Type == epubType || strings.HasSuffix(strings.ToLower(enc.URL), ".epub") {
+					it.EpubURL = enc.URL
+					break
+				}
+			}
+			if it.EpubURL == "" && strings.HasSuffix(strings.ToLower(it.Link), ".epub") {
+				it.EpubURL = it.Link
+			}
+			f.Items = append(f.Items, it)
+		}
+	case "feed":
+		var doc atomDocument
+		if err := dec.DecodeElement(&doc, &root); err != nil {
+			return f, errors.Wrap(err, "parse Atom")
+		}
+		f.Title = strings.TrimSpace(doc.Title)
+		for _, e := range doc.Entries {
+			it := item{Title: strings.TrimSpace(e.Title), Content: e.Content}
+			if it.Content == "" {
+				it.Content = e.Summary
+			}
+			date := e.Published
+			if date == "" {
+				date = e.Updated
+			}
+			it.Published = parseDate(date)
+			for _, l := range e.Links {
+				if l.Type == epubType || (l.Rel == "enclosure" && strings.HasSuffix(strings.ToLower(l.Href), ".epub")) {
+					it.EpubURL = l.Href
+				} else if it.Link == "" && (l.Rel == "" || l.Rel == "alternate") {
+					it.Link = l.Href
+				}
+			}
+			f.Items = append(f.Items, it)
+		}
+	default:
+		return f, errors.Errorf("unknown feed format %s", root.Name.Local)
+	}
+	return f, nil
+}
+
+// dateFormats are the formats dates in feeds are commonly written in.
+var dateFormats = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseDate parses a date from a feed, returning the zero time if it's in an unknown format.
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
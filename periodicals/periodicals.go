@@ -0,0 +1,230 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+// Package periodicals imports issues of periodicals from RSS and Atom feeds.
+package periodicals
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tspivey/books"
+)
+
+// Feed is a periodical to import from a feed.
+type Feed struct {
+	// Name is used as the author and series of each issue. If empty, the feed's title is used.
+	Name string
+	URL  string
+	// LinkedEpubs imports EPUBs linked from feed items as issues, instead of rendering the items into an issue.
+	LinkedEpubs bool
+	// Keep is the number of issues to keep. If 0, issues aren't expired by count.
+	Keep int
+	// MaxAge is how long to keep issues. If 0, issues aren't expired by age.
+	MaxAge time.Duration
+}
+
+// Updater fetches feeds and imports new issues into a library.
+type Updater struct {
+	Lib      *books.Library
+	Template *template.Template
+	// Options are used when importing issues. Issues are always moved into the library.
+	Options books.ImportOptions
+	// Client fetches feeds and linked EPUBs. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Update fetches a feed, imports its items published since the last issue, and expires old issues.
+// Items are rendered into a single EPUB issue, or, if feed.LinkedEpubs is set, each linked EPUB is imported as an issue.
+// Items without a publication date are only included in the first issue.
+// The number of issues imported is returned.
+func (u *Updater) Update(feed Feed) (int, error) {
+	f, err := u.fetchFeed(feed.URL)
+	if err != nil {
+		return 0, errors.Wrap(err, "fetch feed")
+	}
+	name := feed.Name
+	if name == "" {
+		name = f.Title
+	}
+	if name == "" {
+		name = feed.URL
+	}
+
+	latest, found, err := u.Lib.LatestIssue(feed.URL)
+	if err != nil {
+		return 0, err
+	}
+	var items []item
+	for _, it := range f.Items {
+		if !found || it.Published.After(latest.LastItem) {
+			items = append(items, it)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Published.Before(items[j].Published) })
+
+	tmpDir, err := ioutil.TempDir("", "books")
+	if err != nil {
+		return 0, errors.Wrap(err, "create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imported := 0
+	if feed.LinkedEpubs {
+		for _, it := range items {
+			if it.EpubURL == "" {
+				continue
+			}
+			if err := u.importLinkedEpub(feed, name, it, tmpDir); err != nil {
+				log.Printf("Cannot import %s from %s: %s", it.EpubURL, feed.URL, err)
+				continue
+			}
+			imported++
+		}
+	} else if len(items) > 0 {
+		if err := u.importRenderedIssue(feed, name, items, tmpDir); err != nil {
+			return 0, err
+		}
+		imported++
+	}
+
+	// The policy is recorded so the library can expire issues, and leave them off devices, without the feed's configuration.
+	if err := u.Lib.SetRetentionPolicy(feed.URL, books.RetentionPolicy{Keep: feed.Keep, MaxAge: feed.MaxAge}); err != nil {
+		return imported, err
+	}
+	if _, err := u.Lib.ExpireIssues(feed.URL, feed.Keep, feed.MaxAge); err != nil {
+		return imported, errors.Wrap(err, "expire issues")
+	}
+	return imported, nil
+}
+
+// importRenderedIssue renders items into an EPUB, and imports it as an issue dated now.
+func (u *Updater) importRenderedIssue(feed Feed, name string, items []item, tmpDir string) error {
+	now := time.Now()
+	title := name + " - " + now.Format("2006-01-02 15:04")
+	filename := filepath.Join(tmpDir, "issue.epub")
+	if err := writeEpub(filename, title, name, now, items); err != nil {
+		return errors.Wrap(err, "render issue")
+	}
+	return u.importIssue(feed, name, title, filename, now, lastPublished(items, now))
+}
+
+// importLinkedEpub downloads an EPUB linked from a feed item, and imports it as an issue dated when the item was published.
+func (u *Updater) importLinkedEpub(feed Feed, name string, it item, tmpDir string) error {
+	filename := filepath.Join(tmpDir, "linked.epub")
+	if err := u.download(it.EpubURL, filename); err != nil {
+		return err
+	}
+	date := it.Published
+	if date.IsZero() {
+		date = time.Now()
+	}
+	title := it.Title
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(it.EpubURL), ".epub")
+	}
+	return u.importIssue(feed, name, title, filename, date, date)
+}
+
+// importIssue imports a file as an issue of a periodical, and records it as an issue.
+func (u *Updater) importIssue(feed Feed, name, title, filename string, date, lastItem time.Time) error {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	bf := books.BookFile{
+		OriginalFilename: filename,
+		Extension:        "epub",
+		FileSize:         fi.Size(),
+		FileMtime:        date,
+		Source:           "feed:" + feed.URL,
+	}
+	if err := bf.CalculateHash(); err != nil {
+		return err
+	}
+	book := books.Book{Authors: []string{name}, Title: title, Series: name, Publisher: name, Files: []books.BookFile{bf}}
+	opts := u.Options
+	opts.Move = true
+	if err := u.Lib.ImportBookWithOptions(book, u.Template, opts); err != nil {
+		return errors.Wrap(err, "import issue")
+	}
+	bookID, found, err := u.Lib.GetBookIDByTitleAndAuthors(book.Title, book.Authors)
+	if err != nil {
+		return errors.Wrap(err, "find imported issue")
+	}
+	if !found {
+		return errors.Errorf("imported issue %s not found", title)
+	}
+	return u.Lib.AddIssue(books.Issue{BookID: bookID, Feed: feed.URL, Date: date, LastItem: lastItem})
+}
+
+// lastPublished returns the publication time of the newest item, or def if no items have one.
+func lastPublished(items []item, def time.Time) time.Time {
+	var last time.Time
+	for _, it := range items {
+		if it.Published.After(last) {
+			last = it.Published
+		}
+	}
+	if last.IsZero() {
+		return def
+	}
+	return last
+}
+
+func (u *Updater) client() *http.Client {
+	if u.Client != nil {
+		return u.Client
+	}
+	return http.DefaultClient
+}
+
+func (u *Updater) get(url string) (*http.Response, error) {
+	resp, err := u.client().Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+func (u *Updater) fetchFeed(url string) (feed, error) {
+	resp, err := u.get(url)
+	if err != nil {
+		return feed{}, err
+	}
+	defer resp.Body.Close()
+	return parseFeed(resp.Body)
+}
+
+func (u *Updater) download(url, filename string) error {
+	resp, err := u.get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	fp, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fp, resp.Body); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
@@ -0,0 +1,123 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// GlobalPreferences is the user name under which preferences shared by all users are stored.
+const GlobalPreferences = ""
+
+// SetPreference saves a preference for a user, replacing any previous value.
+// Use GlobalPreferences as the user for preferences which aren't specific to a user.
+func (lib *Library) SetPreference(user, key, value string) error {
+	_, err := lib.Exec("insert or replace into preferences (username, key, value, updated_on) values(?, ?, ?, datetime())", user, key, value)
+	if err != nil {
+		return errors.Wrapf(err, "set preference %s", key)
+	}
+	return nil
+}
+
+// GetPreference retrieves a user's preference.
+// found will be false if the preference isn't set.
+func (lib *Library) GetPreference(user, key string) (value string, found bool, err error) {
+	err = lib.QueryRow("select value from preferences where username=? and key=?", user, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, errors.Wrapf(err, "get preference %s", key)
+	}
+	return value, true, nil
+}
+
+// DeletePreference removes a user's preference, if it's set.
+func (lib *Library) DeletePreference(user, key string) error {
+	if _, err := lib.Exec("delete from preferences where username=? and key=?", user, key); err != nil {
+		return errors.Wrapf(err, "delete preference %s", key)
+	}
+	return nil
+}
+
+// GetPreferences retrieves all of a user's preferences, by key.
+func (lib *Library) GetPreferences(user string) (map[string]string, error) {
+	rows, err := lib.Query("select key, value from preferences where username=?", user)
+	if err != nil {
+		return nil, errors.Wrap(err, "get preferences")
+	}
+	defer rows.Close()
+	prefs := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, errors.Wrap(err, "scan preference")
+		}
+		prefs[key] = value
+	}
+	return prefs, rows.Err()
+}
+
+// GetIntPreference retrieves a preference saved by SetIntPreference, or def if it isn't set.
+func (lib *Library) GetIntPreference(user, key string, def int) (int, error) {
+	value, found, err := lib.GetPreference(user, key)
+	if err != nil || !found {
+		return def, err
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return def, errors.Wrapf(err, "parse preference %s", key)
+	}
+	return n, nil
+}
+
+// SetIntPreference saves an integer preference.
+func (lib *Library) SetIntPreference(user, key string, value int) error {
+	return lib.SetPreference(user, key, strconv.Itoa(value))
+}
+
+// GetBoolPreference retrieves a preference saved by SetBoolPreference, or def if it isn't set.
+func (lib *Library) GetBoolPreference(user, key string, def bool) (bool, error) {
+	value, found, err := lib.GetPreference(user, key)
+	if err != nil || !found {
+		return def, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return def, errors.Wrapf(err, "parse preference %s", key)
+	}
+	return b, nil
+}
+
+// SetBoolPreference saves a boolean preference.
+func (lib *Library) SetBoolPreference(user, key string, value bool) error {
+	return lib.SetPreference(user, key, strconv.FormatBool(value))
+}
+
+// GetJSONPreference decodes a preference saved by SetJSONPreference into v.
+// found will be false, and v left unchanged, if the preference isn't set.
+func (lib *Library) GetJSONPreference(user, key string, v interface{}) (found bool, err error) {
+	value, found, err := lib.GetPreference(user, key)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := json.Unmarshal([]byte(value), v); err != nil {
+		return true, errors.Wrapf(err, "decode preference %s", key)
+	}
+	return true, nil
+}
+
+// SetJSONPreference saves v, encoded as JSON, as a preference.
+// It's useful for structured view state, such as a set of search filters.
+func (lib *Library) SetJSONPreference(user, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "encode preference %s", key)
+	}
+	return lib.SetPreference(user, key, string(b))
+}
@@ -0,0 +1,115 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PDFRenderer renders pages of PDF files as images.
+type PDFRenderer interface {
+	// RenderPage renders a page of pdfFile, counting from 1, at dpi dots per inch, and writes it to outFile as a PNG image.
+	RenderPage(pdfFile string, page, dpi int, outFile string) error
+}
+
+// PdftoppmRenderer renders pages of PDF files with pdftoppm, from Poppler.
+type PdftoppmRenderer struct {
+	// Command is the pdftoppm command, "pdftoppm" if empty.
+	Command string
+}
+
+// RenderPage renders a page of pdfFile with pdftoppm.
+func (r PdftoppmRenderer) RenderPage(pdfFile string, page, dpi int, outFile string) error {
+	command := r.Command
+	if command == "" {
+		command = "pdftoppm"
+	}
+	p := strconv.Itoa(page)
+	// pdftoppm adds the .png extension to the name it's given.
+	cmd := exec.Command(command, "-png", "-singlefile", "-f", p, "-l", p, "-r", strconv.Itoa(dpi), pdfFile, strings.TrimSuffix(outFile, ".png"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "pdftoppm: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Limits of the resolution of PDF previews.
+const (
+	DefaultPreviewDPI = 96
+	MaxPreviewDPI     = 300
+)
+
+// ErrNotPDF is returned by RenderPDFPreview when the file isn't a PDF.
+var ErrNotPDF = errors.New("file is not a PDF")
+
+// RenderPDFPreview renders a page of a PDF file, counting from 1, as a PNG image, and returns the image's filename.
+// If dpi is 0, DefaultPreviewDPI is used, and it can't be more than MaxPreviewDPI.
+// Images are cached as derivations of the file, by page and dpi, in the previews directory next to the library.
+func (lib *Library) RenderPDFPreview(fileID int64, page, dpi int) (string, error) {
+	if page < 1 {
+		return "", errors.Errorf("invalid page %d", page)
+	}
+	if dpi == 0 {
+		dpi = DefaultPreviewDPI
+	}
+	if dpi < 1 || dpi > MaxPreviewDPI {
+		return "", errors.Errorf("dpi must be between 1 and %d", MaxPreviewDPI)
+	}
+	files, err := lib.GetFilesByID([]int64{fileID})
+	if err != nil {
+		return "", errors.Wrap(err, "get file")
+	}
+	if len(files) == 0 {
+		return "", errors.Errorf("file %d not found", fileID)
+	}
+	file := files[0]
+	if strings.ToLower(file.Extension) != "pdf" {
+		return "", ErrNotPDF
+	}
+
+	dir := lib.paths.Previews
+	fn := path.Join(dir, fmt.Sprintf("%s-%d-%d.png", file.Hash, page, dpi))
+	params := fmt.Sprintf("%d-%d", page, dpi)
+	if cached, found, err := lib.cachedDerivationFile(file.Hash, DerivationPDFPreview, params, fn); err != nil || found {
+		return cached, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "create previews directory")
+	}
+	// Render to a temporary file, so a partly rendered image is never served from the cache.
+	tmp, err := ioutil.TempFile(dir, "render-*.png")
+	if err != nil {
+		return "", errors.Wrap(err, "create temporary file")
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	renderer := lib.pdfRenderer
+	if renderer == nil {
+		renderer = PdftoppmRenderer{}
+	}
+	src, release, err := lib.CachedFilePath(file)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	if err := renderer.RenderPage(src, page, dpi, tmp.Name()); err != nil {
+		return "", errors.Wrapf(err, "render page %d of file %d", page, fileID)
+	}
+	if err := os.Rename(tmp.Name(), fn); err != nil {
+		return "", errors.Wrap(err, "cache preview")
+	}
+	if err := lib.SaveDerivation(Derivation{Hash: file.Hash, Kind: DerivationPDFPreview, Params: params, Filename: fn}); err != nil {
+		return "", err
+	}
+	return fn, nil
+}
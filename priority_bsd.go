@@ -0,0 +1,21 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package books
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// LowerPriority gives the process the lowest CPU priority, as nice 19 does,
+// so a long import or scan leaves the machine responsive for other programs.
+// The I/O priority can't be changed on this platform.
+func LowerPriority() error {
+	return errors.Wrap(syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19), "set CPU priority")
+}
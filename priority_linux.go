@@ -0,0 +1,48 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io/ioutil"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// I/O priority classes and targets from linux/ioprio.h.
+const (
+	ioprioClassBestEffort = 2
+	ioprioClassShift      = 13
+	ioprioWhoProcess      = 1
+	// ioprioLowest is the lowest priority in the best effort class.
+	ioprioLowest = 7
+)
+
+// LowerPriority gives the process the lowest CPU priority, as nice 19 does,
+// and the lowest best effort I/O priority, as ionice -c 2 -n 7 does,
+// so a long import or scan leaves the machine responsive for other programs.
+func LowerPriority() error {
+	// Linux sets priorities per thread, so each of the process's threads is changed.
+	// Threads started later inherit the priority of the thread which starts them.
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return errors.Wrap(err, "list threads")
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil {
+			return errors.Wrap(err, "set CPU priority")
+		}
+		prio := ioprioClassBestEffort<<ioprioClassShift | ioprioLowest
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return errors.Wrap(errno, "set I/O priority")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package books
+
+import "github.com/pkg/errors"
+
+// LowerPriority lowers the priority of the process.
+// Priorities can't be changed on this platform, so an error is always returned.
+func LowerPriority() error {
+	return errors.New("lowering the process priority isn't supported on this platform")
+}
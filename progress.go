@@ -0,0 +1,84 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"time"
+)
+
+// Progress describes how far a long-running operation, such as an import or reindex, has gotten.
+type Progress struct {
+	// Operation is a short description of the operation, such as "rescan".
+	Operation string
+	// Done is the number of items processed so far.
+	Done int
+	// Total is the total number of items to process, or 0 if unknown.
+	Total int
+	// Current describes the item currently being processed, such as a filename.
+	Current string
+	// Started is when the operation started.
+	Started time.Time
+}
+
+// Fraction returns the fraction of items processed, from 0 to 1, or 0 if the total is unknown.
+func (p Progress) Fraction() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Done) / float64(p.Total)
+}
+
+// ETA estimates the time remaining, based on the average time taken per item so far.
+// It returns 0 if the total is unknown or no items have been processed.
+func (p Progress) ETA() time.Duration {
+	if p.Total <= 0 || p.Done <= 0 || p.Done >= p.Total {
+		return 0
+	}
+	perItem := time.Since(p.Started) / time.Duration(p.Done)
+	return perItem * time.Duration(p.Total-p.Done)
+}
+
+// ProgressFunc is called with progress updates during long-running operations.
+// It is called synchronously, so it should return quickly.
+type ProgressFunc func(p Progress)
+
+// ProgressChan returns a ProgressFunc which sends updates to ch.
+// Updates are dropped rather than blocking the operation if ch isn't ready to receive them.
+func ProgressChan(ch chan<- Progress) ProgressFunc {
+	return func(p Progress) {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// progressTracker reports progress for an operation to a ProgressFunc, which may be nil.
+type progressTracker struct {
+	fn       ProgressFunc
+	progress Progress
+}
+
+func newProgressTracker(operation string, total int, fn ProgressFunc) *progressTracker {
+	return &progressTracker{fn, Progress{Operation: operation, Total: total, Started: time.Now()}}
+}
+
+// start reports that work has begun on the item described by current.
+func (t *progressTracker) start(current string) {
+	t.progress.Current = current
+	t.report()
+}
+
+// done reports that another item has been processed.
+func (t *progressTracker) done() {
+	t.progress.Done++
+	t.report()
+}
+
+func (t *progressTracker) report() {
+	if t.fn != nil {
+		t.fn(t.progress)
+	}
+}
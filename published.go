@@ -0,0 +1,110 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dateRegexp matches a date as stored in Book.Published, with an optional time after it, such as the one in EPUB dates.
+var dateRegexp = regexp.MustCompile(`^(\d{4})(?:-(\d{2})(?:-(\d{2})(?:[T ].*)?)?)?$`)
+
+// NormalizeDate returns s, a date such as "1999", "1999-05", "1999-05-17" or "1999-05-17T00:00:00Z",
+// as it's stored in Book.Published, with any time after it removed.
+// Months and days given as 00, which usually means they aren't known, are left out.
+// An empty string is an unknown date, as is the year 101, which Calibre uses for books whose date isn't known.
+func NormalizeDate(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	m := dateRegexp.FindStringSubmatch(s)
+	if m != nil && m[1] == "0101" {
+		return "", nil
+	}
+	if m == nil || m[1] == "0000" {
+		return "", errors.Errorf("invalid date %q; must be a year, such as 1999, a year and month, such as 1999-05, or a date, such as 1999-05-17", s)
+	}
+	if m[2] == "" || m[2] == "00" {
+		return m[1], nil
+	}
+	if _, err := time.Parse("2006-01", m[1]+"-"+m[2]); err != nil {
+		return "", errors.Errorf("invalid month in date %q", s)
+	}
+	if m[3] == "" || m[3] == "00" {
+		return m[1] + "-" + m[2], nil
+	}
+	date := m[1] + "-" + m[2] + "-" + m[3]
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return "", errors.Errorf("invalid day in date %q", s)
+	}
+	return date, nil
+}
+
+// parsePublished returns s, a date from a filename or a file's contents, normalized with NormalizeDate,
+// or an empty string if it isn't a date.
+func parsePublished(s string) string {
+	published, err := NormalizeDate(s)
+	if err != nil {
+		return ""
+	}
+	return published
+}
+
+// copyrightYearRegexp matches the year in a copyright notice, such as "Copyright © 1999" or "(c) 1999, 2004".
+var copyrightYearRegexp = regexp.MustCompile(`(?i)(?:\bcopyright\b|©|\(c\))(?:\s*(?:©|\(c\)))?\s*((?:1[5-9]|20)\d\d)\b`)
+
+// copyrightYear returns the year in the first copyright notice in paragraphs, such as from a book's copyright page,
+// or an empty string if there isn't one. The first year in a notice is usually when the book was first published.
+func copyrightYear(paragraphs []string) string {
+	for _, p := range paragraphs {
+		if m := copyrightYearRegexp.FindStringSubmatch(p); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// DecadeCount is the number of books published in a decade.
+type DecadeCount struct {
+	// Decade is the first year of the decade, such as 1990.
+	Decade int `json:"decade"`
+	Books  int `json:"books"`
+}
+
+// PublicationDecades counts the books in the library by the decade they were published in, earliest first,
+// and returns the number of books whose publication dates aren't known.
+// Decades without any books are left out.
+func (lib *Library) PublicationDecades() (decades []DecadeCount, undated int, err error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query("select cast(substr(published, 1, 4) as integer) / 10 * 10 as decade, count(*) from books where published != '' group by decade order by decade")
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "count books by decade")
+	}
+	defer rows.Close()
+	decades = []DecadeCount{}
+	for rows.Next() {
+		var d DecadeCount
+		if err := rows.Scan(&d.Decade, &d.Books); err != nil {
+			return nil, 0, errors.Wrap(err, "count books by decade")
+		}
+		decades = append(decades, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "count books by decade")
+	}
+	if err := tx.QueryRow("select count(*) from books where published = ''").Scan(&undated); err != nil {
+		return nil, 0, errors.Wrap(err, "count undated books")
+	}
+	return decades, undated, nil
+}
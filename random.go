@@ -0,0 +1,85 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"github.com/pkg/errors"
+)
+
+// FinishedPercentage is how much of a book a user must have read for it to count as finished.
+// It's less than 1, since readers often stop before the back matter.
+const FinishedPercentage = 0.95
+
+// RandomOptions controls which books RandomBooksWithOptions picks from.
+type RandomOptions struct {
+	// ExcludeReadBy is the name of a sync user whose finished books aren't picked, if it isn't empty.
+	ExcludeReadBy string
+	// ExcludeStarted also excludes the books ExcludeReadBy has started, but not finished.
+	ExcludeStarted bool
+}
+
+// RandomBooks returns up to n books picked at random from the books matching query, in random order.
+// query is a search, as described in Search. If it's empty, books are picked from the whole library.
+func (lib *Library) RandomBooks(query string, n int) ([]Book, error) {
+	return lib.RandomBooksWithOptions(query, n, RandomOptions{})
+}
+
+// RandomBooksWithOptions is like RandomBooks, but books a user has read can be excluded.
+func (lib *Library) RandomBooksWithOptions(query string, n int, opts RandomOptions) ([]Book, error) {
+	if n < 1 {
+		return nil, errors.New("number of books must be at least 1")
+	}
+	terms, filters, err := parseSearchFilters(query)
+	if err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	source, idColumn := "books", "id"
+	if terms != "" || len(filters) > 0 {
+		if terms, err = lib.expandQuery(terms); err != nil {
+			return nil, err
+		}
+		var search string
+		search, args = searchQuery(terms, filters)
+		source, idColumn = "("+search+")", "docid"
+	}
+	q := "select r." + idColumn + " from " + source + " r"
+	if opts.ExcludeReadBy != "" {
+		percentage := FinishedPercentage
+		if opts.ExcludeStarted {
+			percentage = 0
+		}
+		q += ` where not exists (select 1 from reading_progress p join files f on p.file_id = f.id
+where f.book_id = r.` + idColumn + ` and p.username = ? and p.percentage >= ?)`
+		args = append(args, opts.ExcludeReadBy, percentage)
+	}
+	q += " order by random() limit ?"
+	args = append(args, n)
+
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	ids, err := queryInt64s(tx, q, args...)
+	tx.Rollback()
+	if err != nil {
+		return nil, errors.Wrap(err, "pick random books")
+	}
+	books, err := lib.GetBooksByID(ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	bookMap := make(map[int64]Book, len(books))
+	for _, b := range books {
+		bookMap[b.ID] = b
+	}
+	result := make([]Book, 0, len(ids))
+	for _, id := range ids {
+		if b, ok := bookMap[id]; ok {
+			result = append(result, b)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,45 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// MaxRating is the highest rating a book can have.
+const MaxRating = 5
+
+// SetRating sets the rating of a book, from 1 to MaxRating.
+// A rating of 0 clears it.
+func (lib *Library) SetRating(bookID int64, rating int) error {
+	if rating < 0 || rating > MaxRating {
+		return errors.Errorf("rating must be between 0 and %d", MaxRating)
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	res, err := tx.Exec("update books set updated_on=datetime(), rating=? where id=?", rating, bookID)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "set rating")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return ErrBookNotFound
+	}
+	if err := recordActivity(tx, ActivityRating, bookID, 0, "", strconv.Itoa(rating)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUserExists is returned when adding a user whose name is already taken.
+var ErrUserExists = errors.New("user already exists")
+
+// ReadingProgress is how far a user has read in a document.
+type ReadingProgress struct {
+	// User is the name of the reader.
+	User string
+	// Document identifies what is being read, usually PartialMD5 of the file.
+	Document string
+	// Progress is an opaque position within the document, such as a page number or an XPointer.
+	Progress string
+	// Percentage is how much of the document has been read, from 0 to 1.
+	Percentage float64
+	// Device and DeviceID identify the device which last reported progress.
+	Device   string
+	DeviceID string
+	// FileID is the library file with a PartialMD5 matching Document, or 0 if there isn't one.
+	FileID int64
+	// UpdatedOn is when the progress was last reported.
+	UpdatedOn time.Time
+}
+
+// PartialMD5 calculates the partial MD5 hash KOReader uses to identify documents.
+// Rather than the entire file, it hashes 1024 bytes from each of a series of exponentially spaced offsets.
+func PartialMD5(filename string) (string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	hasher := md5.New()
+	buf := make([]byte, 1024)
+	for i := -1; i <= 10; i++ {
+		var offset int64
+		if i >= 0 {
+			offset = 1024 << uint(2*i)
+		}
+		n, err := fp.ReadAt(buf, offset)
+		if n == 0 {
+			break
+		}
+		hasher.Write(buf[:n])
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// SetReadingProgress records a user's progress in a document, replacing any previous progress.
+// If a library file has a partial MD5 matching the document, the progress is linked to it.
+func (lib *Library) SetReadingProgress(p ReadingProgress) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	var fileID, bookID sql.NullInt64
+	err = tx.QueryRow("select id, book_id from files where partial_md5=? order by id limit 1", p.Document).Scan(&fileID, &bookID)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return errors.Wrap(err, "find file for document")
+	}
+	var previous float64
+	err = tx.QueryRow("select percentage from reading_progress where username=? and document=?", p.User, p.Document).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return errors.Wrap(err, "get previous reading progress")
+	}
+	_, err = tx.Exec(`insert or replace into reading_progress (username, document, file_id, progress, percentage, device, device_id, updated_on)
+	values (?, ?, ?, ?, ?, ?, ?, datetime())`, p.User, p.Document, fileID, p.Progress, p.Percentage, p.Device, p.DeviceID)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "set reading progress")
+	}
+	if bookID.Valid && p.Percentage >= FinishedPercentage && previous < FinishedPercentage {
+		if err := recordActivity(tx, ActivityFinish, bookID.Int64, fileID.Int64, p.User, ""); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// GetReadingProgress retrieves a user's progress in a document.
+// found will be false if the user hasn't reported any progress for the document.
+func (lib *Library) GetReadingProgress(user, document string) (p ReadingProgress, found bool, err error) {
+	row, err := scanReadingProgressRow(lib.QueryRow("select "+readingProgressRowColumns+" from reading_progress where username=? and document=?", user, document))
+	if err == sql.ErrNoRows {
+		return p, false, nil
+	} else if err != nil {
+		return p, false, errors.Wrap(err, "get reading progress")
+	}
+	return row.progress(), true, nil
+}
+
+// GetFileReadingProgress retrieves a user's progress in a library file.
+// found will be false if the user hasn't reported any progress for the file.
+func (lib *Library) GetFileReadingProgress(user string, fileID int64) (p ReadingProgress, found bool, err error) {
+	var document string
+	err = lib.QueryRow("select document from reading_progress where username=? and file_id=? order by updated_on desc limit 1", user, fileID).Scan(&document)
+	if err == sql.ErrNoRows {
+		return p, false, nil
+	} else if err != nil {
+		return p, false, errors.Wrap(err, "get reading progress")
+	}
+	return lib.GetReadingProgress(user, document)
+}
+
+// AddSyncUser adds a user who can report reading progress, authenticated by key.
+// For KOReader, key is the hex-encoded MD5 hash of the user's password.
+// ErrUserExists is returned if the name is already taken.
+func (lib *Library) AddSyncUser(username, key string) error {
+	var exists int
+	if err := lib.QueryRow("select count(*) from sync_users where username=?", username).Scan(&exists); err != nil {
+		return errors.Wrap(err, "check for existing user")
+	}
+	if exists > 0 {
+		return ErrUserExists
+	}
+	if _, err := lib.Exec("insert into sync_users (username, key) values(?, ?)", username, key); err != nil {
+		return errors.Wrap(err, "add sync user")
+	}
+	return nil
+}
+
+// CheckSyncUser returns true if key is correct for the given user.
+func (lib *Library) CheckSyncUser(username, key string) (bool, error) {
+	var storedKey string
+	err := lib.QueryRow("select key from sync_users where username=?", username).Scan(&storedKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "get sync user")
+	}
+	return storedKey == key, nil
+}
+
+// IndexPartialMD5s calculates the KOReader partial MD5 of files imported before it was recorded.
+// Reading progress already reported for those files is linked to them.
+// If progress isn't nil, it will be called as each file is hashed.
+func (lib *Library) IndexPartialMD5s(progress ProgressFunc) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	ids, err := queryInt64s(tx, "select id from files where partial_md5 is null and missing=0 order by id")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get file IDs")
+	}
+	files, err := getFilesByID(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get files")
+	}
+	tracker := newProgressTracker("partial MD5", len(files), progress)
+	for _, f := range files {
+		tracker.start(f.CurrentFilename)
+		fn, err := lib.FilePath(f)
+		var hash string
+		if err == nil {
+			hash, err = PartialMD5(fn)
+		}
+		if err != nil {
+			log.Printf("Cannot calculate partial MD5 of file %d: %s", f.ID, err)
+			tracker.done()
+			continue
+		}
+		if _, err := tx.Exec("update files set partial_md5=? where id=?", hash, f.ID); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "update partial MD5")
+		}
+		if _, err := tx.Exec("update reading_progress set file_id=? where document=? and file_id is null", f.ID, hash); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "link reading progress")
+		}
+		tracker.done()
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRemoteCacheSize is the most the files cached from remote books roots take up, unless RemoteCacheOptions.MaxBytes is set.
+const DefaultRemoteCacheSize = 2 << 30
+
+// DefaultRemoteConcurrency is the number of files downloaded from remote books roots at once, unless RemoteCacheOptions.Concurrency is set.
+const DefaultRemoteConcurrency = 2
+
+// RemoteCacheOptions controls how files on remote books roots are downloaded and cached.
+type RemoteCacheOptions struct {
+	// MaxBytes is the most the cached files can take up. Once they take up more, the least recently used are removed,
+	// except those still being read, so the cache can go over it while they are.
+	// If it's 0, DefaultRemoteCacheSize is used.
+	MaxBytes int64
+	// Concurrency is the most files downloaded at once; other downloads wait their turn.
+	// If it's 0, DefaultRemoteConcurrency is used.
+	Concurrency int
+	// BandwidthLimit is the most bytes per second read from remote roots, shared by every download, or 0 for no limit.
+	BandwidthLimit int64
+}
+
+// remoteCache holds local copies of files on remote books roots, named by their hashes, and downloads them a few at a time.
+type remoteCache struct {
+	dir      string
+	maxBytes int64
+	// slots holds a token for each download in progress, so no more than its capacity run at once.
+	slots   chan struct{}
+	limiter *rateLimiter
+
+	mu sync.Mutex
+	// downloads are the downloads in progress, by hash, so a file requested again while it's downloading is only downloaded once.
+	downloads map[string]*remoteDownload
+	// inUse counts, by hash, the callers of get which haven't released the cached files they were given yet,
+	// so they aren't removed while they're being read.
+	inUse map[string]int
+}
+
+// remoteDownload is a file being downloaded into the cache. done is closed once it's finished, and err is set if it failed.
+type remoteDownload struct {
+	done chan struct{}
+	err  error
+}
+
+func newRemoteCache(dir string, opts RemoteCacheOptions) *remoteCache {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultRemoteCacheSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultRemoteConcurrency
+	}
+	return &remoteCache{
+		dir:       dir,
+		maxBytes:  opts.MaxBytes,
+		slots:     make(chan struct{}, opts.Concurrency),
+		limiter:   &rateLimiter{rate: opts.BandwidthLimit},
+		downloads: make(map[string]*remoteDownload),
+		inUse:     make(map[string]int),
+	}
+}
+
+// path returns where the file with hash is cached.
+func (c *remoteCache) path(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash)
+}
+
+// get returns the cached copy of the file at src with hash, downloading it first if it isn't cached.
+// The cached file isn't removed until release is called, which must be called once the file has been read.
+func (c *remoteCache) get(src, hash string) (fn string, release func(), err error) {
+	dst := c.path(hash)
+	release = func() {
+		c.mu.Lock()
+		if c.inUse[hash]--; c.inUse[hash] <= 0 {
+			delete(c.inUse, hash)
+		}
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	c.inUse[hash]++
+	d, ok := c.downloads[hash]
+	if !ok {
+		if _, err := os.Stat(dst); err == nil {
+			c.mu.Unlock()
+			// Cached files are removed in the order they were last used.
+			now := time.Now()
+			if err := os.Chtimes(dst, now, now); err != nil {
+				log.Printf("Cannot mark %s as used: %s", dst, err)
+			}
+			return dst, release, nil
+		}
+		d = &remoteDownload{done: make(chan struct{})}
+		c.downloads[hash] = d
+		go c.download(d, src, hash)
+	}
+	c.mu.Unlock()
+	<-d.done
+	if d.err != nil {
+		release()
+		return "", nil, d.err
+	}
+	return dst, release, nil
+}
+
+// download downloads the file at src into the cache once it's its turn, then removes the least recently used files if the cache is full.
+func (c *remoteCache) download(d *remoteDownload, src, hash string) {
+	c.slots <- struct{}{}
+	d.err = c.copy(src, hash)
+	<-c.slots
+	c.mu.Lock()
+	delete(c.downloads, hash)
+	c.mu.Unlock()
+	close(d.done)
+	if d.err == nil {
+		if err := c.evict(hash); err != nil {
+			log.Printf("Cannot remove files from the remote cache: %s", err)
+		}
+	}
+}
+
+// copy copies the file at src into the cache, no faster than the bandwidth limit, checking that its hash is still hash.
+func (c *remoteCache) copy(src, hash string) error {
+	dst := c.path(hash)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return errors.Wrap(err, "create remote cache directory")
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "open remote file")
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(dst), hash+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create cached file")
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hasher), &limitedReader{r: in, limiter: c.limiter})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && fmt.Sprintf("%x", hasher.Sum(nil)) != hash {
+		err = errors.Errorf("%s has changed since it was added to the library", src)
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return errors.Wrapf(err, "download %s", src)
+	}
+	return nil
+}
+
+// evict removes the least recently used cached files until they take up no more than the cache's limit.
+// Files being downloaded aren't counted or removed, nor are files in use, or the file with hash, which was just downloaded,
+// so a file bigger than the limit can still be read.
+func (c *remoteCache) evict(hash string) error {
+	type cachedFile struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cachedFile
+	var total int64
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		files = append(files, cachedFile{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		removed, err := c.remove(f.path, hash)
+		if err != nil {
+			return err
+		}
+		if removed {
+			total -= f.size
+		}
+	}
+	return nil
+}
+
+// remove removes the cached file at fn, and returns true, unless it's in use or has hash.
+func (c *remoteCache) remove(fn, hash string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name := filepath.Base(fn); name == hash || c.inUse[name] > 0 {
+		return false, nil
+	}
+	if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// remoteRoot returns true if bf is stored on a remote books root.
+func (lib *Library) remoteRoot(bf BookFile) bool {
+	if bf.Path != "" {
+		return false
+	}
+	r, err := lib.root(bf.Root)
+	return err == nil && r.Remote
+}
+
+// CachedFilePath returns the path of a local copy of a file, for reading it.
+// Files on remote books roots are downloaded into the remote cache, in the library's remote_cache directory,
+// unless they're already there, waiting their turn if as many files as RemoteCacheOptions.Concurrency allows are already downloading.
+// Other files are read where they are, as returned by FilePath.
+// The copy is kept in the cache until release is called, which must be done once the file has been read.
+func (lib *Library) CachedFilePath(bf BookFile) (fn string, release func(), err error) {
+	fn, err = lib.FilePath(bf)
+	if err != nil {
+		return "", nil, err
+	}
+	if !lib.remoteRoot(bf) {
+		return fn, func() {}, nil
+	}
+	return lib.remoteCache.get(fn, bf.Hash)
+}
+
+// Prefetch downloads the files on remote books roots into the remote cache, as CachedFilePath does,
+// so they can be read quickly later, such as the files of books which are about to be read.
+// It returns once every file is cached, with the number of files downloaded or already cached,
+// and logs files which couldn't be downloaded, such as those on roots which aren't available.
+// Files not on remote roots are skipped.
+func (lib *Library) Prefetch(files []BookFile, progress ProgressFunc) (int, error) {
+	var remote []BookFile
+	for _, f := range files {
+		if lib.remoteRoot(f) {
+			remote = append(remote, f)
+		}
+	}
+	tracker := newProgressTracker("prefetch", len(remote), progress)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	cached := 0
+	for _, f := range remote {
+		wg.Add(1)
+		go func(f BookFile) {
+			defer wg.Done()
+			_, release, err := lib.CachedFilePath(f)
+			if err == nil {
+				release()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			tracker.start(f.CurrentFilename)
+			if err != nil {
+				log.Printf("Cannot prefetch file %d: %s", f.ID, err)
+			} else {
+				cached++
+			}
+			tracker.done()
+		}(f)
+	}
+	wg.Wait()
+	if cached == 0 && len(remote) > 0 {
+		return 0, errors.New("none of the files could be downloaded")
+	}
+	return cached, nil
+}
@@ -0,0 +1,143 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ReparseChange is a change to a book's metadata proposed by ReparseFilenames.
+type ReparseChange struct {
+	Old Book
+	New Book
+	// Filename is the original filename the new metadata was parsed from, and Regexp is the name of the regexp which matched it.
+	Filename string
+	Regexp   string
+	// ConflictID is the ID of an existing book with the new title and authors.
+	// If it's not 0, the change wasn't made.
+	ConflictID int64
+}
+
+// ReparseFilenames parses the original filenames of the files of books matching query, or all books if query is empty,
+// with the regexps in rules, and updates the books' metadata to match, as if they were imported with those rules.
+// The regexps are tried in order against each of a book's files, as they are when importing, and the first match is used.
+// Only the fields the matching regexp has groups for are changed, so a regexp without a series group leaves the series alone.
+// Files are renamed according to tmpl.
+// If dryRun is true, the changes are returned but not made.
+func (lib *Library) ReparseFilenames(rules RuleSet, query string, tmpl *template.Template, dryRun bool) ([]ReparseChange, error) {
+	regexps, err := rules.compile()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	if strings.TrimSpace(query) == "" {
+		ids, err = lib.ListBookIDs(SortByID, false)
+	} else {
+		var results []SearchResult
+		results, _, err = lib.SearchWithOptions(query, SearchOptions{})
+		for _, r := range results {
+			ids = append(ids, r.Book.ID)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "find books")
+	}
+
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	changes, err := lib.reparseFilenames(tx, ids, regexps, rules.AuthorParser(), tmpl, dryRun)
+	if err != nil || dryRun {
+		tx.Rollback()
+		return changes, err
+	}
+	err = tx.Commit()
+	var changed []int64
+	for _, c := range changes {
+		if c.ConflictID == 0 {
+			changed = append(changed, c.New.ID)
+		}
+	}
+	lib.invalidateBooks(changed...)
+	if err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+	return changes, nil
+}
+
+func (lib *Library) reparseFilenames(tx *sql.Tx, ids []int64, regexps []compiledRegexp, authorParser AuthorParser, tmpl *template.Template, dryRun bool) ([]ReparseChange, error) {
+	bks, err := getBooksByID(tx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "get books")
+	}
+	var changes []ReparseChange
+	for _, old := range bks {
+		book, change, ok := reparseBook(old, regexps, authorParser)
+		if !ok {
+			continue
+		}
+		book.Authors = uniqueAuthors(book.Authors)
+		if book.Title == "" || len(book.Authors) == 0 {
+			continue
+		}
+		if book.Title == old.Title && book.Series == old.Series && book.Publisher == old.Publisher &&
+			stringSlicesEqual(book.Authors, old.Authors, false) && contributorsEqual(book.Contributors, old.Contributors) {
+			continue
+		}
+		change.New = book
+		existingID, found, err := getBookIDByTitleAndAuthors(tx, book.Title, book.Authors, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "find existing book")
+		}
+		if found && existingID != book.ID {
+			change.ConflictID = existingID
+			log.Printf("Not reparsing book %d: book %d already has title %s and authors %s", book.ID, existingID, book.Title, strings.Join(book.Authors, " & "))
+		} else if !dryRun {
+			if err := lib.updateBook(tx, book, tmpl, true); err != nil {
+				return nil, errors.Wrapf(err, "update book %d", book.ID)
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// reparseBook parses the original filenames of old's files with regexps, returning a copy of old with the parsed metadata.
+// ok is false if none of the filenames match.
+func reparseBook(old Book, regexps []compiledRegexp, authorParser AuthorParser) (book Book, change ReparseChange, ok bool) {
+	for _, r := range regexps {
+		for _, f := range old.Files {
+			filename := path.Base(f.OriginalFilename)
+			mapping := re2map(filename, r.re)
+			if mapping == nil {
+				continue
+			}
+			book = copyBook(old)
+			book.Title = collapseSpace(mapping["title"])
+			if author, ok := mapping["author"]; ok {
+				authors, contributors := splitContributors(authorParser.Parse(author))
+				book.Authors = authors
+				if len(contributors) > 0 {
+					book.Contributors = contributors
+				}
+			}
+			if series, ok := mapping["series"]; ok {
+				book.Series = collapseSpace(series)
+			}
+			if publisher, ok := mapping["publisher"]; ok {
+				book.Publisher = collapseSpace(publisher)
+			}
+			return book, ReparseChange{Old: old, Filename: f.OriginalFilename, Regexp: r.name}, true
+		}
+	}
+	return book, ReparseChange{}, false
+}
@@ -0,0 +1,141 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// RescanFunc is called by Rescan for each new or modified file.
+// OriginalFilename, FileSize, FileMtime, and Hash will be set on bf.
+// If RescanFunc returns an error, the file will be scanned again by the next rescan.
+type RescanFunc func(bf BookFile) error
+
+// RescanStats counts the files seen by Rescan.
+type RescanStats struct {
+	// Scanned is the number of files found.
+	Scanned int
+	// Unchanged is the number of files skipped because their size and modification time haven't changed since the last scan.
+	Unchanged int
+	// Imported is the number of new or modified files successfully passed to RescanFunc.
+	Imported int
+	// Errors is the number of files which couldn't be hashed, or for which RescanFunc returned an error.
+	Errors int
+}
+
+// Rescan walks dir, calling fn for each file whose path, size, and modification time
+// aren't already recorded in the scan cache from a previous scan.
+// Only new and modified files are hashed.
+// Subdirectories are only scanned if recursive is true.
+// If progress isn't nil, it will be called as each file is scanned.
+func (lib *Library) Rescan(dir string, recursive bool, fn RescanFunc, progress ProgressFunc) (RescanStats, error) {
+	var stats RescanStats
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return stats, errors.Wrap(err, "get absolute path")
+	}
+	total := 0
+	if progress != nil {
+		// Counting files first is cheap compared to hashing them, and allows an accurate ETA.
+		if total, err = countFiles(root, recursive); err != nil {
+			return stats, errors.Wrap(err, "count files")
+		}
+	}
+	tracker := newProgressTracker("rescan", total, progress)
+	err = walkFiles(root, recursive, func(path string, info os.FileInfo) error {
+		tracker.start(path)
+		defer tracker.done()
+
+		stats.Scanned++
+
+		unchanged, err := lib.scanCacheMatches(path, info)
+		if err != nil {
+			return errors.Wrap(err, "check scan cache")
+		}
+		if unchanged {
+			stats.Unchanged++
+			return nil
+		}
+
+		bf := BookFile{OriginalFilename: path, FileSize: info.Size(), FileMtime: info.ModTime()}
+		if err := bf.CalculateHash(); err != nil {
+			log.Printf("Cannot hash %s: %s; skipping", path, err)
+			stats.Errors++
+			return nil
+		}
+		if err := fn(bf); err != nil {
+			log.Printf("Cannot import %s: %s; skipping", path, err)
+			stats.Errors++
+			return nil
+		}
+		stats.Imported++
+		if err := lib.updateScanCache(bf); err != nil {
+			return errors.Wrap(err, "update scan cache")
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// walkFiles calls fn for each regular file in root, descending into subdirectories only if recursive is true.
+func walkFiles(root string, recursive bool, fn func(path string, info os.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return fn(path, info)
+	})
+}
+
+// countFiles counts the regular files which walkFiles would visit.
+func countFiles(root string, recursive bool) (int, error) {
+	n := 0
+	err := walkFiles(root, recursive, func(string, os.FileInfo) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// scanCacheMatches returns true if the scan cache has an entry for path with the same size and modification time as info.
+func (lib *Library) scanCacheMatches(path string, info os.FileInfo) (bool, error) {
+	var size, mtime int64
+	err := lib.QueryRow("select file_size, file_mtime from scan_cache where path=?", path).Scan(&size, &mtime)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return size == info.Size() && mtime == info.ModTime().UnixNano(), nil
+}
+
+// updateScanCache records the size, modification time, and hash of a scanned file.
+func (lib *Library) updateScanCache(bf BookFile) error {
+	_, err := lib.Exec("insert or replace into scan_cache (path, file_size, file_mtime, hash, scanned_on) values(?, ?, ?, ?, datetime())",
+		bf.OriginalFilename, bf.FileSize, bf.FileMtime.UnixNano(), bf.Hash)
+	return err
+}
+
+// ClearScanCache forgets all files previously seen by Rescan, so that the next rescan hashes every file.
+func (lib *Library) ClearScanCache() error {
+	if _, err := lib.Exec("delete from scan_cache"); err != nil {
+		return errors.Wrap(err, "clear scan cache")
+	}
+	return nil
+}
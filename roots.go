@@ -0,0 +1,273 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRootUnavailable is returned when a file is on a books root which isn't available, such as a drive which isn't mounted.
+var ErrRootUnavailable = errors.New("books root is not available")
+
+// ErrFileInPlace is returned when a file cataloged in place, outside of the books roots, is moved between books roots.
+var ErrFileInPlace = errors.New("file is cataloged in place, outside of the books roots")
+
+// ErrInsufficientSpace is returned when no books root has enough free space or quota left for a file, before it's copied.
+// It's also the cause of the InsufficientSpaceError returned when the free space runs out by the time the file is copied.
+var ErrInsufficientSpace = errors.New("not enough free space or quota")
+
+// A BooksRoot is a directory holding the files of books in the library.
+// A library has a main root, passed to OpenLibrary, and may have others, such as an external archive drive.
+type BooksRoot struct {
+	// Name identifies the root in the library. The main root's name is empty.
+	Name string
+	// Path is the root's directory.
+	// Roots other than the main root are unavailable while their directory doesn't exist:
+	// their files can't be read, aren't marked as missing, and new files aren't placed on them.
+	// A root on a removable drive should be a directory on the drive, rather than where it's mounted,
+	// so that it doesn't exist while the drive is unmounted.
+	Path string
+	// NoPlacement keeps imported files from being placed on the root, such as for an archive which files are only moved to.
+	NoPlacement bool
+	// Quota is the most bytes the files on the root can take up, or 0 for no limit.
+	// Files with the same contents are stored once, so they're only counted once.
+	Quota int64
+	// Remote marks a root on slow storage, such as an S3 bucket or WebDAV share mounted with rclone.
+	// Its files are downloaded into the library's remote cache before they're served,
+	// a few at a time and no faster than RemoteCacheOptions allows, and read from there while they're cached.
+	Remote bool
+}
+
+// Available returns true if the root's files can be read, because its directory exists.
+// The main root is always available, as it was before libraries could have more than one, and is created when it's needed.
+func (r BooksRoot) Available() bool {
+	if r.Name == "" {
+		return true
+	}
+	fi, err := os.Stat(r.Path)
+	return err == nil && fi.IsDir()
+}
+
+// PlacementPolicy chooses which books root an imported file is placed on.
+type PlacementPolicy string
+
+// Placement policies.
+const (
+	// PlaceFirst places files on the first available root, starting with the main root.
+	PlaceFirst PlacementPolicy = "first"
+	// PlaceMostFree places files on the available root with the most free space.
+	PlaceMostFree PlacementPolicy = "most-free"
+)
+
+// newBooksRoots returns the library's roots, starting with the main root, after checking that the others have unique names.
+func newBooksRoots(main string, mainQuota int64, others []BooksRoot) ([]BooksRoot, error) {
+	roots := []BooksRoot{{Path: main, Quota: mainQuota}}
+	seen := make(map[string]bool)
+	for _, r := range others {
+		if r.Name == "" {
+			return nil, errors.Errorf("books root %s has no name", r.Path)
+		}
+		if seen[r.Name] {
+			return nil, errors.Errorf("more than one books root is named %s", r.Name)
+		}
+		if r.Quota < 0 {
+			return nil, errors.Errorf("quota of books root %s is negative", r.Name)
+		}
+		seen[r.Name] = true
+		roots = append(roots, r)
+	}
+	return roots, nil
+}
+
+// Roots returns the library's books roots, starting with the main root.
+func (lib *Library) Roots() []BooksRoot {
+	return append([]BooksRoot(nil), lib.roots...)
+}
+
+// root returns the books root with a name.
+func (lib *Library) root(name string) (BooksRoot, error) {
+	for _, r := range lib.roots {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return BooksRoot{}, errors.Errorf("no books root named %s", name)
+}
+
+// FilePath returns the absolute path of a file in the books root it's stored on, or where it is if it was cataloged in place.
+// If the root isn't available, the error's cause is ErrRootUnavailable.
+func (lib *Library) FilePath(bf BookFile) (string, error) {
+	if bf.Path != "" {
+		return bf.Path, nil
+	}
+	r, err := lib.root(bf.Root)
+	if err != nil {
+		return "", err
+	}
+	if !r.Available() {
+		return "", errors.Wrapf(ErrRootUnavailable, "books root %s", r.Name)
+	}
+	return filepath.Join(r.Path, bf.HashPath()), nil
+}
+
+// placeFile chooses the root a file being imported is stored on, before it's copied.
+// A file with the same hash as a file already in the library is stored with it.
+// Otherwise, only roots with enough free space and quota left for the file are chosen,
+// and if there aren't any, the error's cause is ErrInsufficientSpace.
+func (lib *Library) placeFile(tx *sql.Tx, bf BookFile) (string, error) {
+	var existing string
+	err := tx.QueryRow("select root from files where hash=? and path='' order by id limit 1", bf.Hash).Scan(&existing)
+	if err == nil {
+		return existing, nil
+	} else if err != sql.ErrNoRows {
+		return "", errors.Wrap(err, "find file with the same hash")
+	}
+
+	var best *BooksRoot
+	var bestRoom int64
+	candidates := 0
+	for i, r := range lib.roots {
+		if r.NoPlacement || !r.Available() {
+			continue
+		}
+		candidates++
+		room, err := lib.rootRoom(tx, r)
+		if err != nil {
+			return "", err
+		}
+		if room >= 0 && room < bf.FileSize {
+			continue
+		}
+		if lib.placement != PlaceMostFree {
+			return r.Name, nil
+		}
+		if best == nil || bestRoom >= 0 && (room < 0 || room > bestRoom) {
+			best, bestRoom = &lib.roots[i], room
+		}
+	}
+	if candidates == 0 {
+		return "", errors.Wrap(ErrRootUnavailable, "no books root can hold new files")
+	}
+	if best == nil {
+		return "", errors.Wrapf(ErrInsufficientSpace, "no books root has room for %s, which needs %d bytes", bf.OriginalFilename, bf.FileSize)
+	}
+	return best.Name, nil
+}
+
+// rootRoom returns how many more bytes of files a root can hold, limited by its free space and quota,
+// or -1 if neither can be found.
+func (lib *Library) rootRoom(tx *sql.Tx, r BooksRoot) (int64, error) {
+	room := int64(-1)
+	if free, ok, err := nearestFreeSpace(r.Path); err != nil {
+		log.Printf("Cannot find the free space of books root %s: %s", r.Path, err)
+	} else if ok {
+		room = int64(free)
+	}
+	if r.Quota > 0 {
+		var used int64
+		err := tx.QueryRow("select coalesce(sum(file_size), 0) from (select distinct hash, file_size from files where root=? and path='')", r.Name).Scan(&used)
+		if err != nil {
+			return 0, errors.Wrapf(err, "get space used by books root %s", r.Path)
+		}
+		left := r.Quota - used
+		if left < 0 {
+			left = 0
+		}
+		if room < 0 || left < room {
+			room = left
+		}
+	}
+	return room, nil
+}
+
+// nearestFreeSpace returns the free space of dir, or of its closest parent which exists,
+// since the main root isn't created until the first file is stored on it.
+func nearestFreeSpace(dir string) (free uint64, ok bool, err error) {
+	for {
+		free, ok, err = freeSpace(dir)
+		if !os.IsNotExist(err) {
+			return free, ok, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return free, ok, err
+		}
+		dir = parent
+	}
+}
+
+// MoveFileToRoot moves a file to another books root, such as to archive it on an external drive.
+func (lib *Library) MoveFileToRoot(fileID int64, rootName string) error {
+	dst, err := lib.root(rootName)
+	if err != nil {
+		return err
+	}
+	if !dst.Available() {
+		return errors.Wrapf(ErrRootUnavailable, "books root %s", dst.Name)
+	}
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	files, err := getFilesByID(tx, []int64{fileID})
+	if err != nil {
+		return errors.Wrap(err, "get file")
+	}
+	if len(files) == 0 {
+		return ErrFileNotFound
+	}
+	file := files[0]
+	if file.Path != "" {
+		return ErrFileInPlace
+	}
+	if file.Root == rootName {
+		return nil
+	}
+	oldPath, err := lib.FilePath(file)
+	if err != nil {
+		return err
+	}
+
+	room, err := lib.rootRoom(tx, dst)
+	if err != nil {
+		return err
+	}
+	if room >= 0 && room < file.FileSize {
+		return errors.Wrapf(ErrInsufficientSpace, "books root %s has room for %d bytes, but file %d needs %d", dst.Path, room, fileID, file.FileSize)
+	}
+
+	// Files with the same hash share one copy, so they move together.
+	if _, err := tx.Exec("update files set updated_on=datetime(), root=? where hash=? and path=''", rootName, file.Hash); err != nil {
+		return errors.Wrap(err, "update root")
+	}
+	file.OriginalFilename = oldPath
+	file.Root = rootName
+	if _, err := lib.insertFile(file, false); err != nil {
+		return errors.Wrap(err, "copy file")
+	}
+	err = tx.Commit()
+	lib.invalidateCache()
+	if err != nil {
+		if newPath, pathErr := lib.FilePath(file); pathErr == nil {
+			os.Remove(newPath)
+		}
+		return errors.Wrap(err, "commit")
+	}
+	if err := os.Remove(oldPath); err != nil {
+		log.Printf("Error removing %s after moving it to books root %s: %s", oldPath, rootName, err)
+	}
+	return nil
+}
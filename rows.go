@@ -0,0 +1,299 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//go:generate go run ./internal/rowgen -output rows_gen.go -type bookRow,fileRow,authorRow,contributorRow,identifierRow,classificationRow,alternateTitleRow,fanficTagRow,activityRow,importReportRow,issueRow,readingProgressRow,synonymRow,derivationRow,idempotencyRow rows.go
+
+// rowScanner scans a row of query results, and is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// The row structs below are rows of the tables they're named after.
+// Their db tags are the SQL selected for each field, from which a fooRowColumns constant and scanFooRow function are generated for each fooRow.
+
+// bookRow is a row of the books table.
+type bookRow struct {
+	ID int64 `db:"id"`
+	// UUID is null in rows added before UUIDs were, until they're given one.
+	UUID             string    `db:"coalesce(uuid, '')"`
+	Series           string    `db:"series"`
+	SeriesIndex      float64   `db:"series_index"`
+	Title            string    `db:"title"`
+	Subtitle         string    `db:"subtitle"`
+	Publisher        string    `db:"publisher"`
+	Description      string    `db:"description"`
+	Language         string    `db:"language"`
+	Published        string    `db:"published"`
+	OriginalTitle    string    `db:"original_title"`
+	OriginalLanguage string    `db:"original_language"`
+	TranslationOf    int64     `db:"coalesce(translation_of, 0)"`
+	Rating           int       `db:"rating"`
+	Pages            int       `db:"pages"`
+	License          License   `db:"license"`
+	WordCount        int       `db:"word_count"`
+	SourceURL        string    `db:"source_url"`
+	CreatedOn        time.Time `db:"created_on"`
+	UpdatedOn        time.Time `db:"updated_on"`
+	// FileAddedOn isn't a column, but an expression, which the driver doesn't know is a time.
+	FileAddedOn string `dbconst:"sqlFileAddedOn"`
+}
+
+// book returns the book in the row, without the fields stored in other tables, such as its authors and files.
+func (r bookRow) book() (Book, error) {
+	fileAddedOn, err := parseTimestamp(r.FileAddedOn)
+	if err != nil {
+		return Book{}, errors.Wrapf(err, "file added time of book %d", r.ID)
+	}
+	return Book{
+		ID:               r.ID,
+		UUID:             r.UUID,
+		Series:           r.Series,
+		SeriesIndex:      r.SeriesIndex,
+		Title:            r.Title,
+		Subtitle:         r.Subtitle,
+		Publisher:        r.Publisher,
+		Description:      r.Description,
+		Language:         r.Language,
+		Published:        r.Published,
+		OriginalTitle:    r.OriginalTitle,
+		OriginalLanguage: r.OriginalLanguage,
+		TranslationOf:    r.TranslationOf,
+		Rating:           r.Rating,
+		Pages:            r.Pages,
+		License:          r.License,
+		WordCount:        r.WordCount,
+		SourceURL:        r.SourceURL,
+		CreatedOn:        r.CreatedOn,
+		UpdatedOn:        r.UpdatedOn,
+		FileAddedOn:      fileAddedOn,
+	}, nil
+}
+
+// fileRow is a row of the files table.
+type fileRow struct {
+	ID               int64  `db:"id"`
+	UUID             string `db:"coalesce(uuid, '')"`
+	Extension        string `db:"extension"`
+	OriginalFilename string `db:"original_filename"`
+	// Filename is the file's name relative to its books root, or its absolute path if it's cataloged in place.
+	Filename  string    `db:"filename"`
+	FileSize  int64     `db:"file_size"`
+	FileMtime time.Time `db:"file_mtime"`
+	Hash      string    `db:"hash"`
+	Source    string    `db:"source"`
+	Missing   bool      `db:"missing"`
+	CreatedOn time.Time `db:"created_on"`
+	UpdatedOn time.Time `db:"updated_on"`
+	// Root is the name of the books root the file is on, which is empty for the main root.
+	Root             string `db:"root"`
+	TemplateOverride string `db:"coalesce(template_override, '')"`
+	// Path is where a file cataloged in place is, or empty if it's in a books root.
+	Path string `db:"path"`
+}
+
+// bookFile returns the file in the row, without its tags and works, which are stored in other tables.
+func (r fileRow) bookFile() BookFile {
+	return BookFile{
+		ID:               r.ID,
+		UUID:             r.UUID,
+		Extension:        r.Extension,
+		OriginalFilename: r.OriginalFilename,
+		CurrentFilename:  r.Filename,
+		FileSize:         r.FileSize,
+		FileMtime:        r.FileMtime,
+		Hash:             r.Hash,
+		Source:           r.Source,
+		Missing:          r.Missing,
+		CreatedOn:        r.CreatedOn,
+		UpdatedOn:        r.UpdatedOn,
+		Root:             r.Root,
+		TemplateOverride: r.TemplateOverride,
+		Path:             r.Path,
+	}
+}
+
+// authorRow is a row of the authors table.
+type authorRow struct {
+	ID             int64  `db:"id"`
+	Name           string `db:"name"`
+	Disambiguation string `db:"disambiguation"`
+	ExternalID     string `db:"external_id"`
+}
+
+// author returns the author in the row, without the IDs of their books.
+func (r authorRow) author() Author {
+	return Author{ID: r.ID, Name: r.Name, Disambiguation: r.Disambiguation, ExternalID: r.ExternalID}
+}
+
+// contributorRow is a row of books_authors, with the contributor's name from authors.
+// It's selected from books_authors ba joined with authors a.
+type contributorRow struct {
+	BookID int64  `db:"ba.book_id"`
+	Name   string `db:"a.name"`
+	Role   string `db:"ba.role"`
+}
+
+func (r contributorRow) contributor() Contributor {
+	return Contributor{Name: r.Name, Role: r.Role}
+}
+
+// identifierRow is a row of the identifiers table.
+type identifierRow struct {
+	BookID int64  `db:"book_id"`
+	Type   string `db:"type"`
+	Value  string `db:"value"`
+}
+
+func (r identifierRow) identifier() Identifier {
+	return Identifier{Type: r.Type, Value: r.Value}
+}
+
+// classificationRow is a row of the book_classifications table.
+type classificationRow struct {
+	BookID int64  `db:"book_id"`
+	Scheme string `db:"scheme"`
+	Number string `db:"number"`
+}
+
+func (r classificationRow) classification() Classification {
+	return Classification{Scheme: r.Scheme, Number: r.Number}
+}
+
+// alternateTitleRow is a row of the alternate_titles table.
+type alternateTitleRow struct {
+	BookID int64  `db:"book_id"`
+	Title  string `db:"title"`
+	Kind   string `db:"kind"`
+}
+
+func (r alternateTitleRow) alternateTitle() AlternateTitle {
+	return AlternateTitle{Title: r.Title, Kind: r.Kind}
+}
+
+// fanficTagRow is a row of the fanfic_tags table.
+type fanficTagRow struct {
+	BookID int64 `db:"book_id"`
+	// Kind is fanficFandom or fanficShip.
+	Kind string `db:"kind"`
+	Name string `db:"name"`
+}
+
+// activityRow is a row of the activity table.
+type activityRow struct {
+	ID     int64        `db:"id"`
+	Time   time.Time    `db:"created_on"`
+	Kind   ActivityKind `db:"kind"`
+	BookID int64        `db:"book_id"`
+	// FileID is null once the file is removed.
+	FileID int64  `db:"coalesce(file_id, 0)"`
+	User   string `db:"username"`
+	Detail string `db:"detail"`
+}
+
+func (r activityRow) event() ActivityEvent {
+	return ActivityEvent{ID: r.ID, Time: r.Time, Kind: r.Kind, BookID: r.BookID, FileID: r.FileID, User: r.User, Detail: r.Detail}
+}
+
+// importReportRow is a row of the import_reports table, without the entries, which are stored as JSON.
+type importReportRow struct {
+	ID         int64     `db:"id"`
+	StartedOn  time.Time `db:"started_on"`
+	FinishedOn time.Time `db:"finished_on"`
+	Imported   int       `db:"imported"`
+	Duplicates int       `db:"duplicates"`
+	Failures   int       `db:"failures"`
+}
+
+func (r importReportRow) report() *ImportReport {
+	return &ImportReport{ID: r.ID, StartedOn: r.StartedOn, FinishedOn: r.FinishedOn, Imported: r.Imported, Duplicates: r.Duplicates, Failures: r.Failures}
+}
+
+// issueRow is a row of the periodical_issues table.
+type issueRow struct {
+	BookID   int64     `db:"book_id"`
+	Feed     string    `db:"feed"`
+	Date     time.Time `db:"issue_date"`
+	LastItem time.Time `db:"last_item"`
+}
+
+func (r issueRow) issue() Issue {
+	return Issue{BookID: r.BookID, Feed: r.Feed, Date: r.Date, LastItem: r.LastItem}
+}
+
+// readingProgressRow is a row of the reading_progress table.
+type readingProgressRow struct {
+	User     string `db:"username"`
+	Document string `db:"document"`
+	// FileID is null if no library file matches the document.
+	FileID     int64     `db:"coalesce(file_id, 0)"`
+	Progress   string    `db:"progress"`
+	Percentage float64   `db:"percentage"`
+	Device     string    `db:"device"`
+	DeviceID   string    `db:"device_id"`
+	UpdatedOn  time.Time `db:"updated_on"`
+}
+
+func (r readingProgressRow) progress() ReadingProgress {
+	return ReadingProgress{
+		User:       r.User,
+		Document:   r.Document,
+		FileID:     r.FileID,
+		Progress:   r.Progress,
+		Percentage: r.Percentage,
+		Device:     r.Device,
+		DeviceID:   r.DeviceID,
+		UpdatedOn:  r.UpdatedOn,
+	}
+}
+
+// synonymRow is a row of the synonyms table.
+type synonymRow struct {
+	Term      string `db:"term"`
+	Expansion string `db:"expansion"`
+	IndexTime bool   `db:"index_time"`
+}
+
+func (r synonymRow) synonym() Synonym {
+	return Synonym{Term: r.Term, Expansion: r.Expansion, IndexTime: r.IndexTime}
+}
+
+// derivationRow is a row of the derivations table.
+type derivationRow struct {
+	Hash     string `db:"hash"`
+	Kind     string `db:"kind"`
+	Params   string `db:"params"`
+	Filename string `db:"filename"`
+	// OutputHash is null for everything but conversions.
+	OutputHash string    `db:"coalesce(output_hash, '')"`
+	CreatedOn  time.Time `db:"created_on"`
+}
+
+func (r derivationRow) derivation() Derivation {
+	return Derivation{Hash: r.Hash, Kind: r.Kind, Params: r.Params, Filename: r.Filename, OutputHash: r.OutputHash, CreatedOn: r.CreatedOn}
+}
+
+// idempotencyRow is a row of the idempotency_keys table.
+type idempotencyRow struct {
+	Operation string `db:"operation"`
+	// Result is the ID of the book the operation was done to.
+	Result    string    `db:"result"`
+	CreatedOn time.Time `db:"created_on"`
+}
+
+func (r idempotencyRow) result() (IdempotentResult, error) {
+	bookID, err := strconv.ParseInt(r.Result, 10, 64)
+	if err != nil {
+		return IdempotentResult{}, errors.Wrap(err, "parse idempotent result")
+	}
+	return IdempotentResult{Operation: r.Operation, BookID: bookID, CreatedOn: r.CreatedOn}, nil
+}
@@ -0,0 +1,153 @@
+// Code generated by rowgen from rows.go; DO NOT EDIT.
+
+package books
+
+// bookRowColumns are the columns of bookRow, in the order scanBookRow scans them.
+const bookRowColumns = "id, coalesce(uuid, ''), series, series_index, title, subtitle, publisher, description, language, published, original_title, original_language, coalesce(translation_of, 0), rating, pages, license, word_count, source_url, created_on, updated_on, " + sqlFileAddedOn
+
+// scanBookRow scans a row selected with bookRowColumns.
+func scanBookRow(row rowScanner) (bookRow, error) {
+	var r bookRow
+	err := row.Scan(&r.ID, &r.UUID, &r.Series, &r.SeriesIndex, &r.Title, &r.Subtitle, &r.Publisher, &r.Description, &r.Language, &r.Published, &r.OriginalTitle, &r.OriginalLanguage, &r.TranslationOf, &r.Rating, &r.Pages, &r.License, &r.WordCount, &r.SourceURL, &r.CreatedOn, &r.UpdatedOn, &r.FileAddedOn)
+	return r, err
+}
+
+// fileRowColumns are the columns of fileRow, in the order scanFileRow scans them.
+const fileRowColumns = "id, coalesce(uuid, ''), extension, original_filename, filename, file_size, file_mtime, hash, source, missing, created_on, updated_on, root, coalesce(template_override, ''), path"
+
+// scanFileRow scans a row selected with fileRowColumns.
+func scanFileRow(row rowScanner) (fileRow, error) {
+	var r fileRow
+	err := row.Scan(&r.ID, &r.UUID, &r.Extension, &r.OriginalFilename, &r.Filename, &r.FileSize, &r.FileMtime, &r.Hash, &r.Source, &r.Missing, &r.CreatedOn, &r.UpdatedOn, &r.Root, &r.TemplateOverride, &r.Path)
+	return r, err
+}
+
+// authorRowColumns are the columns of authorRow, in the order scanAuthorRow scans them.
+const authorRowColumns = "id, name, disambiguation, external_id"
+
+// scanAuthorRow scans a row selected with authorRowColumns.
+func scanAuthorRow(row rowScanner) (authorRow, error) {
+	var r authorRow
+	err := row.Scan(&r.ID, &r.Name, &r.Disambiguation, &r.ExternalID)
+	return r, err
+}
+
+// contributorRowColumns are the columns of contributorRow, in the order scanContributorRow scans them.
+const contributorRowColumns = "ba.book_id, a.name, ba.role"
+
+// scanContributorRow scans a row selected with contributorRowColumns.
+func scanContributorRow(row rowScanner) (contributorRow, error) {
+	var r contributorRow
+	err := row.Scan(&r.BookID, &r.Name, &r.Role)
+	return r, err
+}
+
+// identifierRowColumns are the columns of identifierRow, in the order scanIdentifierRow scans them.
+const identifierRowColumns = "book_id, type, value"
+
+// scanIdentifierRow scans a row selected with identifierRowColumns.
+func scanIdentifierRow(row rowScanner) (identifierRow, error) {
+	var r identifierRow
+	err := row.Scan(&r.BookID, &r.Type, &r.Value)
+	return r, err
+}
+
+// classificationRowColumns are the columns of classificationRow, in the order scanClassificationRow scans them.
+const classificationRowColumns = "book_id, scheme, number"
+
+// scanClassificationRow scans a row selected with classificationRowColumns.
+func scanClassificationRow(row rowScanner) (classificationRow, error) {
+	var r classificationRow
+	err := row.Scan(&r.BookID, &r.Scheme, &r.Number)
+	return r, err
+}
+
+// alternateTitleRowColumns are the columns of alternateTitleRow, in the order scanAlternateTitleRow scans them.
+const alternateTitleRowColumns = "book_id, title, kind"
+
+// scanAlternateTitleRow scans a row selected with alternateTitleRowColumns.
+func scanAlternateTitleRow(row rowScanner) (alternateTitleRow, error) {
+	var r alternateTitleRow
+	err := row.Scan(&r.BookID, &r.Title, &r.Kind)
+	return r, err
+}
+
+// fanficTagRowColumns are the columns of fanficTagRow, in the order scanFanficTagRow scans them.
+const fanficTagRowColumns = "book_id, kind, name"
+
+// scanFanficTagRow scans a row selected with fanficTagRowColumns.
+func scanFanficTagRow(row rowScanner) (fanficTagRow, error) {
+	var r fanficTagRow
+	err := row.Scan(&r.BookID, &r.Kind, &r.Name)
+	return r, err
+}
+
+// activityRowColumns are the columns of activityRow, in the order scanActivityRow scans them.
+const activityRowColumns = "id, created_on, kind, book_id, coalesce(file_id, 0), username, detail"
+
+// scanActivityRow scans a row selected with activityRowColumns.
+func scanActivityRow(row rowScanner) (activityRow, error) {
+	var r activityRow
+	err := row.Scan(&r.ID, &r.Time, &r.Kind, &r.BookID, &r.FileID, &r.User, &r.Detail)
+	return r, err
+}
+
+// importReportRowColumns are the columns of importReportRow, in the order scanImportReportRow scans them.
+const importReportRowColumns = "id, started_on, finished_on, imported, duplicates, failures"
+
+// scanImportReportRow scans a row selected with importReportRowColumns.
+func scanImportReportRow(row rowScanner) (importReportRow, error) {
+	var r importReportRow
+	err := row.Scan(&r.ID, &r.StartedOn, &r.FinishedOn, &r.Imported, &r.Duplicates, &r.Failures)
+	return r, err
+}
+
+// issueRowColumns are the columns of issueRow, in the order scanIssueRow scans them.
+const issueRowColumns = "book_id, feed, issue_date, last_item"
+
+// scanIssueRow scans a row selected with issueRowColumns.
+func scanIssueRow(row rowScanner) (issueRow, error) {
+	var r issueRow
+	err := row.Scan(&r.BookID, &r.Feed, &r.Date, &r.LastItem)
+	return r, err
+}
+
+// readingProgressRowColumns are the columns of readingProgressRow, in the order scanReadingProgressRow scans them.
+const readingProgressRowColumns = "username, document, coalesce(file_id, 0), progress, percentage, device, device_id, updated_on"
+
+// scanReadingProgressRow scans a row selected with readingProgressRowColumns.
+func scanReadingProgressRow(row rowScanner) (readingProgressRow, error) {
+	var r readingProgressRow
+	err := row.Scan(&r.User, &r.Document, &r.FileID, &r.Progress, &r.Percentage, &r.Device, &r.DeviceID, &r.UpdatedOn)
+	return r, err
+}
+
+// synonymRowColumns are the columns of synonymRow, in the order scanSynonymRow scans them.
+const synonymRowColumns = "term, expansion, index_time"
+
+// scanSynonymRow scans a row selected with synonymRowColumns.
+func scanSynonymRow(row rowScanner) (synonymRow, error) {
+	var r synonymRow
+	err := row.Scan(&r.Term, &r.Expansion, &r.IndexTime)
+	return r, err
+}
+
+// derivationRowColumns are the columns of derivationRow, in the order scanDerivationRow scans them.
+const derivationRowColumns = "hash, kind, params, filename, coalesce(output_hash, ''), created_on"
+
+// scanDerivationRow scans a row selected with derivationRowColumns.
+func scanDerivationRow(row rowScanner) (derivationRow, error) {
+	var r derivationRow
+	err := row.Scan(&r.Hash, &r.Kind, &r.Params, &r.Filename, &r.OutputHash, &r.CreatedOn)
+	return r, err
+}
+
+// idempotencyRowColumns are the columns of idempotencyRow, in the order scanIdempotencyRow scans them.
+const idempotencyRowColumns = "operation, result, created_on"
+
+// scanIdempotencyRow scans a row selected with idempotencyRowColumns.
+func scanIdempotencyRow(row rowScanner) (idempotencyRow, error) {
+	var r idempotencyRow
+	err := row.Scan(&r.Operation, &r.Result, &r.CreatedOn)
+	return r, err
+}
@@ -0,0 +1,171 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// RulesFormat is the format of a shared rule set document.
+type RulesFormat string
+
+// Formats of rule set documents.
+const (
+	RulesJSON RulesFormat = "json"
+	RulesYAML RulesFormat = "yaml"
+)
+
+// RuleSet is a shareable set of rules for parsing filenames and naming imported files.
+type RuleSet struct {
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Regexps parse metadata from filenames, as RegexpMetadataParser does, and are tried in order.
+	Regexps []NamedRegexp `json:"regexps" yaml:"regexps"`
+	// OutputTemplate names imported files.
+	OutputTemplate string `json:"output_template" yaml:"output_template"`
+	// AuthorSeparators and SplitAuthorCommas configure the AuthorParser for the author group.
+	// If AuthorSeparators is empty and SplitAuthorCommas is nil, the configured parser is kept.
+	AuthorSeparators  []string `json:"author_separators,omitempty" yaml:"author_separators,omitempty"`
+	SplitAuthorCommas *bool    `json:"split_author_commas,omitempty" yaml:"split_author_commas,omitempty"`
+}
+
+// NamedRegexp is a regular expression for parsing filenames, with the name it's logged with.
+type NamedRegexp struct {
+	Name    string `json:"name" yaml:"name"`
+	Pattern string `json:"pattern" yaml:"pattern"`
+}
+
+// RulesFormatForFilename returns the format of a rule set document from its extension.
+// Files ending in .json are JSON, and everything else is YAML.
+func RulesFormatForFilename(fn string) RulesFormat {
+	if strings.EqualFold(filepath.Ext(fn), ".json") {
+		return RulesJSON
+	}
+	return RulesYAML
+}
+
+// ExportRules writes a rule set to w as a document in format.
+func ExportRules(w io.Writer, rules RuleSet, format RulesFormat) error {
+	switch format {
+	case RulesJSON:
+		enc := json.NewEncoder(w)
+		// Patterns are full of angle brackets, which shouldn't be escaped.
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "\t")
+		return errors.Wrap(enc.Encode(rules), "export rules")
+	case RulesYAML:
+		b, err := yaml.Marshal(rules)
+		if err != nil {
+			return errors.Wrap(err, "export rules")
+		}
+		_, err = w.Write(b)
+		return errors.Wrap(err, "export rules")
+	}
+	return errors.Errorf("unknown rules format %s", format)
+}
+
+// ImportRules reads a rule set document in format from r, and checks that its rules can be used.
+// funcs are the functions the output template can call.
+func ImportRules(r io.Reader, format RulesFormat, funcs template.FuncMap) (RuleSet, error) {
+	var rules RuleSet
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return rules, errors.Wrap(err, "import rules")
+	}
+	switch format {
+	case RulesJSON:
+		err = json.Unmarshal(b, &rules)
+	case RulesYAML:
+		err = yaml.UnmarshalStrict(b, &rules)
+	default:
+		return rules, errors.Errorf("unknown rules format %s", format)
+	}
+	if err != nil {
+		return rules, errors.Wrap(err, "import rules")
+	}
+	return rules, rules.Validate(funcs)
+}
+
+// Validate checks that every regexp compiles and has a title group, and that the output template parses with funcs.
+func (rules RuleSet) Validate(funcs template.FuncMap) error {
+	if len(rules.Regexps) == 0 {
+		return errors.New("no regexps in rule set")
+	}
+	names := make(map[string]bool, len(rules.Regexps))
+	for _, r := range rules.Regexps {
+		if r.Name == "" {
+			return errors.New("regexp without a name in rule set")
+		}
+		name := strings.ToLower(r.Name)
+		if names[name] {
+			return errors.Errorf("regexp %s is in the rule set more than once", r.Name)
+		}
+		names[name] = true
+		c, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return errors.Wrapf(err, "compile regexp %s", r.Name)
+		}
+		if !hasGroup(c, "title") {
+			return errors.Errorf("regexp %s has no title group", r.Name)
+		}
+	}
+	if rules.OutputTemplate == "" {
+		return errors.New("no output template in rule set")
+	}
+	if _, err := template.New("filename").Funcs(funcs).Parse(rules.OutputTemplate); err != nil {
+		return errors.Wrap(err, "parse output template")
+	}
+	return nil
+}
+
+// compiledRegexp is a rule set's regexp, compiled.
+type compiledRegexp struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// compile compiles the rule set's regexps, in order.
+func (rules RuleSet) compile() ([]compiledRegexp, error) {
+	compiled := make([]compiledRegexp, 0, len(rules.Regexps))
+	for _, r := range rules.Regexps {
+		c, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "compile regexp %s", r.Name)
+		}
+		compiled = append(compiled, compiledRegexp{r.Name, c})
+	}
+	return compiled, nil
+}
+
+// AuthorParser returns the parser for the author group: DefaultAuthorParser, changed by the rule set's author settings.
+func (rules RuleSet) AuthorParser() AuthorParser {
+	p := DefaultAuthorParser
+	if len(rules.AuthorSeparators) > 0 {
+		p.Separators = rules.AuthorSeparators
+	}
+	if rules.SplitAuthorCommas != nil {
+		p.SplitCommas = *rules.SplitAuthorCommas
+	}
+	return p
+}
+
+// hasGroup returns true if r has a group called name.
+func hasGroup(r *regexp.Regexp, name string) bool {
+	for _, n := range r.SubexpNames() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
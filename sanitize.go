@@ -0,0 +1,170 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// FilenamePolicy makes the filenames generated from templates safe to use on a file system.
+// The zero value leaves filenames unchanged.
+type FilenamePolicy struct {
+	// Name identifies the policy, such as "windows".
+	Name string
+	// Invalid are the characters which are replaced with _. Control characters are always replaced.
+	Invalid string
+	// ASCII transliterates letters with accents and other common characters to ASCII, such as é to e and “ to ",
+	// and replaces other characters which aren't ASCII with _.
+	ASCII bool
+	// Windows removes dots and spaces from the ends of names, and renames names reserved by Windows, such as con and com1.
+	Windows bool
+	// MaxName is the longest each part of a path can be, in bytes, or 0 for no limit.
+	// Names which are too long are shortened before their extension.
+	MaxName int
+	// MaxPath is the longest a whole path can be, in bytes, or 0 for no limit.
+	// Paths which are too long are shortened by shortening their filename.
+	MaxPath int
+}
+
+// Filename policies for common file systems.
+var (
+	// FilenamePolicyPOSIX allows any character but /, which separates directories.
+	FilenamePolicyPOSIX = FilenamePolicy{Name: "posix", MaxName: 255}
+	// FilenamePolicyWindows allows names which Windows can open.
+	FilenamePolicyWindows = FilenamePolicy{Name: "windows", Invalid: `\:*?"<>|`, Windows: true, MaxName: 255, MaxPath: 259}
+	// FilenamePolicyFAT32 allows names which the FAT32 SD cards of e-readers can hold, and their firmware can display.
+	FilenamePolicyFAT32 = FilenamePolicy{Name: "fat32", Invalid: `\:*?"<>|+,;=[]`, ASCII: true, Windows: true, MaxName: 255, MaxPath: 255}
+)
+
+// FilenamePolicies are the available filename policies.
+var FilenamePolicies = []FilenamePolicy{FilenamePolicyPOSIX, FilenamePolicyWindows, FilenamePolicyFAT32}
+
+// GetFilenamePolicy returns the filename policy with a name, ignoring case.
+// An empty name returns the zero policy, which leaves filenames unchanged.
+func GetFilenamePolicy(name string) (FilenamePolicy, error) {
+	if name == "" {
+		return FilenamePolicy{}, nil
+	}
+	for _, p := range FilenamePolicies {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return FilenamePolicy{}, errors.Errorf("unknown filename policy %s", name)
+}
+
+// windowsReservedNames are the names Windows reserves for devices, which can't be used with any extension.
+var windowsReservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// Sanitize applies the policy to fn, a path separated by /, such as one generated from an output template.
+func (p FilenamePolicy) Sanitize(fn string) string {
+	parts := strings.Split(fn, "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		part = p.sanitizeName(part)
+		if p.MaxName > 0 {
+			part = shortenName(part, len(part)-p.MaxName, i == len(parts)-1)
+		}
+		parts[i] = part
+	}
+	fn = strings.Join(parts, "/")
+	if p.MaxPath > 0 && len(fn) > p.MaxPath {
+		last := len(parts) - 1
+		parts[last] = shortenName(parts[last], len(fn)-p.MaxPath, true)
+		fn = strings.Join(parts, "/")
+	}
+	return fn
+}
+
+// sanitizeName applies the policy's character rules to one part of a path.
+func (p FilenamePolicy) sanitizeName(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if p.ASCII && r >= utf8.RuneSelf {
+			if t, ok := transliterations[r]; ok {
+				sb.WriteString(p.sanitizeName(t))
+			} else {
+				sb.WriteByte('_')
+			}
+			continue
+		}
+		if r < ' ' || r == 0x7f || strings.ContainsRune(p.Invalid, r) {
+			sb.WriteByte('_')
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	name = sb.String()
+	if p.Windows {
+		name = strings.TrimRight(name, ". ")
+		if name == "" {
+			name = "_"
+		}
+		base := strings.ToLower(name)
+		if i := strings.Index(base, "."); i >= 0 {
+			base = base[:i]
+		}
+		if windowsReservedNames[strings.TrimRight(base, " ")] {
+			name = "_" + name
+		}
+	}
+	return name
+}
+
+// shortenName removes at least excess bytes from name, without splitting characters.
+// If keepExt is true, bytes are removed from just before the extension.
+func shortenName(name string, excess int, keepExt bool) string {
+	if excess <= 0 {
+		return name
+	}
+	ext := ""
+	if keepExt {
+		ext = path.Ext(name)
+	}
+	base := strings.TrimSuffix(name, ext)
+	end := len(base) - excess
+	if end < 1 {
+		// There isn't room, so keep the first character and give up on the limit.
+		_, end = utf8.DecodeRuneInString(base)
+	}
+	if end >= len(base) {
+		return name
+	}
+	for end > 0 && !utf8.RuneStart(base[end]) {
+		end--
+	}
+	return base[:end] + ext
+}
+
+// transliterations are ASCII replacements for common characters which aren't ASCII.
+var transliterations = map[rune]string{
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Æ': "AE", 'Ç': "C",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U", 'Ý': "Y", 'Þ': "Th", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'þ': "th", 'ÿ': "y",
+	'Ā': "A", 'ā': "a", 'Ă': "A", 'ă': "a", 'Ą': "A", 'ą': "a", 'Ć': "C", 'ć': "c", 'Č': "C", 'č': "c",
+	'Ď': "D", 'ď': "d", 'Đ': "D", 'đ': "d", 'Ē': "E", 'ē': "e", 'Ė': "E", 'ė': "e", 'Ę': "E", 'ę': "e", 'Ě': "E", 'ě': "e",
+	'Ğ': "G", 'ğ': "g", 'Ī': "I", 'ī': "i", 'Į': "I", 'į': "i", 'İ': "I", 'ı': "i",
+	'Ł': "L", 'ł': "l", 'Ń': "N", 'ń': "n", 'Ň': "N", 'ň': "n", 'Ō': "O", 'ō': "o", 'Ő': "O", 'ő': "o", 'Œ': "OE", 'œ': "oe",
+	'Ř': "R", 'ř': "r", 'Ś': "S", 'ś': "s", 'Ş': "S", 'ş': "s", 'Š': "S", 'š': "s", 'Ţ': "T", 'ţ': "t", 'Ť': "T", 'ť': "t",
+	'Ū': "U", 'ū': "u", 'Ů': "U", 'ů': "u", 'Ű': "U", 'ű': "u", 'Ų': "U", 'ų': "u",
+	'Ÿ': "Y", 'Ź': "Z", 'ź': "z", 'Ż': "Z", 'ż': "z", 'Ž': "Z", 'ž': "z",
+	'‘': "'", '’': "'", '‚': "'", '“': `"`, '”': `"`, '„': `"`, '«': `"`, '»': `"`,
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '…': "...", '\u00a0': " ",
+}
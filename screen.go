@@ -0,0 +1,97 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFileFlagged is the cause of the error returned when a screener flags a file being imported, such as for containing malware.
+var ErrFileFlagged = errors.New("file flagged by screener")
+
+// A FileScreener checks a file before it's imported, such as by scanning it for malware.
+// Screen returns an error whose cause is ErrFileFlagged if the file shouldn't be imported.
+// Other errors mean the file couldn't be checked, and it isn't imported either.
+type FileScreener interface {
+	Screen(fn string) error
+}
+
+// FileScreenerFunc is an adapter to allow the use of ordinary functions as FileScreeners.
+type FileScreenerFunc func(fn string) error
+
+// Screen calls f(fn).
+func (f FileScreenerFunc) Screen(fn string) error {
+	return f(fn)
+}
+
+// CommandScreener returns a screener which runs an external command, with the path of the file in the BOOKS_FILE environment variable.
+// The command exits with status 0 if the file is clean, and 1 if it's flagged, as clamscan does;
+// its output is used as the reason the file was flagged. Any other status is an error.
+func CommandScreener(name string, args ...string) FileScreener {
+	return FileScreenerFunc(func(fn string) error {
+		cmd := exec.Command(name, args...)
+		cmd.Env = append(os.Environ(), "BOOKS_FILE="+fn)
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		err := cmd.Run()
+		if err == nil {
+			return nil
+		}
+		msg := strings.TrimSpace(output.String())
+		if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 1 {
+			if msg == "" {
+				return errors.Wrapf(ErrFileFlagged, "%s flagged %s", name, fn)
+			}
+			return errors.Wrapf(ErrFileFlagged, "%s flagged %s: %s", name, fn, msg)
+		}
+		if msg != "" {
+			return errors.Wrapf(err, "run %s: %s", name, msg)
+		}
+		return errors.Wrapf(err, "run %s", name)
+	})
+}
+
+// screenFile runs the screeners on a file being imported, stopping at the first error.
+// If a screener flags the file and quarantineDir isn't empty, the file is moved there,
+// so it can be looked at later without being imported again.
+func screenFile(screeners []FileScreener, fn, quarantineDir string) error {
+	for _, s := range screeners {
+		err := s.Screen(fn)
+		if err == nil {
+			continue
+		}
+		if errors.Cause(err) == ErrFileFlagged && quarantineDir != "" {
+			if qerr := quarantineFile(fn, quarantineDir); qerr != nil {
+				log.Printf("Cannot quarantine %s: %s", fn, qerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// quarantineFile moves fn into dir, with a unique name.
+func quarantineFile(fn, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	dst, err := GetUniqueName(filepath.Join(dir, filepath.Base(fn)), "")
+	if err != nil {
+		return err
+	}
+	if err := moveFile(fn, dst, nil); err != nil {
+		return err
+	}
+	log.Printf("Quarantined %s in %s", fn, dst)
+	return nil
+}
@@ -0,0 +1,333 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SearchOptions controls paging and ranking of search results.
+type SearchOptions struct {
+	// Offset is the number of results to skip.
+	Offset int
+	// Limit is the maximum number of results to return. Set to 0 to return all results.
+	Limit int
+	// MoreResultsLimit is the maximum number of additional results to count past Limit.
+	MoreResultsLimit int
+	// BoostTitleMatches ranks books whose title exactly matches the query above all other results,
+	// followed by books whose title starts with the query.
+	// The title query is made up of all terms not limited to a field other than title.
+	BoostTitleMatches bool
+	// Log records the search and how many books it found, for SearchAnalytics.
+	// Only searches for the first page of results are recorded, and at most Limit+MoreResultsLimit books are counted.
+	Log bool
+}
+
+// SearchResult is a book matching a search, with details of how it matched.
+type SearchResult struct {
+	Book Book
+	// Score is how relevant the book is to the search, with higher scores being more relevant.
+	// It's the number of times search terms matched the book, with matches in the title, authors, and series counting more.
+	Score float64
+	// MatchedFields are the search fields which matched, such as title or author, in the order the fields are listed in Search.
+	MatchedFields []string
+	// Snippet is an excerpt of the best matching field, with matched terms surrounded by [ and ].
+	Snippet string
+}
+
+// searchFieldWeights is how much a match in each field adds to a result's score.
+// Fields not listed have a weight of 1.
+var searchFieldWeights = map[string]float64{"title": 4, "author": 3, "series": 2, "original_title": 2, "alternate_titles": 3, "subtitle": 2}
+
+// Search searches the library for books.
+// By default, all fields are searched, but
+// field:terms+to+search will limit to that field only.
+// The fields are those returned by SearchFields, which are chosen from AllSearchFields.
+// works holds the titles and authors of works contained in a book's files, such as the stories in an anthology.
+// editor, translator, narrator, and illustrator hold the names of the book's contributors in those roles.
+// original_title and original_language hold the title and language code a translated book was first published in.
+// alternate_titles holds the other titles a book is known by, such as its subtitle, added with AddAlternateTitle.
+// subtitle holds the book's subtitle.
+// fandom, ship and url hold the fandoms, ships and source URL of fanfiction.
+// Results can also be filtered by when books were added, the sizes of their files, their ratings, and their word counts,
+// with added:>2024-01-01, size:<5mb, rating:>=4, or words:>50000.
+// The operators <, <=, >, >= and = are supported, and a filter without one matches exactly.
+// license:public-domain, license:cc, license:purchased and license:unknown filter by license,
+// and license:shareable finds public domain and Creative Commons books.
+// Terms with synonyms, added with AddSynonym, also match their expansions.
+// Example: author:Stephen+King title:Shining
+// Books with titles matching the search terms are returned first.
+func (lib *Library) Search(terms string) ([]Book, error) {
+	results, _, err := lib.SearchWithOptions(terms, SearchOptions{BoostTitleMatches: true})
+	if err != nil {
+		return nil, err
+	}
+	return ResultBooks(results), nil
+}
+
+// SearchPaged implements book searching, both paged and non paged.
+// Set limit to 0 to return all results.
+// moreResults will be set to the number of additional results not returned, with a maximum of moreResultsLimit.
+func (lib *Library) SearchPaged(terms string, offset, limit, moreResultsLimit int) (results []SearchResult, moreResults int, err error) {
+	return lib.SearchWithOptions(terms, SearchOptions{Offset: offset, Limit: limit, MoreResultsLimit: moreResultsLimit})
+}
+
+// SearchWithOptions searches the library for books, as described in Search, with paging and ranking controlled by opts.
+// moreResults will be set to the number of additional results not returned, with a maximum of opts.MoreResultsLimit.
+func (lib *Library) SearchWithOptions(terms string, opts SearchOptions) (results []SearchResult, moreResults int, err error) {
+	var hits []searchHit
+	if opts.BoostTitleMatches {
+		hits, err = lib.searchRanked(terms, opts)
+	} else {
+		hits, err = lib.search(terms, opts)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if opts.Log && opts.Offset == 0 {
+		lib.logSearch(terms, len(hits))
+	}
+
+	if opts.Limit > 0 && len(hits) > opts.Limit {
+		moreResults = len(hits) - opts.Limit
+		hits = hits[:opts.Limit]
+	}
+	ids := make([]int64, len(hits))
+	for i, h := range hits {
+		ids[i] = h.id
+	}
+	bookMap, err := lib.GetBooksByIDMap(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results = []SearchResult{}
+	for _, h := range hits {
+		book, ok := bookMap[h.id]
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{Book: book, Score: h.score, MatchedFields: h.fields, Snippet: h.snippet})
+	}
+	return results, moreResults, nil
+}
+
+// ResultBooks returns the books from a list of search results.
+func ResultBooks(results []SearchResult) []Book {
+	books := make([]Book, len(results))
+	for i, r := range results {
+		books[i] = r.Book
+	}
+	return books
+}
+
+// searchHit is a row returned by the search index.
+type searchHit struct {
+	id      int64
+	title   string
+	score   float64
+	fields  []string
+	snippet string
+}
+
+// searchHitColumns selects the columns of a searchHit from books_fts.
+const searchHitColumns = `docid, title, offsets(books_fts), snippet(books_fts, '[', ']', '...', -1, 16)`
+
+// scanSearchHits reads search hits from rows selecting searchHitColumns, from a search index with fields.
+func scanSearchHits(rows *sql.Rows, fields []string) ([]searchHit, error) {
+	var hits []searchHit
+	for rows.Next() {
+		var h searchHit
+		var offsets string
+		if err := rows.Scan(&h.id, &h.title, &offsets, &h.snippet); err != nil {
+			return nil, errors.Wrap(err, "Scanning search results")
+		}
+		h.score, h.fields = scoreOffsets(offsets, fields)
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Retrieving search results from db")
+	}
+	return hits, nil
+}
+
+// scoreOffsets calculates a score and the matched fields from the output of the FTS offsets function,
+// which is made up of four integers for each match: column, term, byte offset, and size.
+// searchFields are the fields of the search index, in order.
+func scoreOffsets(offsets string, searchFields []string) (float64, []string) {
+	values := strings.Fields(offsets)
+	matched := make([]bool, len(searchFields))
+	var score float64
+	for i := 0; i+3 < len(values); i += 4 {
+		col, err := strconv.Atoi(values[i])
+		if err != nil || col < 0 || col >= len(searchFields) {
+			continue
+		}
+		matched[col] = true
+		if w, ok := searchFieldWeights[searchFields[col]]; ok {
+			score += w
+		} else {
+			score++
+		}
+	}
+	var fields []string
+	for i, m := range matched {
+		if m {
+			fields = append(fields, searchFields[i])
+		}
+	}
+	return score, fields
+}
+
+// search returns the books matching terms in the order returned by the search index,
+// limited to opts.Limit+opts.MoreResultsLimit results starting at opts.Offset.
+func (lib *Library) search(terms string, opts SearchOptions) ([]searchHit, error) {
+	terms, filters, err := parseSearchFilters(terms)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := searchIndexFields(lib.DB)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := lib.expandQuery(terms)
+	if err != nil {
+		return nil, err
+	}
+	query, args := searchQuery(expanded, filters)
+	if opts.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, opts.Limit+opts.MoreResultsLimit, opts.Offset)
+	}
+
+	rows, err := lib.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Querying db for search terms")
+	}
+	defer rows.Close()
+	return scanSearchHits(rows, fields)
+}
+
+// searchRanked is like search, but exact and prefix title matches are moved before all other results.
+// Since ranking needs every match, paging is done after the results are ranked.
+func (lib *Library) searchRanked(terms string, opts SearchOptions) ([]searchHit, error) {
+	terms, filters, err := parseSearchFilters(terms)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := searchIndexFields(lib.DB)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := lib.expandQuery(terms)
+	if err != nil {
+		return nil, err
+	}
+	query, args := searchQuery(expanded, filters)
+	rows, err := lib.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Querying db for search terms")
+	}
+	defer rows.Close()
+	hits, err := scanSearchHits(rows, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	titleQuery := normalizeTitle(titleTerms(terms))
+	alternateTitles, err := lib.hitAlternateTitles(hits, titleQuery)
+	if err != nil {
+		return nil, err
+	}
+	ranks := make(map[int64]int, len(hits))
+	for _, h := range hits {
+		rank := titleRank(normalizeTitle(h.title), titleQuery)
+		// Books known by the title are ranked as if it were theirs.
+		for _, t := range alternateTitles[h.id] {
+			if r := titleRank(normalizeTitle(t.Title), titleQuery); r < rank {
+				rank = r
+			}
+		}
+		ranks[h.id] = rank
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return ranks[hits[i].id] < ranks[hits[j].id] })
+
+	if opts.Offset >= len(hits) {
+		return nil, nil
+	}
+	hits = hits[opts.Offset:]
+	if opts.Limit > 0 && len(hits) > opts.Limit+opts.MoreResultsLimit {
+		hits = hits[:opts.Limit+opts.MoreResultsLimit]
+	}
+	return hits, nil
+}
+
+// hitAlternateTitles returns the alternate titles of the books in hits, by book ID, if they're needed to rank them against titleQuery.
+func (lib *Library) hitAlternateTitles(hits []searchHit, titleQuery string) (map[int64][]AlternateTitle, error) {
+	if titleQuery == "" || len(hits) == 0 {
+		return nil, nil
+	}
+	ids := make([]int64, len(hits))
+	for i, h := range hits {
+		ids[i] = h.id
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	m, err := getAlternateTitlesByBookIds(tx, ids)
+	return m, errors.Wrap(err, "get alternate titles of results")
+}
+
+// titleRank ranks a title against a title query: 0 for an exact match, 1 for a prefix match, and 2 otherwise.
+func titleRank(title, query string) int {
+	if query == "" {
+		return 2
+	}
+	if title == query {
+		return 0
+	}
+	if strings.HasPrefix(title, query) {
+		return 1
+	}
+	return 2
+}
+
+// titleTerms returns the parts of a search query which could refer to a title:
+// terms without a field, and terms limited to the title field.
+// Plus signs used to join words in a field are replaced with spaces.
+func titleTerms(terms string) string {
+	var parts []string
+	for _, term := range strings.Fields(terms) {
+		if i := strings.Index(term, ":"); i >= 0 {
+			if strings.ToLower(term[:i]) != "title" {
+				continue
+			}
+			term = term[i+1:]
+		}
+		switch strings.ToUpper(term) {
+		case "AND", "OR", "NOT", "NEAR":
+			continue
+		}
+		if strings.HasPrefix(term, "-") {
+			continue
+		}
+		parts = append(parts, strings.Replace(term, "+", " ", -1))
+	}
+	return strings.Join(parts, " ")
+}
+
+// normalizeTitle lowercases a title, removes quotes and wildcards, and collapses whitespace, for comparing titles against queries.
+func normalizeTitle(title string) string {
+	title = strings.ToLower(title)
+	title = strings.NewReplacer(`"`, "", "*", "").Replace(title)
+	return strings.Join(strings.Fields(title), " ")
+}
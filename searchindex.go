@@ -0,0 +1,286 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AllSearchFields are the fields which can be indexed for searching, in the order they're stored in the search index.
+// editor, translator, narrator and illustrator hold the names of a book's contributors in those roles,
+// tags holds the tags of a book and of its files,
+// works holds the titles and authors of the works contained in its files,
+// filename holds the current filenames of its files, relative to the books root,
+// alternate_titles holds the other titles it's known by, and subtitle holds its subtitle.
+// fandom, ship and url hold the fandoms, ships and source URL of fanfiction.
+var AllSearchFields = []string{"author", "series", "title", "extension", "tags", "filename", "source", "publisher", "works",
+	RoleEditor, RoleTranslator, RoleNarrator, RoleIllustrator, "original_title", "original_language", "alternate_titles", "subtitle",
+	"fandom", "ship", "url"}
+
+// DefaultSearchFields are the fields indexed in new libraries, unless others are chosen when the library is created.
+var DefaultSearchFields = AllSearchFields
+
+// searchFieldSources maps each of AllSearchFields to an SQL expression for the text indexed in it, for the book b.
+// Entries are always rebuilt from these, rather than changed, so they only hold what the book has now.
+// Extensions and sources are indexed once each, however many files have them.
+var searchFieldSources = map[string]string{
+	"author":            sqlIndexedContributors(RoleAuthor, "b.id"),
+	"series":            "coalesce(b.series, '')",
+	"title":             "b.title",
+	"extension":         "(select coalesce(group_concat(distinct extension), '') from files where book_id = b.id)",
+	"tags":              "(select coalesce(group_concat(t.name, ' '), '') from tags t where t.id in (select tag_id from books_tags where book_id = b.id union select ft.tag_id from files f join files_tags ft on ft.file_id = f.id where f.book_id = b.id))",
+	"filename":          "(select coalesce(group_concat(filename, ' '), '') from files where book_id = b.id)",
+	"source":            "(select coalesce(group_concat(distinct source), '') from files where book_id = b.id)",
+	"publisher":         "b.publisher",
+	"works":             sqlIndexedWorks("b.id"),
+	RoleEditor:          sqlIndexedContributors(RoleEditor, "b.id"),
+	RoleTranslator:      sqlIndexedContributors(RoleTranslator, "b.id"),
+	RoleNarrator:        sqlIndexedContributors(RoleNarrator, "b.id"),
+	RoleIllustrator:     sqlIndexedContributors(RoleIllustrator, "b.id"),
+	"original_title":    "b.original_title",
+	"original_language": "b.original_language",
+	"alternate_titles":  sqlIndexedAlternateTitles("b.id"),
+	"subtitle":          "b.subtitle",
+	"fandom":            sqlIndexedFanficTags(fanficFandom, "b.id"),
+	"ship":              sqlIndexedFanficTags(fanficShip, "b.id"),
+	"url":               "b.source_url",
+}
+
+// queryer runs queries, and is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// rowQueryer runs queries returning one row, and is implemented by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// searchIndexFields returns the fields in the search index, in order.
+func searchIndexFields(q queryer) ([]string, error) {
+	rows, err := q.Query("pragma table_info(books_fts)")
+	if err != nil {
+		return nil, errors.Wrap(err, "get search index fields")
+	}
+	defer rows.Close()
+	var fields []string
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return nil, errors.Wrap(err, "get search index fields")
+		}
+		fields = append(fields, name)
+	}
+	return fields, errors.Wrap(rows.Err(), "get search index fields")
+}
+
+// normalizeSearchFields checks that fields can be indexed, and returns them without duplicates, in the order of AllSearchFields.
+// title is always needed, since search results are ranked by it.
+func normalizeSearchFields(fields []string) ([]string, error) {
+	wanted := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if _, ok := searchFieldSources[f]; !ok {
+			return nil, errors.Errorf("unknown search field %s", f)
+		}
+		wanted[f] = true
+	}
+	if !wanted["title"] {
+		return nil, errors.New("the title field must be indexed")
+	}
+	var result []string
+	for _, f := range AllSearchFields {
+		if wanted[f] {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+// sqlSearchFieldSources returns the columns for fields, and the SQL expressions for their text, for the book b.
+// The text of each field is followed by the expansions of the index time synonyms found in it.
+func sqlSearchFieldSources(fields []string) (columns, sources string) {
+	exprs := make([]string, len(fields))
+	for i, f := range fields {
+		exprs[i] = sqlWithIndexSynonyms(searchFieldSources[f])
+	}
+	return strings.Join(fields, ", "), strings.Join(exprs, ", ")
+}
+
+// sqlCreateSearchIndex returns SQL which recreates the search index with fields, and indexes every book in it.
+func sqlCreateSearchIndex(fields []string) string {
+	columns, sources := sqlSearchFieldSources(fields)
+	return `drop table if exists books_fts_terms;
+drop table if exists books_fts;
+create virtual table books_fts using fts4 (` + columns + `);
+insert into books_fts (docid, ` + columns + `) select b.id, ` + sources + ` from books b;
+create virtual table books_fts_terms using fts4aux(books_fts);
+` + sqlCreateSearchTriggers(fields)
+}
+
+// sqlCreateSearchTriggers returns SQL which recreates the triggers that reindex books when their files are deleted or moved,
+// for a search index with fields.
+func sqlCreateSearchTriggers(fields []string) string {
+	columns, sources := sqlSearchFieldSources(fields)
+	return `drop trigger if exists books_fts_delete_file;
+drop trigger if exists books_fts_move_file;
+create trigger books_fts_delete_file after delete on files begin
+	delete from books_fts where docid = old.book_id;
+	insert into books_fts (docid, ` + columns + `) select b.id, ` + sources + ` from books b where b.id = old.book_id;
+end;
+create trigger books_fts_move_file after update of book_id on files when old.book_id != new.book_id begin
+	delete from books_fts where docid in (old.book_id, new.book_id);
+	insert into books_fts (docid, ` + columns + `) select b.id, ` + sources + ` from books b where b.id in (old.book_id, new.book_id);
+end;`
+}
+
+// indexBook indexes a book for searching, replacing its existing search index entry.
+func indexBook(tx *sql.Tx, bookID int64) error {
+	fields, err := searchIndexFields(tx)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("delete from books_fts where docid=?", bookID); err != nil {
+		return errors.Wrap(err, "delete search index entry")
+	}
+	columns, sources := sqlSearchFieldSources(fields)
+	if _, err := tx.Exec("insert into books_fts (docid, "+columns+") select b.id, "+sources+" from books b where b.id=?", bookID); err != nil {
+		return errors.Wrap(err, "index book")
+	}
+	return nil
+}
+
+// SearchFields returns the fields indexed for searching, in order.
+func (lib *Library) SearchFields() ([]string, error) {
+	return searchIndexFields(lib.DB)
+}
+
+// SetSearchFields changes the fields indexed for searching.
+// If they're different from the fields already indexed, the search index is regenerated.
+// title must be one of the fields.
+func (lib *Library) SetSearchFields(fields []string) error {
+	fields, err := normalizeSearchFields(fields)
+	if err != nil {
+		return err
+	}
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	existing, err := searchIndexFields(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if stringSlicesEqual(existing, fields, false) {
+		tx.Rollback()
+		return nil
+	}
+	if _, err := tx.Exec(sqlCreateSearchIndex(fields)); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "regenerate search index")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	log.Printf("Regenerated search index with fields: %s", strings.Join(fields, ", "))
+	return nil
+}
+
+// RegenerateSearchIndex rebuilds the search index from the books in the library, keeping the fields already indexed.
+func (lib *Library) RegenerateSearchIndex() error {
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	if err := rebuildSearchIndex(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// rebuildSearchIndex rebuilds the search index from the books in the library, keeping the fields already indexed.
+func rebuildSearchIndex(tx *sql.Tx) error {
+	fields, err := searchIndexFields(tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(sqlCreateSearchIndex(fields))
+	return errors.Wrap(err, "regenerate search index")
+}
+
+// SearchIndexSize describes how much space the search index takes up.
+type SearchIndexSize struct {
+	// Segments is the number of b-trees the index is split into.
+	// Each change to the index adds one, until they're merged, and searches read all of them.
+	Segments int
+	// Bytes is the size of the index's segments.
+	Bytes int64
+}
+
+// SearchIndexSize returns the size of the search index.
+func (lib *Library) SearchIndexSize() (SearchIndexSize, error) {
+	return searchIndexSize(lib.DB)
+}
+
+func searchIndexSize(q rowQueryer) (SearchIndexSize, error) {
+	var size SearchIndexSize
+	var rootBytes, blockBytes int64
+	if err := q.QueryRow("select count(*), coalesce(sum(length(root)), 0) from books_fts_segdir").Scan(&size.Segments, &rootBytes); err != nil {
+		return size, errors.Wrap(err, "get search index size")
+	}
+	if err := q.QueryRow("select coalesce(sum(length(block)), 0) from books_fts_segments").Scan(&blockBytes); err != nil {
+		return size, errors.Wrap(err, "get search index size")
+	}
+	size.Bytes = rootBytes + blockBytes
+	return size, nil
+}
+
+// OptimizeSearch merges the segments of the search index into one, and returns its size before and after.
+// Libraries which change often, such as by importing and deleting many files, build up segments which slow searches down.
+// Optimizing a large index takes a while, and the space it frees is returned to the file system by vacuuming, such as with Compact.
+func (lib *Library) OptimizeSearch() (before, after SearchIndexSize, err error) {
+	unlock, err := lib.lock()
+	if err != nil {
+		return before, after, err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return before, after, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	if before, err = searchIndexSize(tx); err != nil {
+		return before, after, err
+	}
+	if _, err := tx.Exec("insert into books_fts(books_fts) values('optimize')"); err != nil {
+		return before, after, errors.Wrap(err, "optimize search index")
+	}
+	if after, err = searchIndexSize(tx); err != nil {
+		return before, after, err
+	}
+	if err := tx.Commit(); err != nil {
+		return before, after, errors.Wrap(err, "commit")
+	}
+	log.Printf("Optimized search index from %d segments of %d bytes to %d segments of %d bytes", before.Segments, before.Bytes, after.Segments, after.Bytes)
+	return before, after, nil
+}
@@ -0,0 +1,97 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QueryCount is a search, and how often it was made.
+type QueryCount struct {
+	Query string
+	// Count is the number of times the query was searched for.
+	Count int
+	// Results is the number of books the query found the last time it was searched for.
+	Results      int
+	LastSearched time.Time
+}
+
+// SearchAnalytics summarizes the searches recorded with SearchOptions.Log.
+type SearchAnalytics struct {
+	// Searches is the number of searches recorded.
+	Searches int
+	// TopQueries are the most common searches, most common first.
+	TopQueries []QueryCount
+	// ZeroResultQueries are the most common searches which found nothing the last time they were made, most common first.
+	// They're books people keep looking for which aren't in the library.
+	ZeroResultQueries []QueryCount
+}
+
+// logSearch records a search, and how many books it found.
+// Failing to record it doesn't fail the search, so the error is only logged.
+func (lib *Library) logSearch(terms string, results int) {
+	if terms = collapseSpace(terms); terms == "" {
+		return
+	}
+	if _, err := lib.Exec("insert into search_log (query, results) values(?, ?)", terms, results); err != nil {
+		log.Printf("Error logging search for %s: %s", terms, err)
+	}
+}
+
+// SearchAnalytics summarizes the searches recorded after since, returning up to limit of the top queries and zero result queries.
+// Queries which differ only in case are counted together.
+func (lib *Library) SearchAnalytics(since time.Time, limit int) (SearchAnalytics, error) {
+	var a SearchAnalytics
+	tx, err := lib.Begin()
+	if err != nil {
+		return a, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	after := since.UTC().Format("2006-01-02 15:04:05")
+	if err := tx.QueryRow("select count(*) from search_log where searched_on > ?", after).Scan(&a.Searches); err != nil {
+		return a, errors.Wrap(err, "count searches")
+	}
+	// The latest search for each query has its result count and time.
+	query := `select q.query, q.n, l.results, l.searched_on from
+(select query, count(*) n, max(id) last_id from search_log where searched_on > ? group by query) q join search_log l on l.id = q.last_id
+where ? or l.results = 0 order by q.n desc, q.last_id desc limit ?`
+	for _, zero := range []bool{false, true} {
+		rows, err := tx.Query(query, after, !zero, limit)
+		if err != nil {
+			return a, errors.Wrap(err, "get searches")
+		}
+		var counts []QueryCount
+		for rows.Next() {
+			var c QueryCount
+			if err := rows.Scan(&c.Query, &c.Count, &c.Results, &c.LastSearched); err != nil {
+				rows.Close()
+				return a, errors.Wrap(err, "get searches")
+			}
+			counts = append(counts, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return a, errors.Wrap(err, "get searches")
+		}
+		if zero {
+			a.ZeroResultQueries = counts
+		} else {
+			a.TopQueries = counts
+		}
+	}
+	return a, nil
+}
+
+// ClearSearchLog removes the searches recorded before the given time, and returns how many were removed.
+func (lib *Library) ClearSearchLog(before time.Time) (int64, error) {
+	res, err := lib.Exec("delete from search_log where searched_on < ?", before.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, errors.Wrap(err, "clear search log")
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tspivey/books"
+)
+
+// maxFeedItems is the number of events in the activity RSS feed.
+const maxFeedItems = 50
+
+// Activity is an event in the library's activity feed.
+type Activity struct {
+	ID     int64  `json:"id"`
+	Time   string `json:"time"`
+	Kind   string `json:"kind"`
+	BookID int64  `json:"book_id"`
+	FileID int64  `json:"file_id"`
+	User   string `json:"user"`
+	Detail string `json:"detail"`
+	// Summary describes the event in a sentence, such as "Rated Ancillary Justice 4 of 5".
+	Summary string `json:"summary"`
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string  `xml:"title"`
+	Link    string  `xml:"link"`
+	GUID    rssGUID `xml:"guid"`
+	PubDate string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// activitySummary describes an event in a sentence, using the book it happened to.
+func activitySummary(e books.ActivityEvent, book books.Book) string {
+	name := book.Title
+	if len(book.Authors) > 0 {
+		name += " by " + books.JoinNaturally("and", book.Authors)
+	}
+	switch e.Kind {
+	case books.ActivityImport:
+		return "Imported " + name
+	case books.ActivityFinish:
+		return e.User + " finished " + name
+	case books.ActivityRating:
+		if e.Detail == "0" {
+			return "Cleared the rating of " + name
+		}
+		return fmt.Sprintf("Rated %s %s of %d", name, e.Detail, books.MaxRating)
+	case books.ActivityEdit:
+		return "Edited the " + e.Detail + " of " + name
+	}
+	return string(e.Kind) + " " + name
+}
+
+// activity returns the events after since, newest first, with the books they happened to.
+// Set limit to 0 to return all of them.
+func (srv *Server) activity(since time.Time, limit int) ([]books.ActivityEvent, map[int64]books.Book, error) {
+	events, err := srv.lib.Activity(since, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ids []int64
+	for _, e := range events {
+		ids = append(ids, e.BookID)
+	}
+	bookMap, err := srv.lib.GetBooksByIDMap(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, bookMap, nil
+}
+
+// apiActivityHandler returns the activity feed, newest first.
+// since is an RFC 3339 time, and only events after it are returned. limit is the maximum number of events.
+func (srv *Server) apiActivityHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since time.Time
+	if s := q.Get("since"); s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid since time"})
+			return
+		}
+	}
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid limit"})
+			return
+		}
+	}
+	events, bookMap, err := srv.activity(since, limit)
+	if err != nil {
+		log.Printf("error getting activity: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting activity"})
+		return
+	}
+	result := make([]Activity, 0)
+	for _, e := range events {
+		result = append(result, Activity{e.ID, e.Time.UTC().Format(time.RFC3339), string(e.Kind), e.BookID, e.FileID, e.User, e.Detail,
+			activitySummary(e, bookMap[e.BookID])})
+	}
+	writeJSON(w, result)
+}
+
+// activityFeedHandler serves the most recent events in the activity feed as RSS, linking to the books they happened to.
+func (srv *Server) activityFeedHandler(w http.ResponseWriter, r *http.Request) {
+	events, bookMap, err := srv.activity(time.Time{}, maxFeedItems)
+	if err != nil {
+		log.Printf("Error getting activity: %s", err)
+		http.Error(w, "error getting activity", http.StatusInternalServerError)
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host
+	feed := rss{Version: "2.0", Channel: rssChannel{Title: "Library activity", Link: base + "/", Description: "Books imported, finished, rated and edited"}}
+	for _, e := range events {
+		link := fmt.Sprintf("%s/book/%d", base, e.BookID)
+		if b, ok := bookMap[e.BookID]; ok {
+			link = base + "/b/" + b.ShortID()
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:   activitySummary(e, bookMap[e.BookID]),
+			Link:    link,
+			GUID:    rssGUID{Value: fmt.Sprintf("activity-%d", e.ID)},
+			PubDate: e.Time.UTC().Format(time.RFC1123Z),
+		})
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("Error writing activity feed: %s", err)
+	}
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// adminPrefix is the path the admin UI is served under.
+const adminPrefix = "/admin"
+
+// maxUploadMemory is the size of an uploaded book kept in memory while it's received. Larger files are written to disk.
+const maxUploadMemory = 32 << 20
+
+// adminSorts are the orders books can be listed in by the admin UI.
+var adminSorts = []books.BookSort{books.SortByID, books.SortByTitle, books.SortByAuthor, books.SortBySeries, books.SortByAdded, books.SortByFileAdded}
+
+// adminBooks is a page of the list of books, or of the results of a search, in the admin UI.
+type adminBooks struct {
+	Books []books.Book
+	// Total is the number of books listed on every page.
+	Total      int
+	Query      string
+	Sort       books.BookSort
+	Sorts      []books.BookSort
+	Descending bool
+	PageNumber int
+	Prev       int
+	Next       int
+	// Path is the page the books are shown on, for links to the other pages.
+	Path string
+	// ShowIDs is true if the books' IDs are listed, which they aren't if the server only allows short IDs.
+	ShowIDs bool
+}
+
+// adminBook is a book being edited in the admin UI.
+type adminBook struct {
+	Book    books.Book
+	Message string
+	Error   string
+}
+
+// adminUpload is the form for uploading a book in the admin UI.
+type adminUpload struct {
+	Title   string
+	Authors string
+	Series  string
+	Tags    string
+	Error   string
+}
+
+// addAdminRoutes adds the routes of the admin UI, for browsing, editing and uploading books from a web browser.
+func (srv *Server) addAdminRoutes(r *mux.Router) {
+	r.Handle(adminPrefix, http.RedirectHandler(adminPrefix+"/", http.StatusMovedPermanently))
+	r.HandleFunc(adminPrefix+"/", srv.adminBooksHandler)
+	r.HandleFunc(adminPrefix+"/covers", srv.adminCoversHandler)
+	if !srv.shortIDsOnly {
+		r.HandleFunc(adminPrefix+`/book/{id:\d+}`, srv.adminEditHandler).Methods("GET")
+		r.HandleFunc(adminPrefix+`/book/{id:\d+}`, srv.adminSaveHandler).Methods("POST")
+	}
+	r.HandleFunc(adminPrefix+"/b/{short:"+shortIDPattern+"}", srv.adminEditHandler).Methods("GET")
+	r.HandleFunc(adminPrefix+"/b/{short:"+shortIDPattern+"}", srv.adminSaveHandler).Methods("POST")
+	r.HandleFunc(adminPrefix+"/upload", srv.adminUploadFormHandler).Methods("GET")
+	r.HandleFunc(adminPrefix+"/upload", srv.adminUploadHandler).Methods("POST")
+}
+
+// adminBookList returns the page of books asked for by a request, with the query, sort and page parameters.
+// Without a query, every book is listed. Search results are in order of relevance unless a sort is given.
+func (srv *Server) adminBookList(r *http.Request) (adminBooks, error) {
+	q := r.URL.Query()
+	res := adminBooks{
+		Query:      strings.TrimSpace(q.Get("query")),
+		Sort:       books.BookSort(q.Get("sort")),
+		Sorts:      adminSorts,
+		ShowIDs:    !srv.shortIDsOnly,
+		Descending: q.Get("desc") != "",
+		PageNumber: 1,
+		Path:       r.URL.Path,
+	}
+	if page, err := strconv.Atoi(q.Get("page")); err == nil && page >= 1 {
+		res.PageNumber = page
+	}
+
+	var ids []int64
+	var err error
+	if res.Query == "" {
+		if res.Sort == "" {
+			res.Sort = books.SortByID
+		}
+		if ids, err = srv.lib.ListBookIDs(res.Sort, res.Descending); err != nil {
+			return res, err
+		}
+	} else {
+		found, _, err := srv.lib.SearchWithOptions(res.Query, books.SearchOptions{BoostTitleMatches: true})
+		if err != nil {
+			return res, err
+		}
+		for _, result := range found {
+			ids = append(ids, result.Book.ID)
+		}
+		if res.Sort != "" {
+			if ids, err = srv.lib.SortBookIDs(ids, res.Sort, res.Descending); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	res.Total = len(ids)
+	offset := (res.PageNumber - 1) * srv.itemsPerPage
+	if offset >= len(ids) {
+		ids = nil
+	} else {
+		ids = ids[offset:]
+		if len(ids) > srv.itemsPerPage {
+			ids = ids[:srv.itemsPerPage]
+			res.Next = res.PageNumber + 1
+		}
+	}
+	res.Prev = res.PageNumber - 1
+	res.Books, err = srv.lib.GetBooksByID(ids)
+	return res, err
+}
+
+// adminBooksHandler lists books, or the results of a search, with links to edit them.
+func (srv *Server) adminBooksHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := srv.adminBookList(r)
+	if err != nil {
+		log.Printf("Error listing books: %s", err)
+		srv.render("admin_error", w, errorPage{"Cannot list books", err.Error()})
+		return
+	}
+	srv.render("admin_books", w, res)
+}
+
+// adminCoversHandler shows books, or the results of a search, as a grid of covers.
+func (srv *Server) adminCoversHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := srv.adminBookList(r)
+	if err != nil {
+		log.Printf("Error listing books: %s", err)
+		srv.render("admin_error", w, errorPage{"Cannot list books", err.Error()})
+		return
+	}
+	srv.render("admin_covers", w, res)
+}
+
+// adminGetBook returns the book whose ID or short ID is in the path of a request.
+// If there isn't one, an error page is rendered, and ok is false.
+func (srv *Server) adminGetBook(w http.ResponseWriter, r *http.Request) (book books.Book, ok bool) {
+	id, ok := srv.requestBookID(r)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		srv.render("admin_error", w, errorPage{"Book not found", "That book doesn't exist in the library."})
+		return book, false
+	}
+	bookList, err := srv.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting book %d: %s", id, err)
+		srv.render("admin_error", w, errorPage{"Cannot get book", "An error occurred while getting the book."})
+		return book, false
+	}
+	if len(bookList) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		srv.render("admin_error", w, errorPage{"Book not found", "That book doesn't exist in the library."})
+		return book, false
+	}
+	return bookList[0], true
+}
+
+// adminEditHandler shows a form for editing the metadata of a book.
+func (srv *Server) adminEditHandler(w http.ResponseWriter, r *http.Request) {
+	book, ok := srv.adminGetBook(w, r)
+	if !ok {
+		return
+	}
+	res := adminBook{Book: book}
+	if r.URL.Query().Get("saved") != "" {
+		res.Message = "Saved."
+	}
+	srv.render("admin_edit", w, res)
+}
+
+// adminSaveHandler saves the metadata of a book posted from the edit form.
+// Authors are one per line, and tags are separated by commas.
+func (srv *Server) adminSaveHandler(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	srv.apiLock.Lock()
+	defer srv.apiLock.Unlock()
+	book, ok := srv.adminGetBook(w, r)
+	if !ok {
+		return
+	}
+	book.Title = strings.TrimSpace(r.PostFormValue("title"))
+	book.Subtitle = strings.TrimSpace(r.PostFormValue("subtitle"))
+	book.Authors = splitLines(r.PostFormValue("authors"))
+	book.Series = strings.TrimSpace(r.PostFormValue("series"))
+	book.Publisher = strings.TrimSpace(r.PostFormValue("publisher"))
+	book.Description = strings.Replace(strings.TrimSpace(r.PostFormValue("description")), "\r\n", "\n", -1)
+	book.Language = strings.TrimSpace(r.PostFormValue("language"))
+	published, publishedErr := books.NormalizeDate(r.PostFormValue("published"))
+	book.Published = published
+	book.OriginalTitle = strings.TrimSpace(r.PostFormValue("original_title"))
+	book.OriginalLanguage = strings.TrimSpace(r.PostFormValue("original_language"))
+	book.Tags = splitCommas(r.PostFormValue("tags"))
+	book.Fandoms = splitCommas(r.PostFormValue("fandoms"))
+	book.Ships = splitCommas(r.PostFormValue("ships"))
+	book.WordCount = books.ParseWordCount(r.PostFormValue("word_count"))
+	book.SourceURL = strings.TrimSpace(r.PostFormValue("source_url"))
+	license, licenseErr := books.NormalizeLicense(r.PostFormValue("license"))
+	book.License = license
+	res := adminBook{Book: book}
+	if book.Title == "" || len(book.Authors) == 0 {
+		res.Error = "A book needs a title and at least one author."
+	} else if licenseErr != nil {
+		res.Error = "The license must be public domain, cc, a Creative Commons license such as cc-by-sa, purchased or unknown."
+	} else if publishedErr != nil {
+		res.Error = "The publication date must be a year, such as 1999, a year and month, such as 1999-05, or a date, such as 1999-05-17."
+	}
+	if res.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		srv.render("admin_edit", w, res)
+		return
+	}
+	err := srv.lib.UpdateBook(book, srv.outputTemplate, true)
+	if bee, ok := err.(books.BookExistsError); ok {
+		res.Error = fmt.Sprintf("Another book, %s, already has that title and those authors. Merge them instead.", srv.bookRef(bee.BookID))
+		w.WriteHeader(http.StatusConflict)
+		srv.render("admin_edit", w, res)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating book %d: %s", book.ID, err)
+		res.Error = "An error occurred while saving the book."
+		w.WriteHeader(http.StatusInternalServerError)
+		srv.render("admin_edit", w, res)
+		return
+	}
+	http.Redirect(w, r, adminPrefix+"/b/"+book.ShortID()+"?saved=1", http.StatusSeeOther)
+}
+
+// adminUploadFormHandler shows the form for uploading a book.
+func (srv *Server) adminUploadFormHandler(w http.ResponseWriter, r *http.Request) {
+	srv.render("admin_upload", w, adminUpload{})
+}
+
+// adminUploadHandler imports an uploaded book into the library, then shows it in the edit form.
+// Metadata is parsed from the file by the configured metadata parser, and the fields filled in on the form replace it.
+func (srv *Server) adminUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		srv.render("admin_upload", w, adminUpload{Error: "The upload couldn't be read."})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	form := adminUpload{
+		Title:   strings.TrimSpace(r.FormValue("title")),
+		Authors: r.FormValue("authors"),
+		Series:  strings.TrimSpace(r.FormValue("series")),
+		Tags:    r.FormValue("tags"),
+	}
+	bookID, err := srv.importUpload(r, form)
+	if err != nil {
+		log.Printf("Error importing upload: %s", err)
+		form.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		srv.render("admin_upload", w, form)
+		return
+	}
+	shortID, _ := bookShortID(srv.lib, bookID)
+	http.Redirect(w, r, adminPrefix+"/b/"+shortID, http.StatusSeeOther)
+}
+
+// importUpload imports the file uploaded with form, and returns the ID of the book it was added to.
+func (srv *Server) importUpload(r *http.Request, form adminUpload) (int64, error) {
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		return 0, fmt.Errorf("no file was uploaded")
+	}
+	defer f.Close()
+	name, err := uploadFilename(header.Filename)
+	if err != nil {
+		return 0, err
+	}
+	dir, err := ioutil.TempDir("", "books-upload-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, name)
+	if err := saveUpload(f, fn); err != nil {
+		return 0, err
+	}
+	return srv.importUploadedFile(fn, uploadedBook{
+		Title:   form.Title,
+		Authors: splitLines(form.Authors),
+		Series:  form.Series,
+		Tags:    splitCommas(form.Tags),
+	})
+}
+
+// uploadedBook is the metadata given with an uploaded file, which replaces the metadata parsed from it.
+type uploadedBook struct {
+	Title   string
+	Authors []string
+	Series  string
+	// Tags are the tags of the file.
+	Tags []string
+}
+
+// uploadFilename returns the name an uploaded file is saved with, from the name it was uploaded with.
+// The name is kept, since metadata parsers read it, and the library records it as the original filename.
+func uploadFilename(name string) (string, error) {
+	name = path.Base(filepath.ToSlash(name))
+	if name == "." || name == "/" || filepath.Ext(name) == "" {
+		return "", fmt.Errorf("the uploaded file needs a name with an extension")
+	}
+	return name, nil
+}
+
+// importUploadedFile moves an uploaded file into the library, and returns the ID of the book it was added to.
+// Metadata is parsed from the file by the configured metadata parser, and the non-empty fields of ub replace it.
+func (srv *Server) importUploadedFile(fn string, ub uploadedBook) (int64, error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return 0, err
+	}
+	bf := books.BookFile{
+		OriginalFilename: fn,
+		Extension:        strings.ToLower(strings.TrimPrefix(filepath.Ext(fn), ".")),
+		FileSize:         fi.Size(),
+		FileMtime:        fi.ModTime(),
+		Tags:             ub.Tags,
+		Source:           "upload",
+	}
+	if err := bf.CalculateHash(); err != nil {
+		return 0, err
+	}
+	var book books.Book
+	if srv.metadataParser != nil {
+		book, _ = srv.metadataParser.Parse([]string{fn})
+	}
+	if ub.Title != "" {
+		book.Title = ub.Title
+	}
+	if len(ub.Authors) > 0 {
+		book.Authors = ub.Authors
+	}
+	if ub.Series != "" {
+		book.Series = ub.Series
+	}
+	if book.Title == "" || len(book.Authors) == 0 {
+		return 0, fmt.Errorf("the title and authors couldn't be found from the file, so they must be given")
+	}
+	book.Files = []books.BookFile{bf}
+
+	opts := srv.importOptions
+	opts.Move = true
+	opts.ConflictResolver = nil
+	srv.apiLock.Lock()
+	defer srv.apiLock.Unlock()
+	if err := srv.lib.ImportBookWithOptions(book, srv.outputTemplate, opts); err != nil {
+		return 0, err
+	}
+	imported, err := srv.lib.GetBooksByHash(bf.Hash)
+	if err != nil {
+		return 0, err
+	}
+	if len(imported) == 0 {
+		return 0, fmt.Errorf("the book was imported, but couldn't be found")
+	}
+	return imported[0].ID, nil
+}
+
+// saveUpload writes an uploaded file to fn.
+func saveUpload(r io.Reader, fn string) error {
+	out, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// coverHandler serves the cover of a book.
+func (srv *Server) coverHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := srv.requestBookID(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fn, err := srv.lib.BookCover(id)
+	if err == books.ErrNoCover || err == books.ErrBookNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting cover of book %d: %s", id, err)
+		http.Error(w, "The cover couldn't be extracted", http.StatusInternalServerError)
+		return
+	}
+	http.ServeFile(w, r, fn)
+}
+
+// sameOrigin returns false if a request names a different site in its Origin or Referer header,
+// so forms on other sites can't change the library with the credentials of a signed in user.
+func sameOrigin(r *http.Request) bool {
+	for _, h := range []string{"Origin", "Referer"} {
+		v := r.Header.Get(h)
+		if v == "" {
+			continue
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host != r.Host {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines returns the lines of s which aren't empty, with spaces trimmed.
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// splitCommas returns the items of a list separated by commas, with spaces trimmed and empty items left out.
+// The result isn't nil, so lists which are left empty are cleared.
+func splitCommas(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,154 @@
+package server
+
+// adminTemplates are the templates of the admin UI.
+// They're built into the server, so it works without them in the templates directory,
+// but templates there with the same names replace them.
+const adminTemplates = `
+{{ define "admin_header" }}<!doctype html>
+<html>
+<head>
+<title>{{ if . }}{{ . }} - {{ end }}Books admin</title>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+nav a { margin-right: 1em; }
+table.admin-books { border-collapse: collapse; }
+table.admin-books th, table.admin-books td { border-bottom: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+ul.covers { display: flex; flex-wrap: wrap; list-style: none; padding: 0; }
+ul.covers li { width: 10em; margin: 0 1em 1em 0; }
+ul.covers img { width: 10em; height: 15em; object-fit: contain; background: #eee; }
+form.admin-edit label { display: block; margin-top: 0.6em; }
+form.admin-edit input[type=text], form.admin-edit textarea { width: 30em; max-width: 100%; }
+.message { color: #060; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<nav><a href="/admin/">Books</a><a href="/admin/covers">Covers</a><a href="/admin/upload">Upload</a><a href="/">Search page</a></nav>
+{{ end }}
+
+{{ define "admin_footer" }}
+</body>
+</html>
+{{ end }}
+
+{{ define "admin_error" }}
+{{ template "admin_header" .Short }}
+<h1>{{ .Short }}</h1>
+<p class="error">{{ .Long }}</p>
+{{ template "admin_footer" }}
+{{ end }}
+
+{{ define "admin_searchform" }}
+<form action="{{ .Path }}">
+<input type="text" name="query" aria-label="Search" value="{{ .Query }}">
+<label>Sort by <select name="sort">
+{{ if .Query }}<option value=""{{ if not .Sort }} selected{{ end }}>relevance</option>{{ end }}
+{{ range .Sorts }}<option value="{{ . }}"{{ if eq . $.Sort }} selected{{ end }}>{{ . }}</option>
+{{ end }}</select></label>
+<label><input type="checkbox" name="desc" value="1"{{ if .Descending }} checked{{ end }}> Descending</label>
+<input type="submit" value="Show">
+</form>
+{{ if .Query }}<p>{{ .Total }} books match {{ .Query }}.</p>{{ else }}<p>{{ .Total }} books in the library.</p>{{ end }}
+{{ end }}
+
+{{ define "admin_pages" }}
+{{ if or .Prev .Next }}<p>
+{{ if .Prev }}<a href="{{ .Path }}?query={{ .Query }}&amp;sort={{ .Sort }}{{ if .Descending }}&amp;desc=1{{ end }}&amp;page={{ .Prev }}">&lt; Previous</a>{{ end }}
+Page {{ .PageNumber }}
+{{ if .Next }}<a href="{{ .Path }}?query={{ .Query }}&amp;sort={{ .Sort }}{{ if .Descending }}&amp;desc=1{{ end }}&amp;page={{ .Next }}">Next &gt;</a>{{ end }}
+</p>{{ end }}
+{{ end }}
+
+{{ define "admin_books" }}
+{{ template "admin_header" "Books" }}
+<h1>Books</h1>
+{{ template "admin_searchform" . }}
+{{ if .Books }}
+<table class="admin-books">
+<tr>{{ if .ShowIDs }}<th>ID</th>{{ end }}<th>Title</th><th>Authors</th><th>Series</th><th>Formats</th><th>Added</th><th>Newest file added</th></tr>
+{{ range .Books }}<tr>
+{{ if $.ShowIDs }}<td>{{ .ID }}</td>{{ end }}
+<td><a href="/admin/b/{{ .ShortID }}">{{ .Title }}</a></td>
+<td>{{ joinNaturally "and" .Authors }}</td>
+<td>{{ .Series }}</td>
+<td>{{ range $i, $f := .Files }}{{ if $i }}, {{ end }}<a href="/f/{{ $f.ShortID }}/{{ pathEscape (base $f.CurrentFilename) }}">{{ $f.Extension }}</a>{{ end }}</td>
+<td>{{ .CreatedOn.Format "2006-01-02" }}</td>
+<td>{{ .FileAddedOn.Format "2006-01-02" }}</td>
+</tr>
+{{ end }}</table>
+{{ else }}<p>No books found.</p>{{ end }}
+{{ template "admin_pages" . }}
+{{ template "admin_footer" }}
+{{ end }}
+
+{{ define "admin_covers" }}
+{{ template "admin_header" "Covers" }}
+<h1>Covers</h1>
+{{ template "admin_searchform" . }}
+{{ if .Books }}
+<ul class="covers">
+{{ range .Books }}<li><a href="/admin/b/{{ .ShortID }}"><img src="/b/{{ .ShortID }}/cover" alt="" loading="lazy"><br>{{ .Title }}</a><br>{{ joinNaturally "and" .Authors }}</li>
+{{ end }}</ul>
+{{ else }}<p>No books found.</p>{{ end }}
+{{ template "admin_pages" . }}
+{{ template "admin_footer" }}
+{{ end }}
+
+{{ define "admin_edit" }}
+{{ template "admin_header" (printf "Edit %s" .Book.Title) }}
+<h1>Edit {{ .Book.Title }}</h1>
+{{ if .Message }}<p class="message">{{ .Message }}</p>{{ end }}
+{{ if .Error }}<p class="error">{{ .Error }}</p>{{ end }}
+<p><a href="/b/{{ .Book.ShortID }}">View details</a></p>
+<img src="/b/{{ .Book.ShortID }}/cover" alt="" style="max-width: 10em; max-height: 15em">
+<form class="admin-edit" method="post" action="/admin/b/{{ .Book.ShortID }}">
+<label>Title <br><input type="text" name="title" value="{{ .Book.Title }}" required></label>
+<label>Subtitle <br><input type="text" name="subtitle" value="{{ .Book.Subtitle }}"></label>
+<label>Authors, one per line <br><textarea name="authors" rows="3" required>{{ join .Book.Authors "\n" }}</textarea></label>
+<label>Series <br><input type="text" name="series" value="{{ .Book.Series }}"></label>
+<label>Publisher <br><input type="text" name="publisher" value="{{ .Book.Publisher }}"></label>
+<label>Published, such as 1999 or 1999-05-17 <br><input type="text" name="published" value="{{ .Book.Published }}"></label>
+<label>Language <br><input type="text" name="language" value="{{ .Book.Language }}"></label>
+<label>Description <br><textarea name="description" rows="6">{{ .Book.Description }}</textarea></label>
+<label>Tags, separated by commas <br><input type="text" name="tags" value="{{ join .Book.Tags ", " }}"></label>
+<label>Fandoms, separated by commas <br><input type="text" name="fandoms" value="{{ join .Book.Fandoms ", " }}"></label>
+<label>Ships, separated by commas <br><input type="text" name="ships" value="{{ join .Book.Ships ", " }}"></label>
+<label>Words <br><input type="number" name="word_count" min="0" value="{{ if .Book.WordCount }}{{ .Book.WordCount }}{{ end }}"></label>
+<label>Source URL <br><input type="url" name="source_url" value="{{ .Book.SourceURL }}"></label>
+<label>Original title <br><input type="text" name="original_title" value="{{ .Book.OriginalTitle }}"></label>
+<label>Original language <br><input type="text" name="original_language" value="{{ .Book.OriginalLanguage }}"></label>
+<label>License: public-domain, cc, a Creative Commons license such as cc-by-sa, or purchased <br><input type="text" name="license" value="{{ .Book.License }}" list="licenses"></label>
+<datalist id="licenses"><option value="public-domain"><option value="cc"><option value="cc-by"><option value="cc-by-sa"><option value="cc0"><option value="purchased"></datalist>
+<p><input type="submit" value="Save"></p>
+</form>
+<h2>Files</h2>
+<table class="admin-books">
+<tr><th>Format</th><th>Filename</th><th>Tags</th><th>Size</th></tr>
+{{ range .Book.Files }}<tr>
+<td>{{ if .Missing }}{{ .Extension }} (missing){{ else }}<a href="/f/{{ .ShortID }}/{{ pathEscape (base .CurrentFilename) }}">{{ .Extension }}</a>{{ end }}</td>
+<td>{{ .CurrentFilename }}</td>
+<td>{{ join .Tags ", " }}</td>
+<td>{{ ByteCountSI .FileSize }}</td>
+</tr>
+{{ end }}</table>
+{{ template "admin_footer" }}
+{{ end }}
+
+{{ define "admin_upload" }}
+{{ template "admin_header" "Upload" }}
+<h1>Upload a book</h1>
+{{ if .Error }}<p class="error">{{ .Error }}</p>{{ end }}
+<p>The title, authors and series are parsed from the file when they're left empty.</p>
+<form class="admin-edit" method="post" action="/admin/upload" enctype="multipart/form-data">
+<label>File <br><input type="file" name="file" required></label>
+<label>Title <br><input type="text" name="title" value="{{ .Title }}"></label>
+<label>Authors, one per line <br><textarea name="authors" rows="3">{{ .Authors }}</textarea></label>
+<label>Series <br><input type="text" name="series" value="{{ .Series }}"></label>
+<label>File tags, separated by commas <br><input type="text" name="tags" value="{{ .Tags }}"></label>
+<p><input type="submit" value="Upload"></p>
+</form>
+{{ template "admin_footer" }}
+{{ end }}
+`
@@ -8,19 +8,25 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"text/template"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/tspivey/books"
 )
 
+// idempotencyKeyHeader is the request header which holds the idempotency key of a mutation.
+// A retried request with the same key isn't applied again.
+const idempotencyKeyHeader = "Idempotency-Key"
+
 func (srv *Server) getBookHandler(w http.ResponseWriter, r *http.Request) {
-	bookID := mux.Vars(r)["id"]
-	id, err := strconv.Atoi(bookID)
-	if err != nil {
-		http.NotFound(w, r)
+	id, ok := srv.requestBookID(r)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"no books"})
 		return
 	}
-	bookList, err := srv.lib.GetBooksByID([]int64{int64(id)})
+	bookList, err := srv.lib.GetBooksByID([]int64{id})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("error getting book by ID: %v", err)
@@ -31,16 +37,51 @@ func (srv *Server) getBookHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, apiError{"no books"})
 		return
 	}
-	model := bookToModel(bookList[0])
+	model := srv.bookToModel(bookList[0])
 	writeJSON(w, model)
 }
 
+func (srv *Server) getBookByIdentifierHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	book, err := srv.lib.GetBookByIdentifier(vars["type"], vars["value"])
+	if err == books.ErrBookNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"no books"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting book by identifier: %v", err)
+		return
+	}
+	writeJSON(w, srv.bookToModel(book))
+}
+
 func (srv *Server) updateBookHandler(w http.ResponseWriter, r *http.Request) {
 	var ub updateBook
 	if !readPostedJSON(w, r, &ub) {
 		return
 	}
 	book := modelToBook(ub.Book)
+	if srv.shortIDsOnly {
+		// Clients aren't given IDs, so the book is found by its short ID instead.
+		book.ID = 0
+		if ub.Book.ShortID != "" {
+			found, err := srv.lib.GetBookByShortID(ub.Book.ShortID)
+			if err == books.ErrBookNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				writeJSON(w, apiError{"book not found"})
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Printf("Error getting book %s: %v", ub.Book.ShortID, err)
+				writeJSON(w, apiError{"internal server error"})
+				return
+			}
+			book.ID = found.ID
+		}
+	}
 	if book.ID == 0 {
 		writeJSON(w, apiError{"no book ID"})
 		return
@@ -49,9 +90,24 @@ func (srv *Server) updateBookHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, apiError{"no title/authors"})
 		return
 	}
-	err := srv.lib.UpdateBook(book, srv.outputTemplate, ub.OverwriteSeries)
+	if _, err := books.NormalizeLicense(string(book.License)); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if _, err := books.NormalizeDate(book.Published); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	err := srv.lib.UpdateBookWithKey(r.Header.Get(idempotencyKeyHeader), book, srv.outputTemplate, ub.OverwriteSeries)
+	if ikre, ok := err.(books.IdempotencyKeyReusedError); ok {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJSON(w, apiError{ikre.Error()})
+		return
+	}
 	if bee, ok := err.(books.BookExistsError); ok {
-		msg := fmt.Sprintf("Book exists: %d", bee.BookID)
+		msg := fmt.Sprintf("Book exists: %s", srv.bookRef(bee.BookID))
 		writeJSON(w, apiError{msg})
 		return
 	}
@@ -74,7 +130,13 @@ func (srv *Server) mergeHandler(w http.ResponseWriter, r *http.Request) {
 	if !readPostedJSON(w, r, &ids) {
 		return
 	}
-	if err := srv.lib.MergeBooks(ids, srv.outputTemplate); err != nil {
+	err := srv.lib.MergeBooksWithKey(r.Header.Get(idempotencyKeyHeader), ids, srv.outputTemplate)
+	if ikre, ok := err.(books.IdempotencyKeyReusedError); ok {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJSON(w, apiError{ikre.Error()})
+		return
+	}
+	if err != nil {
 		log.Printf("error merging books: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		writeJSON(w, apiError{"error merging books"})
@@ -83,6 +145,79 @@ func (srv *Server) mergeHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, success{"merged"})
 }
 
+// translationHandler records that a book is a translation of another, or that it isn't a translation if original_id is 0.
+func (srv *Server) translationHandler(w http.ResponseWriter, r *http.Request) {
+	var t translation
+	if !readPostedJSON(w, r, &t) {
+		return
+	}
+	err := srv.lib.SetTranslationOf(t.BookID, t.OriginalID)
+	if err == books.ErrBookNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("error setting translation: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	writeJSON(w, success{"updated"})
+}
+
+// ratingHandler sets the rating of a book, or clears it if rating is 0.
+func (srv *Server) ratingHandler(w http.ResponseWriter, r *http.Request) {
+	var rt rating
+	if !readPostedJSON(w, r, &rt) {
+		return
+	}
+	err := srv.lib.SetRating(rt.BookID, rt.Rating)
+	if err == books.ErrBookNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("error setting rating: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	writeJSON(w, success{"updated"})
+}
+
+// promoteTagHandler makes a tag of a book's files a tag of the book.
+func (srv *Server) promoteTagHandler(w http.ResponseWriter, r *http.Request) {
+	srv.changeTag(w, r, srv.lib.PromoteTag)
+}
+
+// demoteTagHandler makes a tag of a book a tag of each of its files.
+func (srv *Server) demoteTagHandler(w http.ResponseWriter, r *http.Request) {
+	srv.changeTag(w, r, srv.lib.DemoteTag)
+}
+
+// changeTag promotes or demotes the tag posted in the request body with change.
+func (srv *Server) changeTag(w http.ResponseWriter, r *http.Request, change func(bookID int64, tag string, tmpl *template.Template) error) {
+	var bt bookTag
+	if !readPostedJSON(w, r, &bt) {
+		return
+	}
+	err := change(bt.BookID, bt.Tag, srv.outputTemplate)
+	if err == books.ErrBookNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("error changing tag %s of book %d: %v", bt.Tag, bt.BookID, err)
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	writeJSON(w, success{"updated"})
+}
+
 func (srv *Server) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
 	term, ok := r.URL.Query()["term"]
 	if !ok {
@@ -90,19 +225,389 @@ func (srv *Server) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, apiError{"no term specified"})
 		return
 	}
-	bookList, err := srv.lib.Search(term[0])
+	results, _, err := srv.lib.SearchWithOptions(term[0], books.SearchOptions{BoostTitleMatches: true, Log: srv.logSearches})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("error searching for book: %v", err)
 		return
 	}
 	newList := []Book{}
+	for _, r := range results {
+		newList = append(newList, srv.bookToModel(r.Book))
+	}
+	writeJSON(w, newList)
+}
+
+// apiRandomHandler returns random books matching the term parameter, or from the whole library if it isn't given.
+// n is the number of books, 1 if not given.
+// Books the sync user in exclude_read_by has finished aren't returned, nor books they've started if exclude_started is true.
+func (srv *Server) apiRandomHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	n := 1
+	if s := q.Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid n"})
+			return
+		}
+	}
+	opts := books.RandomOptions{ExcludeReadBy: q.Get("exclude_read_by"), ExcludeStarted: q.Get("exclude_started") == "true"}
+	bookList, err := srv.lib.RandomBooksWithOptions(q.Get("term"), n, opts)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	newList := []Book{}
+	for i := range bookList {
+		newList = append(newList, srv.bookToModel(bookList[i]))
+	}
+	writeJSON(w, newList)
+}
+
+// queryYear returns the year in the year parameter, or the current year if it isn't given.
+func queryYear(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("year")
+	if s == "" {
+		return time.Now().Year(), nil
+	}
+	return strconv.Atoi(s)
+}
+
+// getGoalsHandler returns the reading goals of the sync user in the user parameter, for the year in the year parameter.
+func (srv *Server) getGoalsHandler(w http.ResponseWriter, r *http.Request) {
+	year, err := queryYear(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"invalid year"})
+		return
+	}
+	goals, err := srv.lib.GetReadingGoals(r.URL.Query().Get("user"), year)
+	if err != nil {
+		log.Printf("error getting reading goals: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting reading goals"})
+		return
+	}
+	result := make([]ReadingGoal, 0)
+	for _, g := range goals {
+		result = append(result, ReadingGoal{g.User, g.Year, string(g.Kind), g.Target, g.Progress, g.Reached()})
+	}
+	writeJSON(w, result)
+}
+
+// setGoalHandler sets a reading goal, or removes it if target is 0.
+func (srv *Server) setGoalHandler(w http.ResponseWriter, r *http.Request) {
+	var g ReadingGoal
+	if !readPostedJSON(w, r, &g) {
+		return
+	}
+	if err := srv.lib.SetReadingGoal(g.User, g.Year, books.GoalKind(g.Kind), g.Target); err != nil {
+		log.Printf("error setting reading goal: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	writeJSON(w, success{"updated"})
+}
+
+// yearInReviewHandler summarizes the books finished by the sync user in the user parameter, or by anyone if it isn't given,
+// in the year in the year parameter.
+func (srv *Server) yearInReviewHandler(w http.ResponseWriter, r *http.Request) {
+	year, err := queryYear(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"invalid year"})
+		return
+	}
+	review, err := srv.lib.YearInReview(r.URL.Query().Get("user"), year)
+	if err != nil {
+		log.Printf("error getting year in review: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting year in review"})
+		return
+	}
+	result := YearInReview{User: review.User, Year: review.Year, BookIDs: review.BookIDs, Pages: review.Pages,
+		TopAuthors: make([]RankedName, 0), TopGenres: make([]RankedName, 0)}
+	if result.BookIDs == nil {
+		result.BookIDs = make([]int64, 0)
+	}
+	for _, a := range review.TopAuthors {
+		result.TopAuthors = append(result.TopAuthors, RankedName{a.Name, a.Books})
+	}
+	for _, g := range review.TopGenres {
+		result.TopGenres = append(result.TopGenres, RankedName{g.Name, g.Books})
+	}
+	writeJSON(w, result)
+}
+
+// searchAnalyticsHandler returns the most common searches, and those which found nothing, made in the last days days, 30 if not given.
+// limit is the number of each to return, 20 if not given.
+func (srv *Server) searchAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	days, limit := 30, 20
+	var err error
+	if s := q.Get("days"); s != "" {
+		if days, err = strconv.Atoi(s); err != nil || days < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid days"})
+			return
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid limit"})
+			return
+		}
+	}
+	a, err := srv.lib.SearchAnalytics(time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		log.Printf("error getting search analytics: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting search analytics"})
+		return
+	}
+	result := SearchAnalytics{Searches: a.Searches, TopQueries: queryCountsToModel(a.TopQueries), ZeroResultQueries: queryCountsToModel(a.ZeroResultQueries)}
+	writeJSON(w, result)
+}
+
+func queryCountsToModel(counts []books.QueryCount) []QueryCount {
+	result := make([]QueryCount, 0, len(counts))
+	for _, c := range counts {
+		result = append(result, QueryCount{c.Query, c.Count, c.Results, c.LastSearched.UTC().Format(time.RFC3339)})
+	}
+	return result
+}
+
+func (srv *Server) apiSuggestHandler(w http.ResponseWriter, r *http.Request) {
+	term, ok := r.URL.Query()["term"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"no term specified"})
+		return
+	}
+	suggestions, err := srv.lib.SearchSuggestions(term[0], maxSuggestions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting search suggestions: %v", err)
+		return
+	}
+	if suggestions == nil {
+		suggestions = []string{}
+	}
+	writeJSON(w, suggestions)
+}
+
+// classificationTreeHandler returns the classes under the class in the parent parameter, or the main classes if it's not given.
+func (srv *Server) classificationTreeHandler(w http.ResponseWriter, r *http.Request) {
+	nodes, err := srv.lib.ClassificationTree(mux.Vars(r)["scheme"], r.URL.Query().Get("parent"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	result := make([]ClassificationNode, 0)
+	for _, node := range nodes {
+		result = append(result, ClassificationNode{node.Number, node.Caption, node.Books, node.HasChildren})
+	}
+	writeJSON(w, result)
+}
+
+// classifiedBooksHandler returns the books in the class in the class parameter.
+func (srv *Server) classifiedBooksHandler(w http.ResponseWriter, r *http.Request) {
+	class := r.URL.Query().Get("class")
+	if class == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"no class specified"})
+		return
+	}
+	ids, err := srv.lib.ClassifiedBookIDs(mux.Vars(r)["scheme"], class)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting classified books: %v", err)
+		return
+	}
+	bookList, err := srv.lib.GetBooksByID(ids)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting books by ID: %v", err)
+		return
+	}
+	newList := []Book{}
+	for i := range bookList {
+		newList = append(newList, srv.bookToModel(bookList[i]))
+	}
+	writeJSON(w, newList)
+}
+
+// contributorsHandler returns the names of everyone with a role in at least one book.
+func (srv *Server) contributorsHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := srv.lib.Contributors(mux.Vars(r)["role"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+	writeJSON(w, names)
+}
+
+// contributorBooksHandler returns the books the contributor in the name parameter worked on in a role.
+func (srv *Server) contributorBooksHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"no name specified"})
+		return
+	}
+	ids, err := srv.lib.ContributorBookIDs(name, mux.Vars(r)["role"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	bookList, err := srv.lib.GetBooksByID(ids)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting books by ID: %v", err)
+		return
+	}
+	newList := []Book{}
 	for i := range bookList {
-		newList = append(newList, bookToModel(bookList[i]))
+		newList = append(newList, srv.bookToModel(bookList[i]))
 	}
 	writeJSON(w, newList)
 }
 
+// coAuthorsHandler returns the authors who wrote books with the author in the id parameter.
+func (srv *Server) coAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	coAuthors, err := srv.lib.CoAuthors(id)
+	if err == books.ErrAuthorNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{err.Error()})
+		return
+	} else if err != nil {
+		log.Printf("error getting co-authors: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting co-authors"})
+		return
+	}
+	if coAuthors == nil {
+		coAuthors = []books.CoAuthor{}
+	}
+	writeJSON(w, coAuthors)
+}
+
+// authorGraphHandler returns the graph of which authors wrote books together, as JSON, or in the DOT language if format is dot.
+func (srv *Server) authorGraphHandler(w http.ResponseWriter, r *http.Request) {
+	g, err := srv.lib.AuthorGraph()
+	if err != nil {
+		log.Printf("error getting author graph: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting author graph"})
+		return
+	}
+	if r.URL.Query().Get("format") == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		g.WriteDOT(w)
+		return
+	}
+	writeJSON(w, g)
+}
+
+// decadesHandler returns the number of books published in each decade, and the number without publication dates.
+func (srv *Server) decadesHandler(w http.ResponseWriter, r *http.Request) {
+	decades, undated, err := srv.lib.PublicationDecades()
+	if err != nil {
+		log.Printf("error counting books by decade: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error counting books by decade"})
+		return
+	}
+	writeJSON(w, Decades{Decades: decades, Undated: undated})
+}
+
+// defaultPreviewChars is the length of text previews when the chars parameter isn't given.
+const defaultPreviewChars = 500
+
+// apiPreviewHandler returns the opening text of a file, for showing an excerpt with search results.
+func (srv *Server) apiPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := srv.requestFileID(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	chars := defaultPreviewChars
+	if s := r.URL.Query().Get("chars"); s != "" {
+		var err error
+		if chars, err = strconv.Atoi(s); err != nil || chars < 1 || chars > books.MaxPreviewChars {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid chars"})
+			return
+		}
+	}
+	text, err := srv.lib.GetPreview(id, chars)
+	if err == books.ErrPreviewUnsupported {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting preview of file %d: %v", id, err)
+		return
+	}
+	writeJSON(w, preview{text})
+}
+
+// apiCoverHandler returns where a book's cover is, and its palette.
+func (srv *Server) apiCoverHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := srv.requestBookID(r)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{books.ErrBookNotFound.Error()})
+		return
+	}
+	palette, err := srv.lib.CoverPalette(id)
+	if err == books.ErrNoCover || err == books.ErrBookNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting cover palette of book %d: %v", id, err)
+		return
+	}
+	url := "/cover/" + strconv.FormatInt(id, 10)
+	if shortID, ok := bookShortID(srv.lib, id); ok {
+		url = "/b/" + shortID + "/cover"
+	}
+	cover := Cover{URL: url, Palette: palette}
+	if !srv.shortIDsOnly {
+		cover.BookID = id
+	}
+	writeJSON(w, cover)
+}
+
+// formatsHandler returns the capabilities of every supported file format, so that actions files don't support can be disabled.
+func (srv *Server) formatsHandler(w http.ResponseWriter, r *http.Request) {
+	formats := make([]Format, 0)
+	for _, f := range books.SupportedFormats() {
+		formats = append(formats, Format(f))
+	}
+	writeJSON(w, formats)
+}
+
 func writeJSON(w http.ResponseWriter, v interface{}) {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -1,8 +1,6 @@
 package server
 
 import (
-	"time"
-
 	"github.com/tspivey/books"
 )
 
@@ -10,25 +8,36 @@ type apiError struct {
 	Error string `json:"error"`
 }
 
-// Book represents a book in a library.
+// Book represents a book in a library, as books.Book, with the short IDs used in share links.
+// The fields books.Book documents as not being changed by updating a book, such as its rating, are ignored in updates,
+// as is ShortID, made from the UUID, unless the server only allows short IDs, when the book is found by it instead of by ID.
+// Contributors, fandoms, ships and tags which are omitted in an update are unchanged.
+// Searching for a tag finds books which have it, or which have a file with it.
 type Book struct {
-	ID      int64      `json:"id"`
-	Authors []string   `json:"authors"`
-	Title   string     `json:"title"`
-	Series  string     `json:"series"`
-	Files   []BookFile `json:"files"`
+	books.Book
+	// ID, TranslationOf and Translations replace those of books.Book, so they can be left out when the server only allows short IDs.
+	// They're otherwise the same, and TranslationOf and Translations are still ignored in updates.
+	ID            int64      `json:"id,omitempty"`
+	TranslationOf *int64     `json:"translation_of,omitempty"`
+	Translations  *[]int64   `json:"translations,omitempty"`
+	ShortID       string     `json:"short_id"`
+	Files         []BookFile `json:"files"`
+}
+
+// ClassificationNode is a class in a classification tree.
+type ClassificationNode struct {
+	Number      string `json:"number"`
+	Caption     string `json:"caption"`
+	Books       int    `json:"books"`
+	HasChildren bool   `json:"has_children"`
 }
 
-// BookFile represents a file linked to a book.
+// BookFile represents a file linked to a book, as books.BookFile, with its short ID.
 type BookFile struct {
-	ID               int64     `json:"id"`
-	Extension        string    `json:"extension"`
-	Tags             []string  `json:"tags"`
-	Hash             string    `json:"hash"`
-	OriginalFilename string    `json:"original_filename"`
-	Filename         string    `json:"filename"`
-	Mtime            time.Time `json:"mtime"`
-	Size             int64     `json:"size"`
+	books.BookFile
+	// ID replaces that of books.BookFile, so it can be left out when the server only allows short IDs.
+	ID      int64  `json:"id,omitempty"`
+	ShortID string `json:"short_id"`
 }
 
 type updateBook struct {
@@ -36,62 +45,161 @@ type updateBook struct {
 	OverwriteSeries bool `json:"overwrite_series"`
 }
 
+type translation struct {
+	BookID     int64 `json:"book_id"`
+	OriginalID int64 `json:"original_id"`
+}
+
+type bookTag struct {
+	BookID int64  `json:"book_id"`
+	Tag    string `json:"tag"`
+}
+
+type rating struct {
+	BookID int64 `json:"book_id"`
+	Rating int   `json:"rating"`
+}
+
+// ReadingGoal is the number of books or pages a sync user wants to read in a year, and how many they've read so far.
+type ReadingGoal struct {
+	User     string `json:"user"`
+	Year     int    `json:"year"`
+	Kind     string `json:"kind"`
+	Target   int    `json:"target"`
+	Progress int    `json:"progress"`
+	Reached  bool   `json:"reached"`
+}
+
+// RankedName is an author or genre, and the number of books read in a year which have it.
+type RankedName struct {
+	Name  string `json:"name"`
+	Books int    `json:"books"`
+}
+
+// YearInReview summarizes the books finished in a year.
+type YearInReview struct {
+	User       string       `json:"user"`
+	Year       int          `json:"year"`
+	BookIDs    []int64      `json:"book_ids"`
+	Pages      int          `json:"pages"`
+	TopAuthors []RankedName `json:"top_authors"`
+	TopGenres  []RankedName `json:"top_genres"`
+}
+
+// QueryCount is a search, how often it was made, and how many books it found the last time.
+type QueryCount struct {
+	Query        string `json:"query"`
+	Count        int    `json:"count"`
+	Results      int    `json:"results"`
+	LastSearched string `json:"last_searched"`
+}
+
+// SearchAnalytics summarizes logged searches.
+type SearchAnalytics struct {
+	Searches          int          `json:"searches"`
+	TopQueries        []QueryCount `json:"top_queries"`
+	ZeroResultQueries []QueryCount `json:"zero_result_queries"`
+}
+
+// Decades counts the books published in each decade, and the books whose publication dates aren't known.
+type Decades struct {
+	Decades []books.DecadeCount `json:"decades"`
+	Undated int                 `json:"undated"`
+}
+
+// Format is what can be done with files of a format.
+type Format struct {
+	Extension          string `json:"extension"`
+	MetadataExtraction bool   `json:"metadata_extraction"`
+	CoverExtraction    bool   `json:"cover_extraction"`
+	ConversionSource   bool   `json:"conversion_source"`
+	ConversionTarget   bool   `json:"conversion_target"`
+	ContentIndexing    bool   `json:"content_indexing"`
+}
+
+// Cover is a book's cover, with its dominant colors for theming the book.
+type Cover struct {
+	// BookID is left out if the server only allows short IDs.
+	BookID int64  `json:"book_id,omitempty"`
+	URL    string `json:"url"`
+	// Palette is the cover's dominant colors, most common first, as hex colors such as "#1a2b3c".
+	Palette []string `json:"palette"`
+}
+
+type preview struct {
+	Preview string `json:"preview"`
+}
+
 type success struct {
 	Success string `json:"success"`
 }
 
-func bookToModel(book books.Book) Book {
-	modelFiles := make([]BookFile, 0)
+// bookToModel returns book as it's given by the API.
+// If the server only allows short IDs, the IDs of the book, its files and its translations are left out.
+func (srv *Server) bookToModel(book books.Book) Book {
+	newBook := Book{Book: book, ShortID: book.ShortID(), Files: make([]BookFile, 0)}
 	for _, file := range book.Files {
-		newFile := BookFile{
-			ID:               file.ID,
-			Extension:        file.Extension,
-			Tags:             file.Tags,
-			Hash:             file.Hash,
-			OriginalFilename: file.OriginalFilename,
-			Filename:         file.CurrentFilename,
-			Mtime:            file.FileMtime,
-			Size:             file.FileSize,
+		newFile := BookFile{BookFile: file, ShortID: file.ShortID()}
+		if !srv.shortIDsOnly {
+			newFile.ID = file.ID
 		}
 		if newFile.Tags == nil {
 			newFile.Tags = make([]string, 0)
 		}
-		modelFiles = append(modelFiles, newFile)
+		// The works are copied, since the file may be shared with the library's cache.
+		newFile.Works = make([]books.ContainedWork, 0, len(file.Works))
+		for _, w := range file.Works {
+			if w.Authors == nil {
+				w.Authors = make([]string, 0)
+			}
+			newFile.Works = append(newFile.Works, w)
+		}
+		newBook.Files = append(newBook.Files, newFile)
+	}
+	newBook.Book.Files = nil
+	if newBook.Identifiers == nil {
+		newBook.Identifiers = make([]books.Identifier, 0)
+	}
+	if newBook.Classifications == nil {
+		newBook.Classifications = make([]books.Classification, 0)
 	}
-	newBook := Book{
-		ID:      book.ID,
-		Authors: book.Authors,
-		Title:   book.Title,
-		Series:  book.Series,
-		Files:   modelFiles,
+	if newBook.Contributors == nil {
+		newBook.Contributors = make([]books.Contributor, 0)
+	}
+	if newBook.AlternateTitles == nil {
+		newBook.AlternateTitles = make([]books.AlternateTitle, 0)
+	}
+	if newBook.Tags == nil {
+		newBook.Tags = make([]string, 0)
+	}
+	if newBook.Fandoms == nil {
+		newBook.Fandoms = make([]string, 0)
+	}
+	if newBook.Ships == nil {
+		newBook.Ships = make([]string, 0)
+	}
+	if newBook.Book.Translations == nil {
+		newBook.Book.Translations = make([]int64, 0)
 	}
 	if newBook.Authors == nil {
 		newBook.Authors = make([]string, 0)
 	}
+	if !srv.shortIDsOnly {
+		translationOf, translations := newBook.Book.TranslationOf, newBook.Book.Translations
+		newBook.ID = book.ID
+		newBook.TranslationOf = &translationOf
+		newBook.Translations = &translations
+	}
 	return newBook
 }
 
 func modelToBook(modelBook Book) books.Book {
-	files := make([]books.BookFile, 0)
+	book := modelBook.Book
+	book.ID = modelBook.ID
+	book.Files = make([]books.BookFile, 0)
 	for _, file := range modelBook.Files {
-		newFile := books.BookFile{
-			ID:               file.ID,
-			Extension:        file.Extension,
-			Tags:             file.Tags,
-			Hash:             file.Hash,
-			OriginalFilename: file.OriginalFilename,
-			CurrentFilename:  file.Filename,
-			FileMtime:        file.Mtime,
-			FileSize:         file.Size,
-		}
-		files = append(files, newFile)
+		file.BookFile.ID = file.ID
+		book.Files = append(book.Files, file.BookFile)
 	}
-	newBook := books.Book{
-		ID:      modelBook.ID,
-		Authors: modelBook.Authors,
-		Title:   modelBook.Title,
-		Series:  modelBook.Series,
-		Files:   files,
-	}
-	return newBook
+	return book
 }
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// calibreLibraryID is the ID of the library, as seen by clients of Calibre's content server API.
+const calibreLibraryID = "books"
+
+// calibreBook is a book as described by Calibre's content server.
+type calibreBook struct {
+	ApplicationID  int64                    `json:"application_id"`
+	UUID           string                   `json:"uuid"`
+	Title          string                   `json:"title"`
+	TitleSort      string                   `json:"title_sort"`
+	Authors        []string                 `json:"authors"`
+	AuthorSort     string                   `json:"author_sort"`
+	AuthorSortMap  map[string]string        `json:"author_sort_map"`
+	Tags           []string                 `json:"tags"`
+	Series         *string                  `json:"series"`
+	SeriesIndex    *float64                 `json:"series_index"`
+	Publisher      *string                  `json:"publisher"`
+	Identifiers    map[string]string        `json:"identifiers"`
+	Languages      []string                 `json:"languages"`
+	Timestamp      string                   `json:"timestamp"`
+	LastModified   string                   `json:"last_modified"`
+	Formats        []string                 `json:"formats"`
+	FormatMetadata map[string]calibreFormat `json:"format_metadata"`
+	MainFormat     map[string]string        `json:"main_format"`
+	OtherFormats   map[string]string        `json:"other_formats"`
+	Cover          string                   `json:"cover"`
+	Thumbnail      string                   `json:"thumbnail"`
+}
+
+type calibreFormat struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Mtime string `json:"mtime"`
+}
+
+type calibreSearchResult struct {
+	TotalNum              int     `json:"total_num"`
+	NumBooksWithoutSearch int     `json:"num_books_without_search"`
+	Offset                int     `json:"offset"`
+	Num                   int     `json:"num"`
+	Sort                  string  `json:"sort"`
+	SortOrder             string  `json:"sort_order"`
+	BaseURL               string  `json:"base_url"`
+	Query                 string  `json:"query"`
+	LibraryID             string  `json:"library_id"`
+	BookIDs               []int64 `json:"book_ids"`
+	VL                    string  `json:"vl"`
+}
+
+// calibreSorts maps Calibre's sort fields to the orders books can be listed in.
+// Calibre fields not listed are sorted by ID.
+var calibreSorts = map[string]books.BookSort{
+	"title":       books.SortByTitle,
+	"sort":        books.SortByTitle,
+	"authors":     books.SortByAuthor,
+	"author_sort": books.SortByAuthor,
+	"series":      books.SortBySeries,
+	"id":          books.SortByID,
+	"timestamp":   books.SortByAdded,
+}
+
+// calibreFields maps Calibre's search field names to the library's.
+var calibreFields = map[string]string{
+	"authors":   "author",
+	"formats":   "extension",
+	"tag":       "tags",
+	"publisher": "publisher",
+	"series":    "series",
+	"title":     "title",
+}
+
+var calibreFieldRegexp = regexp.MustCompile(`(\w+):(?:"([^"]*)"|(\S*))`)
+
+// addCalibreRoutes adds endpoints mimicking the AJAX API of Calibre's content server to r,
+// so apps written for Calibre can browse and download from the library.
+func (srv *Server) addCalibreRoutes(r *mux.Router) {
+	ar := r.PathPrefix("/ajax").Subrouter()
+	ar.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id, ok := mux.Vars(r)["library_id"]; ok && id != calibreLibraryID {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			next.ServeHTTP(w, r)
+		})
+	})
+	ar.HandleFunc("/library-info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"library_map":     map[string]string{calibreLibraryID: calibreLibraryID},
+			"default_library": calibreLibraryID,
+		})
+	})
+	for _, suffix := range []string{"", "/{library_id}"} {
+		ar.HandleFunc("/search"+suffix, srv.calibreSearchHandler)
+		ar.HandleFunc(`/book/{id:\d+}`+suffix, srv.calibreBookHandler)
+		ar.HandleFunc("/books"+suffix, srv.calibreBooksHandler)
+		r.HandleFunc(`/get/{what}/{id:\d+}`+suffix, srv.calibreGetHandler)
+	}
+}
+
+func (srv *Server) calibreSearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	res := calibreSearchResult{
+		Offset:    0,
+		Num:       100,
+		Sort:      q.Get("sort"),
+		SortOrder: q.Get("sort_order"),
+		BaseURL:   "/ajax/search/" + calibreLibraryID,
+		Query:     q.Get("query"),
+		LibraryID: calibreLibraryID,
+		BookIDs:   []int64{},
+	}
+	if n, err := strconv.Atoi(q.Get("offset")); err == nil && n >= 0 {
+		res.Offset = n
+	}
+	if n, err := strconv.Atoi(q.Get("num")); err == nil && n >= 0 {
+		res.Num = n
+	}
+	if res.Sort == "" {
+		res.Sort = "timestamp"
+	}
+	if res.SortOrder != "asc" {
+		res.SortOrder = "desc"
+	}
+	bookSort, ok := calibreSorts[res.Sort]
+	if !ok {
+		bookSort = books.SortByID
+	}
+	descending := res.SortOrder == "desc"
+
+	all, err := srv.lib.ListBookIDs(bookSort, descending)
+	if err != nil {
+		log.Printf("Error listing books: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.NumBooksWithoutSearch = len(all)
+	ids := all
+	if query := strings.TrimSpace(res.Query); query != "" {
+		found, _, err := srv.lib.SearchWithOptions(calibreQuery(query), books.SearchOptions{})
+		if err != nil {
+			log.Printf("Error searching for %s: %s", query, err)
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid query"})
+			return
+		}
+		ids = make([]int64, len(found))
+		for i, result := range found {
+			ids[i] = result.Book.ID
+		}
+		if ids, err = srv.lib.SortBookIDs(ids, bookSort, descending); err != nil {
+			log.Printf("Error sorting books: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	res.TotalNum = len(ids)
+	if res.Offset < len(ids) {
+		ids = ids[res.Offset:]
+		if len(ids) > res.Num {
+			ids = ids[:res.Num]
+		}
+		res.BookIDs = ids
+	}
+	writeJSON(w, res)
+}
+
+// calibreQuery converts a query in Calibre's search syntax to the library's,
+// translating field names and quoted values.
+func calibreQuery(query string) string {
+	return calibreFieldRegexp.ReplaceAllStringFunc(query, func(s string) string {
+		m := calibreFieldRegexp.FindStringSubmatch(s)
+		field, value := strings.ToLower(m[1]), m[2]+m[3]
+		if f, ok := calibreFields[field]; ok {
+			field = f
+		}
+		return field + ":" + strings.Join(strings.Fields(value), "+")
+	})
+}
+
+func (srv *Server) calibreBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	found, err := srv.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting books by ID: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(found) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, calibreBookModel(found[0]))
+}
+
+func (srv *Server) calibreBooksHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	for _, s := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	found, err := srv.lib.GetBooksByID(ids)
+	if err != nil {
+		log.Printf("Error getting books by ID: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Books which don't exist are included as null, as Calibre does.
+	res := make(map[string]*calibreBook, len(ids))
+	for _, id := range ids {
+		res[strconv.FormatInt(id, 10)] = nil
+	}
+	for _, book := range found {
+		cb := calibreBookModel(book)
+		res[strconv.FormatInt(book.ID, 10)] = &cb
+	}
+	writeJSON(w, res)
+}
+
+// calibreGetHandler downloads a book in a format.
+// Covers and thumbnails aren't available, so they're never found.
+func (srv *Server) calibreGetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if id, ok := vars["library_id"]; ok && id != calibreLibraryID {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	found, err := srv.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting books by ID: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(found) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	book := found[0]
+	file, ok := calibreFormats(book)[strings.ToLower(vars["what"])]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fn, err := srv.lib.FilePath(file)
+	if err != nil {
+		log.Printf("Cannot get the path of file %d: %s", file.ID, err)
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		log.Printf("File %d is in the library but the file is missing: %s", file.ID, fn)
+		http.NotFound(w, r)
+		return
+	}
+	fn, release, err := srv.lib.CachedFilePath(file)
+	if err != nil {
+		log.Printf("Cannot fetch file %d: %s", file.ID, err)
+		http.Error(w, "Cannot fetch file", http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+	name := strings.Replace(fmt.Sprintf("%s - %s.%s", book.Title, books.JoinNaturally("and", book.Authors), file.Extension), `"`, "'", -1)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, fn)
+}
+
+// calibreFormats returns a book's files by lowercase extension.
+// Calibre has at most one file per format, so only the largest file of each format is included.
+func calibreFormats(book books.Book) map[string]books.BookFile {
+	formats := make(map[string]books.BookFile)
+	for _, f := range book.Files {
+		ext := strings.ToLower(f.Extension)
+		if existing, ok := formats[ext]; !ok || f.FileSize > existing.FileSize {
+			formats[ext] = f
+		}
+	}
+	return formats
+}
+
+// calibreBookModel describes a book the way Calibre's content server does.
+func calibreBookModel(book books.Book) calibreBook {
+	id := strconv.FormatInt(book.ID, 10)
+	cb := calibreBook{
+		ApplicationID:  book.ID,
+		UUID:           book.UUID,
+		Title:          book.Title,
+		TitleSort:      book.Title,
+		Authors:        book.Authors,
+		AuthorSortMap:  make(map[string]string),
+		Tags:           []string{},
+		Identifiers:    make(map[string]string),
+		Languages:      []string{},
+		Formats:        []string{},
+		FormatMetadata: make(map[string]calibreFormat),
+		OtherFormats:   make(map[string]string),
+		Cover:          "/get/cover/" + id + "/" + calibreLibraryID,
+		Thumbnail:      "/get/thumb/" + id + "/" + calibreLibraryID,
+	}
+	if cb.Authors == nil {
+		cb.Authors = []string{}
+	}
+	var sorts []string
+	for _, a := range cb.Authors {
+		cb.AuthorSortMap[a] = calibreAuthorSort(a)
+		sorts = append(sorts, cb.AuthorSortMap[a])
+	}
+	cb.AuthorSort = strings.Join(sorts, " & ")
+	if book.Series != "" {
+		cb.Series = &book.Series
+		index := book.SeriesIndex
+		if index == 0 {
+			// Calibre numbers books in a series from 1 when they aren't numbered.
+			index = 1
+		}
+		cb.SeriesIndex = &index
+	}
+	if book.Publisher != "" {
+		cb.Publisher = &book.Publisher
+	}
+	for _, ident := range book.Identifiers {
+		cb.Identifiers[ident.Type] = ident.Value
+	}
+
+	var modified time.Time
+	for ext, f := range calibreFormats(book) {
+		cb.Formats = append(cb.Formats, ext)
+		cb.FormatMetadata[ext] = calibreFormat{Path: f.CurrentFilename, Size: f.FileSize, Mtime: f.FileMtime.UTC().Format(time.RFC3339)}
+		if f.FileMtime.After(modified) {
+			modified = f.FileMtime
+		}
+	}
+	cb.Tags = append(cb.Tags, book.AllTags()...)
+	sort.Strings(cb.Formats)
+	sort.Strings(cb.Tags)
+	for _, ext := range cb.Formats {
+		url := "/get/" + ext + "/" + id + "/" + calibreLibraryID
+		// EPUB is preferred as the main format, as it is by Calibre's default output format.
+		if cb.MainFormat == nil && (ext == "epub" || len(cb.Formats) == 1) {
+			cb.MainFormat = map[string]string{ext: url}
+		} else {
+			cb.OtherFormats[ext] = url
+		}
+	}
+	if cb.MainFormat == nil && len(cb.Formats) > 0 {
+		ext := cb.Formats[0]
+		cb.MainFormat = map[string]string{ext: cb.OtherFormats[ext]}
+		delete(cb.OtherFormats, ext)
+	}
+	// Calibre's timestamp is when the book was added.
+	cb.Timestamp = book.CreatedOn.UTC().Format(time.RFC3339)
+	cb.LastModified = modified.UTC().Format(time.RFC3339)
+	return cb
+}
+
+// calibreAuthorSort returns an author's name as Calibre sorts it, with the last name first.
+func calibreAuthorSort(author string) string {
+	fields := strings.Fields(author)
+	if len(fields) < 2 || strings.Contains(author, ",") {
+		return author
+	}
+	return fields[len(fields)-1] + ", " + strings.Join(fields[:len(fields)-1], " ")
+}
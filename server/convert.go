@@ -1,10 +1,16 @@
 package server
 
 import (
+	"bufio"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -12,101 +18,209 @@ import (
 	"github.com/tspivey/books"
 )
 
-// BookConverter converts a book to epub.
+// BookConverter converts books to other formats.
 type BookConverter interface {
+	// Convert converts a file to epub. It's the same as ConvertTo with the format epub.
 	Convert(bf books.BookFile) (string, error)
+	// ConvertTo returns the filename of a file converted to format, such as mobi.
+	// If the converted file isn't ready yet, the file is queued for conversion, and errBookNotReady is returned.
+	ConvertTo(bf books.BookFile, format string) (string, error)
+	// Progress returns the progress of converting a file to format, and false if it isn't being converted.
+	Progress(bf books.BookFile, format string) (ConversionProgress, bool)
 	Close()
 }
 
-// calibreBookConverter converts a book to epub using calibre.
+// ConversionProgress is how far along converting a file is.
+type ConversionProgress struct {
+	// Percent is how much of the conversion is done, from 0 to 100.
+	Percent int `json:"percent"`
+	// Message describes the step of the conversion being done, as reported by the converter.
+	Message string `json:"message"`
+}
+
+// ConversionFormats are the formats files can be converted to.
+var ConversionFormats = []string{"epub", "azw3", "mobi", "pdf", "docx", "txt"}
+
+// conversionQueueSize is how many files can wait for a worker to convert them.
+const conversionQueueSize = 16
+
+// conversion is a file being converted to a format, or which failed to convert.
+type conversion struct {
+	progress ConversionProgress
+	// err is errBookNotReady until the conversion finishes, and the error if it failed.
+	err error
+}
+
+// conversionJob is a file queued to be converted to a format.
+type conversionJob struct {
+	bf     books.BookFile
+	format string
+}
+
+// conversionKey identifies the conversion of a file to a format.
+func conversionKey(bf books.BookFile, format string) string {
+	return bf.Hash + "." + format
+}
+
+// calibreBookConverter converts books using calibre.
 type calibreBookConverter struct {
 	convertingMtx sync.Mutex
-	converting    map[int64]error // Holds book conversion status
-	fileCh        chan books.BookFile
-	booksRoot     string
+	converting    map[string]*conversion // Holds book conversion status, by conversionKey
+	jobCh         chan conversionJob
+	lib           *books.Library
 	cacheDir      string
-	closed        bool
+	// closed is true once Close is called. It's guarded by convertingMtx, like converting.
+	closed bool
 }
 
 var errBookNotReady = errors.New("book not ready")
 var errQueueFull = errors.New("queue full")
 
 func (c *calibreBookConverter) Convert(bf books.BookFile) (string, error) {
-	if c.closed {
+	return c.ConvertTo(bf, "epub")
+}
+
+func (c *calibreBookConverter) ConvertTo(bf books.BookFile, format string) (string, error) {
+	c.convertingMtx.Lock()
+	closed := c.closed
+	c.convertingMtx.Unlock()
+	if closed {
 		return "", errors.New("book converter closed")
 	}
-	epubFn := path.Join(c.cacheDir, bf.Hash+".epub")
-	_, err := os.Stat(epubFn)
+	// A conversion whose file was removed, such as by clearing the cache, isn't found, and its record is dropped,
+	// so it's converted again below.
+	d, found, err := c.lib.GetDerivation(bf.Hash, books.DerivationConversion, format)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return d.Filename, nil
+	}
+	// Books converted before derivations were recorded are only found by their names.
+	convertedFn := path.Join(c.cacheDir, bf.Hash+"."+format)
+	_, err = os.Stat(convertedFn)
 	if err == nil {
-		return epubFn, nil
+		return convertedFn, c.lib.SaveDerivation(books.Derivation{Hash: bf.Hash, Kind: books.DerivationConversion, Params: format, Filename: convertedFn})
 	}
 	if !os.IsNotExist(err) {
 		return "", err
 	}
 
-	// The converted epub doesn't exist. Tell a worker to convert it if one isn't already doing so.
+	// The converted file doesn't exist. Tell a worker to convert it if one isn't already doing so.
+	key := conversionKey(bf, format)
 	c.convertingMtx.Lock()
-	conversionErr, converting := c.converting[bf.ID]
-	c.convertingMtx.Unlock()
-	if converting {
-		if conversionErr != errBookNotReady {
-			c.convertingMtx.Lock()
-			delete(c.converting, bf.ID)
-			c.convertingMtx.Unlock()
-			return "", errors.Wrap(conversionErr, "Converting book")
+	defer c.convertingMtx.Unlock()
+	// The converter may have been closed since it was checked above, and jobs can't be sent once it is.
+	if c.closed {
+		return "", errors.New("book converter closed")
+	}
+	if conv, converting := c.converting[key]; converting {
+		if conv.err != errBookNotReady {
+			delete(c.converting, key)
+			return "", errors.Wrap(conv.err, "Converting book")
 		}
-		return "", conversionErr
+		return "", conv.err
 	}
 
 	select {
-	case c.fileCh <- bf:
+	case c.jobCh <- conversionJob{bf, format}:
+		c.converting[key] = &conversion{err: errBookNotReady}
 		return "", errBookNotReady
 	default:
 		return "", errQueueFull
 	}
 }
 
+func (c *calibreBookConverter) Progress(bf books.BookFile, format string) (ConversionProgress, bool) {
+	c.convertingMtx.Lock()
+	defer c.convertingMtx.Unlock()
+	conv, ok := c.converting[conversionKey(bf, format)]
+	if !ok || conv.err != errBookNotReady {
+		return ConversionProgress{}, false
+	}
+	return conv.progress, true
+}
+
 func (c *calibreBookConverter) Close() {
+	c.convertingMtx.Lock()
+	defer c.convertingMtx.Unlock()
+	if c.closed {
+		return
+	}
 	c.closed = true
-	close(c.fileCh)
+	close(c.jobCh)
 }
 
-// work listens on c.fileCh for files to convert to epub.
-func (c *calibreBookConverter) work() {
-	for bookFile := range c.fileCh {
-		c.convertingMtx.Lock()
-		c.converting[bookFile.ID] = errBookNotReady
-		c.convertingMtx.Unlock()
+// progressRegexp matches the progress lines ebook-convert prints, such as "34% Running transforms on e-book...".
+var progressRegexp = regexp.MustCompile(`^(\d+)% (.*)$`)
 
-		filename := path.Join(c.booksRoot, bookFile.HashPath())
-		tmpFile := path.Join(c.cacheDir, bookFile.Hash+"."+bookFile.Extension)
-		newFile := path.Join(c.cacheDir, bookFile.Hash+".epub")
-		err := os.Symlink(filename, tmpFile)
+// work listens on c.jobCh for files to convert.
+func (c *calibreBookConverter) work() {
+	for job := range c.jobCh {
+		bookFile := job.bf
+		key := conversionKey(bookFile, job.format)
+		// ebook-convert finds the input format from its extension, which the file in the books root doesn't have.
+		tmpFile := path.Join(c.cacheDir, bookFile.Hash+"-to-"+job.format+"."+bookFile.Extension)
+		newFile := path.Join(c.cacheDir, bookFile.Hash+"."+job.format)
+		filename, release, err := c.lib.CachedFilePath(bookFile)
 		if err == nil {
-			cmd := exec.Command("ebook-convert", tmpFile, newFile)
-			err = cmd.Run()
-			if err := os.Remove(tmpFile); err != nil {
-				log.Printf("Unable to remove %s: %v", tmpFile, err)
+			err = os.Symlink(filename, tmpFile)
+			if err == nil {
+				err = c.run(key, tmpFile, newFile)
+				if err := os.Remove(tmpFile); err != nil {
+					log.Printf("Unable to remove %s: %v", tmpFile, err)
+				}
 			}
+			release()
+		}
+		if err == nil {
+			err = c.lib.SaveDerivation(books.Derivation{Hash: bookFile.Hash, Kind: books.DerivationConversion, Params: job.format, Filename: newFile})
 		}
 
 		c.convertingMtx.Lock()
 		if err != nil {
 			log.Printf("%v", err)
-			c.converting[bookFile.ID] = err
+			c.converting[key].err = err
 		} else {
-			delete(c.converting, bookFile.ID)
+			delete(c.converting, key)
+		}
+		c.convertingMtx.Unlock()
+	}
+}
+
+// run runs ebook-convert, updating the progress of the conversion with key as it reports it.
+func (c *calibreBookConverter) run(key, src, dst string) error {
+	cmd := exec.Command("ebook-convert", src, dst)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		m := progressRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
 		}
+		percent, _ := strconv.Atoi(m[1])
+		c.convertingMtx.Lock()
+		c.converting[key].progress = ConversionProgress{Percent: percent, Message: m[2]}
 		c.convertingMtx.Unlock()
 	}
+	// Read what's left, so ebook-convert doesn't block writing to a full pipe if a line was too long to scan.
+	io.Copy(ioutil.Discard, stdout)
+	return cmd.Wait()
 }
 
 // NewCalibreBookConverter creates a new BookConverter which uses calibre.
-func NewCalibreBookConverter(booksRoot, cacheDir string, numWorkers int) BookConverter {
+// Converted books are cached in cacheDir, and recorded as derivations of their files in lib.
+func NewCalibreBookConverter(lib *books.Library, cacheDir string, numWorkers int) BookConverter {
 	converter := &calibreBookConverter{
-		fileCh:     make(chan books.BookFile),
-		converting: make(map[int64]error),
-		booksRoot:  booksRoot,
+		jobCh:      make(chan conversionJob, conversionQueueSize),
+		converting: make(map[string]*conversion),
+		lib:        lib,
 		cacheDir:   cacheDir,
 	}
 
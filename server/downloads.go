@@ -0,0 +1,202 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/tspivey/books"
+)
+
+// conversionRetrySeconds is how long clients are asked to wait before checking on a conversion again.
+const conversionRetrySeconds = 5
+
+// conversionStatus is returned while a book is being converted to the format it was requested in.
+type conversionStatus struct {
+	Status   string             `json:"status"`
+	FileID   int64              `json:"file_id"`
+	Format   string             `json:"format"`
+	Progress ConversionProgress `json:"progress"`
+}
+
+// addDownloadRoutes adds the routes for downloading books in any format, converting them if needed.
+//
+// GET /api/downloads/{id}/{format} returns the book with the ID in format, such as mobi,
+// as does GET /api/downloads/b/{short}/{format} for the book with the short ID.
+// If the book has a file in that format, it's returned. Otherwise, one of its files is converted,
+// preferring epub, and until the conversion is finished, a conversionStatus is returned with the status 202 Accepted,
+// and a Retry-After header. Converted files are cached, so later requests return them straight away.
+// GET /api/downloads/zip?ids=1,2,3 returns a zip archive of the files with the IDs, or the short IDs if the server only allows them.
+// Routes taking IDs are left out if the server only allows short IDs.
+func (srv *Server) addDownloadRoutes(r *mux.Router) {
+	r.HandleFunc("/zip", srv.downloadZipHandler).Methods("GET")
+	if !srv.shortIDsOnly {
+		r.HandleFunc(`/{id:\d+}/{format:[a-zA-Z0-9]+}`, srv.downloadFormatHandler).Methods("GET", "HEAD")
+	}
+	r.HandleFunc("/b/{short:"+shortIDPattern+"}/{format:[a-zA-Z0-9]+}", srv.downloadFormatHandler).Methods("GET", "HEAD")
+}
+
+// zipResponseWriter sets the headers of a zip download when the archive starts being written,
+// so errors found before then can still be returned as JSON.
+type zipResponseWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (zw *zipResponseWriter) Write(p []byte) (int, error) {
+	if !zw.started {
+		zw.started = true
+		zw.w.Header().Set("Content-Disposition", `attachment; filename="books.zip"`)
+		zw.w.Header().Set("Content-Type", "application/zip")
+	}
+	return zw.w.Write(p)
+}
+
+func (srv *Server) downloadZipHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	for _, s := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		s = strings.TrimSpace(s)
+		if srv.shortIDsOnly {
+			file, err := srv.lib.GetFileByShortID(s)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				writeJSON(w, apiError{books.ErrFileNotFound.Error()})
+				return
+			}
+			ids = append(ids, file.ID)
+			continue
+		}
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid ids"})
+			return
+		}
+		ids = append(ids, id)
+	}
+	zw := &zipResponseWriter{w: w}
+	err := srv.lib.ZipFiles(ids, zw)
+	if err == nil {
+		return
+	}
+	if zw.started {
+		// The archive is cut short, which the client sees as a broken zip.
+		log.Printf("Error sending zip of files %v: %v", ids, err)
+		return
+	}
+	if errors.Cause(err) == books.ErrFileNotFound {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		log.Printf("Cannot zip files %v: %v", ids, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	writeJSON(w, apiError{err.Error()})
+}
+
+func (srv *Server) downloadFormatHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := srv.requestBookID(r)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"no books"})
+		return
+	}
+	format := strings.ToLower(mux.Vars(r)["format"])
+	found, err := srv.lib.GetBooksByID([]int64{id})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error getting book %d: %v", id, err)
+		return
+	}
+	if len(found) == 0 || len(found[0].Files) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"no books"})
+		return
+	}
+
+	file, ok := calibreFormats(found[0])[format]
+	if ok {
+		fn, release, err := srv.lib.CachedFilePath(file)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			writeJSON(w, apiError{err.Error()})
+			return
+		}
+		defer release()
+		srv.serveDownload(w, r, file, fn, format)
+		return
+	}
+	if srv.converter == nil || !isConversionFormat(format) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"unsupported format"})
+		return
+	}
+	file = conversionSource(found[0])
+
+	fn, err := srv.converter.ConvertTo(file, format)
+	switch err {
+	case nil:
+		srv.serveDownload(w, r, file, fn, format)
+	case errBookNotReady:
+		progress, _ := srv.converter.Progress(file, format)
+		w.Header().Set("Retry-After", strconv.Itoa(conversionRetrySeconds))
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, conversionStatus{Status: "converting", FileID: file.ID, Format: format, Progress: progress})
+	case errQueueFull:
+		w.Header().Set("Retry-After", strconv.Itoa(conversionRetrySeconds))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeJSON(w, apiError{"conversion queue is full"})
+	default:
+		log.Printf("Cannot convert file %d to %s: %v", file.ID, format, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"conversion failed"})
+	}
+}
+
+// serveDownload serves fn, which is file in format, as an attachment named after file.
+func (srv *Server) serveDownload(w http.ResponseWriter, r *http.Request, file books.BookFile, fn, format string) {
+	if _, err := os.Stat(fn); err != nil {
+		log.Printf("Cannot download file %d from %s: %v", file.ID, fn, err)
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"file is missing"})
+		return
+	}
+	name := changeExt(path.Base(file.CurrentFilename), "."+format)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+strings.Replace(name, `"`, "'", -1)+"\"")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, fn)
+}
+
+// isConversionFormat returns true if files can be converted to format.
+func isConversionFormat(format string) bool {
+	for _, f := range ConversionFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// conversionSource returns the file of a book which is converted to other formats: its epub, if it has one,
+// since it converts best, or its largest file.
+func conversionSource(book books.Book) books.BookFile {
+	formats := calibreFormats(book)
+	if f, ok := formats["epub"]; ok {
+		return f
+	}
+	var src books.BookFile
+	for _, f := range book.Files {
+		if f.FileSize > src.FileSize || src.ID == 0 {
+			src = f
+		}
+	}
+	return src
+}
@@ -14,17 +14,32 @@ import (
 )
 
 func (srv *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
-	srv.render("index", w, nil)
+	lastSearch, _, err := srv.lib.GetPreference(preferencesUser(r), lastSearchPreference)
+	if err != nil {
+		log.Printf("Error getting last search: %s", err)
+	}
+	srv.render("index", w, results{Query: lastSearch})
+}
+
+// lastSearchPreference is the preference holding a user's most recent search, which the search form is filled in with.
+const lastSearchPreference = "server.last_search"
+
+// preferencesUser returns the user whose preferences apply to a request:
+// the user authenticated with basic authentication, or global preferences if there isn't one.
+func preferencesUser(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	return books.GlobalPreferences
 }
 
 func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
-	fileID := mux.Vars(r)["id"]
-	id, err := strconv.Atoi(fileID)
-	if err != nil {
-		http.NotFound(w, r)
+	id, ok := srv.requestFileID(r)
+	if !ok {
+		srv.render("error_page", w, errorPage{"File not found", "That file doesn't exist in the library."})
 		return
 	}
-	files, err := srv.lib.GetFilesByID([]int64{int64(id)})
+	files, err := srv.lib.GetFilesByID([]int64{id})
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -35,7 +50,12 @@ func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	file := files[0]
 
-	fn := path.Join(srv.booksRoot, file.HashPath())
+	fn, err := srv.lib.FilePath(file)
+	if err != nil {
+		log.Printf("Cannot get the path of file %d: %s", file.ID, err)
+		srv.render("error_page", w, errorPage{"Cannot download file", "That file is on a drive which isn't available right now."})
+		return
+	}
 	base := path.Base(fn)
 	if _, err := os.Stat(fn); os.IsNotExist(err) {
 		log.Printf("File %d is in the library but the file is missing: %s", file.ID, fn)
@@ -68,6 +88,13 @@ func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	fn, release, err := srv.lib.CachedFilePath(file)
+	if err != nil {
+		log.Printf("Cannot fetch file %d: %s", file.ID, err)
+		srv.render("error_page", w, errorPage{"Cannot download file", "That file couldn't be fetched from remote storage."})
+		return
+	}
+	defer release()
 	if _, nameFound := mux.Vars(r)["name"]; !nameFound {
 		w.Header().Set("Content-Disposition", "attachment; filename=\""+base+"\"")
 	}
@@ -75,14 +102,47 @@ func (srv *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, fn)
 }
 
-func (srv *Server) bookDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	bookID := mux.Vars(r)["id"]
-	id, err := strconv.Atoi(bookID)
+// previewHandler serves an image of a page of a PDF file.
+// The resolution can be set with the dpi parameter.
+func (srv *Server) previewHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := srv.requestFileID(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	page, err := strconv.Atoi(mux.Vars(r)["page"])
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
-	books, err := srv.lib.GetBooksByID([]int64{int64(id)})
+	dpi := 0
+	if s := r.URL.Query().Get("dpi"); s != "" {
+		if dpi, err = strconv.Atoi(s); err != nil || dpi < 1 || dpi > books.MaxPreviewDPI {
+			http.Error(w, "Invalid dpi", http.StatusBadRequest)
+			return
+		}
+	}
+	fn, err := srv.lib.RenderPDFPreview(id, page, dpi)
+	if err == books.ErrNotPDF {
+		http.Error(w, "Previews are only available for PDF files", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rendering preview of file %d: %s", id, err)
+		http.Error(w, "The preview couldn't be rendered", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	http.ServeFile(w, r, fn)
+}
+
+func (srv *Server) bookDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := srv.requestBookID(r)
+	if !ok {
+		srv.render("error_page", w, errorPage{"Book not found", "That book doesn't exist in the library."})
+		return
+	}
+	books, err := srv.lib.GetBooksByID([]int64{id})
 	if err != nil {
 		log.Printf("Error getting books by ID: %s", err)
 		http.NotFound(w, r)
@@ -93,6 +153,8 @@ func (srv *Server) bookDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	book := books[0]
+	// Start fetching the book's files from remote books roots, so they're ready if it's downloaded.
+	go srv.lib.Prefetch(book.Files, nil)
 
 	srv.render("book_details", w, book)
 }
@@ -104,8 +166,13 @@ type results struct {
 	Next       int
 	PageLinks  []int
 	Query      string
+	// Suggestions are corrected searches, offered when there are no results.
+	Suggestions []string
 }
 
+// maxSuggestions is the most corrected searches offered when a search has no results.
+const maxSuggestions = 3
+
 type errorPage struct {
 	Short string
 	Long  string
@@ -126,12 +193,22 @@ func (srv *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	books, moreResults, err := srv.lib.SearchPaged(val[0], offset, limit, limit*(maxPageLinks-1))
+	opts := books.SearchOptions{
+		Offset:            offset,
+		Limit:             limit,
+		MoreResultsLimit:  limit * (maxPageLinks - 1),
+		BoostTitleMatches: true,
+		Log:               srv.logSearches,
+	}
+	found, moreResults, err := srv.lib.SearchWithOptions(val[0], opts)
 	if err != nil {
 		log.Printf("Error searching for %s: %s", val[0], err)
 		srv.render("error_page", w, errorPage{"Error while searching", "An error occurred while searching."})
 		return
 	}
+	if err := srv.lib.SetPreference(preferencesUser(r), lastSearchPreference, val[0]); err != nil {
+		log.Printf("Error saving last search: %s", err)
+	}
 
 	morePages := int(math.Ceil(float64(moreResults) / float64(limit)))
 	firstPageLink := pageNumber - int(math.Ceil(float64(maxPageLinks)/2)) + 1
@@ -153,14 +230,21 @@ func (srv *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 	if morePages > 0 {
 		nextPage = pageNumber + 1
 	}
+	var suggestions []string
+	if len(found) == 0 && pageNumber == 1 {
+		if suggestions, err = srv.lib.SearchSuggestions(val[0], maxSuggestions); err != nil {
+			log.Printf("Error getting suggestions for %s: %s", val[0], err)
+		}
+	}
 
 	res := results{
-		Books:      books,
-		PageNumber: pageNumber,
-		Prev:       pageNumber - 1,
-		Next:       nextPage,
-		PageLinks:  pageLinks,
-		Query:      val[0],
+		Books:       books.ResultBooks(found),
+		PageNumber:  pageNumber,
+		Prev:        pageNumber - 1,
+		Next:        nextPage,
+		PageLinks:   pageLinks,
+		Query:       val[0],
+		Suggestions: suggestions,
 	}
 	srv.render("results", w, res)
 }
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// importReportsHandler returns the reports of batch imports, newest first, without the files in them.
+// limit is the maximum number of reports.
+func (srv *Server) importReportsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, apiError{"invalid limit"})
+			return
+		}
+	}
+	reports, err := srv.lib.ImportReports(limit)
+	if err != nil {
+		log.Printf("error getting import reports: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting import reports"})
+		return
+	}
+	writeJSON(w, reports)
+}
+
+// importReportHandler returns an import report, with the outcome of each file.
+// With format=text, it's returned as plain text for people to read.
+func (srv *Server) importReportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	report, found, err := srv.lib.GetImportReport(id)
+	if err != nil {
+		log.Printf("error getting import report %d: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error getting import report"})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"import report not found"})
+		return
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := report.WriteText(w); err != nil {
+			log.Printf("Error writing import report %d: %s", id, err)
+		}
+		return
+	}
+	writeJSON(w, report)
+}
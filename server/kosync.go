@@ -0,0 +1,169 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// kosyncPrefix is where the KOReader progress sync endpoints are served.
+// In KOReader, the custom sync server should be set to the server's address followed by this prefix.
+const kosyncPrefix = "/kosync"
+
+type kosyncError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type kosyncUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type kosyncProgress struct {
+	Document   string  `json:"document"`
+	Progress   string  `json:"progress"`
+	Percentage float64 `json:"percentage"`
+	Device     string  `json:"device"`
+	DeviceID   string  `json:"device_id"`
+	Timestamp  int64   `json:"timestamp,omitempty"`
+}
+
+var (
+	kosyncUnauthorized = kosyncError{2001, "Unauthorized"}
+	kosyncUserExists   = kosyncError{2002, "Username is already registered."}
+	kosyncInvalid      = kosyncError{2003, "Invalid request"}
+	kosyncNoDocument   = kosyncError{2004, "Field 'document' not provided."}
+)
+
+// addKOSyncRoutes adds the endpoints of KOReader's progress sync protocol to r.
+// If registration is false, new users can't be created through the sync protocol.
+func (srv *Server) addKOSyncRoutes(r *mux.Router, registration bool) {
+	kr := r.PathPrefix(kosyncPrefix).Subrouter()
+	kr.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			next.ServeHTTP(w, r)
+		})
+	})
+	kr.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"state": "OK"})
+	})
+	if registration {
+		kr.HandleFunc("/users/create", srv.kosyncCreateUserHandler).Methods("POST")
+	}
+	kr.HandleFunc("/users/auth", srv.kosyncAuth(srv.kosyncAuthHandler)).Methods("GET")
+	kr.HandleFunc("/syncs/progress", srv.kosyncAuth(srv.kosyncUpdateProgressHandler)).Methods("PUT")
+	kr.HandleFunc("/syncs/progress/{document}", srv.kosyncAuth(srv.kosyncGetProgressHandler)).Methods("GET")
+}
+
+// kosyncAuth wraps a handler, calling it only if the x-auth-user and x-auth-key headers identify a sync user.
+func (srv *Server) kosyncAuth(next func(w http.ResponseWriter, r *http.Request, user string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, key := r.Header.Get("x-auth-user"), r.Header.Get("x-auth-key")
+		ok := false
+		if user != "" && key != "" {
+			var err error
+			if ok, err = srv.lib.CheckSyncUser(user, key); err != nil {
+				log.Printf("Error checking sync user: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, kosyncUnauthorized)
+			return
+		}
+		next(w, r, user)
+	}
+}
+
+func (srv *Server) kosyncCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var u kosyncUser
+	if !readPostedJSON(w, r, &u) {
+		return
+	}
+	if u.Username == "" || u.Password == "" {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, kosyncInvalid)
+		return
+	}
+	err := srv.lib.AddSyncUser(u.Username, u.Password)
+	if err == books.ErrUserExists {
+		w.WriteHeader(http.StatusPaymentRequired)
+		writeJSON(w, kosyncUserExists)
+		return
+	} else if err != nil {
+		log.Printf("Error creating sync user: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created sync user %s", u.Username)
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, map[string]string{"username": u.Username})
+}
+
+func (srv *Server) kosyncAuthHandler(w http.ResponseWriter, r *http.Request, user string) {
+	writeJSON(w, map[string]string{"authorized": "OK"})
+}
+
+func (srv *Server) kosyncUpdateProgressHandler(w http.ResponseWriter, r *http.Request, user string) {
+	var p kosyncProgress
+	if !readPostedJSON(w, r, &p) {
+		return
+	}
+	if p.Document == "" {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, kosyncNoDocument)
+		return
+	}
+	if p.Progress == "" || p.Device == "" {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, kosyncInvalid)
+		return
+	}
+	err := srv.lib.SetReadingProgress(books.ReadingProgress{
+		User:       user,
+		Document:   p.Document,
+		Progress:   p.Progress,
+		Percentage: p.Percentage,
+		Device:     p.Device,
+		DeviceID:   p.DeviceID,
+	})
+	if err != nil {
+		log.Printf("Error setting reading progress: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	stored, _, err := srv.lib.GetReadingProgress(user, p.Document)
+	if err != nil {
+		log.Printf("Error getting reading progress: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"document": p.Document, "timestamp": stored.UpdatedOn.Unix()})
+}
+
+func (srv *Server) kosyncGetProgressHandler(w http.ResponseWriter, r *http.Request, user string) {
+	p, found, err := srv.lib.GetReadingProgress(user, mux.Vars(r)["document"])
+	if err != nil {
+		log.Printf("Error getting reading progress: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		writeJSON(w, struct{}{})
+		return
+	}
+	writeJSON(w, kosyncProgress{
+		Document:   p.Document,
+		Progress:   p.Progress,
+		Percentage: p.Percentage,
+		Device:     p.Device,
+		DeviceID:   p.DeviceID,
+		Timestamp:  p.UpdatedOn.Unix(),
+	})
+}
@@ -0,0 +1,427 @@
+package server
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// opdsPrefix is where the OPDS catalog is served.
+const opdsPrefix = "/opds"
+
+// opdsPageSize is the number of entries in each page of an OPDS feed.
+const opdsPageSize = 50
+
+// Types of OPDS feeds and links.
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsSearchType      = "application/opensearchdescription+xml"
+	opdsAcquisitionRel  = "http://opds-spec.org/acquisition"
+	opdsImageRel        = "http://opds-spec.org/image"
+	opdsThumbnailRel    = "http://opds-spec.org/image/thumbnail"
+)
+
+// opdsMediaTypes are the media types of ebook formats, which OPDS clients use to decide which files they can open.
+var opdsMediaTypes = map[string]string{
+	"epub": "application/epub+zip",
+	"pdf":  "application/pdf",
+	"mobi": "application/x-mobipocket-ebook",
+	"azw3": "application/vnd.amazon.ebook",
+	"fb2":  "application/x-fictionbook+xml",
+	"cbz":  "application/vnd.comicbook+zip",
+	"cbr":  "application/vnd.comicbook-rar",
+	"djvu": "image/vnd.djvu",
+	"txt":  "text/plain",
+	"rtf":  "application/rtf",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+}
+
+type opdsFeed struct {
+	XMLName         xml.Name    `xml:"feed"`
+	Xmlns           string      `xml:"xmlns,attr"`
+	XmlnsDC         string      `xml:"xmlns:dc,attr"`
+	XmlnsOpenSearch string      `xml:"xmlns:opensearch,attr"`
+	ID              string      `xml:"id"`
+	Title           string      `xml:"title"`
+	Updated         string      `xml:"updated"`
+	TotalResults    int         `xml:"opensearch:totalResults,omitempty"`
+	Links           []opdsLink  `xml:"link"`
+	Entries         []opdsEntry `xml:"entry"`
+}
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type opdsEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []opdsAuthor   `xml:"author"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Identifier []string       `xml:"dc:identifier"`
+	Categories []opdsCategory `xml:"category"`
+	Content    *opdsContent   `xml:"content"`
+	Links      []opdsLink     `xml:"link"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+}
+
+type opdsCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type opdsContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type openSearchDescription struct {
+	XMLName     xml.Name      `xml:"OpenSearchDescription"`
+	Xmlns       string        `xml:"xmlns,attr"`
+	ShortName   string        `xml:"ShortName"`
+	Description string        `xml:"Description"`
+	URL         openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// addOPDSRoutes adds an OPDS 1.2 catalog of the library under opdsPrefix, for browsing and downloading books from e-readers.
+// Books can be browsed by author, series and tag, listed from newest, and searched.
+// Each book links to its files, and books without an EPUB link to one converted on demand.
+func (srv *Server) addOPDSRoutes(r *mux.Router) {
+	or := r.PathPrefix(opdsPrefix).Subrouter()
+	or.HandleFunc("", srv.opdsRootHandler)
+	or.HandleFunc("/", srv.opdsRootHandler)
+	or.HandleFunc("/new", srv.opdsNewHandler)
+	or.HandleFunc("/authors", srv.opdsNamesHandler("authors", "Authors", func() ([]string, error) {
+		return srv.lib.Contributors(books.RoleAuthor)
+	}))
+	or.HandleFunc("/authors/{name}", srv.opdsBooksHandler("authors", func(name string) ([]int64, error) {
+		return srv.lib.ContributorBookIDs(name, books.RoleAuthor)
+	}))
+	or.HandleFunc("/series", srv.opdsNamesHandler("series", "Series", srv.lib.SeriesNames))
+	or.HandleFunc("/series/{name}", srv.opdsBooksHandler("series", srv.lib.SeriesBookIDs))
+	or.HandleFunc("/tags", srv.opdsNamesHandler("tags", "Tags", srv.lib.TagNames))
+	or.HandleFunc("/tags/{name}", srv.opdsBooksHandler("tags", srv.lib.TagBookIDs))
+	or.HandleFunc("/search", srv.opdsSearchHandler)
+	or.HandleFunc("/opensearch.xml", srv.opdsOpenSearchHandler)
+	or.HandleFunc("/download/{short:"+shortIDPattern+"}/epub", srv.opdsConvertHandler)
+}
+
+// newOPDSFeed returns a feed with links to itself, the start of the catalog, and search.
+func newOPDSFeed(id, title, self, kind string) opdsFeed {
+	return opdsFeed{
+		Xmlns:           "http://www.w3.org/2005/Atom",
+		XmlnsDC:         "http://purl.org/dc/terms/",
+		XmlnsOpenSearch: "http://a9.com/-/spec/opensearch/1.1/",
+		ID:              "urn:books:opds:" + id,
+		Title:           title,
+		Updated:         time.Now().UTC().Format(time.RFC3339),
+		Links: []opdsLink{
+			{Rel: "self", Href: self, Type: kind},
+			{Rel: "start", Href: opdsPrefix, Type: opdsNavigationType},
+			{Rel: "search", Href: opdsPrefix + "/opensearch.xml", Type: opdsSearchType},
+		},
+	}
+}
+
+// writeOPDS writes a feed of kind.
+func writeOPDS(w http.ResponseWriter, kind string, v interface{}) {
+	w.Header().Set("Content-Type", kind+";charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Error writing OPDS feed: %s", err)
+	}
+}
+
+// opdsOffset returns the offset of the page of a feed requested by r.
+func opdsOffset(r *http.Request) int {
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// addPageLinks adds links to the previous and next pages of a feed at self, if there are any.
+func addPageLinks(feed *opdsFeed, self string, offset int, more bool) {
+	sep := "?"
+	if strings.Contains(self, "?") {
+		sep = "&"
+	}
+	if offset > 0 {
+		prev := offset - opdsPageSize
+		if prev < 0 {
+			prev = 0
+		}
+		feed.Links = append(feed.Links, opdsLink{Rel: "previous", Href: self + sep + "offset=" + strconv.Itoa(prev), Type: feed.Links[0].Type})
+	}
+	if more {
+		feed.Links = append(feed.Links, opdsLink{Rel: "next", Href: self + sep + "offset=" + strconv.Itoa(offset+opdsPageSize), Type: feed.Links[0].Type})
+	}
+}
+
+func (srv *Server) opdsRootHandler(w http.ResponseWriter, r *http.Request) {
+	feed := newOPDSFeed("root", "Library", opdsPrefix, opdsNavigationType)
+	now := feed.Updated
+	for _, nav := range []struct{ id, title, content string }{
+		{"new", "New books", "The books added most recently"},
+		{"authors", "Authors", "Books by author"},
+		{"series", "Series", "Books by series"},
+		{"tags", "Tags", "Books by tag"},
+	} {
+		kind := opdsNavigationType
+		if nav.id == "new" {
+			kind = opdsAcquisitionType
+		}
+		feed.Entries = append(feed.Entries, opdsEntry{
+			Title:   nav.title,
+			ID:      "urn:books:opds:" + nav.id,
+			Updated: now,
+			Content: &opdsContent{Type: "text", Text: nav.content},
+			Links:   []opdsLink{{Rel: "subsection", Href: opdsPrefix + "/" + nav.id, Type: kind}},
+		})
+	}
+	writeOPDS(w, opdsNavigationType, feed)
+}
+
+func (srv *Server) opdsNewHandler(w http.ResponseWriter, r *http.Request) {
+	ids, err := srv.lib.ListBookIDs(books.SortByFileAdded, true)
+	if err != nil {
+		log.Printf("Error listing books: %s", err)
+		http.Error(w, "error listing books", http.StatusInternalServerError)
+		return
+	}
+	srv.writeOPDSBooks(w, r, newOPDSFeed("new", "New books", opdsPrefix+"/new", opdsAcquisitionType), ids)
+}
+
+// opdsNamesHandler returns a handler for a navigation feed of names, such as authors, which link to the books with each name.
+func (srv *Server) opdsNamesHandler(id, title string, names func() ([]string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := names()
+		if err != nil {
+			log.Printf("Error getting %s: %s", id, err)
+			http.Error(w, "error getting "+id, http.StatusInternalServerError)
+			return
+		}
+		self := opdsPrefix + "/" + id
+		feed := newOPDSFeed(id, title, self, opdsNavigationType)
+		feed.TotalResults = len(list)
+		offset := opdsOffset(r)
+		end := offset + opdsPageSize
+		if end > len(list) {
+			end = len(list)
+		}
+		if offset < end {
+			for _, name := range list[offset:end] {
+				feed.Entries = append(feed.Entries, opdsEntry{
+					Title:   name,
+					ID:      "urn:books:opds:" + id + ":" + url.PathEscape(name),
+					Updated: feed.Updated,
+					Links:   []opdsLink{{Rel: "subsection", Href: self + "/" + url.PathEscape(name), Type: opdsAcquisitionType}},
+				})
+			}
+		}
+		addPageLinks(&feed, self, offset, end < len(list))
+		writeOPDS(w, opdsNavigationType, feed)
+	}
+}
+
+// opdsBooksHandler returns a handler for an acquisition feed of the books with a name, such as the books by an author.
+func (srv *Server) opdsBooksHandler(id string, bookIDs func(name string) ([]int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		ids, err := bookIDs(name)
+		if err != nil {
+			log.Printf("Error getting books in %s %s: %s", id, name, err)
+			http.Error(w, "error getting books", http.StatusInternalServerError)
+			return
+		}
+		if id != "series" {
+			// Series are already in order.
+			if ids, err = srv.lib.SortBookIDs(ids, books.SortByTitle, false); err != nil {
+				log.Printf("Error sorting books: %s", err)
+				http.Error(w, "error sorting books", http.StatusInternalServerError)
+				return
+			}
+		}
+		self := opdsPrefix + "/" + id + "/" + url.PathEscape(name)
+		srv.writeOPDSBooks(w, r, newOPDSFeed(id+":"+url.PathEscape(name), name, self, opdsAcquisitionType), ids)
+	}
+}
+
+// writeOPDSBooks writes feed, with the page of the books with ids requested by r.
+func (srv *Server) writeOPDSBooks(w http.ResponseWriter, r *http.Request, feed opdsFeed, ids []int64) {
+	feed.TotalResults = len(ids)
+	offset := opdsOffset(r)
+	end := offset + opdsPageSize
+	if end > len(ids) {
+		end = len(ids)
+	}
+	var page []int64
+	if offset < end {
+		page = ids[offset:end]
+	}
+	found, err := srv.lib.GetBooksByID(page)
+	if err != nil {
+		log.Printf("Error getting books: %s", err)
+		http.Error(w, "error getting books", http.StatusInternalServerError)
+		return
+	}
+	for _, b := range found {
+		feed.Entries = append(feed.Entries, opdsBookEntry(b))
+	}
+	addPageLinks(&feed, feed.Links[0].Href, offset, end < len(ids))
+	writeOPDS(w, opdsAcquisitionType, feed)
+}
+
+func (srv *Server) opdsSearchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	self := opdsPrefix + "/search?q=" + url.QueryEscape(q)
+	feed := newOPDSFeed("search:"+url.QueryEscape(q), "Search for "+q, self, opdsAcquisitionType)
+	offset := opdsOffset(r)
+	results, more, err := srv.lib.SearchWithOptions(q, books.SearchOptions{Offset: offset, Limit: opdsPageSize, MoreResultsLimit: 1, Log: srv.logSearches})
+	if err != nil {
+		log.Printf("Error searching for %s: %s", q, err)
+		http.Error(w, "error searching", http.StatusInternalServerError)
+		return
+	}
+	for _, res := range results {
+		feed.Entries = append(feed.Entries, opdsBookEntry(res.Book))
+	}
+	addPageLinks(&feed, self, offset, more > 0)
+	writeOPDS(w, opdsAcquisitionType, feed)
+}
+
+func (srv *Server) opdsOpenSearchHandler(w http.ResponseWriter, r *http.Request) {
+	writeOPDS(w, opdsSearchType, openSearchDescription{
+		Xmlns:       "http://a9.com/-/spec/opensearch/1.1/",
+		ShortName:   "Library",
+		Description: "Search the library",
+		URL:         openSearchURL{Type: opdsAcquisitionType, Template: opdsPrefix + "/search?q={searchTerms}"},
+	})
+}
+
+// opdsBookEntry describes a book in an acquisition feed, with links to download each of its formats.
+func opdsBookEntry(book books.Book) opdsEntry {
+	id := strconv.FormatInt(book.ID, 10)
+	e := opdsEntry{
+		Title:     book.Title,
+		ID:        "urn:uuid:" + book.UUID,
+		Updated:   book.UpdatedOn.UTC().Format(time.RFC3339),
+		Publisher: book.Publisher,
+	}
+	if book.UUID == "" {
+		e.ID = "urn:books:book:" + id
+	}
+	for _, a := range book.Authors {
+		e.Authors = append(e.Authors, opdsAuthor{a})
+	}
+	for _, ident := range book.Identifiers {
+		e.Identifier = append(e.Identifier, "urn:"+ident.Type+":"+ident.Value)
+	}
+	for _, t := range book.Tags {
+		e.Categories = append(e.Categories, opdsCategory{Term: t, Label: t})
+	}
+	if book.Series != "" {
+		series := book.Series
+		if book.SeriesIndex != 0 {
+			series += " #" + strconv.FormatFloat(book.SeriesIndex, 'f', -1, 64)
+		}
+		e.Content = &opdsContent{Type: "text", Text: "Series: " + series}
+	}
+
+	formats := calibreFormats(book)
+	hasCover := false
+	for _, f := range book.Files {
+		ext := strings.ToLower(f.Extension)
+		if formats[ext].ID != f.ID {
+			continue
+		}
+		e.Links = append(e.Links, opdsLink{Rel: opdsAcquisitionRel, Href: "/f/" + f.ShortID(), Type: opdsMediaType(ext), Title: strings.ToUpper(ext)})
+		hasCover = hasCover || books.GetFormatCapabilities(ext).CoverExtraction
+	}
+	if _, ok := formats["epub"]; !ok {
+		if src := conversionSource(book); src.ID != 0 && books.GetFormatCapabilities(src.Extension).ConversionSource {
+			e.Links = append(e.Links, opdsLink{Rel: opdsAcquisitionRel, Href: opdsPrefix + "/download/" + src.ShortID() + "/epub", Type: opdsMediaTypes["epub"], Title: "EPUB (converted)"})
+		}
+	}
+	if hasCover {
+		cover := "/b/" + book.ShortID() + "/cover"
+		e.Links = append(e.Links, opdsLink{Rel: opdsImageRel, Href: cover}, opdsLink{Rel: opdsThumbnailRel, Href: cover})
+	}
+	return e
+}
+
+// opdsMediaType returns the media type of files with an extension.
+func opdsMediaType(ext string) string {
+	if t, ok := opdsMediaTypes[ext]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// opdsConvertHandler serves a file converted to EPUB, converting it with ConvertToEpub first if it hasn't been already.
+// E-readers wait for downloads rather than retrying them, so the file is converted while the request waits.
+func (srv *Server) opdsConvertHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := srv.requestFileID(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	files, err := srv.lib.GetFilesByID([]int64{id})
+	if err != nil {
+		log.Printf("Error getting file %d: %s", id, err)
+		http.Error(w, "error getting file", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 || !books.GetFormatCapabilities(files[0].Extension).ConversionSource {
+		http.NotFound(w, r)
+		return
+	}
+	file := files[0]
+
+	srv.convertLock.Lock()
+	d, found, err := srv.lib.GetDerivation(file.Hash, books.DerivationConversion, "epub")
+	if err == nil && !found {
+		if err = srv.lib.ConvertToEpub(file); err == nil {
+			d, found, err = srv.lib.GetDerivation(file.Hash, books.DerivationConversion, "epub")
+		}
+	}
+	srv.convertLock.Unlock()
+	if err != nil || !found {
+		log.Printf("Cannot convert file %d to epub: %v", id, err)
+		http.Error(w, "the book couldn't be converted", http.StatusInternalServerError)
+		return
+	}
+	if _, err := os.Stat(d.Filename); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	name := changeExt(path.Base(file.CurrentFilename), ".epub")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+strings.Replace(name, `"`, "'", -1)+"\"")
+	w.Header().Set("Content-Type", opdsMediaTypes["epub"])
+	http.ServeFile(w, r, d.Filename)
+}
@@ -28,6 +28,18 @@ type Server struct {
 	itemsPerPage   int
 	booksRoot      string
 	outputTemplate *txtTemplate.Template
+	metadataParser books.MetadataParser
+	importOptions  books.ImportOptions
+	// apiLock serializes requests which change the library.
+	apiLock *sync.Mutex
+	// uploadsDir holds unfinished chunked uploads, and uploadLocks holds a *sync.Mutex for each of them.
+	uploadsDir  string
+	uploadLocks sync.Map
+	// convertLock serializes conversions started from the OPDS catalog, which run while the request waits.
+	convertLock sync.Mutex
+	logSearches bool
+	// shortIDsOnly is true if books and files can only be found by their short IDs, as described in Config.
+	shortIDsOnly bool
 }
 
 // Config is the configuration of the server, used in New.
@@ -40,6 +52,31 @@ type Config struct {
 	HtpasswdFile   string
 	BooksRoot      string
 	OutputTemplate *txtTemplate.Template
+	// KOSync enables the KOReader progress sync endpoints, which authenticate sync users instead of using HtpasswdFile.
+	KOSync bool
+	// KOSyncRegistration allows new sync users to register from KOReader.
+	KOSyncRegistration bool
+	// CalibreAPI enables endpoints compatible with Calibre's content server, for apps which browse Calibre libraries.
+	CalibreAPI bool
+	// OPDS enables an OPDS catalog under /opds, for browsing and downloading books from e-readers.
+	OPDS bool
+	// AdminUI enables the pages under /admin/ for listing, editing and uploading books.
+	// They change the library, so HtpasswdFile should be used to require a login.
+	AdminUI bool
+	// MetadataParser parses the metadata of books uploaded with the admin UI, if not nil.
+	MetadataParser books.MetadataParser
+	// ImportOptions are the options uploaded books are imported with. They're always moved into the library.
+	ImportOptions books.ImportOptions
+	// UploadsDir holds books being uploaded to the API in chunks. If empty, chunked uploads are disabled.
+	UploadsDir string
+	// ShortIDsOnly disables the pages and API endpoints which find books, files and import reports by their IDs,
+	// leaving those which find them by their short IDs, such as under /b/ and /f/,
+	// so the library's size can't be learned from its URLs, nor its books listed by counting.
+	// Zip downloads and book updates take short IDs instead of IDs, books and files are given by the API without their IDs,
+	// and the Calibre content server API, whose apps need IDs, is disabled.
+	ShortIDsOnly bool
+	// LogSearches records the searches made from the web pages, the API and the OPDS catalog, for Library.SearchAnalytics.
+	LogSearches bool
 }
 
 // New creates a new server.
@@ -52,41 +89,132 @@ func New(cfg *Config) *Server {
 		"pathEscape":    url.PathEscape,
 		"changeExt":     changeExt,
 		"ByteCountSI":   books.ByteCountSI,
+		"join":          strings.Join,
+		"bookURL":       func(id int64) string { return bookURL(cfg.Lib, id) },
 	}
+	templates := template.Must(template.New("template").Funcs(htmlFuncMap).Parse(adminTemplates))
 	srv := &Server{
 		lib:            cfg.Lib,
-		templates:      template.Must(template.New("template").Funcs(htmlFuncMap).ParseGlob(path.Join(cfg.TemplatesDir, "*.html"))),
+		templates:      template.Must(templates.ParseGlob(path.Join(cfg.TemplatesDir, "*.html"))),
 		converter:      cfg.Converter,
 		hsrv:           cfg.Hsrv,
 		itemsPerPage:   cfg.ItemsPerPage,
 		booksRoot:      cfg.BooksRoot,
 		outputTemplate: cfg.OutputTemplate,
+		metadataParser: cfg.MetadataParser,
+		importOptions:  cfg.ImportOptions,
+		apiLock:        &sync.Mutex{},
+		uploadsDir:     cfg.UploadsDir,
+		logSearches:    cfg.LogSearches,
+		shortIDsOnly:   cfg.ShortIDsOnly,
 	}
 
 	r := mux.NewRouter()
 	r.HandleFunc("/", srv.indexHandler)
-	r.HandleFunc("/book/{id:\\d+}", srv.bookDetailsHandler)
-	r.HandleFunc("/download/{id:\\d+}/{name:.+}", srv.downloadHandler)
-	r.HandleFunc("/download/{id:\\d+}", srv.downloadHandler)
+	if !cfg.ShortIDsOnly {
+		r.HandleFunc("/book/{id:\\d+}", srv.bookDetailsHandler)
+		r.HandleFunc("/download/{id:\\d+}/{name:.+}", srv.downloadHandler)
+		r.HandleFunc("/download/{id:\\d+}", srv.downloadHandler)
+		r.HandleFunc("/preview/{id:\\d+}/{page:\\d+}", srv.previewHandler)
+		r.HandleFunc("/cover/{id:\\d+}", srv.coverHandler)
+	}
+	r.HandleFunc("/b/{short:"+shortIDPattern+"}", srv.bookDetailsHandler)
+	r.HandleFunc("/b/{short:"+shortIDPattern+"}/cover", srv.coverHandler)
+	r.HandleFunc("/f/{short:"+shortIDPattern+"}/preview/{page:\\d+}", srv.previewHandler)
+	r.HandleFunc("/f/{short:"+shortIDPattern+"}/{name:.+}", srv.downloadHandler)
+	r.HandleFunc("/f/{short:"+shortIDPattern+"}", srv.downloadHandler)
 	r.HandleFunc("/search/", srv.searchHandler)
-	apiRouter := r.PathPrefix("/api/").Subrouter()
+	r.HandleFunc("/activity.rss", srv.activityFeedHandler)
 	key := os.Getenv("BOOKS_API_KEY")
 	if key == "" {
 		log.Printf("Warning: BOOKS_API_KEY not set; API disabled")
 	}
-	apiLock := &sync.Mutex{}
+	if cfg.UploadsDir != "" {
+		// Chunks can take a long time to arrive, so uploads only hold the API lock while they're imported.
+		uploadRouter := r.PathPrefix("/api/uploads").Subrouter()
+		uploadRouter.Use(func(next http.Handler) http.Handler {
+			return apiKeyMiddleware(key, next, nil)
+		})
+		srv.addUploadRoutes(uploadRouter)
+	}
+	// Downloads can take a long time to send, so they don't hold the API lock.
+	downloadRouter := r.PathPrefix("/api/downloads").Subrouter()
+	downloadRouter.Use(func(next http.Handler) http.Handler {
+		return apiKeyMiddleware(key, next, nil)
+	})
+	srv.addDownloadRoutes(downloadRouter)
+	apiRouter := r.PathPrefix("/api/").Subrouter()
 	apiRouter.Use(func(next http.Handler) http.Handler {
-		return apiKeyMiddleware(key, next, apiLock)
+		return apiKeyMiddleware(key, next, srv.apiLock)
 	})
-	apiRouter.HandleFunc(`/book/{id:\d+}`, srv.getBookHandler)
+	if !cfg.ShortIDsOnly {
+		apiRouter.HandleFunc(`/book/{id:\d+}`, srv.getBookHandler)
+		apiRouter.HandleFunc(`/import-reports/{id:\d+}`, srv.importReportHandler)
+		apiRouter.HandleFunc(`/preview/{id:\d+}`, srv.apiPreviewHandler)
+		apiRouter.HandleFunc(`/cover/{id:\d+}`, srv.apiCoverHandler)
+	}
+	apiRouter.HandleFunc("/b/{short:"+shortIDPattern+"}", srv.getBookHandler)
+	apiRouter.HandleFunc("/b/{short:"+shortIDPattern+"}/cover", srv.apiCoverHandler)
+	apiRouter.HandleFunc("/identifier/{type}/{value}", srv.getBookByIdentifierHandler)
 	apiRouter.HandleFunc("/update", srv.updateBookHandler).Methods("POST")
 	apiRouter.HandleFunc("/merge", srv.mergeHandler).Methods("POST")
+	apiRouter.HandleFunc("/translation", srv.translationHandler).Methods("POST")
+	apiRouter.HandleFunc("/rating", srv.ratingHandler).Methods("POST")
+	apiRouter.HandleFunc("/tags/promote", srv.promoteTagHandler).Methods("POST")
+	apiRouter.HandleFunc("/tags/demote", srv.demoteTagHandler).Methods("POST")
 	apiRouter.HandleFunc("/search", srv.apiSearchHandler)
+	apiRouter.HandleFunc("/suggest", srv.apiSuggestHandler)
+	apiRouter.HandleFunc("/search-analytics", srv.searchAnalyticsHandler)
+	apiRouter.HandleFunc("/random", srv.apiRandomHandler)
+	apiRouter.HandleFunc("/goals", srv.getGoalsHandler).Methods("GET")
+	apiRouter.HandleFunc("/goals", srv.setGoalHandler).Methods("POST")
+	apiRouter.HandleFunc("/year-in-review", srv.yearInReviewHandler)
+	apiRouter.HandleFunc("/activity", srv.apiActivityHandler)
+	apiRouter.HandleFunc("/import-reports", srv.importReportsHandler)
+	apiRouter.HandleFunc("/f/{short:"+shortIDPattern+"}/preview", srv.apiPreviewHandler)
+	apiRouter.HandleFunc("/formats", srv.formatsHandler)
+	apiRouter.HandleFunc("/classifications/{scheme}", srv.classificationTreeHandler)
+	apiRouter.HandleFunc("/classifications/{scheme}/books", srv.classifiedBooksHandler)
+	apiRouter.HandleFunc("/contributors/{role}", srv.contributorsHandler)
+	apiRouter.HandleFunc("/contributors/{role}/books", srv.contributorBooksHandler)
+	apiRouter.HandleFunc(`/authors/{id:\d+}/coauthors`, srv.coAuthorsHandler)
+	apiRouter.HandleFunc("/authors/graph", srv.authorGraphHandler)
+	apiRouter.HandleFunc("/decades", srv.decadesHandler)
+	if cfg.KOSync {
+		srv.addKOSyncRoutes(r, cfg.KOSyncRegistration)
+		log.Printf("KOReader progress sync enabled at %s", kosyncPrefix)
+	}
+	if cfg.CalibreAPI && cfg.ShortIDsOnly {
+		// Apps written for Calibre find books by their IDs, which can't be replaced with short IDs.
+		log.Printf("Calibre content server API disabled, since only short IDs are allowed")
+	} else if cfg.CalibreAPI {
+		srv.addCalibreRoutes(r)
+		log.Printf("Calibre content server API enabled")
+	}
+	if cfg.OPDS {
+		srv.addOPDSRoutes(r)
+		log.Printf("OPDS catalog enabled at %s", opdsPrefix)
+	}
+	if cfg.AdminUI {
+		srv.addAdminRoutes(r)
+		log.Printf("Admin UI enabled at %s/", adminPrefix)
+		if _, err := os.Stat(cfg.HtpasswdFile); err != nil {
+			log.Printf("Warning: the admin UI is enabled without authentication, so anyone who can reach the server can change the library")
+		}
+	}
 	secProvider := auth.HtpasswdFileProvider(cfg.HtpasswdFile)
 	authHandler := auth.NewBasicAuthenticator("Basic Realm", secProvider)
 	handler := http.Handler(r)
 	if _, err := os.Stat(cfg.HtpasswdFile); err == nil {
-		handler = auth.JustCheck(authHandler, handler.ServeHTTP)
+		checked := auth.JustCheck(authHandler, r.ServeHTTP)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// KOReader can't use basic authentication, so sync users are authenticated by the sync endpoints themselves.
+			if cfg.KOSync && strings.HasPrefix(req.URL.Path, kosyncPrefix+"/") {
+				r.ServeHTTP(w, req)
+				return
+			}
+			checked(w, req)
+		})
 		log.Printf("Using htpasswd file: %s\n", cfg.HtpasswdFile)
 	}
 	srv.hsrv.Handler = handler
@@ -133,6 +261,7 @@ func changeExt(pathname string, ext string) string {
 	return strings.TrimSuffix(pathname, path.Ext(pathname)) + ext
 }
 
+// apiKeyMiddleware only lets requests with the API key through to next, and holds apiLock while they're handled, if it isn't nil.
 func apiKeyMiddleware(key string, next http.Handler, apiLock *sync.Mutex) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if key == "" || r.Header.Get("x-API-key") != key {
@@ -140,6 +269,10 @@ func apiKeyMiddleware(key string, next http.Handler, apiLock *sync.Mutex) http.H
 			writeJSON(w, apiError{"forbidden"})
 			return
 		}
+		if apiLock == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 		apiLock.Lock()
 		next.ServeHTTP(w, r)
 		apiLock.Unlock()
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/tspivey/books"
+)
+
+// shortIDPattern matches short IDs in routes. Books and files with UUIDs from other programs have them as their short IDs.
+const shortIDPattern = `[0-9A-Za-z-]+`
+
+// requestBookID returns the ID of the book in a request's path, given by its ID, or by its short ID in share links.
+// ok is false if the book doesn't exist.
+func (srv *Server) requestBookID(r *http.Request) (id int64, ok bool) {
+	vars := mux.Vars(r)
+	if shortID, found := vars["short"]; found {
+		book, err := srv.lib.GetBookByShortID(shortID)
+		if err != nil {
+			if err != books.ErrBookNotFound {
+				log.Printf("Error getting book %s: %s", shortID, err)
+			}
+			return 0, false
+		}
+		return book.ID, true
+	}
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	return id, err == nil
+}
+
+// requestFileID returns the ID of the file in a request's path, given by its ID, or by its short ID in share links.
+// ok is false if the file doesn't exist.
+func (srv *Server) requestFileID(r *http.Request) (id int64, ok bool) {
+	vars := mux.Vars(r)
+	if shortID, found := vars["short"]; found {
+		file, err := srv.lib.GetFileByShortID(shortID)
+		if err != nil {
+			if err != books.ErrFileNotFound {
+				log.Printf("Error getting file %s: %s", shortID, err)
+			}
+			return 0, false
+		}
+		return file.ID, true
+	}
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	return id, err == nil
+}
+
+// bookShortID returns the short ID of the book with id, or false if it can't be found.
+func bookShortID(lib *books.Library, id int64) (string, bool) {
+	bks, err := lib.GetBooksByID([]int64{id})
+	if err != nil || len(bks) == 0 {
+		return "", false
+	}
+	return bks[0].ShortID(), true
+}
+
+// bookURL returns the path of the details page of the book with id, by its short ID.
+// It's used by templates to link to books they only have the IDs of, such as translations.
+func bookURL(lib *books.Library, id int64) string {
+	if shortID, ok := bookShortID(lib, id); ok {
+		return "/b/" + shortID
+	}
+	return "/book/" + strconv.FormatInt(id, 10)
+}
+
+// bookRef returns how the book with id is referred to in messages: by its short ID if the server only allows short IDs, or by its ID.
+func (srv *Server) bookRef(id int64) string {
+	if srv.shortIDsOnly {
+		if shortID, ok := bookShortID(srv.lib, id); ok {
+			return shortID
+		}
+	}
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Limits of chunked uploads.
+const (
+	// maxChunkSize is the most data which can be sent in one chunk.
+	maxChunkSize = 64 << 20
+	// uploadExpiry is how long an unfinished upload is kept after it was last written to.
+	uploadExpiry = 24 * time.Hour
+)
+
+// uploadOffsetHeader holds the offset a chunk starts at.
+const uploadOffsetHeader = "Upload-Offset"
+
+// uploadInfoFile is the file in an upload's directory which holds what it is.
+const uploadInfoFile = "upload.json"
+
+var uploadIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+var hashRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// newUpload starts a chunked upload.
+type newUpload struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	// Hash is the hex-encoded SHA-256 hash of the whole file, which it's checked against once it's uploaded.
+	Hash    string   `json:"hash"`
+	Title   string   `json:"title"`
+	Authors []string `json:"authors"`
+	Series  string   `json:"series"`
+	Tags    []string `json:"tags"`
+}
+
+// upload is the state of a chunked upload.
+type upload struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	// Offset is the amount of the file uploaded so far, which the next chunk starts at.
+	Offset int64 `json:"offset"`
+	// BookID and BookShortID are the book the file was imported into, once the upload is finished.
+	// BookID is left out if the server only allows short IDs.
+	BookID      int64  `json:"book_id,omitempty"`
+	BookShortID string `json:"book_short_id,omitempty"`
+}
+
+// addUploadRoutes adds the routes for uploading books to the API in chunks, which can be resumed after a failure.
+//
+// POST /api/uploads, with a newUpload, starts an upload, and returns it.
+// GET /api/uploads/{id} returns an upload, with the offset the next chunk should start at.
+// PATCH /api/uploads/{id} appends its body to the file, at the offset in the Upload-Offset header, and returns the new offset.
+// When the last chunk is written, the file is checked against its hash, and imported. If the import fails,
+// it can be retried by sending an empty chunk at the end of the file.
+// DELETE /api/uploads/{id} cancels an upload.
+// Unfinished uploads are removed after uploadExpiry.
+func (srv *Server) addUploadRoutes(r *mux.Router) {
+	r.HandleFunc("", srv.createUploadHandler).Methods("POST")
+	r.HandleFunc("/{id:[0-9a-f]{32}}", srv.getUploadHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/{id:[0-9a-f]{32}}", srv.uploadChunkHandler).Methods("PATCH")
+	r.HandleFunc("/{id:[0-9a-f]{32}}", srv.deleteUploadHandler).Methods("DELETE")
+}
+
+// uploadLock returns the lock held while an upload is written to.
+func (srv *Server) uploadLock(id string) *sync.Mutex {
+	l, _ := srv.uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	return l.(*sync.Mutex)
+}
+
+// uploadDir returns the directory an upload is kept in.
+func (srv *Server) uploadDir(id string) string {
+	return filepath.Join(srv.uploadsDir, id)
+}
+
+// readUpload returns the details of an upload, and the filename of its data.
+func (srv *Server) readUpload(id string) (nu newUpload, fn string, err error) {
+	b, err := ioutil.ReadFile(filepath.Join(srv.uploadDir(id), uploadInfoFile))
+	if err != nil {
+		return nu, "", err
+	}
+	if err := json.Unmarshal(b, &nu); err != nil {
+		return nu, "", err
+	}
+	return nu, filepath.Join(srv.uploadDir(id), nu.Filename), nil
+}
+
+// expireUploads removes the unfinished uploads which haven't been written to within uploadExpiry.
+func (srv *Server) expireUploads() {
+	dirs, err := ioutil.ReadDir(srv.uploadsDir)
+	if err != nil {
+		return
+	}
+	for _, d := range dirs {
+		if d.IsDir() && uploadIDRegexp.MatchString(d.Name()) && time.Since(d.ModTime()) > uploadExpiry {
+			log.Printf("Removing expired upload %s", d.Name())
+			os.RemoveAll(srv.uploadDir(d.Name()))
+		}
+	}
+}
+
+func (srv *Server) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var nu newUpload
+	if !readPostedJSON(w, r, &nu) {
+		return
+	}
+	name, err := uploadFilename(nu.Filename)
+	if err != nil || name == uploadInfoFile {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"invalid filename"})
+		return
+	}
+	nu.Filename = name
+	if nu.Size < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"invalid size"})
+		return
+	}
+	if !hashRegexp.MatchString(nu.Hash) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"hash must be a hex-encoded SHA-256 hash"})
+		return
+	}
+	srv.expireUploads()
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating upload ID: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error creating upload"})
+		return
+	}
+	id := hex.EncodeToString(b)
+	err = os.MkdirAll(srv.uploadDir(id), 0755)
+	if err == nil {
+		var info []byte
+		if info, err = json.Marshal(nu); err == nil {
+			err = ioutil.WriteFile(filepath.Join(srv.uploadDir(id), uploadInfoFile), info, 0644)
+		}
+	}
+	if err == nil {
+		var f *os.File
+		if f, err = os.Create(filepath.Join(srv.uploadDir(id), nu.Filename)); err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("Error creating upload: %s", err)
+		os.RemoveAll(srv.uploadDir(id))
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error creating upload"})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, upload{ID: id, Filename: nu.Filename, Size: nu.Size})
+}
+
+// getUpload returns the upload named in a request's path, with the offset the next chunk starts at.
+// If it doesn't exist, an error is written, and ok is false.
+func (srv *Server) getUpload(w http.ResponseWriter, r *http.Request) (u upload, nu newUpload, fn string, ok bool) {
+	id := mux.Vars(r)["id"]
+	nu, fn, err := srv.readUpload(id)
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = os.Stat(fn)
+	}
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, apiError{"upload not found"})
+		return u, nu, "", false
+	}
+	if err != nil {
+		log.Printf("Error reading upload %s: %s", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error reading upload"})
+		return u, nu, "", false
+	}
+	return upload{ID: id, Filename: nu.Filename, Size: nu.Size, Offset: fi.Size()}, nu, fn, true
+}
+
+func (srv *Server) getUploadHandler(w http.ResponseWriter, r *http.Request) {
+	u, _, _, ok := srv.getUpload(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	writeJSON(w, u)
+}
+
+func (srv *Server) uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	l := srv.uploadLock(mux.Vars(r)["id"])
+	l.Lock()
+	defer l.Unlock()
+	u, nu, fn, ok := srv.getUpload(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, apiError{"missing or invalid " + uploadOffsetHeader + " header"})
+		return
+	}
+	if offset != u.Offset {
+		// The client lost track of what was written, such as after a chunk failed part of the way through.
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+		w.WriteHeader(http.StatusConflict)
+		writeJSON(w, apiError{"offset doesn't match the uploaded size, which is " + strconv.FormatInt(u.Offset, 10)})
+		return
+	}
+	if r.ContentLength > maxChunkSize || r.ContentLength > u.Size-u.Offset {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		writeJSON(w, apiError{"chunk is too large"})
+		return
+	}
+
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Error opening upload %s: %s", u.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error writing upload"})
+		return
+	}
+	limit := u.Size - u.Offset
+	if limit > maxChunkSize {
+		limit = maxChunkSize
+	}
+	// Whatever is received is kept, even if the connection fails, so the client can resume from the new offset.
+	n, err := io.Copy(f, io.LimitReader(r.Body, limit))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	u.Offset += n
+	// Uploads expire by the time of their directory, so it's updated whenever one is written to.
+	now := time.Now()
+	os.Chtimes(srv.uploadDir(u.ID), now, now)
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	if err != nil {
+		log.Printf("Error writing upload %s: %s", u.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error writing upload"})
+		return
+	}
+	if u.Offset < u.Size {
+		writeJSON(w, u)
+		return
+	}
+
+	bookID, err := srv.finishUpload(u, nu, fn)
+	if err == errHashMismatch {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJSON(w, apiError{err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error importing upload %s: %s", u.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error importing upload: " + err.Error()})
+		return
+	}
+	if !srv.shortIDsOnly {
+		u.BookID = bookID
+	}
+	u.BookShortID, _ = bookShortID(srv.lib, bookID)
+	writeJSON(w, u)
+}
+
+// errHashMismatch is returned by finishUpload when an uploaded file doesn't match its hash.
+var errHashMismatch = errors.New("the uploaded file doesn't match its hash, so it was discarded")
+
+// finishUpload checks a fully uploaded file against its hash, and imports it.
+// Uploads which don't match are removed. Ones which can't be imported are kept, so the import can be retried.
+func (srv *Server) finishUpload(u upload, nu newUpload, fn string) (int64, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != nu.Hash {
+		os.RemoveAll(srv.uploadDir(u.ID))
+		srv.uploadLocks.Delete(u.ID)
+		return 0, errHashMismatch
+	}
+	bookID, err := srv.importUploadedFile(fn, uploadedBook{Title: nu.Title, Authors: nu.Authors, Series: nu.Series, Tags: nu.Tags})
+	if err != nil {
+		return 0, err
+	}
+	os.RemoveAll(srv.uploadDir(u.ID))
+	srv.uploadLocks.Delete(u.ID)
+	return bookID, nil
+}
+
+func (srv *Server) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	l := srv.uploadLock(mux.Vars(r)["id"])
+	l.Lock()
+	defer l.Unlock()
+	u, _, _, ok := srv.getUpload(w, r)
+	if !ok {
+		return
+	}
+	if err := os.RemoveAll(srv.uploadDir(u.ID)); err != nil {
+		log.Printf("Error removing upload %s: %s", u.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, apiError{"error removing upload"})
+		return
+	}
+	srv.uploadLocks.Delete(u.ID)
+	writeJSON(w, success{"deleted"})
+}
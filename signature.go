@@ -0,0 +1,182 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+)
+
+// ErrNoContentSignature is returned by ContentSignature for EPUBs without any content documents.
+var ErrNoContentSignature = errors.New("file has no content to sign")
+
+// coverPageNames are the manifest IDs and file names, without extensions, of the cover pages added by common EPUB tools.
+var coverPageNames = map[string]bool{"cover": true, "titlepage": true}
+
+// ContentSignature returns a hash of the content documents of an EPUB, in reading order.
+// The package document, with the book's metadata, and the cover aren't included,
+// so copies of a book which were only re-tagged or given a new cover have the same signature even though their hashes differ.
+func ContentSignature(filename string) (string, error) {
+	book, err := epub.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer book.Close()
+
+	manifest := make(map[string]epub.Manifest, len(book.Opf.Manifest))
+	for _, item := range book.Opf.Manifest {
+		manifest[item.ID] = item
+	}
+	hasher := sha256.New()
+	signed := 0
+	for _, ref := range book.Opf.Spine.Items {
+		item, ok := manifest[ref.IDref]
+		if !ok || !isContentDocument(item) {
+			continue
+		}
+		href := item.Href
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		base := path.Base(href)
+		if coverPageNames[strings.ToLower(item.ID)] || coverPageNames[strings.ToLower(strings.TrimSuffix(base, path.Ext(base)))] {
+			continue
+		}
+		if err := hashContentDocument(hasher, book, href); err != nil {
+			return "", errors.Wrapf(err, "read %s", href)
+		}
+		signed++
+	}
+	if signed == 0 {
+		return "", ErrNoContentSignature
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// isContentDocument returns true if a manifest item is an (X)HTML document.
+func isContentDocument(item epub.Manifest) bool {
+	switch item.MediaType {
+	case "application/xhtml+xml", "text/html":
+		return true
+	}
+	return false
+}
+
+// hashContentDocument adds a document in book to hasher, preceded by its length so documents can't run together.
+func hashContentDocument(hasher io.Writer, book *epub.Book, href string) error {
+	r, err := book.Open(href)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(hasher, binary.BigEndian, uint64(len(data))); err != nil {
+		return err
+	}
+	_, err = hasher.Write(data)
+	return err
+}
+
+// contentSignatureBookID returns the ID of the book with a file whose content signature is signature.
+func contentSignatureBookID(tx *sql.Tx, signature string) (int64, bool, error) {
+	var bookID int64
+	err := tx.QueryRow("select book_id from files where content_signature=? order by id limit 1", signature).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, errors.Wrap(err, "find content signature")
+	}
+	return bookID, true, nil
+}
+
+// IndexContentSignatures calculates the content signatures of EPUB files which don't have one,
+// such as those imported before signatures were calculated.
+// If progress isn't nil, it will be called as each file is signed.
+func (lib *Library) IndexContentSignatures(progress ProgressFunc) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	ids, err := queryInt64s(tx, "select id from files where content_signature is null and lower(extension)='epub' and missing=0 order by id")
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get file IDs")
+	}
+	files, err := getFilesByID(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get files")
+	}
+	tracker := newProgressTracker("content signature", len(files), progress)
+	for _, f := range files {
+		tracker.start(f.CurrentFilename)
+		fn, err := lib.FilePath(f)
+		var signature string
+		if err == nil {
+			signature, err = ContentSignature(fn)
+		}
+		if err != nil {
+			log.Printf("Cannot calculate content signature of file %d: %s", f.ID, err)
+			tracker.done()
+			continue
+		}
+		if _, err := tx.Exec("update files set content_signature=? where id=?", signature, f.ID); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "update content signature")
+		}
+		tracker.done()
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
+
+// ContentDuplicates returns groups of the IDs of books which have files with the same content signature, in order.
+// These are usually the same book, imported more than once with different metadata.
+func (lib *Library) ContentDuplicates() ([][]int64, error) {
+	rows, err := lib.Query(`select distinct content_signature, book_id from files
+where content_signature in (select content_signature from files where content_signature is not null group by content_signature having count(distinct book_id) > 1)
+order by content_signature, book_id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "get content duplicates")
+	}
+	defer rows.Close()
+	var groups [][]int64
+	var last string
+	for rows.Next() {
+		var signature string
+		var bookID int64
+		if err := rows.Scan(&signature, &bookID); err != nil {
+			return nil, errors.Wrap(err, "get content duplicates")
+		}
+		if len(groups) == 0 || signature != last {
+			groups = append(groups, nil)
+			last = signature
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], bookID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get content duplicates")
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups, nil
+}
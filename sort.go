@@ -0,0 +1,111 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"github.com/pkg/errors"
+)
+
+// BookSort is an order in which books can be listed.
+type BookSort string
+
+// Orders books can be listed in.
+const (
+	// SortByID lists books in the order they were added to the library.
+	SortByID    BookSort = "id"
+	SortByTitle BookSort = "title"
+	// SortByAuthor lists books by the alphabetically first of their authors.
+	SortByAuthor BookSort = "author"
+	// SortBySeries lists books by series, and by their position in it.
+	SortBySeries BookSort = "series"
+	// SortByAdded lists books by when they were added to the library, as SortByID does, but with ties broken by title.
+	SortByAdded BookSort = "added"
+	// SortByFileAdded lists books by when their newest file was added,
+	// so a new format of an old book is listed with the books added at the same time.
+	SortByFileAdded BookSort = "file_added"
+)
+
+// bookSortColumns are the expressions books are ordered by for each BookSort.
+// Text is sorted with the library's locale, by unicodeCollation. Ties are broken by title, then ID.
+var bookSortColumns = map[BookSort]string{
+	SortByID:        "id",
+	SortByTitle:     "title collate " + unicodeCollation,
+	SortByAuthor:    "(select min(a.name collate " + unicodeCollation + ") from books_authors ba join authors a on ba.author_id = a.id where ba.book_id = books.id and ba.role = 'author')",
+	SortBySeries:    "coalesce(series, '') collate " + unicodeCollation,
+	SortByAdded:     "created_on",
+	SortByFileAdded: sqlFileAddedOn,
+}
+
+// sqlFileAddedOn is an SQL expression for when the newest file of the book named books was added,
+// or when the book was added if it has no files.
+const sqlFileAddedOn = "coalesce((select max(f.created_on) from files f where f.book_id = books.id), books.created_on)"
+
+// ListBookIDs returns the IDs of all books in the library, in the given order.
+func (lib *Library) ListBookIDs(sort BookSort, descending bool) ([]int64, error) {
+	return lib.sortedBookIDs("", sort, descending)
+}
+
+// SortBookIDs returns ids in the given order.
+// IDs of books not in the library are left out.
+func (lib *Library) SortBookIDs(ids []int64, sort BookSort, descending bool) ([]int64, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return lib.sortedBookIDs("where id in ("+joinInt64s(ids, ",")+")", sort, descending)
+}
+
+func (lib *Library) sortedBookIDs(where string, sort BookSort, descending bool) ([]int64, error) {
+	column, ok := bookSortColumns[sort]
+	if !ok {
+		return nil, errors.Errorf("unknown sort order %s", sort)
+	}
+	direction := "asc"
+	if descending {
+		direction = "desc"
+	}
+	order := column + " " + direction
+	if sort == SortBySeries {
+		order += ", series_index " + direction
+	}
+	if sort != SortByID {
+		order += ", title collate " + unicodeCollation + " " + direction + ", id " + direction
+	}
+	rows, err := lib.Query("select id from books " + where + " order by " + order)
+	if err != nil {
+		return nil, errors.Wrap(err, "list books")
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "list books")
+		}
+		ids = append(ids, id)
+	}
+	return ids, errors.Wrap(rows.Err(), "list books")
+}
+
+// SeriesBookIDs returns the IDs of the books in a series, ignoring case, in order of their series index, then title.
+func (lib *Library) SeriesBookIDs(series string) ([]int64, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	ids, err := queryInt64s(tx, "select id from books where series = ? collate nocase order by series_index, title collate "+unicodeCollation+", id", series)
+	return ids, errors.Wrap(err, "get books in series")
+}
+
+// SeriesNames returns the names of the series of the books in the library, in alphabetical order.
+func (lib *Library) SeriesNames() ([]string, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+	names, err := queryStrings(tx, "select distinct series from books where series != '' order by series collate "+unicodeCollation)
+	return names, errors.Wrap(err, "get series")
+}
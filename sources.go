@@ -0,0 +1,107 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// SourceOverlap describes the books with files from a source, such as a feed or an email sender.
+type SourceOverlap struct {
+	// Source is where the files came from, or an empty string for files imported without one.
+	Source string
+	Files  int64
+	// Books is the number of books with a file from the source.
+	Books int64
+	// UniqueBooks is the number of those books which have no files from any other source.
+	UniqueBooks int64
+}
+
+// DuplicatesOnly returns true if every book from the source also has files from another source.
+func (s SourceOverlap) DuplicatesOnly() bool {
+	return s.UniqueBooks == 0
+}
+
+// SharedBook is a book with files from more than one source.
+type SharedBook struct {
+	ID    int64
+	Title string
+	// Sources are the sources of its files, in order.
+	Sources []string
+}
+
+// SourceOverlapReport describes how the books from each source overlap with the other sources.
+type SourceOverlapReport struct {
+	// Sources are ordered by source.
+	Sources []SourceOverlap
+	// SharedBooks are the books with files from more than one source, ordered by ID.
+	SharedBooks []SharedBook
+}
+
+// DuplicateSources returns the sources which contribute only books already available from another source.
+func (r SourceOverlapReport) DuplicateSources() []SourceOverlap {
+	var sources []SourceOverlap
+	for _, s := range r.Sources {
+		if s.DuplicatesOnly() {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// SourceOverlapReport reports which books have files from more than one source,
+// and which sources contribute only books which are also available from another.
+func (lib *Library) SourceOverlapReport() (SourceOverlapReport, error) {
+	var report SourceOverlapReport
+	rows, err := lib.Query(`select f.book_id, b.title, coalesce(f.source, '') s, count(*) from files f
+join books b on b.id = f.book_id
+group by f.book_id, s order by f.book_id, s`)
+	if err != nil {
+		return report, errors.Wrap(err, "get file sources")
+	}
+	defer rows.Close()
+
+	var books []SharedBook
+	files := make(map[string]int64)
+	for rows.Next() {
+		var id, count int64
+		var title, source string
+		if err := rows.Scan(&id, &title, &source, &count); err != nil {
+			return report, errors.Wrap(err, "get file sources")
+		}
+		if len(books) == 0 || books[len(books)-1].ID != id {
+			books = append(books, SharedBook{ID: id, Title: title})
+		}
+		b := &books[len(books)-1]
+		b.Sources = append(b.Sources, source)
+		files[source] += count
+	}
+	if err := rows.Err(); err != nil {
+		return report, errors.Wrap(err, "get file sources")
+	}
+
+	overlaps := make(map[string]*SourceOverlap)
+	for source, count := range files {
+		overlaps[source] = &SourceOverlap{Source: source, Files: count}
+	}
+	for _, b := range books {
+		for _, source := range b.Sources {
+			overlaps[source].Books++
+			if len(b.Sources) == 1 {
+				overlaps[source].UniqueBooks++
+			}
+		}
+		if len(b.Sources) > 1 {
+			report.SharedBooks = append(report.SharedBooks, b)
+		}
+	}
+	for _, o := range overlaps {
+		report.Sources = append(report.Sources, *o)
+	}
+	sort.Slice(report.Sources, func(i, j int) bool { return report.Sources[i].Source < report.Sources[j].Source })
+	return report, nil
+}
@@ -0,0 +1,109 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/kapmahc/epub"
+)
+
+// SubjectTagger turns the subjects in a file's metadata into tags, so imported books arrive categorized.
+type SubjectTagger struct {
+	// Mapping maps subjects, ignoring case, to the tags they become. Subjects mapped to an empty string are dropped.
+	// Subjects which aren't in Mapping become tags as they are, lowercased.
+	Mapping map[string]string
+	// Blocklist lists subjects, ignoring case, which never become tags.
+	Blocklist []string
+	// MaxTags limits how many tags are added to a file, if it's greater than 0.
+	// Since tags are usually in filenames, this keeps books with long lists of subjects from getting very long filenames.
+	MaxTags int
+}
+
+// subjectSeparatorRegexp matches the separators in compound subjects, such as "Fiction / Science Fiction" or "Cats -- Fiction".
+var subjectSeparatorRegexp = regexp.MustCompile(`\s+(/|--)\s+`)
+
+// Tags converts subjects into tags, in order and without duplicates.
+// Compound subjects which aren't in the mapping, such as "Fiction / Science Fiction / General", are split into their parts.
+func (st *SubjectTagger) Tags(subjects []string) []string {
+	mapping := make(map[string]string, len(st.Mapping))
+	for k, v := range st.Mapping {
+		mapping[strings.ToLower(collapseSpace(k))] = collapseSpace(v)
+	}
+	blocked := make(map[string]bool, len(st.Blocklist))
+	for _, b := range st.Blocklist {
+		blocked[strings.ToLower(collapseSpace(b))] = true
+	}
+	tagFor := func(subject string) (string, bool) {
+		key := strings.ToLower(subject)
+		if blocked[key] {
+			return "", true
+		}
+		if tag, ok := mapping[key]; ok {
+			return tag, true
+		}
+		return key, false
+	}
+
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if tag == "" || seen[strings.ToLower(tag)] || (st.MaxTags > 0 && len(tags) >= st.MaxTags) {
+			return
+		}
+		seen[strings.ToLower(tag)] = true
+		tags = append(tags, tag)
+	}
+	for _, subject := range subjects {
+		subject = collapseSpace(subject)
+		if tag, known := tagFor(subject); known || !subjectSeparatorRegexp.MatchString(subject) {
+			add(tag)
+			continue
+		}
+		for _, part := range subjectSeparatorRegexp.Split(subject, -1) {
+			tag, _ := tagFor(part)
+			add(tag)
+		}
+	}
+	return tags
+}
+
+// EpubSubjects returns the subjects in an EPUB's metadata.
+func EpubSubjects(filename string) ([]string, error) {
+	f, err := epub.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var subjects []string
+	for _, s := range f.Opf.Metadata.Subject {
+		if s = collapseSpace(s); s != "" {
+			subjects = append(subjects, s)
+		}
+	}
+	return subjects, nil
+}
+
+// tagFromSubjects adds tags made from the subjects in an EPUB file's metadata to the file.
+func tagFromSubjects(bf *BookFile, tagger *SubjectTagger) error {
+	if !strings.EqualFold(bf.Extension, "epub") {
+		return nil
+	}
+	subjects, err := EpubSubjects(bf.OriginalFilename)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(bf.Tags))
+	for _, t := range bf.Tags {
+		seen[strings.ToLower(t)] = true
+	}
+	for _, t := range tagger.Tags(subjects) {
+		if !seen[strings.ToLower(t)] {
+			bf.Tags = append(bf.Tags, t)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// suggestionFields are the search fields whose words are suggested as corrections.
+var suggestionFields = []string{"author", "series", "title", "tags", "publisher", "works", RoleEditor, RoleTranslator, RoleNarrator, RoleIllustrator, "original_title", "alternate_titles", "subtitle", "fandom", "ship"}
+
+// searchWordRegexp matches the words of a search, along with any field name before them or prefix operator after them.
+var searchWordRegexp = regexp.MustCompile(`[A-Za-z0-9]+[:*]?`)
+
+// suggestion is a word which could replace a misspelled one.
+type suggestion struct {
+	word      string
+	distance  int
+	documents int
+}
+
+// SearchSuggestions suggests corrections to the spelling of a search, for when it has no results.
+// Misspelled words are replaced with similar words from the titles, authors, series, tags, and publishers of books in the library.
+// At most max suggestions are returned, best first, and each of them matches at least one book.
+// Filters, such as rating:>=4, are kept as they are.
+func (lib *Library) SearchSuggestions(terms string, max int) ([]string, error) {
+	terms, filters, err := parseSearchFilters(terms)
+	if err != nil {
+		return nil, err
+	}
+	if terms == "" {
+		return nil, nil
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	fields, err := searchIndexFields(tx)
+	if err != nil {
+		return nil, err
+	}
+	words := searchWordRegexp.FindAllStringIndex(terms, -1)
+	candidates := make(map[int][]suggestion)
+	for i, loc := range words {
+		word := terms[loc[0]:loc[1]]
+		if strings.HasSuffix(word, ":") || strings.HasSuffix(word, "*") || word == "OR" || word == "AND" || word == "NOT" || word == "NEAR" {
+			continue
+		}
+		word = strings.ToLower(word)
+		var known int
+		if err := tx.QueryRow("select count(*) from books_fts_terms where term = ? and col = '*'", word).Scan(&known); err != nil {
+			return nil, errors.Wrap(err, "look up word")
+		}
+		if known > 0 {
+			continue
+		}
+		if candidates[i], err = spellingCandidates(tx, fields, word); err != nil {
+			return nil, err
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// The nth suggestion uses the nth best candidate for each word, or its best if it has fewer.
+	var suggestions []string
+	seen := make(map[string]bool)
+	for n := 0; len(suggestions) < max; n++ {
+		var query strings.Builder
+		last, replaced := 0, false
+		for i, loc := range words {
+			c := candidates[i]
+			if len(c) == 0 {
+				continue
+			}
+			query.WriteString(terms[last:loc[0]])
+			if n < len(c) {
+				query.WriteString(c[n].word)
+				replaced = true
+			} else {
+				query.WriteString(c[0].word)
+			}
+			last = loc[1]
+		}
+		if !replaced {
+			break
+		}
+		query.WriteString(terms[last:])
+		s := query.String()
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		q, args := searchQuery(s, filters)
+		var found int
+		if err := tx.QueryRow("select count(*) from ("+q+" limit 1)", args...).Scan(&found); err != nil {
+			return nil, errors.Wrap(err, "check suggestion")
+		}
+		if found > 0 {
+			if len(filters) > 0 {
+				s += " " + filterTerms(filters)
+			}
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions, nil
+}
+
+// spellingCandidates returns the words in the search index similar to word, best first.
+// Longer words may be further from their corrections.
+// searchFields are the fields of the search index, in order.
+func spellingCandidates(tx *sql.Tx, searchFields []string, word string) ([]suggestion, error) {
+	n := len([]rune(word))
+	maxDistance := 2
+	if n < 3 {
+		return nil, nil
+	} else if n < 6 {
+		maxDistance = 1
+	}
+	var cols []string
+	for i, f := range searchFields {
+		for _, s := range suggestionFields {
+			if f == s {
+				cols = append(cols, strconv.Itoa(i))
+			}
+		}
+	}
+	rows, err := tx.Query("select term, sum(documents) from books_fts_terms where col in ("+strings.Join(cols, ",")+") and length(term) between ? and ? group by term",
+		n-maxDistance, n+maxDistance)
+	if err != nil {
+		return nil, errors.Wrap(err, "get search index vocabulary")
+	}
+	defer rows.Close()
+	var candidates []suggestion
+	for rows.Next() {
+		var s suggestion
+		if err := rows.Scan(&s.word, &s.documents); err != nil {
+			return nil, errors.Wrap(err, "get search index vocabulary")
+		}
+		if s.distance = editDistance(word, s.word); s.distance <= maxDistance {
+			candidates = append(candidates, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "get search index vocabulary")
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		if candidates[i].documents != candidates[j].documents {
+			return candidates[i].documents > candidates[j].documents
+		}
+		return candidates[i].word < candidates[j].word
+	})
+	return candidates, nil
+}
+
+// editDistance returns the number of insertions, deletions, substitutions, and transpositions of adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// d[i][j] is the distance between the first i runes of s and the first j runes of t.
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,246 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Synonym is an alternative for a search term, such as science fiction for sf.
+// Searches for the term also find books matching its expansion.
+type Synonym struct {
+	Term      string
+	Expansion string
+	// IndexTime is true if the expansion is also indexed with the text of books containing the term,
+	// so the term is found in snippets and by clients which search the index directly.
+	IndexTime bool
+}
+
+// ErrSynonymNotFound is returned when removing a synonym which doesn't exist.
+var ErrSynonymNotFound = errors.New("synonym not found")
+
+// synonymTermRegexp matches a term which can have synonyms: a single word of letters and digits.
+var synonymTermRegexp = regexp.MustCompile(`^[\pL\pN]+$`)
+
+// synonymPunctuation is replaced by spaces when looking for index time synonyms in text, so terms are found next to it.
+var synonymPunctuation = []string{",", ".", ":", ";", "!", "?", "(", ")", "[", "]", "/", "-", `"`}
+
+// normalizeSynonym checks that s can be used, and returns it with extra spaces removed.
+func normalizeSynonym(s Synonym) (Synonym, error) {
+	s.Term = strings.TrimSpace(s.Term)
+	s.Expansion = collapseSpace(strings.TrimSpace(s.Expansion))
+	if !synonymTermRegexp.MatchString(s.Term) {
+		return s, errors.Errorf("synonym term %q must be a single word of letters and digits", s.Term)
+	}
+	switch strings.ToUpper(s.Term) {
+	case "AND", "OR", "NOT", "NEAR":
+		return s, errors.Errorf("%s is a search operator, and can't have synonyms", s.Term)
+	}
+	if s.Expansion == "" {
+		return s, errors.New("synonym expansion is empty")
+	}
+	if strings.Contains(s.Expansion, `"`) {
+		return s, errors.New("synonym expansion can't contain quotes")
+	}
+	if strings.EqualFold(s.Term, s.Expansion) {
+		return s, errors.New("synonym expansion is the same as its term")
+	}
+	return s, nil
+}
+
+// Synonyms returns the synonyms used when searching, ordered by term.
+func (lib *Library) Synonyms() ([]Synonym, error) {
+	rows, err := lib.Query("select " + synonymRowColumns + " from synonyms order by term, expansion")
+	if err != nil {
+		return nil, errors.Wrap(err, "get synonyms")
+	}
+	defer rows.Close()
+	var synonyms []Synonym
+	for rows.Next() {
+		row, err := scanSynonymRow(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "get synonyms")
+		}
+		synonyms = append(synonyms, row.synonym())
+	}
+	return synonyms, errors.Wrap(rows.Err(), "get synonyms")
+}
+
+// AddSynonym adds a synonym, or changes whether an existing one is applied at index time.
+// If the synonym is or was applied at index time, the search index is regenerated.
+func (lib *Library) AddSynonym(s Synonym) error {
+	s, err := normalizeSynonym(s)
+	if err != nil {
+		return err
+	}
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	var wasIndexed bool
+	err = tx.QueryRow("select index_time from synonyms where term=? and expansion=?", s.Term, s.Expansion).Scan(&wasIndexed)
+	if err == nil {
+		_, err = tx.Exec("update synonyms set updated_on=datetime(), index_time=? where term=? and expansion=?", s.IndexTime, s.Term, s.Expansion)
+	} else if err == sql.ErrNoRows {
+		_, err = tx.Exec("insert into synonyms (term, expansion, index_time) values (?, ?, ?)", s.Term, s.Expansion, s.IndexTime)
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "add synonym")
+	}
+	if s.IndexTime || wasIndexed {
+		if err := rebuildSearchIndex(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// RemoveSynonym removes the synonym of term with expansion, or every synonym of term if expansion is empty.
+// If a removed synonym was applied at index time, the search index is regenerated.
+func (lib *Library) RemoveSynonym(term, expansion string) error {
+	term = strings.TrimSpace(term)
+	expansion = collapseSpace(strings.TrimSpace(expansion))
+	unlock, err := lib.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	where, args := "term=?", []interface{}{term}
+	if expansion != "" {
+		where, args = where+" and expansion=?", append(args, expansion)
+	}
+	var count, indexed int
+	if err := tx.QueryRow("select count(*), coalesce(sum(index_time), 0) from synonyms where "+where, args...).Scan(&count, &indexed); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "remove synonym")
+	}
+	if count == 0 {
+		tx.Rollback()
+		return ErrSynonymNotFound
+	}
+	if _, err := tx.Exec("delete from synonyms where "+where, args...); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "remove synonym")
+	}
+	if indexed > 0 {
+		if err := rebuildSearchIndex(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// querySynonyms returns the expansions of every synonym, by term in lower case.
+func querySynonyms(q queryer) (map[string][]string, error) {
+	rows, err := q.Query("select term, expansion from synonyms order by term, expansion")
+	if err != nil {
+		return nil, errors.Wrap(err, "get synonyms")
+	}
+	defer rows.Close()
+	synonyms := make(map[string][]string)
+	for rows.Next() {
+		var term, expansion string
+		if err := rows.Scan(&term, &expansion); err != nil {
+			return nil, errors.Wrap(err, "get synonyms")
+		}
+		term = strings.ToLower(term)
+		synonyms[term] = append(synonyms[term], expansion)
+	}
+	return synonyms, errors.Wrap(rows.Err(), "get synonyms")
+}
+
+// expandQuery returns the search terms with the synonyms of each term added as alternatives.
+func (lib *Library) expandQuery(terms string) (string, error) {
+	if terms == "" {
+		return terms, nil
+	}
+	synonyms, err := querySynonyms(lib.DB)
+	if err != nil {
+		return "", err
+	}
+	return expandSynonyms(terms, synonyms), nil
+}
+
+// expandSynonyms replaces each term in a search index query which has synonyms with the term or its expansions,
+// such as (sf OR "science fiction"), or (tags:sf OR (tags:science AND tags:fiction)) for a term limited to a field.
+// Quoted phrases, operators, prefix searches and excluded terms are left alone.
+func expandSynonyms(terms string, synonyms map[string][]string) string {
+	if len(synonyms) == 0 {
+		return terms
+	}
+	words := strings.Fields(terms)
+	quoted := false
+	for i, w := range words {
+		if quoted || strings.Contains(w, `"`) {
+			if strings.Count(w, `"`)%2 == 1 {
+				quoted = !quoted
+			}
+			continue
+		}
+		words[i] = expandSynonymWord(w, synonyms)
+	}
+	return strings.Join(words, " ")
+}
+
+// expandSynonymWord expands a single word of a query, which may be in parentheses or limited to a field.
+func expandSynonymWord(w string, synonyms map[string][]string) string {
+	term := strings.TrimLeft(w, "(")
+	opening := w[:len(w)-len(term)]
+	term = strings.TrimRight(term, ")")
+	closing := w[len(opening)+len(term):]
+	field := ""
+	if i := strings.Index(term, ":"); i >= 0 {
+		field, term = term[:i+1], term[i+1:]
+	}
+	expansions := synonyms[strings.ToLower(term)]
+	if len(expansions) == 0 {
+		return w
+	}
+	alternatives := []string{field + term}
+	for _, e := range expansions {
+		if field == "" {
+			alternatives = append(alternatives, `"`+e+`"`)
+			continue
+		}
+		// Phrases can't be limited to a field, so each of their words is.
+		words := strings.Fields(e)
+		for i := range words {
+			words[i] = field + words[i]
+		}
+		if len(words) == 1 {
+			alternatives = append(alternatives, words[0])
+		} else {
+			alternatives = append(alternatives, "("+strings.Join(words, " AND ")+")")
+		}
+	}
+	return opening + "(" + strings.Join(alternatives, " OR ") + ")" + closing
+}
+
+// sqlWithIndexSynonyms returns an SQL expression for the text of source,
+// followed by the expansions of the index time synonyms whose terms are words in it.
+func sqlWithIndexSynonyms(source string) string {
+	words := "v"
+	for _, p := range synonymPunctuation {
+		words = "replace(" + words + ", '" + p + "', ' ')"
+	}
+	return `(select v || coalesce((select ' ' || group_concat(s.expansion, ' ') from synonyms s
+where s.index_time = 1 and ' ' || ` + words + ` || ' ' like '% ' || s.term || ' %'), '') from (select ` + source + ` as v))`
+}
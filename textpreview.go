@@ -0,0 +1,234 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kapmahc/epub"
+	"github.com/pkg/errors"
+)
+
+// MaxPreviewChars is the longest text preview GetPreview returns.
+const MaxPreviewChars = 5000
+
+// ErrPreviewUnsupported is returned by GetPreview for files which text can't be extracted from.
+var ErrPreviewUnsupported = errors.New("previews are only supported for EPUB and text files")
+
+// minPreviewParagraph is the length of the shortest paragraph included in a preview.
+// Shorter paragraphs are usually headings, or parts of title pages.
+const minPreviewParagraph = 40
+
+// frontMatterRegexp matches paragraphs from copyright pages, which aren't worth previewing.
+var frontMatterRegexp = regexp.MustCompile(`(?i)copyright|©|all rights reserved|\bisbn\b|project gutenberg`)
+
+var (
+	ignoredElementsRegexp = regexp.MustCompile(`(?is)<(script|style|head)\b[^>]*>.*?</(script|style|head)>`)
+	blockBreakRegexp      = regexp.MustCompile(`(?i)<br\s*/?>|</?(p|div|h[1-6]|li|blockquote|tr|pre)[^>]*>`)
+	tagRegexp             = regexp.MustCompile(`<[^>]*>`)
+	blankLinesRegexp      = regexp.MustCompile(`\n\s*\n`)
+)
+
+// HTMLParagraphs converts HTML into paragraphs of plain text, with whitespace collapsed.
+// The HTML doesn't need to be valid, since only its text and paragraph breaks are kept.
+func HTMLParagraphs(s string) []string {
+	s = ignoredElementsRegexp.ReplaceAllString(s, "")
+	s = blockBreakRegexp.ReplaceAllString(s, "\n\n")
+	s = tagRegexp.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	return textParagraphs(s)
+}
+
+// textParagraphs splits plain text into paragraphs at blank lines, with whitespace collapsed.
+func textParagraphs(s string) []string {
+	var paragraphs []string
+	for _, p := range blankLinesRegexp.Split(s, -1) {
+		if p = collapseSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// GetPreview returns up to chars characters from the first readable paragraphs of an EPUB or text file,
+// for showing an opening excerpt of a book.
+// Headings, title pages and copyright notices are skipped.
+// Previews are cached as derivations of the file, by chars, in the previews directory next to the library.
+func (lib *Library) GetPreview(fileID int64, chars int) (string, error) {
+	if chars < 1 || chars > MaxPreviewChars {
+		return "", errors.Errorf("chars must be between 1 and %d", MaxPreviewChars)
+	}
+	files, err := lib.GetFilesByID([]int64{fileID})
+	if err != nil {
+		return "", errors.Wrap(err, "get file")
+	}
+	if len(files) == 0 {
+		return "", errors.Errorf("file %d not found", fileID)
+	}
+	file := files[0]
+	ext := strings.ToLower(file.Extension)
+	if !GetFormatCapabilities(ext).ContentIndexing {
+		return "", ErrPreviewUnsupported
+	}
+
+	dir := lib.paths.Previews
+	fn := path.Join(dir, fmt.Sprintf("%s-%d.txt", file.Hash, chars))
+	params := strconv.Itoa(chars)
+	if cached, found, err := lib.cachedDerivationFile(file.Hash, DerivationTextPreview, params, fn); err != nil {
+		return "", err
+	} else if found {
+		b, err := ioutil.ReadFile(cached)
+		return string(b), err
+	}
+
+	src, release, err := lib.CachedFilePath(file)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	var paragraphs []string
+	if ext == "epub" {
+		paragraphs, err = epubParagraphs(src, chars)
+	} else {
+		paragraphs, err = textFileParagraphs(src)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "read file %d", fileID)
+	}
+	preview := previewText(paragraphs, chars)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "create previews directory")
+	}
+	tmp, err := ioutil.TempFile(dir, "preview-*.txt")
+	if err != nil {
+		return "", errors.Wrap(err, "create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(preview)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fn)
+	}
+	if err == nil {
+		err = lib.SaveDerivation(Derivation{Hash: file.Hash, Kind: DerivationTextPreview, Params: params, Filename: fn})
+	}
+	return preview, errors.Wrap(err, "cache preview")
+}
+
+// previewText joins the readable paragraphs into a preview of up to chars characters.
+// A paragraph which doesn't fit is cut at a word boundary and ended with an ellipsis.
+func previewText(paragraphs []string, chars int) string {
+	var sb strings.Builder
+	remaining := chars
+	for _, p := range paragraphs {
+		if utf8.RuneCountInString(p) < minPreviewParagraph || frontMatterRegexp.MatchString(p) {
+			continue
+		}
+		if sb.Len() > 0 {
+			if remaining <= 2 {
+				break
+			}
+			sb.WriteString("\n\n")
+			remaining -= 2
+		}
+		if n := utf8.RuneCountInString(p); n <= remaining {
+			sb.WriteString(p)
+			remaining -= n
+			continue
+		}
+		cut := []rune(p)[:remaining-1]
+		if i := strings.LastIndex(string(cut), " "); i > 0 {
+			cut = []rune(string(cut)[:i])
+		}
+		sb.WriteString(string(cut) + "…")
+		break
+	}
+	return sb.String()
+}
+
+// epubParagraphs returns the paragraphs of an EPUB's content documents in reading order,
+// stopping once there's enough text for a preview of chars characters.
+func epubParagraphs(filename string, chars int) ([]string, error) {
+	book, err := epub.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer book.Close()
+	hrefs := make(map[string]string)
+	for _, item := range book.Opf.Manifest {
+		if item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html" {
+			hrefs[item.ID] = item.Href
+		}
+	}
+	var paragraphs []string
+	length := 0
+	for _, item := range book.Opf.Spine.Items {
+		href, ok := hrefs[item.IDref]
+		if !ok || item.Linear == "no" {
+			continue
+		}
+		r, err := book.Open(href)
+		if err != nil {
+			return nil, errors.Wrapf(err, "open %s", href)
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(r, 1<<22))
+		r.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", href)
+		}
+		for _, p := range HTMLParagraphs(string(b)) {
+			paragraphs = append(paragraphs, p)
+			if len(p) >= minPreviewParagraph {
+				length += len(p)
+			}
+		}
+		if length >= chars {
+			break
+		}
+	}
+	return paragraphs, nil
+}
+
+// textFileParagraphs returns the paragraphs at the start of a text file.
+// Files which aren't valid UTF-8 are read as Latin-1.
+func textFileParagraphs(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	const limit = 4*MaxPreviewChars + 64*1024
+	b, err := ioutil.ReadAll(io.LimitReader(f, limit))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == limit {
+		// Don't let a character cut off at the limit make the file look like it isn't UTF-8.
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(b); i++ {
+			b = b[:len(b)-1]
+		}
+	}
+	s := strings.TrimPrefix(string(b), "\ufeff")
+	if !utf8.ValidString(s) {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	}
+	return textParagraphs(strings.Replace(s, "\r\n", "\n", -1)), nil
+}
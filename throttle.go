@@ -0,0 +1,81 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ioLimiter limits the rate files are read while hashing and copying them.
+// It's shared by every file being read, so the limit holds however many are read at once.
+var ioLimiter = &rateLimiter{}
+
+// SetIOLimit limits the rate files are read while hashing them and copying them into a books root
+// to bytesPerSecond, so a large import doesn't starve other programs of disk or network bandwidth.
+// The limit applies to the whole process. If bytesPerSecond is 0, files are read as fast as possible.
+func SetIOLimit(bytesPerSecond int64) {
+	ioLimiter.mu.Lock()
+	defer ioLimiter.mu.Unlock()
+	ioLimiter.rate = bytesPerSecond
+	ioLimiter.next = time.Time{}
+}
+
+// rateLimiter spaces out reads so they don't exceed rate bytes per second.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	// next is when the bytes already allowed will have been read at the limited rate.
+	next time.Time
+}
+
+// limit returns the number of bytes a read of n bytes should be cut down to, so it doesn't burst far above the rate.
+func (l *rateLimiter) limit(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max := int(l.rate / 10); l.rate > 0 && n > max {
+		if max < 1 {
+			max = 1
+		}
+		return max
+	}
+	return n
+}
+
+// wait sleeps until n more bytes can be read without exceeding the rate.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// limitedReader reads from r no faster than its limiter allows.
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+// throttle returns a reader which reads from r no faster than the limit set by SetIOLimit.
+func throttle(r io.Reader) io.Reader {
+	return &limitedReader{r: r, limiter: ioLimiter}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	p = p[:lr.limiter.limit(len(p))]
+	n, err := lr.r.Read(p)
+	lr.limiter.wait(n)
+	return n, err
+}
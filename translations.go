@@ -0,0 +1,95 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NormalizeLanguage converts a language code, such as "RU" or "pt_BR", to the lowercase, hyphenated form stored in the library.
+func NormalizeLanguage(language string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(language), "_", "-", -1))
+}
+
+// SetTranslationOf records that the book with ID bookID is a translation of the book with ID originalID,
+// which is usually an edition in the original language.
+// If originalID is 0, the book is no longer recorded as a translation.
+func (lib *Library) SetTranslationOf(bookID, originalID int64) error {
+	if bookID == originalID {
+		return errors.New("a book can't be a translation of itself")
+	}
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	if err := setTranslationOf(tx, bookID, originalID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID, originalID)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
+
+func setTranslationOf(tx *sql.Tx, bookID, originalID int64) error {
+	original := sql.NullInt64{Int64: originalID, Valid: originalID != 0}
+	if original.Valid {
+		// Follow the original's own link, so translations always point at the original edition, and links can't form a cycle.
+		var next sql.NullInt64
+		err := tx.QueryRow("select translation_of from books where id=?", originalID).Scan(&next)
+		if err == sql.ErrNoRows {
+			return ErrBookNotFound
+		} else if err != nil {
+			return errors.Wrap(err, "get original book")
+		}
+		if next.Valid {
+			if next.Int64 == bookID {
+				return errors.Errorf("book %d is already a translation of book %d", originalID, bookID)
+			}
+			original.Int64 = next.Int64
+		}
+	}
+	res, err := tx.Exec("update books set updated_on=datetime(), translation_of=? where id=?", original, bookID)
+	if err != nil {
+		return errors.Wrap(err, "set translation")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrBookNotFound
+	}
+	// Translations of this book become translations of its original.
+	if original.Valid {
+		if _, err := tx.Exec("update books set updated_on=datetime(), translation_of=? where translation_of=?", original, bookID); err != nil {
+			return errors.Wrap(err, "move translations")
+		}
+	}
+	return nil
+}
+
+// getTranslationsByBookIds gets the IDs of the translations of each book ID.
+func getTranslationsByBookIds(tx *sql.Tx, ids []int64) (map[int64][]int64, error) {
+	m := make(map[int64][]int64)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select translation_of, id from books where translation_of in (" + joinInt64s(ids, ",") + ") order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var originalID, id int64
+		if err := rows.Scan(&originalID, &id); err != nil {
+			return nil, err
+		}
+		m[originalID] = append(m[originalID], id)
+	}
+	return m, rows.Err()
+}
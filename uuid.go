@@ -0,0 +1,175 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "generate UUID")
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// GetBookByUUID retrieves a book from the library by its UUID.
+// If no book has the UUID, ErrBookNotFound is returned.
+func (lib *Library) GetBookByUUID(uuid string) (Book, error) {
+	var id int64
+	err := lib.QueryRow("select id from books where uuid=?", uuid).Scan(&id)
+	if err == sql.ErrNoRows {
+		return Book{}, ErrBookNotFound
+	} else if err != nil {
+		return Book{}, errors.Wrap(err, "get book by UUID")
+	}
+	bks, err := lib.GetBooksByID([]int64{id})
+	if err != nil {
+		return Book{}, err
+	}
+	if len(bks) == 0 {
+		return Book{}, ErrBookNotFound
+	}
+	return bks[0], nil
+}
+
+// GetFileByUUID retrieves a file from the library by its UUID.
+// If no file has the UUID, ErrFileNotFound is returned.
+func (lib *Library) GetFileByUUID(uuid string) (BookFile, error) {
+	var id int64
+	err := lib.QueryRow("select id from files where uuid=?", uuid).Scan(&id)
+	if err == sql.ErrNoRows {
+		return BookFile{}, ErrFileNotFound
+	} else if err != nil {
+		return BookFile{}, errors.Wrap(err, "get file by UUID")
+	}
+	files, err := lib.GetFilesByID([]int64{id})
+	if err != nil {
+		return BookFile{}, err
+	}
+	if len(files) == 0 {
+		return BookFile{}, ErrFileNotFound
+	}
+	return files[0], nil
+}
+
+// ShortIDLength is the length of short IDs made from UUIDs.
+const ShortIDLength = 12
+
+// ErrAmbiguousShortID is returned when more than one book or file has a short ID.
+var ErrAmbiguousShortID = errors.New("short ID is ambiguous")
+
+// ShortID returns a short ID for uuid, for URLs which shouldn't reveal how many books the library has or let others be guessed:
+// the first ShortIDLength hex digits of the UUID, which are random for the UUIDs the library generates.
+// UUIDs which don't start with enough lowercase hex digits, such as ones given by other programs, are their own short IDs.
+func ShortID(uuid string) string {
+	if len(uuid) < ShortIDLength+1 || uuid[8] != '-' {
+		return uuid
+	}
+	s := uuid[:8] + uuid[9:ShortIDLength+1]
+	if !isLowerHex(s) {
+		return uuid
+	}
+	return s
+}
+
+// ShortID returns the short ID of the book, made from its UUID.
+func (b Book) ShortID() string {
+	return ShortID(b.UUID)
+}
+
+// ShortID returns the short ID of the file, made from its UUID.
+func (bf BookFile) ShortID() string {
+	return ShortID(bf.UUID)
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// idByShortID returns the ID of the record in table with the short ID.
+// Short IDs made from UUIDs are looked up as a range of UUIDs, so the index on them is used.
+func idByShortID(tx *sql.Tx, table, shortID string) (int64, error) {
+	var ids []int64
+	var err error
+	if len(shortID) == ShortIDLength && isLowerHex(shortID) {
+		prefix := shortID[:8] + "-" + shortID[8:]
+		ids, err = queryInt64s(tx, "select id from "+table+" where uuid >= ? and uuid < ? limit 2", prefix, prefix+"~")
+	} else {
+		ids, err = queryInt64s(tx, "select id from "+table+" where uuid = ?", shortID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	switch len(ids) {
+	case 0:
+		return 0, sql.ErrNoRows
+	case 1:
+		return ids[0], nil
+	default:
+		return 0, ErrAmbiguousShortID
+	}
+}
+
+// GetBookByShortID retrieves a book from the library by its short ID.
+// If no book has the short ID, ErrBookNotFound is returned.
+func (lib *Library) GetBookByShortID(shortID string) (Book, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return Book{}, errors.Wrap(err, "begin transaction")
+	}
+	id, err := idByShortID(tx, "books", shortID)
+	tx.Rollback()
+	if err == sql.ErrNoRows {
+		return Book{}, ErrBookNotFound
+	} else if err != nil {
+		return Book{}, errors.Wrap(err, "get book by short ID")
+	}
+	bks, err := lib.GetBooksByID([]int64{id})
+	if err != nil {
+		return Book{}, err
+	}
+	if len(bks) == 0 {
+		return Book{}, ErrBookNotFound
+	}
+	return bks[0], nil
+}
+
+// GetFileByShortID retrieves a file from the library by its short ID.
+// If no file has the short ID, ErrFileNotFound is returned.
+func (lib *Library) GetFileByShortID(shortID string) (BookFile, error) {
+	tx, err := lib.Begin()
+	if err != nil {
+		return BookFile{}, errors.Wrap(err, "begin transaction")
+	}
+	id, err := idByShortID(tx, "files", shortID)
+	tx.Rollback()
+	if err == sql.ErrNoRows {
+		return BookFile{}, ErrFileNotFound
+	} else if err != nil {
+		return BookFile{}, errors.Wrap(err, "get file by short ID")
+	}
+	files, err := lib.GetFilesByID([]int64{id})
+	if err != nil {
+		return BookFile{}, err
+	}
+	if len(files) == 0 {
+		return BookFile{}, ErrFileNotFound
+	}
+	return files[0], nil
+}
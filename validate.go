@@ -0,0 +1,82 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidationProblem is one reason a book was rejected by a strict import.
+type ValidationProblem struct {
+	// Field is what's wrong with the book: title, authors, size, or hash.
+	Field   string
+	Message string
+}
+
+func (p ValidationProblem) String() string {
+	return p.Field + ": " + p.Message
+}
+
+// ValidationError is returned when a book imported with ImportOptions.Strict is rejected, and lists everything wrong with it.
+type ValidationError struct {
+	Filename string
+	Problems []ValidationProblem
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		problems[i] = p.String()
+	}
+	return fmt.Sprintf("invalid book %s: %s", e.Filename, strings.Join(problems, "; "))
+}
+
+// validateImport checks a book being imported in strict mode, once its metadata is final.
+// The book must have a title and at least one author, and its file must not be empty.
+// If the file was given a hash, it's hashed again, and must match, so a stale or wrong hash doesn't put the file in the library under the wrong name.
+// If checkFile is false, only the metadata is checked.
+func validateImport(book Book, checkFile bool) error {
+	var problems []ValidationProblem
+	if strings.TrimSpace(book.Title) == "" {
+		problems = append(problems, ValidationProblem{"title", "missing"})
+	}
+	hasAuthor := false
+	for _, a := range book.Authors {
+		if strings.TrimSpace(a) != "" {
+			hasAuthor = true
+			break
+		}
+	}
+	if !hasAuthor {
+		problems = append(problems, ValidationProblem{"authors", "missing"})
+	}
+	bf := book.Files[0]
+	if checkFile {
+		fi, err := os.Stat(bf.OriginalFilename)
+		if err != nil {
+			return errors.Wrap(err, "get file info")
+		}
+		if fi.Size() == 0 {
+			problems = append(problems, ValidationProblem{"size", "file is empty"})
+		}
+		if bf.Hash != "" {
+			hash, err := hashFile(bf.OriginalFilename)
+			if err != nil {
+				return errors.Wrap(err, "calculate hash")
+			}
+			if hash != bf.Hash {
+				problems = append(problems, ValidationProblem{"hash", fmt.Sprintf("given hash %s, but the file's is %s", bf.Hash, hash)})
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Filename: bf.OriginalFilename, Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContainedWork is a work contained in a file, such as a story in an anthology or an essay in a collection.
+// Contained works are searchable, so searching for a story finds the books which contain it.
+type ContainedWork struct {
+	ID    int64  `json:"id,omitempty"`
+	Title string `json:"title"`
+	// Authors are the authors of the work, which may differ from the authors of the book.
+	Authors []string `json:"authors"`
+}
+
+// sqlIndexedWorks returns an SQL expression for the text indexed in the works column of books_fts, for the book with the given ID.
+func sqlIndexedWorks(bookID string) string {
+	return `(select coalesce(group_concat(w.title || ' ' || w.authors, ' '), '') from contained_works w join files f on w.file_id = f.id where f.book_id = ` + bookID + `)`
+}
+
+// SetContainedWorks replaces the works contained in a file with works, in order.
+func (lib *Library) SetContainedWorks(fileID int64, works []ContainedWork) error {
+	tx, err := lib.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	var bookID int64
+	err = tx.QueryRow("select book_id from files where id=?", fileID).Scan(&bookID)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return errors.Errorf("file %d not found", fileID)
+	} else if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "get book ID")
+	}
+	if _, err := tx.Exec("delete from contained_works where file_id=?", fileID); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "delete contained works")
+	}
+	if err := insertContainedWorks(tx, fileID, works); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := indexBook(tx, bookID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	lib.invalidateBooks(bookID)
+	if err != nil {
+		return errors.Wrap(err, "commit")
+	}
+	return nil
+}
+
+// ParseContainedWork parses a contained work written as "Author & Author - Title", the way books are named.
+// If there's no " - ", the whole string is the title.
+func ParseContainedWork(s string) ContainedWork {
+	i := strings.Index(s, " - ")
+	if i < 0 {
+		return ContainedWork{Title: collapseSpace(s)}
+	}
+	return ContainedWork{Title: collapseSpace(s[i+3:]), Authors: SplitAuthorName(s[:i])}
+}
+
+func insertContainedWorks(tx *sql.Tx, fileID int64, works []ContainedWork) error {
+	for i, w := range works {
+		title := collapseSpace(w.Title)
+		if title == "" {
+			return errors.New("contained work has no title")
+		}
+		_, err := tx.Exec("insert into contained_works (file_id, position, title, authors) values(?, ?, ?, ?)", fileID, i, title, strings.Join(uniqueAuthors(w.Authors), " & "))
+		if err != nil {
+			return errors.Wrap(err, "insert contained work")
+		}
+	}
+	return nil
+}
+
+// getWorksByFileIds gets the works contained in each file ID, in order.
+func getWorksByFileIds(tx *sql.Tx, ids []int64) (map[int64][]ContainedWork, error) {
+	m := make(map[int64][]ContainedWork)
+	if len(ids) == 0 {
+		return m, nil
+	}
+	rows, err := tx.Query("select id, file_id, title, authors from contained_works where file_id in (" + joinInt64s(ids, ",") + ") order by file_id, position")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fileID int64
+		var w ContainedWork
+		var authors string
+		if err := rows.Scan(&w.ID, &fileID, &w.Title, &authors); err != nil {
+			return nil, err
+		}
+		if authors != "" {
+			w.Authors = strings.Split(authors, " & ")
+		}
+		m[fileID] = append(m[fileID], w)
+	}
+	return m, rows.Err()
+}
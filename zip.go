@@ -0,0 +1,97 @@
+// Copyright © 2018 Tyler Spivey <tspivey@pcdesk.net> and Niko Carpenter <nikoacarpenter@gmail.com>
+//
+// This source code is governed by the MIT license, which can be found in the LICENSE file.
+
+package books
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ZipFiles writes a zip archive of files to w, as it reads them, without making the archive on disk.
+// Each file is named with its current filename, which is made from the output template,
+// and files with the same name are numbered, such as "Title (2).epub".
+// Files are checked before anything is written, so a file which is missing or on an unavailable books root
+// returns an error rather than a partial archive. The files are in the order of fileIDs.
+// Entries are dated by the files' modification times in the library, in UTC, so the same files give the same archive, byte for byte.
+func (lib *Library) ZipFiles(fileIDs []int64, w io.Writer) error {
+	files, err := lib.GetFilesByID(fileIDs)
+	if err != nil {
+		return errors.Wrap(err, "get files")
+	}
+	byID := make(map[int64]BookFile, len(files))
+	for _, f := range files {
+		byID[f.ID] = f
+	}
+	type entry struct {
+		file BookFile
+		fn   string
+		name string
+	}
+	var entries []entry
+	names := make(map[string]bool)
+	for _, id := range fileIDs {
+		f, ok := byID[id]
+		if !ok {
+			return errors.Wrapf(ErrFileNotFound, "file %d", id)
+		}
+		fn, release, err := lib.CachedFilePath(f)
+		if err != nil {
+			return errors.Wrapf(err, "file %d", id)
+		}
+		defer release()
+		if _, err := os.Stat(fn); err != nil {
+			return errors.Wrapf(err, "file %d", id)
+		}
+		name := uniqueZipName(f.CurrentFilename, names)
+		names[strings.ToLower(name)] = true
+		entries = append(entries, entry{f, fn, name})
+	}
+
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		if err := writeZipEntry(zw, e.fn, e.name, e.file); err != nil {
+			return errors.Wrapf(err, "add file %d to zip", e.file.ID)
+		}
+	}
+	return errors.Wrap(zw.Close(), "finish zip")
+}
+
+// writeZipEntry adds the file at fn to zw as name.
+func writeZipEntry(zw *zip.Writer, fn, name string, file BookFile) error {
+	fp, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	hdr.Modified = file.FileMtime.UTC()
+	hdr.SetMode(0644)
+	dst, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, throttle(fp))
+	return err
+}
+
+// uniqueZipName returns fn as a name in a zip archive, numbered if it's already in names, which are lowercase.
+func uniqueZipName(fn string, names map[string]bool) string {
+	name := strings.TrimLeft(path.Clean("/"+strings.Replace(fn, `\`, "/", -1)), "/")
+	if name == "" {
+		name = "book"
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; names[strings.ToLower(name)]; i++ {
+		name = base + " (" + strconv.Itoa(i) + ")" + ext
+	}
+	return name
+}